@@ -1,33 +1,168 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	monitoringapi "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/admin"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/handler"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/router"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/envelope"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/monitoring"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/scaling"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/schedule"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/seeder"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/shard"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/slo"
 	"github.com/jessevdk/go-flags"
 )
 
 type options struct {
 	Port        int    `short:"p" long:"port" description:"Port for API to listen on" required:"true"`
 	DatabaseUrl string `short:"d" long:"database-url" description:"Database URL in which to store metadata" required:"true"`
+
+	SchemaPolicy repo.SchemaPolicy `long:"schema-policy" description:"Whether to migrate a database of an earlier schema version on startup or refuse to start, databases of later versions are always refused" choice:"migrate" choice:"refuse" default:"migrate"`
+
+	StorageClasses map[string]string `long:"storage-class" description:"Storage class rolled up and priced as STANDARD, NEARLINE, COLDLINE or ARCHIVE, given as CLASS:TIER such as HOT:STANDARD, can be repeated"`
+	PrefixTTLs     map[string]string `long:"prefix-ttl" description:"Time objects under a prefix of every bucket stay indexed after their creation, given as PREFIX:TTL such as tmp/:168h, removed by the expire job even if their delete notification is lost, can be repeated"`
+
+	CompressionThreshold int `long:"compression-threshold" description:"Minimum response size in bytes before compressing" default:"1024"`
+	CompressionLevel     int `long:"compression-level" description:"gzip/deflate compression level from 1 (fastest) to 9 (smallest), -1 for default" default:"-1"`
+	ZstdLevel            int `long:"zstd-level" description:"zstd compression level of responses and snapshots from 1 (fastest) to 22 (smallest), 0 to only compress responses with gzip/deflate" default:"3"`
+
+	MaxResponseSize          int64            `long:"max-response-size" description:"Size in bytes of the JSON documents answering list, search and query requests beyond which their rows are streamed one per line as NDJSON instead, 0 to disable" default:"16777216"`
+	MaxEndpointResponseSizes map[string]int64 `long:"max-endpoint-response-size" description:"Overrides --max-response-size for an endpoint, given as ENDPOINT:BYTES such as query:1048576, can be repeated"`
+
+	OperationTimeout time.Duration `long:"operation-timeout" description:"Maximum duration of a single database operation, 0 to disable" default:"30s"`
+	CursorTimeout    time.Duration `long:"cursor-timeout" description:"Time the snapshot read by the pages of a paginated listing stays open after each page, holding a database connection and WAL checkpoints back, 0 to page listings on the current contents" default:"10s"`
+	MissingPathTTL   time.Duration `long:"missing-path-ttl" description:"Time paths found missing are answered as empty without querying the database, writes of other processes such as the seeder showing up after it, 0 to disable" default:"10s"`
+	ShutdownTimeout  time.Duration `long:"shutdown-timeout" description:"Time to let in-flight requests finish on shutdown before cancelling them" default:"10s"`
+
+	SlowRequestThreshold time.Duration `long:"slow-request-threshold" description:"Latency above which requests also log their SQL statements and query plans, 0 to disable" default:"1s"`
+	DebugQueries         bool          `long:"debug-queries" description:"Attach the SQL statements, bind parameters and query plans of requests sent with X-Debug-Queries: true to X-Query-Trace response headers, exposing them to any client"`
+
+	AdminPort       int    `long:"admin-port" description:"Port to serve pprof, expvar metrics, goroutine dumps, index advice, bucket registration, the audit log of its mutations and zstd compressed database snapshots on, 0 to disable"`
+	SnapshotKMSKey  string `long:"snapshot-kms-key" description:"Cloud KMS key, as projects/P/locations/L/keyRings/R/cryptoKeys/K, wrapping the data keys snapshots served at /admin/snapshot or written by the snapshot job are encrypted with, unencrypted if empty"`
+	LockProfileRate int    `long:"lock-profile-rate" description:"Sample one in this many contended locks for /debug/locks, 0 to disable"`
+
+	IndexAdvisorMinHits int  `long:"index-advisor-min-hits" description:"Statements an index would support before it is recommended at /debug/indexes, 0 to disable the advisor" default:"100"`
+	AutoCreateIndexes   bool `long:"auto-create-indexes" description:"Create recommended indexes, locking the database while they are built"`
+
+	Backfill          bool   `long:"backfill" description:"Seed buckets registered at /admin/buckets with backfill set, requires access to their objects"`
+	LocateBuckets     bool   `long:"locate-buckets" description:"Look the location of buckets registered at /admin/buckets up in GCS, which costs are priced at, requires access to their metadata, implied by --backfill and --notification-topic"`
+	NotificationTopic string `long:"notification-topic" description:"Pub/Sub topic of the subscriber, as projects/PROJECT/topics/TOPIC, the buckets registered at /admin/buckets with notify set notifying it of their events, and their notifications to it being deleted on deregistration"`
+	GCSFallback       bool   `long:"gcs-fallback" description:"Look objects missing from the index up in GCS and index them, so objects not indexed yet are found, requires access to their objects"`
+	LazyIndexing      bool   `long:"lazy-indexing" description:"List the subtree of a directory from GCS on its first query at /explore, /summary or /search in every registered bucket not seeded yet, so sparsely queried buckets are served without backfilling them, requires access to their objects"`
+	BackfillWorkers   int    `long:"backfill-workers" description:"Number of top level prefixes of a backfilled bucket listed concurrently" default:"1"`
+
+	ConsumerHeader     string        `long:"consumer-header" description:"Header identifying API consumers, set by an authenticating proxy such as Identity-Aware Proxy, to account usage per consumer at /admin/usage and identify operators in the audit log at /admin/audit, empty to disable usage accounting" default:"X-Goog-Authenticated-User-Email"`
+	UsageFlushInterval time.Duration `long:"usage-flush-interval" description:"Time between writes of consumer usage to the database, unless the usage job is scheduled with --schedule" default:"60s"`
+
+	SLOs        map[string]string `long:"slo" description:"Latency objective of an endpoint, given as ENDPOINT:LATENCY:TARGET such as explore:500ms:99.9 for 99.9% of requests answered within 500ms without a server error, reported with burn rates at /admin/slo and in the slo expvar, can be repeated"`
+	SLOWebhook  string            `long:"slo-webhook" description:"URL JSON alerts are posted to when an endpoint starts or stops burning its error budget too fast"`
+	SLOInterval time.Duration     `long:"slo-interval" description:"Time between evaluations of SLO burn rate alerts" default:"1m"`
+
+	ScalingInterval      time.Duration `long:"scaling-interval" description:"Time between samples of the scaling signal served at /scaling on the admin port and exported with --monitoring-project, 0 to disable" default:"10s"`
+	ScalingPending       int           `long:"scaling-pending" description:"Pending database writes the scaling signal reads 1 at, 0 to ignore them" default:"50"`
+	ScalingOldestPending time.Duration `long:"scaling-oldest-pending" description:"Wait of the oldest pending database write the scaling signal reads 1 at, 0 to ignore it" default:"5s"`
+	ScalingUtilization   float64       `long:"scaling-utilization" description:"Fraction of time database writes are in progress the scaling signal reads 1 at, 0 to ignore it" default:"0.8"`
+
+	DegradeSignal    float64       `long:"degrade-signal" description:"Scaling signal at or above which the server degrades, shedding the requests of --degrade-endpoint and serving rollups from cache, advertised in the X-Degraded header, 0 to never degrade on load, requires --scaling-interval"`
+	DegradeOnPage    bool          `long:"degrade-on-page" description:"Degrade while an endpoint burns its error budget fast enough to page, requires --slo"`
+	DegradeEndpoints []string      `long:"degrade-endpoint" description:"Expensive endpoint shed while degraded, can be repeated" default:"search" default:"query" default:"compare" default:"diff" default:"reports" default:"recommendations" default:"simulate"`
+	DegradeCacheTTL  time.Duration `long:"degrade-cache-ttl" description:"Time rollups are served from cache while degraded" default:"60s"`
+
+	MonitoringProject  string        `long:"monitoring-project" description:"Project to export bucket and top level prefix size and count to as Cloud Monitoring custom metrics"`
+	MonitoringInterval time.Duration `long:"monitoring-interval" description:"Time between Cloud Monitoring metric exports, unless the export job is scheduled with --schedule" default:"60s"`
+
+	ShardLeases        string        `long:"shard-leases" description:"GCS location (bucket/prefix) of the leases subscribers of a sharded fleet claim buckets with, the owner of every bucket being listed at /shards on the admin port for clients to route queries to"`
+	ShardBuckets       []string      `long:"shard-bucket" description:"Bucket claimed by the subscribers of the fleet, can be repeated"`
+	ShardLeaseDuration time.Duration `long:"shard-lease-duration" description:"Duration of the bucket leases of the fleet" default:"30s"`
+
+	Schedules           map[string]string `long:"schedule" description:"Schedule of a background job, given as JOB:CRON such as vacuum:0 3 * * 0 for Sundays at 3:00 UTC, or JOB:@every DURATION, jobs being export, usage, vacuum, snapshot and expire, listed with their next run and last outcome at /admin/jobs, can be repeated"`
+	ScheduleJitter      time.Duration     `long:"schedule-jitter" description:"Maximum random delay of every scheduled run, so replicas sharing a schedule don't run their jobs at once"`
+	SnapshotDestination string            `long:"snapshot-destination" description:"GCS location (bucket/prefix) the snapshot job writes snapshots to, to be verified with verify-backup"`
 }
 
+// jobs are the background jobs which can be scheduled with --schedule
+var jobs = map[string]bool{"export": true, "usage": true, "vacuum": true, "snapshot": true, "expire": true}
+
+// expireInterval is the default time between runs of the expire job
+const expireInterval = time.Hour
+
+// freshnessCacheTTL is how long the last write time reported in freshness headers is cached
+const freshnessCacheTTL = 5 * time.Second
+
 const maxDbConnections = 5
 
+// maxWriteBatch is the maximum number of backfill writes committed per transaction
+const maxWriteBatch = 100
+
 func main() {
 	var opts options
 	if _, err := flags.Parse(&opts); err != nil {
 		os.Exit(1)
 	}
 
+	if err := repo.RegisterStorageClasses(opts.StorageClasses); err != nil {
+		log.Fatalf("Error registering storage classes: %v\n", err)
+	}
+
+	if opts.CompressionLevel < gzip.HuffmanOnly || opts.CompressionLevel > gzip.BestCompression {
+		log.Fatalln("gzip/deflate compression level must be between -2 and 9")
+	}
+	if opts.ZstdLevel < 0 || opts.ZstdLevel > 22 {
+		log.Fatalln("zstd level must be between 0 and 22")
+	}
+
+	if opts.DegradeSignal > 0 && opts.ScalingInterval <= 0 {
+		log.Fatalln("Degrading on load requires the scaling signal, please set --scaling-interval")
+	}
+	if opts.DegradeOnPage && len(opts.SLOs) == 0 {
+		log.Fatalln("Degrading on pages requires latency objectives, please set --slo")
+	}
+
+	for job := range opts.Schedules {
+		if !jobs[job] {
+			log.Fatalf("Unknown job %q of --schedule\n", job)
+		}
+	}
+	if (len(opts.Schedules["snapshot"]) > 0) != (len(opts.SnapshotDestination) > 0) {
+		log.Fatalln("The snapshot job requires both --schedule snapshot:CRON and --snapshot-destination")
+	}
+
+	prefixTTLs, err := repo.ParsePrefixTTLs(opts.PrefixTTLs)
+	if err != nil {
+		log.Fatalf("Invalid --prefix-ttl: %v\n", err)
+	}
+	if len(opts.Schedules["expire"]) > 0 && len(prefixTTLs) == 0 {
+		log.Fatalln("The expire job requires --prefix-ttl")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Connect database
-	ctx := context.Background()
 	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+	db.SetOperationTimeout(opts.OperationTimeout)
+	db.SetCursorTimeout(opts.CursorTimeout)
+	db.SetMissingPathTTL(opts.MissingPathTTL)
 
 	if err := db.Connect(ctx); err != nil {
 		log.Fatalf("Error connecting to database: %v\n", err)
@@ -38,15 +173,322 @@ func main() {
 		log.Fatalf("Database has not been initialized: %v\n", err)
 	}
 
-	// Start server
-	router := router.New(db)
+	if err := db.CheckSchema(ctx, opts.SchemaPolicy); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	// Advise indexes from the statements requests execute
+	var advisor *repo.IndexAdvisor
+	if opts.IndexAdvisorMinHits > 0 {
+		advisor = repo.NewIndexAdvisor(db, opts.IndexAdvisorMinHits, opts.AutoCreateIndexes)
+		go advisor.Run(ctx)
+	}
+
+	// Run background jobs on their schedules, by default at the intervals of their flags
+	scheduler := schedule.New(opts.ScheduleJitter)
+	scheduleJob := func(name string, defaultSpec string, job schedule.Job) {
+		spec := opts.Schedules[name]
+		if len(spec) == 0 {
+			spec = defaultSpec
+		}
+		if len(spec) == 0 {
+			return
+		}
+		if err := scheduler.Add(name, spec, job); err != nil {
+			log.Fatalf("Error scheduling job: %v\n", err)
+		}
+	}
+	scheduleJob("vacuum", "", db.Vacuum)
+
+	// Expire objects outliving the TTL of their prefix
+	if len(prefixTTLs) > 0 {
+		scheduleJob("expire", "@every "+expireInterval.String(), repo.NewExpirer(db, prefixTTLs).Expire)
+	}
+
+	var client *storage.Client
+	locateBuckets := opts.LocateBuckets || opts.Backfill || len(opts.NotificationTopic) > 0
+	if locateBuckets || opts.GCSFallback || opts.LazyIndexing || len(opts.SnapshotDestination) > 0 || len(opts.ShardLeases) > 0 {
+		var err error
+		client, err = storage.NewClient(ctx)
+		if err != nil {
+			log.Fatalf("Error creating storage client: %v\n", err)
+		}
+		defer client.Close()
+	}
+
+	// Seed registered buckets in the background, writes being serialized with the API's own
+	var backfiller admin.Backfiller
+	if opts.Backfill {
+		writeQueue := repo.NewWriteQueue(db, maxWriteBatch)
+		db.SetWriteQueue(writeQueue)
+		go writeQueue.Run(ctx)
+
+		seedBackfiller := seeder.NewBackfiller(ctx, client, db)
+		seedBackfiller.SetParallelism(opts.BackfillWorkers, 0)
+		backfiller = seedBackfiller
+	}
+
+	// Look buckets registered at runtime up in GCS and provision their notifications
+	var provisioner admin.Provisioner
+	if locateBuckets {
+		seedProvisioner, err := seeder.NewProvisioner(client, opts.NotificationTopic)
+		if err != nil {
+			log.Fatalf("Invalid --notification-topic: %v\n", err)
+		}
+		provisioner = seedProvisioner
+	}
+
+	// Look objects of registered buckets up in GCS when missing from the index
+	var fetcher handler.ObjectFetcher
+	if opts.GCSFallback {
+		fetcher = seeder.NewFetcher(client, db)
+	}
+
+	// Index the subtrees of registered buckets on their first query
+	var lazyIndexer *seeder.LazyIndexer
+	if opts.LazyIndexing {
+		lazyIndexer = seeder.NewLazyIndexer(ctx, client, db)
+	}
+
+	// Track latency objectives, alerting when endpoints burn their error budget too fast
+	var sloTracker *slo.Tracker
+	if len(opts.SLOs) > 0 {
+		objectives := make([]model.SLO, 0, len(opts.SLOs))
+		for endpoint, value := range opts.SLOs {
+			objective, err := slo.Parse(endpoint, value)
+			if err != nil {
+				log.Fatalf("Invalid --slo of %s: %v\n", endpoint, err)
+			}
+			objectives = append(objectives, objective)
+		}
+		sloTracker = slo.NewTracker(objectives)
+
+		var notify func(context.Context, model.SLOAlert) error
+		if len(opts.SLOWebhook) > 0 {
+			notify = slo.Webhook(http.DefaultClient, opts.SLOWebhook)
+		}
+		go sloTracker.Run(ctx, opts.SLOInterval, notify)
+	}
+
+	// Sample the write load into a signal for external autoscalers
+	var scalingSignal *scaling.Signal
+	if opts.ScalingInterval > 0 {
+		scalingSignal = scaling.NewSignal(db.WriteLoad, scaling.Targets{
+			Pending:       opts.ScalingPending,
+			OldestPending: opts.ScalingOldestPending,
+			Utilization:   opts.ScalingUtilization,
+		})
+		go scalingSignal.Run(ctx, opts.ScalingInterval)
+	}
+
+	// Account requests per consumer
+	var usageMeter *repo.UsageMeter
+	if len(opts.ConsumerHeader) > 0 {
+		usageMeter = repo.NewUsageMeter(db)
+		scheduleJob("usage", "@every "+opts.UsageFlushInterval.String(), usageMeter.Flush)
+	}
+
+	// Encrypt snapshots with data keys wrapped by Cloud KMS
+	var snapshotWrapper envelope.KeyWrapper
+	if len(opts.SnapshotKMSKey) > 0 {
+		kmsWrapper, err := envelope.NewKMSWrapper(ctx, opts.SnapshotKMSKey)
+		if err != nil {
+			log.Fatalf("Error creating KMS client: %v\n", err)
+		}
+		snapshotWrapper = kmsWrapper
+	}
+
+	// Write snapshots to GCS for verify-backup to check
+	if len(opts.SnapshotDestination) > 0 {
+		bucket, prefix, _ := strings.Cut(opts.SnapshotDestination, "/")
+		if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+			prefix = prefix + "/"
+		}
+		snapshotBucket := client.Bucket(bucket)
+		scheduleJob("snapshot", "", func(ctx context.Context) error {
+			return writeSnapshot(ctx, db, snapshotBucket, prefix, max(opts.ZstdLevel, 1), snapshotWrapper)
+		})
+	}
+
+	// Serve debug endpoints
+	if opts.AdminPort > 0 {
+		admin.EnableLockProfiling(opts.LockProfileRate)
+
+		adminHandler := admin.NewHandler()
+		if advisor != nil {
+			adminHandler.HandleFunc("GET /debug/indexes", admin.HandleIndexes(advisor))
+		}
+
+		bucketRepo := repo.NewBucketRepository(db)
+		auditRepo := repo.NewAuditRepository(db)
+		adminHandler.HandleFunc("GET /admin/buckets", admin.HandleListBuckets(bucketRepo))
+		adminHandler.HandleFunc("POST /admin/buckets", admin.HandleRegisterBucket(bucketRepo, backfiller, provisioner))
+		adminHandler.HandleFunc("DELETE /admin/buckets/{name}", admin.HandleDeregisterBucket(bucketRepo, backfiller, provisioner))
+		adminHandler.HandleFunc("GET /admin/buckets/{name}/config", admin.HandleGetBucketConfig(bucketRepo))
+		adminHandler.HandleFunc("PUT /admin/buckets/{name}/config", admin.HandleSetBucketConfig(bucketRepo))
+		adminHandler.HandleFunc("GET /admin/audit", admin.HandleAuditLog(auditRepo))
+		adminHandler.HandleFunc("GET /admin/snapshot", admin.HandleSnapshot(db, max(opts.ZstdLevel, 1), snapshotWrapper))
+		if scalingSignal != nil {
+			adminHandler.HandleFunc("GET /scaling", admin.HandleScaling(scalingSignal.Current))
+		}
+		if sloTracker != nil {
+			adminHandler.HandleFunc("GET /admin/slo", admin.HandleSLO(sloTracker.Status))
+		}
+		if usageMeter != nil {
+			adminHandler.HandleFunc("GET /admin/usage", admin.HandleUsage(repo.NewUsageRepository(db)))
+		}
+		adminHandler.HandleFunc("GET /admin/jobs", admin.HandleJobs(scheduler.Status))
+		if len(opts.ShardLeases) > 0 {
+			bucket, prefix, _ := strings.Cut(opts.ShardLeases, "/")
+			claims := shard.NewGCSClaims(client.Bucket(bucket), prefix, "", opts.ShardBuckets, 0, opts.ShardLeaseDuration)
+			adminHandler.HandleFunc("GET /shards", admin.HandleShards(claims.Owners))
+		}
+
+		go func() {
+			if err := admin.ListenAndServe(ctx, fmt.Sprintf(":%d", opts.AdminPort), admin.Audit(adminHandler, auditRepo, middleware.HeaderIdentity(opts.ConsumerHeader), "/admin/snapshot")); err != nil {
+				log.Printf("Error serving admin endpoints: %v\n", err)
+			}
+		}()
+	}
+
+	// Export custom metrics
+	if len(opts.MonitoringProject) > 0 {
+		if opts.MonitoringInterval < monitoring.MinInterval {
+			log.Fatalf("Monitoring interval must be at least %v\n", monitoring.MinInterval)
+		}
+
+		client, err := monitoringapi.NewMetricClient(ctx)
+		if err != nil {
+			log.Fatalf("Error creating monitoring client: %v\n", err)
+		}
+		defer client.Close()
+
+		exporter := monitoring.NewExporter(client, opts.MonitoringProject, repo.NewExploreRepository(db))
+		exportLag := monitoring.NotificationLagJob(client, opts.MonitoringProject, repo.NewStatsRepository(db))
+		scheduleJob("export", "@every "+opts.MonitoringInterval.String(), func(ctx context.Context) error {
+			errs := []error{exporter.Export(ctx, time.Now()), exportLag(ctx)}
+			if scalingSignal != nil {
+				errs = append(errs, monitoring.ExportScalingSignal(ctx, client, opts.MonitoringProject, scalingSignal.Current()))
+			}
+			return errors.Join(errs...)
+		})
+	}
+
+	go scheduler.Run(ctx)
+
+	// Start server, request contexts are cancelled if they outlive the shutdown timeout
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	router := router.New(db, fetcher)
+	statsRepo := repo.NewStatsRepository(db)
+
+	var handler http.Handler = router
+	if lazyIndexer != nil {
+		handler = middleware.LazyIndex(handler, lazyIndexer.Index)
+	}
+	if advisor != nil {
+		handler = middleware.ObserveQueries(handler, advisor.Observe)
+	}
+	handler = middleware.Freshness(handler, statsRepo.GetLastWrite, freshnessCacheTTL)
+	handler = middleware.LimitResponses(handler, opts.MaxResponseSize, opts.MaxEndpointResponseSizes)
+	if opts.DegradeSignal > 0 || opts.DegradeOnPage {
+		handler = middleware.Degrade(handler, func() string {
+			if opts.DegradeSignal > 0 && scalingSignal != nil && scalingSignal.Current().Value >= opts.DegradeSignal {
+				return "write-load"
+			}
+			if opts.DegradeOnPage && sloTracker != nil && sloTracker.Paging() {
+				return "error-budget"
+			}
+			return ""
+		}, opts.DegradeEndpoints, opts.DegradeCacheTTL)
+	}
+	if opts.DebugQueries {
+		handler = middleware.DebugQueries(handler, db.ExplainQueryPlan)
+	}
+	handler, err = middleware.Compress(handler, opts.CompressionThreshold, opts.CompressionLevel, opts.ZstdLevel)
+	if err != nil {
+		log.Fatalf("Error compressing responses: %v\n", err)
+	}
+	if usageMeter != nil {
+		handler = middleware.Usage(handler, middleware.HeaderIdentity(opts.ConsumerHeader), usageMeter.Record)
+	}
+	if sloTracker != nil {
+		handler = middleware.SLO(handler, sloTracker.Record)
+	}
+	handler = middleware.Logging(handler, opts.SlowRequestThreshold, db.ExplainQueryPlan)
+
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", opts.Port),
-		Handler: router,
+		Addr:        fmt.Sprintf(":%d", opts.Port),
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 
-	log.Println("Starting server on port", server.Addr)
-	if err := server.ListenAndServe(); err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting server on port", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Error in server: %v\n", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		cancelRequests()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Error shutting down server: %v\n", err)
+		}
+		server.Close()
+	}
+
+	if usageMeter != nil {
+		if err := usageMeter.Flush(context.Background()); err != nil {
+			log.Printf("Error flushing consumer usage: %v\n", err)
+		}
+	}
+}
+
+// writeSnapshot writes a snapshot of the database under prefix of bucket, named as those served at /admin/snapshot
+// and encrypted with a data key wrapped by wrapper unless nil
+// Failed snapshots are never finalized, leaving no truncated object behind
+func writeSnapshot(ctx context.Context, db *repo.Database, bucket *storage.BucketHandle, prefix string, level int, wrapper envelope.KeyWrapper) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	name := fmt.Sprintf("%smetadata-%s.db%s", prefix, time.Now().UTC().Format("20060102T150405Z"), repo.SnapshotExtension)
+	if wrapper != nil {
+		name += envelope.Extension
+	}
+	ow := bucket.Object(name).NewWriter(ctx)
+
+	var out io.Writer = ow
+	var ew io.WriteCloser
+	if wrapper != nil {
+		var err error
+		if ew, err = envelope.NewWriter(ctx, ow, wrapper); err != nil {
+			return fmt.Errorf("error encrypting snapshot: %w", err)
+		}
+		out = ew
+	}
+
+	if err := db.WriteSnapshot(ctx, out, level); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return fmt.Errorf("error encrypting snapshot: %w", err)
+		}
+	}
+	if err := ow.Close(); err != nil {
+		return fmt.Errorf("error uploading snapshot: %w", err)
 	}
+	log.Printf("Snapshot %s written\n", name)
+	return nil
 }
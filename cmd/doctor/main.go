@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/doctor"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/jessevdk/go-flags"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+type options struct {
+	DatabaseUrl  string   `short:"d" long:"database-url" description:"Database to check the schema version and writability of, as given to the servers"`
+	Buckets      []string `long:"bucket" description:"Bucket to check the reachability and notifications of, can be repeated"`
+	Subscription string   `long:"subscription" description:"Pub/Sub subscription delivering the notifications of the buckets, as projects/PROJECT/subscriptions/SUBSCRIPTION, to check the existence and permissions of"`
+
+	ClockURL     string        `long:"clock-url" description:"URL whose Date header the local clock is compared with" default:"https://storage.googleapis.com/"`
+	MaxClockSkew time.Duration `long:"max-clock-skew" description:"Largest difference between the local clock and the one of --clock-url before it fails, 0 to skip the check" default:"2s"`
+
+	Timeout time.Duration `long:"timeout" description:"Maximum duration of all checks" default:"1m"`
+}
+
+const maxDbConnections = 1
+
+// credentialsFix is how to fix failing to create API clients
+const credentialsFix = "Provide Application Default Credentials, running as the service account of the servers or with gcloud auth application-default login"
+
+// failed is a check failing with err, for checks which could not be set up
+func failed(name string, err error, fix string) doctor.Check {
+	return doctor.Check{Name: name, Run: func(context.Context) (string, error) {
+		return "", &doctor.Problem{Err: err, Fix: fix}
+	}}
+}
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var checks []doctor.Check
+
+	// Connecting creates missing database files, which would hide a wrong --database-url
+	if len(opts.DatabaseUrl) > 0 {
+		if _, err := os.Stat(opts.DatabaseUrl); errors.Is(err, fs.ErrNotExist) && !strings.HasPrefix(opts.DatabaseUrl, "file:") {
+			checks = append(checks, failed("database", fmt.Errorf("database file %s does not exist", opts.DatabaseUrl), "Check --database-url against the path mounted in the servers"))
+		} else {
+			db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+			if err := db.Connect(ctx); err != nil {
+				checks = append(checks, failed("database", fmt.Errorf("error connecting to database: %w", err), "Check that --database-url names the database file, readable by the servers"))
+			} else {
+				defer db.Close()
+				checks = append(checks, doctor.Database(db))
+			}
+		}
+	}
+
+	// The subscription runs first, so notifications are expected on its topic
+	var topic string
+	if len(opts.Subscription) > 0 {
+		svc, err := pubsub.NewService(ctx)
+		if err != nil {
+			checks = append(checks, failed("subscription "+opts.Subscription, fmt.Errorf("error creating Pub/Sub client: %w", err), credentialsFix))
+		} else {
+			checks = append(checks, doctor.Subscription(svc, opts.Subscription, &topic))
+		}
+	}
+
+	if len(opts.Buckets) > 0 {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			checks = append(checks, failed("gcs", fmt.Errorf("error creating storage client: %w", err), credentialsFix))
+		} else {
+			defer client.Close()
+			for _, bucket := range opts.Buckets {
+				checks = append(checks, doctor.Bucket(client, bucket), doctor.Notifications(client, bucket, &topic))
+			}
+		}
+	}
+
+	if opts.MaxClockSkew > 0 {
+		checks = append(checks, doctor.Clock(http.DefaultClient, opts.ClockURL, opts.MaxClockSkew))
+	}
+
+	failures, err := doctor.Write(os.Stdout, doctor.Run(ctx, checks))
+	if err != nil {
+		log.Fatalf("Error writing results: %v\n", err)
+	}
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
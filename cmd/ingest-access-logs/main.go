@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/accesslog"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/jessevdk/go-flags"
+	"google.golang.org/api/iterator"
+)
+
+type options struct {
+	DatabaseUrl string `short:"d" long:"database-url" description:"Database URL in which metadata is stored" required:"true"`
+
+	SchemaPolicy repo.SchemaPolicy `long:"schema-policy" description:"Whether to migrate a database of an earlier schema version on startup or refuse to start, databases of later versions are always refused" choice:"migrate" choice:"refuse" default:"migrate"`
+
+	Logs []string `long:"logs" description:"Usage logs or audit logs exported by a log sink, every object under a GCS location (gs://bucket/prefix) or a file path, can be repeated" required:"true"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Connect database
+	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+
+	if err := db.Connect(ctx); err != nil {
+		log.Fatalf("Error connecting to database: %v\n", err)
+	}
+	defer db.Close()
+
+	if exists, err := db.PingTable(); !exists || err != nil {
+		log.Fatalf("Database has not been initialized: %v\n", err)
+	}
+
+	if err := db.CheckSchema(ctx, opts.SchemaPolicy); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	popularityRepo := repo.NewPopularityRepository(db)
+
+	var client *storage.Client
+	var ingested, skipped int
+	for _, location := range opts.Logs {
+		ingest := func(name string, r io.Reader) error {
+			reads, err := accesslog.Parse(r)
+			if err != nil {
+				return fmt.Errorf("error parsing %s: %w", name, err)
+			}
+
+			// Logs are recorded by name so they are counted once across runs
+			err = popularityRepo.IngestLog(ctx, name, reads)
+			if errors.Is(err, repo.ErrConflict) {
+				skipped++
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error ingesting %s: %w", name, err)
+			}
+			ingested++
+			return nil
+		}
+
+		if !strings.HasPrefix(location, "gs://") {
+			if err := ingestFile(location, ingest); err != nil {
+				log.Fatalln(err)
+			}
+			continue
+		}
+
+		if client == nil {
+			var err error
+			if client, err = storage.NewClient(ctx); err != nil {
+				log.Fatalf("Error creating storage client: %v\n", err)
+			}
+			defer client.Close()
+		}
+		if err := ingestObjects(ctx, client, strings.TrimPrefix(location, "gs://"), ingest); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	log.Printf("Ingested %d access logs, skipped %d already ingested\n", ingested, skipped)
+}
+
+// ingestFile ingests the log stored in a local file
+func ingestFile(name string, ingest func(string, io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ingest(name, f)
+}
+
+// ingestObjects ingests every log object under location, given as bucket/prefix, skipping storage logs
+func ingestObjects(ctx context.Context, client *storage.Client, location string, ingest func(string, io.Reader) error) error {
+	bucketName, prefix, _ := strings.Cut(location, "/")
+	bucket := client.Bucket(bucketName)
+
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error listing logs: %w", err)
+		}
+
+		if strings.HasSuffix(attrs.Name, "/") || strings.Contains(attrs.Name, accesslog.StorageLogMarker) {
+			continue
+		}
+
+		r, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", attrs.Name, err)
+		}
+		err = ingest("gs://"+bucketName+"/"+attrs.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
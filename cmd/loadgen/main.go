@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/loadgen"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	DatabaseUrl string `short:"d" long:"database-url" description:"Database URL in which to write synthesized metadata" required:"true"`
+	BucketId    string `short:"b" long:"bucket-id" description:"Bucket ID of synthesized objects" default:"loadgen"`
+
+	Objects int     `short:"n" long:"objects" description:"Number of objects to create" default:"100000"`
+	Depth   int     `long:"depth" description:"Maximum directory depth of object names" default:"5"`
+	Fanout  int     `long:"fanout" description:"Number of directories at each level" default:"10"`
+	Churn   float64 `long:"churn" description:"Probability of an event updating or deleting an existing object, in [0, 1)" default:"0.1"`
+	MaxSize int64   `long:"max-size" description:"Maximum object size in bytes" default:"1048576"`
+	Seed    uint64  `long:"seed" description:"Random seed, runs with the same seed synthesize the same events" default:"1"`
+
+	OperationTimeout time.Duration `long:"operation-timeout" description:"Maximum duration of a single database operation, 0 to disable" default:"30s"`
+
+	WriteFailureRate float64       `long:"write-failure-rate" description:"Rate of writes failing as if the database was locked, in [0, 1]"`
+	DuplicateRate    float64       `long:"duplicate-rate" description:"Rate of writes applied twice as redelivered events would be, in [0, 1]"`
+	AckDelayRate     float64       `long:"ack-delay-rate" description:"Rate of writes acknowledged after --ack-delay, in [0, 1]"`
+	AckDelay         time.Duration `long:"ack-delay" description:"Delay of delayed write acknowledgements" default:"100ms"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	cfg := loadgen.Config{
+		Bucket:  opts.BucketId,
+		Objects: opts.Objects,
+		Depth:   opts.Depth,
+		Fanout:  opts.Fanout,
+		Churn:   opts.Churn,
+		MaxSize: opts.MaxSize,
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid options: %v\n", err)
+	}
+
+	faults := repo.FaultConfig{
+		WriteFailureRate: opts.WriteFailureRate,
+		DuplicateRate:    opts.DuplicateRate,
+		AckDelayRate:     opts.AckDelayRate,
+		AckDelay:         opts.AckDelay,
+		Seed:             opts.Seed,
+	}
+	if err := faults.Validate(); err != nil {
+		log.Fatalf("Invalid options: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Connect database
+	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+	db.SetOperationTimeout(opts.OperationTimeout)
+	if faults.Enabled() {
+		log.Printf("Injecting faults: %+v\n", faults)
+		db.SetFaults(faults)
+	}
+
+	if err := db.Connect(ctx); err != nil {
+		log.Fatalf("Error connecting to database: %v\n", err)
+	}
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		log.Fatalf("Error configuring database: %v\n", err)
+	}
+
+	if exists, err := db.PingTable(); err != nil {
+		log.Fatalf("Error checking tables: %v\n", err)
+	} else if !exists {
+		if err := db.CreateTables(); err != nil {
+			log.Fatalf("Error creating tables: %v\n", err)
+		}
+	} else if err := db.CheckSchema(ctx, repo.SchemaMigrate); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	log.Printf("Generating load: %+v\n", cfg)
+
+	res, err := loadgen.Run(ctx, loadgen.NewGenerator(cfg, opts.Seed), repo.NewMetadataRepository(db), repo.NewDirectoryRepository(db))
+	if err != nil {
+		log.Fatalf("Error generating load: %v\n", err)
+	}
+
+	log.Printf("Events: create=%d update=%d delete=%d errors=%d\n",
+		res.Events[loadgen.EventCreate], res.Events[loadgen.EventUpdate], res.Events[loadgen.EventDelete], res.Errors)
+	log.Printf("Duration: %v (%.0f events/s), latency p50=%v p99=%v\n", res.Duration, res.EventsPerSecond(), res.P50, res.P99)
+}
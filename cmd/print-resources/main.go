@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/resources"
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	Project          string `long:"project" description:"Project owning the service account the servers run as" required:"true"`
+	ServiceAccountID string `long:"service-account-id" description:"Account ID of the service account" default:"gcs-metadata-server"`
+
+	Buckets        []string `long:"bucket" description:"Bucket indexed by the seeder, or by the API with --backfill, --gcs-fallback or --lazy-indexing, can be repeated"`
+	BillingProject string   `long:"billing-project" description:"Project billed for requests to requester pays buckets, as set on the seeder"`
+	LeaseObject    string   `long:"lease-object" description:"GCS object (bucket/object) used as writer lease by the seeder"`
+
+	ReportDestination   string `long:"report-destination" description:"GCS location (bucket/prefix) the reporter writes reports to"`
+	SnapshotDestination string `long:"snapshot-destination" description:"GCS location (bucket/prefix) the snapshot job of the API writes snapshots to"`
+	SnapshotLocation    string `long:"snapshot-location" description:"GCS location (bucket/prefix) verify-backup reads snapshots from"`
+	AccessLogLocation   string `long:"access-log-location" description:"GCS location (bucket/prefix) ingest-access-logs reads access logs from"`
+	KMSKey              string `long:"kms-key" description:"Cloud KMS key wrapping the data keys of snapshots"`
+	MonitoringProject   string `long:"monitoring-project" description:"Project the API and verify-backup export custom metrics to"`
+
+	Format string `long:"format" description:"Output format" choice:"json" choice:"terraform" default:"terraform"`
+}
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	r, err := resources.Build(resources.Config{
+		Project:             opts.Project,
+		ServiceAccountID:    opts.ServiceAccountID,
+		Buckets:             opts.Buckets,
+		BillingProject:      opts.BillingProject,
+		LeaseObject:         opts.LeaseObject,
+		ReportDestination:   opts.ReportDestination,
+		SnapshotDestination: opts.SnapshotDestination,
+		SnapshotLocation:    opts.SnapshotLocation,
+		AccessLogLocation:   opts.AccessLogLocation,
+		KMSKey:              opts.KMSKey,
+		MonitoringProject:   opts.MonitoringProject,
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+
+	if opts.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = r.WriteTerraform(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Error writing resources: %v\n", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/report"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/schedule"
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	DatabaseUrl string `short:"d" long:"database-url" description:"Database URL in which metadata is stored" required:"true"`
+
+	SchemaPolicy repo.SchemaPolicy `long:"schema-policy" description:"Whether to migrate a database of an earlier schema version on startup or refuse to start, databases of later versions are always refused" choice:"migrate" choice:"refuse" default:"migrate"`
+
+	Prefix     string        `long:"prefix" description:"Directory to report on, the whole bucket if empty"`
+	Period     time.Duration `long:"period" description:"Time span growth is measured over" default:"168h"`
+	Top        int           `long:"top" description:"Maximum number of growing and stale directories listed" default:"10"`
+	StaleAfter time.Duration `long:"stale-after" description:"Time a directory must go unchanged to be reported as stale" default:"2160h"`
+	Interval   time.Duration `long:"interval" description:"Time between reports, unless scheduled with --schedule" default:"168h"`
+	Schedule   string        `long:"schedule" description:"Cron expression reports are generated on, such as 0 8 * * 1 for Mondays at 8:00 UTC"`
+	Once       bool          `long:"once" description:"Generate a single report and exit"`
+
+	Destination string `long:"destination" description:"GCS location (bucket/prefix) reports are written to"`
+
+	SMTPAddr     string   `long:"smtp-addr" description:"SMTP server (host:port) reports are mailed through, e.g. smtp.sendgrid.net:587"`
+	SMTPUsername string   `long:"smtp-username" description:"SMTP username, apikey for SendGrid"`
+	SMTPPassword string   `long:"smtp-password" description:"SMTP password or SendGrid API key" env:"SMTP_PASSWORD"`
+	SMTPFrom     string   `long:"smtp-from" description:"Sender address of report mails"`
+	SMTPTo       []string `long:"smtp-to" description:"Recipient address of report mails, may be repeated"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	cfg := report.Config{
+		Prefix:     opts.Prefix,
+		Period:     opts.Period,
+		Top:        opts.Top,
+		StaleAfter: opts.StaleAfter,
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid report configuration: %v\n", err)
+	}
+	spec := opts.Schedule
+	if len(spec) == 0 {
+		spec = "@every " + opts.Interval.String()
+	}
+	if _, err := schedule.Parse(spec); !opts.Once && err != nil {
+		log.Fatalf("Invalid report schedule: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Connect database
+	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+
+	if err := db.Connect(ctx); err != nil {
+		log.Fatalf("Error connecting to database: %v\n", err)
+	}
+	defer db.Close()
+
+	if exists, err := db.PingTable(); !exists || err != nil {
+		log.Fatalf("Database has not been initialized: %v\n", err)
+	}
+
+	if err := db.CheckSchema(ctx, opts.SchemaPolicy); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	// Configure destinations
+	var sinks []report.Sink
+
+	if len(opts.Destination) > 0 {
+		bucket, prefix, _ := strings.Cut(opts.Destination, "/")
+		if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+			prefix = prefix + "/"
+		}
+
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			log.Fatalf("Error creating storage client: %v\n", err)
+		}
+		defer client.Close()
+
+		sinks = append(sinks, report.NewGCSSink(client.Bucket(bucket), prefix))
+	}
+
+	if len(opts.SMTPAddr) > 0 {
+		if len(opts.SMTPFrom) == 0 || len(opts.SMTPTo) == 0 {
+			log.Fatalln("Mailing reports requires --smtp-from and --smtp-to")
+		}
+
+		sinks = append(sinks, report.NewSMTPSink(report.SMTPConfig{
+			Addr:     opts.SMTPAddr,
+			Username: opts.SMTPUsername,
+			Password: opts.SMTPPassword,
+			From:     opts.SMTPFrom,
+			To:       opts.SMTPTo,
+		}))
+	}
+
+	if len(sinks) == 0 {
+		log.Fatalln("No report destination, please set --destination or --smtp-addr")
+	}
+
+	builder := report.NewBuilder(cfg, repo.NewExploreRepository(db), repo.NewHistoryRepository(db))
+
+	if opts.Once {
+		if err := report.Generate(ctx, builder, sinks, time.Now()); err != nil {
+			log.Fatalf("Error generating report: %v\n", err)
+		}
+		log.Println("Report generated")
+		return
+	}
+
+	scheduler := schedule.New(0)
+	if err := scheduler.Add("report", spec, func(ctx context.Context) error {
+		if err := report.Generate(ctx, builder, sinks, time.Now()); err != nil {
+			return err
+		}
+		log.Println("Report generated")
+		return nil
+	}); err != nil {
+		log.Fatalf("Error scheduling reports: %v\n", err)
+	}
+
+	log.Println("Generating reports on schedule", spec)
+	scheduler.Run(ctx)
+}
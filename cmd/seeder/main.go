@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/admin"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/lease"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/seeder"
 	"github.com/jessevdk/go-flags"
@@ -15,6 +22,32 @@ import (
 type options struct {
 	BucketId    string `short:"b" long:"bucket-id" description:"Bucket ID to fetch metadata from" required:"true"`
 	DatabaseUrl string `short:"d" long:"database-url" description:"Database URL in which to store metadata" required:"true"`
+	UserProject string `long:"billing-project" description:"Project billed for requests to the bucket, required for requester pays buckets"`
+	ReportACLs  bool   `long:"report-acls" description:"Record objects granting access through object ACLs, for uniform bucket-level access migrations"`
+
+	StorageClasses map[string]string `long:"storage-class" description:"Storage class rolled up and priced as STANDARD, NEARLINE, COLDLINE or ARCHIVE, given as CLASS:TIER such as HOT:STANDARD, can be repeated"`
+
+	SniffContentTypes float64 `long:"sniff-content-types" description:"Fraction of new objects, from 0 to 1, whose first bytes are read to detect their real content type, 0 to disable"`
+
+	EstimatedObjects int64 `long:"estimated-objects" description:"Expected object count of the bucket, to report an ETA at /debug/seed"`
+
+	CatchUp             bool    `long:"catch-up" description:"Compare an indexed bucket with its listing and apply only the objects created, updated or deleted since, instead of seeding it again"`
+	Workers             int     `long:"workers" description:"Number of top level prefixes listed concurrently" default:"1"`
+	MaxObjectsPerSecond float64 `long:"max-objects-per-second" description:"Maximum number of objects indexed per second across workers, 0 for no limit"`
+
+	LeaseObject   string        `long:"lease-object" description:"GCS object (bucket/object) used as writer lease, so a single seeder writes the database at a time"`
+	LeaseDuration time.Duration `long:"lease-duration" description:"Duration of the writer lease, renewed every third of it" default:"30s"`
+
+	InMemory        bool          `long:"in-memory" description:"Seed into an in-memory database persisted to the database URL every persist interval, losing at most one interval of writes on crash"`
+	PersistInterval time.Duration `long:"persist-interval" description:"Time between persisting the in-memory database" default:"1m"`
+
+	SchemaPolicy repo.SchemaPolicy `long:"schema-policy" description:"Whether to migrate a database of an earlier schema version on startup or refuse to start, databases of later versions are always refused" choice:"migrate" choice:"refuse" default:"migrate"`
+
+	OperationTimeout time.Duration `long:"operation-timeout" description:"Maximum duration of a single database operation, 0 to disable" default:"30s"`
+	WriteBatchSize   int           `long:"write-batch-size" description:"Maximum number of writes committed per transaction" default:"100"`
+	AdminPort        int           `long:"admin-port" description:"Port to serve pprof, expvar metrics such as circuit breaker state, goroutine dumps and seeding progress on, 0 to disable"`
+	MetricsPort      int           `long:"metrics-port" description:"Deprecated alias of --admin-port" hidden:"true"`
+	LockProfileRate  int           `long:"lock-profile-rate" description:"Sample one in this many contended locks for /debug/locks, 0 to disable"`
 }
 
 const maxDbConnections = 1
@@ -25,13 +58,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := repo.RegisterStorageClasses(opts.StorageClasses); err != nil {
+		log.Fatalf("Error registering storage classes: %v\n", err)
+	}
+
 	log.Println("Starting seeding service")
 	log.Println("Bucket ID:", opts.BucketId)
 	log.Println("Database URL:", opts.DatabaseUrl)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Connect database
-	ctx := context.Background()
-	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+	dbUrl := opts.DatabaseUrl
+	if opts.InMemory {
+		dbUrl = repo.InMemoryURL("seeder")
+	}
+
+	db := repo.NewDatabase(dbUrl, maxDbConnections)
+	db.SetOperationTimeout(opts.OperationTimeout)
 
 	if err := db.Connect(ctx); err != nil {
 		log.Fatalf("Error connecting to database: %v\n", err)
@@ -42,8 +87,42 @@ func main() {
 		log.Fatalf("Error configuring database: %v\n", err)
 	}
 
-	if err := db.CreateTables(); err != nil {
-		log.Fatalf("Error creating tables: %v\n", err)
+	// Resume from the persisted database of an interrupted in-memory seeding
+	if opts.InMemory {
+		if _, err := os.Stat(opts.DatabaseUrl); err == nil {
+			if err := db.Restore(ctx, opts.DatabaseUrl); err != nil {
+				log.Fatalf("Error restoring database: %v\n", err)
+			}
+		}
+	}
+
+	if exists, err := db.PingTable(); err != nil {
+		log.Fatalf("Error checking tables: %v\n", err)
+	} else if !exists {
+		if err := db.CreateTables(); err != nil {
+			log.Fatalf("Error creating tables: %v\n", err)
+		}
+	} else if err := db.CheckSchema(ctx, opts.SchemaPolicy); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	// Serialize writes through a single writer
+	writeQueue := repo.NewWriteQueue(db, opts.WriteBatchSize)
+	db.SetWriteQueue(writeQueue)
+	go writeQueue.Run(ctx)
+
+	// Persist the in-memory database until seeding ends
+	persistDone := make(chan struct{})
+	if opts.InMemory {
+		persistCtx, stopPersisting := context.WithCancel(ctx)
+		go func() {
+			defer close(persistDone)
+			db.PersistEvery(persistCtx, opts.DatabaseUrl, opts.PersistInterval)
+		}()
+		defer func() {
+			stopPersisting()
+			<-persistDone
+		}()
 	}
 
 	// Connect to storage client
@@ -53,17 +132,112 @@ func main() {
 	}
 
 	// Instantiate repositories
+	bucketRepo := repo.NewBucketRepository(db)
 	directoryRepo := repo.NewDirectoryRepository(db)
 	metadataRepo := repo.NewMetadataRepository(db)
 
-	seedService := seeder.NewSeedService(client, opts.BucketId, directoryRepo, metadataRepo)
+	seedService := seeder.NewSeedService(client, opts.BucketId, bucketRepo, directoryRepo, metadataRepo)
+	seedService.SetUserProject(opts.UserProject)
+	seedService.SetCheckpointRepository(repo.NewCheckpointRepository(db))
+	seedService.SetNoncurrentRepository(repo.NewNoncurrentRepository(db))
+	seedService.SetEstimatedObjects(opts.EstimatedObjects)
+	seedService.SetParallelism(opts.Workers, opts.MaxObjectsPerSecond)
+	if opts.ReportACLs {
+		seedService.SetACLRepository(repo.NewACLRepository(db))
+	}
+
+	// Detect the content types of a sample of new objects until seeding ends
+	if opts.SniffContentTypes < 0 || opts.SniffContentTypes > 1 {
+		log.Fatalf("Content type sniffing fraction must be between 0 and 1\n")
+	}
+	if opts.SniffContentTypes > 0 {
+		bucket := client.Bucket(opts.BucketId)
+		if len(opts.UserProject) > 0 {
+			bucket = bucket.UserProject(opts.UserProject)
+		}
+
+		enricher := seeder.NewEnricher(bucket, metadataRepo, opts.SniffContentTypes)
+		seedService.SetEnricher(enricher)
+
+		enrichDone := make(chan struct{})
+		go func() {
+			defer close(enrichDone)
+			enricher.Run(ctx)
+		}()
+		defer func() {
+			enricher.Close()
+			<-enrichDone
+		}()
+	}
+
+	// Apply the overrides of buckets registered with a config
+	cfg, err := bucketRepo.GetConfig(ctx, opts.BucketId)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		log.Fatalf("Error reading bucket config: %v\n", err)
+	}
+	if cfg != nil {
+		seedService.SetConfig(cfg)
+	}
+
+	// Serve debug endpoints
+	if opts.AdminPort == 0 {
+		opts.AdminPort = opts.MetricsPort
+	}
+	if opts.AdminPort > 0 {
+		admin.EnableLockProfiling(opts.LockProfileRate)
+
+		adminHandler := admin.NewHandler()
+		adminHandler.HandleFunc("GET /debug/seed", admin.HandleSeedProgress(seedService.Progress))
+
+		go func() {
+			if err := admin.ListenAndServe(ctx, fmt.Sprintf(":%d", opts.AdminPort), adminHandler); err != nil {
+				log.Printf("Error serving admin endpoints: %v\n", err)
+			}
+		}()
+	}
+
+	// Acquire writer lease
+	if len(opts.LeaseObject) > 0 {
+		bucket, object, ok := strings.Cut(opts.LeaseObject, "/")
+		if !ok || len(bucket) == 0 || len(object) == 0 {
+			log.Fatalf("Invalid lease object %q, expected bucket/object\n", opts.LeaseObject)
+		}
+
+		writerLease := lease.NewGCSLease(client.Bucket(bucket).Object(object), leaseHolder(), opts.LeaseDuration)
+
+		ctx, err = writerLease.Acquire(ctx)
+		if err != nil {
+			log.Fatalf("Error acquiring writer lease: %v\n", err)
+		}
+		defer writerLease.Release(context.Background())
+
+		log.Println("Acquired writer lease:", opts.LeaseObject)
+	}
 
 	// Begin seeding
 	start := time.Now()
 
+	if opts.CatchUp {
+		if err := seedService.CatchUp(ctx); err != nil {
+			log.Fatalf("Error while catching up: %v\n", err)
+		}
+
+		log.Printf("Catch-up completed. Duration: %v\n", time.Since(start))
+		return
+	}
+
 	if err := seedService.Start(ctx); err != nil {
 		log.Fatalf("Error while seeding: %v\n", err)
 	}
 
 	log.Printf("Seeding completed. Duration: %v\n", time.Since(start))
 }
+
+// leaseHolder identifies this process among seeder instances
+func leaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/admin"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/ingest"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/shard"
+	"github.com/jessevdk/go-flags"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+type options struct {
+	DatabaseUrl  string             `short:"d" long:"database-url" description:"Database URL in which to index notifications" required:"true"`
+	Subscription string             `long:"subscription" description:"Pub/Sub subscription delivering the notifications of the buckets, as projects/PROJECT/subscriptions/SUBSCRIPTION" required:"true"`
+	PayloadMode  ingest.PayloadMode `long:"payload-mode" description:"Whether notifications of unknown payload formats are refused or indexed from their attributes" choice:"strict" choice:"lenient" default:"strict"`
+
+	ConflictPolicy ingest.ConflictPolicy `long:"conflict-policy" description:"Which of two versions of an object is indexed: the one updated last, the higher generation then metageneration, or the one notified last, the last two falling back to update times" choice:"updated" choice:"generation" choice:"event-time" default:"updated"`
+
+	Workers      int           `long:"workers" description:"Number of notifications applied concurrently" default:"4"`
+	AckDeadline  time.Duration `long:"ack-deadline" description:"Duration notifications are leased for at a time, extended until they are applied, between 10s and 10m" default:"1m"`
+	MaxExtension time.Duration `long:"max-extension" description:"Maximum duration a notification is kept leased, after which it is redelivered" default:"1h"`
+
+	MaxOutstandingMessages int           `long:"max-outstanding-messages" description:"Maximum number of notifications pulled but not applied yet" default:"1000"`
+	MaxOutstandingBytes    int64         `long:"max-outstanding-bytes" description:"Maximum size in bytes of the notifications pulled but not applied yet" default:"1000000000"`
+	WorkerQueueSize        int           `long:"worker-queue-size" description:"Number of notifications queued per worker, every notification of an object being applied by the same worker" default:"10"`
+	TargetLatency          time.Duration `long:"target-latency" description:"Latency of applying notifications above which fewer are pulled, such as when bursts of deletions contend for the database, 0 to disable" default:"1s"`
+	SlowApplyThreshold     time.Duration `long:"slow-apply-threshold" description:"Latency of applying a notification above which it is logged with its SQL statements, 0 to disable" default:"1s"`
+
+	Shadow bool `long:"shadow" description:"Evaluate notifications without writing to the database, logging and counting in the subscriber_shadow expvar the writes applying them would make, to validate a deployment against production traffic on a subscription of its own"`
+
+	Journal       bool          `long:"journal" description:"Journal the notifications applied with their writes, acknowledging redeliveries without applying them and seeking the subscription back to the oldest notification not applied on restart, replaying acknowledged ones if the subscription retains them"`
+	JournalMargin time.Duration `long:"journal-margin" description:"Duration before the oldest notification not applied the subscription is seeked back to on restart, covering notifications published out of order" default:"10m"`
+
+	ShardLeases        string        `long:"shard-leases" description:"GCS location (bucket/prefix) of the leases instances sharing the subscription claim buckets with, each instance applying the notifications of the buckets it claimed and nacking the others, disabled if empty"`
+	ShardBuckets       []string      `long:"shard-bucket" description:"Bucket claimed by one of the instances, can be repeated"`
+	ShardMaxBuckets    int           `long:"shard-max-buckets" description:"Maximum number of buckets this instance claims"`
+	ShardAddress       string        `long:"shard-address" description:"Address of the API serving the database of this instance, unique in the fleet, advertised at /shards on the admin port to route queries of its buckets to"`
+	ShardLeaseDuration time.Duration `long:"shard-lease-duration" description:"Duration of the bucket leases, renewed every third of it, buckets of stopped instances being claimed by others once expired" default:"30s"`
+
+	StorageClasses map[string]string `long:"storage-class" description:"Storage class rolled up and priced as STANDARD, NEARLINE, COLDLINE or ARCHIVE, given as CLASS:TIER such as HOT:STANDARD, can be repeated"`
+
+	SchemaPolicy repo.SchemaPolicy `long:"schema-policy" description:"Whether to migrate a database of an earlier schema version on startup or refuse to start, databases of later versions are always refused" choice:"migrate" choice:"refuse" default:"migrate"`
+
+	ConsumerHeader string `long:"consumer-header" description:"Header identifying operators in the audit log of the admin endpoints pausing buckets and replaying notifications, set by an authenticating proxy such as Identity-Aware Proxy" default:"X-Goog-Authenticated-User-Email"`
+
+	OperationTimeout time.Duration `long:"operation-timeout" description:"Maximum duration of a single database operation, 0 to disable" default:"30s"`
+	WriteBatchSize   int           `long:"write-batch-size" description:"Maximum number of writes committed per transaction" default:"100"`
+	AdminPort        int           `long:"admin-port" description:"Port to serve pprof, expvar metrics such as subscriber message counts, goroutine dumps, the bucket owners, bucket pauses and replays on, 0 to disable"`
+	LockProfileRate  int           `long:"lock-profile-rate" description:"Sample one in this many contended locks for /debug/locks, 0 to disable"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	if opts.AckDeadline < ingest.MinAckDeadline || opts.AckDeadline > ingest.MaxAckDeadline {
+		log.Fatalf("Ack deadline must be between %v and %v\n", ingest.MinAckDeadline, ingest.MaxAckDeadline)
+	}
+	if opts.MaxExtension < opts.AckDeadline {
+		log.Fatalf("Maximum extension must be at least the ack deadline\n")
+	}
+	if opts.Workers <= 0 || opts.WorkerQueueSize <= 0 || opts.MaxOutstandingMessages <= 0 || opts.MaxOutstandingBytes <= 0 {
+		log.Fatalf("Workers, worker queue size and outstanding limits must be positive\n")
+	}
+	sharded := len(opts.ShardLeases) > 0
+	if sharded && (len(opts.ShardBuckets) == 0 || opts.ShardMaxBuckets <= 0 || len(opts.ShardAddress) == 0) {
+		log.Fatalf("Sharding requires --shard-bucket, a positive --shard-max-buckets and --shard-address\n")
+	}
+	if opts.Shadow && opts.Journal {
+		log.Fatalf("Shadow subscribers don't write the journal, please drop --journal\n")
+	}
+	if err := repo.RegisterStorageClasses(opts.StorageClasses); err != nil {
+		log.Fatalf("Error registering storage classes: %v\n", err)
+	}
+
+	log.Println("Starting subscriber")
+	log.Println("Subscription:", opts.Subscription)
+	log.Println("Database URL:", opts.DatabaseUrl)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Connect database
+	db := repo.NewDatabase(opts.DatabaseUrl, maxDbConnections)
+	db.SetOperationTimeout(opts.OperationTimeout)
+
+	if err := db.Connect(ctx); err != nil {
+		log.Fatalf("Error connecting to database: %v\n", err)
+	}
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		log.Fatalf("Error configuring database: %v\n", err)
+	}
+	if exists, err := db.PingTable(); err != nil {
+		log.Fatalf("Error checking tables: %v\n", err)
+	} else if !exists {
+		if err := db.CreateTables(); err != nil {
+			log.Fatalf("Error creating tables: %v\n", err)
+		}
+	} else if err := db.CheckSchema(ctx, opts.SchemaPolicy); err != nil {
+		log.Fatalf("Incompatible database schema: %v\n", err)
+	}
+
+	// Serialize writes through a single writer, which outlives the subscriber so messages in flight are applied
+	writeCtx, stopWriting := context.WithCancel(context.Background())
+	writeQueue := repo.NewWriteQueue(db, opts.WriteBatchSize)
+	db.SetWriteQueue(writeQueue)
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		writeQueue.Run(writeCtx)
+	}()
+	defer func() {
+		stopWriting()
+		<-writeDone
+	}()
+
+	svc, err := pubsub.NewService(ctx)
+	if err != nil {
+		log.Fatalf("Error creating Pub/Sub client: %v\n", err)
+	}
+
+	// Claim buckets among the instances of the fleet
+	var claims *shard.Claims
+	if sharded {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			log.Fatalf("Error creating GCS client: %v\n", err)
+		}
+		defer client.Close()
+
+		bucket, prefix, _ := strings.Cut(opts.ShardLeases, "/")
+		claims = shard.NewGCSClaims(client.Bucket(bucket), prefix, opts.ShardAddress, opts.ShardBuckets, opts.ShardMaxBuckets, opts.ShardLeaseDuration)
+		claimsDone := make(chan struct{})
+		go func() {
+			defer close(claimsDone)
+			claims.Run(ctx)
+		}()
+		defer func() { <-claimsDone }()
+	}
+
+	applier := ingest.NewApplier(db)
+	applier.SetConflictPolicy(opts.ConflictPolicy)
+
+	subscriber := ingest.NewSubscriber(ingest.NewPubSubSubscription(svc, opts.Subscription), applier, ingest.SubscriberConfig{
+		Mode:         opts.PayloadMode,
+		Workers:      opts.Workers,
+		AckDeadline:  opts.AckDeadline,
+		MaxExtension: opts.MaxExtension,
+
+		MaxOutstandingMessages: opts.MaxOutstandingMessages,
+		MaxOutstandingBytes:    opts.MaxOutstandingBytes,
+		WorkerQueueSize:        opts.WorkerQueueSize,
+		TargetLatency:          opts.TargetLatency,
+
+		Shadow:    opts.Shadow,
+		SlowApply: opts.SlowApplyThreshold,
+	})
+	if claims != nil {
+		subscriber.SetOwnership(claims.Owns)
+	}
+	if opts.Journal {
+		subscriber.SetJournal(repo.NewJournalRepository(db), opts.Subscription, opts.JournalMargin)
+	}
+
+	// Serve debug endpoints, bucket pauses and replays, whose requests are audited
+	if opts.AdminPort > 0 {
+		admin.EnableLockProfiling(opts.LockProfileRate)
+
+		adminHandler := admin.NewHandler()
+		if claims != nil {
+			adminHandler.HandleFunc("GET /shards", admin.HandleShards(claims.Owners))
+		}
+		adminHandler.HandleFunc("GET /admin/paused", admin.HandleListPaused(subscriber))
+		adminHandler.HandleFunc("POST /admin/buckets/{name}/pause", admin.HandlePauseBucket(subscriber))
+		adminHandler.HandleFunc("POST /admin/buckets/{name}/resume", admin.HandleResumeBucket(subscriber))
+		adminHandler.HandleFunc("POST /admin/replay", admin.HandleReplay(subscriber))
+
+		auditRepo := repo.NewAuditRepository(db)
+		go func() {
+			if err := admin.ListenAndServe(ctx, fmt.Sprintf(":%d", opts.AdminPort), admin.Audit(adminHandler, auditRepo, middleware.HeaderIdentity(opts.ConsumerHeader))); err != nil {
+				log.Printf("Error serving admin endpoints: %v\n", err)
+			}
+		}()
+	}
+
+	subscriber.Run(ctx)
+
+	log.Println("Subscriber stopped")
+}
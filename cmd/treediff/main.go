@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/envelope"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	From   string `long:"from" description:"Database URL of the earlier snapshot, or path of a zstd compressed .zst or encrypted .zst.enc snapshot" required:"true"`
+	To     string `long:"to" description:"Database URL of the later snapshot, or path of a zstd compressed .zst or encrypted .zst.enc snapshot, the earlier snapshot if empty"`
+	KMSKey string `long:"kms-key" description:"Cloud KMS key, as projects/P/locations/L/keyRings/R/cryptoKeys/K, unwrapping the data keys of encrypted snapshots"`
+
+	FromTime string `long:"from-time" description:"Point in the history of the earlier snapshot to compare, as RFC 3339, its current tree if empty"`
+	ToTime   string `long:"to-time" description:"Point in the history of the later snapshot to compare, as RFC 3339, its current tree if empty"`
+
+	Prefix string `long:"prefix" description:"Directory whose subtree is compared, the whole bucket by default" default:"/"`
+	Depth  int    `long:"depth" description:"Maximum depth below the prefix of changed directories listed, 0 for no limit" default:"2"`
+	Output string `short:"o" long:"output" description:"File the JSON diff is written to, - for stdout" default:"-"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	if len(opts.To) == 0 {
+		opts.To = opts.From
+	}
+	if opts.Depth < 0 {
+		log.Fatalln("Depth must not be negative")
+	}
+	if opts.Prefix != "/" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+
+	fromTime, err := parseTime(opts.FromTime)
+	if err != nil {
+		log.Fatalf("Invalid --from-time: %v\n", err)
+	}
+	toTime, err := parseTime(opts.ToTime)
+	if err != nil {
+		log.Fatalf("Invalid --to-time: %v\n", err)
+	}
+	if opts.From == opts.To && fromTime.Equal(toTime) {
+		log.Fatalln("Nothing to compare, please set --to, --from-time or --to-time")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wrapper envelope.KeyWrapper
+	if len(opts.KMSKey) > 0 {
+		kmsWrapper, err := envelope.NewKMSWrapper(ctx, opts.KMSKey)
+		if err != nil {
+			log.Fatalf("Error creating KMS client: %v\n", err)
+		}
+		wrapper = kmsWrapper
+	}
+
+	from, err := loadTree(ctx, opts.From, opts.Prefix, fromTime, wrapper)
+	if err != nil {
+		log.Fatalf("Error loading %s: %v\n", opts.From, err)
+	}
+	to, err := loadTree(ctx, opts.To, opts.Prefix, toTime, wrapper)
+	if err != nil {
+		log.Fatalf("Error loading %s: %v\n", opts.To, err)
+	}
+
+	diff := repo.DiffTrees(opts.Prefix, from, to, opts.Depth)
+	diff.From = snapshotName(opts.From, fromTime)
+	diff.To = snapshotName(opts.To, toTime)
+
+	if err := writeDiff(opts.Output, diff); err != nil {
+		log.Fatalf("Error writing diff: %v\n", err)
+	}
+	log.Printf("Diffed %s: %d added, %d removed and %d changed directories, %+d bytes\n",
+		opts.Prefix, len(diff.Added), len(diff.Removed), len(diff.Changed), diff.SizeDelta)
+}
+
+// parseTime parses an RFC 3339 time, the zero time if s is empty
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// snapshotName names a snapshot in the diff, with the point of its history compared if any
+func snapshotName(url string, asOf time.Time) string {
+	if asOf.IsZero() {
+		return url
+	}
+	return url + "@" + asOf.UTC().Format(time.RFC3339)
+}
+
+// loadTree reads the directory tree under prefix of the database at url as of a point in time
+// Snapshots are read as they are, without migrating them, compressed ones are decrypted by wrapper if needed
+// and decompressed to a temporary file first
+func loadTree(ctx context.Context, url string, prefix string, asOf time.Time, wrapper envelope.KeyWrapper) (map[string]model.DirectoryTotals, error) {
+	if strings.HasSuffix(url, repo.SnapshotExtension) || strings.HasSuffix(url, repo.SnapshotExtension+envelope.Extension) {
+		path, err := decompressSnapshot(ctx, url, wrapper)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing snapshot: %w", err)
+		}
+		defer os.RemoveAll(filepath.Dir(path))
+		url = path
+	}
+
+	db := repo.NewDatabase(url, maxDbConnections)
+	if err := db.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if exists, err := db.PingTable(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("database has not been initialized")
+	}
+
+	return repo.NewTreeRepository(db).GetTree(ctx, prefix, asOf)
+}
+
+// decompressSnapshot decompresses the snapshot at path into a temporary directory, returning the database path
+// Encrypted snapshots are decrypted with a data key unwrapped by wrapper
+func decompressSnapshot(ctx context.Context, path string, wrapper envelope.KeyWrapper) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, envelope.Extension) {
+		if wrapper == nil {
+			return "", errors.New("snapshot is encrypted, please set --kms-key")
+		}
+		if r, err = envelope.NewReader(ctx, f, wrapper); err != nil {
+			return "", err
+		}
+		name = strings.TrimSuffix(name, envelope.Extension)
+	}
+
+	dir, err := os.MkdirTemp("", "treediff")
+	if err != nil {
+		return "", err
+	}
+
+	dbPath := filepath.Join(dir, strings.TrimSuffix(name, repo.SnapshotExtension))
+	if err := repo.ReadSnapshot(r, dbPath); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dbPath, nil
+}
+
+// writeDiff writes diff as indented JSON to path, or to stdout if path is -
+func writeDiff(path string, diff *model.TreeDiff) error {
+	if path == "-" {
+		return encodeDiff(os.Stdout, diff)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeDiff(f, diff); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeDiff(w io.Writer, diff *model.TreeDiff) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diff)
+}
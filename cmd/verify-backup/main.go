@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	monitoringapi "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/envelope"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/monitoring"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/jessevdk/go-flags"
+	"google.golang.org/api/iterator"
+)
+
+type options struct {
+	Snapshot string `long:"snapshot" description:"Snapshot to verify, the latest .zst or .zst.enc object under a GCS location (gs://bucket/prefix), the /admin/snapshot URL of a server, or a file path" required:"true"`
+	KMSKey   string `long:"kms-key" description:"Cloud KMS key, as projects/P/locations/L/keyRings/R/cryptoKeys/K, unwrapping the data keys of encrypted snapshots"`
+
+	ExpectRows map[string]string `long:"expect-rows" description:"Range of the row count of a table, as TABLE:MIN-MAX such as metadata:1000000-2000000, MAX may be omitted, can be repeated"`
+	Timeout    time.Duration     `long:"timeout" description:"Maximum duration of the whole verification" default:"1h"`
+
+	MonitoringProject string `long:"monitoring-project" description:"Project to report the outcome to as Cloud Monitoring custom metrics"`
+}
+
+const maxDbConnections = 1
+
+func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		os.Exit(1)
+	}
+
+	expected := make(map[string]model.RowRange, len(opts.ExpectRows))
+	for table, value := range opts.ExpectRows {
+		r, err := parseRowRange(value)
+		if err != nil {
+			log.Fatalf("Invalid --expect-rows of %s: %v\n", table, err)
+		}
+		expected[table] = r
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wrapper envelope.KeyWrapper
+	if len(opts.KMSKey) > 0 {
+		kmsWrapper, err := envelope.NewKMSWrapper(ctx, opts.KMSKey)
+		if err != nil {
+			log.Fatalf("Error creating KMS client: %v\n", err)
+		}
+		wrapper = kmsWrapper
+	}
+
+	// Failing to fetch or restore the snapshot is a problem of the backup like any found in it
+	start := time.Now()
+	check := &model.BackupCheck{Snapshot: opts.Snapshot, Rows: map[string]int64{}}
+	verifyCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	if err := verify(verifyCtx, check, expected, wrapper); err != nil {
+		check.Problems = append(check.Problems, err.Error())
+	}
+	cancel()
+	check.Checked = time.Now()
+	check.Duration = check.Checked.Sub(start)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(check); err != nil {
+		log.Fatalf("Error writing result: %v\n", err)
+	}
+
+	if len(opts.MonitoringProject) > 0 {
+		client, err := monitoringapi.NewMetricClient(ctx)
+		if err != nil {
+			log.Fatalf("Error creating monitoring client: %v\n", err)
+		}
+		defer client.Close()
+
+		if err := monitoring.ExportBackupCheck(ctx, client, opts.MonitoringProject, check); err != nil {
+			log.Fatalf("Error reporting metrics: %v\n", err)
+		}
+	}
+
+	if !check.Valid() {
+		log.Fatalf("Snapshot %s failed verification with %d problems\n", check.Snapshot, len(check.Problems))
+	}
+	log.Printf("Snapshot %s verified in %v\n", check.Snapshot, check.Duration.Round(time.Millisecond))
+}
+
+// parseRowRange parses MIN-MAX or MIN into a row range
+func parseRowRange(s string) (model.RowRange, error) {
+	minValue, maxValue, bounded := strings.Cut(s, "-")
+
+	var r model.RowRange
+	var err error
+	if r.Min, err = strconv.ParseInt(minValue, 10, 64); err != nil || r.Min < 0 {
+		return r, fmt.Errorf("invalid minimum %q", minValue)
+	}
+	if bounded {
+		if r.Max, err = strconv.ParseInt(maxValue, 10, 64); err != nil || r.Max == 0 || r.Max < r.Min {
+			return r, fmt.Errorf("invalid maximum %q", maxValue)
+		}
+	}
+	return r, nil
+}
+
+// verify restores the snapshot of check into a temporary directory and checks the restored database
+func verify(ctx context.Context, check *model.BackupCheck, expected map[string]model.RowRange, wrapper envelope.KeyWrapper) error {
+	body, name, created, err := openSnapshot(ctx, check.Snapshot)
+	if err != nil {
+		return fmt.Errorf("error fetching snapshot: %w", err)
+	}
+	defer body.Close()
+	check.Snapshot, check.Created = name, created
+
+	var r io.Reader = body
+	if strings.HasSuffix(name, envelope.Extension) {
+		if wrapper == nil {
+			return errors.New("snapshot is encrypted, please set --kms-key")
+		}
+		if r, err = envelope.NewReader(ctx, body, wrapper); err != nil {
+			return fmt.Errorf("error decrypting snapshot: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "verify-backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "metadata.db")
+	if err := repo.ReadSnapshot(r, dbPath); err != nil {
+		return fmt.Errorf("error restoring snapshot: %w", err)
+	}
+
+	db := repo.NewDatabase(dbPath, maxDbConnections)
+	if err := db.Connect(ctx); err != nil {
+		return fmt.Errorf("error opening restored database: %w", err)
+	}
+	defer db.Close()
+
+	if exists, err := db.PingTable(); err != nil {
+		return fmt.Errorf("error opening restored database: %w", err)
+	} else if !exists {
+		return errors.New("restored database has not been initialized")
+	}
+
+	return db.Verify(ctx, check, expected)
+}
+
+// openSnapshot opens the snapshot at location, returning its name and creation time if known
+func openSnapshot(ctx context.Context, location string) (io.ReadCloser, string, time.Time, error) {
+	switch {
+	case strings.HasPrefix(location, "gs://"):
+		return openLatestObject(ctx, strings.TrimPrefix(location, "gs://"))
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return openURL(ctx, location)
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, "", time.Time{}, err
+	}
+	return f, location, info.ModTime(), nil
+}
+
+// openLatestObject opens the snapshot object created last under location, given as bucket/prefix
+func openLatestObject(ctx context.Context, location string) (io.ReadCloser, string, time.Time, error) {
+	bucketName, prefix, _ := strings.Cut(location, "/")
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("error creating storage client: %w", err)
+	}
+
+	bucket := client.Bucket(bucketName)
+	var latest *storage.ObjectAttrs
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			client.Close()
+			return nil, "", time.Time{}, fmt.Errorf("error listing snapshots: %w", err)
+		}
+
+		if !strings.HasSuffix(attrs.Name, repo.SnapshotExtension) && !strings.HasSuffix(attrs.Name, repo.SnapshotExtension+envelope.Extension) {
+			continue
+		}
+		if latest == nil || attrs.Created.After(latest.Created) {
+			latest = attrs
+		}
+	}
+	if latest == nil {
+		client.Close()
+		return nil, "", time.Time{}, fmt.Errorf("no snapshot found under gs://%s", location)
+	}
+
+	r, err := bucket.Object(latest.Name).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		return nil, "", time.Time{}, err
+	}
+	return &objectReader{r, client}, "gs://" + path.Join(bucketName, latest.Name), latest.Created, nil
+}
+
+// openURL downloads the snapshot served at url, named after its Content-Disposition
+func openURL(ctx context.Context, url string) (io.ReadCloser, string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", time.Time{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// Snapshots served by the API are taken as they are requested
+	name := url
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && len(params["filename"]) > 0 {
+		name = params["filename"]
+	}
+	return resp.Body, name, time.Now(), nil
+}
+
+// objectReader reads an object, closing the client it was opened with once done
+type objectReader struct {
+	io.ReadCloser
+	client *storage.Client
+}
+
+func (c *objectReader) Close() error {
+	err := c.ReadCloser.Close()
+	c.client.Close()
+	return err
+}
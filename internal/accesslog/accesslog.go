@@ -0,0 +1,154 @@
+// Package accesslog extracts the object reads of Cloud Storage usage logs, and of Data Access audit logs
+// exported to Cloud Storage by a log sink, so read counts can complement the size rollups
+package accesslog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// StorageLogMarker is part of the names of storage log objects, which hold the daily size of buckets
+// rather than requests and are skipped when ingesting
+const StorageLogMarker = "_storage_"
+
+// auditReadMethod is the method of audit log entries reading an object
+const auditReadMethod = "storage.objects.get"
+
+// Parse returns the successful object reads of a usage log, in CSV, or of an audit log, one JSON entry per line
+func Parse(r io.Reader) ([]model.ObjectRead, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		case '{':
+			return parseAuditLog(br)
+		default:
+			return parseUsageLog(br)
+		}
+	}
+}
+
+// parseUsageLog reads a usage log, whose first row names its columns
+func parseUsageLog(r io.Reader) ([]model.ObjectRead, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage log header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"time_micros", "cs_method", "cs_uri", "sc_status", "cs_bucket", "cs_object"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("usage log has no %s column", name)
+		}
+	}
+
+	var reads []model.ObjectRead
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return reads, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading usage log: %w", err)
+		}
+
+		field := func(name string) string { return record[columns[name]] }
+		if !isUsageRead(field("cs_method"), field("sc_status"), field("cs_uri"), field("cs_object")) {
+			continue
+		}
+
+		micros, err := strconv.ParseInt(field("time_micros"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_micros %q", field("time_micros"))
+		}
+		reads = append(reads, model.ObjectRead{
+			Bucket: field("cs_bucket"),
+			Name:   field("cs_object"),
+			Time:   time.UnixMicro(micros).UTC(),
+		})
+	}
+}
+
+// isUsageRead reports whether a usage log row downloaded an object's data, JSON API requests without
+// alt=media only returning its metadata
+func isUsageRead(method, status, uri, object string) bool {
+	if method != "GET" || len(object) == 0 || !strings.HasPrefix(status, "2") {
+		return false
+	}
+	if strings.Contains(uri, "/storage/v1/") {
+		return strings.Contains(uri, "alt=media")
+	}
+	return true
+}
+
+// auditEntry holds the fields of an audit log entry identifying an object read
+type auditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	ProtoPayload struct {
+		MethodName   string `json:"methodName"`
+		ResourceName string `json:"resourceName"`
+		Status       struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	} `json:"protoPayload"`
+}
+
+// parseAuditLog reads a Data Access audit log
+func parseAuditLog(r io.Reader) ([]model.ObjectRead, error) {
+	var reads []model.ObjectRead
+	decoder := json.NewDecoder(r)
+	for {
+		var entry auditEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return reads, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading audit log: %w", err)
+		}
+
+		if entry.ProtoPayload.MethodName != auditReadMethod || entry.ProtoPayload.Status.Code != 0 {
+			continue
+		}
+		bucket, name, ok := parseResourceName(entry.ProtoPayload.ResourceName)
+		if !ok {
+			continue
+		}
+		reads = append(reads, model.ObjectRead{Bucket: bucket, Name: name, Time: entry.Timestamp.UTC()})
+	}
+}
+
+// parseResourceName splits an object resource name, projects/_/buckets/BUCKET/objects/NAME
+func parseResourceName(resource string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(resource, "projects/_/buckets/")
+	if !ok {
+		return "", "", false
+	}
+	bucket, name, ok := strings.Cut(rest, "/objects/")
+	if !ok || len(bucket) == 0 || len(name) == 0 {
+		return "", "", false
+	}
+	return bucket, name, true
+}
@@ -0,0 +1,64 @@
+package accesslog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestParse(t *testing.T) {
+	usageLog := `"time_micros","c_ip","c_ip_type","c_ip_region","cs_method","cs_uri","sc_status","cs_bytes","sc_bytes","time_taken_micros","cs_host","cs_referer","cs_user_agent","s_request_id","cs_operation","cs_bucket","cs_object"
+"1727740800000000","10.0.0.1","1","","GET","/mock/a/file1","200","0","10","1000","storage.googleapis.com","","curl","id1","GET_Object","mock","a/file1"
+"1727740801000000","10.0.0.1","1","","GET","/storage/v1/b/mock/o/a%2Ffile2","200","0","10","1000","storage.googleapis.com","","curl","id2","GET_Object","mock","a/file2"
+"1727740802000000","10.0.0.1","1","","GET","/download/storage/v1/b/mock/o/a%2Ffile2?alt=media","200","0","10","1000","storage.googleapis.com","","curl","id3","GET_Object","mock","a/file2"
+"1727740803000000","10.0.0.1","1","","GET","/mock/a/missing","404","0","10","1000","storage.googleapis.com","","curl","id4","GET_Object","mock","a/missing"
+"1727740804000000","10.0.0.1","1","","PUT","/mock/a/file3","200","10","0","1000","storage.googleapis.com","","curl","id5","PUT_Object","mock","a/file3"
+`
+
+	auditLog := `
+{"timestamp":"2024-10-01T00:00:00Z","protoPayload":{"methodName":"storage.objects.get","resourceName":"projects/_/buckets/mock/objects/a/file1","status":{}}}
+{"timestamp":"2024-10-01T00:00:01Z","protoPayload":{"methodName":"storage.objects.get","resourceName":"projects/_/buckets/mock/objects/a/denied","status":{"code":7}}}
+{"timestamp":"2024-10-01T00:00:02Z","protoPayload":{"methodName":"storage.objects.list","resourceName":"projects/_/buckets/mock","status":{}}}
+`
+
+	testCases := []struct {
+		name string
+		log  string
+		want []model.ObjectRead
+	}{
+		{"Usage log", usageLog, []model.ObjectRead{
+			{Bucket: "mock", Name: "a/file1", Time: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)},
+			{Bucket: "mock", Name: "a/file2", Time: time.Date(2024, 10, 1, 0, 0, 2, 0, time.UTC)},
+		}},
+		{"Audit log", auditLog, []model.ObjectRead{
+			{Bucket: "mock", Name: "a/file1", Time: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)},
+		}},
+		{"Empty log", "\n", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tc.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("Reads mismatch: got %+v, want %+v", got, tc.want)
+			}
+			for i := range got {
+				if got[i].Bucket != tc.want[i].Bucket || got[i].Name != tc.want[i].Name || !got[i].Time.Equal(tc.want[i].Time) {
+					t.Errorf("Read %d mismatch: got %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalidUsageLog(t *testing.T) {
+	if _, err := Parse(strings.NewReader("\"time_micros\",\"cs_method\"\n")); err == nil {
+		t.Error("Expected an error for a usage log missing columns")
+	}
+}
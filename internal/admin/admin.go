@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight debug requests, such as CPU profiles, delay shutdown
+const shutdownTimeout = 5 * time.Second
+
+// NewHandler serves runtime debug endpoints:
+// pprof profiles under /debug/pprof/, expvar metrics at /debug/vars,
+// every goroutine stack at /debug/goroutines and contended locks at /debug/locks
+// Callers may register further endpoints on the returned mux
+func NewHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/goroutines", handleGoroutines)
+	mux.HandleFunc("GET /debug/locks", handleLocks)
+
+	return mux
+}
+
+// handleGoroutines dumps the stack of every goroutine, as printed by an unrecovered panic
+func handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := runtimepprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		log.Printf("Error dumping goroutines: %v", err)
+	}
+}
+
+// handleLocks dumps the stacks holding contended mutexes and blocking on synchronization,
+// which are only sampled once EnableLockProfiling was called
+func handleLocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, name := range []string{"mutex", "block"} {
+		fmt.Fprintf(w, "--- %s profile ---\n", name)
+		if err := runtimepprof.Lookup(name).WriteTo(w, 1); err != nil {
+			log.Printf("Error dumping %s profile: %v", name, err)
+		}
+	}
+}
+
+// EnableLockProfiling samples one in rate mutex contention and blocking events
+// Sampling costs some throughput, so it is disabled unless requested
+func EnableLockProfiling(rate int) {
+	runtime.SetMutexProfileFraction(rate)
+	runtime.SetBlockProfileRate(rate)
+}
+
+// ListenAndServe serves handler on addr until ctx is cancelled
+// The admin port exposes process internals, so it must not be reachable publicly
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
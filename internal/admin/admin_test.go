@@ -0,0 +1,618 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/envelope"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"pprof index", "/debug/pprof/", http.StatusOK, "goroutine"},
+		{"Named profile", "/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{"expvar", "/debug/vars", http.StatusOK, "memstats"},
+		{"Goroutine dump", "/debug/goroutines", http.StatusOK, "TestHandler"},
+		{"Lock dump", "/debug/locks", http.StatusOK, "--- mutex profile ---"},
+		{"Unknown path", "/mock", http.StatusNotFound, ""},
+	}
+
+	handler := NewHandler()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if !strings.Contains(rr.Body.String(), tc.wantBody) {
+				t.Errorf("body does not contain %q", tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleIndexes(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /debug/indexes", HandleIndexes(repo.NewIndexAdvisor(db, 1, false)))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/indexes", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var report model.IndexReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Indexes) == 0 || report.Recommendations == nil {
+		t.Errorf("Report mismatch: got %+v", report)
+	}
+}
+
+func TestHandleSeedProgress(t *testing.T) {
+	progress := &model.SeedProgress{Bucket: "mock", Objects: 42, ResumedFrom: "a/file"}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /debug/seed", HandleSeedProgress(func() *model.SeedProgress { return progress }))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/seed", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var got model.SeedProgress
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Bucket != progress.Bucket || got.Objects != progress.Objects || got.ResumedFrom != progress.ResumedFrom {
+		t.Errorf("Progress mismatch: got %+v, want %+v", got, progress)
+	}
+}
+
+func TestHandleSLO(t *testing.T) {
+	statuses := []model.SLOStatus{{
+		SLO:       model.SLO{Endpoint: "explore", Latency: model.Duration(time.Second), Target: 0.99},
+		BurnRates: []model.BurnRate{{Window: "5m0s", Requests: 10, Bad: 5, Rate: 50}},
+		Severity:  "page",
+	}}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /admin/slo", HandleSLO(func() []model.SLOStatus { return statuses }))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/slo", nil))
+
+	var got []model.SLOStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].SLO != statuses[0].SLO || got[0].Severity != "page" || len(got[0].BurnRates) != 1 {
+		t.Errorf("Status mismatch: got %+v, want %+v", got, statuses)
+	}
+}
+
+func TestHandleJobs(t *testing.T) {
+	lastRun := time.Date(2024, 10, 1, 3, 0, 0, 0, time.UTC)
+	statuses := []model.JobStatus{{
+		Name:         "vacuum",
+		Schedule:     "0 3 * * *",
+		NextRun:      lastRun.AddDate(0, 0, 1),
+		Runs:         1,
+		LastRun:      &lastRun,
+		LastDuration: model.Duration(time.Minute),
+	}}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /admin/jobs", HandleJobs(func() []model.JobStatus { return statuses }))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/jobs", nil))
+
+	var got []model.JobStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Name != "vacuum" || !got[0].NextRun.Equal(statuses[0].NextRun) || got[0].LastDuration != model.Duration(time.Minute) {
+		t.Errorf("Jobs mismatch: got %+v, want %+v", got, statuses)
+	}
+}
+
+func TestHandleScaling(t *testing.T) {
+	signal := model.ScalingSignal{Value: 1.5, Limiting: "pending", Pending: 75, Headroom: 1}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /scaling", HandleScaling(func() model.ScalingSignal { return signal }))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/scaling", nil))
+
+	var got model.ScalingSignal
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Value != signal.Value || got.Limiting != signal.Limiting || got.Pending != signal.Pending {
+		t.Errorf("Signal mismatch: got %+v, want %+v", got, signal)
+	}
+}
+
+func TestHandleShards(t *testing.T) {
+	shards := []model.BucketShard{{Bucket: "a", Address: "shard-0:8080"}, {Bucket: "b"}}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /shards", HandleShards(func(ctx context.Context) ([]model.BucketShard, error) { return shards, nil }))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/shards", nil))
+
+	var got []model.BucketShard
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0] != shards[0] || got[1] != shards[1] {
+		t.Errorf("Shards mismatch: got %+v, want %+v", got, shards)
+	}
+}
+
+func TestHandleBuckets(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	bucketRepo := repo.NewBucketRepository(db)
+	backfiller := &mockBackfiller{running: map[string]bool{"running": true}}
+	provisioner := &mockProvisioner{missing: "missing"}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /admin/buckets", HandleListBuckets(bucketRepo))
+	handler.HandleFunc("POST /admin/buckets", HandleRegisterBucket(bucketRepo, backfiller, provisioner))
+	handler.HandleFunc("DELETE /admin/buckets/{name}", HandleDeregisterBucket(bucketRepo, backfiller, provisioner))
+	handler.HandleFunc("GET /admin/buckets/{name}/config", HandleGetBucketConfig(bucketRepo))
+	handler.HandleFunc("PUT /admin/buckets/{name}/config", HandleSetBucketConfig(bucketRepo))
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"Registers bucket", "POST", "/admin/buckets", `{"name": "mock", "notify": true}`, http.StatusCreated},
+		{"Rejects missing bucket", "POST", "/admin/buckets", `{"name": "missing"}`, http.StatusBadRequest},
+		{"Registers and backfills bucket", "POST", "/admin/buckets", `{"name": "backfilled", "backfill": true}`, http.StatusCreated},
+		{"Rejects bucket being backfilled", "POST", "/admin/buckets", `{"name": "running", "backfill": true}`, http.StatusConflict},
+		{"Rejects invalid name", "POST", "/admin/buckets", `{"name": "Mock"}`, http.StatusBadRequest},
+		{"Rejects invalid body", "POST", "/admin/buckets", `mock`, http.StatusBadRequest},
+		{"Registers configured bucket", "POST", "/admin/buckets", `{"name": "configured", "config": {"workers": 4, "reconcile_interval": "1h"}}`, http.StatusCreated},
+		{"Rejects invalid config", "POST", "/admin/buckets", `{"name": "invalid", "config": {"workers": -1}}`, http.StatusBadRequest},
+		{"Reconfigures bucket", "PUT", "/admin/buckets/configured/config", `{"workers": 8, "exclude_prefixes": ["tmp/"]}`, http.StatusOK},
+		{"Rejects invalid duration", "PUT", "/admin/buckets/configured/config", `{"reconcile_interval": "soon"}`, http.StatusBadRequest},
+		{"Configures unknown bucket", "PUT", "/admin/buckets/unknown/config", `{}`, http.StatusNotFound},
+		{"Reads bucket config", "GET", "/admin/buckets/configured/config", "", http.StatusOK},
+		{"Reads unknown bucket config", "GET", "/admin/buckets/unknown/config", "", http.StatusNotFound},
+		{"Deregisters bucket", "DELETE", "/admin/buckets/backfilled", "", http.StatusNoContent},
+		{"Deregisters unknown bucket", "DELETE", "/admin/buckets/unknown", "", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+		})
+	}
+
+	if !backfiller.started["backfilled"] || !backfiller.stopped["backfilled"] {
+		t.Errorf("Backfill mismatch: started %v, stopped %v", backfiller.started, backfiller.stopped)
+	}
+	if !slices.Equal(provisioner.notified, []string{"mock"}) || !slices.Equal(provisioner.unnotified, []string{"backfilled", "unknown"}) {
+		t.Errorf("Notifications mismatch: provisioned %v, deleted %v", provisioner.notified, provisioner.unnotified)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/buckets", nil))
+
+	var got []*model.Bucket
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The conflicting registration is recorded before its backfill is rejected, buckets at their location in GCS
+	if len(got) != 3 || got[0].Name != "configured" || got[1].Name != "mock" || got[1].Location != "US" || got[2].Name != "running" {
+		t.Errorf("Buckets mismatch: got %+v", got)
+	}
+
+	cfg, err := bucketRepo.GetConfig(context.Background(), "configured")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 8 || cfg.ReconcileInterval != 0 || len(cfg.ExcludePrefixes) != 1 {
+		t.Errorf("Config mismatch: got %+v", cfg)
+	}
+}
+
+type mockBackfiller struct {
+	running map[string]bool
+	started map[string]bool
+	stopped map[string]bool
+}
+
+func (m *mockBackfiller) Start(bucket string) error {
+	if m.running[bucket] {
+		return repo.ErrConflict
+	}
+	if m.started == nil {
+		m.started = make(map[string]bool)
+	}
+	m.started[bucket] = true
+	return nil
+}
+
+func (m *mockBackfiller) Stop(bucket string) {
+	if m.stopped == nil {
+		m.stopped = make(map[string]bool)
+	}
+	m.stopped[bucket] = true
+}
+
+type mockProvisioner struct {
+	missing    string
+	notified   []string
+	unnotified []string
+}
+
+func (m *mockProvisioner) Locate(ctx context.Context, bucket string) (*model.Bucket, error) {
+	if bucket == m.missing {
+		return nil, repo.ErrNotFound
+	}
+	return &model.Bucket{Name: bucket, Location: "US", LocationType: "multi-region"}, nil
+}
+
+func (m *mockProvisioner) Notify(ctx context.Context, bucket string) (string, error) {
+	m.notified = append(m.notified, bucket)
+	return "1", nil
+}
+
+func (m *mockProvisioner) Unnotify(ctx context.Context, bucket string) error {
+	m.unnotified = append(m.unnotified, bucket)
+	return nil
+}
+
+func TestHandleUsage(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	meter := repo.NewUsageMeter(db)
+	meter.Record("mock", 10, time.Millisecond)
+	if err := meter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /admin/usage", HandleUsage(repo.NewUsageRepository(db)))
+
+	for _, query := range []string{"?days=0", "?days=mock", "?days=1000"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage"+query, nil))
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s status code mismatch: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage?days=1", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var got model.UsageReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Consumers) != 1 || got.Consumers[0].Consumer != "mock" || got.Consumers[0].Requests != 1 {
+		t.Errorf("Usage mismatch: got %+v", got.Consumers)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	bucketRepo := repo.NewBucketRepository(db)
+	auditRepo := repo.NewAuditRepository(db)
+
+	mux := NewHandler()
+	mux.HandleFunc("GET /admin/buckets", HandleListBuckets(bucketRepo))
+	mux.HandleFunc("POST /admin/buckets", HandleRegisterBucket(bucketRepo, nil, nil))
+	mux.HandleFunc("GET /admin/audit", HandleAuditLog(auditRepo))
+	mux.HandleFunc("GET /admin/export", func(w http.ResponseWriter, r *http.Request) {})
+	handler := Audit(mux, auditRepo, func(r *http.Request) string { return r.Header.Get("X-Operator") }, "/admin/export")
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/admin/buckets", `{"name": "mock"}`},
+		{"GET", "/admin/buckets", ""},
+		{"POST", "/admin/buckets", `{"name": "backfilled", "backfill": true}`},
+		{"GET", "/admin/export", ""},
+		{"POST", "/admin/buckets", strings.Repeat(" ", maxAuditedBody+1)},
+	}
+
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+		r.Header.Set("X-Operator", "operator@example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// The handler still reads the body
+	if buckets, err := bucketRepo.List(context.Background()); err != nil || len(buckets) != 1 || buckets[0].Name != "mock" {
+		t.Errorf("Buckets mismatch: got %+v, %v", buckets, err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/audit?limit=10", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var got []*model.AuditEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are not audited, unless their path is, and bodies over the limit are rejected before being served
+	if len(got) != 4 {
+		t.Fatalf("Entry count mismatch: got %d, want 4", len(got))
+	}
+
+	if got[0].Status != http.StatusRequestEntityTooLarge || len(got[0].Params) != maxAuditParams {
+		t.Errorf("Oversized entry mismatch: got status %d, %d bytes of params", got[0].Status, len(got[0].Params))
+	}
+	if got[1].Status != http.StatusOK || got[1].Method != "GET" || got[1].Path != "/admin/export" {
+		t.Errorf("Audited read entry mismatch: got %+v", got[1])
+	}
+	if got[2].Status != http.StatusBadRequest || got[2].Error != "Backfill is not enabled" || got[2].Params != requests[2].body {
+		t.Errorf("Rejected entry mismatch: got %+v", got[2])
+	}
+	if got[3].Status != http.StatusCreated || got[3].Identity != "operator@example.com" || got[3].Method != "POST" || got[3].Path != "/admin/buckets" {
+		t.Errorf("Registration entry mismatch: got %+v", got[3])
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=mock", "?before=-1"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/audit"+query, nil))
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s status code mismatch: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleSnapshot(t *testing.T) {
+	testCases := []struct {
+		name            string
+		wrapper         envelope.KeyWrapper
+		wantContentType string
+		wantExtension   string
+	}{
+		{"Compressed", nil, "application/zstd", ".db.zst\""},
+		{"Encrypted", xorWrapper(0x5a), "application/octet-stream", ".db.zst.enc\""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := repo.NewDatabase(repo.InMemoryURL(t.Name()), 1)
+			if err := db.Connect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if err := db.CreateTables(); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.NewBucketRepository(db).Upsert(ctx, model.Bucket{Name: "mock"}); err != nil {
+				t.Fatal(err)
+			}
+
+			handler := NewHandler()
+			handler.HandleFunc("GET /admin/snapshot", HandleSnapshot(db, 3, tc.wrapper))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/snapshot", nil))
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("Content-Type mismatch: got %q want %q", got, tc.wantContentType)
+			}
+			if got := rr.Header().Get("Content-Disposition"); !strings.HasSuffix(got, tc.wantExtension) {
+				t.Errorf("Content-Disposition mismatch: got %q want suffix %q", got, tc.wantExtension)
+			}
+
+			var body io.Reader = rr.Body
+			if tc.wrapper != nil {
+				var err error
+				if body, err = envelope.NewReader(ctx, rr.Body, tc.wrapper); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "metadata.db")
+			if err := repo.ReadSnapshot(body, path); err != nil {
+				t.Fatal(err)
+			}
+
+			restored := repo.NewDatabase(path, 1)
+			if err := restored.Connect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer restored.Close()
+
+			if _, err := repo.NewBucketRepository(restored).Get(ctx, "mock"); err != nil {
+				t.Errorf("Expected the snapshot to hold bucket mock, got %v", err)
+			}
+		})
+	}
+}
+
+// xorWrapper wraps data keys by xoring them with a byte, standing in for Cloud KMS
+type xorWrapper byte
+
+func (x xorWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	wrapped := make([]byte, len(key))
+	for i := range key {
+		wrapped[i] = key[i] ^ byte(x)
+	}
+	return wrapped, nil
+}
+
+func (x xorWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return x.Wrap(ctx, wrapped)
+}
+
+func TestHandlePause(t *testing.T) {
+	pauser := &mockPauser{paused: make(map[string]bool)}
+
+	handler := NewHandler()
+	handler.HandleFunc("GET /admin/paused", HandleListPaused(pauser))
+	handler.HandleFunc("POST /admin/buckets/{name}/pause", HandlePauseBucket(pauser))
+	handler.HandleFunc("POST /admin/buckets/{name}/resume", HandleResumeBucket(pauser))
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"Pauses bucket", "/admin/buckets/mock/pause", http.StatusNoContent},
+		{"Pauses paused bucket", "/admin/buckets/mock/pause", http.StatusNoContent},
+		{"Rejects invalid name", "/admin/buckets/Mock/pause", http.StatusBadRequest},
+		{"Pauses other bucket", "/admin/buckets/other/pause", http.StatusNoContent},
+		{"Resumes bucket", "/admin/buckets/other/resume", http.StatusNoContent},
+		{"Resumes running bucket", "/admin/buckets/other/resume", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", tc.path, nil))
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/paused", nil))
+
+	var got []model.PausedBucket
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Bucket != "mock" {
+		t.Errorf("Paused mismatch: got %+v", got)
+	}
+}
+
+type mockPauser struct {
+	paused map[string]bool
+}
+
+func (m *mockPauser) Pause(bucket string) {
+	m.paused[bucket] = true
+}
+
+func (m *mockPauser) Resume(bucket string) bool {
+	paused := m.paused[bucket]
+	delete(m.paused, bucket)
+	return paused
+}
+
+func (m *mockPauser) Paused() []model.PausedBucket {
+	var paused []model.PausedBucket
+	for bucket := range m.paused {
+		paused = append(paused, model.PausedBucket{Bucket: bucket})
+	}
+	return paused
+}
+
+func TestHandleReplay(t *testing.T) {
+	replayer := &mockReplayer{}
+
+	handler := NewHandler()
+	handler.HandleFunc("POST /admin/replay", HandleReplay(replayer))
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"Replays since a time", `{"since": "2024-10-01T00:00:00Z"}`, http.StatusAccepted},
+		{"Replays from a snapshot", `{"snapshot": "projects/mock/snapshots/mock"}`, http.StatusAccepted},
+		{"Rejects both", `{"since": "2024-10-01T00:00:00Z", "snapshot": "projects/mock/snapshots/mock"}`, http.StatusBadRequest},
+		{"Rejects neither", `{}`, http.StatusBadRequest},
+		{"Rejects invalid body", `mock`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/replay", strings.NewReader(tc.body)))
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+		})
+	}
+
+	if want := []string{"2024-10-01T00:00:00Z", "projects/mock/snapshots/mock"}; len(replayer.replays) != 2 || replayer.replays[0] != want[0] || replayer.replays[1] != want[1] {
+		t.Errorf("Replays mismatch: got %v, want %v", replayer.replays, want)
+	}
+}
+
+type mockReplayer struct {
+	replays []string
+}
+
+func (m *mockReplayer) Replay(ctx context.Context, since time.Time, snapshot string) error {
+	if len(snapshot) > 0 {
+		m.replays = append(m.replays, snapshot)
+	} else {
+		m.replays = append(m.replays, since.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	// maxAuditedBody is the number of bytes of the bodies of audited requests read at most, larger requests being
+	// rejected
+	maxAuditedBody = 1 << 20
+	// maxAuditParams is the number of bytes of request bodies kept in the audit log
+	maxAuditParams = 4096
+	// maxAuditError is the number of bytes of error responses kept in the audit log
+	maxAuditError = 512
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// Audit records every request of next other than reads into the audit log,
+// with the identity identify returns, its body and its outcome
+// Reads of the paths of auditedReads are recorded too, such as exports of the index
+// Requests are served even if they fail to be recorded
+func Audit(next http.Handler, auditRepo repo.AuditRepository, identify func(r *http.Request) string, auditedReads ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if read && !slices.Contains(auditedReads, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		var err error
+		if r.Body != nil {
+			body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuditedBody))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		aw := &auditWriter{ResponseWriter: w, status: http.StatusOK}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(aw, fmt.Sprintf("Request body exceeds %d bytes", maxAuditedBody), http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(aw, "Error reading request", http.StatusBadRequest)
+		default:
+			next.ServeHTTP(aw, r)
+		}
+
+		entry := &model.AuditEntry{
+			Time:     time.Now(),
+			Identity: identify(r),
+			Method:   r.Method,
+			Path:     r.URL.RequestURI(),
+			Params:   truncate(string(body), maxAuditParams),
+			Status:   aw.status,
+		}
+		if aw.status >= http.StatusBadRequest {
+			entry.Error = truncate(strings.TrimSpace(aw.body.String()), maxAuditError)
+		}
+
+		// The mutation is applied even if the client went away, so is its entry
+		if err := auditRepo.Record(context.WithoutCancel(r.Context()), entry); err != nil {
+			log.Printf("Error recording audit entry %s %s by %s: %v", entry.Method, entry.Path, entry.Identity, err)
+		}
+	})
+}
+
+// HandleAuditLog lists audit entries from the latest one, limit at a time
+// The before parameter continues a listing from the ID of the last entry returned
+func HandleAuditLog(auditRepo repo.AuditRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultAuditLimit
+		if limitString := r.URL.Query().Get("limit"); len(limitString) > 0 {
+			var err error
+			limit, err = strconv.Atoi(limitString)
+			if err != nil || limit < 1 || limit > maxAuditLimit {
+				http.Error(w, fmt.Sprintf("Invalid limit parameter, please use a number between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var before int64
+		if beforeString := r.URL.Query().Get("before"); len(beforeString) > 0 {
+			var err error
+			before, err = strconv.ParseInt(beforeString, 10, 64)
+			if err != nil || before < 1 {
+				http.Error(w, "Invalid before parameter, please use the ID of an entry", http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries, err := auditRepo.List(r.Context(), before, limit)
+		if err != nil {
+			log.Printf("Error listing audit entries: %v", err)
+			http.Error(w, "Error listing audit entries", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, entries)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// auditWriter records the status code of the response, and its body up to maxAuditError bytes
+type auditWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (a *auditWriter) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status = status
+		a.wroteHeader = true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditWriter) Write(p []byte) (int, error) {
+	a.wroteHeader = true
+	if remaining := maxAuditError - a.body.Len(); remaining > 0 {
+		a.body.Write(p[:min(len(p), remaining)])
+	}
+	return a.ResponseWriter.Write(p)
+}
+
+func (a *auditWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
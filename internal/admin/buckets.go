@@ -0,0 +1,322 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// bucketNamePattern matches GCS bucket names, dotted names being allowed up to 222 characters
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,221}[a-z0-9]$`)
+
+// Backfiller seeds registered buckets in the background
+type Backfiller interface {
+	// Start begins seeding bucket, or returns repo.ErrConflict if it is already being seeded
+	Start(bucket string) error
+	// Stop cancels the seeding of bucket if running and waits for it to return
+	Stop(bucket string)
+}
+
+// Provisioner looks registered buckets up in GCS and provisions their notifications
+type Provisioner interface {
+	// Locate returns bucket with its location, or repo.ErrNotFound if it doesn't exist
+	Locate(ctx context.Context, bucket string) (*model.Bucket, error)
+	// Notify provisions the notification of the events of bucket to the topic of the subscriber, unless it
+	// exists, returning its ID
+	Notify(ctx context.Context, bucket string) (string, error)
+	// Unnotify deletes the notifications of bucket to the topic of the subscriber
+	Unnotify(ctx context.Context, bucket string) error
+}
+
+// Pauser pauses applying the notifications of buckets, such as the subscriber during maintenance of a bucket
+type Pauser interface {
+	Pause(bucket string)
+	// Resume applies the notifications of bucket again, returning false if it wasn't paused
+	Resume(bucket string) bool
+	Paused() []model.PausedBucket
+}
+
+// Replayer reprocesses the notifications of a subscription, such as the subscriber
+type Replayer interface {
+	// Replay seeks back to since, or to snapshot if set
+	Replay(ctx context.Context, since time.Time, snapshot string) error
+}
+
+// HandleListBuckets lists the registered buckets
+func HandleListBuckets(bucketRepo repo.BucketRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := bucketRepo.List(r.Context())
+		if err != nil {
+			log.Printf("Error listing buckets: %v", err)
+			http.Error(w, "Error listing buckets", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, buckets)
+	}
+}
+
+// HandleRegisterBucket registers the bucket of a model.BucketRegistration body at its location in GCS, provisioning
+// its notification and backfilling it if requested
+// backfiller and provisioner may be nil, in which case registrations requesting a backfill or notifications are
+// rejected, and buckets are registered without their location until backfilled
+func HandleRegisterBucket(bucketRepo repo.BucketRepository, backfiller Backfiller, provisioner Provisioner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var registration model.BucketRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid registration: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if !bucketNamePattern.MatchString(registration.Name) {
+			http.Error(w, fmt.Sprintf("Invalid bucket name %q", registration.Name), http.StatusBadRequest)
+			return
+		}
+
+		if registration.Backfill && backfiller == nil {
+			http.Error(w, "Backfill is not enabled", http.StatusBadRequest)
+			return
+		}
+		if registration.Notify && provisioner == nil {
+			http.Error(w, "Notification provisioning is not enabled", http.StatusBadRequest)
+			return
+		}
+
+		if registration.Config != nil {
+			if err := validateConfig(registration.Config); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid config: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		bucket := &model.Bucket{Name: registration.Name}
+		if provisioner != nil {
+			var err error
+			bucket, err = provisioner.Locate(r.Context(), registration.Name)
+			if errors.Is(err, repo.ErrNotFound) {
+				http.Error(w, fmt.Sprintf("Bucket %s does not exist", registration.Name), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("Error looking bucket %s up: %v", registration.Name, err)
+				http.Error(w, "Error looking bucket up", http.StatusBadGateway)
+				return
+			}
+		}
+
+		if registration.Notify {
+			id, err := provisioner.Notify(r.Context(), bucket.Name)
+			if err != nil {
+				log.Printf("Error provisioning the notification of bucket %s: %v", bucket.Name, err)
+				http.Error(w, "Error provisioning notification", http.StatusBadGateway)
+				return
+			}
+			log.Printf("Bucket %s notifies the subscriber with notification %s", bucket.Name, id)
+		}
+
+		if err := bucketRepo.Upsert(r.Context(), *bucket); err != nil {
+			log.Printf("Error registering bucket: %v", err)
+			http.Error(w, "Error registering bucket", http.StatusInternalServerError)
+			return
+		}
+		if registration.Config != nil {
+			if err := bucketRepo.SetConfig(r.Context(), bucket.Name, registration.Config); err != nil {
+				log.Printf("Error configuring bucket: %v", err)
+				http.Error(w, "Error configuring bucket", http.StatusInternalServerError)
+				return
+			}
+		}
+		log.Printf("Registered bucket %s", bucket.Name)
+
+		if registration.Backfill {
+			err := backfiller.Start(bucket.Name)
+			if errors.Is(err, repo.ErrConflict) {
+				http.Error(w, "Bucket is already being backfilled", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Printf("Error starting backfill: %v", err)
+				http.Error(w, "Error starting backfill", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, bucket)
+	}
+}
+
+// HandleDeregisterBucket stops backfilling the bucket of the path and deletes its notifications to the subscriber,
+// then deregisters it and purges its rows
+// backfiller and provisioner may be nil
+func HandleDeregisterBucket(bucketRepo repo.BucketRepository, backfiller Backfiller, provisioner Provisioner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		if backfiller != nil {
+			backfiller.Stop(name)
+		}
+		// Notifications left behind would index the bucket again
+		if provisioner != nil {
+			if err := provisioner.Unnotify(r.Context(), name); err != nil {
+				log.Printf("Error deleting the notifications of bucket %s: %v", name, err)
+				http.Error(w, "Error deleting notifications", http.StatusBadGateway)
+				return
+			}
+		}
+
+		err := bucketRepo.Delete(r.Context(), name)
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Bucket is not registered", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error deregistering bucket: %v", err)
+			http.Error(w, "Error deregistering bucket", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Deregistered bucket %s", name)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleGetBucketConfig returns the configuration of the bucket of the path
+func HandleGetBucketConfig(bucketRepo repo.BucketRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := bucketRepo.GetConfig(r.Context(), r.PathValue("name"))
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Bucket is not registered", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading bucket config: %v", err)
+			http.Error(w, "Error reading bucket config", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, cfg)
+	}
+}
+
+// HandleSetBucketConfig replaces the configuration of the bucket of the path with a model.BucketConfig body
+// Running backfills keep the configuration they started with
+func HandleSetBucketConfig(bucketRepo repo.BucketRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var cfg model.BucketConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid config: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateConfig(&cfg); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid config: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err := bucketRepo.SetConfig(r.Context(), r.PathValue("name"), &cfg)
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Bucket is not registered", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error configuring bucket: %v", err)
+			http.Error(w, "Error configuring bucket", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, cfg)
+	}
+}
+
+func validateConfig(cfg *model.BucketConfig) error {
+	switch {
+	case cfg.Workers < 0:
+		return errors.New("workers must not be negative")
+	case cfg.MaxObjectsPerSecond < 0:
+		return errors.New("max_objects_per_second must not be negative")
+	case cfg.ReconcileInterval < 0:
+		return errors.New("reconcile_interval must not be negative")
+	}
+
+	for _, prefix := range slices.Concat(cfg.ExcludePrefixes, cfg.AggregateOnlyPrefixes) {
+		if len(prefix) == 0 {
+			return errors.New("prefixes must not be empty")
+		}
+	}
+	for prefix, budget := range cfg.Budgets {
+		if len(prefix) == 0 {
+			return errors.New("budget prefixes must not be empty, use / to budget the whole bucket")
+		}
+		if budget <= 0 {
+			return fmt.Errorf("budget of %s must be positive", prefix)
+		}
+	}
+	return nil
+}
+
+// HandleListPaused lists the buckets whose notifications are paused
+func HandleListPaused(pauser Pauser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pauser.Paused())
+	}
+}
+
+// HandlePauseBucket pauses applying the notifications of the bucket of the path until it is resumed
+func HandlePauseBucket(pauser Pauser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !bucketNamePattern.MatchString(name) {
+			http.Error(w, "Invalid bucket name", http.StatusBadRequest)
+			return
+		}
+
+		pauser.Pause(name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleResumeBucket applies the notifications of the bucket of the path again
+func HandleResumeBucket(pauser Pauser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !pauser.Resume(r.PathValue("name")) {
+			http.Error(w, "Bucket is not paused", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleReplay seeks the subscription back to the time or snapshot of a model.ReplayRequest body, reprocessing the
+// notifications since, such as after fixing a bug which applied some of them wrong
+func HandleReplay(replayer Replayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.ReplayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid replay: %v", err), http.StatusBadRequest)
+			return
+		}
+		if (req.Since == nil) == (len(req.Snapshot) == 0) {
+			http.Error(w, "Invalid replay, please set either since or snapshot", http.StatusBadRequest)
+			return
+		}
+
+		var since time.Time
+		if req.Since != nil {
+			since = *req.Since
+		}
+		if err := replayer.Replay(r.Context(), since, req.Snapshot); err != nil {
+			log.Printf("Error replaying notifications: %v", err)
+			http.Error(w, "Error replaying notifications", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/envelope"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	defaultUsageDays = 7
+	// maxUsageDays is the number of days consumer usage is kept
+	maxUsageDays = int(repo.UsageRetention / (24 * time.Hour))
+)
+
+// HandleIndexes lists the database indexes, why they exist and the ones advisor recommends
+func HandleIndexes(advisor *repo.IndexAdvisor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := advisor.Report(r.Context())
+		if err != nil {
+			log.Printf("Error reporting indexes: %v", err)
+			http.Error(w, "Error reporting indexes", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, report)
+	}
+}
+
+// HandleSeedProgress reports the objects a running seeding indexed and when it should complete
+func HandleSeedProgress(progress func() *model.SeedProgress) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, progress())
+	}
+}
+
+// HandleSLO reports how fast every endpoint with a latency objective burns its error budget
+func HandleSLO(status func() []model.SLOStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, status())
+	}
+}
+
+// HandleJobs lists the background jobs of the server with their next run and the outcome of their last
+func HandleJobs(status func() []model.JobStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, status())
+	}
+}
+
+// HandleScaling reports the scaling signal of the server, for external autoscalers such as
+// KEDA's metrics API scaler reading its value field
+func HandleScaling(signal func() model.ScalingSignal) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, signal())
+	}
+}
+
+// HandleShards lists the instance owning every bucket of a sharded fleet, for clients to route queries to
+func HandleShards(owners func(ctx context.Context) ([]model.BucketShard, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shards, err := owners(r.Context())
+		if err != nil {
+			log.Printf("Error reading bucket owners: %v", err)
+			http.Error(w, "Error reading bucket owners", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, shards)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// HandleUsage reports the daily requests, bytes served and latency of every API consumer
+// over the last days given by the days parameter, 7 by default
+func HandleUsage(usageRepo repo.UsageRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := defaultUsageDays
+		if daysString := r.URL.Query().Get("days"); len(daysString) > 0 {
+			var err error
+			days, err = strconv.Atoi(daysString)
+			if err != nil || days < 1 || days > maxUsageDays {
+				http.Error(w, fmt.Sprintf("Invalid days parameter, please use a number between 1 and %d", maxUsageDays), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Today counts as the first day
+		since := time.Now().UTC().AddDate(0, 0, 1-days)
+		usage, err := usageRepo.GetUsage(r.Context(), since)
+		if err != nil {
+			log.Printf("Error retrieving consumer usage: %v", err)
+			http.Error(w, "Error retrieving consumer usage", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, model.UsageReport{Since: since.Format(time.DateOnly), Consumers: usage})
+	}
+}
+
+// HandleSnapshot streams a zstd compressed copy of the whole database, compressed at level,
+// to be kept as a backup or restored elsewhere
+// Snapshots are encrypted with a data key wrapped by wrapper unless nil
+func HandleSnapshot(db *repo.Database, level int, wrapper envelope.KeyWrapper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contentType, extension := "application/zstd", repo.SnapshotExtension
+		if wrapper != nil {
+			contentType, extension = "application/octet-stream", extension+envelope.Extension
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"metadata-%s.db%s\"", time.Now().UTC().Format("20060102T150405Z"), extension))
+
+		var out io.Writer = w
+		var ew io.WriteCloser
+		if wrapper != nil {
+			var err error
+			if ew, err = envelope.NewWriter(r.Context(), w, wrapper); err != nil {
+				log.Printf("Error encrypting snapshot: %v", err)
+				http.Error(w, "Error encrypting snapshot", http.StatusInternalServerError)
+				return
+			}
+			out = ew
+		}
+
+		// Errors past the first bytes can't change the status, the truncated stream fails to decompress
+		// and encrypted streams are left unsealed so they fail to decrypt
+		if err := db.WriteSnapshot(r.Context(), out, level); err != nil {
+			log.Printf("Error writing snapshot: %v", err)
+			http.Error(w, "Error writing snapshot", http.StatusInternalServerError)
+			return
+		}
+		if ew != nil {
+			if err := ew.Close(); err != nil {
+				log.Printf("Error encrypting snapshot: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type aclHandler struct {
+	aclRepo repo.ACLRepository
+}
+
+func NewACLHandler(aclRepo repo.ACLRepository) *aclHandler {
+	return &aclHandler{aclRepo}
+}
+
+// HandleACLReport lists the prefixes containing objects that grant access through object ACLs
+func (a *aclHandler) HandleACLReport(w http.ResponseWriter, r *http.Request) {
+	// Normalize path param by adding slash(/) suffix if missing
+	path := r.PathValue("path")
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+
+	report, err := a.aclRepo.GetACLReport(r.Context(), path)
+	if err != nil {
+		writeError(w, "retrieving ACL report", err)
+		return
+	}
+
+	response := model.ACLReport{
+		Path:     r.PathValue("path"),
+		Prefixes: report,
+	}
+
+	writeResponse(w, r, response, aclRows(report))
+}
+
+// aclRow is the tabular form of an ACL report with entities separated by semicolons
+type aclRow struct {
+	Prefix   string `json:"prefix"`
+	Objects  int64  `json:"objects"`
+	Entities string `json:"entities"`
+}
+
+func aclRows(report []*model.PrefixACLStats) []aclRow {
+	rows := make([]aclRow, len(report))
+	for i, stat := range report {
+		rows[i] = aclRow{stat.Prefix, stat.Objects, strings.Join(stat.Entities, ";")}
+	}
+	return rows
+}
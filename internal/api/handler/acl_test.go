@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestHandleACLReport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		query    string
+		wantPath string
+		wantBody string
+	}{
+		{"Normalizes path", "mock", "", "mock/", ""},
+		{"Root path", "", "", "/", ""},
+		{"Renders entities in CSV", "mock/", "?format=csv", "mock/", "prefix,objects,entities\nmock/a/,2,allUsers;user-mock@example.com\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/reports/acl/"+tc.path+tc.query, nil)
+			req.SetPathValue("path", tc.path)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockACLRepository{}
+
+			handler := NewACLHandler(mockRepo)
+			handler.HandleACLReport(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			if mockRepo.prefix != tc.wantPath {
+				t.Errorf("prefix mismatch: got %s want %s", mockRepo.prefix, tc.wantPath)
+			}
+
+			if len(tc.wantBody) > 0 && rr.Body.String() != tc.wantBody {
+				t.Errorf("body mismatch: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type mockACLRepository struct {
+	prefix string
+}
+
+func (m *mockACLRepository) Upsert(ctx context.Context, bucket string, name string, entities []string) error {
+	return nil
+}
+
+func (m *mockACLRepository) GetACLReport(ctx context.Context, prefix string) ([]*model.PrefixACLStats, error) {
+	m.prefix = prefix
+	return []*model.PrefixACLStats{
+		{Prefix: "mock/a/", Objects: 2, Entities: []string{"allUsers", "user-mock@example.com"}},
+	}, nil
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type comparisonHandler struct {
+	comparisonRepo repo.ComparisonRepository
+}
+
+func NewComparisonHandler(comparisonRepo repo.ComparisonRepository) *comparisonHandler {
+	return &comparisonHandler{comparisonRepo}
+}
+
+// HandleCompare lists the objects missing from one of two buckets or differing in size, validating
+// replications and migrations from the index instead of listing both buckets
+func (c *comparisonHandler) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	bucketA, bucketB := r.URL.Query().Get("bucketA"), r.URL.Query().Get("bucketB")
+	if len(bucketA) == 0 || len(bucketB) == 0 {
+		http.Error(w, "Missing bucketA or bucketB parameter", http.StatusBadRequest)
+		return
+	}
+
+	// The prefix of bucketB defaults to the one of bucketA, both normalized as directories
+	prefixA := r.URL.Query().Get("prefix")
+	prefixB := prefixA
+	if r.URL.Query().Has("prefixB") {
+		prefixB = r.URL.Query().Get("prefixB")
+	}
+	if !strings.HasSuffix(prefixA, "/") {
+		prefixA = prefixA + "/"
+	}
+	if !strings.HasSuffix(prefixB, "/") {
+		prefixB = prefixB + "/"
+	}
+
+	limit := defaultPageSize
+	if limitString := r.URL.Query().Get("limit"); len(limitString) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > repo.MaxComparisonDifferences {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter, please use a number between 1 and %d", repo.MaxComparisonDifferences), http.StatusBadRequest)
+			return
+		}
+	}
+
+	comparison, err := c.comparisonRepo.CompareBuckets(r.Context(), bucketA, prefixA, bucketB, prefixB, limit)
+	if err != nil {
+		writeError(w, "comparing buckets", err)
+		return
+	}
+
+	writeResponse(w, r, comparison, differenceRows(comparison.Differences))
+}
+
+// differenceRow is the tabular form of a comparison, sizes missing on one side left empty
+type differenceRow struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	SizeA  string `json:"size_a"`
+	SizeB  string `json:"size_b"`
+}
+
+func differenceRows(differences []*model.ObjectDifference) []differenceRow {
+	rows := make([]differenceRow, len(differences))
+	for i, diff := range differences {
+		rows[i] = differenceRow{Name: diff.Name, Status: diff.Status}
+		if diff.SizeA != nil {
+			rows[i].SizeA = strconv.FormatInt(*diff.SizeA, 10)
+		}
+		if diff.SizeB != nil {
+			rows[i].SizeB = strconv.FormatInt(*diff.SizeB, 10)
+		}
+	}
+	return rows
+}
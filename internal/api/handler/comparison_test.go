@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestHandleCompare(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantPrefixA string
+		wantPrefixB string
+		wantLimit   int
+		wantBody    string
+	}{
+		{"Same prefix on both sides", "?bucketA=source&bucketB=replica&prefix=data", http.StatusOK, "data/", "data/", defaultPageSize, ""},
+		{"Whole buckets", "?bucketA=source&bucketB=replica&limit=10", http.StatusOK, "/", "/", 10, ""},
+		{"Different prefixes", "?bucketA=source&bucketB=replica&prefix=data/&prefixB=backup", http.StatusOK, "data/", "backup/", defaultPageSize, ""},
+		{"Renders CSV", "?bucketA=source&bucketB=replica&format=csv", http.StatusOK, "/", "/", defaultPageSize,
+			"name,status,size_a,size_b\nchanged,size_differs,10,20\nextra,only_in_b,,5\n"},
+		{"Missing bucket", "?bucketA=source", http.StatusBadRequest, "", "", 0, ""},
+		{"Invalid limit", "?bucketA=source&bucketB=replica&limit=0", http.StatusBadRequest, "", "", 0, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/compare"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockComparisonRepository{}
+
+			NewComparisonHandler(mockRepo).HandleCompare(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if mockRepo.prefixA != tc.wantPrefixA || mockRepo.prefixB != tc.wantPrefixB {
+				t.Errorf("prefixes mismatch: got %s and %s want %s and %s", mockRepo.prefixA, mockRepo.prefixB, tc.wantPrefixA, tc.wantPrefixB)
+			}
+			if mockRepo.limit != tc.wantLimit {
+				t.Errorf("limit mismatch: got %d want %d", mockRepo.limit, tc.wantLimit)
+			}
+			if len(tc.wantBody) > 0 && rr.Body.String() != tc.wantBody {
+				t.Errorf("body mismatch: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type mockComparisonRepository struct {
+	prefixA, prefixB string
+	limit            int
+}
+
+func (m *mockComparisonRepository) CompareBuckets(ctx context.Context, bucketA, prefixA, bucketB, prefixB string, limit int) (*model.BucketComparison, error) {
+	m.prefixA, m.prefixB, m.limit = prefixA, prefixB, limit
+	sizeA, sizeB, extra := int64(10), int64(20), int64(5)
+	return &model.BucketComparison{
+		BucketA: bucketA, PrefixA: prefixA, BucketB: bucketB, PrefixB: prefixB,
+		Differences: []*model.ObjectDifference{
+			{Name: "changed", Status: model.DifferenceSizeDiffs, SizeA: &sizeA, SizeB: &sizeB},
+			{Name: "extra", Status: model.DifferenceOnlyInB, SizeB: &extra},
+		},
+	}, nil
+}
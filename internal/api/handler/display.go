@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones are embedded as images running the server may lack a zoneinfo database
+	_ "time/tzdata"
+)
+
+// Byte units of the units query param
+const (
+	unitsBinary  = "binary"
+	unitsDecimal = "decimal"
+)
+
+// byteUnits are the unit prefixes of each system, in increasing powers of its base
+var byteUnits = map[string]struct {
+	base     float64
+	prefixes []string
+}{
+	unitsBinary:  {1024, []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}},
+	unitsDecimal: {1000, []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}},
+}
+
+// displayKind is the display tag of a field, telling how its values are rendered beyond what their type tells
+// Fields holding structs, maps or slices pass their kind on to the values they hold
+type displayKind string
+
+const (
+	// displayBytes marks sizes in bytes, rendered with unit prefixes
+	displayBytes displayKind = "bytes"
+	// displayID marks integers identifying rather than counting, such as generations, never grouped
+	displayID displayKind = "id"
+	// displayTimestamp marks RFC 3339 strings, rendered in the requested time zone like time.Time values
+	displayTimestamp displayKind = "timestamp"
+)
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// displayOptions render the raw values of responses for people, as requested by the units, tz and numbers
+// query params, so clients don't each reformat byte counts and UTC timestamps
+type displayOptions struct {
+	// units renders sizes with binary or decimal unit prefixes
+	units string
+	// location renders timestamps in a time zone rather than UTC
+	location *time.Location
+	// grouped separates the thousands of integers other than sizes
+	grouped bool
+}
+
+// parseDisplayOptions returns the display options requested, nil if values are to be rendered raw
+func parseDisplayOptions(query url.Values) (*displayOptions, error) {
+	var opts displayOptions
+	if units := strings.ToLower(query.Get("units")); len(units) > 0 && units != "raw" {
+		if _, ok := byteUnits[units]; !ok {
+			return nil, fmt.Errorf("unsupported units %q, please use 'raw', 'binary' or 'decimal'", query.Get("units"))
+		}
+		opts.units = units
+	}
+	if tz := query.Get("tz"); len(tz) > 0 {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", tz)
+		}
+		opts.location = location
+	}
+	switch numbers := strings.ToLower(query.Get("numbers")); numbers {
+	case "", "raw":
+	case "grouped":
+		opts.grouped = true
+	default:
+		return nil, fmt.Errorf("unsupported numbers %q, please use 'raw' or 'grouped'", query.Get("numbers"))
+	}
+
+	if opts == (displayOptions{}) {
+		return nil, nil
+	}
+	return &opts, nil
+}
+
+// apply returns v with its values rendered for display, structs becoming maps keyed by their json tags so that
+// fields selection still applies to the result
+func (o *displayOptions) apply(v any) any {
+	return o.render(reflect.ValueOf(v), "")
+}
+
+// render renders v, of a field of display kind kind, as encoding/json would encode it
+func (o *displayOptions) render(v reflect.Value, kind displayKind) any {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		return o.render(v.Elem(), kind)
+	}
+
+	if v.Type() == timeType {
+		if t := v.Interface().(time.Time); !t.IsZero() && o.location != nil {
+			return t.In(o.location).Format(time.RFC3339)
+		}
+		return v.Interface()
+	}
+	// Other values encoding themselves are left to their encoding
+	if marshals(v.Type()) {
+		return v.Interface()
+	}
+	if v.CanAddr() && marshals(reflect.PointerTo(v.Type())) {
+		return v.Addr().Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		doc := make(map[string]any, v.NumField())
+		o.renderFields(doc, v, kind)
+		return doc
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		doc := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			doc[iter.Key().String()] = o.render(iter.Value(), kind)
+		}
+		return doc
+	case reflect.Slice, reflect.Array:
+		// Byte slices are encoded as base64 strings
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			return v.Interface()
+		}
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = o.render(v.Index(i), kind)
+		}
+		return list
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if formatted, ok := o.formatInt(v.Int(), kind); ok {
+			return formatted
+		}
+	case reflect.String:
+		if kind == displayTimestamp && o.location != nil {
+			if t, err := time.Parse(time.RFC3339Nano, v.String()); err == nil {
+				return t.In(o.location).Format(time.RFC3339)
+			}
+		}
+	}
+	return v.Interface()
+}
+
+// renderFields renders the fields of struct v into doc, keyed by their json tags
+// The fields of embedded structs are promoted unless shadowed, as encoding/json does
+func (o *displayOptions) renderFields(doc map[string]any, v reflect.Value, kind displayKind) {
+	promoted := map[string]any{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		value := v.Field(i)
+		if field.Anonymous && len(name) == 0 {
+			embedded := value
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				o.renderFields(promoted, embedded, kind)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(value) {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = field.Name
+		}
+		fieldKind := kind
+		if tag := field.Tag.Get("display"); len(tag) > 0 {
+			fieldKind = displayKind(tag)
+		}
+		doc[name] = o.render(value, fieldKind)
+	}
+
+	for name, value := range promoted {
+		if _, ok := doc[name]; !ok {
+			doc[name] = value
+		}
+	}
+}
+
+// marshals reports whether values of t encode themselves in JSON
+func marshals(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)
+}
+
+// isEmptyValue reports whether v is left out of its struct by the omitempty option of encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// formatCSV renders a CSV value of a column of display kind kind, raw if o is nil
+func (o *displayOptions) formatCSV(v reflect.Value, kind displayKind) string {
+	if o == nil {
+		return formatCSVValue(v)
+	}
+	rendered := o.render(v, kind)
+	if rendered == nil {
+		return ""
+	}
+	return formatCSVValue(reflect.ValueOf(rendered))
+}
+
+// formatInt renders an integer of display kind kind, returning false if it is left raw
+func (o *displayOptions) formatInt(n int64, kind displayKind) (string, bool) {
+	switch {
+	case kind == displayBytes && len(o.units) > 0:
+		return formatBytes(n, o.units), true
+	case o.grouped && kind != displayID:
+		return groupDigits(n), true
+	}
+	return "", false
+}
+
+// formatBytes renders a size with the largest unit prefix of units it spans, to one decimal
+func formatBytes(n int64, units string) string {
+	system := byteUnits[units]
+	value := float64(n)
+	if n < 0 {
+		value = -value
+	}
+
+	i := 0
+	for value >= system.base && i < len(system.prefixes)-1 {
+		value /= system.base
+		i++
+	}
+
+	sign := ""
+	if n < 0 {
+		sign = "-"
+	}
+	if i == 0 {
+		return fmt.Sprintf("%s%d %s", sign, int64(value), system.prefixes[0])
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, value, system.prefixes[i])
+}
+
+// groupDigits separates the thousands of n with commas
+func groupDigits(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		n     int64
+		units string
+		want  string
+	}{
+		{0, unitsBinary, "0 B"},
+		{1023, unitsBinary, "1023 B"},
+		{1536, unitsBinary, "1.5 KiB"},
+		{5 << 30, unitsBinary, "5.0 GiB"},
+		{1500, unitsDecimal, "1.5 kB"},
+		{-2_000_000, unitsDecimal, "-2.0 MB"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatBytes(tc.n, tc.units); got != tc.want {
+			t.Errorf("formatBytes(%d, %s) mismatch: got %q, want %q", tc.n, tc.units, got, tc.want)
+		}
+	}
+}
+
+func TestGroupDigits(t *testing.T) {
+	testCases := map[int64]string{
+		0:         "0",
+		999:       "999",
+		1000:      "1,000",
+		-1234567:  "-1,234,567",
+		100000000: "100,000,000",
+	}
+
+	for n, want := range testCases {
+		if got := groupDigits(n); got != want {
+			t.Errorf("groupDigits(%d) mismatch: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestParseDisplayOptions(t *testing.T) {
+	for _, raw := range []string{"", "units=raw&numbers=raw"} {
+		query, _ := url.ParseQuery(raw)
+		if opts, err := parseDisplayOptions(query); err != nil || opts != nil {
+			t.Errorf("Expected raw rendering for %q, got %+v, %v", raw, opts, err)
+		}
+	}
+
+	for _, raw := range []string{"units=octal", "tz=Mars/Olympus", "numbers=roman"} {
+		query, _ := url.ParseQuery(raw)
+		if _, err := parseDisplayOptions(query); err == nil {
+			t.Errorf("Expected an error for %q", raw)
+		}
+	}
+}
+
+func TestApplyDisplay(t *testing.T) {
+	type inner struct {
+		Size    int64 `json:"size" display:"bytes"`
+		Count   int64 `json:"count"`
+		Ignored int64 `json:"-"`
+	}
+	type mock struct {
+		inner
+		Count      int64            `json:"count" display:"id"`
+		Sizes      map[string]int64 `json:"sizes" display:"bytes"`
+		Generation int64            `json:"generation" display:"id"`
+		Seen       string           `json:"seen" display:"timestamp"`
+		Created    time.Time        `json:"created"`
+		Interval   model.Duration   `json:"interval"`
+		Omitted    *time.Time       `json:"omitted,omitempty"`
+	}
+
+	query, _ := url.ParseQuery("units=decimal&numbers=grouped&tz=Asia/Tokyo")
+	opts, err := parseDisplayOptions(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	got := opts.apply(&mock{
+		inner:      inner{Size: 1500, Count: 1000, Ignored: 1},
+		Count:      2000,
+		Sizes:      map[string]int64{"standard": 2_000_000},
+		Generation: 1727784000123456,
+		Seen:       created.Format(time.RFC3339),
+		Created:    created,
+		Interval:   model.Duration(time.Hour),
+	})
+
+	// Promoted fields are shadowed by the fields of the outer struct, as encoding/json does
+	want := map[string]any{
+		"size":       "1.5 kB",
+		"count":      int64(2000),
+		"sizes":      map[string]any{"standard": "2.0 MB"},
+		"generation": int64(1727784000123456),
+		"seen":       "2024-10-01T21:00:00+09:00",
+		"created":    "2024-10-01T21:00:00+09:00",
+		"interval":   model.Duration(time.Hour),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Display mismatch:\ngot  %#v\nwant %#v", got, want)
+	}
+
+	// Counts named after sizes are not sizes
+	got = opts.apply(model.BucketComparison{SizeDiffers: 1234})
+	if differs := got.(map[string]any)["size_differs"]; differs != "1,234" {
+		t.Errorf("Count mismatch: got %v want %v", differs, "1,234")
+	}
+}
+
+func TestWriteResponseDisplay(t *testing.T) {
+	created := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	summary := &model.Summary{
+		Path:     "mock/",
+		Size:     model.Size{Standard: 3 << 20, Nearline: 512},
+		LiveSize: 3<<20 + 512,
+	}
+	contents := []*model.Metadata{{Bucket: "mock", Name: "mock/file", Size: 2048, Count: 12345, Created: created, Updated: created}}
+
+	req := httptest.NewRequest("GET", "/summary/mock/?units=binary&tz=Europe/Paris&numbers=grouped", nil)
+	rr := httptest.NewRecorder()
+	writeResponse(rr, req, summary, []*model.Summary{summary})
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var got struct {
+		Size     map[string]any `json:"size"`
+		LiveSize string         `json:"live_size"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Size["standard"] != "3.0 MiB" || got.Size["nearline"] != "512 B" || got.LiveSize != "3.0 MiB" {
+		t.Errorf("Sizes mismatch: got %+v", got)
+	}
+
+	req = httptest.NewRequest("GET", "/explore/mock/?format=csv&units=decimal&tz=Europe/Paris&numbers=grouped", nil)
+	rr = httptest.NewRecorder()
+	writeResponse(rr, req, model.PathContents{Path: "mock/", Contents: contents}, contents)
+
+	want := strings.Join([]string{
+		"bucket,name,parent,storage_class,size,count,cost,created,updated,custom_time,detected_type,marker,noncurrent_size",
+		"mock,mock/file,,,2.0 kB,\"12,345\",0,2024-10-01T14:00:00+02:00,2024-10-01T14:00:00+02:00,,,false,0 B",
+		"",
+	}, "\n")
+	if got := rr.Body.String(); got != want {
+		t.Errorf("CSV mismatch:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Fields are selected among the values rendered after the types of the whole document
+	req = httptest.NewRequest("GET", "/summary/mock/?units=binary&fields=size(standard)", nil)
+	rr = httptest.NewRecorder()
+	writeResponse(rr, req, summary, []*model.Summary{summary})
+
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"size":{"standard":"3.0 MiB"}}` {
+		t.Errorf("Selected sizes mismatch: got %s", body)
+	}
+
+	req = httptest.NewRequest("GET", "/explore/mock/?tz=Europe/Paris&numbers=grouped", nil)
+	rr = httptest.NewRecorder()
+	writeResponse(rr, req, model.PathContents{Path: "mock/", Contents: contents}, contents)
+
+	if body := rr.Body.String(); !strings.Contains(body, `"created":"2024-10-01T14:00:00+02:00"`) || !strings.Contains(body, `"count":"12,345"`) || !strings.Contains(body, `"size":"2,048"`) {
+		t.Errorf("JSON mismatch: got %s", body)
+	}
+}
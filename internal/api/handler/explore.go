@@ -1,15 +1,27 @@
 package handler
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
 )
 
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// defaultLargestDirectories is the number of largest directories listed unless requested otherwise
+	defaultLargestDirectories = 10
+)
+
+// NextPageTokenHeader carries the token of the next page of a listing, which streamed listings have no body field for
+const NextPageTokenHeader = "X-Next-Page-Token"
+
 type ExploreHandler interface {
 	Explore(w http.ResponseWriter, r *http.Request)
 }
@@ -40,26 +52,159 @@ func (e *exploreHandler) HandleExplore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contents, err := e.exploreRepo.GetPathContents(path, sortBy)
+	// Validate pagination query params, listings are only paginated when requested
+	pageToken := r.URL.Query().Get("page_token")
+	pageSize := defaultPageSize
+
+	if pageSizeString := r.URL.Query().Get("page_size"); len(pageSizeString) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(pageSizeString)
+		if err != nil || pageSize < 1 || pageSize > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid page_size parameter, please use a number between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	} else if len(pageToken) == 0 {
+		pageSize = 0
+	}
+
+	opts, err := parseListOptions(r)
 	if err != nil {
-		log.Printf("Error retrieving path contents: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.IncludeMarkers, err = parseIncludeMarkers(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response := struct {
-		Path     string            `json:"path"`
-		Contents []*model.Metadata `json:"contents"`
-	}{
-		Path:     r.PathValue("path"),
-		Contents: contents,
+	var contents []*model.Metadata
+	var nextPageToken string
+
+	if pageSize > 0 {
+		contents, nextPageToken, err = e.exploreRepo.GetPathContentsPage(r.Context(), path, sortBy, opts, pageSize, pageToken)
+	} else {
+		contents, err = e.exploreRepo.GetPathContents(r.Context(), path, sortBy, opts)
+	}
+
+	if err != nil {
+		writeError(w, "retrieving path contents", err)
+		return
+	}
+
+	response := model.PathContents{
+		Path:          r.PathValue("path"),
+		Contents:      contents,
+		NextPageToken: nextPageToken,
+	}
+
+	// Pages beyond the response limit end at their last row within it, the next page starting after it
+	if n, ok := rowsWithinLimit(r, response); ok && pageSize > 0 && n < len(contents) {
+		response.Contents = contents[:n]
+		response.NextPageToken, err = e.exploreRepo.PageTokenAfter(path, sortBy, opts, nextPageToken, contents[n-1])
+		if err != nil {
+			writeError(w, "resuming path contents", err)
+			return
+		}
+	}
+
+	if len(response.NextPageToken) > 0 {
+		w.Header().Set(NextPageTokenHeader, response.NextPageToken)
+	}
+
+	writeResponse(w, r, response, response.Contents)
+}
+
+// HandleSearch lists the objects under a path whose name contains the q query param
+func (e *exploreHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	// Normalize path param by adding slash(/) suffix if missing
+	path := r.PathValue("path")
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+
+	query := r.URL.Query().Get("q")
+	if len(query) == 0 {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Validate collation query param
+	collation := repo.Collation(strings.ToLower(r.URL.Query().Get("collation")))
+	switch collation {
+	case "":
+		collation = repo.CollationBinary
+	case repo.CollationBinary, repo.CollationNoCase, repo.CollationUnicode:
+	default:
+		http.Error(w, "Invalid collation parameter, please use 'binary', 'nocase' or 'unicode'", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPageSize
+	if limitString := r.URL.Query().Get("limit"); len(limitString) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter, please use a number between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := e.exploreRepo.Search(r.Context(), path, query, collation, opts, limit)
+	if err != nil {
+		writeError(w, "searching path", err)
+		return
+	}
+
+	response := model.SearchResults{
+		Path:      r.PathValue("path"),
+		Query:     query,
+		Collation: string(collation),
+		Results:   results,
+	}
+
+	writeResponse(w, r, response, results)
+}
+
+// HandleLargestDirectories lists the largest directories of the bucket query param, or of every bucket
+func (e *exploreHandler) HandleLargestDirectories(w http.ResponseWriter, r *http.Request) {
+	bucket := r.URL.Query().Get("bucket")
+
+	n := defaultLargestDirectories
+	if nString := r.URL.Query().Get("n"); len(nString) > 0 {
+		var err error
+		n, err = strconv.Atoi(nString)
+		if err != nil || n < 1 || n > repo.MaxTopDirectories {
+			http.Error(w, fmt.Sprintf("Invalid n parameter, please use a number between 1 and %d", repo.MaxTopDirectories), http.StatusBadRequest)
+			return
+		}
+	}
+
+	directories, err := e.exploreRepo.GetLargestDirectories(r.Context(), bucket, n)
+	if err != nil {
+		writeError(w, "retrieving largest directories", err)
+		return
 	}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	response := model.LargestDirectories{
+		Bucket:      bucket,
+		Directories: directories,
 	}
+
+	writeResponse(w, r, response, directories)
+}
+
+// summaryRow is the tabular form of a summary with one row per storage class
+type summaryRow struct {
+	Path         string  `json:"path"`
+	StorageClass string  `json:"storage_class"`
+	Size         int64   `json:"size" display:"bytes"`
+	Cost         float64 `json:"cost"`
 }
 
 func (e *exploreHandler) HandleSummary(w http.ResponseWriter, r *http.Request) {
@@ -69,15 +214,125 @@ func (e *exploreHandler) HandleSummary(w http.ResponseWriter, r *http.Request) {
 		path = path + "/"
 	}
 
-	summary, err := e.exploreRepo.GetPathSummary(path)
+	summary, err := e.exploreRepo.GetPathSummary(r.Context(), path)
+	if err != nil {
+		writeError(w, "retrieving path summary", err)
+		return
+	}
+
+	rows := []summaryRow{
+		{summary.Path, string(repo.StorageStandard), summary.Size.Standard, summary.Cost.Standard},
+		{summary.Path, string(repo.StorageNearline), summary.Size.Nearline, summary.Cost.Nearline},
+		{summary.Path, string(repo.StorageColdline), summary.Size.Coldline, summary.Cost.Coldline},
+		{summary.Path, string(repo.StorageArchive), summary.Size.Archive, summary.Cost.Archive},
+	}
+
+	writeResponse(w, r, summary, rows)
+}
+
+// HandleCount counts the objects under a path and their size, extrapolated from a sample of objects
+// with error margins when the approximate query param is set, for prefixes too wide to scan
+func (e *exploreHandler) HandleCount(w http.ResponseWriter, r *http.Request) {
+	// Normalize path param by adding slash(/) suffix if missing
+	path := r.PathValue("path")
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+
+	var approximate bool
+	if approximateString := r.URL.Query().Get("approximate"); len(approximateString) > 0 {
+		var err error
+		approximate, err = strconv.ParseBool(approximateString)
+		if err != nil {
+			http.Error(w, "Invalid approximate parameter, please use 'true' or 'false'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.IncludeMarkers, err = parseIncludeMarkers(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	count, err := e.exploreRepo.CountObjects(r.Context(), path, opts, approximate)
+	if err != nil {
+		writeError(w, "counting objects", err)
+		return
+	}
+
+	writeResponse(w, r, count, []*model.ObjectCount{count})
+}
+
+// parseIncludeMarkers reads the include_markers query param, directory markers being excluded by default
+func parseIncludeMarkers(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("include_markers")
+	if len(value) == 0 {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
 	if err != nil {
-		log.Printf("Error retrieving path summary: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return false, errors.New("Invalid include_markers parameter, please use 'true' or 'false'")
+	}
+	return include, nil
+}
+
+// parseListOptions reads the min_size, max_size, updated_after, updated_before and storage_class query params,
+// storage classes being separated by commas and times given as RFC 3339 or YYYY-MM-DD
+func parseListOptions(r *http.Request) (repo.ListOptions, error) {
+	var opts repo.ListOptions
+	params := r.URL.Query()
+
+	for _, size := range []struct {
+		param string
+		value **int64
+	}{
+		{"min_size", &opts.MinSize},
+		{"max_size", &opts.MaxSize},
+	} {
+		if value := params.Get(size.param); len(value) > 0 {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("%s must be a number of bytes", size.param)
+			}
+			*size.value = &n
+		}
+	}
+	if opts.MinSize != nil && opts.MaxSize != nil && *opts.MinSize > *opts.MaxSize {
+		return opts, errors.New("min_size must not exceed max_size")
+	}
+
+	for _, updated := range []struct {
+		param string
+		value **time.Time
+	}{
+		{"updated_after", &opts.UpdatedAfter},
+		{"updated_before", &opts.UpdatedBefore},
+	} {
+		if value := params.Get(updated.param); len(value) > 0 {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				t, err = time.Parse(time.DateOnly, value)
+			}
+			if err != nil {
+				return opts, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", updated.param)
+			}
+			*updated.value = &t
+		}
 	}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(summary); err != nil {
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	if classes := params.Get("storage_class"); len(classes) > 0 {
+		for _, class := range strings.Split(classes, ",") {
+			storageClass, err := repo.ParseStorageClass(class)
+			if err != nil {
+				return opts, fmt.Errorf("%w, storage_class must list STANDARD, NEARLINE, COLDLINE, ARCHIVE or a registered storage class", err)
+			}
+			opts.StorageClasses = append(opts.StorageClasses, storageClass)
+		}
 	}
+	return opts, nil
 }
@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
 )
@@ -81,6 +86,127 @@ func TestHandleExplore(t *testing.T) {
 	}
 }
 
+func TestHandleExplorePagination(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		wantStatus    int
+		wantNextToken string
+	}{
+		{
+			"Unpaginated listing",
+			"",
+			http.StatusOK,
+			"",
+		},
+		{
+			"First page",
+			"page_size=10",
+			http.StatusOK,
+			"next",
+		},
+		{
+			"Next page with default page size",
+			"page_token=mock",
+			http.StatusOK,
+			"next",
+		},
+		{
+			"Expired page token",
+			"page_token=expired",
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"Invalid page size",
+			"page_size=invalid",
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"Page size above maximum",
+			"page_size=100000",
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"Including directory markers",
+			"include_markers=true",
+			http.StatusOK,
+			"",
+		},
+		{
+			"Invalid include markers",
+			"include_markers=maybe",
+			http.StatusBadRequest,
+			"",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/explore/mock/?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			mockRepo := &mockExploreRepository{
+				pathContents: []*model.Metadata{},
+			}
+
+			handler := NewExploreHandler(mockRepo)
+			handler.HandleExplore(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v",
+					status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response model.PathContents
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if response.NextPageToken != tc.wantNextToken {
+				t.Errorf("next page token mismatch: got %q want %q", response.NextPageToken, tc.wantNextToken)
+			}
+		})
+	}
+}
+
+func TestHandleExplorePageLimit(t *testing.T) {
+	var contents []*model.Metadata
+	for i := range 10 {
+		contents = append(contents, &model.Metadata{Name: fmt.Sprintf("mock/file%d", i), Size: int64(i)})
+	}
+	handler := middleware.LimitResponses(http.HandlerFunc(NewExploreHandler(&mockExploreRepository{pathContents: contents}).HandleExplore), 600, nil)
+
+	req := httptest.NewRequest("GET", "/v1/explore/mock/?page_size=10", nil)
+	req.SetPathValue("path", "mock/")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response model.PathContents
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(response.Contents); n == 0 || n == len(contents) {
+		t.Fatalf("Expected the page to end within the limit, got %d rows", n)
+	}
+	last := response.Contents[len(response.Contents)-1]
+	if want := "after " + last.Name; response.NextPageToken != want || rr.Header().Get(NextPageTokenHeader) != want {
+		t.Errorf("Next page token mismatch: got %q and header %q, want %q", response.NextPageToken, rr.Header().Get(NextPageTokenHeader), want)
+	}
+	if size := rr.Body.Len(); size > 600 {
+		t.Errorf("Expected at most 600 bytes, got %d", size)
+	}
+}
+
 func TestHandleSummary(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -130,14 +256,220 @@ func TestHandleSummary(t *testing.T) {
 	}
 }
 
+func TestHandleCount(t *testing.T) {
+	testCases := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantApproximate bool
+	}{
+		{"Exact", "", http.StatusOK, false},
+		{"Approximate", "?approximate=true&storage_class=standard", http.StatusOK, true},
+		{"Invalid approximate", "?approximate=maybe", http.StatusBadRequest, false},
+		{"Invalid filter", "?min_size=-1", http.StatusBadRequest, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/count/mock/"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := NewExploreHandler(&mockExploreRepository{})
+			handler.HandleCount(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var count model.ObjectCount
+			if err := json.Unmarshal(rr.Body.Bytes(), &count); err != nil {
+				t.Fatal(err)
+			}
+			if count.Approximate != tc.wantApproximate {
+				t.Errorf("approximate mismatch: got %v want %v", count.Approximate, tc.wantApproximate)
+			}
+		})
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{
+			"Default collation",
+			"q=cafe",
+			http.StatusOK,
+		},
+		{
+			"Unicode collation",
+			"q=caf%C3%A9&collation=unicode&limit=10",
+			http.StatusOK,
+		},
+		{
+			"Missing query",
+			"collation=nocase",
+			http.StatusBadRequest,
+		},
+		{
+			"Invalid collation",
+			"q=cafe&collation=fuzzy",
+			http.StatusBadRequest,
+		},
+		{
+			"Limit above maximum",
+			"q=cafe&limit=100000",
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/search/mock/?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			mockRepo := &mockExploreRepository{
+				pathContents: []*model.Metadata{},
+			}
+
+			handler := NewExploreHandler(mockRepo)
+			handler.HandleSearch(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v",
+					status, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandleLargestDirectories(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{
+			"Every bucket",
+			"",
+			http.StatusOK,
+		},
+		{
+			"One bucket",
+			"bucket=mock&n=100",
+			http.StatusOK,
+		},
+		{
+			"Too many directories",
+			"n=101",
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/top/directories?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := NewExploreHandler(&mockExploreRepository{})
+			handler.HandleLargestDirectories(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v",
+					status, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestParseListOptions(t *testing.T) {
+	size := func(n int64) *int64 { return &n }
+	date := func(t time.Time) *time.Time { return &t }
+
+	testCases := []struct {
+		name    string
+		query   string
+		want    repo.ListOptions
+		wantErr bool
+	}{
+		{"No filter", "", repo.ListOptions{}, false},
+		{"Sizes", "?min_size=10&max_size=20", repo.ListOptions{MinSize: size(10), MaxSize: size(20)}, false},
+		{"Empty objects", "?max_size=0", repo.ListOptions{MaxSize: size(0)}, false},
+		{"Dates and times", "?updated_after=2024-01-01&updated_before=2024-02-01T12:00:00Z", repo.ListOptions{
+			UpdatedAfter:  date(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+			UpdatedBefore: date(time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)),
+		}, false},
+		{"Storage classes in any case", "?storage_class=archive,%20Coldline", repo.ListOptions{StorageClasses: []repo.StorageClass{repo.StorageArchive, repo.StorageColdline}}, false},
+		{"Negative size", "?min_size=-1", repo.ListOptions{}, true},
+		{"Inverted size range", "?min_size=20&max_size=10", repo.ListOptions{}, true},
+		{"Inverted size range to empty objects", "?min_size=1&max_size=0", repo.ListOptions{}, true},
+		{"Malformed time", "?updated_after=yesterday", repo.ListOptions{}, true},
+		{"Legacy storage class", "?storage_class=regional", repo.ListOptions{StorageClasses: []repo.StorageClass{"REGIONAL"}}, false},
+		{"Unknown storage class", "?storage_class=STANDARD,WARM", repo.ListOptions{}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseListOptions(httptest.NewRequest("GET", "/explore/"+tc.query, nil))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error mismatch: got %v, want error %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && got.String() != tc.want.String() {
+				t.Errorf("options mismatch: got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 type mockExploreRepository struct {
 	pathContents []*model.Metadata
 }
 
-func (m *mockExploreRepository) GetPathContents(path string, sort repo.SortType) ([]*model.Metadata, error) {
+func (m *mockExploreRepository) GetPathContents(ctx context.Context, path string, sort repo.SortType, opts repo.ListOptions) ([]*model.Metadata, error) {
 	return m.pathContents, nil
 }
 
-func (m *mockExploreRepository) GetPathSummary(path string) (*model.Summary, error) {
+func (m *mockExploreRepository) GetPathContentsPage(ctx context.Context, path string, sort repo.SortType, opts repo.ListOptions, pageSize int, pageToken string) ([]*model.Metadata, string, error) {
+	if pageToken == "expired" {
+		return nil, "", repo.ErrInvalidPageToken
+	}
+	return m.pathContents, "next", nil
+}
+
+func (m *mockExploreRepository) PageTokenAfter(path string, sort repo.SortType, opts repo.ListOptions, pageToken string, last *model.Metadata) (string, error) {
+	return "after " + last.Name, nil
+}
+
+func (m *mockExploreRepository) GetPathSummary(ctx context.Context, path string) (*model.Summary, error) {
 	return &model.Summary{}, nil
 }
+
+func (m *mockExploreRepository) GetTopLevelDirectories(ctx context.Context) ([]*model.Directory, error) {
+	return []*model.Directory{}, nil
+}
+
+func (m *mockExploreRepository) GetLargestDirectories(ctx context.Context, bucket string, n int) ([]*model.Directory, error) {
+	return []*model.Directory{}, nil
+}
+
+func (m *mockExploreRepository) CountObjects(ctx context.Context, path string, opts repo.ListOptions, approximate bool) (*model.ObjectCount, error) {
+	return &model.ObjectCount{Path: path, Approximate: approximate}, nil
+}
+
+func (m *mockExploreRepository) Search(ctx context.Context, path string, query string, collation repo.Collation, opts repo.ListOptions, limit int) ([]*model.Metadata, error) {
+	return m.pathContents, nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fieldSelection is a parsed partial response selector, e.g. "path,contents(name,size)"
+// A nil selection for a key includes its entire value
+type fieldSelection map[string]fieldSelection
+
+// parseFields parses a Google APIs style fields parameter
+// Nested fields are selected either with parentheses, contents(name,size), or slashes, contents/name
+func parseFields(expr string) (fieldSelection, error) {
+	if len(strings.TrimSpace(expr)) == 0 {
+		return nil, nil
+	}
+
+	selection, rest, err := parseFieldList(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected %q", rest)
+	}
+	return selection, nil
+}
+
+func parseFieldList(expr string) (fieldSelection, string, error) {
+	selection := make(fieldSelection)
+	for {
+		end := strings.IndexAny(expr, ",()")
+		if end == -1 {
+			end = len(expr)
+		}
+
+		path := strings.TrimSpace(expr[:end])
+		if len(path) == 0 {
+			return nil, "", errors.New("empty field name")
+		}
+		expr = expr[end:]
+
+		// Walk down slash separated paths, creating intermediate selections
+		names := strings.Split(path, "/")
+		parent := selection
+		for _, name := range names[:len(names)-1] {
+			if len(name) == 0 {
+				return nil, "", errors.New("empty field name")
+			}
+			child, ok := parent[name]
+			if !ok {
+				child = make(fieldSelection)
+				parent[name] = child
+			} else if child == nil {
+				child = make(fieldSelection) // already fully selected, discard the narrower selection
+			}
+			parent = child
+		}
+
+		leaf := names[len(names)-1]
+		if len(leaf) == 0 {
+			return nil, "", errors.New("empty field name")
+		}
+
+		if strings.HasPrefix(expr, "(") {
+			sub, rest, err := parseFieldList(expr[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			if !strings.HasPrefix(rest, ")") {
+				return nil, "", errors.New("missing closing parenthesis")
+			}
+			expr = rest[1:]
+
+			if existing, ok := parent[leaf]; ok && existing != nil {
+				for k, v := range sub {
+					existing[k] = v
+				}
+			} else if !ok {
+				parent[leaf] = sub
+			}
+		} else {
+			parent[leaf] = nil
+		}
+
+		if !strings.HasPrefix(expr, ",") {
+			return selection, expr, nil
+		}
+		expr = expr[1:]
+	}
+}
+
+// projectFields returns v reduced to the selected fields
+// Values are round tripped through JSON so that selection follows the json tags of v
+func projectFields(v any, selection fieldSelection) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return project(generic, selection), nil
+}
+
+func project(v any, selection fieldSelection) any {
+	if selection == nil {
+		return v
+	}
+
+	switch value := v.(type) {
+	case map[string]any:
+		projected := make(map[string]any, len(selection))
+		for key, sub := range selection {
+			if field, ok := value[key]; ok {
+				projected[key] = project(field, sub)
+			}
+		}
+		return projected
+	case []any:
+		for i := range value {
+			value[i] = project(value[i], selection)
+		}
+		return value
+	default:
+		return v
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestParseFields(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    fieldSelection
+		wantErr bool
+	}{
+		{"Empty selection", "", nil, false},
+		{"Top level fields", "path,contents", fieldSelection{"path": nil, "contents": nil}, false},
+		{"Nested with parentheses", "contents(name,size)", fieldSelection{"contents": {"name": nil, "size": nil}}, false},
+		{"Nested with slashes", "contents/name,contents/size", fieldSelection{"contents": {"name": nil, "size": nil}}, false},
+		{"Deeply nested", "a(b(c),d/e)", fieldSelection{"a": {"b": {"c": nil}, "d": {"e": nil}}}, false},
+		{"Whole field wins over nested", "contents,contents/name", fieldSelection{"contents": nil}, false},
+		{"Whitespace is ignored", " path , contents( name )", fieldSelection{"path": nil, "contents": {"name": nil}}, false},
+		{"Empty field name", "path,,size", nil, true},
+		{"Empty path segment", "contents//name", nil, true},
+		{"Unclosed parenthesis", "contents(name", nil, true},
+		{"Unopened parenthesis", "contents)", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseFields(tc.in)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wantErr {
+				t.Fatalf("Expected error but did pass")
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Selection mismatch: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONFields(t *testing.T) {
+	response := model.PathContents{
+		Path: "mock/",
+		Contents: []*model.Metadata{
+			{Name: "mock/file1", Size: 1, Count: 0, StorageClass: "STANDARD"},
+			{Name: "mock/dir/", Size: 2, Count: 1},
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		fields     string
+		want       string
+		wantStatus int
+	}{
+		{
+			"Selects nested fields",
+			"contents(name,size)",
+			`{"contents":[{"name":"mock/file1","size":1},{"name":"mock/dir/","size":2}]}`,
+			http.StatusOK,
+		},
+		{
+			"Selects top level field",
+			"path",
+			`{"path":"mock/"}`,
+			http.StatusOK,
+		},
+		{
+			"Ignores unknown fields",
+			"path,unknown",
+			`{"path":"mock/"}`,
+			http.StatusOK,
+		},
+		{
+			"Rejects malformed selection",
+			"contents(name",
+			"",
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/explore/mock/?fields="+tc.fields, nil)
+			rr := httptest.NewRecorder()
+
+			writeJSON(rr, req, response)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got, want any
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Response mismatch: got %s, want %s", rr.Body.String(), tc.want)
+			}
+		})
+	}
+}
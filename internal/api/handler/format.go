@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type outputFormat string
+
+const (
+	formatJSON outputFormat = "json"
+	formatCSV  outputFormat = "csv"
+	// formatNDJSON streams the rows of a response as one JSON document per line
+	formatNDJSON outputFormat = "ndjson"
+	// formatParquet encodes the rows of a response as a Parquet file with typed columns, such as for pandas or
+	// BigQuery external tables
+	formatParquet outputFormat = "parquet"
+)
+
+var formatContentTypes = map[outputFormat]string{
+	formatJSON:    "application/json",
+	formatCSV:     "text/csv",
+	formatNDJSON:  "application/x-ndjson",
+	formatParquet: "application/vnd.apache.parquet",
+}
+
+// negotiateFormat picks the response format from the format query param, falling back to the Accept header
+// Unknown Accept media types fall back to JSON while an unknown format param is rejected
+func negotiateFormat(r *http.Request) (outputFormat, error) {
+	if param := r.URL.Query().Get("format"); len(param) > 0 {
+		format := outputFormat(strings.ToLower(param))
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q, please use 'json', 'csv', 'ndjson' or 'parquet'", param)
+		}
+		return format, nil
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json":
+			return formatJSON, nil
+		case "text/csv":
+			return formatCSV, nil
+		case "application/x-ndjson":
+			return formatNDJSON, nil
+		case "application/vnd.apache.parquet":
+			return formatParquet, nil
+		}
+	}
+	return formatJSON, nil
+}
+
+// tableColumn is a single exported field of a row struct
+type tableColumn struct {
+	name    string
+	index   int
+	display displayKind
+}
+
+// tableColumns returns the columns of a row struct named after their json tags
+func tableColumns(rowType reflect.Type) []tableColumn {
+	var columns []tableColumn
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		columns = append(columns, tableColumn{name: name, index: i, display: displayKind(field.Tag.Get("display"))})
+	}
+	return columns
+}
+
+// rowElemType returns the struct type of a slice of rows
+func rowElemType(rows any) (reflect.Value, reflect.Type, error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return reflect.Value{}, nil, errors.New("rows must be a slice")
+	}
+
+	elemType := value.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return reflect.Value{}, nil, errors.New("rows must be a slice of structs")
+	}
+	return value, elemType, nil
+}
+
+// tabular rows only know their columns at runtime, such as the results of ad-hoc queries
+type tabular interface {
+	header() []string
+	records() [][]any
+}
+
+// writeCSV renders a slice of flat structs, or tabular rows, as CSV with a header row
+// Values are rendered raw unless display options are given
+func writeCSV(w io.Writer, rows any, display *displayOptions) error {
+	if table, ok := rows.(tabular); ok {
+		return writeTableCSV(w, table, display)
+	}
+
+	value, elemType, err := rowElemType(rows)
+	if err != nil {
+		return err
+	}
+
+	columns := tableColumns(elemType)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for i := 0; i < value.Len(); i++ {
+		row := reflect.Indirect(value.Index(i))
+		for j, c := range columns {
+			record[j] = display.formatCSV(row.Field(c.index), c.display)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeTableCSV(w io.Writer, table tabular, display *displayOptions) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.header()); err != nil {
+		return err
+	}
+
+	for _, values := range table.records() {
+		record := make([]string, len(values))
+		for i, v := range values {
+			// Columns known at runtime have no display tag, their values rendering after their types alone
+			if v != nil {
+				record[i] = display.formatCSV(reflect.ValueOf(v), "")
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatCSVValue(v reflect.Value) string {
+	// Optional values are left empty when unset
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// Parquet column types, as metadata of the Parquet writer
+const (
+	parquetString    = "type=BYTE_ARRAY, convertedtype=UTF8"
+	parquetBool      = "type=BOOLEAN"
+	parquetInt       = "type=INT64"
+	parquetFloat     = "type=DOUBLE"
+	parquetTimestamp = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+)
+
+// parquetWorkers is the number of goroutines the Parquet writer encodes a response with
+const parquetWorkers = 1
+
+// writeParquet renders a slice of flat structs, or tabular rows, as a Parquet file
+// Columns are typed after the fields of structs, or the values of tabular rows, every column being optional
+// Values are raw, display options applying to text formats only
+func writeParquet(w io.Writer, rows any) error {
+	var header, types []string
+	var records [][]any
+	if table, ok := rows.(tabular); ok {
+		header, records = table.header(), table.records()
+		types = make([]string, len(header))
+		for i := range header {
+			types[i] = inferParquetType(records, i)
+		}
+	} else {
+		value, elemType, err := rowElemType(rows)
+		if err != nil {
+			return err
+		}
+
+		columns := tableColumns(elemType)
+		header = make([]string, len(columns))
+		types = make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.name
+			types[i] = parquetType(elemType.Field(c.index).Type)
+		}
+
+		records = make([][]any, value.Len())
+		for i := range records {
+			row := reflect.Indirect(value.Index(i))
+			records[i] = make([]any, len(columns))
+			for j, c := range columns {
+				if field := row.Field(c.index); field.Kind() != reflect.Pointer || !field.IsNil() {
+					records[i][j] = reflect.Indirect(field).Interface()
+				}
+			}
+		}
+	}
+
+	names := parquetNames(header)
+	metadata := make([]string, len(header))
+	for i := range header {
+		metadata[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", names[i], types[i])
+	}
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, parquetWorkers)
+	if err != nil {
+		return err
+	}
+
+	for _, values := range records {
+		record := make([]any, len(values))
+		for i, v := range values {
+			record[i] = parquetValue(types[i], v)
+		}
+		if err := pw.Write(record); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
+
+// parquetType returns the column type of fields of type t, strings holding the values of other types
+func parquetType(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return parquetTimestamp
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return parquetBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parquetInt
+	case reflect.Float32, reflect.Float64:
+		return parquetFloat
+	default:
+		return parquetString
+	}
+}
+
+// inferParquetType returns the column type of column of records, strings holding columns of mixed types
+// Integers mixed with floats are floats, as SQLite stores whole reals as integers
+func inferParquetType(records [][]any, column int) string {
+	var inferred string
+	for _, values := range records {
+		if values[column] == nil {
+			continue
+		}
+
+		switch t := parquetType(reflect.TypeOf(values[column])); {
+		case len(inferred) == 0 || inferred == t:
+			inferred = t
+		case (inferred == parquetInt && t == parquetFloat) || (inferred == parquetFloat && t == parquetInt):
+			inferred = parquetFloat
+		default:
+			return parquetString
+		}
+	}
+	return cmp.Or(inferred, parquetString)
+}
+
+// parquetValue converts v to the value of a column of type t, nil for unset values
+func parquetValue(t string, v any) any {
+	if v == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(v)
+	switch t {
+	case parquetBool:
+		return value.Bool()
+	case parquetInt:
+		if value.CanUint() {
+			return int64(value.Uint())
+		}
+		return value.Int()
+	case parquetFloat:
+		if value.CanFloat() {
+			return value.Float()
+		}
+		return float64(value.Int())
+	case parquetTimestamp:
+		if t := v.(time.Time); !t.IsZero() {
+			return t.UnixMilli()
+		}
+		return nil
+	}
+
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return formatCSVValue(value)
+}
+
+// parquetNames returns column names the Parquet writer accepts, unique regardless of case, as it exports columns
+// as fields
+func parquetNames(header []string) []string {
+	names := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, column := range header {
+		name := strings.Map(func(r rune) rune {
+			if r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				return r
+			}
+			return '_'
+		}, column)
+		if len(name) == 0 {
+			name = "column"
+		}
+
+		for n := 2; seen[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", strings.TrimRight(name, "_0123456789"), n)
+		}
+		seen[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
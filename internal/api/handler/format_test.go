@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		accept  string
+		want    outputFormat
+		wantErr bool
+	}{
+		{"Defaults to JSON", "", "", formatJSON, false},
+		{"Any media type", "", "*/*", formatJSON, false},
+		{"Accept CSV", "", "text/csv", formatCSV, false},
+		{"Accept CSV with parameters", "", "text/csv; charset=utf-8", formatCSV, false},
+		{"First supported media type wins", "", "text/html, text/csv, application/json", formatCSV, false},
+		{"Format param overrides Accept", "?format=json", "text/csv", formatJSON, false},
+		{"Format param is case insensitive", "?format=CSV", "", formatCSV, false},
+		{"Accept NDJSON", "", "application/x-ndjson", formatNDJSON, false},
+		{"Accept Parquet", "", "application/vnd.apache.parquet", formatParquet, false},
+		{"Unsupported format param", "?format=xml", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/explore/"+tc.query, nil)
+			req.Header.Set("Accept", tc.accept)
+
+			got, err := negotiateFormat(req)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wantErr {
+				t.Fatalf("Expected error but did pass")
+			}
+
+			if got != tc.want {
+				t.Errorf("Format mismatch: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteResponseCSV(t *testing.T) {
+	created := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	contents := []*model.Metadata{
+		{Bucket: "mock", Name: "mock/file,1", StorageClass: "STANDARD", Size: 1, Cost: 0.5, Created: created, Updated: created, DetectedType: "image/png"},
+		{Name: "mock/dir/", Size: 2, Count: 1},
+	}
+
+	req := httptest.NewRequest("GET", "/explore/mock/?format=csv", nil)
+	rr := httptest.NewRecorder()
+
+	writeResponse(rr, req, model.PathContents{Path: "mock/", Contents: contents}, contents)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if got := rr.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type mismatch: got %s, want %s", got, "text/csv")
+	}
+
+	want := strings.Join([]string{
+		"bucket,name,parent,storage_class,size,count,cost,created,updated,custom_time,detected_type,marker,noncurrent_size",
+		`mock,"mock/file,1",,STANDARD,1,0,0.5,2024-10-01T12:00:00Z,2024-10-01T12:00:00Z,,image/png,false,0`,
+		",mock/dir/,,,2,1,0,,,,,false,0",
+		"",
+	}, "\n")
+
+	if got := rr.Body.String(); got != want {
+		t.Errorf("CSV mismatch:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// readParquet decodes the rows of a Parquet file as maps of column names to values, nil if unset
+func readParquet(t *testing.T, b []byte) ([]string, []map[string]any) {
+	t.Helper()
+	file, err := buffer.NewBufferFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(file, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	rows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader renames columns after the fields of the rows it decodes
+	var columns []string
+	for _, info := range pr.SchemaHandler.Infos[1:] {
+		columns = append(columns, info.ExName)
+	}
+	records := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		value := reflect.ValueOf(row)
+		records[i] = make(map[string]any, value.NumField())
+		for j := range value.NumField() {
+			if field := value.Field(j); !field.IsNil() {
+				records[i][columns[j]] = field.Elem().Interface()
+			}
+		}
+	}
+	return columns, records
+}
+
+func TestWriteResponseParquet(t *testing.T) {
+	created := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	contents := []*model.Metadata{
+		{Bucket: "mock", Name: "mock/file,1", StorageClass: "STANDARD", Size: 1, Cost: 0.5, Created: created, Updated: created},
+		{Name: "mock/dir/", Size: 2, Count: 1},
+	}
+
+	req := httptest.NewRequest("GET", "/explore/mock/", nil)
+	req.Header.Set("Accept", "application/vnd.apache.parquet")
+	rr := httptest.NewRecorder()
+
+	writeResponse(rr, req, model.PathContents{Path: "mock/", Contents: contents}, contents)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/vnd.apache.parquet" {
+		t.Errorf("Content-Type mismatch: got %s", got)
+	}
+
+	// Columns keep the types of their fields, unset times and pointers being null
+	columns, rows := readParquet(t, rr.Body.Bytes())
+	if want := []string{"bucket", "name", "parent", "storage_class", "size", "count", "cost", "created", "updated", "custom_time", "detected_type", "marker", "noncurrent_size"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("Columns mismatch: got %v, want %v", columns, want)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Rows mismatch: got %d, want 2", len(rows))
+	}
+	if rows[0]["name"] != "mock/file,1" || rows[0]["size"] != int64(1) || rows[0]["cost"] != 0.5 || rows[0]["created"] != created.UnixMilli() || rows[0]["marker"] != false {
+		t.Errorf("Row mismatch: got %v", rows[0])
+	}
+	if _, ok := rows[1]["created"]; ok || rows[1]["custom_time"] != nil || rows[1]["count"] != int64(1) {
+		t.Errorf("Row mismatch: got %v", rows[1])
+	}
+}
+
+func TestWriteParquetTable(t *testing.T) {
+	result := &model.QueryResult{
+		Columns: []string{"bucket", "COUNT(*)", "SUM(size)", "count(*)"},
+		Rows: []map[string]any{
+			{"bucket": "mock", "COUNT(*)": int64(2), "SUM(size)": int64(3), "count(*)": "mixed"},
+			{"bucket": []byte("other"), "COUNT(*)": int64(1), "SUM(size)": 1.5, "count(*)": int64(1)},
+		},
+	}
+
+	var b strings.Builder
+	if err := writeParquet(&b, queryRows{result}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Columns are typed after their values, integers mixed with reals being reals and other mixes strings
+	columns, rows := readParquet(t, []byte(b.String()))
+	if want := []string{"bucket", "COUNT___", "SUM_size_", "count_2"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("Columns mismatch: got %v, want %v", columns, want)
+	}
+	want := []map[string]any{
+		{"bucket": "mock", "COUNT___": int64(2), "SUM_size_": 3.0, "count_2": "mixed"},
+		{"bucket": "other", "COUNT___": int64(1), "SUM_size_": 1.5, "count_2": "1"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Rows mismatch: got %v, want %v", rows, want)
+	}
+}
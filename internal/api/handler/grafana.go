@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Grafana targets name a directory metric as metric:directory, e.g. size:logs/
+const (
+	grafanaMetricSize  = "size"
+	grafanaMetricCount = "count"
+)
+
+// maxGrafanaAnnotations bounds the changes annotated per query to the largest ones
+const maxGrafanaAnnotations = 10
+
+type grafanaHandler struct {
+	exploreRepo repo.ExploreRepository
+	historyRepo repo.HistoryRepository
+}
+
+func NewGrafanaHandler(exploreRepo repo.ExploreRepository, historyRepo repo.HistoryRepository) *grafanaHandler {
+	return &grafanaHandler{exploreRepo, historyRepo}
+}
+
+// HandleTest answers the datasource connection test
+func (g *grafanaHandler) HandleTest(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleSearch lists the size and count targets of the child directories of the searched directory
+func (g *grafanaHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	var req model.GrafanaSearchRequest
+	if !decodeGrafanaRequest(w, r, &req) {
+		return
+	}
+
+	prefix := req.Target
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	contents, err := g.exploreRepo.GetPathContents(r.Context(), prefix, repo.SortBySize, repo.ListOptions{})
+	if err != nil {
+		writeError(w, "searching grafana targets", err)
+		return
+	}
+
+	targets := []string{}
+	for _, m := range contents {
+		if m.Name == prefix || !strings.HasSuffix(m.Name, "/") {
+			continue // only directories hold history
+		}
+		targets = append(targets, grafanaMetricSize+":"+m.Name, grafanaMetricCount+":"+m.Name)
+	}
+
+	writeJSON(w, r, targets)
+}
+
+// HandleQuery returns the size or count of every target directory over the queried range
+func (g *grafanaHandler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var req model.GrafanaQueryRequest
+	if !decodeGrafanaRequest(w, r, &req) {
+		return
+	}
+
+	series := []model.GrafanaTimeSeries{}
+	for _, target := range req.Targets {
+		metric, dir, ok := strings.Cut(target.Target, ":")
+		if !ok || (metric != grafanaMetricSize && metric != grafanaMetricCount) {
+			http.Error(w, fmt.Sprintf("Invalid target %q, please use size:<directory> or count:<directory>", target.Target), http.StatusBadRequest)
+			return
+		}
+
+		points, err := g.historyRepo.GetSeries(r.Context(), dir, req.Range.From, req.Range.To)
+		if err != nil {
+			writeError(w, "querying grafana series", err)
+			return
+		}
+
+		// Extend the last totals to the end of the range so the series covers it entirely
+		points = append(points, &model.DirectoryPoint{
+			Time:  req.Range.To,
+			Size:  points[len(points)-1].Size,
+			Count: points[len(points)-1].Count,
+		})
+
+		datapoints := make([][2]float64, len(points))
+		for i, p := range points {
+			value := p.Size
+			if metric == grafanaMetricCount {
+				value = p.Count
+			}
+			datapoints[i] = [2]float64{float64(value), float64(p.Time.UnixMilli())}
+		}
+
+		series = append(series, model.GrafanaTimeSeries{Target: target.Target, Datapoints: datapoints})
+	}
+
+	writeJSON(w, r, series)
+}
+
+// HandleAnnotations annotates the largest hourly size changes of the directory named by the annotation query
+func (g *grafanaHandler) HandleAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req model.GrafanaAnnotationRequest
+	if !decodeGrafanaRequest(w, r, &req) {
+		return
+	}
+
+	dir := req.Annotation.Query
+	if !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+
+	points, err := g.historyRepo.GetSeries(r.Context(), dir, req.Range.From, req.Range.To)
+	if err != nil {
+		writeError(w, "querying grafana annotations", err)
+		return
+	}
+
+	changes := points[1:] // the first point holds the totals at the start of the range
+	sort.SliceStable(changes, func(i, j int) bool {
+		return abs(changes[i].SizeDelta) > abs(changes[j].SizeDelta)
+	})
+	if len(changes) > maxGrafanaAnnotations {
+		changes = changes[:maxGrafanaAnnotations]
+	}
+
+	annotations := []model.GrafanaAnnotation{}
+	for _, p := range changes {
+		if p.SizeDelta == 0 {
+			continue
+		}
+		annotations = append(annotations, model.GrafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       p.Time.UnixMilli(),
+			Title:      fmt.Sprintf("%s changed by %+d bytes", dir, p.SizeDelta),
+			Text:       fmt.Sprintf("%+d objects, %d bytes in %d objects after the change", p.CountDelta, p.Size, p.Count),
+			Tags:       []string{dir},
+		})
+	}
+
+	writeJSON(w, r, annotations)
+}
+
+// decodeGrafanaRequest decodes a JSON request body, responding with an error if it is invalid
+func decodeGrafanaRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+var mockGrafanaFrom = time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+var mockSeries = []*model.DirectoryPoint{
+	{Time: mockGrafanaFrom, Size: 100, Count: 1},
+	{Time: mockGrafanaFrom.Add(time.Hour), Size: 150, Count: 2, SizeDelta: 50, CountDelta: 1},
+	{Time: mockGrafanaFrom.Add(2 * time.Hour), Size: 50, Count: 1, SizeDelta: -100, CountDelta: -1},
+}
+
+func TestHandleGrafanaSearch(t *testing.T) {
+	mockRepo := &mockExploreRepository{pathContents: []*model.Metadata{
+		{Name: "logs/"},
+		{Name: "logs/2024/"},
+		{Name: "logs/file"},
+	}}
+
+	req := httptest.NewRequest("POST", "/grafana/search", strings.NewReader(`{"target": "logs"}`))
+	rr := httptest.NewRecorder()
+
+	handler := NewGrafanaHandler(mockRepo, &mockHistoryRepository{})
+	handler.HandleSearch(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var got []string
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"size:logs/2024/", "count:logs/2024/"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("targets mismatch: got %v want %v", got, want)
+	}
+}
+
+func TestHandleGrafanaQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantValues []float64
+	}{
+		{"Size series", `{"range": {"from": "2024-10-01T00:00:00Z", "to": "2024-10-01T06:00:00Z"}, "targets": [{"target": "size:logs/", "refId": "A"}]}`, http.StatusOK, []float64{100, 150, 50, 50}},
+		{"Count series", `{"range": {"from": "2024-10-01T00:00:00Z", "to": "2024-10-01T06:00:00Z"}, "targets": [{"target": "count:logs/", "refId": "A"}]}`, http.StatusOK, []float64{1, 2, 1, 1}},
+		{"Invalid metric", `{"range": {"from": "2024-10-01T00:00:00Z", "to": "2024-10-01T06:00:00Z"}, "targets": [{"target": "mock:logs/", "refId": "A"}]}`, http.StatusBadRequest, nil},
+		{"Invalid body", `mock`, http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/grafana/query", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			mockRepo := &mockHistoryRepository{series: mockSeries}
+
+			handler := NewGrafanaHandler(&mockExploreRepository{}, mockRepo)
+			handler.HandleQuery(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if mockRepo.prefix != "logs/" {
+				t.Errorf("directory mismatch: got %s want %s", mockRepo.prefix, "logs/")
+			}
+
+			var got []model.GrafanaTimeSeries
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 1 || len(got[0].Datapoints) != len(tc.wantValues) {
+				t.Fatalf("series mismatch: got %+v", got)
+			}
+
+			for i, want := range tc.wantValues {
+				if got[0].Datapoints[i][0] != want {
+					t.Errorf("datapoint %d mismatch: got %v want %v", i, got[0].Datapoints[i][0], want)
+				}
+			}
+
+			// The last datapoint extends the series to the end of the range
+			if last := got[0].Datapoints[len(tc.wantValues)-1][1]; last != float64(mockGrafanaFrom.Add(6*time.Hour).UnixMilli()) {
+				t.Errorf("last datapoint time mismatch: got %v", last)
+			}
+		})
+	}
+}
+
+func TestHandleGrafanaAnnotations(t *testing.T) {
+	body := `{"range": {"from": "2024-10-01T00:00:00Z", "to": "2024-10-01T06:00:00Z"}, "annotation": {"name": "changes", "enable": true, "query": "logs"}}`
+	req := httptest.NewRequest("POST", "/grafana/annotations", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	handler := NewGrafanaHandler(&mockExploreRepository{}, &mockHistoryRepository{series: mockSeries})
+	handler.HandleAnnotations(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", status, http.StatusOK)
+	}
+
+	var got []model.GrafanaAnnotation
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	// Annotations are ordered from the largest change
+	if len(got) != 2 || got[0].Time != mockGrafanaFrom.Add(2*time.Hour).UnixMilli() || got[0].Annotation.Name != "changes" {
+		t.Errorf("annotations mismatch: got %+v", got)
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type historyHandler struct {
+	historyRepo repo.HistoryRepository
+}
+
+func NewHistoryHandler(historyRepo repo.HistoryRepository) *historyHandler {
+	return &historyHandler{historyRepo}
+}
+
+// HandleDiff lists how much every child directory of a prefix grew or shrank between two points in time
+func (h *historyHandler) HandleDiff(w http.ResponseWriter, r *http.Request) {
+	// Normalize prefix query param by adding slash(/) suffix if missing
+	prefix := r.URL.Query().Get("prefix")
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from parameter, please use an RFC 3339 timestamp", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	if toString := r.URL.Query().Get("to"); len(toString) > 0 {
+		if to, err = time.Parse(time.RFC3339, toString); err != nil {
+			http.Error(w, "Invalid to parameter, please use an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "Invalid time range, from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	deltas, err := h.historyRepo.GetDiff(r.Context(), prefix, from, to)
+	if err != nil {
+		writeError(w, "retrieving directory diff", err)
+		return
+	}
+
+	response := model.DirectoryDiff{
+		Prefix:      r.URL.Query().Get("prefix"),
+		From:        from,
+		To:          to,
+		Directories: deltas,
+	}
+
+	writeResponse(w, r, response, deltas)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestHandleDiff(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantPrefix string
+	}{
+		{"Root prefix", "?from=2024-10-01T00:00:00Z&to=2024-10-08T00:00:00Z", http.StatusOK, "/"},
+		{"Normalizes prefix", "?prefix=mock&from=2024-10-01T00:00:00Z", http.StatusOK, "mock/"},
+		{"Missing from", "?prefix=mock/", http.StatusBadRequest, ""},
+		{"Invalid to", "?from=2024-10-01T00:00:00Z&to=mock", http.StatusBadRequest, ""},
+		{"From after to", "?from=2024-10-08T00:00:00Z&to=2024-10-01T00:00:00Z", http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/diff"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockHistoryRepository{}
+
+			handler := NewHistoryHandler(mockRepo)
+			handler.HandleDiff(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if mockRepo.prefix != tc.wantPrefix {
+				t.Errorf("prefix mismatch: got %s want %s", mockRepo.prefix, tc.wantPrefix)
+			}
+
+			var got model.DirectoryDiff
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Directories) != 1 || !got.From.Equal(mockRepo.from) || !got.To.Equal(mockRepo.to) {
+				t.Errorf("response mismatch: got %+v", got)
+			}
+		})
+	}
+}
+
+type mockHistoryRepository struct {
+	prefix   string
+	from, to time.Time
+	series   []*model.DirectoryPoint
+}
+
+func (m *mockHistoryRepository) GetDiff(ctx context.Context, prefix string, from time.Time, to time.Time) ([]*model.DirectoryDelta, error) {
+	m.prefix, m.from, m.to = prefix, from, to
+	return []*model.DirectoryDelta{{Name: "mock/a/", SizeDelta: 2 << 40, CountDelta: 10}}, nil
+}
+
+func (m *mockHistoryRepository) GetStale(ctx context.Context, prefix string, since time.Time) ([]*model.StaleDirectory, error) {
+	return []*model.StaleDirectory{}, nil
+}
+
+func (m *mockHistoryRepository) GetSeries(ctx context.Context, name string, from time.Time, to time.Time) ([]*model.DirectoryPoint, error) {
+	m.prefix = name
+	return m.series, nil
+}
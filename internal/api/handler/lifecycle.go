@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type lifecycleHandler struct {
+	lifecycleRepo repo.LifecycleRepository
+}
+
+func NewLifecycleHandler(lifecycleRepo repo.LifecycleRepository) *lifecycleHandler {
+	return &lifecycleHandler{lifecycleRepo}
+}
+
+func (l *lifecycleHandler) HandleSimulate(w http.ResponseWriter, r *http.Request) {
+	// Lifecycle policies apply to the objects of a single bucket
+	bucket := r.URL.Query().Get("bucket")
+	if len(bucket) == 0 {
+		http.Error(w, "Missing bucket parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Normalize prefix query param by adding slash(/) suffix if missing
+	prefix := r.URL.Query().Get("prefix")
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	// Accept both a bare policy and the bucket resource form {"lifecycle": {...}}
+	var body struct {
+		Lifecycle *model.LifecyclePolicy `json:"lifecycle"`
+		Rules     []model.LifecycleRule  `json:"rule"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		http.Error(w, "Invalid lifecycle policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy := &model.LifecyclePolicy{Rules: body.Rules}
+	if body.Lifecycle != nil {
+		policy = body.Lifecycle
+	}
+
+	if err := repo.ValidateLifecyclePolicy(policy); err != nil {
+		http.Error(w, "Invalid lifecycle policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	simulations, err := l.lifecycleRepo.SimulateLifecycle(r.Context(), bucket, prefix, policy, time.Now())
+	if err != nil {
+		writeError(w, "simulating lifecycle policy", err)
+		return
+	}
+
+	response := model.LifecycleSimulationResult{
+		Bucket:   bucket,
+		Prefix:   r.URL.Query().Get("prefix"),
+		Prefixes: simulations,
+	}
+
+	writeResponse(w, r, response, lifecycleRows(simulations))
+}
+
+// HandleAgeHistogram counts the objects under a prefix per age of their custom time, creation or last update
+func (l *lifecycleHandler) HandleAgeHistogram(w http.ResponseWriter, r *http.Request) {
+	bucket := r.URL.Query().Get("bucket")
+	if len(bucket) == 0 {
+		http.Error(w, "Missing bucket parameter", http.StatusBadRequest)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	by := repo.AgeField(strings.ToLower(r.URL.Query().Get("by")))
+	if len(by) == 0 {
+		by = repo.AgeByCustomTime
+	} else if by != repo.AgeByCustomTime && by != repo.AgeByCreated && by != repo.AgeByUpdated {
+		http.Error(w, "Invalid by parameter, please use 'custom_time', 'created' or 'updated'", http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := l.lifecycleRepo.GetAgeHistogram(r.Context(), bucket, prefix, by, time.Now())
+	if err != nil {
+		writeError(w, "retrieving age histogram", err)
+		return
+	}
+
+	response := model.AgeHistogram{
+		Bucket:  bucket,
+		Prefix:  r.URL.Query().Get("prefix"),
+		By:      string(by),
+		Buckets: buckets,
+	}
+
+	writeResponse(w, r, response, buckets)
+}
+
+// HandleRecommendations recommends a lifecycle rule per child prefix of a prefix, moving objects old enough
+// and not read recently to a colder storage class, with the monthly storage cost it saves
+func (l *lifecycleHandler) HandleRecommendations(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	response, err := l.lifecycleRepo.RecommendLifecycle(r.Context(), prefix, time.Now())
+	if err != nil {
+		writeError(w, "recommending lifecycle rules", err)
+		return
+	}
+	response.Prefix = r.URL.Query().Get("prefix")
+
+	writeResponse(w, r, response, recommendationRows(response.Recommendations))
+}
+
+// recommendationRow is the tabular form of a recommendation
+type recommendationRow struct {
+	Prefix          string  `json:"prefix"`
+	StorageClass    string  `json:"storage_class"`
+	Age             int64   `json:"age"`
+	Count           int64   `json:"count"`
+	Size            int64   `json:"size" display:"bytes"`
+	RecentlyRead    int64   `json:"recently_read"`
+	CurrentCost     float64 `json:"current_cost"`
+	RecommendedCost float64 `json:"recommended_cost"`
+	MonthlySavings  float64 `json:"monthly_savings"`
+}
+
+func recommendationRows(recommendations []*model.LifecycleRecommendation) []recommendationRow {
+	rows := make([]recommendationRow, len(recommendations))
+	for i, rec := range recommendations {
+		rows[i] = recommendationRow{
+			Prefix:          rec.Prefix,
+			StorageClass:    rec.Rule.Action.StorageClass,
+			Count:           rec.Count,
+			Size:            rec.Size,
+			RecentlyRead:    rec.RecentlyRead,
+			CurrentCost:     rec.CurrentCost,
+			RecommendedCost: rec.RecommendedCost,
+			MonthlySavings:  rec.MonthlySavings,
+		}
+		if rec.Rule.Condition.Age != nil {
+			rows[i].Age = *rec.Rule.Condition.Age
+		}
+	}
+	return rows
+}
+
+// lifecycleRow is the tabular form of a simulation with one row per prefix and action
+type lifecycleRow struct {
+	Prefix       string `json:"prefix"`
+	Action       string `json:"action"`
+	StorageClass string `json:"storage_class"`
+	Count        int64  `json:"count"`
+	Size         int64  `json:"size" display:"bytes"`
+}
+
+func lifecycleRows(simulations []*model.LifecycleSimulation) []lifecycleRow {
+	var rows []lifecycleRow
+	for _, sim := range simulations {
+		if sim.Delete.Count > 0 {
+			rows = append(rows, lifecycleRow{sim.Prefix, repo.LifecycleDelete, "", sim.Delete.Count, sim.Delete.Size})
+		}
+
+		classes := make([]string, 0, len(sim.Transitions))
+		for class := range sim.Transitions {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		for _, class := range classes {
+			impact := sim.Transitions[class]
+			rows = append(rows, lifecycleRow{sim.Prefix, repo.LifecycleSetStorageClass, class, impact.Count, impact.Size})
+		}
+	}
+	return rows
+}
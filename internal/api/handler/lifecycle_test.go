@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestHandleSimulate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		bucket     string
+		prefix     string
+		body       string
+		wantStatus int
+	}{
+		{
+			"Valid policy",
+			"mock",
+			"logs/",
+			`{"rule": [{"action": {"type": "Delete"}, "condition": {"age": 30}}]}`,
+			http.StatusOK,
+		},
+		{
+			"Valid bucket lifecycle resource",
+			"mock",
+			"",
+			`{"lifecycle": {"rule": [{"action": {"type": "SetStorageClass", "storageClass": "NEARLINE"}, "condition": {"age": 30}}]}}`,
+			http.StatusOK,
+		},
+		{
+			"Missing bucket",
+			"",
+			"logs/",
+			`{"rule": [{"action": {"type": "Delete"}, "condition": {"age": 30}}]}`,
+			http.StatusBadRequest,
+		},
+		{
+			"Malformed JSON",
+			"mock",
+			"",
+			`{"rule": [`,
+			http.StatusBadRequest,
+		},
+		{
+			"Unsupported condition",
+			"mock",
+			"",
+			`{"rule": [{"action": {"type": "Delete"}, "condition": {"numNewerVersions": 3}}]}`,
+			http.StatusBadRequest,
+		},
+		{
+			"Unsupported action",
+			"mock",
+			"",
+			`{"rule": [{"action": {"type": "AbortIncompleteMultipartUpload"}, "condition": {"age": 1}}]}`,
+			http.StatusBadRequest,
+		},
+		{
+			"Empty policy",
+			"mock",
+			"",
+			`{}`,
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/simulate/lifecycle?bucket="+tc.bucket+"&prefix="+tc.prefix, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			mockRepo := &mockLifecycleRepository{}
+
+			handler := NewLifecycleHandler(mockRepo)
+			handler.HandleSimulate(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v",
+					status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if mockRepo.bucket != tc.bucket {
+				t.Errorf("bucket mismatch: got %s want %s", mockRepo.bucket, tc.bucket)
+			}
+			if mockRepo.prefix != tc.prefix && mockRepo.prefix != "/" {
+				t.Errorf("prefix mismatch: got %s want %s", mockRepo.prefix, tc.prefix)
+			}
+		})
+	}
+}
+
+func TestHandleAgeHistogram(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantBy     repo.AgeField
+		wantStatus int
+	}{
+		{"Defaults to custom time", "?bucket=mock&prefix=logs", repo.AgeByCustomTime, http.StatusOK},
+		{"Age by creation", "?bucket=mock&by=created", repo.AgeByCreated, http.StatusOK},
+		{"By is case insensitive", "?bucket=mock&by=UPDATED", repo.AgeByUpdated, http.StatusOK},
+		{"Unsupported field", "?bucket=mock&by=deleted", "", http.StatusBadRequest},
+		{"Missing bucket", "?by=created", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stats/age"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockLifecycleRepository{}
+
+			NewLifecycleHandler(mockRepo).HandleAgeHistogram(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if mockRepo.by != tc.wantBy {
+				t.Errorf("by mismatch: got %s want %s", mockRepo.by, tc.wantBy)
+			}
+			if tc.wantStatus == http.StatusOK && mockRepo.bucket != "mock" {
+				t.Errorf("bucket mismatch: got %s want %s", mockRepo.bucket, "mock")
+			}
+		})
+	}
+}
+
+func TestHandleRecommendations(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantPrefix string
+		wantBody   string
+	}{
+		{"Normalizes prefix", "?prefix=logs", "logs/", ""},
+		{"Root prefix", "", "/", ""},
+		{"Renders CSV", "?format=csv", "/", "prefix,storage_class,age,count,size,recently_read,current_cost,recommended_cost,monthly_savings\nlogs/,COLDLINE,90,2,1073741824,1,0.023,0.007,0.016\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/recommendations"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockLifecycleRepository{}
+
+			NewLifecycleHandler(mockRepo).HandleRecommendations(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			if mockRepo.prefix != tc.wantPrefix {
+				t.Errorf("prefix mismatch: got %s want %s", mockRepo.prefix, tc.wantPrefix)
+			}
+
+			if len(tc.wantBody) > 0 && rr.Body.String() != tc.wantBody {
+				t.Errorf("body mismatch: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type mockLifecycleRepository struct {
+	bucket string
+	prefix string
+	by     repo.AgeField
+}
+
+func (m *mockLifecycleRepository) SimulateLifecycle(ctx context.Context, bucket, prefix string, policy *model.LifecyclePolicy, now time.Time) ([]*model.LifecycleSimulation, error) {
+	m.bucket = bucket
+	m.prefix = prefix
+	return []*model.LifecycleSimulation{}, nil
+}
+
+func (m *mockLifecycleRepository) GetAgeHistogram(ctx context.Context, bucket, prefix string, by repo.AgeField, now time.Time) ([]*model.AgeBucket, error) {
+	m.bucket = bucket
+	m.prefix = prefix
+	m.by = by
+	return []*model.AgeBucket{}, nil
+}
+
+func (m *mockLifecycleRepository) RecommendLifecycle(ctx context.Context, prefix string, now time.Time) (*model.LifecycleRecommendations, error) {
+	m.prefix = prefix
+	age := int64(90)
+	return &model.LifecycleRecommendations{Recommendations: []*model.LifecycleRecommendation{{
+		Prefix:          "logs/",
+		Rule:            model.LifecycleRule{Action: model.LifecycleAction{Type: repo.LifecycleSetStorageClass, StorageClass: "COLDLINE"}, Condition: model.LifecycleCondition{Age: &age}},
+		Count:           2,
+		Size:            1073741824,
+		RecentlyRead:    1,
+		CurrentCost:     0.023,
+		RecommendedCost: 0.007,
+		MonthlySavings:  0.016,
+	}}}, nil
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// MaxStatPaths caps the paths of a single stat request
+const MaxStatPaths = 1000
+
+// sourceHeader tells whether an object was answered from the index or looked up in GCS
+const sourceHeader = "X-Metadata-Source"
+
+// ObjectFetcher looks objects missing from the index up in GCS, returning repo.ErrNotFound if they don't exist
+type ObjectFetcher interface {
+	Fetch(ctx context.Context, bucket, name string) (*model.Metadata, error)
+}
+
+type objectHandler struct {
+	metadataRepo repo.MetadataRepository
+	fetcher      ObjectFetcher
+}
+
+// NewObjectHandler answers object lookups from metadataRepo, falling back to fetcher if not nil
+func NewObjectHandler(metadataRepo repo.MetadataRepository, fetcher ObjectFetcher) *objectHandler {
+	return &objectHandler{metadataRepo, fetcher}
+}
+
+// HandleGetObject returns the metadata of a single object
+func (o *objectHandler) HandleGetObject(w http.ResponseWriter, r *http.Request) {
+	bucket, name := r.PathValue("bucket"), r.PathValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Missing object name", http.StatusBadRequest)
+		return
+	}
+
+	source := "index"
+	obj, err := o.metadataRepo.Get(r.Context(), bucket, name)
+	if errors.Is(err, repo.ErrNotFound) && o.fetcher != nil {
+		// Notifications of new objects may not have been indexed yet
+		source = "gcs"
+		obj, err = o.fetcher.Fetch(r.Context(), bucket, name)
+	}
+	if err != nil {
+		writeError(w, "retrieving object", err)
+		return
+	}
+
+	w.Header().Set(sourceHeader, source)
+	writeResponse(w, r, obj, []*model.Metadata{obj})
+}
+
+// HandleStat returns the metadata of many objects at once, each path naming an object as bucket/name
+// Objects are looked up with one statement per bucket, and missing ones in GCS one by one when the fallback is enabled
+func (o *objectHandler) HandleStat(w http.ResponseWriter, r *http.Request) {
+	var req model.StatRequest
+
+	// Paths are at most 1024 bytes of object name, their bucket and JSON quoting
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxStatPaths*1100))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid stat request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 || len(req.Paths) > MaxStatPaths {
+		http.Error(w, fmt.Sprintf("Invalid paths, please request between 1 and %d paths", MaxStatPaths), http.StatusBadRequest)
+		return
+	}
+
+	// Group names per bucket, rejecting paths without both
+	names := make(map[string][]string)
+	for _, path := range req.Paths {
+		bucket, name, ok := strings.Cut(path, "/")
+		if !ok || len(bucket) == 0 || len(name) == 0 {
+			http.Error(w, fmt.Sprintf("Invalid path %q, please use bucket/name", path), http.StatusBadRequest)
+			return
+		}
+		names[bucket] = append(names[bucket], name)
+	}
+
+	found := make(map[string]*model.Metadata, len(req.Paths))
+	for bucket, bucketNames := range names {
+		objects, err := o.metadataRepo.GetMany(r.Context(), bucket, bucketNames)
+		if err != nil {
+			writeError(w, "retrieving objects", err)
+			return
+		}
+		for _, obj := range objects {
+			found[obj.Bucket+"/"+obj.Name] = obj
+		}
+	}
+
+	// Paths requested twice are looked up in GCS once
+	fetched := make(map[string]bool)
+	response := model.StatResults{Results: make([]model.StatResult, len(req.Paths))}
+	for i, path := range req.Paths {
+		obj, ok := found[path]
+		if !ok && o.fetcher != nil && !fetched[path] {
+			fetched[path] = true
+			bucket, name, _ := strings.Cut(path, "/")
+
+			var err error
+			obj, err = o.fetcher.Fetch(r.Context(), bucket, name)
+			if err != nil && !errors.Is(err, repo.ErrNotFound) {
+				writeError(w, "retrieving object", err)
+				return
+			}
+			if obj != nil {
+				found[path], ok = obj, true
+			}
+		}
+		response.Results[i] = model.StatResult{Path: path, Found: ok, Object: obj}
+	}
+
+	writeResponse(w, r, response, statRows(response.Results))
+}
+
+// statRow is the tabular form of a stat result, the metadata of missing objects left empty
+type statRow struct {
+	Path         string    `json:"path"`
+	Found        bool      `json:"found"`
+	Size         int64     `json:"size" display:"bytes"`
+	StorageClass string    `json:"storage_class"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+}
+
+func statRows(results []model.StatResult) []statRow {
+	rows := make([]statRow, len(results))
+	for i, result := range results {
+		rows[i] = statRow{Path: result.Path, Found: result.Found}
+		if obj := result.Object; obj != nil {
+			rows[i].Size, rows[i].StorageClass = obj.Size, obj.StorageClass
+			rows[i].Created, rows[i].Updated = obj.Created, obj.Updated
+		}
+	}
+	return rows
+}
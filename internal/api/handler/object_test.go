@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestHandleGetObject(t *testing.T) {
+	testCases := []struct {
+		name       string
+		object     string
+		fallback   bool
+		wantCode   int
+		wantSource string
+	}{
+		{"Indexed object", "indexed", false, http.StatusOK, "index"},
+		{"Missing object", "new", false, http.StatusNotFound, ""},
+		{"Missing object found in GCS", "new", true, http.StatusOK, "gcs"},
+		{"Missing object missing from GCS", "gone", true, http.StatusNotFound, ""},
+		{"Missing name", "", false, http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/objects/mock/"+tc.object, nil)
+			req.SetPathValue("bucket", "mock")
+			req.SetPathValue("name", tc.object)
+			rr := httptest.NewRecorder()
+
+			var fetcher ObjectFetcher
+			if tc.fallback {
+				fetcher = &mockObjectFetcher{}
+			}
+
+			handler := NewObjectHandler(&mockMetadataRepository{}, fetcher)
+			handler.HandleGetObject(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, tc.wantCode)
+			}
+			if got := rr.Header().Get(sourceHeader); got != tc.wantSource {
+				t.Errorf("source mismatch: got %q want %q", got, tc.wantSource)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var got model.Metadata
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Bucket != "mock" || got.Name != tc.object {
+				t.Errorf("object mismatch: got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandleStat(t *testing.T) {
+	testCases := []struct {
+		name      string
+		body      string
+		fallback  bool
+		wantCode  int
+		wantFound string
+	}{
+		{"Marks missing objects", `{"paths": ["mock/indexed", "mock/new", "other/indexed"]}`, false, http.StatusOK, "true,false,true"},
+		{"Looks missing objects up in GCS", `{"paths": ["mock/new", "mock/gone", "mock/new", "mock/gone"]}`, true, http.StatusOK, "true,false,true,false"},
+		{"Rejects paths without name", `{"paths": ["mock"]}`, false, http.StatusBadRequest, ""},
+		{"Rejects empty requests", `{"paths": []}`, false, http.StatusBadRequest, ""},
+		{"Rejects too many paths", `{"paths": [` + strings.Repeat(`"mock/a",`, MaxStatPaths) + `"mock/a"]}`, false, http.StatusBadRequest, ""},
+		{"Rejects unknown fields", `{"names": ["mock/a"]}`, false, http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/stat", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			fetcher := &mockObjectFetcher{}
+			handler := NewObjectHandler(&mockMetadataRepository{}, nil)
+			if tc.fallback {
+				handler = NewObjectHandler(&mockMetadataRepository{}, fetcher)
+			}
+			handler.HandleStat(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("status code mismatch: got %v want %v: %s", rr.Code, tc.wantCode, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var got model.StatResults
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			var found []string
+			for _, result := range got.Results {
+				found = append(found, strconv.FormatBool(result.Found))
+				if result.Found != (result.Object != nil) {
+					t.Errorf("result %s mismatch: found %v with object %v", result.Path, result.Found, result.Object)
+				}
+			}
+			if strings.Join(found, ",") != tc.wantFound {
+				t.Errorf("found mismatch: got %v want %s", found, tc.wantFound)
+			}
+			if tc.fallback && fetcher.fetched != 2 {
+				t.Errorf("fetches mismatch: got %d want 2", fetcher.fetched)
+			}
+		})
+	}
+}
+
+// mockMetadataRepository indexes a single object, named indexed
+type mockMetadataRepository struct {
+	repo.MetadataRepository
+}
+
+func (m *mockMetadataRepository) Get(ctx context.Context, bucket, name string) (*model.Metadata, error) {
+	if name != "indexed" {
+		return nil, repo.ErrNotFound
+	}
+	return &model.Metadata{Bucket: bucket, Name: name, Size: 1}, nil
+}
+
+func (m *mockMetadataRepository) GetMany(ctx context.Context, bucket string, names []string) ([]*model.Metadata, error) {
+	objects := []*model.Metadata{}
+	for _, name := range names {
+		if obj, err := m.Get(ctx, bucket, name); err == nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// mockObjectFetcher finds every object in GCS but those named gone
+type mockObjectFetcher struct {
+	fetched int
+}
+
+func (m *mockObjectFetcher) Fetch(ctx context.Context, bucket, name string) (*model.Metadata, error) {
+	m.fetched++
+	if name == "gone" {
+		return nil, repo.ErrNotFound
+	}
+	return &model.Metadata{Bucket: bucket, Name: name, Size: 2}, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type popularityHandler struct {
+	popularityRepo repo.PopularityRepository
+}
+
+func NewPopularityHandler(popularityRepo repo.PopularityRepository) *popularityHandler {
+	return &popularityHandler{popularityRepo}
+}
+
+// HandleColdReport lists the objects and directories under a path not read since a date, or never read,
+// largest first
+func (p *popularityHandler) HandleColdReport(w http.ResponseWriter, r *http.Request) {
+	// Normalize path param by adding slash(/) suffix if missing
+	path := r.PathValue("path")
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+
+	var since time.Time
+	if sinceString := r.URL.Query().Get("since"); len(sinceString) > 0 {
+		var err error
+		if since, err = time.Parse(time.RFC3339, sinceString); err != nil {
+			if since, err = time.Parse(time.DateOnly, sinceString); err != nil {
+				http.Error(w, "Invalid since parameter, please use an RFC 3339 timestamp or a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	limit := defaultPageSize
+	if limitString := r.URL.Query().Get("limit"); len(limitString) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > repo.MaxColdEntries {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter, please use a number between 1 and %d", repo.MaxColdEntries), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := p.popularityRepo.GetColdReport(r.Context(), path, since, limit)
+	if err != nil {
+		writeError(w, "retrieving cold data report", err)
+		return
+	}
+
+	response := model.ColdReport{
+		Path:    r.PathValue("path"),
+		Entries: entries,
+	}
+	if !since.IsZero() {
+		response.Since = &since
+	}
+
+	writeResponse(w, r, response, coldRows(entries))
+}
+
+// coldRow is the tabular form of a cold data report, the last read left empty if never read
+type coldRow struct {
+	Bucket    string `json:"bucket"`
+	Name      string `json:"name"`
+	Directory bool   `json:"directory"`
+	Size      int64  `json:"size" display:"bytes"`
+	Count     int64  `json:"count"`
+	Reads     int64  `json:"reads"`
+	LastRead  string `json:"last_read" display:"timestamp"`
+}
+
+func coldRows(entries []*model.ColdEntry) []coldRow {
+	rows := make([]coldRow, len(entries))
+	for i, e := range entries {
+		rows[i] = coldRow{Bucket: e.Bucket, Name: e.Name, Directory: e.Directory, Size: e.Size, Count: e.Count, Reads: e.Reads}
+		if e.LastRead != nil {
+			rows[i].LastRead = e.LastRead.UTC().Format(time.RFC3339)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestHandleColdReport(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       string
+		query      string
+		wantStatus int
+		wantPath   string
+		wantSince  time.Time
+		wantLimit  int
+		wantBody   string
+	}{
+		{"Never read", "mock", "", http.StatusOK, "mock/", time.Time{}, defaultPageSize, ""},
+		{"Since date", "", "?since=2024-10-01&limit=5", http.StatusOK, "/", time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), 5, ""},
+		{"Since timestamp", "mock/", "?since=2024-10-01T12:00:00Z", http.StatusOK, "mock/", time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC), defaultPageSize, ""},
+		{"Renders CSV", "mock/", "?format=csv", http.StatusOK, "mock/", time.Time{}, defaultPageSize,
+			"bucket,name,directory,size,count,reads,last_read\nmock,a/,true,30,3,0,\nmock,b,false,10,1,2,2024-09-01T00:00:00Z\n"},
+		{"Invalid since", "mock/", "?since=yesterday", http.StatusBadRequest, "", time.Time{}, 0, ""},
+		{"Invalid limit", "mock/", "?limit=100000", http.StatusBadRequest, "", time.Time{}, 0, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/reports/cold/"+tc.path+tc.query, nil)
+			req.SetPathValue("path", tc.path)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockPopularityRepository{}
+
+			NewPopularityHandler(mockRepo).HandleColdReport(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if mockRepo.path != tc.wantPath {
+				t.Errorf("path mismatch: got %s want %s", mockRepo.path, tc.wantPath)
+			}
+			if !mockRepo.since.Equal(tc.wantSince) {
+				t.Errorf("since mismatch: got %v want %v", mockRepo.since, tc.wantSince)
+			}
+			if mockRepo.limit != tc.wantLimit {
+				t.Errorf("limit mismatch: got %d want %d", mockRepo.limit, tc.wantLimit)
+			}
+			if len(tc.wantBody) > 0 && rr.Body.String() != tc.wantBody {
+				t.Errorf("body mismatch: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type mockPopularityRepository struct {
+	path  string
+	since time.Time
+	limit int
+}
+
+func (m *mockPopularityRepository) IngestLog(ctx context.Context, logName string, reads []model.ObjectRead) error {
+	return nil
+}
+
+func (m *mockPopularityRepository) GetColdReport(ctx context.Context, path string, since time.Time, limit int) ([]*model.ColdEntry, error) {
+	m.path, m.since, m.limit = path, since, limit
+	lastRead := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	return []*model.ColdEntry{
+		{Bucket: "mock", Name: "a/", Directory: true, Size: 30, Count: 3},
+		{Bucket: "mock", Name: "b", Size: 10, Count: 1, Reads: 2, LastRead: &lastRead},
+	}, nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type queryHandler struct {
+	queryRepo repo.QueryRepository
+}
+
+func NewQueryHandler(queryRepo repo.QueryRepository) *queryHandler {
+	return &queryHandler{queryRepo}
+}
+
+// HandleQuery runs an ad-hoc statement of the restricted query language over the indexed objects
+func (q *queryHandler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var req model.QueryRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*query.MaxLength))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid query request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stmt, err := query.Parse(req.Query)
+	if err != nil {
+		var queryErr *query.Error
+		if errors.As(err, &queryErr) {
+			http.Error(w, "Invalid query "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeError(w, "parsing query", err)
+		return
+	}
+
+	result, err := q.queryRepo.Run(r.Context(), stmt)
+	if err != nil {
+		writeError(w, "running query", err)
+		return
+	}
+
+	writeResponse(w, r, result, queryRows{result})
+}
+
+// queryRows is the tabular form of a query result, columns in the order they were selected
+type queryRows struct {
+	*model.QueryResult
+}
+
+func (q queryRows) header() []string {
+	return q.Columns
+}
+
+func (q queryRows) records() [][]any {
+	records := make([][]any, len(q.Rows))
+	for i, row := range q.Rows {
+		records[i] = make([]any, len(q.Columns))
+		for j, column := range q.Columns {
+			records[i][j] = row[column]
+		}
+	}
+	return records
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
+)
+
+func TestHandleQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"Valid statement",
+			"/query",
+			`{"query": "SELECT size, name WHERE size > 0"}`,
+			http.StatusOK,
+			`"columns":["size","name"]`,
+		},
+		{
+			"CSV keeps the selected column order",
+			"/query?format=csv",
+			`{"query": "SELECT size, name, detected_type"}`,
+			http.StatusOK,
+			"size,name,detected_type\n1,\"a,b\",\n",
+		},
+		{
+			"Invalid statement",
+			"/query",
+			`{"query": "DELETE FROM metadata"}`,
+			http.StatusBadRequest,
+			"Invalid query at position 0",
+		},
+		{
+			"Unknown request field",
+			"/query",
+			`{"sql": "SELECT *"}`,
+			http.StatusBadRequest,
+			"Invalid query request",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tc.url, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := NewQueryHandler(&mockQueryRepository{})
+			handler.HandleQuery(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if !strings.Contains(rr.Body.String(), tc.wantBody) {
+				t.Errorf("body mismatch: got %q, want it to contain %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+type mockQueryRepository struct{}
+
+func (m *mockQueryRepository) Run(ctx context.Context, stmt *query.Statement) (*model.QueryResult, error) {
+	row := map[string]any{"size": int64(1), "name": "a,b", "detected_type": nil}
+	return &model.QueryResult{Columns: stmt.Columns, Rows: []map[string]any{row}}, nil
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// ConsistentReads serves h with every repository read of a request on db seeing the same snapshot of the database,
+// so endpoints issuing several queries never mix the states before and after a write
+// The read transaction holds a connection of db for the whole request, h must not write nor paginate listings
+func ConsistentReads(db *repo.Database, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, end, err := db.WithReadTransaction(r.Context())
+		if err != nil {
+			writeError(w, "starting read transaction", err)
+			return
+		}
+		defer end()
+
+		h(w, r.WithContext(ctx))
+	}
+}
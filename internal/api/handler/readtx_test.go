@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestConsistentReads(t *testing.T) {
+	db := repotest.New(t, repotest.Tree{"mock": {{Name: "dir/a", Size: 10}}})
+	exploreRepo := repo.NewExploreRepository(db)
+
+	var count *model.ObjectCount
+	h := ConsistentReads(db, func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if count, err = exploreRepo.CountObjects(r.Context(), "dir/", repo.ListOptions{}, false); err != nil {
+			writeError(w, "counting objects", err)
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/count/dir/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if count == nil || count.Count != 1 {
+		t.Errorf("count mismatch: got %+v", count)
+	}
+
+	// The transaction is released with the request, leaving the single connection of the database to writes
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	obj := &model.Metadata{Bucket: "mock", Name: "dir/b", Size: 1, StorageClass: "STANDARD", Created: repotest.Epoch, Updated: repotest.Epoch}
+	if err := repo.NewMetadataRepository(db).Insert(ctx, obj); err != nil {
+		t.Errorf("Expected writes to proceed after the request, got %v", err)
+	}
+
+	db.Close()
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/count/dir/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("status code mismatch on a closed database: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type reservationHandler struct {
+	reservationRepo repo.ReservationRepository
+}
+
+func NewReservationHandler(reservationRepo repo.ReservationRepository) *reservationHandler {
+	return &reservationHandler{reservationRepo}
+}
+
+// HandleReserve grants or denies the bytes an uploader intends to write under a prefix against the bucket budgets
+// Denied reservations are answered with 200 and granted set to false, along with the budgets they exceed
+func (rh *reservationHandler) HandleReserve(w http.ResponseWriter, r *http.Request) {
+	var req model.ReservationRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid reservation request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Bucket) == 0 {
+		http.Error(w, "Invalid reservation request: bucket is required", http.StatusBadRequest)
+		return
+	}
+	if req.Bytes <= 0 {
+		http.Error(w, "Invalid reservation request: bytes must be positive", http.StatusBadRequest)
+		return
+	}
+
+	ttl := time.Duration(req.TTL)
+	if ttl == 0 {
+		ttl = repo.DefaultReservationTTL
+	}
+	if ttl < 0 || ttl > repo.MaxReservationTTL {
+		http.Error(w, fmt.Sprintf("Invalid reservation request: ttl must be positive and at most %s", repo.MaxReservationTTL), http.StatusBadRequest)
+		return
+	}
+
+	reservation, err := rh.reservationRepo.Reserve(r.Context(), req.Bucket, req.Prefix, req.Bytes, ttl)
+	if err != nil {
+		writeError(w, "reserving bytes", err)
+		return
+	}
+
+	writeResponse(w, r, reservation, reservation.Budgets)
+}
+
+// HandleRelease stops a granted reservation from counting against budgets, once its bytes are written or abandoned
+func (rh *reservationHandler) HandleRelease(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid reservation id", http.StatusBadRequest)
+		return
+	}
+
+	if err := rh.reservationRepo.Release(r.Context(), id); err != nil {
+		writeError(w, "releasing reservation", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
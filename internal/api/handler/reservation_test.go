@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestHandleReserve(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantTTL    time.Duration
+		wantStatus int
+	}{
+		{"Default TTL", `{"bucket": "mock", "prefix": "logs/", "bytes": 10}`, repo.DefaultReservationTTL, http.StatusOK},
+		{"Requested TTL", `{"bucket": "mock", "prefix": "logs/", "bytes": 10, "ttl": "1h"}`, time.Hour, http.StatusOK},
+		{"TTL too long", `{"bucket": "mock", "bytes": 10, "ttl": "48h"}`, 0, http.StatusBadRequest},
+		{"Negative TTL", `{"bucket": "mock", "bytes": 10, "ttl": "-1m"}`, 0, http.StatusBadRequest},
+		{"Missing bucket", `{"prefix": "logs/", "bytes": 10}`, 0, http.StatusBadRequest},
+		{"No bytes", `{"bucket": "mock", "prefix": "logs/"}`, 0, http.StatusBadRequest},
+		{"Unknown field", `{"bucket": "mock", "bytes": 10, "size": 10}`, 0, http.StatusBadRequest},
+		{"Unregistered bucket", `{"bucket": "missing", "bytes": 10}`, repo.DefaultReservationTTL, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/reserve", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			mockRepo := &mockReservationRepository{}
+
+			NewReservationHandler(mockRepo).HandleReserve(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+			if mockRepo.ttl != tc.wantTTL {
+				t.Errorf("ttl mismatch: got %s want %s", mockRepo.ttl, tc.wantTTL)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got model.Reservation
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Granted || got.Bytes != 10 {
+				t.Errorf("reservation mismatch: got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandleRelease(t *testing.T) {
+	testCases := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{"Outstanding reservation", "1", http.StatusNoContent},
+		{"Released or expired reservation", "2", http.StatusNotFound},
+		{"Invalid id", "abc", http.StatusBadRequest},
+		{"Non positive id", "0", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", "/reserve/"+tc.id, nil)
+			req.SetPathValue("id", tc.id)
+			rr := httptest.NewRecorder()
+
+			NewReservationHandler(&mockReservationRepository{}).HandleRelease(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+		})
+	}
+}
+
+type mockReservationRepository struct {
+	ttl time.Duration
+}
+
+func (m *mockReservationRepository) Reserve(ctx context.Context, bucket string, prefix string, bytes int64, ttl time.Duration) (*model.Reservation, error) {
+	m.ttl = ttl
+	if bucket != "mock" {
+		return nil, repo.ErrNotFound
+	}
+	return &model.Reservation{ID: 1, Bucket: bucket, Prefix: prefix, Bytes: bytes, Granted: true, Budgets: []*model.BudgetUsage{}}, nil
+}
+
+func (m *mockReservationRepository) Release(ctx context.Context, id int64) error {
+	if id != 1 {
+		return repo.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// streamFlushRows is the number of rows streamed between flushes of the response
+const streamFlushRows = 100
+
+// ResponseLimitHeader is set to the size limit of JSON responses whose rows were streamed instead
+const ResponseLimitHeader = "X-Response-Limit"
+
+// busyRetryAfter is the Retry-After hint in seconds sent when the database is busy
+const busyRetryAfter = "1"
+
+// writeError responds with the status code matching a repository error
+// Unexpected errors are logged with action, describing the failed operation, and hidden from the client
+func writeError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, repo.ErrInvalidPageToken):
+		http.Error(w, "Invalid page_token, please restart the listing", http.StatusBadRequest)
+	case errors.Is(err, repo.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, repo.ErrConflict), errors.Is(err, repo.ErrStale):
+		http.Error(w, "Conflict", http.StatusConflict)
+	case errors.Is(err, repo.ErrBusy):
+		w.Header().Set("Retry-After", busyRetryAfter)
+		http.Error(w, "Service busy, please retry", http.StatusServiceUnavailable)
+	default:
+		log.Printf("Error %s: %v", action, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// parseDocumentOptions reads the fields selection and display options of r, nil if not requested
+func parseDocumentOptions(r *http.Request) (fieldSelection, *displayOptions, error) {
+	selection, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid fields parameter: %w", err)
+	}
+	display, err := parseDisplayOptions(r.URL.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	return selection, display, nil
+}
+
+// renderDocument applies display then selection to v
+// Display comes first as it renders values after the types and tags of their fields
+func renderDocument(v any, selection fieldSelection, display *displayOptions) (any, error) {
+	var err error
+	if display != nil {
+		v = display.apply(v)
+	}
+	if selection != nil {
+		if v, err = projectFields(v, selection); err != nil {
+			return nil, fmt.Errorf("error projecting response fields: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// encodeDocument applies display then selection to v, returning its JSON encoding
+func encodeDocument(v any, selection fieldSelection, display *displayOptions) ([]byte, error) {
+	v, err := renderDocument(v, selection, display)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// writeJSON encodes v as the response body, applying any fields selection requested
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	selection, display, err := parseDocumentOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := encodeDocument(v, selection, display)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, b)
+}
+
+// writeBody writes the JSON document b as the response body
+func writeBody(w http.ResponseWriter, b []byte) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+// writeResponse encodes v in the format negotiated with the client
+// rows holds the flat tabular form of v, a slice of structs, used for CSV, NDJSON and Parquet output
+// JSON documents beyond the response limit of the endpoint stream the rows of their longest list instead, as
+// selected by fields
+func writeResponse(w http.ResponseWriter, r *http.Request, v any, rows any) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selection, display, err := parseDocumentOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if table, ok := rows.(tabular); ok {
+		middleware.RecordRows(r.Context(), len(table.records()))
+	} else if v := reflect.ValueOf(rows); v.Kind() == reflect.Slice {
+		middleware.RecordRows(r.Context(), v.Len())
+	}
+
+	if format == formatJSON {
+		// Documents are measured row by row, so the ones beyond the limit are never encoded whole
+		if limit := middleware.ResponseLimit(r.Context()); limit > 0 {
+			doc, err := measureDocument(v, selection, display, limit)
+			if err != nil {
+				log.Printf("Error encoding response: %v", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			if doc != nil && doc.fit < doc.rows.Len() {
+				w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
+				w.Header().Set("Content-Type", formatContentTypes[formatNDJSON])
+				w.Header().Set(ResponseLimitHeader, strconv.FormatInt(limit, 10))
+				if err := writeNDJSON(w, doc.rows.Interface(), doc.selection, display); err != nil {
+					log.Printf("Error writing streamed response: %v", err)
+				}
+				return
+			}
+		}
+
+		// Documents without a list have no rows to stream, they are sent whole
+		b, err := encodeDocument(v, selection, display)
+		if err != nil {
+			log.Printf("Error encoding response: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeBody(w, b)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
+	w.Header().Set("Content-Type", formatContentTypes[format])
+
+	switch format {
+	case formatNDJSON:
+		err = writeNDJSON(w, rows, selection, display)
+	case formatParquet:
+		err = writeParquet(w, rows)
+	default:
+		err = writeCSV(w, rows, display)
+	}
+	if err != nil {
+		log.Printf("Error writing %s response: %v", format, err)
+	}
+}
+
+// limitedDocument is a JSON document measured against a response limit
+type limitedDocument struct {
+	// rows is the longest list of the document, or the document itself if it is a list
+	rows reflect.Value
+	// selection selects the fields of each row
+	selection fieldSelection
+	// fit is the number of rows fitting within the limit, the length of rows if the whole document does
+	fit int
+}
+
+// measureDocument measures the JSON document answering with v, as selected by selection and rendered by display,
+// against limit, returning nil if it holds no list
+// The document is encoded without its rows, then each row on its own until they go beyond the limit
+func measureDocument(v any, selection fieldSelection, display *displayOptions, limit int64) (*limitedDocument, error) {
+	name, envelope, rows, ok := documentList(v, selection)
+	if !ok {
+		return nil, nil
+	}
+	doc := &limitedDocument{rows: rows, selection: selection}
+	if len(name) > 0 {
+		doc.selection = selection[name]
+	}
+
+	b, err := encodeDocument(envelope, selection, display)
+	if err != nil {
+		return nil, err
+	}
+	// Rows are measured as encoded in the document, separated by commas
+	size := int64(len(b))
+	for ; doc.fit < rows.Len(); doc.fit++ {
+		row, err := renderDocument(rows.Index(doc.fit).Interface(), doc.selection, display)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		if doc.fit > 0 {
+			size++
+		}
+		if size += int64(len(encoded)); size > limit {
+			break
+		}
+	}
+	return doc, nil
+}
+
+// documentList returns the JSON name and rows of the longest list of v, or v itself if it is a list, with v
+// emptied of these rows, and false if v holds no list selected by selection
+// Lists of embedded structs aren't considered, as emptying them would change v
+func documentList(v any, selection fieldSelection) (string, any, reflect.Value, bool) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "", nil, reflect.Value{}, false
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice:
+		return "", reflect.MakeSlice(value.Type(), 0, 0).Interface(), value, true
+	case reflect.Struct:
+	default:
+		return "", nil, reflect.Value{}, false
+	}
+
+	longest := -1
+	var name string
+	for _, field := range reflect.VisibleFields(value.Type()) {
+		if len(field.Index) > 1 {
+			continue
+		}
+		i := field.Index[0]
+		list := value.Field(i)
+		if !field.IsExported() || list.Kind() != reflect.Slice || list.Type().Elem().Kind() == reflect.Uint8 {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if len(tag) == 0 {
+			tag = field.Name
+		}
+		if _, ok := selection[tag]; selection != nil && !ok {
+			continue
+		}
+		if longest == -1 || list.Len() > value.Field(longest).Len() {
+			longest, name = i, tag
+		}
+	}
+	if longest == -1 {
+		return "", nil, reflect.Value{}, false
+	}
+
+	envelope := reflect.New(value.Type()).Elem()
+	envelope.Set(value)
+	envelope.Field(longest).Set(reflect.MakeSlice(value.Field(longest).Type(), 0, 0))
+	return name, envelope.Interface(), value.Field(longest), true
+}
+
+// rowsWithinLimit returns how many rows of the longest list of the JSON document answering r with v fit within
+// the response limit of its endpoint, at least one, and false if the whole document does or isn't requested
+// Lists which can be resumed end at the last of these rows instead of being streamed
+func rowsWithinLimit(r *http.Request, v any) (int, bool) {
+	limit := middleware.ResponseLimit(r.Context())
+	if format, err := negotiateFormat(r); limit == 0 || err != nil || format != formatJSON {
+		return 0, false
+	}
+
+	selection, display, err := parseDocumentOptions(r)
+	if err != nil {
+		return 0, false
+	}
+	doc, err := measureDocument(v, selection, display, limit)
+	if err != nil || doc == nil || doc.fit == doc.rows.Len() {
+		return 0, false
+	}
+	return max(doc.fit, 1), true
+}
+
+// eachRow calls f with every row of a slice or of tabular rows, the latter as maps keyed by column
+func eachRow(rows any, f func(row any) error) error {
+	if table, ok := rows.(tabular); ok {
+		header := table.header()
+		for _, values := range table.records() {
+			row := make(map[string]any, len(header))
+			for i, column := range header {
+				row[column] = values[i]
+			}
+			if err := f(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return errors.New("rows must be a slice")
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := f(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNDJSON writes every row as a JSON document on its own line, flushing as rows are written
+// Rows are narrowed to the fields of selection, named as in the rows, when given
+func writeNDJSON(w io.Writer, rows any, selection fieldSelection, display *displayOptions) error {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	n := 0
+	return eachRow(rows, func(row any) error {
+		row, err := renderDocument(row, selection, display)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+		if n++; n%streamFlushRows == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
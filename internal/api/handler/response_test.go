@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestWriteError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{"Invalid page token", repo.ErrInvalidPageToken, http.StatusBadRequest},
+		{"Not found", repo.ErrNotFound, http.StatusNotFound},
+		{"Conflict", fmt.Errorf("%w: UNIQUE constraint failed", repo.ErrConflict), http.StatusConflict},
+		{"Stale", repo.ErrStale, http.StatusConflict},
+		{"Busy", fmt.Errorf("%w: database is locked", repo.ErrBusy), http.StatusServiceUnavailable},
+		{"Unexpected error", errors.New("mock"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			writeError(rr, "mocking", tc.err)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+
+			if gotRetry := rr.Header().Get("Retry-After"); (tc.wantStatus == http.StatusServiceUnavailable) != (len(gotRetry) > 0) {
+				t.Errorf("Unexpected Retry-After header: %q", gotRetry)
+			}
+		})
+	}
+}
+
+func TestMeasureDocument(t *testing.T) {
+	result := &model.QueryResult{Columns: []string{"name", "size"}}
+	for i := range 10 {
+		result.Rows = append(result.Rows, map[string]any{"name": fmt.Sprintf("file%d", i), "size": i})
+	}
+	selection, err := parseFields("rows(name)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, selection := range []fieldSelection{nil, selection} {
+		b, err := encodeDocument(result, selection, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last, err := encodeDocument(result.Rows[9], selection["rows"], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Rows are measured as they are encoded in the document
+		testCases := []struct {
+			limit   int64
+			wantFit int
+		}{
+			{int64(len(b)), 10},
+			{int64(len(b)) - 1, 9},
+			{int64(len(b) - len(last) - 1), 9},
+			{int64(len(b) - len(last) - 2), 8},
+			{1, 0},
+		}
+		for _, tc := range testCases {
+			doc, err := measureDocument(result, selection, nil, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc == nil || doc.rows.Len() != 10 || doc.fit != tc.wantFit {
+				t.Errorf("Measured document mismatch for %v within %d bytes: got %+v, want %d rows fitting", selection, tc.limit, doc, tc.wantFit)
+			}
+		}
+	}
+
+	// Documents without a list selected hold no rows
+	selection, err = parseFields("columns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := measureDocument(result, fieldSelection{"query": nil}, nil, 1); err != nil || doc != nil {
+		t.Errorf("Expected no rows without a list selected, got %+v, %v", doc, err)
+	}
+	if doc, err := measureDocument(result, selection, nil, 1); err != nil || doc == nil || doc.rows.Len() != len(result.Columns) {
+		t.Errorf("Expected the selected list, got %+v, %v", doc, err)
+	}
+}
+
+func TestWriteResponseLimit(t *testing.T) {
+	result := &model.QueryResult{Columns: []string{"name", "size"}}
+	for i := range 10 {
+		result.Rows = append(result.Rows, map[string]any{"name": fmt.Sprintf("file%d", i), "size": i})
+	}
+
+	testCases := []struct {
+		name            string
+		target          string
+		limit           int64
+		wantContentType string
+		wantLines       int
+		wantSize        bool
+	}{
+		{"Under the limit", "/v1/query", 1000, "application/json", 1, true},
+		{"Unlimited", "/v1/query", 0, "application/json", 1, true},
+		{"Beyond the limit", "/v1/query", 100, "application/x-ndjson", 10, true},
+		{"Beyond the limit with selected fields", "/v1/query?fields=rows(name)", 100, "application/x-ndjson", 10, false},
+		{"NDJSON requested", "/v1/query?format=ndjson", 0, "application/x-ndjson", 10, true},
+		{"NDJSON requested with selected fields", "/v1/query?format=ndjson&fields=name", 0, "application/x-ndjson", 10, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := middleware.LimitResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeResponse(w, r, result, queryRows{result})
+			}), tc.limit, nil)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", tc.target, nil))
+
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("Content-Type mismatch: got %q, want %q", got, tc.wantContentType)
+			}
+			if got := strings.Count(rr.Body.String(), "\n"); got != tc.wantLines {
+				t.Errorf("Line count mismatch: got %d, want %d", got, tc.wantLines)
+			}
+			if streamed := len(rr.Header().Get(ResponseLimitHeader)) > 0; streamed != (tc.limit > 0 && tc.wantLines > 1) {
+				t.Errorf("Unexpected %s header: %q", ResponseLimitHeader, rr.Header().Get(ResponseLimitHeader))
+			}
+			if !strings.Contains(rr.Body.String(), `"file9"`) {
+				t.Errorf("Expected every row, got %s", rr.Body.String())
+			}
+			if gotSize := strings.Contains(rr.Body.String(), `"size"`); gotSize != tc.wantSize {
+				t.Errorf("Size field mismatch: got %v want %v in %s", gotSize, tc.wantSize, rr.Body.String())
+			}
+		})
+	}
+}
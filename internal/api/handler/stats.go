@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	defaultStatsWindow = time.Hour
+	minStatsWindow     = time.Minute
+	// Events are counted per hour, over a day unless requested otherwise
+	defaultEventStatsWindow = 24 * time.Hour
+	minEventStatsWindow     = time.Hour
+)
+
+type statsHandler struct {
+	statsRepo repo.StatsRepository
+}
+
+func NewStatsHandler(statsRepo repo.StatsRepository) *statsHandler {
+	return &statsHandler{statsRepo}
+}
+
+// HandleWriteStats lists the writes applied per top level prefix over a rolling window
+func (s *statsHandler) HandleWriteStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := parseStatsWindow(w, r)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-window).UTC()
+	stats, err := s.statsRepo.GetWriteStats(r.Context(), since)
+	if err != nil {
+		writeError(w, "retrieving write stats", err)
+		return
+	}
+
+	response := model.WriteStats{
+		Window:   window.String(),
+		Since:    since,
+		Prefixes: stats,
+	}
+
+	writeResponse(w, r, response, stats)
+}
+
+// HandleNotificationLag lists how late notifications arrived after the writes they announce per bucket and
+// top level prefix over a rolling window, bounding how long writes to dual-region buckets took to replicate
+func (s *statsHandler) HandleNotificationLag(w http.ResponseWriter, r *http.Request) {
+	window, ok := parseStatsWindow(w, r)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-window).UTC()
+	lags, err := s.statsRepo.GetNotificationLag(r.Context(), since)
+	if err != nil {
+		writeError(w, "retrieving notification lag", err)
+		return
+	}
+
+	response := model.NotificationLag{
+		Window:   window.String(),
+		Since:    since,
+		Prefixes: lags,
+	}
+
+	writeResponse(w, r, response, lagRows(lags))
+}
+
+// HandleEventStats lists the notifications applied per bucket and hour by event type over a rolling window,
+// showing churn patterns such as nightly delete storms
+func (s *statsHandler) HandleEventStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := parseWindow(w, r, defaultEventStatsWindow, minEventStatsWindow, repo.EventStatsRetention)
+	if !ok {
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	since := time.Now().Add(-window).UTC()
+	stats, err := s.statsRepo.GetEventStats(r.Context(), since, bucket)
+	if err != nil {
+		writeError(w, "retrieving event stats", err)
+		return
+	}
+
+	response := model.EventStats{
+		Window: window.String(),
+		Since:  since,
+		Bucket: bucket,
+		Hours:  stats,
+	}
+
+	writeResponse(w, r, response, stats)
+}
+
+// parseStatsWindow returns the window query param, responding with an error if invalid
+func parseStatsWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	return parseWindow(w, r, defaultStatsWindow, minStatsWindow, repo.StatsRetention)
+}
+
+// parseWindow returns the window query param between minWindow and maxWindow, defaultWindow if unset,
+// responding with an error if invalid
+func parseWindow(w http.ResponseWriter, r *http.Request, defaultWindow, minWindow, maxWindow time.Duration) (time.Duration, bool) {
+	windowString := r.URL.Query().Get("window")
+	if len(windowString) == 0 {
+		return defaultWindow, true
+	}
+
+	window, err := time.ParseDuration(windowString)
+	if err != nil || window < minWindow || window > maxWindow {
+		http.Error(w, fmt.Sprintf("Invalid window parameter, please use a duration between %v and %v", minWindow, maxWindow), http.StatusBadRequest)
+		return 0, false
+	}
+	return window, true
+}
+
+// lagRow is the tabular form of the notification lag, in milliseconds
+type lagRow struct {
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix"`
+	DualRegion bool   `json:"dual_region"`
+	Events     int64  `json:"events"`
+	MeanLagMs  int64  `json:"mean_lag_ms"`
+	MaxLagMs   int64  `json:"max_lag_ms"`
+}
+
+func lagRows(lags []*model.PrefixLag) []lagRow {
+	rows := make([]lagRow, len(lags))
+	for i, lag := range lags {
+		rows[i] = lagRow{
+			Bucket:     lag.Bucket,
+			Prefix:     lag.Prefix,
+			DualRegion: lag.DualRegion,
+			Events:     lag.Events,
+			MeanLagMs:  time.Duration(lag.MeanLag).Milliseconds(),
+			MaxLagMs:   time.Duration(lag.MaxLag).Milliseconds(),
+		}
+	}
+	return rows
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestHandleWriteStats(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantWindow time.Duration
+	}{
+		{"Default window", "", http.StatusOK, time.Hour},
+		{"Custom window", "?window=15m", http.StatusOK, 15 * time.Minute},
+		{"Invalid window", "?window=mock", http.StatusBadRequest, 0},
+		{"Window below minimum", "?window=10s", http.StatusBadRequest, 0},
+		{"Window beyond retention", "?window=48h", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stats/writes"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockStatsRepository{}
+
+			handler := NewStatsHandler(mockRepo)
+			handler.HandleWriteStats(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if got := time.Since(mockRepo.since); got < tc.wantWindow || got > tc.wantWindow+time.Minute {
+				t.Errorf("since mismatch: got %v ago, want %v ago", got, tc.wantWindow)
+			}
+
+			var got model.WriteStats
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Prefixes) != 1 || got.Window != tc.wantWindow.String() {
+				t.Errorf("response mismatch: got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandleNotificationLag(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Default window", "", http.StatusOK, ""},
+		{"Renders CSV in milliseconds", "?window=15m&format=csv", http.StatusOK, "bucket,prefix,dual_region,events,mean_lag_ms,max_lag_ms\nmock,logs/,true,2,1500,2000\n"},
+		{"Invalid window", "?window=48h", http.StatusBadRequest, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stats/lag"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockStatsRepository{}
+
+			NewStatsHandler(mockRepo).HandleNotificationLag(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if len(tc.wantBody) > 0 && rr.Body.String() != tc.wantBody {
+				t.Errorf("body mismatch: got %q want %q", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleEventStats(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantWindow time.Duration
+		wantBucket string
+	}{
+		{"Default window", "", http.StatusOK, 24 * time.Hour, ""},
+		{"One bucket over a week", "?window=168h&bucket=mock", http.StatusOK, 7 * 24 * time.Hour, "mock"},
+		{"Window below an hour", "?window=15m", http.StatusBadRequest, 0, ""},
+		{"Window beyond retention", "?window=200h", http.StatusBadRequest, 0, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stats/events"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			mockRepo := &mockStatsRepository{}
+
+			NewStatsHandler(mockRepo).HandleEventStats(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			if got := time.Since(mockRepo.since); got < tc.wantWindow || got > tc.wantWindow+time.Minute {
+				t.Errorf("since mismatch: got %v ago, want %v ago", got, tc.wantWindow)
+			}
+			if mockRepo.bucket != tc.wantBucket {
+				t.Errorf("bucket mismatch: got %q want %q", mockRepo.bucket, tc.wantBucket)
+			}
+
+			var got model.EventStats
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Hours) != 1 || got.Hours[0].Delete != 5 || got.Window != tc.wantWindow.String() {
+				t.Errorf("response mismatch: got %+v", got)
+			}
+		})
+	}
+}
+
+type mockStatsRepository struct {
+	since  time.Time
+	bucket string
+}
+
+func (m *mockStatsRepository) GetWriteStats(ctx context.Context, since time.Time) ([]*model.PrefixWriteStat, error) {
+	m.since = since
+	return []*model.PrefixWriteStat{{Prefix: "mock/", Events: 1}}, nil
+}
+
+func (m *mockStatsRepository) GetLastWrite(ctx context.Context) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (m *mockStatsRepository) RecordLag(ctx context.Context, bucket string, objName string, lag time.Duration) error {
+	return nil
+}
+
+func (m *mockStatsRepository) GetNotificationLag(ctx context.Context, since time.Time) ([]*model.PrefixLag, error) {
+	m.since = since
+	return []*model.PrefixLag{{
+		Bucket:     "mock",
+		Prefix:     "logs/",
+		DualRegion: true,
+		LagStats:   model.LagStats{Events: 2, MeanLag: model.Duration(1500 * time.Millisecond), MaxLag: model.Duration(2 * time.Second)},
+	}}, nil
+}
+
+func (m *mockStatsRepository) RecordEvent(ctx context.Context, bucket string, eventType string) error {
+	return nil
+}
+
+func (m *mockStatsRepository) GetEventStats(ctx context.Context, since time.Time, bucket string) ([]*model.EventStat, error) {
+	m.since, m.bucket = since, bucket
+	return []*model.EventStat{{Bucket: "mock", Hour: since.Truncate(time.Hour), Delete: 5}}, nil
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingZstd    = "zstd"
+)
+
+// Compress encodes responses with zstd, gzip or deflate as negotiated through Accept-Encoding
+// Responses smaller than threshold bytes are sent uncompressed since the savings don't pay for the CPU
+// level applies to gzip and deflate, zstdLevel from 1 (fastest) to 22 (smallest) to zstd, 0 disabling it
+// It returns an error if either level is invalid
+func Compress(next http.Handler, threshold int, level int, zstdLevel int) (http.Handler, error) {
+	// Writers are created once up front, so the pools only create writers of valid levels
+	gzipWriter, err := gzip.NewWriterLevel(io.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+	flateWriter, err := flate.NewWriter(io.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+	if zstdLevel < 0 || zstdLevel > 22 {
+		return nil, fmt.Errorf("zstd: invalid compression level: %d", zstdLevel)
+	}
+	zstdOptions := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zstdLevel)), zstd.WithEncoderConcurrency(1)}
+	zstdWriter, err := zstd.NewWriter(nil, zstdOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	gzipPool := &sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}}
+	gzipPool.Put(gzipWriter)
+	flatePool := &sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}}
+	flatePool.Put(flateWriter)
+	zstdPool := &sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstdOptions...)
+		return w
+	}}
+	zstdPool.Put(zstdWriter)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), zstdLevel > 0)
+		if len(encoding) == 0 || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			threshold:      threshold,
+			gzipPool:       gzipPool,
+			flatePool:      flatePool,
+			zstdPool:       zstdPool,
+			status:         http.StatusOK,
+		}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	}), nil
+}
+
+// negotiateEncoding picks the preferred supported encoding from an Accept-Encoding header, zstd only if allowed
+func negotiateEncoding(header string, allowZstd bool) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		rank, ok := encodingRanks[name]
+		if !ok || (name == encodingZstd && !allowZstd) {
+			continue
+		}
+		// Prefer zstd, then gzip, when several are equally acceptable
+		if q > bestQ || (q == bestQ && rank > encodingRanks[best]) {
+			best, bestQ = name, q
+		}
+	}
+
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// encodingRanks orders the supported encodings by preference
+var encodingRanks = map[string]int{
+	encodingDeflate: 1,
+	encodingGzip:    2,
+	encodingZstd:    3,
+}
+
+// compressWriter buffers the response until it reaches the threshold, then switches to compressed output
+type compressWriter struct {
+	http.ResponseWriter
+	encoding  string
+	threshold int
+	gzipPool  *sync.Pool
+	flatePool *sync.Pool
+	zstdPool  *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  io.WriteCloser
+	release func()
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.decided {
+		return
+	}
+	c.status = status
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		if c.writer != nil {
+			return c.writer.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) < c.threshold {
+		return len(p), nil
+	}
+
+	if err := c.decide(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush forces compression to begin so streamed responses reach the client as they are produced
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if err := c.decide(len(c.buf) > 0); err != nil {
+			return
+		}
+	}
+
+	if f, ok := c.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sends the response headers and flushes the buffer, through a compressor if compress is set
+func (c *compressWriter) decide(compress bool) error {
+	c.decided = true
+
+	header := c.Header()
+	if len(header.Get("Content-Encoding")) > 0 || c.status == http.StatusNoContent || c.status == http.StatusNotModified {
+		compress = false
+	}
+
+	if compress {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+
+		switch c.encoding {
+		case encodingGzip:
+			gw := c.gzipPool.Get().(*gzip.Writer)
+			gw.Reset(c.ResponseWriter)
+			c.writer = gw
+			c.release = func() { c.gzipPool.Put(gw) }
+		case encodingDeflate:
+			fw := c.flatePool.Get().(*flate.Writer)
+			fw.Reset(c.ResponseWriter)
+			c.writer = fw
+			c.release = func() { c.flatePool.Put(fw) }
+		case encodingZstd:
+			zw := c.zstdPool.Get().(*zstd.Encoder)
+			zw.Reset(c.ResponseWriter)
+			c.writer = zw
+			c.release = func() { c.zstdPool.Put(zw) }
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if c.writer != nil {
+		_, err = c.writer.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close writes any buffered response and finishes the compressed stream
+func (c *compressWriter) close() {
+	if !c.decided {
+		c.decide(false)
+	}
+
+	if c.writer != nil {
+		c.writer.Close()
+		c.release()
+	}
+}
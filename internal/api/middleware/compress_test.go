@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		name      string
+		in        string
+		allowZstd bool
+		want      string
+	}{
+		{"No header", "", true, ""},
+		{"Gzip", "gzip", true, "gzip"},
+		{"Deflate", "deflate", true, "deflate"},
+		{"Prefers gzip on tie", "deflate, gzip", true, "gzip"},
+		{"Prefers zstd on tie", "gzip, deflate, br, zstd", true, "zstd"},
+		{"Respects q-values", "gzip;q=0.5, deflate;q=0.8", true, "deflate"},
+		{"Respects q-values over zstd", "zstd;q=0.5, gzip", true, "gzip"},
+		{"Rejects disabled encodings", "gzip;q=0, deflate;q=0", true, ""},
+		{"Ignores unsupported encodings", "br", true, ""},
+		{"Ignores zstd unless allowed", "br, zstd", false, ""},
+		{"Case insensitive", "GZIP", true, "gzip"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := negotiateEncoding(tc.in, tc.allowZstd); got != tc.want {
+				t.Errorf("Encoding mismatch: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompressInvalidLevel(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if _, err := Compress(next, 1024, 10, 3); err == nil {
+		t.Error("Expected an error for gzip level 10")
+	}
+	if _, err := Compress(next, 1024, gzip.DefaultCompression, 23); err == nil {
+		t.Error("Expected an error for zstd level 23")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("metadata ", 1000)
+
+	testCases := []struct {
+		name         string
+		accept       string
+		body         string
+		status       int
+		wantEncoding string
+	}{
+		{"Compresses large gzip responses", "gzip", large, http.StatusOK, "gzip"},
+		{"Compresses large deflate responses", "deflate", large, http.StatusOK, "deflate"},
+		{"Compresses large zstd responses", "zstd", large, http.StatusOK, "zstd"},
+		{"Skips responses under threshold", "gzip", "small", http.StatusOK, ""},
+		{"Skips clients without support", "", large, http.StatusOK, ""},
+		{"Preserves status codes", "gzip", large, http.StatusBadRequest, "gzip"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, err := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			}), 1024, gzip.DefaultCompression, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tc.accept)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("status code mismatch: got %v want %v", rr.Code, tc.status)
+			}
+
+			if got := rr.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+				t.Fatalf("Content-Encoding mismatch: got %q, want %q", got, tc.wantEncoding)
+			}
+
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary mismatch: got %q", got)
+			}
+
+			var reader io.Reader = rr.Body
+			switch tc.wantEncoding {
+			case "gzip":
+				gr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gr
+			case "deflate":
+				reader = flate.NewReader(rr.Body)
+			case "zstd":
+				zr, err := zstd.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				reader = zr
+			}
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.body {
+				t.Errorf("Body mismatch: got %d bytes, want %d bytes", len(got), len(tc.body))
+			}
+		})
+	}
+}
+
+// BenchmarkCompress compares the cost of encoding a large listing with each encoding
+// The reported ratio metric is the compressed size relative to the identity response
+func BenchmarkCompress(b *testing.B) {
+	contents := make([]*model.Metadata, 10000)
+	for i := range contents {
+		contents[i] = &model.Metadata{
+			Name:         fmt.Sprintf("logs/2024/10/%02d/server-%d.log", i%31, i),
+			Parent:       "logs/2024/10/",
+			StorageClass: "STANDARD",
+			Size:         int64(i) * 1024,
+			Cost:         0.023,
+			Created:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+			Updated:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	payload, err := json.Marshal(model.PathContents{Path: "logs/2024/10/", Contents: contents})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	})
+
+	benchmarks := []struct {
+		name      string
+		accept    string
+		level     int
+		zstdLevel int
+	}{
+		{"identity", "", gzip.DefaultCompression, 3},
+		{"gzip-speed", "gzip", gzip.BestSpeed, 3},
+		{"gzip-default", "gzip", gzip.DefaultCompression, 3},
+		{"gzip-best", "gzip", gzip.BestCompression, 3},
+		{"deflate-default", "deflate", flate.DefaultCompression, 3},
+		{"zstd-speed", "zstd", gzip.DefaultCompression, 1},
+		{"zstd-default", "zstd", gzip.DefaultCompression, 3},
+		{"zstd-best", "zstd", gzip.DefaultCompression, 19},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			handler, err := Compress(next, 1024, bm.level, bm.zstdLevel)
+			if err != nil {
+				b.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", bm.accept)
+
+			var written int
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rr := &discardRecorder{header: make(http.Header)}
+				handler.ServeHTTP(rr, req)
+				written = rr.written
+			}
+			b.ReportMetric(float64(written)/float64(len(payload)), "ratio")
+		})
+	}
+}
+
+// discardRecorder is a ResponseWriter that only counts bytes written
+type discardRecorder struct {
+	header  http.Header
+	written int
+}
+
+func (d *discardRecorder) Header() http.Header {
+	return d.header
+}
+
+func (d *discardRecorder) Write(p []byte) (int, error) {
+	d.written += len(p)
+	return io.Discard.Write(p)
+}
+
+func (d *discardRecorder) WriteHeader(int) {}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	// DebugQueriesHeader requests the statements executed by a request, set to true
+	DebugQueriesHeader = "X-Debug-Queries"
+	// QueryTraceHeader holds a statement executed by a request per value, encoded as a JSON queryTrace
+	QueryTraceHeader = "X-Query-Trace"
+)
+
+// queryTrace is a statement executed by a request, with the plan SQLite picked for it
+type queryTrace struct {
+	SQL       string   `json:"sql"`
+	Args      []any    `json:"args"`
+	Duration  string   `json:"duration"`
+	Plan      []string `json:"plan,omitempty"`
+	PlanError string   `json:"plan_error,omitempty"`
+}
+
+// DebugQueries attaches the statements executed by requests setting DebugQueriesHeader to their response,
+// with their bind parameters, duration and query plan, one QueryTraceHeader value per statement
+// The responses of traced requests are buffered, since their statements are only known once served
+func DebugQueries(next http.Handler, explain ExplainFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if debug, _ := strconv.ParseBool(r.Header.Get(DebugQueriesHeader)); !debug {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, queryLog := repo.WithQueryLog(r.Context())
+		bw := &bufferedWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(bw, r.WithContext(ctx))
+
+		// The request context may be cancelled already, plans are explained on their own
+		explainCtx := context.WithoutCancel(ctx)
+		for _, q := range queryLog.Queries() {
+			trace := queryTrace{
+				SQL:      strings.Join(strings.Fields(q.SQL), " "),
+				Args:     q.Args,
+				Duration: q.Duration.String(),
+			}
+			if plan, err := explain(explainCtx, q.SQL, q.Args); err != nil {
+				trace.PlanError = err.Error()
+			} else {
+				trace.Plan = plan
+			}
+
+			value, err := json.Marshal(trace)
+			if err != nil {
+				log.Printf("Error encoding query trace: %v", err)
+				continue
+			}
+			w.Header().Add(QueryTraceHeader, string(value))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+
+		w.WriteHeader(bw.status)
+		if _, err := w.Write(bw.body.Bytes()); err != nil {
+			log.Printf("Error writing traced response: %v", err)
+		}
+	})
+}
+
+// bufferedWriter holds the status and body of a response back, its headers are set on the response directly
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestDebugQueries(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	exploreRepo := repo.NewExploreRepository(db)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := exploreRepo.GetPathSummary(r.Context(), "mock/"); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("body"))
+	})
+	handler := DebugQueries(next, db.ExplainQueryPlan)
+
+	testCases := []struct {
+		name       string
+		debug      string
+		wantTraces int
+	}{
+		{"Traced", "true", 1},
+		{"Not requested", "", 0},
+		{"Disabled", "false", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if len(tc.debug) > 0 {
+				req.Header.Set(DebugQueriesHeader, tc.debug)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusTeapot || rr.Body.String() != "body" {
+				t.Errorf("Response mismatch: got %d %q", rr.Code, rr.Body.String())
+			}
+
+			traces := rr.Header().Values(QueryTraceHeader)
+			if len(traces) != tc.wantTraces {
+				t.Fatalf("Trace count mismatch: got %d, want %d", len(traces), tc.wantTraces)
+			}
+
+			for _, value := range traces {
+				var trace queryTrace
+				if err := json.Unmarshal([]byte(value), &trace); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(trace.SQL, "SELECT") || strings.Contains(trace.SQL, "\n") {
+					t.Errorf("Unexpected statement: %q", trace.SQL)
+				}
+				if len(trace.Args) == 0 || len(trace.Plan) == 0 || len(trace.PlanError) > 0 {
+					t.Errorf("Expected bind parameters and a plan, got %+v", trace)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DegradedHeader is set on every response served while the server is degraded, to why it degraded,
+// followed by "; cached" on rollups served from cache
+const DegradedHeader = "X-Degraded"
+
+// rollupEndpoints answer from directory rollups, served from cache while degraded
+var rollupEndpoints = map[string]bool{"explore": true, "summary": true, "top": true}
+
+const (
+	// maxDegradedEntries bounds the responses cached while degraded, later ones being served uncached
+	maxDegradedEntries = 1000
+	// maxDegradedBody is the largest response cached while degraded
+	maxDegradedBody = 1 << 20
+)
+
+// cachedResponse is a rollup response served again while degraded
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// Degrade sheds the expensive requests of the shed endpoints, named as in LimitResponses, while reason returns
+// why the server is degraded, answering them 503 with a Retry-After so that cheap reads and the single writer
+// keep their latency rather than everything slowing down alike
+// Rollups are meanwhile served from responses cached for up to cacheTTL, the cache being dropped once the
+// server recovers
+func Degrade(next http.Handler, reason func() string, shed []string, cacheTTL time.Duration) http.Handler {
+	shedEndpoints := make(map[string]bool, len(shed))
+	for _, name := range shed {
+		shedEndpoints[name] = true
+	}
+
+	var mu sync.Mutex
+	cache := make(map[string]*cachedResponse)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		degraded := reason()
+		if len(degraded) == 0 {
+			mu.Lock()
+			if len(cache) > 0 {
+				cache = make(map[string]*cachedResponse)
+			}
+			mu.Unlock()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name := endpoint(r.URL.Path)
+		if shedEndpoints[name] {
+			w.Header().Set(DegradedHeader, degraded)
+			w.Header().Set("Retry-After", strconv.Itoa(int(max(cacheTTL, time.Second).Seconds())))
+			http.Error(w, "Service degraded, please retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Method != http.MethodGet || !rollupEndpoints[name] {
+			w.Header().Set(DegradedHeader, degraded)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Responses are negotiated from the query and the Accept header, compression applying on top of them
+		key := r.URL.RequestURI() + "\n" + r.Header.Get("Accept")
+		now := time.Now()
+		mu.Lock()
+		cached, ok := cache[key]
+		mu.Unlock()
+		if ok && now.Sub(cached.stored) < cacheTTL {
+			for k, v := range cached.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(DegradedHeader, degraded+"; cached")
+			w.Header().Set("Age", strconv.Itoa(int(now.Sub(cached.stored).Seconds())))
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		w.Header().Set(DegradedHeader, degraded)
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		if rw.status != http.StatusOK || rw.overflow {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := cache[key]; ok || len(cache) < maxDegradedEntries {
+			header := w.Header().Clone()
+			header.Del(DegradedHeader)
+			cache[key] = &cachedResponse{status: rw.status, header: header, body: rw.body.Bytes(), stored: now}
+		}
+	})
+}
+
+// recordingWriter keeps a copy of the response written, up to maxDegradedBody bytes
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	if !rw.overflow {
+		if rw.body.Len()+len(p) > maxDegradedBody {
+			rw.overflow = true
+			rw.body = bytes.Buffer{}
+		} else {
+			rw.body.Write(p)
+		}
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
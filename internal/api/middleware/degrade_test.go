@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDegrade(t *testing.T) {
+	var calls int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	})
+
+	reason := ""
+	handler := Degrade(next, func() string { return reason }, []string{"search", "query"}, time.Minute)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	// Healthy servers serve every request
+	if rr := serve("GET", "/v1/search/mock/?q=a"); rr.Code != http.StatusOK || len(rr.Header().Get(DegradedHeader)) > 0 {
+		t.Errorf("Expected the search to be served, got %d %v", rr.Code, rr.Header())
+	}
+
+	reason = "write-load"
+
+	// Expensive endpoints are shed
+	rr := serve("POST", "/v1/query")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get(DegradedHeader) != "write-load" || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected the query to be shed, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Rollups are served from cache once fetched
+	first := serve("GET", "/v1/summary/mock/")
+	second := serve("GET", "/v1/summary/mock/")
+	if first.Body.String() != `{"call":2}` || first.Header().Get(DegradedHeader) != "write-load" {
+		t.Errorf("First rollup mismatch: got %s %v", first.Body.String(), first.Header())
+	}
+	if second.Body.String() != `{"call":2}` || second.Header().Get(DegradedHeader) != "write-load; cached" || second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Cached rollup mismatch: got %s %v", second.Body.String(), second.Header())
+	}
+
+	// Other requests are served, advertising the degradation
+	if rr := serve("GET", "/v1/objects/mock/a"); rr.Body.String() != `{"call":3}` || rr.Header().Get(DegradedHeader) != "write-load" {
+		t.Errorf("Object mismatch: got %s %v", rr.Body.String(), rr.Header())
+	}
+
+	// The cache is dropped once the server recovers
+	reason = ""
+	if rr := serve("GET", "/v1/summary/mock/"); rr.Body.String() != `{"call":4}` {
+		t.Errorf("Expected a fresh rollup once recovered, got %s", rr.Body.String())
+	}
+	reason = "error-budget"
+	if rr := serve("GET", "/v1/summary/mock/"); rr.Body.String() != `{"call":5}` {
+		t.Errorf("Expected the cache to be dropped on recovery, got %s", rr.Body.String())
+	}
+}
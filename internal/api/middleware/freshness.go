@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FreshnessHeader reports the age of the data served, so consumers can judge whether it's current
+const FreshnessHeader = "X-Metadata-Freshness"
+
+// Freshness sets FreshnessHeader on every response from the time of the latest applied write
+// lastWrite is cached for cacheTTL since every request would otherwise query it
+func Freshness(next http.Handler, lastWrite func(context.Context) (time.Time, error), cacheTTL time.Duration) http.Handler {
+	var mu sync.Mutex
+	var cached time.Time
+	var fetched time.Time
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if time.Since(fetched) >= cacheTTL {
+			if t, err := lastWrite(r.Context()); err != nil {
+				log.Printf("Error retrieving last write time: %v", err)
+			} else {
+				cached, fetched = t, time.Now()
+			}
+		}
+		last := cached
+		mu.Unlock()
+
+		w.Header().Set(FreshnessHeader, formatFreshness(last, time.Now()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// formatFreshness renders the header value, e.g. "age=42; last-write=2024-10-01T12:00:00Z"
+func formatFreshness(lastWrite time.Time, now time.Time) string {
+	if lastWrite.IsZero() {
+		return "unknown"
+	}
+
+	age := max(now.Sub(lastWrite), 0)
+	return fmt.Sprintf("age=%d; last-write=%s", int64(age.Seconds()), lastWrite.UTC().Format(time.RFC3339))
+}
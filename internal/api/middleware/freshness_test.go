@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFormatFreshness(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 42, 0, time.UTC)
+
+	testCases := []struct {
+		name      string
+		lastWrite time.Time
+		want      string
+	}{
+		{"Never written", time.Time{}, "unknown"},
+		{"Written before", time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC), "age=42; last-write=2024-10-01T12:00:00Z"},
+		{"Written after now due to clock skew", now.Add(time.Second), "age=0; last-write=2024-10-01T12:00:43Z"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatFreshness(tc.lastWrite, now); got != tc.want {
+				t.Errorf("Header mismatch: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	var calls int
+	var err error
+	lastWrite := func(ctx context.Context) (time.Time, error) {
+		calls++
+		return time.Now().Add(-time.Minute), err
+	}
+
+	handler := Freshness(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), lastWrite, time.Hour)
+
+	for range 3 {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		if got := rr.Header().Get(FreshnessHeader); len(got) == 0 || got == "unknown" {
+			t.Fatalf("Unexpected header: %q", got)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected last write to be cached, got %d calls", calls)
+	}
+
+	// Errors aren't cached and leave the header unknown
+	err = errors.New("mock")
+	handler = Freshness(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), lastWrite, time.Hour)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if got := rr.Header().Get(FreshnessHeader); got != "unknown" {
+		t.Errorf("Header mismatch: got %q, want %q", got, "unknown")
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// lazyRoutes are the routes querying the subtree of a directory, named by the rest of their path
+var lazyRoutes = []string{"/explore/", "/summary/", "/search/"}
+
+// versionSegment matches the API version a path may start with
+var versionSegment = regexp.MustCompile(`^/v[0-9]+/`)
+
+// LazyIndex lets index list the subtree of the directory queried by explore, summary and search requests
+// before they are served, answering 503 if it fails so subtrees not indexed yet are never reported empty
+func LazyIndex(next http.Handler, index func(ctx context.Context, path string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := lazyPath(r); ok {
+			if err := index(r.Context(), path); err != nil {
+				log.Printf("Error indexing %q on demand: %v", path, err)
+				http.Error(w, "Error indexing path, please retry", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lazyPath returns the directory queried by a request, "/" for the root, or false if it queries none
+func lazyPath(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		return "", false
+	}
+
+	path := r.URL.Path
+	if loc := versionSegment.FindStringIndex(path); loc != nil {
+		path = path[loc[1]-1:]
+	}
+
+	for _, route := range lazyRoutes {
+		if rest, ok := strings.CutPrefix(path, route); ok {
+			if len(rest) == 0 {
+				return "/", true
+			}
+			return rest, true
+		}
+	}
+	return "", false
+}
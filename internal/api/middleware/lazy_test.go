@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLazyIndex(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		url        string
+		err        error
+		wantPath   string
+		wantStatus int
+	}{
+		{"Versioned explore", "GET", "/v1/explore/a/b", nil, "a/b", http.StatusOK},
+		{"Legacy summary", "GET", "/summary/a/", nil, "a/", http.StatusOK},
+		{"Root search", "GET", "/v1/search/?q=log", nil, "/", http.StatusOK},
+		{"Object lookup", "GET", "/v1/objects/mock/a/b", nil, "", http.StatusOK},
+		{"Other method", "POST", "/v1/explore/a/", nil, "", http.StatusOK},
+		{"Failed listing", "GET", "/v1/explore/a/", errors.New("listing failed"), "a/", http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var indexed string
+			handler := LazyIndex(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(ctx context.Context, path string) error {
+				indexed = path
+				return tc.err
+			})
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.url, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("status code mismatch: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if indexed != tc.wantPath {
+				t.Errorf("path mismatch: got %q want %q", indexed, tc.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type responseLimitKey struct{}
+
+// LimitResponses caps the size in bytes of the JSON documents handlers respond with, beyond which
+// they stream their rows instead, or end paginated listings early with the token of the next page, so a
+// single wide listing can't send an unbounded document
+// limits overrides defaultLimit per endpoint, named after the first path segment following the API
+// version such as explore or query, and a limit of 0 lifts the cap
+func LimitResponses(next http.Handler, defaultLimit int64, limits map[string]int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := limits[endpoint(r.URL.Path)]
+		if !ok {
+			limit = defaultLimit
+		}
+		if limit > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), responseLimitKey{}, limit))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ResponseLimit returns the maximum size in bytes of the JSON document answering the request of ctx,
+// 0 if unlimited
+func ResponseLimit(ctx context.Context) int64 {
+	limit, _ := ctx.Value(responseLimitKey{}).(int64)
+	return limit
+}
+
+// endpoint returns the first segment of path following the API version, if any
+func endpoint(path string) string {
+	if loc := versionSegment.FindStringIndex(path); loc != nil {
+		path = path[loc[1]-1:]
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return name
+}
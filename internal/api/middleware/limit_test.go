@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimitResponses(t *testing.T) {
+	testCases := []struct {
+		name string
+		path string
+		want int64
+	}{
+		{"Default", "/v1/search/bucket/", 1000},
+		{"Endpoint override", "/v1/query", 50},
+		{"Legacy route", "/query", 50},
+		{"Lifted", "/v1/explore/bucket/dir/", 0},
+	}
+
+	limits := map[string]int64{"query": 50, "explore": 0}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got int64 = -1
+			handler := LimitResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ResponseLimit(r.Context())
+			}), 1000, limits)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+			if got != tc.want {
+				t.Errorf("Limit mismatch: got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// ExplainFunc returns the query plan of a statement
+type ExplainFunc func(ctx context.Context, query string, args []any) ([]string, error)
+
+type rowsKey struct{}
+
+// RecordRows reports the number of rows a handler returned, for the request log
+func RecordRows(ctx context.Context, n int) {
+	if rows, ok := ctx.Value(rowsKey{}).(*atomic.Int64); ok {
+		rows.Store(int64(n))
+	}
+}
+
+// Logging logs the method, URL, status, latency and rows returned of every request
+// Requests slower than slowThreshold also log every statement they executed with its query plan,
+// a threshold of 0 disables slow request capture
+func Logging(next http.Handler, slowThreshold time.Duration, explain ExplainFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rows := &atomic.Int64{}
+		rows.Store(-1)
+		ctx := context.WithValue(r.Context(), rowsKey{}, rows)
+
+		var queryLog *repo.QueryLog
+		if slowThreshold > 0 {
+			ctx, queryLog = repo.WithQueryLog(ctx)
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		latency := time.Since(start)
+
+		rowsReturned := "-"
+		if n := rows.Load(); n >= 0 {
+			rowsReturned = fmt.Sprint(n)
+		}
+		log.Printf("%s %s %d %v rows=%s", r.Method, r.URL.RequestURI(), sw.status, latency, rowsReturned)
+
+		if queryLog != nil && latency >= slowThreshold {
+			logSlowRequest(r, queryLog.Queries(), explain)
+		}
+	})
+}
+
+// logSlowRequest logs the statements of a slow request with their query plans
+func logSlowRequest(r *http.Request, queries []repo.LoggedQuery, explain ExplainFunc) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Slow request %s %s executed %d statements:", r.Method, r.URL.RequestURI(), len(queries))
+
+	// The request context may be cancelled already, plans are explained on their own
+	ctx := context.WithoutCancel(r.Context())
+
+	for _, q := range queries {
+		fmt.Fprintf(&b, "\n  [%v] %s %v", q.Duration, strings.Join(strings.Fields(q.SQL), " "), q.Args)
+
+		plan, err := explain(ctx, q.SQL, q.Args)
+		if err != nil {
+			fmt.Fprintf(&b, "\n    plan unavailable: %v", err)
+			continue
+		}
+		for _, step := range plan {
+			fmt.Fprintf(&b, "\n    %s", step)
+		}
+	}
+	log.Print(b.String())
+}
+
+// statusWriter records the status code written to the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
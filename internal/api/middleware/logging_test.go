@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestLogging(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	exploreRepo := repo.NewExploreRepository(db)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := exploreRepo.GetPathSummary(r.Context(), "mock/"); err != nil {
+			t.Fatal(err)
+		}
+		RecordRows(r.Context(), 3)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	testCases := []struct {
+		name          string
+		slowThreshold time.Duration
+		wantSlow      bool
+	}{
+		{"Slow capture disabled", 0, false},
+		{"Fast request", time.Hour, false},
+		{"Slow request", time.Nanosecond, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			defer log.SetOutput(log.Writer())
+			log.SetOutput(&buf)
+
+			handler := Logging(next, tc.slowThreshold, db.ExplainQueryPlan)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/summary/mock/?fields=size", nil))
+
+			if rr.Code != http.StatusTeapot {
+				t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusTeapot)
+			}
+
+			out := buf.String()
+			if !strings.Contains(out, "GET /summary/mock/?fields=size 418") || !strings.Contains(out, "rows=3") {
+				t.Errorf("Request log mismatch: got %q", out)
+			}
+
+			gotSlow := strings.Contains(out, "Slow request GET /summary/mock/?fields=size executed 1 statements") &&
+				(strings.Contains(out, "    SCAN directory") || strings.Contains(out, "    SEARCH directory")) // query plan step
+			if gotSlow != tc.wantSlow {
+				t.Errorf("Slow request log mismatch: got %q", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// ObserveQueries passes the statements executed by every request to observe once it completes
+func ObserveQueries(next http.Handler, observe func(queries []repo.LoggedQuery)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, queryLog := repo.WithQueryLog(r.Context())
+		next.ServeHTTP(w, r.WithContext(ctx))
+		observe(queryLog.Queries())
+	})
+}
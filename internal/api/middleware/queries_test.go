@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestObserveQueries(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	exploreRepo := repo.NewExploreRepository(db)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := exploreRepo.GetPathSummary(r.Context(), "mock/"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var observed []repo.LoggedQuery
+	handler := ObserveQueries(next, func(queries []repo.LoggedQuery) {
+		observed = append(observed, queries...)
+	})
+
+	// The enclosing request log keeps recording
+	ctx, queryLog := repo.WithQueryLog(context.Background())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	if len(observed) != 1 {
+		t.Errorf("Observed query count mismatch: got %d, want %d", len(observed), 1)
+	}
+
+	if n := len(queryLog.Queries()); n != 1 {
+		t.Errorf("Enclosing query count mismatch: got %d, want %d", n, 1)
+	}
+}
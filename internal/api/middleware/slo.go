@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// SLO passes the endpoint, status and latency of every request to record once it completes,
+// endpoints being named after the first path segment following the API version such as explore
+func SLO(next http.Handler, record func(endpoint string, status int, latency time.Duration)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		record(endpoint(r.URL.Path), sw.status, time.Since(start))
+	})
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLO(t *testing.T) {
+	var gotEndpoint string
+	var gotStatus int
+	handler := SLO(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}), func(endpoint string, status int, latency time.Duration) {
+		gotEndpoint, gotStatus = endpoint, status
+	})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/explore/bucket/dir/", nil))
+
+	if gotEndpoint != "explore" || gotStatus != http.StatusInternalServerError {
+		t.Errorf("Recorded request mismatch: got %s %d", gotEndpoint, gotStatus)
+	}
+}
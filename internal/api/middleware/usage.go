@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// AnonymousConsumer is the consumer of requests without an identity
+const AnonymousConsumer = "anonymous"
+
+// HeaderIdentity identifies consumers by a header set by an authenticating proxy in front of the API,
+// such as X-Goog-Authenticated-User-Email set by Identity-Aware Proxy
+// The header must not be settable by clients reaching the API directly
+func HeaderIdentity(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if identity := r.Header.Get(header); len(identity) > 0 {
+			return identity
+		}
+		return AnonymousConsumer
+	}
+}
+
+// Usage passes the consumer, bytes written and latency of every request to record once it completes
+func Usage(next http.Handler, identify func(r *http.Request) string, record func(consumer string, bytes int64, latency time.Duration)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(cw, r)
+		record(identify(r), cw.bytes, time.Since(start))
+	})
+}
+
+// countingWriter counts the bytes written to the response body
+type countingWriter struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.bytes += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
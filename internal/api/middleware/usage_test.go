@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUsage(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mock"))
+		w.Write([]byte(" body"))
+	})
+
+	type recorded struct {
+		consumer string
+		bytes    int64
+	}
+	var got []recorded
+	handler := Usage(next, HeaderIdentity("X-Goog-Authenticated-User-Email"), func(consumer string, bytes int64, latency time.Duration) {
+		got = append(got, recorded{consumer, bytes})
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Goog-Authenticated-User-Email", "accounts.google.com:mock@example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := []recorded{
+		{"accounts.google.com:mock@example.com", 9},
+		{AnonymousConsumer, 9},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Recorded count mismatch: got %d, want %d", len(got), len(want))
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Request %d mismatch: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
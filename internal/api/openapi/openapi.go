@@ -0,0 +1,230 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const specVersion = "3.0.3"
+
+// Route describes a single handler registered on the router
+// Pattern uses http.ServeMux syntax, e.g. "GET /explore/{path...}"
+type Route struct {
+	Pattern     string
+	Summary     string
+	Query       []Parameter
+	RequestBody any // zero value of the request body type, nil if none
+	Response    any // zero value of the response body type
+}
+
+type Parameter struct {
+	Name        string
+	Description string
+	Type        string
+	Enum        []string
+}
+
+// Spec accumulates routes and renders them as an OpenAPI document
+type Spec struct {
+	title   string
+	version string
+
+	mu    sync.Mutex
+	paths map[string]map[string]any
+}
+
+func New(title string, version string) *Spec {
+	return &Spec{
+		title:   title,
+		version: version,
+		paths:   make(map[string]map[string]any),
+	}
+}
+
+// Add registers a route's operation in the spec
+func (s *Spec) Add(route Route) {
+	method, path := splitPattern(route.Pattern)
+
+	var parameters []map[string]any
+	for _, name := range pathParams(route.Pattern) {
+		parameters = append(parameters, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	for _, p := range route.Query {
+		schema := map[string]any{"type": p.Type}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		parameters = append(parameters, map[string]any{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      schema,
+		})
+	}
+
+	operation := map[string]any{
+		"summary": route.Summary,
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": SchemaOf(route.Response)},
+				},
+			},
+			"400": map[string]any{"description": "Invalid request"},
+			"500": map[string]any{"description": "Internal error"},
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if route.RequestBody != nil {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": SchemaOf(route.RequestBody)},
+			},
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.paths[path]; !ok {
+		s.paths[path] = make(map[string]any)
+	}
+	s.paths[path][strings.ToLower(method)] = operation
+}
+
+// Document returns the OpenAPI document as a JSON-serializable map
+func (s *Spec) Document() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{
+		"openapi": specVersion,
+		"info": map[string]any{
+			"title":   s.title,
+			"version": s.version,
+		},
+		"paths": s.paths,
+	}
+}
+
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: remove in production
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Document()); err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// splitPattern converts a ServeMux pattern into its method and OpenAPI path
+// Wildcards such as {path...} become {path}, and the {$} anchoring a path to its trailing slash is dropped
+func splitPattern(pattern string) (string, string) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		return "GET", strings.TrimSuffix(pattern, "{$}")
+	}
+	return method, strings.ReplaceAll(strings.TrimSuffix(path, "{$}"), "...}", "}")
+}
+
+// pathParams returns the names of every wildcard in a ServeMux pattern
+func pathParams(pattern string) []string {
+	var names []string
+	for {
+		start := strings.Index(pattern, "{")
+		if start == -1 {
+			return names
+		}
+		end := strings.Index(pattern[start:], "}")
+		if end == -1 {
+			return names
+		}
+		if name := pattern[start+1 : start+end]; name != "$" {
+			names = append(names, strings.TrimSuffix(name, "..."))
+		}
+		pattern = pattern[start+end+1:]
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf derives a JSON schema from a value's type using its json struct tags
+func SchemaOf(v any) map[string]any {
+	if v == nil {
+		return map[string]any{}
+	}
+	return schemaOf(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+// schemaOf recursively builds a schema, describing already visited structs as plain objects to break cycles
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return map[string]any{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+
+			// Untagged embedded structs are flattened by encoding/json
+			if field.Anonymous && len(name) == 0 {
+				embedded := schemaOf(field.Type, visiting)
+				if props, ok := embedded["properties"].(map[string]any); ok {
+					for k, v := range props {
+						properties[k] = v
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if len(name) == 0 {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type, visiting)
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,110 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplitPattern(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		wantMethod string
+		wantPath   string
+	}{
+		{"Method and path", "POST /simulate/lifecycle", "POST", "/simulate/lifecycle"},
+		{"Trailing wildcard", "GET /explore/{path...}", "GET", "/explore/{path}"},
+		{"Missing method", "/openapi.json", "GET", "/openapi.json"},
+		{"Anchored trailing slash", "GET /grafana/{$}", "GET", "/grafana/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method, path := splitPattern(tc.in)
+			if method != tc.wantMethod || path != tc.wantPath {
+				t.Errorf("Pattern mismatch: got %s %s, want %s %s", method, path, tc.wantMethod, tc.wantPath)
+			}
+		})
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	type embedded struct {
+		Inner string `json:"inner"`
+	}
+	type mock struct {
+		embedded
+		Name     string            `json:"name"`
+		Size     int64             `json:"size"`
+		Cost     float64           `json:"cost"`
+		Created  time.Time         `json:"created"`
+		Children []*mock           `json:"children"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Ignored  string            `json:"-"`
+		private  string
+	}
+
+	got := SchemaOf(mock{})
+	properties := got["properties"].(map[string]any)
+
+	wantTypes := map[string]string{
+		"inner":    "string",
+		"name":     "string",
+		"size":     "integer",
+		"cost":     "number",
+		"created":  "string",
+		"children": "array",
+		"labels":   "object",
+	}
+
+	if len(properties) != len(wantTypes) {
+		t.Fatalf("Property count mismatch: got %d, want %d", len(properties), len(wantTypes))
+	}
+
+	for name, wantType := range wantTypes {
+		prop, ok := properties[name].(map[string]any)
+		if !ok {
+			t.Errorf("Missing property %s", name)
+			continue
+		}
+		if prop["type"] != wantType {
+			t.Errorf("%s type mismatch: got %v, want %s", name, prop["type"], wantType)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	spec := New("mock", "1.0.0")
+	spec.Add(Route{
+		Pattern:  "GET /explore/{path...}",
+		Query:    []Parameter{{Name: "sort", Type: "string"}},
+		Response: struct{}{},
+	})
+
+	rr := httptest.NewRecorder()
+	spec.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status code mismatch: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var doc struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	operation, ok := doc.Paths["/explore/{path}"]["get"]
+	if !ok {
+		t.Fatalf("Missing operation for GET /explore/{path}")
+	}
+
+	if params := operation["parameters"].([]any); len(params) != 2 {
+		t.Errorf("Parameter count mismatch: got %d, want %d", len(params), 2)
+	}
+}
@@ -1,20 +1,313 @@
 package router
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/handler"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/openapi"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
 )
 
-func New(db *repo.Database) *http.ServeMux {
+const (
+	apiTitle   = "GCS Metadata Server"
+	apiVersion = "1.0.0"
+)
+
+// fieldsParam documents the partial response selector accepted by every JSON endpoint
+var fieldsParam = openapi.Parameter{
+	Name:        "fields",
+	Description: "Comma separated list of response fields to include, e.g. path,contents(name,size), or row fields such as name,size with format=ndjson",
+	Type:        "string",
+}
+
+// formatParam documents the output format negotiation accepted by every endpoint
+var formatParam = openapi.Parameter{
+	Name:        "format",
+	Description: "Response format, overriding the Accept header, ndjson streaming one row per line and parquet encoding rows as a Parquet file",
+	Type:        "string",
+	Enum:        []string{"json", "csv", "ndjson", "parquet"},
+}
+
+// displayParams document the formatting of values for people accepted by every endpoint
+var displayParams = []openapi.Parameter{
+	{Name: "units", Description: "Render sizes with binary (KiB, MiB) or decimal (kB, MB) units rather than raw bytes", Type: "string", Enum: []string{"raw", "binary", "decimal"}},
+	{Name: "tz", Description: "IANA time zone to render timestamps in rather than UTC, e.g. Europe/Paris", Type: "string"},
+	{Name: "numbers", Description: "Separate the thousands of counts and other integers with commas", Type: "string", Enum: []string{"raw", "grouped"}},
+}
+
+// filterParams document the server side filters accepted by listing endpoints
+var filterParams = []openapi.Parameter{
+	{Name: "min_size", Description: "Minimum size in bytes of the listed objects and directories", Type: "integer"},
+	{Name: "max_size", Description: "Maximum size in bytes of the listed objects and directories", Type: "integer"},
+	{Name: "updated_after", Description: "Only list objects updated after this RFC 3339 time or YYYY-MM-DD date", Type: "string"},
+	{Name: "updated_before", Description: "Only list objects updated before this RFC 3339 time or YYYY-MM-DD date", Type: "string"},
+	{Name: "storage_class", Description: "Comma separated storage classes of the listed objects", Type: "string"},
+}
+
+// New routes the API over db, looking objects missing from it up with fetcher unless nil
+func New(db *repo.Database, fetcher handler.ObjectFetcher) *http.ServeMux {
 	mux := http.NewServeMux()
+	spec := openapi.New(apiTitle, apiVersion)
+
+	// handle registers a handler under an API version and documents it in the OpenAPI spec
+	// Routes of the legacy version are also served unversioned with deprecation headers
+	handle := func(version string, route openapi.Route, handlerFunc http.HandlerFunc) {
+		if version == legacyVersion {
+			mux.Handle(route.Pattern, deprecated(version, handlerFunc))
+		}
+
+		route.Pattern = versionPattern(version, route.Pattern)
+		route.Query = append(append(route.Query, fieldsParam, formatParam), displayParams...)
+		mux.HandleFunc(route.Pattern, handlerFunc)
+		spec.Add(route)
+	}
+
+	// consistent serves a read-only handler issuing several queries from a single snapshot of the database,
+	// listings are left out as their pages are read from snapshots of their own
+	consistent := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.ConsistentReads(db, h)
+	}
 
 	exploreRepo := repo.NewExploreRepository(db)
 	exploreHandler := handler.NewExploreHandler(exploreRepo)
 
-	mux.HandleFunc("GET /explore/{path...}", exploreHandler.HandleExplore)
-	mux.HandleFunc("GET /summary/{path...}", exploreHandler.HandleSummary)
+	handle(V1, openapi.Route{
+		Pattern: "GET /explore/{path...}",
+		Summary: "List the immediate contents of a directory",
+		Query: append([]openapi.Parameter{
+			{Name: "sort", Description: "Sort contents by size or count", Type: "string", Enum: []string{string(repo.SortBySize), string(repo.SortByCount)}},
+			{Name: "page_size", Description: "Number of entries per page, enables snapshot consistent pagination", Type: "integer"},
+			{Name: "page_token", Description: "Token of the next page returned by the previous page", Type: "string"},
+			{Name: "include_markers", Description: "List directory marker objects and count them in directories", Type: "boolean"},
+		}, filterParams...),
+		Response: model.PathContents{},
+	}, exploreHandler.HandleExplore)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /top/directories",
+		Summary: "List the largest directories, read from a ranking maintained as directories change",
+		Query: []openapi.Parameter{
+			{Name: "bucket", Description: "Bucket to rank the directories of, every bucket by default", Type: "string"},
+			{Name: "n", Description: "Number of directories listed, at most 100", Type: "integer"},
+		},
+		Response: model.LargestDirectories{},
+	}, exploreHandler.HandleLargestDirectories)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /search/{path...}",
+		Summary: "Search the objects under a directory whose name contains a string",
+		Query: append([]openapi.Parameter{
+			{Name: "q", Description: "String the object names contain", Type: "string"},
+			{Name: "collation", Description: "Compare names byte for byte, ignoring ASCII case, or folding Unicode case and normalization", Type: "string", Enum: []string{string(repo.CollationBinary), string(repo.CollationNoCase), string(repo.CollationUnicode)}},
+			{Name: "limit", Description: "Maximum number of objects returned", Type: "integer"},
+		}, filterParams...),
+		Response: model.SearchResults{},
+	}, exploreHandler.HandleSearch)
+
+	handle(V1, openapi.Route{
+		Pattern:  "GET /summary/{path...}",
+		Summary:  "Summarize the size and cost of a directory per storage class",
+		Response: model.Summary{},
+	}, consistent(exploreHandler.HandleSummary))
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /count/{path...}",
+		Summary: "Count the objects under a directory and their size, exactly or approximately from a sample of objects",
+		Query: append([]openapi.Parameter{
+			{Name: "approximate", Description: fmt.Sprintf("Extrapolate from the one in %d objects sampled, with 95%% error margins, instead of scanning every object", repo.SampleRate), Type: "boolean"},
+			{Name: "include_markers", Description: "Count directory marker objects", Type: "boolean"},
+		}, filterParams...),
+		Response: model.ObjectCount{},
+	}, exploreHandler.HandleCount)
+
+	objectHandler := handler.NewObjectHandler(repo.NewMetadataRepository(db), fetcher)
+
+	handle(V1, openapi.Route{
+		Pattern:  "GET /objects/{bucket}/{name...}",
+		Summary:  "Get the metadata of an object, looked up in GCS if not indexed yet when the fallback is enabled",
+		Response: model.Metadata{},
+	}, objectHandler.HandleGetObject)
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /stat",
+		Summary:     fmt.Sprintf("Get the metadata of up to %d objects named bucket/name at once, marking the missing ones", handler.MaxStatPaths),
+		RequestBody: model.StatRequest{},
+		Response:    model.StatResults{},
+	}, objectHandler.HandleStat)
+
+	comparisonHandler := handler.NewComparisonHandler(repo.NewComparisonRepository(db))
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /compare",
+		Summary: "Compare the objects under a prefix of two buckets by name and size, listing those missing on either side or differing",
+		Query: []openapi.Parameter{
+			{Name: "bucketA", Description: "First bucket, such as the source of a replication", Type: "string"},
+			{Name: "bucketB", Description: "Second bucket, such as the destination of a replication", Type: "string"},
+			{Name: "prefix", Description: "Prefix compared, in bucketA and in bucketB unless prefixB is set", Type: "string"},
+			{Name: "prefixB", Description: "Prefix of bucketB matched with the prefix of bucketA", Type: "string"},
+			{Name: "limit", Description: fmt.Sprintf("Maximum number of differences listed, at most %d, all of them being counted", repo.MaxComparisonDifferences), Type: "integer"},
+		},
+		Response: model.BucketComparison{},
+	}, consistent(comparisonHandler.HandleCompare))
+
+	lifecycleRepo := repo.NewLifecycleRepository(db)
+	lifecycleHandler := handler.NewLifecycleHandler(lifecycleRepo)
+
+	handle(V1, openapi.Route{
+		Pattern: "POST /simulate/lifecycle",
+		Summary: "Preview the objects a lifecycle policy would transition or delete",
+		Query: []openapi.Parameter{
+			{Name: "bucket", Description: "Bucket the policy applies to", Type: "string"},
+			{Name: "prefix", Description: "Prefix to evaluate the policy under", Type: "string"},
+		},
+		RequestBody: model.LifecyclePolicy{},
+		Response:    model.LifecycleSimulationResult{},
+	}, lifecycleHandler.HandleSimulate)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /stats/age",
+		Summary: "Count the objects under a prefix per age in days, driving lifecycle rules on custom times",
+		Query: []openapi.Parameter{
+			{Name: "bucket", Description: "Bucket to count objects of", Type: "string"},
+			{Name: "prefix", Description: "Prefix to count objects under", Type: "string"},
+			{Name: "by", Description: "Timestamp the age is computed from", Type: "string", Enum: []string{string(repo.AgeByCustomTime), string(repo.AgeByCreated), string(repo.AgeByUpdated)}},
+		},
+		Response: model.AgeHistogram{},
+	}, lifecycleHandler.HandleAgeHistogram)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /recommendations",
+		Summary: "Recommend a lifecycle rule per child prefix moving objects old enough, and not read recently if access logs are ingested, to a colder storage class, with the monthly savings",
+		Query: []openapi.Parameter{
+			{Name: "prefix", Description: "Prefix whose child prefixes get recommendations", Type: "string"},
+		},
+		Response: model.LifecycleRecommendations{},
+	}, consistent(lifecycleHandler.HandleRecommendations))
+
+	queryRepo := repo.NewQueryRepository(db)
+	queryHandler := handler.NewQueryHandler(queryRepo)
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /query",
+		Summary:     "Run a restricted SELECT statement over the columns " + strings.Join(query.Columns(), ", "),
+		RequestBody: model.QueryRequest{},
+		Response:    model.QueryResult{},
+	}, queryHandler.HandleQuery)
+
+	statsRepo := repo.NewStatsRepository(db)
+	statsHandler := handler.NewStatsHandler(statsRepo)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /stats/writes",
+		Summary: "Count the writes applied per top level prefix over a rolling window",
+		Query: []openapi.Parameter{
+			{Name: "window", Description: "Duration of the window, e.g. 15m, up to 24h", Type: "string"},
+		},
+		Response: model.WriteStats{},
+	}, statsHandler.HandleWriteStats)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /stats/lag",
+		Summary: "Measure how late object notifications arrived after their writes per bucket and top level prefix, bounding the replication of dual-region buckets",
+		Query: []openapi.Parameter{
+			{Name: "window", Description: "Duration of the window, e.g. 15m, up to 24h", Type: "string"},
+		},
+		Response: model.NotificationLag{},
+	}, statsHandler.HandleNotificationLag)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /stats/events",
+		Summary: "Count the object notifications applied per bucket and hour by event type, showing churn patterns",
+		Query: []openapi.Parameter{
+			{Name: "window", Description: "Duration of the window, e.g. 72h, from 1h up to 168h", Type: "string"},
+			{Name: "bucket", Description: "Bucket to count the events of, every bucket by default", Type: "string"},
+		},
+		Response: model.EventStats{},
+	}, statsHandler.HandleEventStats)
+
+	reservationHandler := handler.NewReservationHandler(repo.NewReservationRepository(db))
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /reserve",
+		Summary:     "Reserve the bytes about to be written under a prefix, granted if they fit in the budgets of the prefix and its parents",
+		RequestBody: model.ReservationRequest{},
+		Response:    model.Reservation{},
+	}, reservationHandler.HandleReserve)
+
+	handle(V1, openapi.Route{
+		Pattern: "DELETE /reserve/{id}",
+		Summary: "Release a reservation before it expires",
+	}, reservationHandler.HandleRelease)
+
+	aclRepo := repo.NewACLRepository(db)
+	aclHandler := handler.NewACLHandler(aclRepo)
+
+	handle(V1, openapi.Route{
+		Pattern:  "GET /reports/acl/{path...}",
+		Summary:  "List the prefixes containing objects granting access through object ACLs",
+		Response: model.ACLReport{},
+	}, aclHandler.HandleACLReport)
+
+	popularityHandler := handler.NewPopularityHandler(repo.NewPopularityRepository(db))
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /reports/cold/{path...}",
+		Summary: "List the largest objects and directories under a path not read since a date, from ingested access logs",
+		Query: []openapi.Parameter{
+			{Name: "since", Description: "RFC 3339 timestamp or YYYY-MM-DD date entries weren't read since, entries never read by default", Type: "string"},
+			{Name: "limit", Description: fmt.Sprintf("Maximum number of entries returned, at most %d", repo.MaxColdEntries), Type: "integer"},
+		},
+		Response: model.ColdReport{},
+	}, popularityHandler.HandleColdReport)
+
+	historyRepo := repo.NewHistoryRepository(db)
+	historyHandler := handler.NewHistoryHandler(historyRepo)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /diff",
+		Summary: "Compare the size and count of every child directory of a prefix between two points in time",
+		Query: []openapi.Parameter{
+			{Name: "prefix", Description: "Prefix whose child directories are compared", Type: "string"},
+			{Name: "from", Description: "RFC 3339 start of the comparison", Type: "string"},
+			{Name: "to", Description: "RFC 3339 end of the comparison, defaults to now", Type: "string"},
+		},
+		Response: model.DirectoryDiff{},
+	}, historyHandler.HandleDiff)
+
+	// Grafana JSON datasource, configured with the /v1/grafana URL
+	grafanaHandler := handler.NewGrafanaHandler(exploreRepo, historyRepo)
+
+	handle(V1, openapi.Route{
+		Pattern: "GET /grafana/{$}",
+		Summary: "Test the Grafana datasource connection",
+	}, grafanaHandler.HandleTest)
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /grafana/search",
+		Summary:     "List the Grafana targets of the child directories of a directory",
+		RequestBody: model.GrafanaSearchRequest{},
+		Response:    []string{},
+	}, grafanaHandler.HandleSearch)
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /grafana/query",
+		Summary:     "Query the size or count of directories over time for Grafana",
+		RequestBody: model.GrafanaQueryRequest{},
+		Response:    []model.GrafanaTimeSeries{},
+	}, consistent(grafanaHandler.HandleQuery))
+
+	handle(V1, openapi.Route{
+		Pattern:     "POST /grafana/annotations",
+		Summary:     "Annotate the largest hourly size changes of a directory for Grafana",
+		RequestBody: model.GrafanaAnnotationRequest{},
+		Response:    []model.GrafanaAnnotation{},
+	}, grafanaHandler.HandleAnnotations)
+
+	mux.Handle("GET /openapi.json", spec)
 
 	return mux
 }
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	mux := New(db, nil)
+
+	testCases := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantDeprecated bool
+		wantLink       string
+	}{
+		{
+			"Versioned explore route",
+			"/v1/explore/mock/?sort=count",
+			http.StatusOK,
+			false,
+			"",
+		},
+		{
+			"Versioned summary route",
+			"/v1/summary/mock/",
+			http.StatusOK,
+			false,
+			"",
+		},
+		{
+			"Legacy explore route is deprecated",
+			"/explore/mock/?sort=count",
+			http.StatusOK,
+			true,
+			`</v1/explore/mock/?sort=count>; rel="successor-version"`,
+		},
+		{
+			"Legacy summary route is deprecated",
+			"/summary/mock/",
+			http.StatusOK,
+			true,
+			`</v1/summary/mock/>; rel="successor-version"`,
+		},
+		{
+			"Grafana datasource test route",
+			"/v1/grafana/",
+			http.StatusOK,
+			false,
+			"",
+		},
+		{
+			"Grafana datasource test route only matches itself",
+			"/v1/grafana/unknown",
+			http.StatusNotFound,
+			false,
+			"",
+		},
+		{
+			"Unknown version",
+			"/v0/explore/mock/",
+			http.StatusNotFound,
+			false,
+			"",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Fatalf("status code mismatch: got %v want %v", status, tc.wantStatus)
+			}
+
+			if gotDeprecated := rr.Header().Get("Deprecation") == "true"; gotDeprecated != tc.wantDeprecated {
+				t.Errorf("Deprecation header mismatch: got %v want %v", gotDeprecated, tc.wantDeprecated)
+			}
+
+			if link := rr.Header().Get("Link"); link != tc.wantLink {
+				t.Errorf("Link header mismatch: got %s want %s", link, tc.wantLink)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	V1 = "/v1"
+
+	// legacyVersion is served from unversioned routes for clients predating API versioning
+	legacyVersion = V1
+)
+
+// versionPattern prefixes the path of a ServeMux pattern with an API version
+func versionPattern(version string, pattern string) string {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		return version + pattern
+	}
+	return method + " " + version + path
+}
+
+// deprecated marks responses from a legacy route and points clients at its versioned successor
+func deprecated(version string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := version + r.URL.EscapedPath()
+		if len(r.URL.RawQuery) > 0 {
+			successor += "?" + r.URL.RawQuery
+		}
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,198 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	// StateClosed lets every call through
+	StateClosed State = "closed"
+	// StateOpen rejects every call until the open timeout elapses
+	StateOpen State = "open"
+	// StateHalfOpen lets a single probe call through to decide whether to close again
+	StateHalfOpen State = "half-open"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// breakers exposes the state of every breaker under /debug/vars
+var breakers = expvar.NewMap("circuit_breakers")
+
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// MaxRetries is the number of retries of a failed call before giving up
+	MaxRetries int
+	// BaseBackoff is the backoff before the first retry and the first open timeout, both double on every attempt
+	BaseBackoff time.Duration
+	// MaxBackoff caps retry backoffs and open timeouts
+	MaxBackoff time.Duration
+	// Retryable reports whether a failed call may succeed if retried, all errors are retried if nil
+	Retryable func(error) bool
+}
+
+// DefaultConfig suits calls to Google Cloud APIs
+var DefaultConfig = Config{
+	FailureThreshold: 5,
+	MaxRetries:       3,
+	BaseBackoff:      500 * time.Millisecond,
+	MaxBackoff:       time.Minute,
+}
+
+// Breaker stops calling a failing dependency, retrying failed calls with exponential backoff and jitter
+// It opens after consecutive failures and is probed again after an open timeout which grows with every trip
+type Breaker struct {
+	cfg Config
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	trips     int
+	openUntil time.Time
+	probing   bool
+	now       func() time.Time
+	sleep     func(context.Context, time.Duration) error
+}
+
+// New returns a closed breaker, publishing its state as name in the circuit_breakers expvar
+func New(name string, cfg Config) *Breaker {
+	b := &Breaker{
+		cfg:   cfg,
+		state: StateClosed,
+		now:   time.Now,
+		sleep: sleep,
+	}
+	breakers.Set(name, expvar.Func(b.stats))
+	return b
+}
+
+// Do calls op, retrying it while it fails with a retryable error
+// It returns ErrOpen without calling op if the breaker is open
+func (b *Breaker) Do(ctx context.Context, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := b.allow(); err != nil {
+			return err
+		}
+
+		err := op()
+		if ctx.Err() != nil {
+			b.release()
+			return err // cancelled calls say nothing about the dependency
+		}
+		b.record(err)
+
+		if err == nil || !b.retryable(err) || attempt >= b.cfg.MaxRetries {
+			return err
+		}
+
+		if err := b.sleep(ctx, b.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && !b.now().Before(b.openUntil) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Before(b.openUntil) {
+			return ErrOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+	case StateHalfOpen:
+		if b.probing {
+			return ErrOpen // a probe is already in flight
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// release ends a probe whose outcome is unknown
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || !b.retryable(err) {
+		b.state = StateClosed
+		b.failures = 0
+		b.trips = 0
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.state = StateOpen
+		b.openUntil = b.now().Add(b.cap(b.cfg.BaseBackoff << b.trips))
+		b.trips++
+	}
+}
+
+func (b *Breaker) retryable(err error) bool {
+	return b.cfg.Retryable == nil || b.cfg.Retryable(err)
+}
+
+// backoff returns a random duration up to the exponential backoff of attempt
+func (b *Breaker) backoff(attempt int) time.Duration {
+	d := b.cap(b.cfg.BaseBackoff << attempt)
+	return time.Duration(rand.Int64N(int64(d)) + 1)
+}
+
+// cap limits d to MaxBackoff, handling shifts that overflowed
+func (b *Breaker) cap(d time.Duration) time.Duration {
+	if d <= 0 || d > b.cfg.MaxBackoff {
+		return b.cfg.MaxBackoff
+	}
+	return d
+}
+
+func (b *Breaker) stats() any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]any{
+		"state":    b.state,
+		"failures": b.failures,
+		"trips":    b.trips,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
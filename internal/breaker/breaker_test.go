@@ -0,0 +1,127 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errMock = errors.New("mock")
+
+func newTestBreaker(cfg Config) (*Breaker, *time.Time) {
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	b := New("test", cfg)
+	b.now = func() time.Time { return now }
+	b.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	return b, &now
+}
+
+func TestDo(t *testing.T) {
+	permanent := errors.New("permanent")
+
+	testCases := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+		wantState State
+	}{
+		{"Succeeds on first call", []error{nil}, 1, nil, StateClosed},
+		{"Retries until success", []error{errMock, errMock, nil}, 3, nil, StateClosed},
+		{"Gives up after max retries", []error{errMock, errMock, errMock, errMock}, 4, errMock, StateClosed},
+		{"Does not retry permanent errors", []error{permanent}, 1, permanent, StateClosed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := newTestBreaker(Config{
+				FailureThreshold: 10,
+				MaxRetries:       3,
+				BaseBackoff:      time.Second,
+				MaxBackoff:       time.Minute,
+				Retryable:        func(err error) bool { return !errors.Is(err, permanent) },
+			})
+
+			var calls int
+			err := b.Do(context.Background(), func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Error mismatch: got %v, want %v", err, tc.wantErr)
+			}
+
+			if calls != tc.wantCalls {
+				t.Errorf("Calls mismatch: got %d, want %d", calls, tc.wantCalls)
+			}
+
+			if got := b.State(); got != tc.wantState {
+				t.Errorf("State mismatch: got %s, want %s", got, tc.wantState)
+			}
+		})
+	}
+}
+
+func TestTrip(t *testing.T) {
+	b, now := newTestBreaker(Config{
+		FailureThreshold: 2,
+		MaxRetries:       0,
+		BaseBackoff:      time.Second,
+		MaxBackoff:       time.Minute,
+	})
+
+	failing := func() error { return errMock }
+	ctx := context.Background()
+
+	// Consecutive failures open the breaker
+	b.Do(ctx, failing)
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("State mismatch: got %s, want %s", got, StateClosed)
+	}
+	b.Do(ctx, failing)
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("State mismatch: got %s, want %s", got, StateOpen)
+	}
+
+	// Calls are rejected while open
+	var called bool
+	if err := b.Do(ctx, func() error { called = true; return nil }); !errors.Is(err, ErrOpen) || called {
+		t.Fatalf("Expected ErrOpen without calling op, got %v", err)
+	}
+
+	// A failed probe opens the breaker for twice as long
+	*now = now.Add(time.Second)
+	if got := b.State(); got != StateHalfOpen {
+		t.Fatalf("State mismatch: got %s, want %s", got, StateHalfOpen)
+	}
+	b.Do(ctx, failing)
+
+	*now = now.Add(time.Second)
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("Expected open timeout to double, got %s", got)
+	}
+
+	// A successful probe closes the breaker
+	*now = now.Add(time.Second)
+	if err := b.Do(ctx, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("State mismatch: got %s, want %s", got, StateClosed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b, _ := newTestBreaker(Config{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	for attempt := range 70 {
+		d := b.backoff(attempt)
+		if d <= 0 || d > 10*time.Second {
+			t.Fatalf("Backoff of attempt %d out of range: %v", attempt, d)
+		}
+	}
+}
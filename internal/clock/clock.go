@@ -0,0 +1,43 @@
+// Package clock tells the time, so tests can advance it instead of sleeping
+package clock
+
+import "time"
+
+// Clock tells the time and waits for it
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker sending the time every d, dropping ticks a slow receiver misses
+	NewTicker(d time.Duration) Ticker
+	// After sends the time once d elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	after := f.After(time.Minute)
+	ticker := f.NewTicker(20 * time.Second)
+	f.BlockUntil(2)
+
+	f.Advance(30 * time.Second)
+	if got := f.Now(); !got.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("Now mismatch: got %v, want %v", got, start.Add(30*time.Second))
+	}
+
+	select {
+	case <-after:
+		t.Fatal("Expected After not to fire before its duration")
+	default:
+	}
+
+	if got := <-ticker.C(); !got.Equal(start.Add(20 * time.Second)) {
+		t.Errorf("Tick mismatch: got %v, want %v", got, start.Add(20*time.Second))
+	}
+
+	// Ticks the receiver misses are dropped
+	f.Advance(time.Minute)
+	if got := <-after; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("After mismatch: got %v, want %v", got, start.Add(time.Minute))
+	}
+	if got := <-ticker.C(); !got.Equal(start.Add(40 * time.Second)) {
+		t.Errorf("Tick mismatch: got %v, want %v", got, start.Add(40*time.Second))
+	}
+	select {
+	case got := <-ticker.C():
+		t.Errorf("Expected missed ticks to be dropped, got %v", got)
+	default:
+	}
+
+	// Stopped tickers no longer tick
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case got := <-ticker.C():
+		t.Errorf("Expected stopped ticker not to tick, got %v", got)
+	default:
+	}
+
+	select {
+	case <-f.After(0):
+	default:
+		t.Error("Expected After of 0 to fire immediately")
+	}
+}
@@ -0,0 +1,127 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock which only moves when advanced
+type Fake struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After or a running ticker
+type waiter struct {
+	when   time.Time
+	period time.Duration // 0 for After
+	c      chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.add(&waiter{when: f.now.Add(d), c: c})
+	return c
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{when: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.add(w)
+	return &fakeTicker{f, w}
+}
+
+func (f *Fake) add(w *waiter) {
+	f.waiters = append(f.waiters, w)
+	f.changed.Broadcast()
+}
+
+func (f *Fake) remove(w *waiter) {
+	for i, pending := range f.waiters {
+		if pending == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing every After and ticker due by then in time order
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].when.Before(f.waiters[j].when) })
+		if len(f.waiters) == 0 || f.waiters[0].when.After(end) {
+			break
+		}
+
+		w := f.waiters[0]
+		f.now = w.when
+		select {
+		case w.c <- f.now:
+		default: // the receiver missed the previous tick
+		}
+
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			f.remove(w)
+		}
+	}
+	f.now = end
+}
+
+// BlockUntil waits until n Afters and tickers are pending, so a goroutine waiting on the clock is not advanced past
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	waiter *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.remove(t.waiter)
+}
@@ -0,0 +1,293 @@
+// Package doctor diagnoses deployments of the servers, checking the database, the indexed buckets and their
+// notifications, the Pub/Sub subscription delivering them and the clock, and tells how to fix what fails
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/ingest"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"google.golang.org/api/googleapi"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// Check diagnoses a part of the deployment, returning what it found or a Problem
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Problem is a failed check and the action fixing it
+type Problem struct {
+	Err error
+	Fix string
+}
+
+func (p *Problem) Error() string {
+	return p.Err.Error()
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// problem fails a check with the error formatted from format and args, fixed by fix
+func problem(fix string, format string, args ...any) error {
+	return &Problem{Err: fmt.Errorf(format, args...), Fix: fix}
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name   string
+	OK     bool
+	Detail string
+	Fix    string
+}
+
+// Run runs checks in order, every check running even if earlier ones failed
+func Run(ctx context.Context, checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		detail, err := check.Run(ctx)
+		result := Result{Name: check.Name, OK: err == nil, Detail: detail}
+		if err != nil {
+			result.Detail = err.Error()
+			var p *Problem
+			if errors.As(err, &p) {
+				result.Fix = p.Fix
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Write prints a line per result, followed by the fix of failures, and returns how many failed
+func Write(w io.Writer, results []Result) (int, error) {
+	var b strings.Builder
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if !r.OK {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(&b, "%-4s  %s: %s\n", status, r.Name, r.Detail)
+		if len(r.Fix) > 0 {
+			fmt.Fprintf(&b, "      fix: %s\n", r.Fix)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d checks, %d failed\n", len(results), failed)
+
+	_, err := io.WriteString(w, b.String())
+	return failed, err
+}
+
+// statusCode returns the HTTP status of a failed Google API call, 0 if it did not fail with one
+func statusCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// Database checks that the database is initialized, at the schema version of this binary and writable
+func Database(db *repo.Database) Check {
+	return Check{Name: "database", Run: func(ctx context.Context) (string, error) {
+		exists, err := db.PingTable()
+		if err != nil {
+			return "", problem("Check that --database-url names the database file, readable by the servers", "error reading database: %v", err)
+		}
+		if !exists {
+			return "", problem("Create the tables by running the seeder, or restore a snapshot checked by verify-backup", "database has not been initialized")
+		}
+
+		version, pending, err := db.SchemaStatus(ctx)
+		if err != nil {
+			return "", err
+		}
+		if version > repo.SchemaVersion {
+			return "", problem("Upgrade the servers to the version which migrated the database", "database is at schema version %d, this binary supports up to version %d", version, repo.SchemaVersion)
+		}
+		if len(pending) > 0 {
+			return "", problem("Start the API with --schema-policy migrate, or run the seeder, to apply them", "database is at schema version %d, missing migrations %s", version, strings.Join(pending, ", "))
+		}
+
+		if err := db.CheckWritable(ctx); err != nil {
+			return "", problem("Make the database file and its directory writable by the servers, and stop processes holding its write lock", "database is not writable: %v", err)
+		}
+		return fmt.Sprintf("schema version %d, writable", repo.SchemaVersion), nil
+	}}
+}
+
+// Bucket checks that the bucket exists and GCS can be reached and read
+func Bucket(client *storage.Client, bucket string) Check {
+	return Check{Name: "bucket gs://" + bucket, Run: func(ctx context.Context) (string, error) {
+		attrs, err := client.Bucket(bucket).Attrs(ctx)
+		switch {
+		case errors.Is(err, storage.ErrBucketNotExist):
+			return "", problem("Check the bucket name, buckets being registered without their gs:// scheme", "bucket %s does not exist", bucket)
+		case statusCode(err) == http.StatusForbidden || statusCode(err) == http.StatusUnauthorized:
+			return "", problem("Grant roles/storage.legacyBucketReader and roles/storage.objectViewer on the bucket to the service account, as listed by print-resources", "not allowed to read bucket %s: %v", bucket, err)
+		case err != nil:
+			return "", problem("Check that storage.googleapis.com is reachable, through Private Google Access from private networks", "error reaching GCS: %v", err)
+		}
+		return fmt.Sprintf("%s %s", strings.ToLower(attrs.LocationType), attrs.Location), nil
+	}}
+}
+
+// indexedEvents are the event types the index drifts without
+var indexedEvents = []string{string(ingest.EventFinalize), string(ingest.EventDelete)}
+
+// Notifications checks that the bucket notifies the topic, or any topic if empty, of the events the index needs
+// in a payload format it decodes
+// topic is read once the check runs, so it may be filled by the Subscription check running before
+func Notifications(client *storage.Client, bucket string, topic *string) Check {
+	return Check{Name: "notifications gs://" + bucket, Run: func(ctx context.Context) (string, error) {
+		configs, err := client.Bucket(bucket).Notifications(ctx)
+		switch {
+		case errors.Is(err, storage.ErrBucketNotExist) || statusCode(err) == http.StatusNotFound:
+			return "", problem("Check the bucket name", "bucket %s does not exist", bucket)
+		case statusCode(err) == http.StatusForbidden || statusCode(err) == http.StatusUnauthorized:
+			return "", problem("Grant roles/storage.legacyBucketReader on the bucket to the service account", "not allowed to list the notifications of bucket %s: %v", bucket, err)
+		case err != nil:
+			return "", fmt.Errorf("error listing notifications: %w", err)
+		}
+
+		var want string
+		if topic != nil {
+			want = *topic
+		}
+		create := "gcloud storage buckets notifications create gs://" + bucket + " --topic=TOPIC --payload-format=json"
+		if len(want) > 0 {
+			create = strings.Replace(create, "TOPIC", want, 1)
+		}
+
+		var matching []*storage.Notification
+		for _, n := range configs {
+			if len(want) == 0 || fmt.Sprintf("projects/%s/topics/%s", n.TopicProjectID, n.TopicID) == want {
+				matching = append(matching, n)
+			}
+		}
+		slices.SortFunc(matching, func(a, b *storage.Notification) int { return strings.Compare(a.ID, b.ID) })
+		if len(matching) == 0 && len(want) > 0 {
+			return "", problem("Create one with "+create, "bucket %s sends no notifications to %s", bucket, want)
+		}
+		if len(matching) == 0 {
+			return "", problem("Create one with "+create, "bucket %s sends no notifications", bucket)
+		}
+
+		var details []string
+		for _, n := range matching {
+			if n.PayloadFormat != ingest.PayloadJSONAPIV1 && n.PayloadFormat != ingest.PayloadNone {
+				return "", problem("Recreate it with "+create, "notification %s has payload format %s, which is not decoded", n.ID, n.PayloadFormat)
+			}
+			if len(n.EventTypes) > 0 {
+				for _, event := range indexedEvents {
+					if !slices.Contains(n.EventTypes, event) {
+						return "", problem("Recreate it without --event-types with "+create, "notification %s leaves %s events out, so the index drifts from the bucket", n.ID, event)
+					}
+				}
+			}
+
+			detail := fmt.Sprintf("notification %s to projects/%s/topics/%s", n.ID, n.TopicProjectID, n.TopicID)
+			if len(n.ObjectNamePrefix) > 0 {
+				detail += " of objects under " + n.ObjectNamePrefix
+			}
+			details = append(details, detail)
+		}
+		return strings.Join(details, ", "), nil
+	}}
+}
+
+// subscriptionPattern matches the resource names of Pub/Sub subscriptions
+var subscriptionPattern = regexp.MustCompile(`^projects/[^/]+/subscriptions/[^/]+$`)
+
+// deletedTopic is the topic of subscriptions whose topic was deleted
+const deletedTopic = "_deleted-topic_"
+
+// Permissions on the subscription
+const (
+	permissionConsume = "pubsub.subscriptions.consume"
+	permissionGet     = "pubsub.subscriptions.get"
+)
+
+// Subscription checks that the subscription named projects/PROJECT/subscriptions/SUBSCRIPTION exists and may be
+// consumed, recording its topic in topic if it can be read
+func Subscription(svc *pubsub.Service, name string, topic *string) Check {
+	return Check{Name: "subscription " + name, Run: func(ctx context.Context) (string, error) {
+		if !subscriptionPattern.MatchString(name) {
+			return "", problem("Name the subscription as projects/PROJECT/subscriptions/SUBSCRIPTION", "invalid subscription name %q", name)
+		}
+
+		// Testing permissions needs none, so the subscription is found even if it can't be read
+		resp, err := svc.Projects.Subscriptions.TestIamPermissions(name, &pubsub.TestIamPermissionsRequest{
+			Permissions: []string{permissionConsume, permissionGet},
+		}).Context(ctx).Do()
+		switch {
+		case statusCode(err) == http.StatusNotFound:
+			return "", problem(fmt.Sprintf("Create it with gcloud pubsub subscriptions create %s --topic=TOPIC", name), "subscription %s does not exist", name)
+		case err != nil:
+			return "", problem("Check that pubsub.googleapis.com is reachable", "error reaching Pub/Sub: %v", err)
+		}
+		if !slices.Contains(resp.Permissions, permissionConsume) {
+			return "", problem("Grant roles/pubsub.subscriber on the subscription to the service account", "not allowed to consume subscription %s", name)
+		}
+		if !slices.Contains(resp.Permissions, permissionGet) {
+			return "consumable, topic unknown without pubsub.subscriptions.get, notifications to any topic accepted", nil
+		}
+
+		sub, err := svc.Projects.Subscriptions.Get(name).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("error reading subscription: %w", err)
+		}
+		if sub.Topic == deletedTopic {
+			return "", problem("Recreate the subscription on the topic the buckets notify", "the topic of subscription %s was deleted", name)
+		}
+		if topic != nil {
+			*topic = sub.Topic
+		}
+		return "consumable, attached to " + sub.Topic, nil
+	}}
+}
+
+// Clock checks that the local clock is within maxSkew of the Date header of url, such as a Google API
+// The Date header counts whole seconds, so skews are measured from the middle of its second
+func Clock(client *http.Client, url string, maxSkew time.Duration) Check {
+	return Check{Name: "clock", Run: func(ctx context.Context) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return "", err
+		}
+
+		sent := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", problem("Check that "+req.URL.Host+" is reachable", "error reaching %s: %v", req.URL.Host, err)
+		}
+		resp.Body.Close()
+		received := time.Now()
+
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			return "", fmt.Errorf("%s answered without a valid Date header: %w", req.URL.Host, err)
+		}
+
+		local := sent.Add(received.Sub(sent) / 2)
+		skew := local.Sub(date.Add(time.Second / 2)).Round(time.Millisecond)
+		if skew > maxSkew || -skew > maxSkew {
+			return "", problem("Synchronize the clock with NTP, such as the metadata server on Compute Engine, lags, freshness and windows being measured with it", "clock is %v off %s, more than %v", skew, req.URL.Host, maxSkew)
+		}
+		return fmt.Sprintf("%v off %s", skew, req.URL.Host), nil
+	}}
+}
@@ -0,0 +1,193 @@
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// fakeGoogle answers the JSON API calls of the checks from canned responses keyed by method and path
+func fakeGoogle(t *testing.T, responses map[string]any) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[r.Method+" "+r.URL.Path]
+		if !ok {
+			resp = http.StatusNotFound
+		}
+		if code, ok := resp.(int); ok {
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": code, "message": http.StatusText(code)}})
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newStorageClient(t *testing.T, srv *httptest.Server) *storage.Client {
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newPubSubService(t *testing.T, srv *httptest.Server) *pubsub.Service {
+	svc, err := pubsub.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+// run runs check, returning its result
+func run(check Check) Result {
+	return Run(context.Background(), []Check{check})[0]
+}
+
+func assertResult(t *testing.T, got Result, ok bool, detail string) {
+	t.Helper()
+	if got.OK != ok || !strings.Contains(got.Detail, detail) {
+		t.Errorf("%s mismatch: got ok %v with %q, want ok %v with %q", got.Name, got.OK, got.Detail, ok, detail)
+	}
+	if !got.OK && len(got.Fix) == 0 {
+		t.Errorf("expected %s to tell how to fix %q", got.Name, got.Detail)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	results := Run(context.Background(), []Check{
+		{Name: "good", Run: func(ctx context.Context) (string, error) { return "fine", nil }},
+		{Name: "bad", Run: func(ctx context.Context) (string, error) { return "", problem("do this", "broken") }},
+		{Name: "unexpected", Run: func(ctx context.Context) (string, error) { return "", errors.New("failed") }},
+	})
+
+	var b strings.Builder
+	failed, err := Write(&b, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 2 {
+		t.Errorf("failed mismatch: got %d want 2", failed)
+	}
+
+	want := "ok    good: fine\n" +
+		"FAIL  bad: broken\n" +
+		"      fix: do this\n" +
+		"FAIL  unexpected: failed\n" +
+		"\n3 checks, 2 failed\n"
+	if b.String() != want {
+		t.Errorf("output mismatch: got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := repo.NewDatabase(t.TempDir()+"/metadata.db", 1)
+	if err := db.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assertResult(t, run(Database(db)), false, "not been initialized")
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	assertResult(t, run(Database(db)), true, "writable")
+
+	if _, err := db.Exec(`DROP TABLE reservation; PRAGMA user_version = 0;`); err != nil {
+		t.Fatal(err)
+	}
+	assertResult(t, run(Database(db)), false, `missing migrations "reservations"`)
+}
+
+func TestBucket(t *testing.T) {
+	srv := fakeGoogle(t, map[string]any{
+		"GET /storage/v1/b/mock":      map[string]any{"name": "mock", "location": "US", "locationType": "multi-region"},
+		"GET /storage/v1/b/forbidden": http.StatusForbidden,
+	})
+	client := newStorageClient(t, srv)
+
+	assertResult(t, run(Bucket(client, "mock")), true, "multi-region US")
+	assertResult(t, run(Bucket(client, "missing")), false, "does not exist")
+	assertResult(t, run(Bucket(client, "forbidden")), false, "not allowed")
+}
+
+func TestNotifications(t *testing.T) {
+	notification := func(id, topic, payload string, events ...string) map[string]any {
+		return map[string]any{"id": id, "topic": "//pubsub.googleapis.com/projects/p/topics/" + topic, "payload_format": payload, "event_types": events}
+	}
+	srv := fakeGoogle(t, map[string]any{
+		"GET /storage/v1/b/mock/notificationConfigs":    map[string]any{"items": []any{notification("1", "events", "JSON_API_V1")}},
+		"GET /storage/v1/b/none/notificationConfigs":    map[string]any{},
+		"GET /storage/v1/b/partial/notificationConfigs": map[string]any{"items": []any{notification("2", "events", "JSON_API_V1", "OBJECT_FINALIZE")}},
+	})
+	client := newStorageClient(t, srv)
+
+	topic := ""
+	assertResult(t, run(Notifications(client, "mock", &topic)), true, "notification 1 to projects/p/topics/events")
+	assertResult(t, run(Notifications(client, "none", &topic)), false, "sends no notifications")
+	assertResult(t, run(Notifications(client, "partial", &topic)), false, "leaves OBJECT_DELETE events out")
+
+	topic = "projects/p/topics/other"
+	result := run(Notifications(client, "mock", &topic))
+	assertResult(t, result, false, "no notifications to projects/p/topics/other")
+	if !strings.Contains(result.Fix, "--topic=projects/p/topics/other") {
+		t.Errorf("expected the fix to name the topic, got %q", result.Fix)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	permissions := func(p ...string) map[string]any { return map[string]any{"permissions": p} }
+	srv := fakeGoogle(t, map[string]any{
+		"POST /v1/projects/p/subscriptions/sub:testIamPermissions":         permissions(permissionConsume, permissionGet),
+		"GET /v1/projects/p/subscriptions/sub":                             map[string]any{"name": "projects/p/subscriptions/sub", "topic": "projects/p/topics/events"},
+		"POST /v1/projects/p/subscriptions/blind:testIamPermissions":       permissions(permissionConsume),
+		"POST /v1/projects/p/subscriptions/denied:testIamPermissions":      permissions(),
+		"POST /v1/projects/p/subscriptions/orphan:testIamPermissions":      permissions(permissionConsume, permissionGet),
+		"GET /v1/projects/p/subscriptions/orphan":                          map[string]any{"name": "projects/p/subscriptions/orphan", "topic": deletedTopic},
+		"POST /v1/projects/p/subscriptions/unreachable:testIamPermissions": http.StatusServiceUnavailable,
+	})
+	svc := newPubSubService(t, srv)
+
+	var topic string
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/sub", &topic)), true, "attached to projects/p/topics/events")
+	if topic != "projects/p/topics/events" {
+		t.Errorf("topic mismatch: got %q", topic)
+	}
+
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/blind", nil)), true, "topic unknown")
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/denied", nil)), false, "not allowed to consume")
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/orphan", nil)), false, "was deleted")
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/missing", nil)), false, "does not exist")
+	assertResult(t, run(Subscription(svc, "projects/p/subscriptions/unreachable", nil)), false, "error reaching Pub/Sub")
+	assertResult(t, run(Subscription(svc, "sub", nil)), false, "invalid subscription name")
+}
+
+func TestClock(t *testing.T) {
+	var offset time.Duration
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	assertResult(t, run(Clock(srv.Client(), srv.URL, 2*time.Second)), true, "off")
+
+	offset = -time.Hour
+	assertResult(t, run(Clock(srv.Client(), srv.URL, 2*time.Second)), false, "more than 2s")
+}
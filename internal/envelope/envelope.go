@@ -0,0 +1,221 @@
+// Package envelope encrypts streams with a data key of their own, wrapped by a key encryption key
+// such as a Cloud KMS key, so snapshots can be kept encrypted at rest
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Extension is the file extension of encrypted streams
+const Extension = ".enc"
+
+// magic starts every encrypted stream, followed by its format version
+var magic = []byte("GCSMDENC")
+
+const (
+	version = 1
+	// chunkSize is the number of plaintext bytes sealed together
+	chunkSize = 64 << 10
+	// maxWrappedKeySize bounds the wrapped data key read back from a stream
+	maxWrappedKeySize = 4 << 10
+)
+
+// KeyWrapper encrypts and decrypts data keys with a key encryption key
+type KeyWrapper interface {
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// NewWriter returns a writer encrypting everything written to it into w with a new data key wrapped by wrapper
+// The stream is only complete once the writer is closed, streams cut short fail to decrypt
+func NewWriter(ctx context.Context, w io.Writer, wrapper KeyWrapper) (io.WriteCloser, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapper.Wrap(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %w", err)
+	}
+	if len(wrapped) > maxWrappedKeySize {
+		return nil, fmt.Errorf("wrapped data key of %d bytes is too large", len(wrapped))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{}, magic...)
+	header = append(header, version)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead}, nil
+}
+
+// NewReader returns a reader decrypting a stream written by NewWriter, its data key unwrapped by wrapper
+func NewReader(ctx context.Context, r io.Reader, wrapper KeyWrapper) (io.Reader, error) {
+	header := make([]byte, len(magic)+3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, errors.New("not an encrypted stream")
+	}
+	if v := header[len(magic)]; v != version {
+		return nil, fmt.Errorf("unsupported format version %d", v)
+	}
+
+	size := binary.BigEndian.Uint16(header[len(magic)+1:])
+	if size > maxWrappedKeySize {
+		return nil, fmt.Errorf("wrapped data key of %d bytes is too large", size)
+	}
+	wrapped := make([]byte, size)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, fmt.Errorf("error reading wrapped data key: %w", err)
+	}
+
+	key, err := wrapper.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: r, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce numbers the chunks of a stream, data keys are never reused across streams
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// chunkFlag marks the last chunk of a stream, it is authenticated with the chunk so streams can't be
+// truncated between chunks
+func chunkFlag(final bool) byte {
+	if final {
+		return 1
+	}
+	return 0
+}
+
+// writer seals plaintext in chunks of chunkSize, each prefixed with its flag and sealed length
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypted stream")
+	}
+
+	e.buf = append(e.buf, p...)
+	// The last chunk is sealed on close, so a full buffer is only sealed once more bytes follow
+	for len(e.buf) > chunkSize {
+		if err := e.seal(e.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[chunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the last chunk, it does not close the underlying writer
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(e.buf, true)
+}
+
+func (e *writer) seal(plaintext []byte, final bool) error {
+	flag := chunkFlag(final)
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.counter), plaintext, []byte{flag})
+	e.counter++
+
+	chunk := binary.BigEndian.AppendUint32([]byte{flag}, uint32(len(sealed)))
+	chunk = append(chunk, sealed...)
+	_, err := e.w.Write(chunk)
+	return err
+}
+
+// reader opens the chunks sealed by writer one at a time
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	plain   []byte
+	counter uint64
+	final   bool
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *reader) open() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	flag, size := header[0], binary.BigEndian.Uint32(header[1:])
+	if int(size) > chunkSize+d.aead.Overhead() {
+		return fmt.Errorf("chunk of %d bytes is too large", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return err
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.aead, d.counter), sealed, []byte{flag})
+	if err != nil {
+		return errors.New("encrypted stream is corrupt or was encrypted with another key")
+	}
+	d.counter++
+	d.plain = plain
+	d.final = flag == chunkFlag(true)
+	return nil
+}
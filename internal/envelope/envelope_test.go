@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// xorWrapper wraps data keys by xoring them with a key, standing in for a key management service
+type xorWrapper struct {
+	key byte
+}
+
+func (x xorWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	wrapped := make([]byte, len(key))
+	for i := range key {
+		wrapped[i] = key[i] ^ x.key
+	}
+	return wrapped, nil
+}
+
+func (x xorWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return x.Wrap(ctx, wrapped)
+}
+
+func encrypt(t *testing.T, plaintext []byte, wrapper KeyWrapper) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(context.Background(), &buf, wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(encrypted []byte, wrapper KeyWrapper) ([]byte, error) {
+	r, err := NewReader(context.Background(), bytes.NewReader(encrypted), wrapper)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	large := make([]byte, 3*chunkSize+42)
+	rand.Read(large)
+
+	testCases := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{"Empty", nil},
+		{"Small", []byte("metadata")},
+		{"Exactly one chunk", large[:chunkSize]},
+		{"Several chunks", large},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encrypted := encrypt(t, tc.plaintext, xorWrapper{42})
+			if len(tc.plaintext) > 0 && bytes.Contains(encrypted, tc.plaintext) {
+				t.Error("Encrypted stream holds the plaintext")
+			}
+
+			got, err := decrypt(encrypted, xorWrapper{42})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.plaintext) {
+				t.Errorf("Plaintext mismatch: got %d bytes, want %d bytes", len(got), len(tc.plaintext))
+			}
+		})
+	}
+}
+
+func TestTampering(t *testing.T) {
+	plaintext := make([]byte, 2*chunkSize+1)
+	encrypted := encrypt(t, plaintext, xorWrapper{42})
+
+	// The first chunk follows the header and the wrapped 32 bytes data key
+	firstChunk := len(magic) + 3 + 32
+
+	flipped := bytes.Clone(encrypted)
+	flipped[len(flipped)-1] ^= 1
+
+	finalFlag := bytes.Clone(encrypted)
+	finalFlag[firstChunk] = chunkFlag(true)
+
+	testCases := []struct {
+		name      string
+		encrypted []byte
+		wrapper   KeyWrapper
+	}{
+		{"Another key", encrypted, xorWrapper{7}},
+		{"Flipped bit", flipped, xorWrapper{42}},
+		{"Truncated between chunks", encrypted[:firstChunk+5+chunkSize+16], xorWrapper{42}},
+		{"Truncated chunk", encrypted[:len(encrypted)-3], xorWrapper{42}},
+		{"First chunk marked last", finalFlag, xorWrapper{42}},
+		{"Not encrypted", []byte("plain metadata"), xorWrapper{42}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decrypt(tc.encrypted, tc.wrapper); err == nil {
+				t.Error("Expected decryption to fail")
+			}
+		})
+	}
+
+	if _, err := decrypt(encrypted[:firstChunk+5+chunkSize+16], xorWrapper{42}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected ErrUnexpectedEOF for a truncated stream, got %v", err)
+	}
+}
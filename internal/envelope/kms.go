@@ -0,0 +1,54 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// kmsKeyPattern matches the resource names of Cloud KMS keys
+var kmsKeyPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// KMSWrapper wraps data keys with a symmetric Cloud KMS key, which never leaves KMS
+type KMSWrapper struct {
+	keys *cloudkms.ProjectsLocationsKeyRingsCryptoKeysService
+	name string
+}
+
+// NewKMSWrapper wraps data keys with the key named projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY
+func NewKMSWrapper(ctx context.Context, name string) (*KMSWrapper, error) {
+	if !kmsKeyPattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid Cloud KMS key name %q", name)
+	}
+
+	svc, err := cloudkms.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &KMSWrapper{keys: svc.Projects.Locations.KeyRings.CryptoKeys, name: name}, nil
+}
+
+// Wrap encrypts key with the primary version of the KMS key
+func (k *KMSWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := k.keys.Encrypt(k.name, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(key),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Unwrap decrypts a key wrapped by any enabled version of the KMS key
+func (k *KMSWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	resp, err := k.keys.Decrypt(k.name, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(wrapped),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"cmp"
+	"expvar"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// ConflictPolicy decides which of two versions of an object is the newer one
+type ConflictPolicy string
+
+const (
+	// ConflictUpdated keeps the version updated last, ties keeping the indexed version
+	ConflictUpdated ConflictPolicy = "updated"
+	// ConflictGeneration keeps the higher generation, then metageneration, as GCS orders them, resolving ties of
+	// regenerated objects and skewed update times, falling back to update times for versions of unknown generation
+	ConflictGeneration ConflictPolicy = "generation"
+	// ConflictEventTime keeps the version of the notification sent last, falling back to update times for versions
+	// not indexed from notifications
+	ConflictEventTime ConflictPolicy = "event-time"
+)
+
+// conflictStats counts the versions whose update times disagree with their generations or event times, and how
+// they were resolved, published in the ingest_conflicts expvar
+var conflictStats = expvar.NewMap("ingest_conflicts")
+
+// compareVersions orders obj and current by generation then metageneration, 0 if either generation is unknown
+func compareVersions(obj, current *model.Metadata) int {
+	if obj.Generation == 0 || current.Generation == 0 {
+		return 0
+	}
+	return cmp.Or(cmp.Compare(obj.Generation, current.Generation), cmp.Compare(obj.Metageneration, current.Metageneration))
+}
+
+// compareEventTimes orders obj and current by the time of their notifications, 0 if either is unknown
+func compareEventTimes(obj, current *model.Metadata) int {
+	if obj.EventTime == nil || current.EventTime == nil {
+		return 0
+	}
+	return obj.EventTime.Compare(*current.EventTime)
+}
+
+// newer returns whether obj replaces the indexed version current under policy, counting the conflicts it resolves
+func (p ConflictPolicy) newer(obj, current *model.Metadata) bool {
+	updated := obj.Updated.Compare(current.Updated)
+	version := compareVersions(obj, current)
+	event := compareEventTimes(obj, current)
+
+	var order int
+	switch p {
+	case ConflictGeneration:
+		order = cmp.Or(version, updated)
+	case ConflictEventTime:
+		order = cmp.Or(event, updated)
+	default:
+		order = updated
+	}
+
+	if (version != 0 && version != updated) || (event != 0 && event != updated) {
+		conflictStats.Add("detected", 1)
+		if order > 0 {
+			conflictStats.Add("replaced", 1)
+		} else {
+			conflictStats.Add("kept", 1)
+		}
+	}
+	return order > 0
+}
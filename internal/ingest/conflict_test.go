@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	sent := updated.Add(time.Second)
+	version := func(generation, metageneration int64, updated time.Time, eventTime *time.Time) *model.Metadata {
+		return &model.Metadata{Generation: generation, Metageneration: metageneration, Updated: updated, EventTime: eventTime}
+	}
+	later := sent.Add(time.Second)
+	detected := func() int64 {
+		if v, ok := conflictStats.Get("detected").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	testCases := []struct {
+		name         string
+		obj, current *model.Metadata
+		want         map[ConflictPolicy]bool
+		wantConflict bool
+	}{
+		{"Newer in every way", version(2, 1, updated.Add(time.Second), &later), version(1, 1, updated, &sent),
+			map[ConflictPolicy]bool{ConflictUpdated: true, ConflictGeneration: true, ConflictEventTime: true}, false},
+		{"Redelivered", version(1, 1, updated, &sent), version(1, 1, updated, &sent),
+			map[ConflictPolicy]bool{ConflictUpdated: false, ConflictGeneration: false, ConflictEventTime: false}, false},
+		{"Regenerated within the same update time", version(2, 1, updated, &later), version(1, 1, updated, &sent),
+			map[ConflictPolicy]bool{ConflictUpdated: false, ConflictGeneration: true, ConflictEventTime: true}, true},
+		{"Metadata updated with a skewed clock", version(1, 2, updated.Add(-time.Second), &later), version(1, 1, updated, &sent),
+			map[ConflictPolicy]bool{ConflictUpdated: false, ConflictGeneration: true, ConflictEventTime: true}, true},
+		{"Unknown generation falls back to update times", version(1, 1, updated.Add(time.Second), nil), version(0, 0, updated, nil),
+			map[ConflictPolicy]bool{ConflictUpdated: true, ConflictGeneration: true, ConflictEventTime: true}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := detected()
+			for policy, want := range tc.want {
+				if got := policy.newer(tc.obj, tc.current); got != want {
+					t.Errorf("Newer under %s mismatch: got %t, want %t", policy, got, want)
+				}
+			}
+
+			if conflicted := detected() > before; conflicted != tc.wantConflict {
+				t.Errorf("Conflict detection mismatch: got %t, want %t", conflicted, tc.wantConflict)
+			}
+		})
+	}
+}
+
+func TestApplyConflictPolicy(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	ctx := context.Background()
+	applier := NewApplier(db)
+	applier.SetConflictPolicy(ConflictGeneration)
+	metadataRepo := repo.NewMetadataRepository(db)
+
+	// The object is regenerated within the update time of the generation it replaces, then deleted
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	v1 := model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: updated, Updated: updated}
+	v2 := v1
+	v2.Size = 20
+
+	for _, ev := range []Event{
+		{Type: EventFinalize, Object: v1, Generation: 1, Metageneration: 1},
+		{Type: EventFinalize, Object: v2, Generation: 2, Metageneration: 1},
+		{Type: EventFinalize, Object: v1, Generation: 1, Metageneration: 1}, // redelivered
+		{Type: EventDelete, Object: v1, Generation: 1},                      // of the replaced generation
+	} {
+		if err := applier.Apply(ctx, ev); err != nil {
+			t.Fatalf("Error applying %v: %v", ev, err)
+		}
+	}
+
+	obj, err := metadataRepo.Get(ctx, "mock", "a/file")
+	if err != nil || obj.Size != 20 || obj.Generation != 2 {
+		t.Fatalf("Live generation mismatch: got %+v, %v", obj, err)
+	}
+
+	if err := applier.Apply(ctx, Event{Type: EventDelete, Object: v2, Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := metadataRepo.Get(ctx, "mock", "a/file"); err == nil {
+		t.Errorf("Expected the live generation to be deleted, got %+v", obj)
+	}
+}
@@ -0,0 +1,115 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of the latest apply in the moving average of apply latencies
+const latencyWeight = 0.2
+
+// flowController bounds the messages and bytes a subscriber holds, and adapts the number of messages to the
+// latency of applying them
+// Writes slowing down, such as a burst of deletions locking the directory rollups, halve the messages pulled
+// at a time, which then grow by one per message applied within the target latency
+type flowController struct {
+	mu       sync.Mutex
+	changed  chan struct{}
+	messages int
+	bytes    int64
+
+	maxMessages   int
+	maxBytes      int64
+	targetLatency time.Duration
+
+	limit     int
+	latency   time.Duration
+	throttled time.Time
+}
+
+// newFlowController returns a flow controller holding up to maxMessages messages and maxBytes bytes, adapting
+// to targetLatency unless 0
+func newFlowController(maxMessages int, maxBytes int64, targetLatency time.Duration) *flowController {
+	return &flowController{
+		changed:       make(chan struct{}),
+		maxMessages:   maxMessages,
+		maxBytes:      maxBytes,
+		targetLatency: targetLatency,
+		limit:         maxMessages,
+	}
+}
+
+// available waits until messages may be pulled and returns how many
+// Once messages are released, the messages held are below the adaptive limit and bytes below their maximum
+func (f *flowController) available(ctx context.Context) (int, error) {
+	for {
+		f.mu.Lock()
+		if f.messages < f.limit && f.bytes < f.maxBytes {
+			n := f.limit - f.messages
+			f.mu.Unlock()
+			return n, nil
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// acquire holds a message of size bytes, pulled within the messages available
+// Bytes may exceed their maximum by the messages of a pull, pulls waiting until they are released
+func (f *flowController) acquire(size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages++
+	f.bytes += size
+}
+
+// release frees a message of size bytes once it is settled
+func (f *flowController) release(size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages--
+	f.bytes -= size
+	f.notify()
+}
+
+// observe adapts the message limit to the latency of applying a message
+func (f *flowController) observe(latency time.Duration) {
+	if f.targetLatency <= 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.latency == 0 {
+		f.latency = latency
+	} else {
+		f.latency += time.Duration(latencyWeight * float64(latency-f.latency))
+	}
+
+	// Halving waits a target latency, so the messages in flight when writes slowed down don't halve it again
+	switch {
+	case f.latency > f.targetLatency && time.Since(f.throttled) > f.targetLatency:
+		if f.limit > 1 {
+			f.limit /= 2
+			f.throttled = time.Now()
+			subscriberStats.Add("throttled", 1)
+		}
+	case f.latency <= f.targetLatency && f.limit < f.maxMessages:
+		f.limit++
+		f.notify()
+	}
+}
+
+// notify wakes the pulls waiting for available messages, f.mu being held
+func (f *flowController) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
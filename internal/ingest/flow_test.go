@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFlowController(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Bounds messages and bytes held", func(t *testing.T) {
+		flow := newFlowController(2, 100, 0)
+		if n, err := flow.available(ctx); err != nil || n != 2 {
+			t.Fatalf("Available mismatch: got %d, %v, want 2", n, err)
+		}
+		flow.acquire(150)
+
+		// Bytes over their maximum hold back pulls until released
+		waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := flow.available(waitCtx); err == nil {
+			t.Fatal("Pull not held back by bytes over their maximum")
+		}
+
+		done := make(chan int)
+		go func() {
+			n, _ := flow.available(ctx)
+			done <- n
+		}()
+		flow.release(150)
+		if n := <-done; n != 2 {
+			t.Errorf("Available after release mismatch: got %d, want 2", n)
+		}
+
+		flow.acquire(1)
+		if n, _ := flow.available(ctx); n != 1 {
+			t.Errorf("Available mismatch: got %d, want 1", n)
+		}
+	})
+
+	t.Run("Adapts to apply latency", func(t *testing.T) {
+		flow := newFlowController(8, 100, time.Millisecond)
+
+		flow.observe(time.Second)
+		if n, _ := flow.available(ctx); n != 4 {
+			t.Errorf("Available after slow apply mismatch: got %d, want 4", n)
+		}
+		// Slow applies in flight when throttling don't halve the limit again within the target latency
+		flow.throttled = time.Now().Add(time.Hour)
+		flow.observe(time.Second)
+		if n, _ := flow.available(ctx); n != 4 {
+			t.Errorf("Available after throttling again mismatch: got %d, want 4", n)
+		}
+
+		// Fast applies grow the limit back one at a time, as the average latency recovers
+		flow.latency = 0
+		for range 10 {
+			flow.observe(0)
+		}
+		if n, _ := flow.available(ctx); n != 8 {
+			t.Errorf("Available after fast applies mismatch: got %d, want 8", n)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+// Package ingest indexes GCS object change notifications, keeping the directory rollups of the index in sync
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// EventType mirrors the event types of GCS Pub/Sub notifications
+type EventType string
+
+const (
+	// EventFinalize is sent when a new generation of an object is written
+	EventFinalize EventType = "OBJECT_FINALIZE"
+	// EventMetadataUpdate is sent when the metadata of the live generation changes, moving its update time,
+	// and is applied as its finalization
+	EventMetadataUpdate EventType = "OBJECT_METADATA_UPDATE"
+	// EventArchive is sent when the live generation of an object of a versioned bucket becomes noncurrent
+	EventArchive EventType = "OBJECT_ARCHIVE"
+	// EventDelete is sent when a generation is deleted, live or noncurrent
+	EventDelete EventType = "OBJECT_DELETE"
+)
+
+// Event is a notification about a generation of an object
+type Event struct {
+	Type       EventType
+	Object     model.Metadata
+	Generation int64
+	// Metageneration is the version of the metadata of the generation, 0 if unknown
+	Metageneration int64
+	// EventTime is when the notification was sent, zero if unknown
+	EventTime time.Time
+	// Received is when the notification arrived, such as the publish time of its Pub/Sub message
+	// If set, the lag of finalize notifications after the write is measured per top level prefix
+	Received time.Time
+	// Ordered is set for notifications delivered in order with the others of their object, such as by a
+	// subscription ordering messages by object name
+	// Finalizations then mostly follow the deletion or archival of the generation they replace, so they are
+	// inserted without looking the object up first, which only happens once the insert conflicts
+	Ordered bool
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s#%d", e.Type, e.Object.Name, e.Generation)
+}
+
+// Applier indexes events, keeping directory rollups in sync
+// The live generation of an object is identified by its generation, or by its update time for objects indexed
+// without one
+type Applier struct {
+	db             *repo.Database
+	directoryRepo  repo.DirectoryRepository
+	metadataRepo   repo.MetadataRepository
+	noncurrentRepo repo.NoncurrentRepository
+	statsRepo      repo.StatsRepository
+	policy         ConflictPolicy
+}
+
+func NewApplier(db *repo.Database) *Applier {
+	return &Applier{
+		db:             db,
+		directoryRepo:  repo.NewDirectoryRepository(db),
+		metadataRepo:   repo.NewMetadataRepository(db),
+		noncurrentRepo: repo.NewNoncurrentRepository(db),
+		statsRepo:      repo.NewStatsRepository(db),
+		policy:         ConflictUpdated,
+	}
+}
+
+// SetConflictPolicy decides which of two versions of an object is indexed, ConflictUpdated by default
+func (a *Applier) SetConflictPolicy(policy ConflictPolicy) {
+	a.policy = policy
+}
+
+// Apply indexes a single event, counting it per bucket and event type once applied
+// Events about generations the index already moved past are ignored, so retirements may arrive
+// before or after the generation overwriting them
+// The writes of an event are committed together, within the transaction of ctx if it runs atomically, so an
+// event failing midway is applied again whole
+func (a *Applier) Apply(ctx context.Context, ev Event) error {
+	return a.db.Atomically(ctx, func(ctx context.Context) error {
+		if err := a.apply(ctx, ev); err != nil {
+			return err
+		}
+		return a.statsRepo.RecordEvent(ctx, ev.Object.Bucket, string(ev.Type))
+	})
+}
+
+func (a *Applier) apply(ctx context.Context, ev Event) error {
+	switch ev.Type {
+	case EventFinalize, EventMetadataUpdate:
+		if err := a.finalize(ctx, &ev); err != nil {
+			return err
+		}
+		// Redelivered notifications are measured too, the write being visible late all the same
+		if ev.Received.IsZero() {
+			return nil
+		}
+		return a.statsRepo.RecordLag(ctx, ev.Object.Bucket, ev.Object.Name, ev.Received.Sub(ev.Object.Updated))
+	case EventArchive:
+		if err := a.retire(ctx, &ev.Object, ev.Generation); err != nil {
+			return err
+		}
+
+		obj := &model.NoncurrentObject{
+			Bucket:       ev.Object.Bucket,
+			Name:         ev.Object.Name,
+			Generation:   ev.Generation,
+			Size:         ev.Object.Size,
+			StorageClass: ev.Object.StorageClass,
+			Deleted:      ev.Object.Updated,
+		}
+		err := a.noncurrentRepo.Insert(ctx, obj)
+		if errors.Is(err, repo.ErrConflict) {
+			return nil // redelivered
+		}
+		if err != nil {
+			return err
+		}
+		return a.directoryRepo.UpsertNoncurrentDirs(ctx, obj.Bucket, obj.Name, obj.Size, 1)
+	case EventDelete:
+		err := a.noncurrentRepo.Delete(ctx, ev.Object.Bucket, ev.Object.Name, ev.Generation)
+		if errors.Is(err, repo.ErrNotFound) {
+			return a.retire(ctx, &ev.Object, ev.Generation)
+		}
+		if err != nil {
+			return err
+		}
+		return a.directoryRepo.UpsertNoncurrentDirs(ctx, ev.Object.Bucket, ev.Object.Name, -ev.Object.Size, -1)
+	default:
+		return fmt.Errorf("unknown event type %q", ev.Type)
+	}
+}
+
+// finalize indexes a new live generation, replacing the one indexed if it is newer under the conflict policy
+// Ordered finalizations try inserting the generation before looking up the one indexed
+func (a *Applier) finalize(ctx context.Context, ev *Event) error {
+	obj := &ev.Object
+	obj.Generation, obj.Metageneration = ev.Generation, ev.Metageneration
+	if !ev.EventTime.IsZero() {
+		obj.EventTime = &ev.EventTime
+	}
+
+	if ev.Ordered {
+		err := a.metadataRepo.Insert(ctx, obj)
+		if err == nil {
+			return a.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1)
+		}
+		if !errors.Is(err, repo.ErrConflict) {
+			return err
+		}
+	}
+
+	current, err := a.metadataRepo.Get(ctx, obj.Bucket, obj.Name)
+	if errors.Is(err, repo.ErrNotFound) {
+		return a.insert(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !a.policy.newer(obj, current):
+		return nil // stale or redelivered
+	case current.StorageClass != obj.StorageClass:
+		// Directories total sizes per storage class, the object moves from one total to the other
+		if err := a.delete(ctx, current); err != nil {
+			return err
+		}
+		return a.insert(ctx, obj)
+	default:
+		// Stale replacements raced another write of the object, and are resolved again once redelivered
+		if err := a.metadataRepo.Replace(ctx, current, obj); err != nil {
+			return err
+		}
+		return a.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size-current.Size, 0)
+	}
+}
+
+// retire removes obj, of the given generation, from the live objects if it still is the live generation
+func (a *Applier) retire(ctx context.Context, obj *model.Metadata, generation int64) error {
+	current, err := a.metadataRepo.Get(ctx, obj.Bucket, obj.Name)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if generation != 0 && current.Generation != 0 {
+		if current.Generation != generation {
+			return nil // overwritten already
+		}
+	} else if !current.Updated.Equal(obj.Updated) {
+		return nil // overwritten already
+	}
+	return a.delete(ctx, current)
+}
+
+func (a *Applier) insert(ctx context.Context, obj *model.Metadata) error {
+	err := a.metadataRepo.Insert(ctx, obj)
+	if errors.Is(err, repo.ErrConflict) {
+		return nil // redelivered
+	}
+	if err != nil {
+		return err
+	}
+	return a.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1)
+}
+
+func (a *Applier) delete(ctx context.Context, obj *model.Metadata) error {
+	err := a.metadataRepo.Delete(ctx, obj.Bucket, obj.Name)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, -obj.Size, -1)
+}
+
+// ApplyAll applies events with a number of workers, every name being applied in order by the same worker
+// as a subscriber using the object name as ordering key would, and returns the first error
+func (a *Applier) ApplyAll(ctx context.Context, events []Event, workers int) error {
+	queues := make([]chan Event, max(workers, 1))
+	for i := range queues {
+		queues[i] = make(chan Event, len(events))
+	}
+
+	for _, ev := range events {
+		h := fnv.New32a()
+		h.Write([]byte(ev.Object.Name))
+		queues[h.Sum32()%uint32(len(queues))] <- ev
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(queues))
+	for i, queue := range queues {
+		close(queue)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range queue {
+				if err := a.Apply(ctx, ev); err != nil && errs[i] == nil {
+					errs[i] = fmt.Errorf("error applying %v: %w", ev, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestApply(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	ctx := context.Background()
+	applier := NewApplier(db)
+	metadataRepo := repo.NewMetadataRepository(db)
+
+	first := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	v1 := model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: first, Updated: first}
+	v2 := v1
+	v2.Size, v2.Updated = 20, first.Add(time.Hour)
+
+	// The overwrite is delivered before the archival of the generation it replaces
+	events := []Event{
+		{Type: EventFinalize, Object: v1, Generation: 1},
+		{Type: EventFinalize, Object: v2, Generation: 2},
+		{Type: EventArchive, Object: v1, Generation: 1},
+		{Type: EventArchive, Object: v1, Generation: 1}, // redelivered
+	}
+	for _, ev := range events {
+		if err := applier.Apply(ctx, ev); err != nil {
+			t.Fatalf("Error applying %v: %v", ev, err)
+		}
+	}
+
+	if obj, err := metadataRepo.Get(ctx, "mock", "a/file"); err != nil || obj.Size != 20 {
+		t.Errorf("Live generation mismatch: got %+v, %v", obj, err)
+	}
+
+	var noncurrent int64
+	if err := db.Get(&noncurrent, `SELECT noncurrent_size FROM directory WHERE bucket = 'mock' AND name = 'a/';`); err != nil {
+		t.Fatal(err)
+	}
+	if noncurrent != 10 {
+		t.Errorf("Noncurrent size mismatch: got %d want 10", noncurrent)
+	}
+
+	// Metadata updates move the update time of the live generation
+	v2.Updated = v2.Updated.Add(time.Hour)
+	if err := applier.Apply(ctx, Event{Type: EventMetadataUpdate, Object: v2, Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := metadataRepo.Get(ctx, "mock", "a/file"); err != nil || !obj.Updated.Equal(v2.Updated) {
+		t.Errorf("Metadata update mismatch: got %+v, %v", obj, err)
+	}
+
+	// Deleting the live generation removes it, its archived predecessor being deleted separately
+	if err := applier.Apply(ctx, Event{Type: EventDelete, Object: v2, Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "a/file"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected the live generation to be deleted, got %v", err)
+	}
+
+	if err := applier.Apply(ctx, Event{Type: "OBJECT_RESTORE", Object: v2}); err == nil {
+		t.Error("Expected an error applying an unknown event type")
+	}
+
+	// Applied events are counted per type, redeliveries included
+	stats, err := repo.NewStatsRepository(db).GetEventStats(ctx, time.Now().Add(-time.Hour), "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got model.EventStat
+	for _, hour := range stats {
+		got.Finalize += hour.Finalize
+		got.MetadataUpdate += hour.MetadataUpdate
+		got.Archive += hour.Archive
+		got.Delete += hour.Delete
+	}
+	if got.Finalize != 2 || got.MetadataUpdate != 1 || got.Archive != 2 || got.Delete != 1 {
+		t.Errorf("Event stats mismatch: got %+v", got)
+	}
+}
+
+func TestApplyMeasuresLag(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	ctx := context.Background()
+	applier := NewApplier(db)
+
+	written := time.Now().Add(-time.Minute)
+	obj := model.Metadata{Bucket: "mock", Name: "logs/file", Size: 10, StorageClass: "STANDARD", Created: written, Updated: written}
+	for _, ev := range []Event{
+		{Type: EventFinalize, Object: obj, Generation: 1, Received: written.Add(2 * time.Second)},
+		{Type: EventFinalize, Object: obj, Generation: 1}, // without arrival time
+		{Type: EventDelete, Object: obj, Generation: 1, Received: written.Add(time.Minute)},
+	} {
+		if err := applier.Apply(ctx, ev); err != nil {
+			t.Fatalf("Error applying %v: %v", ev, err)
+		}
+	}
+
+	lags, err := repo.NewStatsRepository(db).GetNotificationLag(ctx, written.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lags) != 1 || lags[0].Prefix != "logs/" || lags[0].Events != 1 || time.Duration(lags[0].MaxLag) != 2*time.Second {
+		t.Errorf("Lag mismatch: got %+v", lags)
+	}
+}
+
+func TestApplyOrdered(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	ctx := context.Background()
+	applier := NewApplier(db)
+	metadataRepo := repo.NewMetadataRepository(db)
+
+	first := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	v1 := model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: first, Updated: first}
+	v2 := v1
+	v2.Size, v2.Updated = 20, first.Add(time.Hour)
+
+	// New objects are inserted outright, while overwrites and redeliveries conflict and are looked up
+	for _, ev := range []Event{
+		{Type: EventFinalize, Object: v1, Generation: 1, Ordered: true},
+		{Type: EventFinalize, Object: v2, Generation: 2, Ordered: true},
+		{Type: EventFinalize, Object: v1, Generation: 1, Ordered: true}, // redelivered
+	} {
+		if err := applier.Apply(ctx, ev); err != nil {
+			t.Fatalf("Error applying %v: %v", ev, err)
+		}
+	}
+
+	if obj, err := metadataRepo.Get(ctx, "mock", "a/file"); err != nil || obj.Size != 20 {
+		t.Errorf("Live generation mismatch: got %+v, %v", obj, err)
+	}
+
+	var count, size int64
+	if err := db.QueryRow(`SELECT count, size_standard FROM directory WHERE bucket = 'mock' AND name = 'a/';`).Scan(&count, &size); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || size != 20 {
+		t.Errorf("Directory mismatch: got count %d size %d, want 1 and 20", count, size)
+	}
+}
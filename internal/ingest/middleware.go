@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Handler applies the event decoded from a message
+type Handler func(ctx context.Context, msg *Message, ev Event) error
+
+// Middleware wraps a Handler with a behavior cutting across events, such as filtering or deduplicating them
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in middlewares, the first one running first
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// ErrDuplicate acknowledges a message without applying it, as it was applied already
+var ErrDuplicate = errors.New("message applied already")
+
+// RedeliveryError returns a message to be redelivered after Delay, neither applied nor failed
+type RedeliveryError struct {
+	// Reason counts the messages redelivered in the subscriber expvar
+	Reason string
+	Delay  time.Duration
+}
+
+func (e *RedeliveryError) Error() string {
+	return fmt.Sprintf("message redelivered after %v: %s", e.Delay, e.Reason)
+}
+
+var (
+	// eventStats counts the events handled by type and outcome, such as "OBJECT_FINALIZE applied", and the
+	// milliseconds spent applying them, published in the subscriber_events expvar
+	eventStats = expvar.NewMap("subscriber_events")
+	// shadowStats counts the writes of dry runs by statement and table, such as "insert metadata", published in the
+	// subscriber_shadow expvar
+	shadowStats = expvar.NewMap("subscriber_shadow")
+)
+
+// Owned redelivers the events of the buckets owns returns false for to the subscribers owning them after delay
+// Subscribers share their subscription, so redelivering right away could hand the events back to the same
+// subscriber in a loop
+func Owned(owns func(bucket string) bool, delay time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			if !owns(ev.Object.Bucket) {
+				return &RedeliveryError{Reason: "unowned", Delay: delay}
+			}
+			return next(ctx, msg, ev)
+		}
+	}
+}
+
+// Paused redelivers the events of the buckets paused returns true for after delay, so they don't spin until
+// their bucket is resumed
+func Paused(paused func(bucket string) bool, delay time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			if paused(ev.Object.Bucket) {
+				return &RedeliveryError{Reason: "paused", Delay: delay}
+			}
+			return next(ctx, msg, ev)
+		}
+	}
+}
+
+// Metrics counts events by type and outcome, and the time spent applying them
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			start := time.Now()
+			err := next(ctx, msg, ev)
+
+			var redelivery *RedeliveryError
+			outcome := "applied"
+			switch {
+			case errors.As(err, &redelivery):
+				outcome = redelivery.Reason
+			case errors.Is(err, ErrDuplicate):
+				outcome = "duplicate"
+			case err != nil:
+				outcome = "failed"
+			}
+			eventStats.Add(fmt.Sprintf("%s %s", ev.Type, outcome), 1)
+			eventStats.Add(fmt.Sprintf("%s ms", ev.Type), time.Since(start).Milliseconds())
+			return err
+		}
+	}
+}
+
+// Trace logs the events taking threshold or longer to handle with the statements they executed
+func Trace(threshold time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			ctx, queryLog := repo.WithQueryLog(ctx)
+			start := time.Now()
+			err := next(ctx, msg, ev)
+			if latency := time.Since(start); latency >= threshold {
+				var b strings.Builder
+				queries := queryLog.Queries()
+				fmt.Fprintf(&b, "Slow apply of %v of message %s took %v, executed %d statements:", ev, msg.ID, latency, len(queries))
+				for _, q := range queries {
+					fmt.Fprintf(&b, "\n  [%v] %s %v", q.Duration, strings.Join(strings.Fields(q.SQL), " "), q.Args)
+				}
+				log.Print(b.String())
+			}
+			return err
+		}
+	}
+}
+
+// Dedup handles every message of subscription once, journaling it in journal along with the writes of the
+// handlers it wraps, and moving the checkpoint of subscription to the publish time watermark returns
+func Dedup(journal repo.JournalRepository, subscription string, watermark func() time.Time) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			err := journal.ApplyOnce(ctx, subscription, msg.ID, msg.PublishTime, watermark(), func(ctx context.Context) error {
+				return next(ctx, msg, ev)
+			})
+			if errors.Is(err, repo.ErrConflict) {
+				return ErrDuplicate
+			}
+			return err
+		}
+	}
+}
+
+// DryRun evaluates events in transactions of db rolled back once the handlers it wraps return, reading the index
+// as if their writes were made, and logs and counts the writes they would make
+func DryRun(db *repo.Database) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			writes, err := db.DryRun(ctx, func(ctx context.Context) error {
+				return next(ctx, msg, ev)
+			})
+			if err != nil {
+				return err
+			}
+
+			targets := make([]string, len(writes))
+			for i, w := range writes {
+				statement, table := w.WriteTarget()
+				targets[i] = strings.ToLower(statement) + " " + table
+				shadowStats.Add(targets[i], 1)
+			}
+			log.Printf("Shadow %v would %s", ev, strings.Join(targets, ", "))
+			return nil
+		}
+	}
+}
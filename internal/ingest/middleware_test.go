@@ -0,0 +1,123 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg *Message, ev Event) error {
+				calls = append(calls, name)
+				return next(ctx, msg, ev)
+			}
+		}
+	}
+	h := Chain(func(ctx context.Context, msg *Message, ev Event) error {
+		calls = append(calls, "handler")
+		return nil
+	}, record("first"), record("second"))
+
+	if err := h(context.Background(), &Message{}, Event{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"first", "second", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("Calls mismatch: got %v, want %v", calls, want)
+	}
+}
+
+func TestOwnedAndPaused(t *testing.T) {
+	applied := 0
+	h := Chain(func(ctx context.Context, msg *Message, ev Event) error {
+		applied++
+		return nil
+	}, Owned(func(bucket string) bool { return bucket != "other" }, time.Second), Paused(func(bucket string) bool { return bucket == "paused" }, DefaultAckDeadline))
+
+	tests := []struct {
+		bucket string
+		reason string
+		delay  time.Duration
+	}{
+		{bucket: "other", reason: "unowned", delay: time.Second},
+		{bucket: "paused", reason: "paused", delay: DefaultAckDeadline},
+		{bucket: "mock"},
+	}
+	for _, tt := range tests {
+		err := h(context.Background(), &Message{}, Event{Object: model.Metadata{Bucket: tt.bucket}})
+		var redelivery *RedeliveryError
+		if len(tt.reason) == 0 {
+			if err != nil {
+				t.Errorf("Unexpected error for %s: %v", tt.bucket, err)
+			}
+			continue
+		}
+		if !errors.As(err, &redelivery) || redelivery.Reason != tt.reason || redelivery.Delay != tt.delay {
+			t.Errorf("Redelivery mismatch for %s: got %v, want %s", tt.bucket, err, tt.reason)
+		}
+	}
+	if applied != 1 {
+		t.Errorf("Applied mismatch: got %d, want 1", applied)
+	}
+}
+
+func TestSubscriberShadow(t *testing.T) {
+	db := repotest.NewDatabase(t)
+	metadataRepo := repo.NewMetadataRepository(db)
+
+	shadowed := func(target string) int64 {
+		if v, ok := shadowStats.Get(target).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	inserts := shadowed("insert metadata")
+
+	sub := newFakeSubscription([]*Message{finalizeMessage("1", "a/file")})
+	s := NewSubscriber(sub, NewApplier(db), SubscriberConfig{Shadow: true})
+	runSubscriber(t, s, sub, 1)
+
+	// Shadow messages are evaluated and acknowledged, counting the writes they would make without making them
+	acked, _ := sub.settled()
+	if !slices.Equal(acked, []string{"ack-1"}) {
+		t.Errorf("Acknowledged mismatch: got %v", acked)
+	}
+	if got := shadowed("insert metadata") - inserts; got != 1 {
+		t.Errorf("Shadow inserts mismatch: got %d, want 1", got)
+	}
+	if _, err := metadataRepo.Get(context.Background(), "mock", "a/file"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected the shadow apply to leave the index unchanged, got %v", err)
+	}
+}
+
+func TestSubscriberUse(t *testing.T) {
+	sub := newFakeSubscription([]*Message{finalizeMessage("1", "a/file"), finalizeMessage("2", "b/file")})
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 1})
+	s.apply = func(ctx context.Context, ev Event) error { return nil }
+
+	// Custom middlewares filter messages like the built-in ones
+	s.Use(func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message, ev Event) error {
+			if msg.ID == "2" {
+				return ErrDuplicate
+			}
+			return next(ctx, msg, ev)
+		}
+	})
+	runSubscriber(t, s, sub, 2)
+
+	acked, _ := sub.settled()
+	slices.Sort(acked)
+	if !slices.Equal(acked, []string{"ack-1", "ack-2"}) {
+		t.Errorf("Acknowledged mismatch: got %v", acked)
+	}
+}
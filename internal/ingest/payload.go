@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Payload formats of GCS notifications, named by the payloadFormat attribute of their messages
+const (
+	// PayloadJSONAPIV1 messages carry the object resource of the JSON API
+	PayloadJSONAPIV1 = "JSON_API_V1"
+	// PayloadNone messages carry attributes only
+	PayloadNone = "NONE"
+)
+
+// PayloadMode decides how messages deviating from their format are decoded
+type PayloadMode string
+
+const (
+	// PayloadStrict rejects messages of unknown formats, and payloads disagreeing with their attributes
+	PayloadStrict PayloadMode = "strict"
+	// PayloadLenient decodes messages of unknown formats from their attributes, which prevail over payloads
+	PayloadLenient PayloadMode = "lenient"
+)
+
+var (
+	ErrUnknownPayloadFormat = errors.New("unknown payload format")
+	// ErrMetadataMissing is returned with the event decoded from the attributes of messages without object metadata,
+	// whose object must be looked up before the event can be applied
+	ErrMetadataMissing = errors.New("notification carries no object metadata")
+)
+
+// PayloadDecoder fills an event, decoded from the attributes of its message already, with the object metadata of
+// the message payload
+type PayloadDecoder func(data []byte, ev *Event) error
+
+var (
+	payloadDecodersMu sync.RWMutex
+	payloadDecoders   = map[string]PayloadDecoder{
+		PayloadJSONAPIV1: decodeJSONAPIV1,
+		PayloadNone:      decodeNone,
+	}
+)
+
+// eventTypes maps the eventType attribute of notifications to the events they are applied as
+var eventTypes = map[string]EventType{
+	"OBJECT_FINALIZE":        EventFinalize,
+	"OBJECT_METADATA_UPDATE": EventMetadataUpdate,
+	"OBJECT_ARCHIVE":         EventArchive,
+	"OBJECT_DELETE":          EventDelete,
+}
+
+// RegisterPayloadFormat decodes the payloads of format with decoder, so new notification formats are handled
+// without changing the decoding of existing ones, which cannot be registered again
+func RegisterPayloadFormat(format string, decoder PayloadDecoder) error {
+	if len(format) == 0 || decoder == nil {
+		return errors.New("payload format and decoder must be set")
+	}
+
+	payloadDecodersMu.Lock()
+	defer payloadDecodersMu.Unlock()
+	if _, ok := payloadDecoders[format]; ok {
+		return fmt.Errorf("payload format %s is already registered", format)
+	}
+	payloadDecoders[format] = decoder
+	return nil
+}
+
+// Decode decodes the data and attributes of a notification message into an event, with the decoder registered
+// for its payloadFormat attribute
+func Decode(data []byte, attributes map[string]string, mode PayloadMode) (Event, error) {
+	var ev Event
+
+	eventType, ok := eventTypes[attributes["eventType"]]
+	if !ok {
+		return ev, fmt.Errorf("unsupported event type %q", attributes["eventType"])
+	}
+	ev.Type = eventType
+
+	bucket, name := attributes["bucketId"], attributes["objectId"]
+	if len(bucket) == 0 || len(name) == 0 {
+		return ev, errors.New("notification has no bucketId or objectId attribute")
+	}
+	ev.Object.Bucket, ev.Object.Name = bucket, name
+
+	if generation := attributes["objectGeneration"]; len(generation) > 0 {
+		var err error
+		if ev.Generation, err = strconv.ParseInt(generation, 10, 64); err != nil || ev.Generation < 0 {
+			return ev, fmt.Errorf("invalid objectGeneration %q", generation)
+		}
+	}
+
+	if eventTime := attributes["eventTime"]; len(eventTime) > 0 {
+		var err error
+		if ev.EventTime, err = time.Parse(time.RFC3339Nano, eventTime); err != nil {
+			return ev, fmt.Errorf("invalid eventTime %q", eventTime)
+		}
+	}
+
+	format := attributes["payloadFormat"]
+	payloadDecodersMu.RLock()
+	decoder, ok := payloadDecoders[format]
+	payloadDecodersMu.RUnlock()
+	if !ok {
+		if mode == PayloadLenient {
+			return ev, ErrMetadataMissing
+		}
+		return ev, fmt.Errorf("%w %q", ErrUnknownPayloadFormat, format)
+	}
+
+	if err := decoder(data, &ev); err != nil {
+		return ev, err
+	}
+
+	if ev.Object.Bucket != bucket || ev.Object.Name != name {
+		if mode != PayloadLenient {
+			return ev, fmt.Errorf("payload names %s/%s but attributes name %s/%s", ev.Object.Bucket, ev.Object.Name, bucket, name)
+		}
+		ev.Object.Bucket, ev.Object.Name = bucket, name
+	}
+	return ev, nil
+}
+
+// decodeNone returns ErrMetadataMissing, messages of the NONE format carrying attributes only
+func decodeNone(data []byte, ev *Event) error {
+	return ErrMetadataMissing
+}
+
+// objectResource holds the fields of a JSON API object resource the index keeps, 64-bit integers being strings
+type objectResource struct {
+	Bucket         string     `json:"bucket"`
+	Name           string     `json:"name"`
+	Generation     string     `json:"generation"`
+	Metageneration string     `json:"metageneration"`
+	Size           string     `json:"size"`
+	StorageClass   string     `json:"storageClass"`
+	TimeCreated    time.Time  `json:"timeCreated"`
+	Updated        time.Time  `json:"updated"`
+	CustomTime     *time.Time `json:"customTime"`
+}
+
+// decodeJSONAPIV1 decodes the object resource of JSON_API_V1 payloads
+func decodeJSONAPIV1(data []byte, ev *Event) error {
+	var obj objectResource
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid JSON_API_V1 payload: %w", err)
+	}
+
+	size, err := strconv.ParseInt(obj.Size, 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid object size %q", obj.Size)
+	}
+	if len(obj.Generation) > 0 {
+		if ev.Generation, err = strconv.ParseInt(obj.Generation, 10, 64); err != nil || ev.Generation < 0 {
+			return fmt.Errorf("invalid object generation %q", obj.Generation)
+		}
+	}
+	if len(obj.Metageneration) > 0 {
+		if ev.Metageneration, err = strconv.ParseInt(obj.Metageneration, 10, 64); err != nil || ev.Metageneration < 0 {
+			return fmt.Errorf("invalid object metageneration %q", obj.Metageneration)
+		}
+	}
+
+	ev.Object.Bucket = obj.Bucket
+	ev.Object.Name = obj.Name
+	ev.Object.Size = size
+	ev.Object.StorageClass = obj.StorageClass
+	ev.Object.Created = obj.TimeCreated
+	ev.Object.Updated = obj.Updated
+	ev.Object.CustomTime = obj.CustomTime
+	return nil
+}
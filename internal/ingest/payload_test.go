@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	attributes := func(eventType, format, name string) map[string]string {
+		return map[string]string{
+			"eventType":        eventType,
+			"payloadFormat":    format,
+			"bucketId":         "mock",
+			"objectId":         name,
+			"objectGeneration": "2",
+		}
+	}
+	payload := []byte(`{"kind": "storage#object", "bucket": "mock", "name": "a/file", "generation": "2", "metageneration": "3", "size": "10",
+		"storageClass": "NEARLINE", "timeCreated": "2024-10-01T00:00:00Z", "updated": "2024-10-01T01:00:00Z", "md5Hash": "mock"}`)
+
+	testCases := []struct {
+		name       string
+		data       []byte
+		attributes map[string]string
+		mode       PayloadMode
+		wantType   EventType
+		wantName   string
+		wantSize   int64
+		wantErr    error
+	}{
+		{"JSON API payload", payload, attributes("OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file"), PayloadStrict, EventFinalize, "a/file", 10, nil},
+		{"Metadata update", payload, attributes("OBJECT_METADATA_UPDATE", PayloadJSONAPIV1, "a/file"), PayloadStrict, EventMetadataUpdate, "a/file", 10, nil},
+		{"Attributes only", nil, attributes("OBJECT_DELETE", PayloadNone, "a/file"), PayloadStrict, EventDelete, "a/file", 0, ErrMetadataMissing},
+		{"Unknown format in strict mode", nil, attributes("OBJECT_DELETE", "JSON_API_V2", "a/file"), PayloadStrict, EventDelete, "a/file", 0, ErrUnknownPayloadFormat},
+		{"Unknown format in lenient mode", nil, attributes("OBJECT_ARCHIVE", "JSON_API_V2", "a/file"), PayloadLenient, EventArchive, "a/file", 0, ErrMetadataMissing},
+		{"Attributes prevail in lenient mode", payload, attributes("OBJECT_FINALIZE", PayloadJSONAPIV1, "b/file"), PayloadLenient, EventFinalize, "b/file", 10, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev, err := Decode(tc.data, tc.attributes, tc.mode)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Error mismatch: got %v, want %v", err, tc.wantErr)
+			}
+
+			if ev.Type != tc.wantType || ev.Object.Bucket != "mock" || ev.Object.Name != tc.wantName || ev.Generation != 2 || ev.Object.Size != tc.wantSize {
+				t.Errorf("Event mismatch: got %+v", ev)
+			}
+		})
+	}
+
+	withEventTime := attributes("OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file")
+	withEventTime["eventTime"] = "2024-10-01T01:00:00.5Z"
+	ev, err := Decode(payload, withEventTime, PayloadStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Object.StorageClass != "NEARLINE" || !ev.Object.Updated.Equal(time.Date(2024, 10, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Object mismatch: got %+v", ev.Object)
+	}
+	if ev.Metageneration != 3 || !ev.EventTime.Equal(time.Date(2024, 10, 1, 1, 0, 0, 500_000_000, time.UTC)) {
+		t.Errorf("Versions mismatch: got metageneration %d, event time %v", ev.Metageneration, ev.EventTime)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid := map[string]string{"eventType": "OBJECT_FINALIZE", "payloadFormat": PayloadJSONAPIV1, "bucketId": "mock", "objectId": "a/file"}
+	with := func(key, value string) map[string]string {
+		attributes := make(map[string]string, len(valid))
+		for k, v := range valid {
+			attributes[k] = v
+		}
+		attributes[key] = value
+		return attributes
+	}
+
+	testCases := []struct {
+		name       string
+		data       string
+		attributes map[string]string
+	}{
+		{"Unsupported event type", `{}`, with("eventType", "OBJECT_RESTORE")},
+		{"Missing object", `{}`, with("objectId", "")},
+		{"Invalid generation", `{}`, with("objectGeneration", "latest")},
+		{"Malformed payload", `{`, valid},
+		{"Invalid size", `{"bucket": "mock", "name": "a/file", "size": "large"}`, valid},
+		{"Negative size", `{"bucket": "mock", "name": "a/file", "size": "-1"}`, valid},
+		{"Negative generation", `{}`, with("objectGeneration", "-2")},
+		{"Payload disagreeing in strict mode", `{"bucket": "mock", "name": "b/file", "size": "1"}`, valid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tc.data), tc.attributes, PayloadStrict); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestRegisterPayloadFormat(t *testing.T) {
+	if err := RegisterPayloadFormat(PayloadJSONAPIV1, decodeJSONAPIV1); err == nil {
+		t.Error("Expected an error registering a format again")
+	}
+
+	if err := RegisterPayloadFormat("MOCK_V1", func(data []byte, ev *Event) error {
+		ev.Object.Size = int64(len(data))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ev, err := Decode([]byte("mock"), map[string]string{"eventType": "OBJECT_FINALIZE", "payloadFormat": "MOCK_V1", "bucketId": "mock", "objectId": "a/file"}, PayloadStrict)
+	if err != nil || ev.Object.Size != 4 {
+		t.Errorf("Registered format mismatch: got %+v, %v", ev, err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	payload := `{"kind": "storage#object", "bucket": "mock", "name": "a/file", "generation": "2", "metageneration": "3", "size": "10",
+		"storageClass": "NEARLINE", "timeCreated": "2024-10-01T00:00:00Z", "updated": "2024-10-01T01:00:00Z", "customTime": "2024-10-01T00:00:00Z"}`
+	f.Add([]byte(payload), "OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file", "2", "2024-10-01T01:00:00.5Z", false)
+	f.Add([]byte(payload), "OBJECT_METADATA_UPDATE", PayloadJSONAPIV1, "b/file", "", "", true)
+	f.Add([]byte(nil), "OBJECT_DELETE", PayloadNone, "a/file", "2", "", false)
+	f.Add([]byte(nil), "OBJECT_ARCHIVE", "JSON_API_V2", "a/file", "", "", true)
+	f.Add([]byte(`{`), "OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file", "", "", false)
+	f.Add([]byte(`{"bucket": "mock", "name": "a/file", "size": "9223372036854775808"}`), "OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file", "-1", "", false)
+	f.Add([]byte(`{"bucket": "mock", "name": "a/file", "size": "-1", "updated": "10000-01-01T00:00:00Z"}`), "OBJECT_FINALIZE", PayloadJSONAPIV1, "a/file", "", "0001-01-01T00:00:00Z", true)
+	f.Add([]byte(`{"bucket": ["mock"], "name": null, "size": 1}`), "OBJECT_FINALIZE", PayloadJSONAPIV1, "", "latest", "yesterday", false)
+
+	f.Fuzz(func(t *testing.T, data []byte, eventType, format, name, generation, eventTime string, lenient bool) {
+		mode := PayloadStrict
+		if lenient {
+			mode = PayloadLenient
+		}
+		attributes := map[string]string{
+			"eventType":        eventType,
+			"payloadFormat":    format,
+			"bucketId":         "mock",
+			"objectId":         name,
+			"objectGeneration": generation,
+			"eventTime":        eventTime,
+		}
+
+		// Malformed messages are rejected with an error rather than a panic, and decoded events are consistent
+		ev, err := Decode(data, attributes, mode)
+		if err != nil && !errors.Is(err, ErrMetadataMissing) {
+			return
+		}
+		if ev.Object.Bucket != "mock" || ev.Object.Name != name {
+			t.Errorf("Decoded object %s/%s, attributes name mock/%s", ev.Object.Bucket, ev.Object.Name, name)
+		}
+		if ev.Object.Size < 0 || ev.Generation < 0 || ev.Metageneration < 0 {
+			t.Errorf("Decoded negative size or versions: %+v", ev)
+		}
+		if _, ok := eventTypes[eventType]; !ok {
+			t.Errorf("Decoded unsupported event type %q", eventType)
+		}
+	})
+}
@@ -0,0 +1,716 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	// DefaultAckDeadline is how long messages are leased for at a time unless configured otherwise
+	DefaultAckDeadline = time.Minute
+	// DefaultMaxExtension bounds how long messages are kept leased unless configured otherwise
+	DefaultMaxExtension = time.Hour
+	// MinAckDeadline and MaxAckDeadline are the ack deadlines Pub/Sub accepts
+	MinAckDeadline = 10 * time.Second
+	MaxAckDeadline = 10 * time.Minute
+
+	defaultWorkers = 4
+	// Defaults bounding the messages held, as the Pub/Sub client libraries do
+	DefaultMaxOutstandingMessages = 1000
+	DefaultMaxOutstandingBytes    = 1e9
+	defaultWorkerQueueSize        = 10
+
+	// ackFlushInterval is the longest time acknowledgements are batched for
+	ackFlushInterval = 100 * time.Millisecond
+	// settleTimeout bounds the requests settling messages, which are sent during shutdown too
+	settleTimeout = 10 * time.Second
+	// unownedRedeliveryDelay is the pause before redelivering the messages of buckets owned by other subscribers,
+	// short enough not to hold up the owners much
+	unownedRedeliveryDelay = 5 * time.Second
+
+	minPullRetryDelay = time.Second
+	maxPullRetryDelay = time.Minute
+
+	// journalPruneInterval is the time between pruning the journal of messages no restart seeks back to
+	journalPruneInterval = time.Hour
+)
+
+// subscriberStats counts the messages of subscribers by outcome, published in the subscriber expvar
+var subscriberStats = expvar.NewMap("subscriber")
+
+// SubscriberConfig tunes how a Subscriber pulls and leases messages, zero values picking defaults
+type SubscriberConfig struct {
+	// Mode decodes messages deviating from their payload format
+	Mode PayloadMode
+	// Workers is the number of messages applied concurrently
+	Workers int
+	// AckDeadline is how long messages are leased for at a time, leases being extended until messages are applied
+	AckDeadline time.Duration
+	// MaxExtension bounds how long a message is kept leased, after which it is left to be redelivered
+	MaxExtension time.Duration
+
+	// MaxOutstandingMessages and MaxOutstandingBytes bound the messages held, pulled but not settled yet
+	MaxOutstandingMessages int
+	MaxOutstandingBytes    int64
+	// WorkerQueueSize is the number of messages queued per worker, pulls waiting for the worker of a message
+	// once its queue is full
+	WorkerQueueSize int
+	// TargetLatency is the latency of applying messages above which fewer messages are held, 0 to always
+	// hold the maximum
+	TargetLatency time.Duration
+
+	// Shadow evaluates messages without writing to the database, logging and counting the writes applying them
+	// would make, such as to validate a deployment against production traffic on a subscription of its own
+	// Messages are acknowledged once evaluated
+	Shadow bool
+	// SlowApply is the time applying a message above which it is logged with the statements it executed, 0 to
+	// never log them
+	SlowApply time.Duration
+}
+
+// Subscriber applies the notifications of a Pub/Sub subscription to the index
+// Messages are leased from their receipt until they are applied, so slow writes, such as while the database
+// is checkpointed or locked by another writer, don't get them redelivered while they are applied
+// Messages are acknowledged once applied, and redelivered if they fail to apply, while malformed messages and
+// messages without object metadata are acknowledged and counted, as redelivering them would not apply them
+// Messages of the same object are applied in order by the same worker, so they don't contend for its rows
+// Messages with an ordering key are applied strictly in order, by the same worker as the others of their key, and
+// the messages of a key pulled before one of them failed are redelivered after it rather than applied
+type Subscriber struct {
+	sub      Subscription
+	db       *repo.Database
+	apply    func(ctx context.Context, ev Event) error
+	handle   Handler
+	cfg      SubscriberConfig
+	leases   *leaseSet
+	flow     *flowController
+	acks     chan string
+	pulls    atomic.Uint64
+	failures *keyFailures
+
+	// journal records the messages applied, nil unless set by SetJournal
+	journal      repo.JournalRepository
+	subscription string
+	margin       time.Duration
+	pending      *pendingSet
+
+	// owns returns whether the instance owns a bucket, every bucket being owned unless set by SetOwnership
+	owns func(bucket string) bool
+
+	pausedMu sync.Mutex
+	paused   map[string]time.Time
+
+	// middlewares wrap the application of messages, within the built-in ones, as set by Use
+	middlewares []Middleware
+}
+
+func NewSubscriber(sub Subscription, applier *Applier, cfg SubscriberConfig) *Subscriber {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.AckDeadline <= 0 {
+		cfg.AckDeadline = DefaultAckDeadline
+	}
+	if cfg.MaxExtension <= 0 {
+		cfg.MaxExtension = DefaultMaxExtension
+	}
+	if len(cfg.Mode) == 0 {
+		cfg.Mode = PayloadStrict
+	}
+	if cfg.MaxOutstandingMessages <= 0 {
+		cfg.MaxOutstandingMessages = DefaultMaxOutstandingMessages
+	}
+	if cfg.MaxOutstandingBytes <= 0 {
+		cfg.MaxOutstandingBytes = DefaultMaxOutstandingBytes
+	}
+	if cfg.WorkerQueueSize <= 0 {
+		cfg.WorkerQueueSize = defaultWorkerQueueSize
+	}
+
+	var db *repo.Database
+	if applier != nil {
+		db = applier.db
+	}
+
+	return &Subscriber{
+		sub:      sub,
+		db:       db,
+		apply:    applier.Apply,
+		cfg:      cfg,
+		leases:   newLeaseSet(),
+		flow:     newFlowController(cfg.MaxOutstandingMessages, cfg.MaxOutstandingBytes, cfg.TargetLatency),
+		acks:     make(chan string, maxAckIDs),
+		failures: newKeyFailures(),
+		pending:  newPendingSet(),
+		paused:   make(map[string]time.Time),
+	}
+}
+
+// SetJournal records the messages of subscription applied in journal, in the transaction of their writes
+// Messages journaled already are acknowledged without applying them again, and Run seeks the subscription back
+// to the checkpoint of the journal less margin, the messages received before the crash of a previous run being
+// redelivered even if they were acknowledged, as long as the subscription retains acknowledged messages
+// The checkpoint is the publish time of the oldest message held but not applied, which margin covers messages
+// delivered out of publish order, and messages nacked or left to expire, which may be applied by other subscribers
+func (s *Subscriber) SetJournal(journal repo.JournalRepository, subscription string, margin time.Duration) {
+	s.journal = journal
+	s.subscription = subscription
+	s.margin = margin
+}
+
+// SetOwnership only applies the messages of the buckets owns returns true for, such as the buckets claimed by a
+// shard, nacking the others for the instances owning them
+func (s *Subscriber) SetOwnership(owns func(bucket string) bool) {
+	s.owns = owns
+}
+
+// Pause stops applying the messages of bucket until it is resumed, returning them to be redelivered after the
+// ack deadline, so maintenance of the bucket runs without losing its notifications or holding back other buckets
+// Pauses last until Resume or the subscriber stops
+func (s *Subscriber) Pause(bucket string) {
+	s.pausedMu.Lock()
+	defer s.pausedMu.Unlock()
+	if _, ok := s.paused[bucket]; !ok {
+		s.paused[bucket] = time.Now()
+		log.Printf("Paused bucket %s", bucket)
+	}
+}
+
+// Resume applies the messages of bucket again, returning false if it wasn't paused
+func (s *Subscriber) Resume(bucket string) bool {
+	s.pausedMu.Lock()
+	defer s.pausedMu.Unlock()
+	if _, ok := s.paused[bucket]; !ok {
+		return false
+	}
+	delete(s.paused, bucket)
+	log.Printf("Resumed bucket %s", bucket)
+	return true
+}
+
+// Paused lists the paused buckets sorted by name
+func (s *Subscriber) Paused() []model.PausedBucket {
+	s.pausedMu.Lock()
+	defer s.pausedMu.Unlock()
+
+	paused := make([]model.PausedBucket, 0, len(s.paused))
+	for bucket, since := range s.paused {
+		paused = append(paused, model.PausedBucket{Bucket: bucket, Since: since})
+	}
+	slices.SortFunc(paused, func(a, b model.PausedBucket) int { return strings.Compare(a.Bucket, b.Bucket) })
+	return paused
+}
+
+// Use wraps the application of messages in middlewares, the first one running first
+// They run within the built-in middlewares filtering, tracing and counting messages, and wrap the dry runs of
+// shadow subscribers and the journal, so they see the messages about to be applied and the errors applying them
+func (s *Subscriber) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// handler chains the middlewares applying the event of a message
+func (s *Subscriber) handler() Handler {
+	var middlewares []Middleware
+	if s.owns != nil {
+		middlewares = append(middlewares, Owned(s.owns, unownedRedeliveryDelay))
+	}
+	// Redelivering right away would spin on the messages of a paused bucket until it is resumed
+	middlewares = append(middlewares, Paused(s.isPaused, s.cfg.AckDeadline))
+	if s.cfg.SlowApply > 0 {
+		middlewares = append(middlewares, Trace(s.cfg.SlowApply))
+	}
+	middlewares = append(middlewares, Metrics())
+	middlewares = append(middlewares, s.middlewares...)
+	if s.cfg.Shadow {
+		middlewares = append(middlewares, DryRun(s.db))
+	}
+	if s.journal != nil {
+		middlewares = append(middlewares, Dedup(s.journal, s.subscription, s.pending.watermark))
+	}
+
+	return Chain(func(ctx context.Context, msg *Message, ev Event) error {
+		return s.apply(ctx, ev)
+	}, middlewares...)
+}
+
+func (s *Subscriber) isPaused(bucket string) bool {
+	s.pausedMu.Lock()
+	defer s.pausedMu.Unlock()
+	_, ok := s.paused[bucket]
+	return ok
+}
+
+// Run pulls and applies messages until ctx is cancelled, finishing the messages in flight before returning
+func (s *Subscriber) Run(ctx context.Context) {
+	s.handle = s.handler()
+	if s.journal != nil {
+		s.seekToCheckpoint(ctx)
+	}
+
+	// Messages in flight are applied and settled after ctx is cancelled
+	settleCtx, stopSettling := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopSettling()
+
+	var settlers sync.WaitGroup
+	settlers.Add(2)
+	go func() {
+		defer settlers.Done()
+		s.flushAcks(settleCtx)
+	}()
+	go func() {
+		defer settlers.Done()
+		s.extendLeases(settleCtx)
+	}()
+	if s.journal != nil {
+		settlers.Add(1)
+		go func() {
+			defer settlers.Done()
+			s.pruneJournal(settleCtx)
+		}()
+	}
+
+	queues := make([]chan *Message, s.cfg.Workers)
+	var workers sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *Message, s.cfg.WorkerQueueSize)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range queues[i] {
+				// Messages still queued when stopping are redelivered, only the ones being applied are finished
+				if ctx.Err() != nil {
+					s.nack(settleCtx, msg)
+					continue
+				}
+				s.process(settleCtx, msg)
+			}
+		}()
+	}
+
+	s.pull(ctx, settleCtx, queues)
+	for _, queue := range queues {
+		close(queue)
+	}
+	workers.Wait()
+
+	close(s.acks)
+	stopSettling()
+	settlers.Wait()
+}
+
+// pull hands the messages of the subscription to the queues of their workers until ctx is cancelled, retrying
+// failed pulls with exponential backoff
+func (s *Subscriber) pull(ctx, settleCtx context.Context, queues []chan *Message) {
+	delay := minPullRetryDelay
+	for {
+		n, err := s.flow.available(ctx)
+		if err != nil {
+			return
+		}
+
+		pull := s.pulls.Add(1)
+		messages, err := s.sub.Pull(ctx, n)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error pulling messages, retrying in %v: %v", delay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxPullRetryDelay)
+			continue
+		}
+		delay = minPullRetryDelay
+
+		// Messages are leased for the configured deadline rather than the default of the subscription
+		ackIDs := make([]string, len(messages))
+		for i, msg := range messages {
+			msg.pull = pull
+			ackIDs[i] = msg.AckID
+			s.leases.add(msg.AckID)
+			s.pending.add(msg)
+			s.flow.acquire(msg.size())
+		}
+		subscriberStats.Add("received", int64(len(messages)))
+		s.modifyAckDeadline(settleCtx, ackIDs, s.cfg.AckDeadline)
+
+		for i, msg := range messages {
+			select {
+			case queues[msg.worker(len(queues))] <- msg:
+			case <-ctx.Done():
+				// Messages not handed out yet are redelivered right away, possibly to other subscribers
+				s.nack(settleCtx, messages[i:]...)
+				return
+			}
+		}
+	}
+}
+
+// process applies the event of msg and settles msg
+func (s *Subscriber) process(ctx context.Context, msg *Message) {
+	if len(msg.OrderingKey) > 0 && s.failures.blocks(msg) {
+		subscriberStats.Add("reordered", 1)
+		s.nack(ctx, msg)
+		return
+	}
+
+	ev, err := Decode(msg.Data, msg.Attributes, s.cfg.Mode)
+	switch {
+	case errors.Is(err, ErrMetadataMissing):
+		log.Printf("Skipping message %s without object metadata: %v", msg.ID, ev)
+		subscriberStats.Add("unresolved", 1)
+		s.ack(msg)
+		return
+	case err != nil:
+		log.Printf("Skipping malformed message %s: %v", msg.ID, err)
+		subscriberStats.Add("malformed", 1)
+		s.ack(msg)
+		return
+	}
+	ev.Received = msg.PublishTime
+	ev.Ordered = len(msg.OrderingKey) > 0
+
+	start := time.Now()
+	err = s.handle(ctx, msg, ev)
+	var redelivery *RedeliveryError
+	switch {
+	case errors.As(err, &redelivery):
+		subscriberStats.Add(redelivery.Reason, 1)
+		s.nackAfter(ctx, redelivery.Delay, msg)
+		return
+	case errors.Is(err, ErrDuplicate):
+		subscriberStats.Add("duplicate", 1)
+		s.ack(msg)
+		return
+	}
+
+	s.flow.observe(time.Since(start))
+	if err != nil {
+		log.Printf("Error applying %v of message %s, redelivering it: %v", ev, msg.ID, err)
+		subscriberStats.Add("failed", 1)
+		if len(msg.OrderingKey) > 0 {
+			s.failures.fail(msg, s.pulls.Load())
+		}
+		s.nack(ctx, msg)
+		return
+	}
+	subscriberStats.Add("applied", 1)
+	s.ack(msg)
+}
+
+// ack acknowledges a message with the next batch of acknowledgements
+func (s *Subscriber) ack(msg *Message) {
+	s.leases.remove(msg.AckID)
+	s.pending.remove(msg)
+	s.flow.release(msg.size())
+	s.acks <- msg.AckID
+}
+
+// nack redelivers messages right away
+func (s *Subscriber) nack(ctx context.Context, messages ...*Message) {
+	s.nackAfter(ctx, 0, messages...)
+}
+
+// nackAfter returns messages to be redelivered once delay passed
+// They may be redelivered to other subscribers, so they no longer hold back the checkpoint of this one
+func (s *Subscriber) nackAfter(ctx context.Context, delay time.Duration, messages ...*Message) {
+	ackIDs := make([]string, len(messages))
+	for i, msg := range messages {
+		ackIDs[i] = msg.AckID
+		s.leases.remove(msg.AckID)
+		s.pending.remove(msg)
+		s.flow.release(msg.size())
+	}
+	s.modifyAckDeadline(ctx, ackIDs, delay)
+}
+
+func (s *Subscriber) modifyAckDeadline(ctx context.Context, ackIDs []string, deadline time.Duration) {
+	if len(ackIDs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, settleTimeout)
+	defer cancel()
+	if err := s.sub.ModifyAckDeadline(ctx, ackIDs, deadline); err != nil {
+		log.Printf("Error modifying the ack deadline of %d messages: %v", len(ackIDs), err)
+	}
+}
+
+// flushAcks acknowledges the messages of s.acks in batches until it is closed
+// Messages whose acknowledgement fails are redelivered once their lease expires, and applied again
+func (s *Subscriber) flushAcks(ctx context.Context) {
+	ticker := time.NewTicker(ackFlushInterval)
+	defer ticker.Stop()
+
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, settleTimeout)
+		defer cancel()
+		if err := s.sub.Acknowledge(ctx, batch); err != nil {
+			log.Printf("Error acknowledging %d messages: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case ackID, ok := <-s.acks:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, ackID); len(batch) >= maxAckIDs {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// extendLeases extends the leases of the messages in flight every half ack deadline until ctx is cancelled,
+// leaving messages leased for longer than the maximum extension to be redelivered
+func (s *Subscriber) extendLeases(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.AckDeadline / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		extend, expired := s.leases.due(time.Now().Add(-s.cfg.MaxExtension))
+		if len(expired) > 0 {
+			log.Printf("Leases of %d messages reached their maximum extension of %v, leaving them to be redelivered", len(expired), s.cfg.MaxExtension)
+			subscriberStats.Add("expired", int64(len(expired)))
+			s.pending.drop(expired)
+		}
+		if len(extend) > 0 {
+			subscriberStats.Add("extended", int64(len(extend)))
+			s.modifyAckDeadline(ctx, extend, s.cfg.AckDeadline)
+		}
+	}
+}
+
+// leaseSet tracks the messages in flight by ack ID, with the time they were received
+type leaseSet struct {
+	mu       sync.Mutex
+	received map[string]time.Time
+}
+
+func newLeaseSet() *leaseSet {
+	return &leaseSet{received: make(map[string]time.Time)}
+}
+
+func (l *leaseSet) add(ackID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.received[ackID] = time.Now()
+}
+
+func (l *leaseSet) remove(ackID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.received, ackID)
+}
+
+// due returns the messages to extend the leases of, and removes and returns the ones received before cutoff
+func (l *leaseSet) due(cutoff time.Time) (extend, expired []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ackID, received := range l.received {
+		if received.Before(cutoff) {
+			expired = append(expired, ackID)
+			delete(l.received, ackID)
+		} else {
+			extend = append(extend, ackID)
+		}
+	}
+	return extend, expired
+}
+
+// keyFailure is the failed message of an ordering key, and the last pull started before it failed
+type keyFailure struct {
+	id   string
+	pull uint64
+}
+
+// keyFailures tracks the ordering keys whose messages failed to apply
+// Pub/Sub redelivers a failed message followed by the messages of its key after it, so the messages of the key
+// pulled until then are redelivered rather than applied out of order, until the failed message is redelivered
+// or messages arrive from a later pull, in case it was redelivered to another subscriber
+type keyFailures struct {
+	mu     sync.Mutex
+	failed map[string]keyFailure
+}
+
+func newKeyFailures() *keyFailures {
+	return &keyFailures{failed: make(map[string]keyFailure)}
+}
+
+// fail marks the messages of the key of msg as following it, up to the pull numbered pull
+func (k *keyFailures) fail(msg *Message, pull uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.failed[msg.OrderingKey] = keyFailure{id: msg.ID, pull: pull}
+}
+
+// blocks returns whether msg follows a failed message of its key, forgetting the failure once messages of the key
+// are redelivered
+func (k *keyFailures) blocks(msg *Message) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	failure, ok := k.failed[msg.OrderingKey]
+	if !ok {
+		return false
+	}
+	if msg.ID == failure.id || msg.pull > failure.pull {
+		delete(k.failed, msg.OrderingKey)
+		return false
+	}
+	return true
+}
+
+// Replay seeks the subscription back to since, or to snapshot if set, reprocessing the messages redelivered to
+// recover from applies gone wrong, the applier ignoring the versions older than the ones indexed
+// The messages journaled since since, or every message when seeking to a snapshot, are forgotten first so they are
+// applied again, once, the journal still skipping their redeliveries
+func (s *Subscriber) Replay(ctx context.Context, since time.Time, snapshot string) error {
+	if s.journal != nil {
+		if len(snapshot) > 0 {
+			since = time.Time{}
+		}
+		forgotten, err := s.journal.Rewind(ctx, s.subscription, since)
+		if err != nil {
+			return fmt.Errorf("error rewinding the journal: %w", err)
+		}
+		log.Printf("Forgot %d journaled messages to replay them", forgotten)
+	}
+
+	if len(snapshot) > 0 {
+		if err := s.sub.SeekSnapshot(ctx, snapshot); err != nil {
+			return fmt.Errorf("error seeking to snapshot %s: %w", snapshot, err)
+		}
+		log.Printf("Replaying messages from snapshot %s", snapshot)
+		return nil
+	}
+
+	if err := s.sub.Seek(ctx, since); err != nil {
+		return fmt.Errorf("error seeking to %v: %w", since, err)
+	}
+	log.Printf("Replaying messages published since %v", since)
+	return nil
+}
+
+// seekToCheckpoint seeks the subscription back to the checkpoint of the journal less the margin, if any
+func (s *Subscriber) seekToCheckpoint(ctx context.Context) {
+	checkpoint, err := s.journal.Checkpoint(ctx, s.subscription)
+	if errors.Is(err, repo.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading the checkpoint of %s, not seeking: %v", s.subscription, err)
+		return
+	}
+
+	t := checkpoint.Add(-s.margin)
+	if err := s.sub.Seek(ctx, t); err != nil {
+		log.Printf("Error seeking %s to %v: %v", s.subscription, t, err)
+		return
+	}
+	log.Printf("Seeked %s back to %v, %v before its checkpoint", s.subscription, t, s.margin)
+}
+
+// pruneJournal forgets the journaled messages published before the checkpoint less the margin every
+// journalPruneInterval, as restarts don't seek back to them
+// Messages redelivered after being forgotten are applied again, the applier ignoring stale versions
+func (s *Subscriber) pruneJournal(ctx context.Context) {
+	ticker := time.NewTicker(journalPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkpoint, err := s.journal.Checkpoint(ctx, s.subscription)
+		if errors.Is(err, repo.ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			_, err = s.journal.Prune(ctx, s.subscription, checkpoint.Add(-s.margin))
+		}
+		if err != nil {
+			log.Printf("Error pruning the journal of %s: %v", s.subscription, err)
+		}
+	}
+}
+
+// pendingSet tracks the messages held but not settled yet by ack ID, with their publish time
+// Each delivery of a message has its own ack ID, so redeliveries are tracked apart from the expired leases of
+// previous deliveries
+type pendingSet struct {
+	mu        sync.Mutex
+	published map[string]time.Time
+}
+
+func newPendingSet() *pendingSet {
+	return &pendingSet{published: make(map[string]time.Time)}
+}
+
+func (p *pendingSet) add(msg *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published[msg.AckID] = msg.PublishTime
+}
+
+func (p *pendingSet) remove(msg *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.published, msg.AckID)
+}
+
+// drop removes the messages of ackIDs, whose leases expired
+func (p *pendingSet) drop(ackIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ackID := range ackIDs {
+		delete(p.published, ackID)
+	}
+}
+
+// watermark returns the publish time of the oldest pending message
+func (p *pendingSet) watermark() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var oldest time.Time
+	for _, published := range p.published {
+		if oldest.IsZero() || published.Before(oldest) {
+			oldest = published
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,598 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+// fakeSubscription delivers batches of messages, recording how they are settled
+// Batches of redeliveries are delivered once the message they are keyed by is nacked
+type fakeSubscription struct {
+	mu           sync.Mutex
+	batches      [][]*Message
+	redeliveries map[string][]*Message
+	pulled       []int
+	acked        []string
+	deadlines    map[string][]time.Duration
+	seeks        []time.Time
+	snapshots    []string
+}
+
+func newFakeSubscription(batches ...[]*Message) *fakeSubscription {
+	return &fakeSubscription{batches: batches, deadlines: make(map[string][]time.Duration)}
+}
+
+func (f *fakeSubscription) Pull(ctx context.Context, max int) ([]*Message, error) {
+	for {
+		f.mu.Lock()
+		if len(f.batches) > 0 {
+			batch := f.batches[0]
+			f.batches = f.batches[1:]
+			f.pulled = append(f.pulled, max)
+			f.mu.Unlock()
+			return batch, nil
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (f *fakeSubscription) Acknowledge(ctx context.Context, ackIDs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked = append(f.acked, ackIDs...)
+	return nil
+}
+
+func (f *fakeSubscription) ModifyAckDeadline(ctx context.Context, ackIDs []string, deadline time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ackID := range ackIDs {
+		f.deadlines[ackID] = append(f.deadlines[ackID], deadline)
+		if batch, ok := f.redeliveries[ackID]; ok && deadline == 0 {
+			f.batches = append(f.batches, batch)
+			delete(f.redeliveries, ackID)
+		}
+	}
+	return nil
+}
+
+func (f *fakeSubscription) Seek(ctx context.Context, t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seeks = append(f.seeks, t)
+	return nil
+}
+
+func (f *fakeSubscription) SeekSnapshot(ctx context.Context, snapshot string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshots = append(f.snapshots, snapshot)
+	return nil
+}
+
+// settled returns the acknowledged messages and the ack deadlines of each message
+func (f *fakeSubscription) settled() ([]string, map[string][]time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	acked := slices.Clone(f.acked)
+	slices.Sort(acked)
+	deadlines := make(map[string][]time.Duration, len(f.deadlines))
+	for ackID, d := range f.deadlines {
+		deadlines[ackID] = slices.Clone(d)
+	}
+	return acked, deadlines
+}
+
+func finalizeMessage(id, name string) *Message {
+	return &Message{
+		ID:    id,
+		AckID: "ack-" + id,
+		Data: []byte(`{"bucket": "mock", "name": "` + name + `", "generation": "1", "size": "10", "storageClass": "STANDARD",
+			"timeCreated": "2024-10-01T00:00:00Z", "updated": "2024-10-01T00:00:00Z"}`),
+		Attributes: map[string]string{
+			"eventType":        "OBJECT_FINALIZE",
+			"payloadFormat":    PayloadJSONAPIV1,
+			"bucketId":         "mock",
+			"objectId":         name,
+			"objectGeneration": "1",
+		},
+		PublishTime: time.Date(2024, 10, 1, 0, 0, 1, 0, time.UTC),
+	}
+}
+
+// runSubscriber runs s until every message of sub was settled, or fails the test after a while
+func runSubscriber(t *testing.T, s *Subscriber, sub *fakeSubscription, messages int) {
+	t.Helper()
+	runSubscriberUntil(t, s, sub, func(acked []string, deadlines map[string][]time.Duration) bool {
+		nacked := 0
+		for _, d := range deadlines {
+			if d[len(d)-1] == 0 {
+				nacked++
+			}
+		}
+		return len(acked)+nacked >= messages
+	})
+}
+
+// runSubscriberUntil runs s until the messages settled in sub satisfy done, or fails the test after a while
+func runSubscriberUntil(t *testing.T, s *Subscriber, sub *fakeSubscription, done func(acked []string, deadlines map[string][]time.Duration) bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		acked, deadlines := sub.settled()
+		if done(acked, deadlines) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Messages not settled: acked %v, deadlines %v", acked, deadlines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+}
+
+func TestSubscriber(t *testing.T) {
+	unresolved := finalizeMessage("3", "c")
+	unresolved.Data = nil
+	unresolved.Attributes["payloadFormat"] = PayloadNone
+	malformed := finalizeMessage("4", "d")
+	malformed.Data = []byte("{")
+
+	sub := newFakeSubscription(
+		[]*Message{finalizeMessage("1", "a"), finalizeMessage("2", "fail")},
+		[]*Message{unresolved, malformed},
+	)
+
+	var mu sync.Mutex
+	var applied []string
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 2})
+	s.apply = func(ctx context.Context, ev Event) error {
+		if ev.Object.Name == "fail" {
+			return errors.New("mock error")
+		}
+		if ev.Received.IsZero() {
+			t.Errorf("Event received without the publish time: %v", ev)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, ev.Object.Name)
+		return nil
+	}
+
+	runSubscriber(t, s, sub, 4)
+
+	if !slices.Equal(applied, []string{"a"}) {
+		t.Errorf("Applied mismatch: got %v", applied)
+	}
+	// Messages which can't be applied on redelivery are acknowledged too
+	acked, deadlines := sub.settled()
+	if want := []string{"ack-1", "ack-3", "ack-4"}; !slices.Equal(acked, want) {
+		t.Errorf("Acknowledged mismatch: got %v, want %v", acked, want)
+	}
+	// Messages are leased for the ack deadline on receipt, and failed ones are redelivered right away
+	if got := deadlines["ack-2"]; !slices.Equal(got, []time.Duration{DefaultAckDeadline, 0}) {
+		t.Errorf("Deadlines of the failed message mismatch: got %v", got)
+	}
+	if got := deadlines["ack-1"]; !slices.Equal(got, []time.Duration{DefaultAckDeadline}) {
+		t.Errorf("Deadlines of the applied message mismatch: got %v", got)
+	}
+}
+
+func TestSubscriberExtendsLeases(t *testing.T) {
+	const ackDeadline = 20 * time.Millisecond
+
+	t.Run("Extends leases of messages in flight", func(t *testing.T) {
+		sub := newFakeSubscription([]*Message{finalizeMessage("1", "a")})
+		s := NewSubscriber(sub, nil, SubscriberConfig{AckDeadline: ackDeadline})
+		s.apply = func(ctx context.Context, ev Event) error {
+			time.Sleep(10 * ackDeadline)
+			return nil
+		}
+
+		runSubscriber(t, s, sub, 1)
+
+		acked, deadlines := sub.settled()
+		if !slices.Equal(acked, []string{"ack-1"}) {
+			t.Errorf("Acknowledged mismatch: got %v", acked)
+		}
+		// The lease is extended every half ack deadline while the message is applied
+		if got := deadlines["ack-1"]; len(got) < 5 || slices.ContainsFunc(got, func(d time.Duration) bool { return d != ackDeadline }) {
+			t.Errorf("Extended deadlines mismatch: got %v", got)
+		}
+	})
+
+	t.Run("Stops extending past the maximum extension", func(t *testing.T) {
+		release := make(chan struct{})
+		sub := newFakeSubscription([]*Message{finalizeMessage("1", "a")})
+		s := NewSubscriber(sub, nil, SubscriberConfig{AckDeadline: ackDeadline, MaxExtension: 2 * ackDeadline})
+		s.apply = func(ctx context.Context, ev Event) error {
+			<-release
+			return nil
+		}
+
+		go func() {
+			time.Sleep(20 * ackDeadline)
+			close(release)
+		}()
+		runSubscriber(t, s, sub, 1)
+
+		_, deadlines := sub.settled()
+		if got := deadlines["ack-1"]; len(got) > 5 {
+			t.Errorf("Lease extended past the maximum extension: got %v", got)
+		}
+	})
+}
+
+func TestSubscriberNacksOnShutdown(t *testing.T) {
+	block := make(chan struct{})
+	sub := newFakeSubscription([]*Message{finalizeMessage("1", "a"), finalizeMessage("2", "b"), finalizeMessage("3", "c")})
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 1})
+	s.apply = func(ctx context.Context, ev Event) error {
+		<-block
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	// The message being applied when stopping is still applied and acknowledged
+	time.Sleep(50 * time.Millisecond)
+	close(block)
+	<-done
+
+	acked, deadlines := sub.settled()
+	if !slices.Equal(acked, []string{"ack-1"}) {
+		t.Errorf("Acknowledged mismatch: got %v", acked)
+	}
+	for _, ackID := range []string{"ack-2", "ack-3"} {
+		if got := deadlines[ackID]; len(got) == 0 || got[len(got)-1] != 0 {
+			t.Errorf("Deadlines of %s mismatch, want it nacked: got %v", ackID, got)
+		}
+	}
+}
+
+func TestSubscriberFlowControl(t *testing.T) {
+	sub := newFakeSubscription(
+		[]*Message{finalizeMessage("1", "a"), finalizeMessage("2", "a")},
+		[]*Message{finalizeMessage("3", "a")},
+	)
+
+	var mu sync.Mutex
+	var applied []string
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 4, MaxOutstandingMessages: 2})
+	s.apply = func(ctx context.Context, ev Event) error {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, ev.Object.Name)
+		return nil
+	}
+
+	runSubscriber(t, s, sub, 3)
+
+	// The second pull waits for a message to be settled, and pulls no more than were settled
+	sub.mu.Lock()
+	pulled := slices.Clone(sub.pulled)
+	sub.mu.Unlock()
+	if len(pulled) != 2 || pulled[0] != 2 || pulled[1] > 2 {
+		t.Errorf("Pulled mismatch: got %v", pulled)
+	}
+	// Messages of the same object are applied by the same worker, one at a time
+	if len(applied) != 3 {
+		t.Errorf("Applied mismatch: got %v", applied)
+	}
+}
+
+func TestSubscriberOrderingKeys(t *testing.T) {
+	keyed := func(id, name string) *Message {
+		msg := finalizeMessage(id, name)
+		msg.OrderingKey = "mock/a"
+		return msg
+	}
+	redelivered := func(msg *Message, name string) *Message {
+		msg = keyed(msg.ID, name)
+		msg.AckID += "'"
+		return msg
+	}
+	// Nacking the failed message redelivers it with the one following it, now applying
+	failed, following := keyed("1", "fail"), keyed("2", "a")
+	sub := newFakeSubscription([]*Message{failed, following, finalizeMessage("3", "b")})
+	sub.redeliveries = map[string][]*Message{"ack-1": {redelivered(failed, "a"), redelivered(following, "a")}}
+
+	var mu sync.Mutex
+	var applied []string
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 4})
+	s.apply = func(ctx context.Context, ev Event) error {
+		if ev.Object.Name == "fail" {
+			return errors.New("mock error")
+		}
+		if ev.Ordered != (ev.Object.Name == "a") {
+			t.Errorf("Ordered mismatch: got %v for %v", ev.Ordered, ev)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, ev.Object.Name)
+		return nil
+	}
+
+	runSubscriber(t, s, sub, 5)
+
+	acked, deadlines := sub.settled()
+	if want := []string{"ack-1'", "ack-2'", "ack-3"}; !slices.Equal(acked, want) {
+		t.Errorf("Acknowledged mismatch: got %v, want %v", acked, want)
+	}
+	// The message following the failed one of its key is redelivered rather than applied out of order
+	if got := deadlines["ack-2"]; len(got) == 0 || got[len(got)-1] != 0 {
+		t.Errorf("Deadlines of the message following the failed one mismatch, want it nacked: got %v", got)
+	}
+}
+
+func TestSubscriberCheckpointAfterNack(t *testing.T) {
+	db := repotest.NewDatabase(t)
+	journal := repo.NewJournalRepository(db)
+
+	failed, later := finalizeMessage("1", "fail"), finalizeMessage("2", "a")
+	later.PublishTime = failed.PublishTime.Add(time.Hour)
+
+	sub := newFakeSubscription([]*Message{failed})
+	s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 1})
+	s.SetJournal(journal, "mock-sub", time.Minute)
+	s.apply = func(ctx context.Context, ev Event) error {
+		if ev.Object.Name == "fail" {
+			return errors.New("mock error")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	waitSettled := func(done func(acked []string, deadlines map[string][]time.Duration) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			acked, deadlines := sub.settled()
+			if done(acked, deadlines) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Messages not settled: acked %v, deadlines %v", acked, deadlines)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitSettled(func(acked []string, deadlines map[string][]time.Duration) bool {
+		d := deadlines["ack-1"]
+		return len(d) > 0 && d[len(d)-1] == 0
+	})
+
+	// Another subscriber applies the nacked message, which no longer holds back the checkpoint of this one
+	redelivered := finalizeMessage("1", "fail")
+	redelivered.AckID += "'"
+	elsewhere := newFakeSubscription([]*Message{redelivered})
+	other := NewSubscriber(elsewhere, nil, SubscriberConfig{Workers: 1})
+	other.SetJournal(journal, "mock-sub", time.Minute)
+	other.apply = func(ctx context.Context, ev Event) error { return nil }
+	runSubscriber(t, other, elsewhere, 1)
+
+	sub.mu.Lock()
+	sub.batches = append(sub.batches, []*Message{later})
+	sub.mu.Unlock()
+	waitSettled(func(acked []string, deadlines map[string][]time.Duration) bool {
+		return slices.Contains(acked, later.AckID)
+	})
+
+	checkpoint, err := journal.Checkpoint(context.Background(), "mock-sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.Equal(later.PublishTime) {
+		t.Errorf("Checkpoint mismatch: got %v, want %v", checkpoint, later.PublishTime)
+	}
+}
+
+func TestSubscriberJournal(t *testing.T) {
+	db := repotest.NewDatabase(t)
+	journal := repo.NewJournalRepository(db)
+
+	failed, following := finalizeMessage("1", "fail"), finalizeMessage("2", "a")
+	following.PublishTime = failed.PublishTime.Add(time.Second)
+
+	var mu sync.Mutex
+	var applied []string
+	newSubscriber := func(sub *fakeSubscription) *Subscriber {
+		s := NewSubscriber(sub, nil, SubscriberConfig{Workers: 1})
+		s.SetJournal(journal, "mock-sub", time.Minute)
+		s.apply = func(ctx context.Context, ev Event) error {
+			if ev.Object.Name == "fail" {
+				return errors.New("mock error")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			applied = append(applied, ev.Object.Name)
+			return nil
+		}
+		return s
+	}
+
+	sub := newFakeSubscription([]*Message{failed, following})
+	runSubscriber(t, newSubscriber(sub), sub, 2)
+
+	// The checkpoint moves past the failed message once it's nacked, the margin covering it
+	checkpoint, err := journal.Checkpoint(context.Background(), "mock-sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.Equal(following.PublishTime) {
+		t.Errorf("Checkpoint mismatch: got %v, want %v", checkpoint, following.PublishTime)
+	}
+	if len(sub.seeks) != 0 {
+		t.Errorf("Seeked without a checkpoint: %v", sub.seeks)
+	}
+
+	// Restarting seeks back to the checkpoint, acknowledging the applied messages redelivered without applying them
+	redelivered := finalizeMessage("2", "a")
+	redelivered.AckID += "'"
+	sub = newFakeSubscription([]*Message{redelivered})
+	runSubscriber(t, newSubscriber(sub), sub, 1)
+
+	if want := []time.Time{following.PublishTime.Add(-time.Minute)}; !slices.EqualFunc(sub.seeks, want, time.Time.Equal) {
+		t.Errorf("Seeks mismatch: got %v, want %v", sub.seeks, want)
+	}
+	acked, _ := sub.settled()
+	if !slices.Equal(acked, []string{"ack-2'"}) {
+		t.Errorf("Acknowledged mismatch: got %v", acked)
+	}
+	if !slices.Equal(applied, []string{"a"}) {
+		t.Errorf("Applied mismatch: got %v", applied)
+	}
+
+	// Replays apply the messages journaled since the time seeked to again
+	sub = newFakeSubscription([]*Message{redelivered})
+	s := newSubscriber(sub)
+	if err := s.Replay(context.Background(), following.PublishTime, ""); err != nil {
+		t.Fatal(err)
+	}
+	runSubscriber(t, s, sub, 1)
+
+	if !slices.ContainsFunc(sub.seeks, following.PublishTime.Equal) {
+		t.Errorf("Seeks of the replay mismatch: got %v", sub.seeks)
+	}
+	if !slices.Equal(applied, []string{"a", "a"}) {
+		t.Errorf("Applied after the replay mismatch: got %v", applied)
+	}
+
+	// Replaying from a snapshot forgets every message journaled
+	if err := s.Replay(context.Background(), time.Time{}, "projects/mock/snapshots/mock"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sub.snapshots, []string{"projects/mock/snapshots/mock"}) {
+		t.Errorf("Snapshots seeked mismatch: got %v", sub.snapshots)
+	}
+	if _, err := journal.Checkpoint(context.Background(), "mock-sub"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after replaying from a snapshot, got %v", err)
+	}
+}
+
+func TestSubscriberOwnership(t *testing.T) {
+	owned, other := finalizeMessage("1", "a"), finalizeMessage("2", "b")
+	other.Data = []byte(strings.Replace(string(other.Data), `"mock"`, `"other"`, 1))
+	other.Attributes["bucketId"] = "other"
+	sub := newFakeSubscription([]*Message{owned, other})
+
+	var mu sync.Mutex
+	var applied []string
+	s := NewSubscriber(sub, nil, SubscriberConfig{})
+	s.SetOwnership(func(bucket string) bool { return bucket == "mock" })
+	s.apply = func(ctx context.Context, ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, ev.Object.Name)
+		return nil
+	}
+
+	runSubscriberUntil(t, s, sub, func(acked []string, deadlines map[string][]time.Duration) bool {
+		return len(acked) == 1 && len(deadlines["ack-2"]) == 2
+	})
+
+	if !slices.Equal(applied, []string{"a"}) {
+		t.Errorf("Applied mismatch: got %v", applied)
+	}
+	// Messages of buckets owned by other instances are redelivered to them after a pause
+	acked, deadlines := sub.settled()
+	if !slices.Equal(acked, []string{"ack-1"}) {
+		t.Errorf("Acknowledged mismatch: got %v", acked)
+	}
+	if got := deadlines["ack-2"]; got[len(got)-1] != unownedRedeliveryDelay {
+		t.Errorf("Deadlines of the message of another bucket mismatch, want it nacked after %v: got %v", unownedRedeliveryDelay, got)
+	}
+}
+
+func TestSubscriberPause(t *testing.T) {
+	pausedMessage := func(ackID string) *Message {
+		msg := finalizeMessage("1", "a")
+		msg.AckID = ackID
+		msg.Data = []byte(strings.Replace(string(msg.Data), `"mock"`, `"paused"`, 1))
+		msg.Attributes["bucketId"] = "paused"
+		return msg
+	}
+	sub := newFakeSubscription([]*Message{pausedMessage("ack-1"), finalizeMessage("2", "b")})
+
+	var mu sync.Mutex
+	var applied []string
+	s := NewSubscriber(sub, nil, SubscriberConfig{})
+	s.apply = func(ctx context.Context, ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, ev.Object.Name)
+		return nil
+	}
+	s.Pause("paused")
+	if got := s.Paused(); len(got) != 1 || got[0].Bucket != "paused" {
+		t.Errorf("Paused mismatch: got %v", got)
+	}
+
+	resumed := false
+	runSubscriberUntil(t, s, sub, func(acked []string, deadlines map[string][]time.Duration) bool {
+		if !resumed && len(acked) == 1 && len(deadlines["ack-1"]) == 2 {
+			// Messages of the paused bucket are redelivered after the ack deadline, other buckets going on
+			mu.Lock()
+			if !slices.Equal(applied, []string{"b"}) {
+				t.Errorf("Applied while paused mismatch: got %v", applied)
+			}
+			mu.Unlock()
+			if got := deadlines["ack-1"]; !slices.Equal(got, []time.Duration{DefaultAckDeadline, DefaultAckDeadline}) {
+				t.Errorf("Deadlines of the paused message mismatch: got %v", got)
+			}
+
+			if !s.Resume("paused") || s.Resume("paused") {
+				t.Error("Resume mismatch, want true once paused only")
+			}
+			resumed = true
+			sub.mu.Lock()
+			sub.batches = append(sub.batches, []*Message{pausedMessage("ack-1'")})
+			sub.mu.Unlock()
+		}
+		return len(acked) == 2
+	})
+
+	if !slices.Equal(applied, []string{"b", "a"}) {
+		t.Errorf("Applied once resumed mismatch: got %v", applied)
+	}
+}
@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// maxAckIDs is the maximum number of ack IDs settled per request, keeping requests within the size limit of Pub/Sub
+const maxAckIDs = 1000
+
+// Message is a notification message delivered by a Pub/Sub subscription
+type Message struct {
+	ID          string
+	AckID       string
+	Data        []byte
+	Attributes  map[string]string
+	PublishTime time.Time
+	OrderingKey string
+
+	// pull numbers the pull which delivered the message
+	pull uint64
+}
+
+// size is the number of bytes held for the message
+func (m *Message) size() int64 {
+	n := len(m.Data)
+	for k, v := range m.Attributes {
+		n += len(k) + len(v)
+	}
+	return int64(n)
+}
+
+// worker returns which of n workers applies the message, the same for every message of its ordering key, or of
+// its object without one
+func (m *Message) worker(n int) int {
+	h := fnv.New32a()
+	if len(m.OrderingKey) > 0 {
+		h.Write([]byte(m.OrderingKey))
+	} else {
+		h.Write([]byte(m.Attributes["bucketId"]))
+		h.Write([]byte{'/'})
+		h.Write([]byte(m.Attributes["objectId"]))
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+// Subscription delivers the messages of a Pub/Sub subscription, which are redelivered unless acknowledged
+// before their ack deadline
+type Subscription interface {
+	// Pull returns up to max messages, waiting a while for some to be published if none are
+	Pull(ctx context.Context, max int) ([]*Message, error)
+	// Acknowledge settles messages, which are not delivered again
+	Acknowledge(ctx context.Context, ackIDs []string) error
+	// ModifyAckDeadline leases messages for deadline from now, 0 redelivering them right away
+	ModifyAckDeadline(ctx context.Context, ackIDs []string, deadline time.Duration) error
+	// Seek redelivers the messages published from t on, and acknowledges the ones published before
+	// Acknowledged messages are only redelivered if the subscription retains them
+	Seek(ctx context.Context, t time.Time) error
+	// SeekSnapshot redelivers the messages unacknowledged when snapshot was taken or published since
+	SeekSnapshot(ctx context.Context, snapshot string) error
+}
+
+// pubsubSubscription pulls messages through the REST API of Pub/Sub
+type pubsubSubscription struct {
+	svc  *pubsub.Service
+	name string
+}
+
+// NewPubSubSubscription returns the subscription named projects/PROJECT/subscriptions/SUBSCRIPTION
+func NewPubSubSubscription(svc *pubsub.Service, name string) Subscription {
+	return &pubsubSubscription{svc: svc, name: name}
+}
+
+func (p *pubsubSubscription) Pull(ctx context.Context, max int) ([]*Message, error) {
+	resp, err := p.svc.Projects.Subscriptions.Pull(p.name, &pubsub.PullRequest{MaxMessages: int64(max)}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(resp.ReceivedMessages))
+	for _, received := range resp.ReceivedMessages {
+		if received.Message == nil {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(received.Message.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data of message %s: %w", received.Message.MessageId, err)
+		}
+		published, _ := time.Parse(time.RFC3339Nano, received.Message.PublishTime)
+
+		messages = append(messages, &Message{
+			ID:          received.Message.MessageId,
+			AckID:       received.AckId,
+			Data:        data,
+			Attributes:  received.Message.Attributes,
+			PublishTime: published,
+			OrderingKey: received.Message.OrderingKey,
+		})
+	}
+	return messages, nil
+}
+
+func (p *pubsubSubscription) Acknowledge(ctx context.Context, ackIDs []string) error {
+	for len(ackIDs) > 0 {
+		n := min(len(ackIDs), maxAckIDs)
+		if _, err := p.svc.Projects.Subscriptions.Acknowledge(p.name, &pubsub.AcknowledgeRequest{AckIds: ackIDs[:n]}).Context(ctx).Do(); err != nil {
+			return err
+		}
+		ackIDs = ackIDs[n:]
+	}
+	return nil
+}
+
+func (p *pubsubSubscription) ModifyAckDeadline(ctx context.Context, ackIDs []string, deadline time.Duration) error {
+	for len(ackIDs) > 0 {
+		n := min(len(ackIDs), maxAckIDs)
+		req := &pubsub.ModifyAckDeadlineRequest{
+			AckIds:             ackIDs[:n],
+			AckDeadlineSeconds: int64(deadline / time.Second),
+			ForceSendFields:    []string{"AckDeadlineSeconds"}, // 0 nacks
+		}
+		if _, err := p.svc.Projects.Subscriptions.ModifyAckDeadline(p.name, req).Context(ctx).Do(); err != nil {
+			return err
+		}
+		ackIDs = ackIDs[n:]
+	}
+	return nil
+}
+
+func (p *pubsubSubscription) Seek(ctx context.Context, t time.Time) error {
+	_, err := p.svc.Projects.Subscriptions.Seek(p.name, &pubsub.SeekRequest{Time: t.UTC().Format(time.RFC3339Nano)}).Context(ctx).Do()
+	return err
+}
+
+func (p *pubsubSubscription) SeekSnapshot(ctx context.Context, snapshot string) error {
+	_, err := p.svc.Projects.Subscriptions.Seek(p.name, &pubsub.SeekRequest{Snapshot: snapshot}).Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,83 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+func TestPubSubSubscription(t *testing.T) {
+	const name = "projects/mock/subscriptions/notifications"
+
+	var mu sync.Mutex
+	requests := make(map[string][]map[string]any)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Invalid request body: %v", err)
+		}
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, ":")+1:]
+		mu.Lock()
+		requests[method] = append(requests[method], body)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if method == "pull" {
+			fmt.Fprint(w, `{"receivedMessages": [{"ackId": "ack-1", "message": {"messageId": "1", "data": "e30=",
+				"attributes": {"eventType": "OBJECT_FINALIZE"}, "publishTime": "2024-10-01T00:00:01.5Z", "orderingKey": "mock"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := pubsub.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewPubSubSubscription(svc, name)
+	ctx := context.Background()
+
+	messages, err := sub.Pull(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Messages mismatch: got %d, want 1", len(messages))
+	}
+	msg := messages[0]
+	published := time.Date(2024, 10, 1, 0, 0, 1, 500_000_000, time.UTC)
+	if msg.ID != "1" || msg.AckID != "ack-1" || string(msg.Data) != "{}" || msg.Attributes["eventType"] != "OBJECT_FINALIZE" ||
+		!msg.PublishTime.Equal(published) || msg.OrderingKey != "mock" {
+		t.Errorf("Message mismatch: got %+v", msg)
+	}
+
+	// Settling is split in requests of at most maxAckIDs ack IDs
+	ackIDs := make([]string, maxAckIDs+1)
+	for i := range ackIDs {
+		ackIDs[i] = fmt.Sprintf("ack-%d", i)
+	}
+	if err := sub.Acknowledge(ctx, ackIDs); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.ModifyAckDeadline(ctx, ackIDs[:1], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := requests["acknowledge"]; len(got) != 2 || len(got[0]["ackIds"].([]any)) != maxAckIDs || len(got[1]["ackIds"].([]any)) != 1 {
+		t.Errorf("Acknowledge requests mismatch: got %d requests", len(got))
+	}
+	// Nacking sends the deadline of 0 explicitly
+	if got := requests["modifyAckDeadline"]; len(got) != 1 || got[0]["ackDeadlineSeconds"] != float64(0) {
+		t.Errorf("ModifyAckDeadline requests mismatch: got %v", got)
+	}
+}
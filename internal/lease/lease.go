@@ -0,0 +1,251 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	ErrLeaseHeld = errors.New("lease is held by another holder")
+	ErrLeaseLost = errors.New("lease was lost")
+	ErrLeaseFree = errors.New("lease is not held")
+
+	errNotExist           = errors.New("lease record does not exist")
+	errPreconditionFailed = errors.New("lease record changed concurrently")
+)
+
+// maxClockSkew bounds how far the clocks of holders may drift apart, leases being given up at least this long,
+// or a tenth of their duration if shorter, before they expire
+const maxClockSkew = time.Second
+
+// record is the content of the lease object
+type record struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// store persists the lease record with compare-and-swap semantics on its generation
+type store interface {
+	// read returns the record and its generation, or errNotExist
+	read(ctx context.Context) (*record, int64, error)
+	// write replaces the record if its generation matches, 0 meaning it must not exist yet
+	write(ctx context.Context, rec *record, generation int64) (int64, error)
+	// delete removes the record if its generation matches
+	delete(ctx context.Context, generation int64) error
+}
+
+// Lease is a time bound exclusive lock, used to elect a single writer among replicas
+// Holders renew the lease in the background and give it up if renewals keep failing, before it expires and
+// another holder may take it
+type Lease struct {
+	store    store
+	holder   string
+	duration time.Duration
+
+	mu         sync.Mutex
+	generation int64
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewGCSLease returns a lease stored in a GCS object, relying on generation preconditions for exclusivity
+func NewGCSLease(obj *storage.ObjectHandle, holder string, duration time.Duration) *Lease {
+	return newLease(&gcsStore{obj}, holder, duration)
+}
+
+func newLease(s store, holder string, duration time.Duration) *Lease {
+	return &Lease{
+		store:    s,
+		holder:   holder,
+		duration: duration,
+	}
+}
+
+// Acquire takes the lease if it is free or expired, and keeps renewing it until Release
+// The returned context is cancelled if the lease is lost, so writers must stop using it
+func (l *Lease) Acquire(ctx context.Context) (context.Context, error) {
+	rec, generation, err := l.store.read(ctx)
+	switch {
+	case errors.Is(err, errNotExist):
+		generation = 0
+	case err != nil:
+		return nil, fmt.Errorf("error reading lease: %w", err)
+	case rec.Holder != l.holder && time.Now().Before(rec.Expires):
+		return nil, fmt.Errorf("%w: %s until %v", ErrLeaseHeld, rec.Holder, rec.Expires)
+	}
+
+	rec = l.newRecord()
+	newGeneration, err := l.store.write(ctx, rec, generation)
+	if errors.Is(err, errPreconditionFailed) {
+		return nil, ErrLeaseHeld // another holder won the race
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing lease: %w", err)
+	}
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+
+	l.mu.Lock()
+	l.generation = newGeneration
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.mu.Unlock()
+
+	go l.renew(leaseCtx, cancel, rec.Expires)
+
+	return leaseCtx, nil
+}
+
+// renew extends the lease expiring at expires every third of its duration, cancelling ctx once it can no longer
+// be held
+// Failed renewals give the lease up once it would expire before the next renewal, allowing for clock skew, so
+// work stops before another holder may take the lease over
+func (l *Lease) renew(ctx context.Context, cancel context.CancelCauseFunc, expires time.Time) {
+	defer close(l.done)
+
+	interval := l.duration / 3
+	skew := min(maxClockSkew, l.duration/10)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Renewals still running once the lease may be lost can't extend it anymore
+		rec := l.newRecord()
+		writeCtx, cancelWrite := context.WithDeadline(ctx, expires.Add(-skew))
+		l.mu.Lock()
+		generation, err := l.store.write(writeCtx, rec, l.generation)
+		if err == nil {
+			l.generation = generation
+		}
+		l.mu.Unlock()
+		cancelWrite()
+
+		switch {
+		case err == nil:
+			expires = rec.Expires
+		case errors.Is(err, errPreconditionFailed):
+			cancel(ErrLeaseLost) // taken over by another holder
+			return
+		case time.Until(expires) < interval+skew:
+			cancel(fmt.Errorf("%w: %v", ErrLeaseLost, err))
+			return
+		default:
+			log.Printf("Error renewing lease, retrying: %v", err)
+		}
+	}
+}
+
+// Release stops renewing the lease and frees it for other holders
+func (l *Lease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	stop, done := l.stop, l.done
+	l.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stop = nil
+
+	if err := l.store.delete(ctx, l.generation); err != nil && !errors.Is(err, errPreconditionFailed) {
+		return fmt.Errorf("error releasing lease: %w", err)
+	}
+	return nil
+}
+
+// Holder returns the holder of the lease and when it expires, or ErrLeaseFree if it is free or expired
+func (l *Lease) Holder(ctx context.Context) (string, time.Time, error) {
+	rec, _, err := l.store.read(ctx)
+	if errors.Is(err, errNotExist) {
+		return "", time.Time{}, ErrLeaseFree
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading lease: %w", err)
+	}
+	if !time.Now().Before(rec.Expires) {
+		return "", time.Time{}, ErrLeaseFree
+	}
+	return rec.Holder, rec.Expires, nil
+}
+
+func (l *Lease) newRecord() *record {
+	return &record{
+		Holder:  l.holder,
+		Expires: time.Now().Add(l.duration),
+	}
+}
+
+type gcsStore struct {
+	obj *storage.ObjectHandle
+}
+
+func (g *gcsStore) read(ctx context.Context) (*record, int64, error) {
+	r, err := g.obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, 0, errNotExist
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	var rec record
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return nil, 0, fmt.Errorf("malformed lease object: %w", err)
+	}
+	return &rec, r.Attrs.Generation, nil
+}
+
+func (g *gcsStore) write(ctx context.Context, rec *record, generation int64) (int64, error) {
+	conds := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		conds = storage.Conditions{DoesNotExist: true}
+	}
+
+	w := g.obj.If(conds).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if err := json.NewEncoder(w).Encode(rec); err != nil {
+		w.Close()
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, gcsError(err)
+	}
+	return w.Attrs().Generation, nil
+}
+
+func (g *gcsStore) delete(ctx context.Context, generation int64) error {
+	return gcsError(g.obj.If(storage.Conditions{GenerationMatch: generation}).Delete(ctx))
+}
+
+// gcsError maps failed generation preconditions to errPreconditionFailed
+func gcsError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == 412 {
+		return errPreconditionFailed
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errPreconditionFailed
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	testCases := []struct {
+		name    string
+		current *record
+		wantErr error
+	}{
+		{
+			"Acquires free lease",
+			nil,
+			nil,
+		},
+		{
+			"Acquires expired lease",
+			&record{Holder: "other", Expires: time.Now().Add(-time.Minute)},
+			nil,
+		},
+		{
+			"Reacquires own lease",
+			&record{Holder: "mock", Expires: time.Now().Add(time.Minute)},
+			nil,
+		},
+		{
+			"Fails acquiring lease held by another holder",
+			&record{Holder: "other", Expires: time.Now().Add(time.Minute)},
+			ErrLeaseHeld,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &memStore{}
+			if tc.current != nil {
+				s.rec, s.generation = tc.current, 1
+			}
+
+			l := newLease(s, "mock", time.Minute)
+			_, err := l.Acquire(context.Background())
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Error mismatch: got %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer l.Release(context.Background())
+
+			if s.rec.Holder != "mock" {
+				t.Errorf("Holder mismatch: got %s, want %s", s.rec.Holder, "mock")
+			}
+		})
+	}
+}
+
+func TestHolder(t *testing.T) {
+	s := &memStore{}
+	l := newLease(s, "mock", time.Minute)
+
+	if _, _, err := l.Holder(context.Background()); !errors.Is(err, ErrLeaseFree) {
+		t.Fatalf("Expected ErrLeaseFree, got %v", err)
+	}
+
+	s.rec, s.generation = &record{Holder: "other", Expires: time.Now().Add(time.Minute)}, 1
+	holder, expires, err := l.Holder(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "other" || !expires.Equal(s.rec.Expires) {
+		t.Errorf("Holder mismatch: got %s until %v", holder, expires)
+	}
+
+	s.rec.Expires = time.Now().Add(-time.Minute)
+	if _, _, err := l.Holder(context.Background()); !errors.Is(err, ErrLeaseFree) {
+		t.Errorf("Expected ErrLeaseFree for an expired lease, got %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	s := &memStore{}
+
+	first := newLease(s, "first", time.Minute)
+	if _, err := first.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newLease(s, "second", time.Minute)
+	if _, err := second.Acquire(context.Background()); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld, got %v", err)
+	}
+
+	if err := first.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := second.Acquire(context.Background()); err != nil {
+		t.Fatalf("Expected released lease to be acquired, got %v", err)
+	}
+	second.Release(context.Background())
+}
+
+func TestRenew(t *testing.T) {
+	s := &memStore{}
+
+	l := newLease(s, "mock", 30*time.Millisecond)
+	ctx, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewals keep the lease alive past its duration
+	time.Sleep(100 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatalf("Expected lease to be renewed, got %v", context.Cause(ctx))
+	}
+
+	// A takeover by another holder is noticed on the next renewal
+	s.mu.Lock()
+	s.rec, s.generation = &record{Holder: "other", Expires: time.Now().Add(time.Minute)}, s.generation+1
+	s.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrLeaseLost) {
+			t.Errorf("Expected ErrLeaseLost, got %v", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected lease context to be cancelled")
+	}
+
+	l.Release(context.Background())
+	if s.rec == nil || s.rec.Holder != "other" {
+		t.Errorf("Expected release to keep the other holder's lease")
+	}
+}
+
+func TestRenewFailing(t *testing.T) {
+	s := &memStore{}
+
+	l := newLease(s, "mock", 300*time.Millisecond)
+	ctx, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release(context.Background())
+
+	s.mu.Lock()
+	expires := s.rec.Expires
+	s.failWrites = errors.New("unavailable")
+	s.mu.Unlock()
+
+	// The lease is given up while it is still held, before another holder may take it over
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrLeaseLost) {
+			t.Errorf("Expected ErrLeaseLost, got %v", cause)
+		}
+		if !time.Now().Before(expires) {
+			t.Errorf("Expected the lease to be given up before it expires at %v", expires)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected lease context to be cancelled")
+	}
+}
+
+// memStore is an in-memory store with the same generation semantics as GCS
+type memStore struct {
+	mu         sync.Mutex
+	rec        *record
+	generation int64
+	// failWrites fails every write with it, when set
+	failWrites error
+}
+
+func (m *memStore) read(ctx context.Context) (*record, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rec == nil {
+		return nil, 0, errNotExist
+	}
+	rec := *m.rec
+	return &rec, m.generation, nil
+}
+
+func (m *memStore) write(ctx context.Context, rec *record, generation int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failWrites != nil {
+		return 0, m.failWrites
+	}
+	if (generation == 0 && m.rec != nil) || (generation != 0 && generation != m.generation) {
+		return 0, errPreconditionFailed
+	}
+	m.rec = rec
+	m.generation++
+	return m.generation, nil
+}
+
+func (m *memStore) delete(ctx context.Context, generation int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rec == nil || generation != m.generation {
+		return errPreconditionFailed
+	}
+	m.rec = nil
+	return nil
+}
@@ -0,0 +1,213 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+)
+
+var storageClasses = []repo.StorageClass{repo.StorageStandard, repo.StorageNearline, repo.StorageColdline, repo.StorageArchive}
+
+// Event is a synthesized object change, as a bucket notification would report it
+type Event struct {
+	Type     EventType
+	Metadata model.Metadata
+	// SizeDelta is the size change applied to parent directories
+	SizeDelta int64
+}
+
+// Config shapes the synthesized bucket
+type Config struct {
+	Bucket string
+	// Objects is the number of objects created over the run
+	Objects int
+	// Depth is the maximum directory depth of object names
+	Depth int
+	// Fanout is the number of directories at each level
+	Fanout int
+	// Churn is the probability in [0, 1) of an event updating or deleting an existing object
+	Churn float64
+	// MaxSize is the maximum object size in bytes
+	MaxSize int64
+}
+
+// Validate checks the configuration describes a bucket that can be generated
+func (c Config) Validate() error {
+	switch {
+	case len(c.Bucket) == 0:
+		return errors.New("bucket is empty")
+	case c.Objects < 0:
+		return errors.New("objects must not be negative")
+	case c.Depth < 0:
+		return errors.New("depth must not be negative")
+	case c.Fanout < 1:
+		return errors.New("fanout must be at least 1")
+	case c.Churn < 0 || c.Churn >= 1:
+		return errors.New("churn must be in [0, 1)")
+	case c.MaxSize < 1:
+		return errors.New("max size must be at least 1")
+	}
+	return nil
+}
+
+// Generator synthesizes a deterministic stream of events for a seed
+type Generator struct {
+	cfg     Config
+	rand    *rand.Rand
+	live    []*model.Metadata
+	created int
+	now     time.Time
+}
+
+func NewGenerator(cfg Config, seed uint64) *Generator {
+	return &Generator{
+		cfg:  cfg,
+		rand: rand.New(rand.NewPCG(seed, seed)),
+		now:  time.Now().UTC(),
+	}
+}
+
+// Next returns the next event, or false once all objects have been created
+func (g *Generator) Next() (Event, bool) {
+	if len(g.live) > 0 && g.rand.Float64() < g.cfg.Churn {
+		return g.churn(), true
+	}
+
+	if g.created >= g.cfg.Objects {
+		return Event{}, false
+	}
+	return g.create(), true
+}
+
+func (g *Generator) create() Event {
+	var name strings.Builder
+	for range g.rand.IntN(g.cfg.Depth + 1) {
+		fmt.Fprintf(&name, "dir-%d/", g.rand.IntN(g.cfg.Fanout))
+	}
+	fmt.Fprintf(&name, "object-%d", g.created)
+	g.created++
+
+	obj := &model.Metadata{
+		Bucket:       g.cfg.Bucket,
+		Name:         name.String(),
+		StorageClass: string(storageClasses[g.rand.IntN(len(storageClasses))]),
+		Size:         g.rand.Int64N(g.cfg.MaxSize) + 1,
+		Created:      g.now,
+		Updated:      g.now,
+	}
+	g.live = append(g.live, obj)
+
+	return Event{Type: EventCreate, Metadata: *obj, SizeDelta: obj.Size}
+}
+
+// churn updates or deletes a random live object with equal probability
+func (g *Generator) churn() Event {
+	i := g.rand.IntN(len(g.live))
+	obj := g.live[i]
+
+	if g.rand.IntN(2) == 0 {
+		g.live[i] = g.live[len(g.live)-1]
+		g.live = g.live[:len(g.live)-1]
+		return Event{Type: EventDelete, Metadata: *obj, SizeDelta: -obj.Size}
+	}
+
+	size := g.rand.Int64N(g.cfg.MaxSize) + 1
+	delta := size - obj.Size
+	obj.Size = size
+	obj.Updated = obj.Updated.Add(time.Second)
+
+	return Event{Type: EventUpdate, Metadata: *obj, SizeDelta: delta}
+}
+
+// Result summarizes a load generation run
+type Result struct {
+	Events   map[EventType]int
+	Errors   int
+	Duration time.Duration
+	P50      time.Duration
+	P99      time.Duration
+}
+
+// EventsPerSecond returns the applied event throughput
+func (r *Result) EventsPerSecond() float64 {
+	var total int
+	for _, n := range r.Events {
+		total += n
+	}
+	return float64(total) / r.Duration.Seconds()
+}
+
+// Run applies all events of g to the repositories, measuring the latency of each event
+func Run(ctx context.Context, g *Generator, metadataRepo repo.MetadataRepository, dirRepo repo.DirectoryRepository) (*Result, error) {
+	res := &Result{Events: make(map[EventType]int)}
+	var latencies []time.Duration
+
+	start := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ev, ok := g.Next()
+		if !ok {
+			break
+		}
+
+		eventStart := time.Now()
+		if err := Apply(ctx, ev, metadataRepo, dirRepo); err != nil {
+			res.Errors++
+		}
+		latencies = append(latencies, time.Since(eventStart))
+		res.Events[ev.Type]++
+	}
+	res.Duration = time.Since(start)
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		res.P50 = latencies[len(latencies)*50/100]
+		res.P99 = latencies[len(latencies)*99/100]
+	}
+	return res, nil
+}
+
+// Apply writes a single event to the repositories, keeping parent directory totals in sync
+func Apply(ctx context.Context, ev Event, metadataRepo repo.MetadataRepository, dirRepo repo.DirectoryRepository) error {
+	m := ev.Metadata
+
+	var countDelta int64
+	switch ev.Type {
+	case EventCreate:
+		if err := metadataRepo.Insert(ctx, &m); err != nil {
+			return err
+		}
+		countDelta = 1
+	case EventUpdate:
+		if err := metadataRepo.Update(ctx, m.Bucket, m.Name, m.Size, m.CustomTime, m.Updated); err != nil {
+			return err
+		}
+	case EventDelete:
+		if err := metadataRepo.Delete(ctx, m.Bucket, m.Name); err != nil {
+			return err
+		}
+		countDelta = -1
+	default:
+		return fmt.Errorf("unknown event type %q", ev.Type)
+	}
+
+	return dirRepo.UpsertParentDirs(ctx, repo.StorageClass(m.StorageClass), m.Bucket, m.Name, ev.SizeDelta, countDelta)
+}
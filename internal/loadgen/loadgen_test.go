@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  Config
+	}{
+		{"Creates flat bucket", Config{Bucket: "mock", Objects: 50, Depth: 0, Fanout: 1, MaxSize: 10}},
+		{"Creates nested bucket", Config{Bucket: "mock", Objects: 100, Depth: 4, Fanout: 3, MaxSize: 100}},
+		{"Creates bucket with churn", Config{Bucket: "mock", Objects: 100, Depth: 3, Fanout: 2, Churn: 0.5, MaxSize: 100}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := repotest.NewDatabase(t)
+
+			if err := tc.cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := Run(context.Background(), NewGenerator(tc.cfg, 1), repo.NewMetadataRepository(db), repo.NewDirectoryRepository(db))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Errors != 0 {
+				t.Fatalf("Expected no errors, got %d", res.Errors)
+			}
+
+			if res.Events[EventCreate] != tc.cfg.Objects {
+				t.Errorf("Create count mismatch: got %d, want %d", res.Events[EventCreate], tc.cfg.Objects)
+			}
+
+			if tc.cfg.Churn == 0 && res.Events[EventUpdate]+res.Events[EventDelete] != 0 {
+				t.Errorf("Expected no churn events, got %v", res.Events)
+			}
+
+			// Directory totals must match the remaining objects
+			var wantCount, wantSize int64
+			if err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM metadata`).Scan(&wantCount, &wantSize); err != nil {
+				t.Fatal(err)
+			}
+
+			var gotCount, gotSize int64
+			err = db.QueryRow(`SELECT count, size_standard + size_nearline + size_coldline + size_archive FROM directory WHERE name = '/'`).Scan(&gotCount, &gotSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotCount != wantCount || gotSize != wantSize {
+				t.Errorf("Root directory mismatch: got count %d size %d, want count %d size %d", gotCount, gotSize, wantCount, wantSize)
+			}
+
+			if wantCount != int64(tc.cfg.Objects-res.Events[EventDelete]) {
+				t.Errorf("Object count mismatch: got %d, want %d", wantCount, tc.cfg.Objects-res.Events[EventDelete])
+			}
+		})
+	}
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	cfg := Config{Bucket: "mock", Objects: 20, Depth: 3, Fanout: 4, Churn: 0.3, MaxSize: 100}
+
+	a, b := NewGenerator(cfg, 42), NewGenerator(cfg, 42)
+	for {
+		evA, okA := a.Next()
+		evB, okB := b.Next()
+		if okA != okB {
+			t.Fatal("Generators ended at different events")
+		}
+		if !okA {
+			break
+		}
+
+		if evA.Type != evB.Type || evA.Metadata.Name != evB.Metadata.Name || evA.SizeDelta != evB.SizeDelta {
+			t.Fatalf("Event mismatch: got %+v, want %+v", evB, evA)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	cfg := Config{Bucket: "mock", Objects: b.N, Depth: 5, Fanout: 10, Churn: 0.2, MaxSize: 1 << 20}
+
+	db := repotest.NewDatabase(b)
+
+	g := NewGenerator(cfg, 1)
+	b.ResetTimer()
+
+	if _, err := Run(context.Background(), g, repo.NewMetadataRepository(db), repo.NewDirectoryRepository(db)); err != nil {
+		b.Fatal(err)
+	}
+}
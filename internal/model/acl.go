@@ -0,0 +1,13 @@
+package model
+
+type ACLReport struct {
+	Path     string            `json:"path"`
+	Prefixes []*PrefixACLStats `json:"prefixes"`
+}
+
+// PrefixACLStats counts the objects under a prefix granting access through object ACLs
+type PrefixACLStats struct {
+	Prefix   string   `json:"prefix"`
+	Objects  int64    `json:"objects"`
+	Entities []string `json:"entities"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// AuditEntry records an admin mutation, who requested it and its outcome
+type AuditEntry struct {
+	ID       int64     `json:"id" db:"id"`
+	Time     time.Time `json:"time" db:"time"`
+	Identity string    `json:"identity" db:"identity"`
+	Method   string    `json:"method" db:"method"`
+	Path     string    `json:"path" db:"path"`
+	Params   string    `json:"params" db:"params"`
+	Status   int       `json:"status" db:"status"`
+	Error    string    `json:"error,omitempty" db:"error"`
+}
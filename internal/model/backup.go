@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// RowRange bounds the expected row count of a table, a Max of 0 leaving it unbounded
+type RowRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max,omitempty"`
+}
+
+// Contains reports whether count lies within the range
+func (r RowRange) Contains(count int64) bool {
+	return count >= r.Min && (r.Max == 0 || count <= r.Max)
+}
+
+// BackupCheck is the outcome of restoring a snapshot and checking the restored database
+type BackupCheck struct {
+	Snapshot string           `json:"snapshot"`
+	Created  time.Time        `json:"created"`
+	Checked  time.Time        `json:"checked"`
+	Duration time.Duration    `json:"duration"`
+	Rows     map[string]int64 `json:"rows"`
+	Problems []string         `json:"problems"`
+}
+
+// Valid reports whether the check found no problem
+func (c *BackupCheck) Valid() bool {
+	return len(c.Problems) == 0
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Bucket struct {
+	Name         string `json:"name" db:"name"`
+	Location     string `json:"location" db:"location"`
+	LocationType string `json:"location_type" db:"location_type"`
+}
+
+// BucketRegistration registers a bucket at runtime, its location being looked up in GCS
+type BucketRegistration struct {
+	Name string `json:"name"`
+	// Notify provisions the notification of the events of the bucket to the topic of the subscriber
+	Notify bool `json:"notify"`
+	// Backfill seeds the bucket in the background once registered
+	Backfill bool          `json:"backfill"`
+	Config   *BucketConfig `json:"config,omitempty"`
+}
+
+// BucketConfig overrides how a bucket is seeded and reconciled, zero values keeping the defaults
+type BucketConfig struct {
+	// Workers and MaxObjectsPerSecond override the seeding parallelism and rate limit
+	Workers             int     `json:"workers,omitempty"`
+	MaxObjectsPerSecond float64 `json:"max_objects_per_second,omitempty"`
+	// ExcludePrefixes are never indexed
+	ExcludePrefixes []string `json:"exclude_prefixes,omitempty"`
+	// AggregateOnlyPrefixes count towards the totals of their directories without indexing their objects
+	AggregateOnlyPrefixes []string `json:"aggregate_only_prefixes,omitempty"`
+	// ReconcileInterval is the time between catch-ups of a backfilled bucket, 0 to never catch up
+	ReconcileInterval Duration `json:"reconcile_interval,omitempty"`
+	// Delimiter splits the bucket into the prefixes seeded in parallel, "/" by default
+	Delimiter string `json:"delimiter,omitempty"`
+	// Budgets are the maximum billable bytes of prefixes, checked by reservations, "/" budgeting the whole bucket
+	Budgets map[string]int64 `json:"budgets,omitempty" display:"bytes"`
+}
+
+// PausedBucket is a bucket whose notifications are not applied until it is resumed, such as during maintenance
+type PausedBucket struct {
+	Bucket string    `json:"bucket"`
+	Since  time.Time `json:"since"`
+}
+
+// Duration is a time.Duration encoded in JSON as a string such as "1h30m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,38 @@
+package model
+
+// Statuses of the objects differing between two buckets
+const (
+	DifferenceOnlyInA   = "only_in_a"
+	DifferenceOnlyInB   = "only_in_b"
+	DifferenceSizeDiffs = "size_differs"
+)
+
+// ObjectDifference is an object missing from one side of a comparison, or differing in size
+type ObjectDifference struct {
+	// Name is relative to the prefix compared on each side
+	Name   string `json:"name" db:"name"`
+	Status string `json:"status"`
+	SizeA  *int64 `json:"size_a,omitempty" db:"size_a" display:"bytes"`
+	SizeB  *int64 `json:"size_b,omitempty" db:"size_b" display:"bytes"`
+}
+
+// BucketComparison compares the objects under a prefix of two buckets, such as the source and
+// destination of a replication or migration
+type BucketComparison struct {
+	BucketA string `json:"bucket_a"`
+	PrefixA string `json:"prefix_a"`
+	BucketB string `json:"bucket_b"`
+	PrefixB string `json:"prefix_b"`
+
+	CountA   int64 `json:"count_a"`
+	CountB   int64 `json:"count_b"`
+	Matching int64 `json:"matching"`
+	OnlyInA  int64 `json:"only_in_a"`
+	OnlyInB  int64 `json:"only_in_b"`
+	// SizeDiffers counts the objects on both sides whose size differs
+	SizeDiffers int64 `json:"size_differs"`
+
+	// Differences lists the first differing objects by name, Truncated being set if there are more
+	Differences []*ObjectDifference `json:"differences"`
+	Truncated   bool                `json:"truncated"`
+}
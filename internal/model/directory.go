@@ -3,6 +3,11 @@ package model
 type Directory struct {
 	Bucket string `json:"bucket" db:"bucket"`
 	Name   string `json:"name" db:"name"`
-	Size   int64  `json:"size" db:"size"`
+	Size   int64  `json:"size" db:"size" display:"bytes"`
 	Count  int64  `json:"count" db:"count"`
 }
+
+type LargestDirectories struct {
+	Bucket      string       `json:"bucket,omitempty"`
+	Directories []*Directory `json:"directories"`
+}
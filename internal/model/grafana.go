@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// Grafana JSON datasource wire types, field names follow the datasource contract
+
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+type GrafanaQueryRequest struct {
+	Range   GrafanaRange    `json:"range"`
+	Targets []GrafanaTarget `json:"targets"`
+}
+
+type GrafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+}
+
+// GrafanaTimeSeries holds datapoints as [value, unix milliseconds] pairs
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange           `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+type GrafanaAnnotationQuery struct {
+	Name   string `json:"name"`
+	Enable bool   `json:"enable"`
+	Query  string `json:"query"`
+}
+
+type GrafanaAnnotation struct {
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+	Time       int64                  `json:"time"` // unix milliseconds
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
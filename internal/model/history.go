@@ -0,0 +1,63 @@
+package model
+
+import "time"
+
+type DirectoryDiff struct {
+	Prefix      string            `json:"prefix"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Directories []*DirectoryDelta `json:"directories"`
+}
+
+// DirectoryDelta is the change of a directory's totals between two points in time
+type DirectoryDelta struct {
+	Name       string `json:"name" db:"name"`
+	SizeDelta  int64  `json:"size_delta" db:"size_delta" display:"bytes"`
+	CountDelta int64  `json:"count_delta" db:"count_delta"`
+}
+
+// StaleDirectory is a directory whose contents have not changed for a while
+type StaleDirectory struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size" display:"bytes"`
+	Count      int64     `json:"count"`
+	LastChange time.Time `json:"last_change"`
+}
+
+// DirectoryPoint holds the totals of a directory at the end of the history window starting at Time,
+// and their change over that window
+type DirectoryPoint struct {
+	Time       time.Time `json:"time"`
+	Size       int64     `json:"size" display:"bytes"`
+	Count      int64     `json:"count"`
+	SizeDelta  int64     `json:"size_delta" display:"bytes"`
+	CountDelta int64     `json:"count_delta"`
+}
+
+// DirectoryTotals are the size and object count of a directory at some point
+type DirectoryTotals struct {
+	Size  int64 `json:"size" display:"bytes"`
+	Count int64 `json:"count"`
+}
+
+// TreeDiff is the difference between the directory trees under Prefix of two snapshots,
+// Added and Removed holding the topmost directories only, their subdirectories being implied
+type TreeDiff struct {
+	Prefix     string           `json:"prefix"`
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	SizeDelta  int64            `json:"size_delta" display:"bytes"`
+	CountDelta int64            `json:"count_delta"`
+	Added      []*SubtreeChange `json:"added"`
+	Removed    []*SubtreeChange `json:"removed"`
+	Changed    []*SubtreeChange `json:"changed"`
+}
+
+// SubtreeChange is the change of the totals of a directory, and of everything under it, between two snapshots
+type SubtreeChange struct {
+	Name       string          `json:"name"`
+	From       DirectoryTotals `json:"from"`
+	To         DirectoryTotals `json:"to"`
+	SizeDelta  int64           `json:"size_delta" display:"bytes"`
+	CountDelta int64           `json:"count_delta"`
+}
@@ -0,0 +1,29 @@
+package model
+
+// IndexReport lists the indexes of the database and the ones the observed queries would benefit from
+type IndexReport struct {
+	AutoCreate      bool                   `json:"auto_create"`
+	Indexes         []*Index               `json:"indexes"`
+	Recommendations []*IndexRecommendation `json:"recommendations"`
+}
+
+type Index struct {
+	Name       string `json:"name" db:"name"`
+	Table      string `json:"table" db:"tbl_name"`
+	Definition string `json:"definition" db:"sql"`
+	// Reason is why the index exists, empty if unknown
+	Reason string `json:"reason"`
+}
+
+// IndexRecommendation is a missing index whose columns were filtered or sorted on by scanning statements
+type IndexRecommendation struct {
+	Name       string   `json:"name"`
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns"`
+	Reason     string   `json:"reason"`
+	Definition string   `json:"definition"`
+	// Hits is the number of executed statements scanning the table that the index would support
+	Hits int `json:"hits"`
+	// Statements are examples of those statements
+	Statements []string `json:"statements"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// JobStatus is the schedule of a background job and the outcome of its last run
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+	Runs     int64     `json:"runs"`
+	Failures int64     `json:"failures"`
+	// Skipped counts the runs skipped because the previous run was still in progress
+	Skipped int64 `json:"skipped"`
+	// LastRun, LastDuration and LastError describe the last run which finished, unset until one did
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration Duration   `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
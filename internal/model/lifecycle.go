@@ -0,0 +1,85 @@
+package model
+
+// LifecyclePolicy mirrors the GCS bucket lifecycle configuration
+// See https://cloud.google.com/storage/docs/lifecycle-configurations
+type LifecyclePolicy struct {
+	Rules []LifecycleRule `json:"rule"`
+}
+
+type LifecycleRule struct {
+	Action    LifecycleAction    `json:"action"`
+	Condition LifecycleCondition `json:"condition"`
+}
+
+type LifecycleAction struct {
+	Type         string `json:"type"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+type LifecycleCondition struct {
+	Age                 *int64   `json:"age,omitempty"`
+	CreatedBefore       string   `json:"createdBefore,omitempty"`
+	DaysSinceCustomTime *int64   `json:"daysSinceCustomTime,omitempty"`
+	CustomTimeBefore    string   `json:"customTimeBefore,omitempty"`
+	MatchesStorageClass []string `json:"matchesStorageClass,omitempty"`
+	MatchesPrefix       []string `json:"matchesPrefix,omitempty"`
+	MatchesSuffix       []string `json:"matchesSuffix,omitempty"`
+}
+
+// LifecycleSimulation holds the outcome of a lifecycle policy for a single prefix
+type LifecycleSimulation struct {
+	Prefix      string                     `json:"prefix"`
+	Delete      LifecycleImpact            `json:"delete"`
+	Transitions map[string]LifecycleImpact `json:"transitions"`
+}
+
+type LifecycleImpact struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size" display:"bytes"`
+}
+
+type LifecycleSimulationResult struct {
+	Bucket   string                 `json:"bucket"`
+	Prefix   string                 `json:"prefix"`
+	Prefixes []*LifecycleSimulation `json:"prefixes"`
+}
+
+// AgeBucket counts the objects aged between MinDays included and MaxDays excluded
+// MaxDays is unset for the oldest bucket, and both are for objects without the timestamp
+type AgeBucket struct {
+	Label   string `json:"label"`
+	MinDays int64  `json:"min_days"`
+	MaxDays *int64 `json:"max_days,omitempty"`
+	Count   int64  `json:"count"`
+	Size    int64  `json:"size" display:"bytes"`
+}
+
+type AgeHistogram struct {
+	Bucket  string       `json:"bucket"`
+	Prefix  string       `json:"prefix"`
+	By      string       `json:"by"`
+	Buckets []*AgeBucket `json:"buckets"`
+}
+
+// LifecycleRecommendation is a rule transitioning the objects of a prefix to a colder storage class,
+// and the monthly storage cost it would save
+type LifecycleRecommendation struct {
+	Prefix      string        `json:"prefix"`
+	Rule        LifecycleRule `json:"rule"`
+	Description string        `json:"description"`
+	// Count and Size are of the objects the rule would transition now
+	Count int64 `json:"count"`
+	Size  int64 `json:"size" display:"bytes"`
+	// RecentlyRead counts the objects old enough left out because access logs show them read within the age
+	RecentlyRead    int64   `json:"recently_read"`
+	CurrentCost     float64 `json:"current_cost"`
+	RecommendedCost float64 `json:"recommended_cost"`
+	MonthlySavings  float64 `json:"monthly_savings"`
+}
+
+type LifecycleRecommendations struct {
+	Prefix string `json:"prefix"`
+	// AccessData is set once access logs were ingested, recommendations otherwise relying on age alone
+	AccessData      bool                       `json:"access_data"`
+	Recommendations []*LifecycleRecommendation `json:"recommendations"`
+}
@@ -7,9 +7,47 @@ type Metadata struct {
 	Name         string    `json:"name" db:"name"`
 	Parent       string    `json:"parent" db:"parent"`
 	StorageClass string    `json:"storage_class" db:"storage_class"`
-	Size         int64     `json:"size" db:"size"`
+	Size         int64     `json:"size" db:"size" display:"bytes"`
 	Count        int64     `json:"count" db:"count"`
 	Cost         float64   `json:"cost" db:"cost"`
 	Created      time.Time `json:"created" db:"created"`
 	Updated      time.Time `json:"updated" db:"updated"`
+	// CustomTime is the custom time set on the object, if any
+	CustomTime *time.Time `json:"custom_time,omitempty" db:"custom_time"`
+	// DetectedType is the content type sniffed from the first bytes of the object, if it was sampled
+	DetectedType string `json:"detected_type,omitempty" db:"detected_type"`
+	// Marker is set on the placeholder objects of directories, listed only when requested
+	Marker bool `json:"marker,omitempty" db:"marker"`
+	// NoncurrentSize is the size of the noncurrent generations under a directory, billed on top of its Size
+	NoncurrentSize int64 `json:"noncurrent_size,omitempty" db:"noncurrent_size" display:"bytes"`
+	// Generation and Metageneration are the versions of the object and of its metadata, 0 if unknown, and
+	// EventTime is when the notification last indexing it was sent, nil unless indexed from one
+	// They resolve conflicts between notifications, and are left out of responses
+	Generation     int64      `json:"-" db:"generation"`
+	Metageneration int64      `json:"-" db:"metageneration"`
+	EventTime      *time.Time `json:"-" db:"event_time"`
+}
+
+// NoncurrentObject is a generation of an object of a versioned bucket which was overwritten or deleted,
+// and is billed until it expires
+type NoncurrentObject struct {
+	Bucket       string    `json:"bucket" db:"bucket"`
+	Name         string    `json:"name" db:"name"`
+	Generation   int64     `json:"generation" db:"generation" display:"id"`
+	Size         int64     `json:"size" db:"size" display:"bytes"`
+	StorageClass string    `json:"storage_class" db:"storage_class"`
+	Deleted      time.Time `json:"deleted" db:"deleted"`
+}
+
+type PathContents struct {
+	Path          string      `json:"path"`
+	Contents      []*Metadata `json:"contents"`
+	NextPageToken string      `json:"next_page_token,omitempty"`
+}
+
+type SearchResults struct {
+	Path      string      `json:"path"`
+	Query     string      `json:"query"`
+	Collation string      `json:"collation"`
+	Results   []*Metadata `json:"results"`
 }
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// ObjectRead is a read of an object found in an access log
+type ObjectRead struct {
+	Bucket string
+	Name   string
+	Time   time.Time
+}
+
+// ColdEntry is an object or directory directly under a path, and how often its data was read
+type ColdEntry struct {
+	Bucket    string `json:"bucket"`
+	Name      string `json:"name"`
+	Directory bool   `json:"directory"`
+	Size      int64  `json:"size" display:"bytes"`
+	Count     int64  `json:"count"`
+	Reads     int64  `json:"reads"`
+	// LastRead is unset if no read was ingested
+	LastRead *time.Time `json:"last_read,omitempty"`
+}
+
+// ColdReport lists the entries under a path not read since a time, or never read if unset
+type ColdReport struct {
+	Path    string       `json:"path"`
+	Since   *time.Time   `json:"since,omitempty"`
+	Entries []*ColdEntry `json:"entries"`
+}
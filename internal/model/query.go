@@ -0,0 +1,11 @@
+package model
+
+type QueryRequest struct {
+	Query string `json:"query"`
+}
+
+// QueryResult holds rows keyed by column name, Columns giving their order
+type QueryResult struct {
+	Columns []string         `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+}
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// ReplayRequest seeks the subscription back to reprocess its notifications, from a time or a Pub/Sub snapshot
+type ReplayRequest struct {
+	Since *time.Time `json:"since,omitempty"`
+	// Snapshot is the full name of a snapshot of the subscription, as projects/PROJECT/snapshots/SNAPSHOT
+	Snapshot string `json:"snapshot,omitempty"`
+}
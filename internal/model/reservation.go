@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// ReservationRequest declares the bytes an uploader intends to write under a prefix
+type ReservationRequest struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	Bytes  int64  `json:"bytes" display:"bytes"`
+	// TTL is how long a granted reservation counts against budgets, unless released earlier
+	TTL Duration `json:"ttl,omitempty"`
+}
+
+// Reservation is the outcome of a reservation request, with the budgets it was checked against
+// Denied reservations have no ID and are not recorded
+type Reservation struct {
+	ID        int64          `json:"id,omitempty"`
+	Bucket    string         `json:"bucket"`
+	Prefix    string         `json:"prefix"`
+	Bytes     int64          `json:"bytes" display:"bytes"`
+	Granted   bool           `json:"granted"`
+	ExpiresAt *time.Time     `json:"expires_at,omitempty"`
+	Budgets   []*BudgetUsage `json:"budgets"`
+}
+
+// BudgetUsage is the billable size and outstanding reservations of a budgeted prefix
+// Remaining excludes the bytes of the reservation checked against it
+type BudgetUsage struct {
+	Prefix    string `json:"prefix"`
+	Budget    int64  `json:"budget"`
+	Used      int64  `json:"used"`
+	Reserved  int64  `json:"reserved"`
+	Remaining int64  `json:"remaining"`
+}
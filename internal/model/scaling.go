@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// WriteLoad is the write pressure on the database, whose single writer bounds the rate of writes
+type WriteLoad struct {
+	// Pending is the number of writes submitted and not committed yet
+	Pending int `json:"pending"`
+	// OldestPending is the time the oldest pending write has been waiting
+	OldestPending Duration `json:"oldest_pending"`
+	// Busy is the total time writes were in progress
+	Busy Duration `json:"busy"`
+}
+
+// ScalingSignal normalizes the load of a server for autoscalers, a value of 1 meaning at capacity
+// The value is the largest of its components, each the ratio of a measure to its target
+type ScalingSignal struct {
+	Value float64 `json:"value"`
+	// Limiting names the component the value comes from
+	Limiting      string   `json:"limiting"`
+	Pending       int      `json:"pending"`
+	OldestPending Duration `json:"oldest_pending"`
+	// Utilization is the fraction of the last interval writes were in progress, its complement the write headroom
+	Utilization float64   `json:"utilization"`
+	Headroom    float64   `json:"headroom"`
+	Sampled     time.Time `json:"sampled"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// SeedCheckpoint is how far the seeding of a bucket went, to resume it after an interruption
+// Checkpoints of partitions listed in parallel are kept under their prefix, the one of the whole bucket under ""
+type SeedCheckpoint struct {
+	Bucket string `json:"bucket" db:"bucket"`
+	Prefix string `json:"prefix" db:"prefix"`
+	// LastObject is the name of the last object listed, every object sorting before it has been indexed
+	LastObject string `json:"last_object" db:"last_object"`
+	// LastGeneration is the generation of the last object listed, generations of a name being listed in order
+	LastGeneration int64     `json:"last_generation" db:"last_generation"`
+	Objects        int64     `json:"objects" db:"objects"`
+	Completed      bool      `json:"completed" db:"completed"`
+	Updated        time.Time `json:"updated" db:"updated"`
+}
+
+// SeedProgress reports a running seeding
+type SeedProgress struct {
+	Bucket      string    `json:"bucket"`
+	Started     time.Time `json:"started"`
+	ResumedFrom string    `json:"resumed_from,omitempty"`
+	// Objects counts the objects indexed, including those indexed before resuming
+	Objects          int64   `json:"objects"`
+	ObjectsPerSecond float64 `json:"objects_per_second"`
+	LastObject       string  `json:"last_object"`
+	Completed        bool    `json:"completed"`
+	// CatchUpSince is the last update indexed before catching up, Inserted, Updated and Deleted
+	// count the changes catching up found
+	CatchUpSince *time.Time `json:"catch_up_since,omitempty"`
+	Inserted     int64      `json:"inserted,omitempty"`
+	Updated      int64      `json:"updated,omitempty"`
+	Deleted      int64      `json:"deleted,omitempty"`
+	// Partitions are the prefixes listed in parallel, if any
+	Partitions          int `json:"partitions,omitempty"`
+	PartitionsCompleted int `json:"partitions_completed,omitempty"`
+	// EstimatedObjects and ETA are only known if the bucket's object count was estimated
+	EstimatedObjects int64      `json:"estimated_objects,omitempty"`
+	ETA              *time.Time `json:"eta,omitempty"`
+}
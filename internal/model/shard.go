@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// BucketShard is the instance owning a bucket in a sharded fleet, which queries of the bucket are routed to
+type BucketShard struct {
+	Bucket string `json:"bucket"`
+	// Address is the address the owner advertises, empty while no instance owns the bucket
+	Address string     `json:"address,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
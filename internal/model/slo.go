@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// SLO is a latency objective of an API endpoint, requests answered in time without a server error being good
+type SLO struct {
+	Endpoint string   `json:"endpoint"`
+	Latency  Duration `json:"latency"`
+	// Target is the fraction of requests that must be good, such as 0.999
+	Target float64 `json:"target"`
+}
+
+// BurnRate is how fast an endpoint consumed its error budget over a window, 1 using it up exactly by the end
+// of the SLO period
+type BurnRate struct {
+	Window   string  `json:"window"`
+	Requests int64   `json:"requests"`
+	Bad      int64   `json:"bad"`
+	Rate     float64 `json:"rate"`
+}
+
+// SLOStatus is the budget consumption of an endpoint, alerting at Severity while it burns too fast
+type SLOStatus struct {
+	SLO       SLO        `json:"slo"`
+	BurnRates []BurnRate `json:"burn_rates"`
+	Severity  string     `json:"severity,omitempty"`
+}
+
+// SLOAlert reports an endpoint starting or ceasing to burn its error budget too fast
+type SLOAlert struct {
+	Status   SLOStatus `json:"status"`
+	Firing   bool      `json:"firing"`
+	Observed time.Time `json:"observed"`
+}
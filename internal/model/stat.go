@@ -0,0 +1,18 @@
+package model
+
+// StatRequest names objects as bucket/name paths
+type StatRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// StatResult is the metadata of a requested path, Found being false if no such object exists
+type StatResult struct {
+	Path   string    `json:"path"`
+	Found  bool      `json:"found"`
+	Object *Metadata `json:"object,omitempty"`
+}
+
+// StatResults holds a result per requested path, in the order they were requested
+type StatResults struct {
+	Results []StatResult `json:"results"`
+}
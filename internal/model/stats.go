@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+type WriteStats struct {
+	Window   string             `json:"window"`
+	Since    time.Time          `json:"since"`
+	Prefixes []*PrefixWriteStat `json:"prefixes"`
+}
+
+type PrefixWriteStat struct {
+	Prefix string `json:"prefix" db:"prefix"`
+	Events int64  `json:"events" db:"events"`
+}
+
+// LagStats measures how late notifications arrived after the writes they announce
+type LagStats struct {
+	Events  int64    `json:"events"`
+	MeanLag Duration `json:"mean_lag"`
+	MaxLag  Duration `json:"max_lag"`
+}
+
+// PrefixLag is the notification lag of the objects of a bucket under a top level prefix
+// Writes to dual-region buckets are only visible once notified, the lag bounding their replication
+type PrefixLag struct {
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix"`
+	DualRegion bool   `json:"dual_region"`
+	LagStats
+}
+
+type NotificationLag struct {
+	Window   string       `json:"window"`
+	Since    time.Time    `json:"since"`
+	Prefixes []*PrefixLag `json:"prefixes"`
+}
+
+// EventStat counts the notifications of every type applied to a bucket in an hour
+type EventStat struct {
+	Bucket         string    `json:"bucket"`
+	Hour           time.Time `json:"hour"`
+	Finalize       int64     `json:"finalize"`
+	MetadataUpdate int64     `json:"metadata_update"`
+	Archive        int64     `json:"archive"`
+	Delete         int64     `json:"delete"`
+}
+
+type EventStats struct {
+	Window string       `json:"window"`
+	Since  time.Time    `json:"since"`
+	Bucket string       `json:"bucket,omitempty"`
+	Hours  []*EventStat `json:"hours"`
+}
@@ -1,16 +1,26 @@
 package model
 
 type Summary struct {
-	Path string `json:"path" db:"name"`
-	Cost `json:"cost"`
-	Size `json:"size"`
+	Path     string `json:"path" db:"name"`
+	Location string `json:"location,omitempty" db:"location"`
+	Cost     `json:"cost"`
+	Size     `json:"size"`
+	// LiveSize totals the sizes of the live objects of every storage class
+	LiveSize int64 `json:"live_size" display:"bytes"`
+	// NoncurrentSize totals the sizes of the noncurrent generations of versioned buckets
+	NoncurrentSize int64 `json:"noncurrent_size" db:"noncurrent_size" display:"bytes"`
+	// BillableSize is the storage billed, live objects and noncurrent generations alike
+	BillableSize int64 `json:"billable_size" display:"bytes"`
+	// NotificationLag measures the notifications of the top level prefix of the path over the last hour,
+	// unset if none carried their arrival time
+	NotificationLag *LagStats `json:"notification_lag,omitempty"`
 }
 
 type Size struct {
-	Standard int64 `json:"standard" db:"size_standard"`
-	Nearline int64 `json:"nearline" db:"size_nearline"`
-	Coldline int64 `json:"coldline" db:"size_coldline"`
-	Archive  int64 `json:"archive" db:"size_archive"`
+	Standard int64 `json:"standard" db:"size_standard" display:"bytes"`
+	Nearline int64 `json:"nearline" db:"size_nearline" display:"bytes"`
+	Coldline int64 `json:"coldline" db:"size_coldline" display:"bytes"`
+	Archive  int64 `json:"archive" db:"size_archive" display:"bytes"`
 }
 
 type Cost struct {
@@ -19,3 +29,17 @@ type Cost struct {
 	Coldline float64 `json:"coldline"`
 	Archive  float64 `json:"archive"`
 }
+
+// ObjectCount is the number and size of the objects under a path, exact or extrapolated from a sample of objects
+type ObjectCount struct {
+	Path        string `json:"path"`
+	Count       int64  `json:"count"`
+	Size        int64  `json:"size" display:"bytes"`
+	Approximate bool   `json:"approximate"`
+	// CountMargin and SizeMargin bound the error of approximate counts and sizes with 95% confidence
+	CountMargin int64 `json:"count_margin,omitempty"`
+	SizeMargin  int64 `json:"size_margin,omitempty" display:"bytes"`
+	// Sampled is the number of sampled objects approximate counts were extrapolated from, one in SampleRate objects
+	Sampled    int64 `json:"sampled,omitempty"`
+	SampleRate int64 `json:"sample_rate,omitempty"`
+}
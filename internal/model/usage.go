@@ -0,0 +1,15 @@
+package model
+
+// ConsumerUsage aggregates the requests of an API consumer over a UTC day
+type ConsumerUsage struct {
+	Day          string  `json:"day" db:"day"`
+	Consumer     string  `json:"consumer" db:"consumer"`
+	Requests     int64   `json:"requests" db:"requests"`
+	Bytes        int64   `json:"bytes" db:"bytes" display:"bytes"`
+	AvgLatencyMs float64 `json:"avg_latency_ms" db:"avg_latency_ms"`
+}
+
+type UsageReport struct {
+	Since     string           `json:"since"`
+	Consumers []*ConsumerUsage `json:"consumers"`
+}
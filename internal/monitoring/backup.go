@@ -0,0 +1,47 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	// MetricBackupValid is 1 if the last verified snapshot restored without problems, 0 otherwise
+	MetricBackupValid = "custom.googleapis.com/gcs_metadata/backup/valid"
+	// MetricBackupAge is the age in seconds of the last verified snapshot when it was checked
+	MetricBackupAge = "custom.googleapis.com/gcs_metadata/backup/age"
+	// MetricBackupRows is the row count of a table of the last verified snapshot, labelled with the table
+	MetricBackupRows = "custom.googleapis.com/gcs_metadata/backup/rows"
+)
+
+// ExportBackupCheck writes the outcome of a backup verification as custom metrics of the project
+func ExportBackupCheck(ctx context.Context, client MetricWriter, projectId string, check *model.BackupCheck) error {
+	var valid int64
+	if check.Valid() {
+		valid = 1
+	}
+
+	series := []*monitoringpb.TimeSeries{
+		gauge(projectId, MetricBackupValid, nil, valid, check.Checked),
+	}
+	if !check.Created.IsZero() {
+		age := int64(check.Checked.Sub(check.Created) / time.Second)
+		series = append(series, gauge(projectId, MetricBackupAge, nil, age, check.Checked))
+	}
+	for table, count := range check.Rows {
+		series = append(series, gauge(projectId, MetricBackupRows, map[string]string{"table": table}, count, check.Checked))
+	}
+
+	// A handful of tables are checked, well below the limit of a single request
+	if err := client.CreateTimeSeries(ctx, &monitoringpb.CreateTimeSeriesRequest{
+		Name:       "projects/" + projectId,
+		TimeSeries: series,
+	}); err != nil {
+		return fmt.Errorf("error writing time series: %w", err)
+	}
+	return nil
+}
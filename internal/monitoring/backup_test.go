@@ -0,0 +1,63 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestExportBackupCheck(t *testing.T) {
+	checked := time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		check      *model.BackupCheck
+		wantValid  int64
+		wantSeries int
+	}{
+		{
+			"Valid snapshot",
+			&model.BackupCheck{Created: checked.Add(-time.Hour), Checked: checked, Rows: map[string]int64{"metadata": 10, "directory": 3}},
+			1, 4,
+		},
+		{
+			"Corrupt snapshot of unknown age",
+			&model.BackupCheck{Checked: checked, Problems: []string{"table bucket is missing"}},
+			0, 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &mockMetricWriter{}
+			if err := ExportBackupCheck(context.Background(), client, "mock-project", tc.check); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(client.requests) != 1 {
+				t.Fatalf("Request count mismatch: got %d, want 1", len(client.requests))
+			}
+			series := client.requests[0].TimeSeries
+			if len(series) != tc.wantSeries {
+				t.Fatalf("Time series count mismatch: got %d, want %d", len(series), tc.wantSeries)
+			}
+
+			valid := series[0]
+			if valid.Metric.Type != MetricBackupValid || valid.Points[0].Value.GetInt64Value() != tc.wantValid {
+				t.Errorf("Valid time series mismatch: got %v", valid)
+			}
+			if tc.wantSeries > 1 {
+				if age := series[1]; age.Metric.Type != MetricBackupAge || age.Points[0].Value.GetInt64Value() != 3600 {
+					t.Errorf("Age time series mismatch: got %v", age)
+				}
+			}
+			for _, s := range series[min(2, len(series)):] {
+				if s.Metric.Type != MetricBackupRows || s.Points[0].Value.GetInt64Value() != tc.check.Rows[s.Metric.Labels["table"]] {
+					t.Errorf("Rows time series mismatch: got %v", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/googleapis/gax-go/v2"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// MetricSize and MetricCount are the custom metrics exported per directory,
+	// labelled with its bucket and prefix, / for the whole bucket
+	MetricSize  = "custom.googleapis.com/gcs_metadata/directory/size"
+	MetricCount = "custom.googleapis.com/gcs_metadata/directory/count"
+
+	// maxTimeSeriesPerRequest is the Cloud Monitoring limit of time series written per request
+	maxTimeSeriesPerRequest = 200
+
+	// MinInterval is the shortest interval at which Cloud Monitoring accepts points of a time series
+	MinInterval = 5 * time.Second
+)
+
+// MetricWriter writes time series, implemented by the Cloud Monitoring metric client
+type MetricWriter interface {
+	CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest, opts ...gax.CallOption) error
+}
+
+// Exporter writes the size and object count of every bucket and top level prefix as custom metrics
+type Exporter struct {
+	client      MetricWriter
+	projectId   string
+	exploreRepo repo.ExploreRepository
+}
+
+func NewExporter(client MetricWriter, projectId string, exploreRepo repo.ExploreRepository) *Exporter {
+	return &Exporter{client, projectId, exploreRepo}
+}
+
+// Export writes the current totals as points at now
+func (e *Exporter) Export(ctx context.Context, now time.Time) error {
+	dirs, err := e.exploreRepo.GetTopLevelDirectories(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving directories: %w", err)
+	}
+
+	var series []*monitoringpb.TimeSeries
+	for _, dir := range dirs {
+		series = append(series,
+			e.timeSeries(MetricSize, dir, dir.Size, now),
+			e.timeSeries(MetricCount, dir, dir.Count, now),
+		)
+	}
+
+	for start := 0; start < len(series); start += maxTimeSeriesPerRequest {
+		end := min(start+maxTimeSeriesPerRequest, len(series))
+
+		if err := e.client.CreateTimeSeries(ctx, &monitoringpb.CreateTimeSeriesRequest{
+			Name:       "projects/" + e.projectId,
+			TimeSeries: series[start:end],
+		}); err != nil {
+			return fmt.Errorf("error writing time series: %w", err)
+		}
+	}
+	return nil
+}
+
+// timeSeries returns a gauge point of a directory, on the global resource of the project
+func (e *Exporter) timeSeries(metricType string, dir *model.Directory, value int64, now time.Time) *monitoringpb.TimeSeries {
+	return gauge(e.projectId, metricType, map[string]string{
+		"bucket": dir.Bucket,
+		"prefix": dir.Name,
+	}, value, now)
+}
+
+// gauge returns a point of an integer gauge with labels, on the global resource of the project
+func gauge(projectId string, metricType string, labels map[string]string, value int64, now time.Time) *monitoringpb.TimeSeries {
+	return &monitoringpb.TimeSeries{
+		Metric: &metricpb.Metric{
+			Type:   metricType,
+			Labels: labels,
+		},
+		Resource: &monitoredrespb.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": projectId},
+		},
+		MetricKind: metricpb.MetricDescriptor_GAUGE,
+		ValueType:  metricpb.MetricDescriptor_INT64,
+		Points: []*monitoringpb.Point{{
+			Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.New(now)},
+			Value:    &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: value}},
+		}},
+	}
+}
@@ -0,0 +1,80 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+	"github.com/googleapis/gax-go/v2"
+)
+
+type mockMetricWriter struct {
+	requests []*monitoringpb.CreateTimeSeriesRequest
+}
+
+func (m *mockMetricWriter) CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest, opts ...gax.CallOption) error {
+	m.requests = append(m.requests, req)
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	testCases := []struct {
+		name         string
+		prefixes     int
+		wantRequests int
+	}{
+		{"Empty database", 0, 0},
+		{"Single request", 10, 1},
+		{"Batches time series", 150, 2}, // 151 directories with 2 metrics each
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := repotest.NewDatabase(t)
+
+			ctx := context.Background()
+			dirRepo := repo.NewDirectoryRepository(db)
+			for i := range tc.prefixes {
+				if err := dirRepo.UpsertParentDirs(ctx, repo.StorageStandard, "mock", fmt.Sprintf("dir-%d/object", i), 10, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &mockMetricWriter{}
+			exporter := NewExporter(client, "mock-project", repo.NewExploreRepository(db))
+
+			now := time.Now()
+			if err := exporter.Export(ctx, now); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(client.requests) != tc.wantRequests {
+				t.Fatalf("Request count mismatch: got %d, want %d", len(client.requests), tc.wantRequests)
+			}
+			if tc.wantRequests == 0 {
+				return
+			}
+
+			req := client.requests[0]
+			if req.Name != "projects/mock-project" {
+				t.Errorf("Project mismatch: got %s", req.Name)
+			}
+
+			// The root directory is exported first with its totals
+			size, count := req.TimeSeries[0], req.TimeSeries[1]
+			if size.Metric.Type != MetricSize || size.Metric.Labels["prefix"] != "/" || size.Points[0].Value.GetInt64Value() != int64(10*tc.prefixes) {
+				t.Errorf("Size time series mismatch: got %v", size)
+			}
+			if count.Metric.Type != MetricCount || count.Points[0].Value.GetInt64Value() != int64(tc.prefixes) {
+				t.Errorf("Count time series mismatch: got %v", count)
+			}
+			if !size.Points[0].Interval.EndTime.AsTime().Equal(now) {
+				t.Errorf("Point time mismatch: got %v, want %v", size.Points[0].Interval.EndTime.AsTime(), now)
+			}
+		})
+	}
+}
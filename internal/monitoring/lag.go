@@ -0,0 +1,55 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// MetricNotificationLag is the longest delay in milliseconds between writes and their notifications over the
+// last interval, labelled with the bucket, its top level prefix and whether the bucket is dual-region
+const MetricNotificationLag = "custom.googleapis.com/gcs_metadata/notification/max_lag"
+
+// ExportNotificationLag writes the maximum lag of every prefix as custom metrics of the project
+func ExportNotificationLag(ctx context.Context, client MetricWriter, projectId string, lags []*model.PrefixLag, now time.Time) error {
+	var series []*monitoringpb.TimeSeries
+	for _, lag := range lags {
+		series = append(series, gauge(projectId, MetricNotificationLag, map[string]string{
+			"bucket":      lag.Bucket,
+			"prefix":      lag.Prefix,
+			"dual_region": strconv.FormatBool(lag.DualRegion),
+		}, time.Duration(lag.MaxLag).Milliseconds(), now))
+	}
+
+	for start := 0; start < len(series); start += maxTimeSeriesPerRequest {
+		end := min(start+maxTimeSeriesPerRequest, len(series))
+
+		if err := client.CreateTimeSeries(ctx, &monitoringpb.CreateTimeSeriesRequest{
+			Name:       "projects/" + projectId,
+			TimeSeries: series[start:end],
+		}); err != nil {
+			return fmt.Errorf("error writing time series: %w", err)
+		}
+	}
+	return nil
+}
+
+// NotificationLagJob returns a job exporting the lag measured since its previous run, for a scheduler which
+// never overlaps its runs
+func NotificationLagJob(client MetricWriter, projectId string, statsRepo repo.StatsRepository) func(ctx context.Context) error {
+	since := time.Now()
+	return func(ctx context.Context) error {
+		now := time.Now()
+		lags, err := statsRepo.GetNotificationLag(ctx, since)
+		if err != nil {
+			return err
+		}
+		since = now
+		return ExportNotificationLag(ctx, client, projectId, lags, now)
+	}
+}
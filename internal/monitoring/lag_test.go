@@ -0,0 +1,29 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestExportNotificationLag(t *testing.T) {
+	lags := []*model.PrefixLag{
+		{Bucket: "mock", Prefix: "logs/", DualRegion: true, LagStats: model.LagStats{Events: 2, MaxLag: model.Duration(1500 * time.Millisecond)}},
+		{Bucket: "mock", Prefix: "/", LagStats: model.LagStats{Events: 1, MaxLag: model.Duration(time.Second)}},
+	}
+
+	client := &mockMetricWriter{}
+	if err := ExportNotificationLag(context.Background(), client, "mock-project", lags, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.requests) != 1 || len(client.requests[0].TimeSeries) != 2 {
+		t.Fatalf("Expected 2 time series in a request, got %v", client.requests)
+	}
+	series := client.requests[0].TimeSeries[0]
+	if series.Metric.Type != MetricNotificationLag || series.Metric.Labels["dual_region"] != "true" || series.Points[0].Value.GetInt64Value() != 1500 {
+		t.Errorf("Time series mismatch: got %v", series)
+	}
+}
@@ -0,0 +1,28 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// MetricScalingSignal is the scaling signal of a server in percent, 100 meaning at capacity,
+// for autoscalers reading custom metrics
+const MetricScalingSignal = "custom.googleapis.com/gcs_metadata/scaling/signal"
+
+// ExportScalingSignal writes the scaling signal as a custom metric of the project
+func ExportScalingSignal(ctx context.Context, client MetricWriter, projectId string, signal model.ScalingSignal) error {
+	value := int64(math.Round(signal.Value * 100))
+	if err := client.CreateTimeSeries(ctx, &monitoringpb.CreateTimeSeriesRequest{
+		Name: "projects/" + projectId,
+		TimeSeries: []*monitoringpb.TimeSeries{
+			gauge(projectId, MetricScalingSignal, map[string]string{"limiting": signal.Limiting}, value, signal.Sampled),
+		},
+	}); err != nil {
+		return fmt.Errorf("error writing time series: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestExportScalingSignal(t *testing.T) {
+	signal := model.ScalingSignal{Value: 1.234, Limiting: "pending", Sampled: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	client := &mockMetricWriter{}
+	if err := ExportScalingSignal(context.Background(), client, "mock-project", signal); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.requests) != 1 || len(client.requests[0].TimeSeries) != 1 {
+		t.Fatalf("Expected a single time series, got %v", client.requests)
+	}
+	series := client.requests[0].TimeSeries[0]
+	if series.Metric.Type != MetricScalingSignal || series.Metric.Labels["limiting"] != "pending" || series.Points[0].Value.GetInt64Value() != 123 {
+		t.Errorf("Time series mismatch: got %v", series)
+	}
+}
@@ -0,0 +1,502 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenKeyword
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string // keywords are upper cased, strings unquoted
+	pos  int
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true,
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "IN": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+}
+
+// symbols are the operators and punctuation of statements, longest first
+var symbols = []string{"!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", ",", "*"}
+
+var comparisons = map[string]bool{"=": true, "!=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// lex splits src into tokens, rejecting any character statements have no use for
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			// Quotes are escaped by doubling them
+			var text strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i == len(src) {
+					return nil, &Error{Pos: start, Msg: "unterminated string"}
+				}
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						text.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteByte(src[i])
+			}
+			tokens = append(tokens, token{tokenString, text.String(), start})
+		case isDigit(c) || (c == '-' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i++; i < len(src) && isDigit(src[i]); i++ {
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case isIdentStart(c):
+			start := i
+			for i++; i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])); i++ {
+			}
+			word := src[start:i]
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, token{tokenKeyword, upper, start})
+			} else {
+				tokens = append(tokens, token{tokenIdent, strings.ToLower(word), start})
+			}
+		default:
+			var matched bool
+			for _, s := range symbols {
+				if strings.HasPrefix(src[i:], s) {
+					tokens = append(tokens, token{tokenSymbol, s, i})
+					i += len(s)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src)}), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+type parser struct {
+	tokens     []token
+	pos        int
+	depth      int
+	predicates int
+}
+
+// operand is a compiled expression, literal holding the text of string literals
+// so they can still be read as timestamps when compared with timestamp columns
+type operand struct {
+	sql      string
+	args     []any
+	typ      valueType
+	column   *column
+	literal  *string
+	position int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword or symbol text
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenKeyword || t.kind == tokenSymbol) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	msg := fmt.Sprintf(format, args...)
+	if t.kind == tokenEOF {
+		msg += ", got end of statement"
+	} else {
+		msg += fmt.Sprintf(", got %q", t.text)
+	}
+	return &Error{Pos: t.pos, Msg: msg}
+}
+
+func (p *parser) columnRef() (column, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return column{}, p.errorf("expected column")
+	}
+	c, ok := lookupColumn(t.text)
+	if !ok {
+		return column{}, &Error{Pos: t.pos, Msg: fmt.Sprintf("unknown column %q, please use one of %s", t.text, strings.Join(Columns(), ", "))}
+	}
+	p.pos++
+	return c, nil
+}
+
+func (p *parser) statement() (*Statement, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+
+	var selected []column
+	if p.accept("*") {
+		selected = columns
+	} else {
+		for {
+			c, err := p.columnRef()
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, c)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.accept("FROM") {
+		if t := p.next(); t.kind != tokenIdent || t.text != "metadata" {
+			return nil, &Error{Pos: t.pos, Msg: "only the metadata table can be queried"}
+		}
+	}
+
+	var where *operand
+	if p.accept("WHERE") {
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		where = &cond
+	}
+
+	var order []string
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			if len(order) == maxOrderKeys {
+				return nil, p.errorf("at most %d ORDER BY columns are allowed", maxOrderKeys)
+			}
+			c, err := p.columnRef()
+			if err != nil {
+				return nil, err
+			}
+			if p.accept("DESC") {
+				order = append(order, c.expr+" DESC")
+			} else {
+				p.accept("ASC")
+				order = append(order, c.expr)
+			}
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	limit := DefaultLimit
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || n < 1 || n > MaxLimit {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("LIMIT must be a number between 1 and %d", MaxLimit)}
+		}
+		limit = n
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("expected end of statement")
+	}
+
+	return build(selected, where, order, limit), nil
+}
+
+func (p *parser) or() (operand, error) {
+	return p.binary("OR", p.and)
+}
+
+func (p *parser) and() (operand, error) {
+	return p.binary("AND", p.not)
+}
+
+// binary parses operands of next joined by the keyword op
+func (p *parser) binary(op string, next func() (operand, error)) (operand, error) {
+	left, err := next()
+	if err != nil {
+		return operand{}, err
+	}
+	for p.accept(op) {
+		right, err := next()
+		if err != nil {
+			return operand{}, err
+		}
+		left = operand{
+			sql:  left.sql + " " + op + " " + right.sql,
+			args: slices.Concat(left.args, right.args),
+			typ:  typeBool,
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (operand, error) {
+	if !p.accept("NOT") {
+		return p.predicate()
+	}
+
+	if err := p.enter(); err != nil {
+		return operand{}, err
+	}
+	defer p.leave()
+
+	cond, err := p.not()
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{sql: "NOT (" + cond.sql + ")", args: cond.args, typ: typeBool}, nil
+}
+
+func (p *parser) enter() error {
+	if p.depth == maxDepth {
+		return p.errorf("conditions nest at most %d levels deep", maxDepth)
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) predicate() (operand, error) {
+	if p.accept("(") {
+		if err := p.enter(); err != nil {
+			return operand{}, err
+		}
+		defer p.leave()
+
+		cond, err := p.or()
+		if err != nil {
+			return operand{}, err
+		}
+		if err := p.expect(")"); err != nil {
+			return operand{}, err
+		}
+		return operand{sql: "(" + cond.sql + ")", args: cond.args, typ: typeBool}, nil
+	}
+
+	if p.predicates == maxPredicates {
+		return operand{}, p.errorf("at most %d comparisons are allowed", maxPredicates)
+	}
+	p.predicates++
+
+	left, err := p.value()
+	if err != nil {
+		return operand{}, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenSymbol && comparisons[t.text]:
+		p.pos++
+		right, err := p.value()
+		if err != nil {
+			return operand{}, err
+		}
+		return compare(left, t.text, right)
+	case p.accept("IS"):
+		negate := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return operand{}, err
+		}
+		if left.column == nil || !left.column.nullable {
+			return operand{}, &Error{Pos: left.position, Msg: "only nullable columns can be compared with NULL"}
+		}
+		if negate {
+			return operand{sql: left.sql + " IS NOT NULL", typ: typeBool}, nil
+		}
+		return operand{sql: left.sql + " IS NULL", typ: typeBool}, nil
+	}
+
+	negate := p.accept("NOT")
+	switch {
+	case p.accept("LIKE"):
+		pattern, err := p.value()
+		if err != nil {
+			return operand{}, err
+		}
+		if left.typ != typeString || pattern.typ != typeString {
+			return operand{}, &Error{Pos: left.position, Msg: "LIKE compares strings"}
+		}
+		op := " LIKE "
+		if negate {
+			op = " NOT LIKE "
+		}
+		return operand{sql: left.sql + op + pattern.sql, args: slices.Concat(left.args, pattern.args), typ: typeBool}, nil
+	case p.accept("IN"):
+		return p.in(left, negate)
+	case negate:
+		return operand{}, p.errorf("expected LIKE or IN")
+	}
+
+	// A boolean column stands alone as a condition
+	if left.typ != typeBool {
+		return operand{}, p.errorf("expected comparison")
+	}
+	return left, nil
+}
+
+func (p *parser) in(left operand, negate bool) (operand, error) {
+	if err := p.expect("("); err != nil {
+		return operand{}, err
+	}
+
+	var values []string
+	args := slices.Clone(left.args)
+	for {
+		if len(values) == maxInValues {
+			return operand{}, p.errorf("IN lists hold at most %d values", maxInValues)
+		}
+		v, err := p.value()
+		if err != nil {
+			return operand{}, err
+		}
+		if v.column != nil {
+			return operand{}, &Error{Pos: v.position, Msg: "IN lists hold literals only"}
+		}
+		if v, err = coerce(v, left.typ); err != nil {
+			return operand{}, err
+		}
+		values = append(values, v.sql)
+		args = append(args, v.args...)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if err := p.expect(")"); err != nil {
+		return operand{}, err
+	}
+
+	op := " IN ("
+	if negate {
+		op = " NOT IN ("
+	}
+	return operand{sql: left.sql + op + strings.Join(values, ", ") + ")", args: args, typ: typeBool}, nil
+}
+
+// value parses a column or a literal
+func (p *parser) value() (operand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenIdent:
+		c, err := p.columnRef()
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{sql: c.expr, typ: c.typ, column: &c, position: t.pos}, nil
+	case tokenString:
+		p.pos++
+		text := t.text
+		return operand{sql: "?", args: []any{text}, typ: typeString, literal: &text, position: t.pos}, nil
+	case tokenNumber:
+		p.pos++
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return operand{}, &Error{Pos: t.pos, Msg: "number out of range"}
+		}
+		return operand{sql: "?", args: []any{n}, typ: typeInteger, position: t.pos}, nil
+	case tokenKeyword:
+		if t.text == "TRUE" || t.text == "FALSE" {
+			p.pos++
+			return operand{sql: "?", args: []any{t.text == "TRUE"}, typ: typeBool, position: t.pos}, nil
+		}
+	}
+	return operand{}, p.errorf("expected column or value")
+}
+
+// compare compiles a comparison, which must involve a column and operands of the same type
+func compare(left operand, op string, right operand) (operand, error) {
+	if left.column == nil && right.column == nil {
+		return operand{}, &Error{Pos: left.position, Msg: "comparisons must involve a column"}
+	}
+
+	var err error
+	if left.column == nil {
+		left, err = coerce(left, right.typ)
+	} else {
+		right, err = coerce(right, left.typ)
+	}
+	if err != nil {
+		return operand{}, err
+	}
+
+	if op == "<>" {
+		op = "!="
+	}
+	return operand{sql: left.sql + " " + op + " " + right.sql, args: slices.Concat(left.args, right.args), typ: typeBool}, nil
+}
+
+// timeLayouts are the formats of timestamp literals
+var timeLayouts = []string{time.RFC3339Nano, time.DateOnly}
+
+// coerce checks that literal v can be compared with a value of type typ, reading strings as timestamps if needed
+func coerce(v operand, typ valueType) (operand, error) {
+	if v.typ == typ {
+		return v, nil
+	}
+
+	if typ == typeTime && v.literal != nil {
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, *v.literal); err == nil {
+				// Timestamps are stored in UTC, and compared as text
+				return operand{sql: "?", args: []any{t.UTC()}, typ: typeTime, position: v.position}, nil
+			}
+		}
+		return operand{}, &Error{Pos: v.position, Msg: "timestamps must be RFC 3339 or YYYY-MM-DD strings"}
+	}
+
+	return operand{}, &Error{Pos: v.position, Msg: fmt.Sprintf("expected %s value, got %s", typ, v.typ)}
+}
@@ -0,0 +1,143 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the number of rows returned by statements without a LIMIT clause
+	DefaultLimit = 100
+	// MaxLimit caps the LIMIT clause
+	MaxLimit = 1000
+	// MaxLength caps the length of a statement in bytes
+	MaxLength = 4096
+
+	// maxPredicates caps the comparisons of a WHERE clause
+	maxPredicates = 32
+	// maxDepth caps the nesting of parentheses and NOT operators
+	maxDepth = 16
+	// maxInValues caps the values of an IN list
+	maxInValues = 100
+	// maxOrderKeys caps the keys of an ORDER BY clause
+	maxOrderKeys = 4
+)
+
+type valueType string
+
+const (
+	typeString  valueType = "string"
+	typeInteger valueType = "integer"
+	typeTime    valueType = "timestamp"
+	typeBool    valueType = "boolean"
+)
+
+// column is a virtual column exposed to statements, computed by an SQL expression over the metadata table
+type column struct {
+	name     string
+	expr     string
+	typ      valueType
+	nullable bool
+}
+
+// columns are the only names statements can refer to, in the order SELECT * returns them
+var columns = []column{
+	{name: "bucket", expr: "bucket", typ: typeString},
+	{name: "name", expr: "name", typ: typeString},
+	{name: "parent", expr: "parent", typ: typeString},
+	{name: "size", expr: "size", typ: typeInteger},
+	{name: "storage_class", expr: "storage_class", typ: typeString},
+	{name: "created", expr: "created", typ: typeTime},
+	{name: "updated", expr: "updated", typ: typeTime},
+	{name: "custom_time", expr: "custom_time", typ: typeTime, nullable: true},
+	{name: "detected_type", expr: "detected_type", typ: typeString, nullable: true},
+	{name: "marker", expr: "marker", typ: typeBool},
+	{name: "depth", expr: "length(name) - length(replace(name, '/', ''))", typ: typeInteger},
+}
+
+func lookupColumn(name string) (column, bool) {
+	for _, c := range columns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return column{}, false
+}
+
+// Columns returns the names of the columns statements can refer to
+func Columns() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Error is a statement rejected by Parse, Pos being the byte offset of the offending token
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+// Statement is a validated statement compiled to SQL over the metadata table
+// Every value of the statement is bound as an argument, only column expressions and operators reach the SQL
+type Statement struct {
+	// Columns names the result columns in order
+	Columns []string
+	// Booleans marks the result columns holding booleans, which drivers may scan as integers
+	Booleans []bool
+	SQL      string
+	Args     []any
+}
+
+// Parse validates a statement of the form
+//
+//	SELECT * | column [, ...] [FROM metadata] [WHERE condition] [ORDER BY column [ASC | DESC] [, ...]] [LIMIT n]
+//
+// Conditions combine comparisons of columns with literals or other columns with AND, OR, NOT and parentheses
+// Comparisons are =, !=, <>, <, <=, >, >=, [NOT] LIKE, [NOT] IN (...) and IS [NOT] NULL,
+// timestamps are compared with RFC 3339 or YYYY-MM-DD strings and boolean columns may stand alone
+func Parse(src string) (*Statement, error) {
+	if len(src) > MaxLength {
+		return nil, &Error{Pos: MaxLength, Msg: fmt.Sprintf("statement longer than %d bytes", MaxLength)}
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	return p.statement()
+}
+
+// build assembles the SQL of a parsed statement
+func build(selected []column, where *operand, order []string, limit int) *Statement {
+	stmt := &Statement{}
+
+	exprs := make([]string, len(selected))
+	for i, c := range selected {
+		exprs[i] = c.expr + " AS " + c.name
+		stmt.Columns = append(stmt.Columns, c.name)
+		stmt.Booleans = append(stmt.Booleans, c.typ == typeBool)
+	}
+
+	var sql strings.Builder
+	sql.WriteString("SELECT " + strings.Join(exprs, ", ") + " FROM metadata")
+	if where != nil {
+		sql.WriteString(" WHERE " + where.sql)
+		stmt.Args = append(stmt.Args, where.args...)
+	}
+
+	// Rows come in primary key order unless ordered otherwise, and ties are broken by it
+	order = append(order, "bucket", "name")
+	sql.WriteString(" ORDER BY " + strings.Join(order, ", ") + " LIMIT ?;")
+	stmt.Args = append(stmt.Args, limit)
+
+	stmt.SQL = sql.String()
+	return stmt
+}
@@ -0,0 +1,133 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		src         string
+		wantColumns []string
+		wantSQL     string
+		wantArgs    []any
+	}{
+		{
+			"Select every column",
+			"SELECT *",
+			Columns(),
+			"SELECT bucket AS bucket, name AS name, parent AS parent, size AS size, storage_class AS storage_class, " +
+				"created AS created, updated AS updated, custom_time AS custom_time, detected_type AS detected_type, marker AS marker, " +
+				"length(name) - length(replace(name, '/', '')) AS depth FROM metadata ORDER BY bucket, name LIMIT ?;",
+			[]any{DefaultLimit},
+		},
+		{
+			"Filter, order and limit",
+			"select name, size from metadata where size >= 1024 and (storage_class = 'STANDARD' or name like '%.log') order by size desc limit 10",
+			[]string{"name", "size"},
+			"SELECT name AS name, size AS size FROM metadata WHERE size >= ? AND (storage_class = ? OR name LIKE ?) ORDER BY size DESC, bucket, name LIMIT ?;",
+			[]any{int64(1024), "STANDARD", "%.log", 10},
+		},
+		{
+			"Timestamps, lists, nulls and boolean columns",
+			"SELECT name WHERE created < '2024-10-01' AND updated >= '2024-10-01T12:00:00+02:00' AND storage_class NOT IN ('ARCHIVE', 'COLDLINE') AND detected_type IS NOT NULL AND NOT marker",
+			[]string{"name"},
+			"SELECT name AS name FROM metadata WHERE created < ? AND updated >= ? AND storage_class NOT IN (?, ?) AND detected_type IS NOT NULL AND NOT (marker) ORDER BY bucket, name LIMIT ?;",
+			[]any{time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC), "ARCHIVE", "COLDLINE", DefaultLimit},
+		},
+		{
+			"Literal on the left and escaped quotes",
+			"SELECT name WHERE 'it''s' = name OR 2 < depth",
+			[]string{"name"},
+			"SELECT name AS name FROM metadata WHERE ? = name OR ? < length(name) - length(replace(name, '/', '')) ORDER BY bucket, name LIMIT ?;",
+			[]any{"it's", int64(2), DefaultLimit},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmt, err := Parse(tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(stmt.Columns, tc.wantColumns) {
+				t.Errorf("Columns mismatch: got %v, want %v", stmt.Columns, tc.wantColumns)
+			}
+			if stmt.SQL != tc.wantSQL {
+				t.Errorf("SQL mismatch:\ngot  %s\nwant %s", stmt.SQL, tc.wantSQL)
+			}
+			if !reflect.DeepEqual(stmt.Args, tc.wantArgs) {
+				t.Errorf("Args mismatch: got %#v, want %#v", stmt.Args, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     string
+		wantPos int
+	}{
+		{"Empty statement", "", 0},
+		{"Unknown column", "SELECT owner", 7},
+		{"Other table", "SELECT * FROM bucket", 14},
+		{"Statement separator", "SELECT *; DROP TABLE metadata", 8},
+		{"Comment", "SELECT * -- all", 9},
+		{"Unterminated string", "SELECT * WHERE name = 'a", 22},
+		{"Type mismatch", "SELECT * WHERE size = 'large'", 22},
+		{"Invalid timestamp", "SELECT * WHERE created > 'yesterday'", 25},
+		{"Comparison without column", "SELECT * WHERE 1 = 1", 15},
+		{"Null on non-nullable column", "SELECT * WHERE name IS NULL", 15},
+		{"Non boolean column alone", "SELECT * WHERE size", 19},
+		{"Limit above maximum", "SELECT * LIMIT 100000", 15},
+		{"Trailing tokens", "SELECT * LIMIT 1 2", 17},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.src)
+
+			var queryErr *Error
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Expected query error, got %v", err)
+			}
+			if queryErr.Pos != tc.wantPos {
+				t.Errorf("Position mismatch: got %d, want %d (%v)", queryErr.Pos, tc.wantPos, err)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	src := "SELECT * WHERE "
+	for i := 0; i <= maxPredicates; i++ {
+		if i > 0 {
+			src += " OR "
+		}
+		src += "size = 1"
+	}
+	if _, err := Parse(src); err == nil {
+		t.Errorf("Expected error with %d comparisons", maxPredicates+1)
+	}
+
+	src = "SELECT * WHERE "
+	for i := 0; i <= maxDepth; i++ {
+		src += "NOT "
+	}
+	if _, err := Parse(src + "marker"); err == nil {
+		t.Errorf("Expected error nesting %d levels deep", maxDepth+1)
+	}
+
+	long := make([]byte, MaxLength+1)
+	for i := range long {
+		long[i] = ' '
+	}
+	if _, err := Parse("SELECT *" + string(long)); err == nil {
+		t.Error("Expected error with overlong statement")
+	}
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// aclSchema is part of the schema, and added to databases created before object ACLs were indexed
+const aclSchema = `
+	CREATE TABLE object_acl (
+		bucket		TEXT NOT NULL,
+		name		TEXT NOT NULL,
+		entities	TEXT NOT NULL, -- comma separated entities granted access outside of project roles
+		PRIMARY KEY (bucket, name)
+	);
+`
+
+type ACL struct {
+	*Database
+}
+
+type ACLRepository interface {
+	Upsert(ctx context.Context, bucket string, name string, entities []string) error
+	GetACLReport(ctx context.Context, prefix string) ([]*model.PrefixACLStats, error)
+}
+
+func NewACLRepository(db *Database) ACLRepository {
+	return &ACL{db}
+}
+
+// Upsert records the entities an object grants access to through its ACL
+func (a *ACL) Upsert(ctx context.Context, bucket string, name string, entities []string) error {
+	query := `
+		INSERT INTO object_acl (bucket, name, entities)
+		VALUES ($1, $2, $3)
+		ON CONFLICT(bucket, name)
+		DO UPDATE SET entities = $3;
+	`
+
+	if len(bucket) == 0 || len(name) == 0 {
+		return errors.New("bucket or name argument is empty")
+	}
+
+	return a.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, bucket, name, strings.Join(entities, ","))
+		return err
+	})
+}
+
+// GetACLReport counts the objects with ACL grants under prefix per child prefix,
+// listing the distinct entities granted access, ordered from the prefix with the most objects
+func (a *ACL) GetACLReport(ctx context.Context, prefix string) ([]*model.PrefixACLStats, error) {
+	type aclRow struct {
+		Name     string `db:"name"`
+		Entities string `db:"entities"`
+	}
+
+	if prefix == "/" {
+		prefix = "" // handle root
+	}
+
+	query := `
+		SELECT name, entities
+		FROM object_acl
+		WHERE name LIKE $1 || '%';
+	`
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := a.reader(ctx).QueryxContext(ctx, query, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]*model.PrefixACLStats)
+	for rows.Next() {
+		var row aclRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		childPrefix := getChildPrefix(prefix, row.Name)
+		stat, ok := stats[childPrefix]
+		if !ok {
+			stat = &model.PrefixACLStats{Prefix: childPrefix, Entities: []string{}}
+			stats[childPrefix] = stat
+		}
+
+		stat.Objects++
+		for _, entity := range strings.Split(row.Entities, ",") {
+			if len(entity) > 0 && !slices.Contains(stat.Entities, entity) {
+				stat.Entities = append(stat.Entities, entity)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := make([]*model.PrefixACLStats, 0, len(stats))
+	for _, stat := range stats {
+		sort.Strings(stat.Entities)
+		report = append(report, stat)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Objects != report[j].Objects {
+			return report[i].Objects > report[j].Objects
+		}
+		return report[i].Prefix < report[j].Prefix
+	})
+	return report, nil
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetACLReport(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	aclRepo := NewACLRepository(db)
+
+	acls := []struct {
+		name     string
+		entities []string
+	}{
+		{"public/a", []string{"allUsers"}},
+		{"public/b/c", []string{"allUsers", "user-mock@example.com"}},
+		{"shared/d", []string{"group-mock@example.com"}},
+		{"e", []string{"allAuthenticatedUsers"}},
+	}
+
+	for _, acl := range acls {
+		if err := aclRepo.Upsert(ctx, "mock", acl.name, acl.entities); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			"Groups root by top level prefix",
+			"/",
+			[]string{
+				"public/:2:allUsers,user-mock@example.com",
+				"/:1:allAuthenticatedUsers",
+				"shared/:1:group-mock@example.com",
+			},
+		},
+		{
+			"Groups nested prefix by child prefix",
+			"public/",
+			[]string{
+				"public/:1:allUsers",
+				"public/b/:1:allUsers,user-mock@example.com",
+			},
+		},
+		{
+			"Returns empty report without ACL grants",
+			"private/",
+			[]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := aclRepo.GetACLReport(ctx, tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, stat := range report {
+				got = append(got, fmt.Sprintf("%s:%d:%s", stat.Prefix, stat.Objects, strings.Join(stat.Entities, ",")))
+			}
+
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("Report mismatch: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	// maxAdvisedStatements bounds the distinct statements whose plans are remembered
+	maxAdvisedStatements = 1024
+	// maxExampleStatements bounds the statements reported per recommendation
+	maxExampleStatements = 3
+	// advisorQueueSize bounds the statements waiting to be analyzed, later ones are dropped
+	advisorQueueSize = 1024
+)
+
+// indexCandidate is an index supporting a filter or sort the API runs
+type indexCandidate struct {
+	name    string
+	table   string
+	columns []string
+	reason  string
+	// pattern matches statements filtering or sorting on the leading column
+	pattern *regexp.Regexp
+}
+
+func newIndexCandidate(name, table string, columns []string, reason string) *indexCandidate {
+	return &indexCandidate{
+		name:    name,
+		table:   table,
+		columns: columns,
+		reason:  reason,
+		pattern: regexp.MustCompile(fmt.Sprintf(`(?is)\b(WHERE|ORDER BY)\b.*\b%s\b`, columns[0])),
+	}
+}
+
+func (c *indexCandidate) definition() string {
+	return fmt.Sprintf("CREATE INDEX %s ON %s (%s)", c.name, c.table, strings.Join(c.columns, ", "))
+}
+
+var indexCandidates = []*indexCandidate{
+	newIndexCandidate("directory_history_name", "directory_history", []string{"name", "window_start"}, "Directory time series filter on name and window"),
+	newIndexCandidate("write_stats_window", "write_stats", []string{"window_start"}, "Write statistics filter and prune on window"),
+	newIndexCandidate("metadata_updated", "metadata", []string{"updated"}, "Age conditions filter objects on their update time"),
+	newIndexCandidate("metadata_size", "metadata", []string{"size"}, "Filtering or sorting objects by size"),
+	newIndexCandidate("metadata_storage_class", "metadata", []string{"storage_class"}, "Filtering objects by storage class"),
+}
+
+// indexReasons explains the indexes of the schema
+var indexReasons = map[string]string{
+	"metadata_parent":               "Listing the objects of a directory filters on parent",
+	"metadata_parent_size":          "Listings filtered by size scan the objects of a directory in size order",
+	"metadata_parent_updated":       "Listings filtered by update time scan the objects of a directory in update order",
+	"directory_parent":              "Listing the child directories of a directory filters on parent",
+	"directory_history_parent":      "Directory diffs filter on parent and window",
+	"metadata_name_nocase":          "Searches narrow names down to their path, ignoring ASCII case if requested",
+	"top_directory_size":            "Rankings of the largest directories are read and evicted by size",
+	"reservation_bucket_expires_at": "Reservations are summed per bucket while unexpired, and pruned once expired",
+	"ingest_journal_published":      "Journaled messages are pruned per subscription by publish time",
+}
+
+// IndexAdvisor recommends indexes from the query plans of executed statements,
+// and creates them once they are hit often enough if enabled
+type IndexAdvisor struct {
+	db         *Database
+	minHits    int
+	autoCreate bool
+	queue      chan LoggedQuery
+
+	mu sync.Mutex
+	// plans maps every analyzed statement to the candidates supporting it
+	plans    map[string][]*indexCandidate
+	hits     map[string]int
+	examples map[string][]string
+}
+
+// NewIndexAdvisor recommends an index once minHits statements would have used it
+// If autoCreate is set, recommended indexes are created as well
+func NewIndexAdvisor(db *Database, minHits int, autoCreate bool) *IndexAdvisor {
+	return &IndexAdvisor{
+		db:         db,
+		minHits:    minHits,
+		autoCreate: autoCreate,
+		queue:      make(chan LoggedQuery, advisorQueueSize),
+		plans:      make(map[string][]*indexCandidate),
+		hits:       make(map[string]int),
+		examples:   make(map[string][]string),
+	}
+}
+
+// Observe queues executed statements for analysis without blocking
+func (a *IndexAdvisor) Observe(queries []LoggedQuery) {
+	for _, q := range queries {
+		select {
+		case a.queue <- q:
+		default:
+		}
+	}
+}
+
+// Run analyzes observed statements until ctx is cancelled
+func (a *IndexAdvisor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-a.queue:
+			if err := a.analyze(ctx, q); err != nil {
+				log.Printf("Error analyzing statement for indexes: %v", err)
+			}
+		}
+	}
+}
+
+// analyze counts a statement against the candidates supporting it,
+// explaining its plan the first time it is seen
+func (a *IndexAdvisor) analyze(ctx context.Context, q LoggedQuery) error {
+	query := q.SQL
+
+	a.mu.Lock()
+	candidates, ok := a.plans[query]
+	full := len(a.plans) >= maxAdvisedStatements
+	a.mu.Unlock()
+
+	if !ok {
+		if full {
+			return nil
+		}
+
+		plan, err := a.db.ExplainQueryPlan(ctx, query, q.Args)
+		if err != nil {
+			return err
+		}
+		candidates = supportingCandidates(query, plan)
+	}
+
+	a.mu.Lock()
+	a.plans[query] = candidates
+	var ready []*indexCandidate
+	for _, c := range candidates {
+		a.hits[c.name]++
+		if len(a.examples[c.name]) < maxExampleStatements && !ok {
+			a.examples[c.name] = append(a.examples[c.name], strings.Join(strings.Fields(query), " "))
+		}
+		if a.hits[c.name] == a.minHits {
+			ready = append(ready, c)
+		}
+	}
+	a.mu.Unlock()
+
+	if !a.autoCreate {
+		return nil
+	}
+
+	for _, c := range ready {
+		log.Printf("Creating index %s after %d supported statements: %s", c.name, a.minHits, c.reason)
+		if err := a.createIndex(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supportingCandidates returns the candidates on tables the plan scans whose columns the statement filters or sorts on
+func supportingCandidates(query string, plan []string) []*indexCandidate {
+	var candidates []*indexCandidate
+	for _, c := range indexCandidates {
+		if !c.pattern.MatchString(query) {
+			continue
+		}
+
+		for _, step := range plan {
+			step = strings.TrimSpace(step)
+			if step == "SCAN "+c.table || strings.HasPrefix(step, "SCAN "+c.table+" ") {
+				candidates = append(candidates, c)
+				break
+			}
+		}
+	}
+	return candidates
+}
+
+func (a *IndexAdvisor) createIndex(ctx context.Context, c *indexCandidate) error {
+	ctx, cancel := a.db.withTimeout(ctx)
+	defer cancel()
+
+	query := strings.Replace(c.definition(), "CREATE INDEX", "CREATE INDEX IF NOT EXISTS", 1)
+	if _, err := a.db.ExecContext(ctx, query); err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// Report lists the existing indexes and why they exist,
+// and the missing ones observed statements would have used, most hit first
+func (a *IndexAdvisor) Report(ctx context.Context) (*model.IndexReport, error) {
+	ctx, cancel := a.db.withTimeout(ctx)
+	defer cancel()
+
+	indexes := []*model.Index{}
+	query := `
+		SELECT name, tbl_name, COALESCE(sql, '') AS sql
+		FROM sqlite_master
+		WHERE type = 'index'
+		ORDER BY tbl_name, name;
+	`
+	if err := a.db.SelectContext(ctx, &indexes, query); err != nil {
+		return nil, translateError(err)
+	}
+
+	reasons := make(map[string]string, len(indexReasons)+len(indexCandidates))
+	for name, reason := range indexReasons {
+		reasons[name] = reason
+	}
+	for _, c := range indexCandidates {
+		reasons[c.name] = c.reason
+	}
+
+	existing := make(map[string]bool, len(indexes))
+	for _, index := range indexes {
+		existing[index.Name] = true
+		index.Reason = reasons[index.Name]
+		if strings.HasPrefix(index.Name, "sqlite_autoindex_") {
+			index.Reason = "Primary key"
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recommendations := []*model.IndexRecommendation{}
+	for _, c := range indexCandidates {
+		if existing[c.name] {
+			continue
+		}
+
+		if a.hits[c.name] < a.minHits {
+			continue
+		}
+
+		recommendations = append(recommendations, &model.IndexRecommendation{
+			Name:       c.name,
+			Table:      c.table,
+			Columns:    c.columns,
+			Reason:     c.reason,
+			Definition: c.definition(),
+			Hits:       a.hits[c.name],
+			Statements: a.examples[c.name],
+		})
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].Hits > recommendations[j].Hits
+	})
+
+	return &model.IndexReport{
+		AutoCreate:      a.autoCreate,
+		Indexes:         indexes,
+		Recommendations: recommendations,
+	}, nil
+}
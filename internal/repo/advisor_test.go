@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIndexAdvisor(t *testing.T) {
+	testCases := []struct {
+		name                string
+		autoCreate          bool
+		executions          int
+		wantRecommendations int
+		wantIndex           bool
+	}{
+		{"Below threshold", false, 1, 0, false},
+		{"Recommended", false, 2, 1, false},
+		{"Created", true, 2, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewDatabase(":memory:", 1)
+			db.Connect(context.Background())
+			defer db.Close()
+
+			if err := db.Setup(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.CreateTables(); err != nil {
+				t.Fatal(err)
+			}
+
+			advisor := NewIndexAdvisor(db, 2, tc.autoCreate)
+			statsRepo := NewStatsRepository(db)
+
+			for range tc.executions {
+				ctx, queryLog := WithQueryLog(context.Background())
+				if _, err := statsRepo.GetWriteStats(ctx, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, q := range queryLog.Queries() {
+					if err := advisor.analyze(context.Background(), q); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			report, err := advisor.Report(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Recommendations) != tc.wantRecommendations {
+				t.Fatalf("Recommendation count mismatch: got %d, want %d", len(report.Recommendations), tc.wantRecommendations)
+			}
+
+			if tc.wantRecommendations > 0 {
+				got := report.Recommendations[0]
+				if got.Name != "write_stats_window" || got.Hits != tc.executions || len(got.Statements) != 1 {
+					t.Errorf("Recommendation mismatch: got %+v", got)
+				}
+			}
+
+			var hasIndex bool
+			for _, index := range report.Indexes {
+				if index.Name == "write_stats_window" {
+					hasIndex = true
+					if index.Reason == "" || index.Table != "write_stats" {
+						t.Errorf("Index mismatch: got %+v", index)
+					}
+				}
+				if index.Reason == "" {
+					t.Errorf("Expected reason for index %s", index.Name)
+				}
+			}
+
+			if hasIndex != tc.wantIndex {
+				t.Errorf("Index existence mismatch: got %v, want %v", hasIndex, tc.wantIndex)
+			}
+		})
+	}
+}
+
+func TestSupportingCandidates(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		plan  []string
+		want  []string
+	}{
+		{"Filter scanning table", "SELECT * FROM metadata WHERE updated < ?", []string{"SCAN metadata"}, []string{"metadata_updated"}},
+		{"Sort scanning table", "SELECT * FROM metadata ORDER BY size DESC", []string{"SCAN metadata", "USE TEMP B-TREE FOR ORDER BY"}, []string{"metadata_size"}},
+		{"Searched with index", "SELECT * FROM metadata WHERE updated < ?", []string{"SEARCH metadata USING INDEX metadata_updated (updated<?)"}, nil},
+		{"Column only selected", "SELECT updated FROM metadata", []string{"SCAN metadata"}, nil},
+		{"Nested scan", "SELECT * FROM directory_history h WHERE h.name = ?", []string{"  SCAN directory_history"}, []string{"directory_history_name"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := supportingCandidates(tc.query, tc.plan)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Candidate count mismatch: got %d, want %d", len(got), len(tc.want))
+			}
+			for i, c := range got {
+				if c.name != tc.want[i] {
+					t.Errorf("Candidate mismatch: got %s, want %s", c.name, tc.want[i])
+				}
+			}
+		})
+	}
+}
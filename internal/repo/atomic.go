@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type writeTxKey struct{}
+
+// errDryRun rolls back the transaction of a dry run once it completed
+var errDryRun = errors.New("dry run")
+
+// writeTargetPattern matches the statement and table of INSERT, UPDATE and DELETE statements
+var writeTargetPattern = regexp.MustCompile(`(?is)^\s*(INSERT|UPDATE|DELETE)\s+(?:OR\s+\w+\s+)?(?:INTO\s+|FROM\s+)?(\w+)`)
+
+// writeTx is the transaction shared by the repository writes and reads of a context
+type writeTx struct {
+	db *Database
+	tx *sql.Tx
+}
+
+// Atomically runs fn in a single transaction of db, shared by every repository write and read of the context
+// given to fn, so its writes are committed together or not at all, and its reads see them
+// Writes failing within fn roll back their own changes only, the transaction being rolled back as a whole if fn
+// returns an error
+// Atomically nests within the transaction of ctx if it already runs in one
+// The statements of fn are recorded in the QueryLog of ctx, if any
+func (db *Database) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if db.writeTxOf(ctx) != nil {
+			return fn(ctx)
+		}
+		return fn(db.withWriteTx(ctx, tx, nil))
+	})
+}
+
+// withWriteTx returns ctx running its writes and reads in tx, and recording its statements in queryLog if set
+func (db *Database) withWriteTx(ctx context.Context, tx *sql.Tx, queryLog *QueryLog) context.Context {
+	if queryLog != nil {
+		ctx = context.WithValue(ctx, queryLogKey{}, queryLog)
+	}
+	return context.WithValue(ctx, writeTxKey{}, &writeTx{db, tx})
+}
+
+// DryRun runs fn like Atomically then rolls its writes back, returning the write statements it executed
+// fn reads the database as if its writes were committed, so it runs as it would for real
+func (db *Database) DryRun(ctx context.Context, fn func(ctx context.Context) error) ([]LoggedQuery, error) {
+	parent, _ := ctx.Value(queryLogKey{}).(*QueryLog)
+	var writes []LoggedQuery
+	err := db.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		// Retried transactions run fn again
+		writes = nil
+		queryLog := &QueryLog{parent: parent}
+		if err := fn(db.withWriteTx(ctx, tx, queryLog)); err != nil {
+			return err
+		}
+
+		// Writes failing within the transaction roll back to the savepoint of their operation
+		var savepoints []int
+		for _, q := range queryLog.Queries() {
+			statement := strings.ToUpper(strings.TrimSpace(q.SQL))
+			switch {
+			case strings.HasPrefix(statement, "SAVEPOINT"):
+				savepoints = append(savepoints, len(writes))
+			case strings.HasPrefix(statement, "ROLLBACK TO") && len(savepoints) > 0:
+				writes = writes[:savepoints[len(savepoints)-1]]
+				if strings.Contains(statement, "RELEASE") {
+					savepoints = savepoints[:len(savepoints)-1]
+				}
+			case strings.HasPrefix(statement, "RELEASE") && len(savepoints) > 0:
+				savepoints = savepoints[:len(savepoints)-1]
+			default:
+				if target, _ := q.WriteTarget(); len(target) > 0 {
+					writes = append(writes, q)
+				}
+			}
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return writes, nil
+	}
+	return nil, err
+}
+
+// WriteTarget returns the statement of q, INSERT, UPDATE or DELETE, and the table it writes, or empty strings
+// if q doesn't write a table
+func (q LoggedQuery) WriteTarget() (statement, table string) {
+	match := writeTargetPattern.FindStringSubmatch(q.SQL)
+	if match == nil {
+		return "", ""
+	}
+	return strings.ToUpper(match[1]), match[2]
+}
+
+// writeTxOf returns the transaction of ctx begun on db by Atomically, or nil
+func (db *Database) writeTxOf(ctx context.Context) *sql.Tx {
+	if t, ok := ctx.Value(writeTxKey{}).(*writeTx); ok && t.db == db {
+		return t.tx
+	}
+	return nil
+}
+
+// writeWithin runs op in tx, rolling back its changes only if it fails
+func writeWithin(ctx context.Context, tx *sql.Tx, op WriteOp) error {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT atomic_op;`); err != nil {
+		return translateError(err)
+	}
+
+	if err := translateError(op(ctx, tx)); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO atomic_op; RELEASE atomic_op;`); rollbackErr != nil {
+			return translateError(rollbackErr)
+		}
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `RELEASE atomic_op;`)
+	return translateError(err)
+}
+
+// txReader runs the reads of a context within its write transaction, so they see its writes
+func (db *Database) txReader(tx *sql.Tx) reader {
+	return &sqlx.Tx{Tx: tx, Mapper: db.DB.Mapper}
+}
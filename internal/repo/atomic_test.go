@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestAtomically(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := NewWriteQueue(db, 10)
+	db.SetWriteQueue(queue)
+	go queue.Run(ctx)
+
+	metadataRepo := NewMetadataRepository(db)
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	obj := &model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: updated, Updated: updated}
+
+	// Writes failing within the transaction roll back their own changes only, and nested transactions join it,
+	// the single connection of the database being shared by the reads of the transaction
+	err := db.Atomically(ctx, func(ctx context.Context) error {
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			return err
+		}
+		if err := metadataRepo.Insert(ctx, obj); !errors.Is(err, ErrConflict) {
+			t.Errorf("Expected ErrConflict inserting twice, got %v", err)
+		}
+		return db.Atomically(ctx, func(ctx context.Context) error {
+			_, err := metadataRepo.Get(ctx, "mock", "a/file")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "a/file"); err != nil {
+		t.Errorf("Expected the object to be committed, got %v", err)
+	}
+
+	// Transactions failing roll back every write
+	err = db.Atomically(ctx, func(ctx context.Context) error {
+		if err := metadataRepo.Delete(ctx, "mock", "a/file"); err != nil {
+			return err
+		}
+		return errors.New("mock error")
+	})
+	if err == nil {
+		t.Fatal("Expected the error of the transaction")
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "a/file"); err != nil {
+		t.Errorf("Expected the deletion to be rolled back, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	obj := &model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: updated, Updated: updated}
+
+	// Dry runs read their own writes, report the ones which didn't fail and roll every write back
+	writes, err := db.DryRun(ctx, func(ctx context.Context) error {
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			return err
+		}
+		if err := metadataRepo.Insert(ctx, obj); !errors.Is(err, ErrConflict) {
+			t.Errorf("Expected ErrConflict inserting twice, got %v", err)
+		}
+		_, err := metadataRepo.Get(ctx, "mock", "a/file")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserts := 0
+	for _, w := range writes {
+		if statement, table := w.WriteTarget(); statement == "INSERT" && table == "metadata" {
+			inserts++
+		}
+	}
+	if inserts != 1 {
+		t.Errorf("Expected a single insert into metadata, got %d of %v", inserts, writes)
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "a/file"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the writes of the dry run to be rolled back, got %v", err)
+	}
+
+	// Dry runs failing return their error
+	if _, err := db.DryRun(ctx, func(ctx context.Context) error { return errors.New("mock error") }); err == nil {
+		t.Error("Expected the error of the dry run")
+	}
+}
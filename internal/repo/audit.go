@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// auditSchema is part of the schema, and added to databases created before admin mutations were audited
+const auditSchema = `
+	CREATE TABLE audit_log (
+		id			INTEGER PRIMARY KEY AUTOINCREMENT,
+		time		TIMESTAMP NOT NULL,
+		identity	TEXT NOT NULL,
+		method		TEXT NOT NULL,
+		path		TEXT NOT NULL, -- path and query of the request
+		params		TEXT NOT NULL, -- request body
+		status		INTEGER NOT NULL,
+		error		TEXT NOT NULL DEFAULT ''
+	);
+`
+
+type Audit struct {
+	*Database
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, entry *model.AuditEntry) error
+	List(ctx context.Context, beforeID int64, limit int) ([]*model.AuditEntry, error)
+}
+
+func NewAuditRepository(db *Database) AuditRepository {
+	return &Audit{db}
+}
+
+// Record appends an entry to the audit log, setting its ID
+func (a *Audit) Record(ctx context.Context, entry *model.AuditEntry) error {
+	return a.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO audit_log (time, identity, method, path, params, status, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id;
+		`, entry.Time.UTC(), entry.Identity, entry.Method, entry.Path, entry.Params, entry.Status, entry.Error).Scan(&entry.ID)
+	})
+}
+
+// List returns up to limit entries recorded before the entry beforeID, from the latest one
+// A beforeID of 0 lists from the latest entry
+func (a *Audit) List(ctx context.Context, beforeID int64, limit int) ([]*model.AuditEntry, error) {
+	query := `
+		SELECT id, time, identity, method, path, params, status, error
+		FROM audit_log
+		WHERE $1 = 0 OR id < $1
+		ORDER BY id DESC
+		LIMIT $2;
+	`
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	entries := []*model.AuditEntry{}
+	if err := a.reader(ctx).SelectContext(ctx, &entries, query, beforeID, limit); err != nil {
+		return nil, translateError(err)
+	}
+	return entries, nil
+}
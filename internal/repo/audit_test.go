@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestAudit(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	auditRepo := NewAuditRepository(db)
+
+	for _, path := range []string{"/admin/buckets", "/admin/buckets/a/config", "/admin/buckets/a"} {
+		entry := &model.AuditEntry{Time: time.Now(), Identity: "mock", Method: "POST", Path: path, Status: 200}
+		if err := auditRepo.Record(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.ID == 0 {
+			t.Errorf("Expected ID of %s to be set", path)
+		}
+	}
+
+	got, err := auditRepo.List(ctx, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Path != "/admin/buckets/a" || got[1].Path != "/admin/buckets/a/config" || got[0].Identity != "mock" {
+		t.Fatalf("Entries mismatch: got %+v", got)
+	}
+
+	// Listings continue before the last entry returned
+	got, err = auditRepo.List(ctx, got[1].ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Path != "/admin/buckets" {
+		t.Errorf("Entries mismatch: got %+v", got)
+	}
+}
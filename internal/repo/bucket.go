@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// bucketSchema is part of the schema, and added to databases created before buckets were registered
+const bucketSchema = `
+	CREATE TABLE bucket (
+		name			TEXT NOT NULL PRIMARY KEY,
+		location		TEXT NOT NULL,
+		location_type	TEXT NOT NULL,
+		config			TEXT NOT NULL DEFAULT '{}' -- JSON encoded model.BucketConfig
+	);
+`
+
+type Bucket struct {
+	*Database
+}
+
+type BucketRepository interface {
+	Upsert(ctx context.Context, bucket model.Bucket) error
+	Get(ctx context.Context, name string) (*model.Bucket, error)
+	List(ctx context.Context) ([]*model.Bucket, error)
+	Delete(ctx context.Context, name string) error
+	GetConfig(ctx context.Context, name string) (*model.BucketConfig, error)
+	SetConfig(ctx context.Context, name string, cfg *model.BucketConfig) error
+}
+
+// bucketTables are the tables holding rows of a bucket, purged when it is deregistered
+var bucketTables = []string{"metadata", "directory", "object_acl", "write_stats", "directory_history", "seed_checkpoint", "top_directory", "top_directory_floor", "noncurrent", "reservation", "object_reads", "directory_reads", "notification_lag", "object_sample", "event_stats"}
+
+func NewBucketRepository(db *Database) BucketRepository {
+	return &Bucket{db}
+}
+
+// Upsert registers a bucket or refreshes its location
+func (b *Bucket) Upsert(ctx context.Context, bucket model.Bucket) error {
+	query := `
+		INSERT INTO bucket (name, location, location_type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT(name)
+		DO UPDATE
+		SET location = $2,
+			location_type = $3;
+	`
+
+	if len(bucket.Name) == 0 {
+		return errors.New("bucket name is empty")
+	}
+
+	return b.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, bucket.Name, bucket.Location, bucket.LocationType)
+		return err
+	})
+}
+
+// Get returns a registered bucket, or ErrNotFound
+func (b *Bucket) Get(ctx context.Context, name string) (*model.Bucket, error) {
+	query := `
+		SELECT name, location, location_type
+		FROM bucket
+		WHERE name = $1;
+	`
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+
+	var bucket model.Bucket
+	err := b.reader(ctx).QueryRowxContext(ctx, query, name).StructScan(&bucket)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &bucket, nil
+}
+
+// List returns the registered buckets sorted by name
+func (b *Bucket) List(ctx context.Context) ([]*model.Bucket, error) {
+	query := `
+		SELECT name, location, location_type
+		FROM bucket
+		ORDER BY name;
+	`
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+
+	buckets := []*model.Bucket{}
+	if err := b.reader(ctx).SelectContext(ctx, &buckets, query); err != nil {
+		return nil, translateError(err)
+	}
+	return buckets, nil
+}
+
+// Delete deregisters a bucket and purges all of its rows in one transaction, or returns ErrNotFound if it has none
+// Buckets indexed without being registered, such as those seeded before buckets were, are purged too
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	return b.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var deleted int64
+		for _, table := range append([]string{"bucket"}, bucketTables...) {
+			column := "bucket"
+			if table == "bucket" {
+				column = "name"
+			}
+
+			res, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s = $1;`, table, column), name)
+			if err != nil {
+				return err
+			}
+			rowsAffected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += rowsAffected
+		}
+
+		if deleted == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// GetConfig returns the configuration of a registered bucket, or ErrNotFound
+func (b *Bucket) GetConfig(ctx context.Context, name string) (*model.BucketConfig, error) {
+	query := `
+		SELECT config
+		FROM bucket
+		WHERE name = $1;
+	`
+
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+
+	var data string
+	err := b.reader(ctx).QueryRowContext(ctx, query, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	var cfg model.BucketConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return nil, fmt.Errorf("error decoding config of bucket %s: %w", name, err)
+	}
+	return &cfg, nil
+}
+
+// SetConfig replaces the configuration of a registered bucket, or returns ErrNotFound
+func (b *Bucket) SetConfig(ctx context.Context, name string, cfg *model.BucketConfig) error {
+	query := `
+		UPDATE bucket
+		SET config = ?
+		WHERE name = ?;
+	`
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return b.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, string(data), name)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestUpsertBucket(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucketRepo := NewBucketRepository(db)
+
+	if _, err := bucketRepo.Get(ctx, "mock"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := bucketRepo.Upsert(ctx, model.Bucket{}); err == nil {
+		t.Fatal("Expected error but did pass")
+	}
+
+	for _, bucket := range []model.Bucket{
+		{Name: "mock", Location: "US", LocationType: "multi-region"},
+		{Name: "mock", Location: "EUROPE-WEST6", LocationType: "region"},
+	} {
+		if err := bucketRepo.Upsert(ctx, bucket); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := bucketRepo.Get(ctx, bucket.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *got != bucket {
+			t.Errorf("Bucket mismatch: got %+v, want %+v", got, bucket)
+		}
+	}
+}
+
+func TestSummaryUsesBucketLocation(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := NewBucketRepository(db).Upsert(ctx, model.Bucket{Name: "mock", Location: "EUROPE-WEST6", LocationType: "region"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "file", bytesPerGB, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	exploreRepo := NewExploreRepository(db)
+
+	summary, err := exploreRepo.GetPathSummary(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Location != "EUROPE-WEST6" {
+		t.Errorf("Location mismatch: got %s, want %s", summary.Location, "EUROPE-WEST6")
+	}
+
+	if want := locationPricing[LocationEU][StorageStandard]; summary.Cost.Standard != want {
+		t.Errorf("Cost mismatch: got %f, want %f", summary.Cost.Standard, want)
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucketRepo := NewBucketRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+	directoryRepo := NewDirectoryRepository(db)
+
+	// Buckets seeded before buckets were registered have rows but no registration
+	for _, name := range []string{"kept", "purged", "unregistered"} {
+		if name != "unregistered" {
+			if err := bucketRepo.Upsert(ctx, model.Bucket{Name: name, Location: "US", LocationType: "multi-region"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := metadataRepo.Insert(ctx, &model.Metadata{Bucket: name, Name: "a/b.txt", Size: 1, StorageClass: "STANDARD"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := directoryRepo.UpsertParentDirs(ctx, StorageClass("STANDARD"), name, "a/b.txt", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"purged", "unregistered"} {
+		if err := bucketRepo.Delete(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bucketRepo.Delete(ctx, "purged"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error mismatch: got %v, want %v", err, ErrNotFound)
+	}
+
+	buckets, err := bucketRepo.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Name != "kept" {
+		t.Errorf("Buckets mismatch: got %+v", buckets)
+	}
+
+	for _, table := range bucketTables {
+		var purged, kept int
+		if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FILTER (WHERE bucket IN ('purged', 'unregistered')), COUNT(*) FILTER (WHERE bucket = 'kept') FROM %s;`, table)).Scan(&purged, &kept); err != nil {
+			t.Fatal(err)
+		}
+		if purged != 0 {
+			t.Errorf("%d rows of purged bucket left in %s", purged, table)
+		}
+		if table == "metadata" && kept != 1 {
+			t.Errorf("Rows of kept bucket deleted from %s", table)
+		}
+	}
+}
+
+func TestBucketConfig(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucketRepo := NewBucketRepository(db)
+
+	cfg := &model.BucketConfig{Workers: 4, ExcludePrefixes: []string{"tmp/"}, ReconcileInterval: model.Duration(time.Hour)}
+	if err := bucketRepo.SetConfig(ctx, "mock", cfg); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error mismatch: got %v, want %v", err, ErrNotFound)
+	}
+
+	if err := bucketRepo.Upsert(ctx, model.Bucket{Name: "mock", Location: "US", LocationType: "multi-region"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bucketRepo.SetConfig(ctx, "mock", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing the location keeps the config
+	if err := bucketRepo.Upsert(ctx, model.Bucket{Name: "mock", Location: "EU", LocationType: "multi-region"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := bucketRepo.GetConfig(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Workers != cfg.Workers || got.ReconcileInterval != cfg.ReconcileInterval || len(got.ExcludePrefixes) != 1 || got.ExcludePrefixes[0] != "tmp/" {
+		t.Errorf("Config mismatch: got %+v, want %+v", got, cfg)
+	}
+}
@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+type Checkpoint struct {
+	*Database
+}
+
+type CheckpointRepository interface {
+	Get(ctx context.Context, bucket string, prefix string) (*model.SeedCheckpoint, error)
+	Save(ctx context.Context, checkpoint *model.SeedCheckpoint) error
+	Reset(ctx context.Context, bucket string) error
+	Covers(ctx context.Context, bucket string, prefix string) (bool, error)
+}
+
+func NewCheckpointRepository(db *Database) CheckpointRepository {
+	return &Checkpoint{db}
+}
+
+// Get returns the seeding checkpoint of a partition of a bucket, or ErrNotFound if it was never seeded
+func (c *Checkpoint) Get(ctx context.Context, bucket string, prefix string) (*model.SeedCheckpoint, error) {
+	query := `
+		SELECT bucket, prefix, last_object, last_generation, objects, completed, updated
+		FROM seed_checkpoint
+		WHERE bucket = $1 AND prefix = $2;
+	`
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var checkpoint model.SeedCheckpoint
+	err := c.reader(ctx).QueryRowxContext(ctx, query, bucket, prefix).StructScan(&checkpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &checkpoint, nil
+}
+
+// Save replaces the seeding checkpoint of a partition of a bucket
+func (c *Checkpoint) Save(ctx context.Context, checkpoint *model.SeedCheckpoint) error {
+	if len(checkpoint.Bucket) == 0 {
+		return errors.New("bucket name is empty")
+	}
+
+	return c.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return saveCheckpoint(ctx, tx, checkpoint)
+	})
+}
+
+// saveCheckpoint applies Save within tx, doing nothing if checkpoint is nil
+func saveCheckpoint(ctx context.Context, tx *sql.Tx, checkpoint *model.SeedCheckpoint) error {
+	query := `
+		INSERT INTO seed_checkpoint (bucket, prefix, last_object, last_generation, objects, completed, updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT(bucket, prefix)
+		DO UPDATE
+		SET last_object = $3,
+			last_generation = $4,
+			objects = $5,
+			completed = $6,
+			updated = $7;
+	`
+
+	if checkpoint == nil {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, query, checkpoint.Bucket, checkpoint.Prefix, checkpoint.LastObject, checkpoint.LastGeneration, checkpoint.Objects, checkpoint.Completed, checkpoint.Updated)
+	return err
+}
+
+// Reset deletes every checkpoint of a bucket, so its next seeding starts over
+func (c *Checkpoint) Reset(ctx context.Context, bucket string) error {
+	return c.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM seed_checkpoint WHERE bucket = $1;`, bucket)
+		return err
+	})
+}
+
+// Covers returns whether the objects under prefix were all listed, by a completed seeding of the bucket,
+// or of prefix or one of its parents
+func (c *Checkpoint) Covers(ctx context.Context, bucket string, prefix string) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM seed_checkpoint
+			WHERE bucket = $1 AND completed AND substr($2, 1, length(prefix)) = prefix
+		);
+	`
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var covered bool
+	if err := c.reader(ctx).QueryRowContext(ctx, query, bucket, prefix).Scan(&covered); err != nil {
+		return false, translateError(err)
+	}
+	return covered, nil
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestSaveCheckpoint(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	checkpointRepo := NewCheckpointRepository(db)
+
+	if _, err := checkpointRepo.Get(ctx, "mock", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := checkpointRepo.Save(ctx, &model.SeedCheckpoint{}); err == nil {
+		t.Fatal("Expected error but did pass")
+	}
+
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, checkpoint := range []model.SeedCheckpoint{
+		{Bucket: "mock", LastObject: "a/file", Objects: 1000, Updated: updated},
+		{Bucket: "mock", LastObject: "b/file", Objects: 1500, Completed: true, Updated: updated.Add(time.Minute)},
+		{Bucket: "mock", Prefix: "b/", LastObject: "b/file", Objects: 500, Updated: updated},
+	} {
+		if err := checkpointRepo.Save(ctx, &checkpoint); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := checkpointRepo.Get(ctx, checkpoint.Bucket, checkpoint.Prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.LastObject != checkpoint.LastObject || got.Objects != checkpoint.Objects || got.Completed != checkpoint.Completed || !got.Updated.Equal(checkpoint.Updated) {
+			t.Errorf("Checkpoint mismatch: got %+v, want %+v", got, checkpoint)
+		}
+	}
+
+	// Resetting deletes the checkpoints of every partition
+	if err := checkpointRepo.Reset(ctx, "mock"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"", "b/"} {
+		if _, err := checkpointRepo.Get(ctx, "mock", prefix); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for prefix %q, got %v", prefix, err)
+		}
+	}
+}
+
+func TestCoversCheckpoint(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	checkpointRepo := NewCheckpointRepository(db)
+
+	for _, checkpoint := range []model.SeedCheckpoint{
+		{Bucket: "mock", Prefix: "a/", Completed: true},
+		{Bucket: "mock", Prefix: "b/", LastObject: "b/file"},
+	} {
+		if err := checkpointRepo.Save(ctx, &checkpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		bucket string
+		prefix string
+		want   bool
+	}{
+		{"mock", "a/", true},
+		{"mock", "a/b/", true},
+		{"mock", "ab/", false},
+		{"mock", "b/", false},
+		{"mock", "", false},
+		{"other", "a/", false},
+	}
+
+	for _, tc := range testCases {
+		covered, err := checkpointRepo.Covers(ctx, tc.bucket, tc.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if covered != tc.want {
+			t.Errorf("Covers(%s, %q) mismatch: got %v want %v", tc.bucket, tc.prefix, covered, tc.want)
+		}
+	}
+
+	// A completed seeding of the whole bucket covers every prefix
+	if err := checkpointRepo.Save(ctx, &model.SeedCheckpoint{Bucket: "mock", Completed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if covered, err := checkpointRepo.Covers(ctx, "mock", "b/"); err != nil || !covered {
+		t.Errorf("Expected b/ to be covered by the bucket, got %v, %v", covered, err)
+	}
+}
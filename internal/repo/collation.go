@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Collation selects how searches compare object names
+type Collation string
+
+const (
+	// CollationBinary compares names byte for byte
+	CollationBinary Collation = "binary"
+	// CollationNoCase ignores the case of ASCII letters, matching SQLite's NOCASE
+	CollationNoCase Collation = "nocase"
+	// CollationUnicode folds the case of every letter and normalizes compatibility forms,
+	// so "Café", "CAFÉ" and "café" match, at the cost of comparing every name
+	CollationUnicode Collation = "unicode"
+)
+
+// foldNameFunc is the SQL function folding names as CollationUnicode compares them
+const foldNameFunc = "fold_name"
+
+// foldName normalizes s to NFKC and folds its case
+// Casers hold state, so one is created per call to be safe on concurrent connections
+func foldName(s string) string {
+	return cases.Fold().String(norm.NFKC.String(s))
+}
+
+// asciiLower lowers ASCII letters only, as SQLite's lower() and NOCASE do without ICU
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
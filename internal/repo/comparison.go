@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// MaxComparisonDifferences bounds the differences listed by a comparison, all of them being counted
+const MaxComparisonDifferences = 10000
+
+type Comparison struct {
+	*Database
+}
+
+type ComparisonRepository interface {
+	CompareBuckets(ctx context.Context, bucketA, prefixA, bucketB, prefixB string, limit int) (*model.BucketComparison, error)
+}
+
+func NewComparisonRepository(db *Database) ComparisonRepository {
+	return &Comparison{db}
+}
+
+// CompareBuckets matches the objects under prefixA of bucketA with those under prefixB of bucketB by their name
+// relative to the prefix, counting the objects missing on either side or differing in size and listing up to limit
+// of them by name
+// Checksums aren't indexed, so objects of the same name and size are considered identical
+func (c *Comparison) CompareBuckets(ctx context.Context, bucketA, prefixA, bucketB, prefixB string, limit int) (*model.BucketComparison, error) {
+	result := &model.BucketComparison{
+		BucketA:     bucketA,
+		PrefixA:     prefixA,
+		BucketB:     bucketB,
+		PrefixB:     prefixB,
+		Differences: []*model.ObjectDifference{},
+	}
+
+	if prefixA == "/" {
+		prefixA = "" // handle root
+	}
+	if prefixB == "/" {
+		prefixB = ""
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	countQuery := `SELECT COUNT(*) FROM metadata WHERE bucket = $1 AND name >= $2 AND name < $3;`
+	if err := c.reader(ctx).GetContext(ctx, &result.CountA, countQuery, bucketA, prefixA, prefixEnd(prefixA)); err != nil {
+		return nil, translateError(err)
+	}
+	if err := c.reader(ctx).GetContext(ctx, &result.CountB, countQuery, bucketB, prefixB, prefixEnd(prefixB)); err != nil {
+		return nil, translateError(err)
+	}
+
+	// Objects of either side are looked up on the other through the primary key
+	// Parameters are numbered explicitly, $N names being bound in the order they first appear
+	query := `
+		SELECT substr(a.name, length(?2) + 1) AS name, a.size AS size_a, b.size AS size_b
+		FROM metadata a
+		LEFT JOIN metadata b ON b.bucket = ?4 AND b.name = ?5 || substr(a.name, length(?2) + 1)
+		WHERE a.bucket = ?1 AND a.name >= ?2 AND a.name < ?3 AND (b.name IS NULL OR b.size != a.size)
+		UNION ALL
+		SELECT substr(b.name, length(?5) + 1) AS name, NULL AS size_a, b.size AS size_b
+		FROM metadata b
+		WHERE b.bucket = ?4 AND b.name >= ?5 AND b.name < ?6
+			AND NOT EXISTS (SELECT 1 FROM metadata a WHERE a.bucket = ?1 AND a.name = ?2 || substr(b.name, length(?5) + 1))
+		ORDER BY name;
+	`
+
+	rows, err := c.reader(ctx).QueryxContext(ctx, query, bucketA, prefixA, prefixEnd(prefixA), bucketB, prefixB, prefixEnd(prefixB))
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		diff := &model.ObjectDifference{}
+		if err := rows.StructScan(diff); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		switch {
+		case diff.SizeA == nil:
+			diff.Status = model.DifferenceOnlyInB
+			result.OnlyInB++
+		case diff.SizeB == nil:
+			diff.Status = model.DifferenceOnlyInA
+			result.OnlyInA++
+		default:
+			diff.Status = model.DifferenceSizeDiffs
+			result.SizeDiffers++
+		}
+
+		if len(result.Differences) < limit {
+			result.Differences = append(result.Differences, diff)
+		} else {
+			result.Truncated = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	result.Matching = result.CountA - result.OnlyInA - result.SizeDiffers
+	return result, nil
+}
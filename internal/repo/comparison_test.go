@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestCompareBuckets(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	comparisonRepo := NewComparisonRepository(db)
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range []model.Metadata{
+		{Bucket: "source", Name: "data/same", Size: 10},
+		{Bucket: "source", Name: "data/changed", Size: 10},
+		{Bucket: "source", Name: "data/nested/missing", Size: 10},
+		{Bucket: "source", Name: "other/ignored", Size: 10},
+		{Bucket: "replica", Name: "backup/same", Size: 10},
+		{Bucket: "replica", Name: "backup/changed", Size: 20},
+		{Bucket: "replica", Name: "backup/extra", Size: 5},
+		{Bucket: "replica", Name: "data/same", Size: 10},
+	} {
+		m.StorageClass, m.Created, m.Updated = "STANDARD", now, now
+		if err := metadataRepo.Insert(ctx, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size := func(s int64) *int64 {
+		return &s
+	}
+
+	testCases := []struct {
+		name                           string
+		bucketA, prefixA               string
+		bucketB, prefixB               string
+		limit                          int
+		wantCountA, wantCountB         int64
+		wantMatching                   int64
+		wantOnlyA, wantOnlyB, wantSize int64
+		wantDifferences                []*model.ObjectDifference
+		wantTruncated                  bool
+	}{
+		{
+			"Different prefixes",
+			"source", "data/", "replica", "backup/", 10,
+			3, 3, 1, 1, 1, 1,
+			[]*model.ObjectDifference{
+				{Name: "changed", Status: model.DifferenceSizeDiffs, SizeA: size(10), SizeB: size(20)},
+				{Name: "extra", Status: model.DifferenceOnlyInB, SizeB: size(5)},
+				{Name: "nested/missing", Status: model.DifferenceOnlyInA, SizeA: size(10)},
+			},
+			false,
+		},
+		{
+			"Whole buckets truncated",
+			"source", "/", "replica", "/", 1,
+			4, 4, 1, 3, 3, 0,
+			[]*model.ObjectDifference{
+				{Name: "backup/changed", Status: model.DifferenceOnlyInB, SizeB: size(20)},
+			},
+			true,
+		},
+		{
+			"Identical prefixes",
+			"source", "data/", "source", "data/", 10,
+			3, 3, 3, 0, 0, 0,
+			[]*model.ObjectDifference{},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := comparisonRepo.CompareBuckets(ctx, tc.bucketA, tc.prefixA, tc.bucketB, tc.prefixB, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.CountA != tc.wantCountA || got.CountB != tc.wantCountB || got.Matching != tc.wantMatching {
+				t.Errorf("Counts mismatch: got %d, %d and %d matching, want %d, %d and %d matching",
+					got.CountA, got.CountB, got.Matching, tc.wantCountA, tc.wantCountB, tc.wantMatching)
+			}
+			if got.OnlyInA != tc.wantOnlyA || got.OnlyInB != tc.wantOnlyB || got.SizeDiffers != tc.wantSize {
+				t.Errorf("Differences counts mismatch: got %d, %d and %d, want %d, %d and %d",
+					got.OnlyInA, got.OnlyInB, got.SizeDiffers, tc.wantOnlyA, tc.wantOnlyB, tc.wantSize)
+			}
+			if got.Truncated != tc.wantTruncated {
+				t.Errorf("Truncated mismatch: got %v, want %v", got.Truncated, tc.wantTruncated)
+			}
+
+			if len(got.Differences) != len(tc.wantDifferences) {
+				t.Fatalf("Differences mismatch: got %d, want %d", len(got.Differences), len(tc.wantDifferences))
+			}
+			for i, want := range tc.wantDifferences {
+				diff := got.Differences[i]
+				if diff.Name != want.Name || diff.Status != want.Status || !equalSize(diff.SizeA, want.SizeA) || !equalSize(diff.SizeB, want.SizeB) {
+					t.Errorf("Difference %d mismatch: got %+v, want %+v", i, diff, want)
+				}
+			}
+		})
+	}
+}
+
+func equalSize(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// defaultCursorTimeout is how long an idle cursor keeps its read transaction open unless configured otherwise
+	// Open read transactions hold back WAL checkpoints, so cursors must not live long
+	defaultCursorTimeout = 10 * time.Second
+
+	// maxOpenCursors bounds the connections held by cursors, the oldest cursor is evicted beyond it
+	maxOpenCursors = 1
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// pagePosition is the sort key of the last row of a page, the next page starting after it
+type pagePosition struct {
+	Value  int64  `json:"v"`
+	Name   string `json:"n"`
+	Marker bool   `json:"m,omitempty"`
+}
+
+// pageState resumes a listing after the position of its last page
+// Cursor names the snapshot the listing is read from while it stays open in this process
+type pageState struct {
+	Listing string       `json:"l"`
+	After   pagePosition `json:"a"`
+	Cursor  string       `json:"c,omitempty"`
+}
+
+// listingHash identifies the listing of key in page tokens, so they are refused by other listings
+func listingHash(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (t pageState) encode() (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodePageToken decodes the token of the listing of key
+func decodePageToken(token string, key string) (pageState, error) {
+	var t pageState
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(b, &t) != nil || t.Listing != listingHash(key) {
+		return pageState{}, ErrInvalidPageToken
+	}
+	return t, nil
+}
+
+// SetCursorTimeout keeps the snapshot of a paginated listing open for timeout after each of its pages, 0 to page
+// listings after the last row of their previous page only, releasing their connection between pages
+func (db *Database) SetCursorTimeout(timeout time.Duration) {
+	db.cursorTimeout = timeout
+}
+
+// cursor is a read transaction pinning the pages of a listing to its snapshot
+type cursor struct {
+	id      string
+	tx      *sqlx.Tx
+	created time.Time
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// cursorStore tracks open cursors and rolls back their transactions once they expire
+type cursorStore struct {
+	mu      sync.Mutex
+	cursors map[string]*cursor
+}
+
+func newCursorStore() *cursorStore {
+	return &cursorStore{cursors: make(map[string]*cursor)}
+}
+
+// open registers a new cursor over the transaction of c, assigning its id, rolled back once idle for timeout
+func (s *cursorStore) open(c *cursor, timeout time.Duration) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	c.id = hex.EncodeToString(b)
+	c.created = time.Now()
+	c.timeout = timeout
+	c.timer = time.AfterFunc(timeout, func() { s.close(c.id) })
+	s.put(c)
+	return nil
+}
+
+// take removes a cursor from the store so a single request can use it exclusively, nil if it expired or
+// was evicted
+func (s *cursorStore) take(id string) *cursor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cursors[id]
+	if !ok {
+		return nil
+	}
+	c.timer.Stop()
+	delete(s.cursors, id)
+	return c
+}
+
+// put adds a cursor to the store with a refreshed timeout, evicting the oldest cursor if the store is full
+func (s *cursorStore) put(c *cursor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.cursors) >= maxOpenCursors {
+		var oldest *cursor
+		for _, open := range s.cursors {
+			if oldest == nil || open.created.Before(oldest.created) {
+				oldest = open
+			}
+		}
+		s.release(oldest)
+	}
+
+	c.timer.Reset(c.timeout)
+	s.cursors[c.id] = c
+}
+
+// close rolls back and removes a cursor
+func (s *cursorStore) close(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.cursors[id]; ok {
+		s.release(c)
+	}
+}
+
+func (s *cursorStore) release(c *cursor) {
+	c.timer.Stop()
+	c.tx.Rollback()
+	delete(s.cursors, c.id)
+}
@@ -2,10 +2,13 @@ package repo
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const DATABASE_TYPE = "sqlite3"
@@ -25,13 +28,32 @@ const schema = `
 		updated 	TIMESTAMP NOT NULL,
 		created		TIMESTAMP NOT NULL,
 		storage_class TEXT NOT NULL CHECK (storage_class IN ('STANDARD', 'NEARLINE', 'COLDLINE', 'ARCHIVE')),
+		parent		TEXT GENERATED ALWAYS AS (` + metadataParentExpr + `) VIRTUAL,
+		detected_type TEXT, -- content type sniffed from the first bytes of sampled objects
+		custom_time	TIMESTAMP, -- set by uploaders, lifecycle rules may age objects by it instead of their creation
+		marker		BOOLEAN GENERATED ALWAYS AS (` + markerExpr + `) VIRTUAL,
+		generation	INTEGER NOT NULL DEFAULT 0, -- 0 if unknown, conflicts with it being resolved by update time
+		metageneration	INTEGER NOT NULL DEFAULT 0,
+		event_time	TIMESTAMP, -- of the notification last indexing the object
 		PRIMARY KEY (bucket, name)
 	);
+
+	CREATE INDEX metadata_parent ON metadata (parent);
+
+	-- Listings filtered by size or update time scan the matching range of the directory only
+	CREATE INDEX metadata_parent_size ON metadata (parent, size);
+	CREATE INDEX metadata_parent_updated ON metadata (parent, updated);
+
+	-- Searches narrow names down to their path with it, unless comparing them as Unicode
+	CREATE INDEX metadata_name_nocase ON metadata (name COLLATE NOCASE);
 	
 	CREATE TABLE directory (
 		bucket			TEXT NOT NULL,
 		name			TEXT NOT NULL,
-		count			INTEGER DEFAULT 0,
+		count			INTEGER DEFAULT 0, -- objects, directory markers excluded
+		markers			INTEGER DEFAULT 0, -- directory marker objects
+		noncurrent_size		INTEGER DEFAULT 0, -- bytes of noncurrent generations, billed on top of the live sizes
+		noncurrent_count	INTEGER DEFAULT 0,
 		size_standard 	INTEGER DEFAULT 0,
 		size_nearline 	INTEGER DEFAULT 0,
 		size_coldline	INTEGER DEFAULT 0,
@@ -40,18 +62,242 @@ const schema = `
 		FOREIGN KEY (parent) REFERENCES directory(name),
 		PRIMARY KEY (bucket, name)
 	);
+
+	CREATE INDEX directory_parent ON directory (parent);
+` + bucketSchema + aclSchema + writeStatsSchema + historySchema + seedCheckpointSchema + topDirectorySchema + noncurrentSchema + usageSchema + auditSchema + reservationSchema + popularitySchema + lagSchema + sampleSchema + eventStatsSchema + journalSchema + `
+`
+
+// seedCheckpointSchema is part of the schema, and added to databases created before checkpoints
+const seedCheckpointSchema = `
+	CREATE TABLE seed_checkpoint (
+		bucket			TEXT NOT NULL,
+		prefix			TEXT NOT NULL, -- partition listed on its own, empty for the whole bucket
+		last_object		TEXT NOT NULL,
+		last_generation	INTEGER NOT NULL DEFAULT 0,
+		objects			INTEGER DEFAULT 0,
+		completed		BOOLEAN DEFAULT FALSE,
+		updated			TIMESTAMP NOT NULL,
+		PRIMARY KEY (bucket, prefix)
+	);
 `
 
+// metadataParentExpr computes the directory containing an object, matching getParentDir:
+// trimming every character but '/' from the right of the name leaves the name up to its last '/'
+const metadataParentExpr = `CASE WHEN instr(name, '/') = 0 THEN '/' ELSE rtrim(name, replace(name, '/', '')) END`
+
+// markerExpr flags directory marker objects, the placeholders tools such as the console, gsutil
+// and HDFS connectors create for empty directories, matching isDirectoryMarker
+const markerExpr = `substr(name, -1) = '/'`
+
+// dirParentExpr computes the parent of a directory name of column col, matching getParentDir
+func dirParentExpr(col string) string {
+	trimmed := fmt.Sprintf("substr(%[1]s, 1, length(%[1]s) - 1)", col)
+	return fmt.Sprintf("CASE WHEN instr(%[1]s, '/') = 0 THEN '/' ELSE rtrim(%[1]s, replace(%[1]s, '/', '')) END", trimmed)
+}
+
+// migrations bring databases created by earlier versions up to the current schema
+// Each one applies if its check query returns false, and must be safe to run on a live database
+var migrations = []struct {
+	name  string
+	check string
+	apply string
+}{
+	{
+		// Listing directory contents looked children up with LIKE scans,
+		// indexing the virtual column computes it for every existing object
+		name:  "metadata and directory parent indexes",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'metadata_parent');`,
+		apply: `
+			ALTER TABLE metadata ADD COLUMN parent TEXT GENERATED ALWAYS AS (` + metadataParentExpr + `) VIRTUAL;
+			CREATE INDEX metadata_parent ON metadata (parent);
+			CREATE INDEX IF NOT EXISTS directory_parent ON directory (parent);
+		`,
+	},
+	{
+		name:  "seed checkpoints",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'seed_checkpoint');`,
+		apply: seedCheckpointSchema,
+	},
+	{
+		name:  "buckets",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'bucket');`,
+		apply: bucketSchema,
+	},
+	{
+		name:  "write statistics",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'write_stats');`,
+		apply: writeStatsSchema,
+	},
+	{
+		name:  "object ACLs",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'object_acl');`,
+		apply: aclSchema,
+	},
+	{
+		name:  "directory history",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'directory_history');`,
+		apply: historySchema,
+	},
+	{
+		name:  "bucket configs",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('bucket') WHERE name = 'config');`,
+		apply: `ALTER TABLE bucket ADD COLUMN config TEXT NOT NULL DEFAULT '{}';`,
+	},
+	{
+		name:  "detected content types",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('metadata') WHERE name = 'detected_type');`,
+		apply: `ALTER TABLE metadata ADD COLUMN detected_type TEXT;`,
+	},
+	{
+		// Markers were counted as objects by every directory above the one they stand for,
+		// their counts move to markers, which include that directory as well
+		name:  "directory markers",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('directory') WHERE name = 'markers');`,
+		apply: `
+			ALTER TABLE metadata ADD COLUMN marker BOOLEAN GENERATED ALWAYS AS (` + markerExpr + `) VIRTUAL;
+			ALTER TABLE directory ADD COLUMN markers INTEGER DEFAULT 0;
+
+			WITH RECURSIVE ancestors(bucket, name) AS (
+				SELECT bucket, name FROM metadata WHERE ` + markerExpr + `
+				UNION ALL
+				SELECT bucket, ` + dirParentExpr("name") + ` FROM ancestors WHERE name != '/'
+			)
+			INSERT INTO directory (bucket, name, markers, parent)
+			SELECT bucket, name, COUNT(*), ` + dirParentExpr("name") + ` FROM ancestors WHERE TRUE GROUP BY bucket, name
+			ON CONFLICT(bucket, name) DO UPDATE
+			SET markers = excluded.markers,
+				count = count - excluded.markers +
+					EXISTS(SELECT 1 FROM metadata WHERE metadata.bucket = directory.bucket AND metadata.name = directory.name);
+		`,
+	},
+	{
+		name:  "case-insensitive name index",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'metadata_name_nocase');`,
+		apply: `CREATE INDEX metadata_name_nocase ON metadata (name COLLATE NOCASE);`,
+	},
+	{
+		name:  "top directories",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'top_directory');`,
+		apply: topDirectorySchema + rankingRebuild("TRUE"),
+	},
+	{
+		name:  "noncurrent generations",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'noncurrent');`,
+		apply: noncurrentSchema + `
+			ALTER TABLE directory ADD COLUMN noncurrent_size INTEGER DEFAULT 0;
+			ALTER TABLE directory ADD COLUMN noncurrent_count INTEGER DEFAULT 0;
+		`,
+	},
+	{
+		name:  "listing filter indexes",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'metadata_parent_updated');`,
+		apply: `
+			CREATE INDEX IF NOT EXISTS metadata_parent_size ON metadata (parent, size);
+			CREATE INDEX metadata_parent_updated ON metadata (parent, updated);
+		`,
+	},
+	{
+		name:  "custom times",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('metadata') WHERE name = 'custom_time');`,
+		apply: `ALTER TABLE metadata ADD COLUMN custom_time TIMESTAMP;`,
+	},
+	{
+		name:  "consumer usage",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'consumer_usage');`,
+		apply: usageSchema,
+	},
+	{
+		name:  "audit log",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'audit_log');`,
+		apply: auditSchema,
+	},
+	{
+		name:  "reservations",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'reservation');`,
+		apply: reservationSchema,
+	},
+	{
+		name:  "object popularity",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'access_log');`,
+		apply: popularitySchema,
+	},
+	{
+		name:  "notification lag",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'notification_lag');`,
+		apply: lagSchema,
+	},
+	{
+		name:  "object samples",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'object_sample');`,
+		apply: sampleSchema + sampleBackfill,
+	},
+	{
+		name:  "event statistics",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'event_stats');`,
+		apply: eventStatsSchema,
+	},
+	{
+		name:  "checkpoint generations",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('seed_checkpoint') WHERE name = 'last_generation');`,
+		apply: `ALTER TABLE seed_checkpoint ADD COLUMN last_generation INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		name:  "object generations",
+		check: `SELECT EXISTS(SELECT 1 FROM pragma_table_info('metadata') WHERE name = 'generation');`,
+		apply: `
+			ALTER TABLE metadata ADD COLUMN generation INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE metadata ADD COLUMN metageneration INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE metadata ADD COLUMN event_time TIMESTAMP;
+		`,
+	},
+	{
+		name:  "ingest journal",
+		check: `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'ingest_journal');`,
+		apply: journalSchema,
+	},
+}
+
+// SchemaVersion is the version of the schema this binary creates and migrates databases to, the number of
+// migrations it knows, recorded as the user_version of databases
+var SchemaVersion = len(migrations)
+
+// SchemaPolicy decides how databases of an earlier schema version are opened
+type SchemaPolicy string
+
+const (
+	// SchemaMigrate applies the migrations the database is missing
+	SchemaMigrate SchemaPolicy = "migrate"
+	// SchemaRefuse fails with ErrSchemaOutdated, leaving migrations to an operator
+	SchemaRefuse SchemaPolicy = "refuse"
+)
+
+// defaultOperationTimeout bounds every repository operation unless configured otherwise
+const defaultOperationTimeout = 30 * time.Second
+
 type Database struct {
 	*sqlx.DB
 	url                string
 	maxOpenConnections int
+	operationTimeout   time.Duration
+	writeQueue         *WriteQueue
+	// cursorTimeout is how long the snapshots of paginated listings stay open between pages, 0 to disable them
+	cursorTimeout time.Duration
+	// missing remembers paths found missing, nil unless enabled by SetMissingPathTTL
+	missing *missingPaths
+	// faults are injected into writes, nil unless set by SetFaults
+	faults *faults
+	// load tracks the writes in progress, reported by WriteLoad
+	load  writeLoad
+	clock clock.Clock
 }
 
 func NewDatabase(url string, maxOpenConnections int) *Database {
 	db := &Database{
 		url:                url,
 		maxOpenConnections: maxOpenConnections,
+		operationTimeout:   defaultOperationTimeout,
+		cursorTimeout:      defaultCursorTimeout,
+		clock:              clock.Real,
 	}
 
 	return db
@@ -64,7 +310,7 @@ func (db *Database) Connect(ctx context.Context) error {
 	defer cancel()
 
 	var err error
-	db.DB, err = sqlx.ConnectContext(dbCtx, DATABASE_TYPE, db.url)
+	db.DB, err = sqlx.ConnectContext(dbCtx, queryLogDriver, db.url)
 	if err != nil {
 		return err
 	}
@@ -74,6 +320,30 @@ func (db *Database) Connect(ctx context.Context) error {
 	return nil
 }
 
+// SetOperationTimeout sets how long a single repository operation may run before it is cancelled
+// A timeout of 0 disables it, leaving operations bound only by their caller's context
+func (db *Database) SetOperationTimeout(timeout time.Duration) {
+	db.operationTimeout = timeout
+}
+
+// SetClock makes repositories on db tell the time with c, which windows write statistics and history
+// and expires missing paths and consumer usage
+// It must be called before the database is used
+func (db *Database) SetClock(c clock.Clock) {
+	db.clock = c
+	if db.missing != nil {
+		db.missing.clock = c
+	}
+}
+
+// withTimeout derives the context of a single repository operation from ctx
+func (db *Database) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.operationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.operationTimeout)
+}
+
 // Setup executes all PRAGMA configs to setup database behavior
 func (db *Database) Setup() error {
 	if _, err := db.Exec(pragma); err != nil {
@@ -84,9 +354,135 @@ func (db *Database) Setup() error {
 
 // CreateTables creates and executes database schema defined
 func (db *Database) CreateTables() error {
-	if _, err := db.Exec(schema); err != nil {
+	if _, err := db.Exec(schema + fmt.Sprintf("PRAGMA user_version = %d;", SchemaVersion)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Migrate applies the migrations a database created by an earlier version is missing
+func (db *Database) Migrate(ctx context.Context) error {
+	for _, m := range migrations {
+		var applied bool
+		if err := db.QueryRowContext(ctx, m.check).Scan(&applied); err != nil {
+			return fmt.Errorf("checking migration %q: %w", m.name, err)
+		}
+		if applied {
+			continue
+		}
+
+		log.Printf("Migrating database: %s", m.name)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.apply); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %q: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	// Databases migrated by a later version keep their version
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
 		return err
 	}
+	if version < SchemaVersion {
+		return db.setSchemaVersion(ctx, SchemaVersion)
+	}
+	return nil
+}
+
+// CheckSchema makes sure the database has the schema of this binary before it is used, migrating it
+// if policy allows, so upgrades fail at startup rather than with SQL errors once the database is used
+// It returns ErrSchemaTooNew for databases of a later version, and ErrSchemaOutdated for earlier ones
+// when policy refuses to migrate them
+func (db *Database) CheckSchema(ctx context.Context, policy SchemaPolicy) error {
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: database is at version %d, this binary supports up to version %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+
+	if policy == SchemaMigrate {
+		return db.Migrate(ctx)
+	}
+
+	pending, err := db.pendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: database is at version %d, missing migrations %s", ErrSchemaOutdated, version, strings.Join(pending, ", "))
+	}
+
+	// Databases created before versions were recorded may be up to date
+	return db.setSchemaVersion(ctx, SchemaVersion)
+}
+
+// SchemaStatus returns the schema version recorded in the database and the migrations it is missing, without
+// applying them, for diagnostics
+func (db *Database) SchemaStatus(ctx context.Context) (int, []string, error) {
+	version, err := db.schemaVersion(ctx)
+	if err != nil || version >= SchemaVersion {
+		return version, nil, err
+	}
+	pending, err := db.pendingMigrations(ctx)
+	return version, pending, err
+}
+
+// CheckWritable returns an error unless the database can be written, taking the write lock without writing anything
+func (db *Database) CheckWritable(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	// Deleting nothing still starts a write transaction, which read-only databases refuse
+	_, err = tx.ExecContext(ctx, `DELETE FROM metadata WHERE 0;`)
+	return translateError(err)
+}
+
+// pendingMigrations names the migrations not applied to the database yet, in order
+func (db *Database) pendingMigrations(ctx context.Context) ([]string, error) {
+	var pending []string
+	for _, m := range migrations {
+		var applied bool
+		if err := db.QueryRowContext(ctx, m.check).Scan(&applied); err != nil {
+			return nil, fmt.Errorf("checking migration %q: %w", m.name, err)
+		}
+		if !applied {
+			pending = append(pending, fmt.Sprintf("%q", m.name))
+		}
+	}
+	return pending, nil
+}
+
+// schemaVersion reads the schema version recorded in the database, 0 if created before versions were recorded
+func (db *Database) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (db *Database) setSchemaVersion(ctx context.Context, version int) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", version)); err != nil {
+		return fmt.Errorf("recording schema version: %w", err)
+	}
 	return nil
 }
 
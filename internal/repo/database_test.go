@@ -0,0 +1,417 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestMigrate(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revert to the schema without parent lookups nor bucket configs
+	if _, err := db.Exec(`
+		DROP INDEX metadata_parent;
+		DROP INDEX metadata_parent_size;
+		DROP INDEX metadata_parent_updated;
+		DROP INDEX directory_parent;
+		ALTER TABLE metadata DROP COLUMN parent;
+		ALTER TABLE bucket DROP COLUMN config;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO metadata (bucket, name, size, storage_class, created, updated)
+		VALUES ('mock', 'a/b/file', 1, 'STANDARD', ?, ?), ('mock', 'file', 1, 'STANDARD', ?, ?);
+	`, time.Now(), time.Now(), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating twice applies every migration once
+	for range 2 {
+		if err := db.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var parents []string
+	if err := db.Select(&parents, `SELECT parent FROM metadata ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(parents, ",") != "a/b/,/" {
+		t.Errorf("Parent mismatch: got %v, want [a/b/ /]", parents)
+	}
+
+	exploreRepo := NewExploreRepository(db)
+	ctx, queryLog := WithQueryLog(context.Background())
+	contents, err := exploreRepo.GetPathContents(ctx, "a/b/", SortBySize, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(contents) != 1 || contents[0].Name != "a/b/file" {
+		t.Errorf("Contents mismatch: got %v", contents)
+	}
+
+	// Children are found through the parent indexes instead of scanning
+	q := queryLog.Queries()[0]
+	plan, err := db.ExplainQueryPlan(context.Background(), q.SQL, q.Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range plan {
+		if strings.Contains(step, "SCAN metadata") || strings.Contains(step, "SCAN directory") {
+			t.Errorf("Expected indexed lookups, got plan %v", plan)
+		}
+	}
+
+	bucketRepo := NewBucketRepository(db)
+	if err := bucketRepo.Upsert(context.Background(), model.Bucket{Name: "mock", Location: "US", LocationType: "multi-region"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg, err := bucketRepo.GetConfig(context.Background(), "mock"); err != nil || cfg.Workers != 0 {
+		t.Errorf("Config of migrated bucket mismatch: got %+v, %v", cfg, err)
+	}
+
+	t.Run("Baseline schema", func(t *testing.T) {
+		fresh := NewDatabase(":memory:", 1)
+		fresh.Connect(context.Background())
+		defer fresh.Close()
+
+		if err := fresh.CreateTables(); err != nil {
+			t.Fatal(err)
+		}
+
+		baseline := NewDatabase(":memory:", 1)
+		baseline.Connect(context.Background())
+		defer baseline.Close()
+
+		if _, err := baseline.Exec(baselineSchema); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := baseline.Exec(`
+			INSERT INTO metadata (bucket, name, size, storage_class, created, updated)
+			VALUES ('mock', 'a/b/file', 1, 'STANDARD', ?, ?);
+		`, time.Now(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := baseline.CheckSchema(context.Background(), SchemaMigrate); err != nil {
+			t.Fatal(err)
+		}
+
+		// Migrated databases end up with the tables, columns and indexes of the databases created afresh
+		want, got := schemaObjects(t, fresh), schemaObjects(t, baseline)
+		for object := range want {
+			if !got[object] {
+				t.Errorf("Migrated schema is missing %s", object)
+			}
+		}
+		for object := range got {
+			if !want[object] {
+				t.Errorf("Migrated schema has unexpected %s", object)
+			}
+		}
+
+		version, pending, err := baseline.SchemaStatus(context.Background())
+		if err != nil || version != SchemaVersion || len(pending) > 0 {
+			t.Errorf("Schema status mismatch: got version %d missing %v, %v", version, pending, err)
+		}
+	})
+}
+
+// baselineSchema is the schema of the first release, which every later schema migrates from
+const baselineSchema = `
+	CREATE TABLE metadata (
+		bucket 		TEXT NOT NULL,
+		name 		TEXT NOT NULL,
+		size		INTEGER NOT NULL,
+		updated 	TIMESTAMP NOT NULL,
+		created		TIMESTAMP NOT NULL,
+		storage_class TEXT NOT NULL CHECK (storage_class IN ('STANDARD', 'NEARLINE', 'COLDLINE', 'ARCHIVE')),
+		PRIMARY KEY (bucket, name)
+	);
+	
+	CREATE TABLE directory (
+		bucket			TEXT NOT NULL,
+		name			TEXT NOT NULL,
+		count			INTEGER DEFAULT 0,
+		size_standard 	INTEGER DEFAULT 0,
+		size_nearline 	INTEGER DEFAULT 0,
+		size_coldline	INTEGER DEFAULT 0,
+		size_archive 	INTEGER DEFAULT 0,
+		parent			TEXT,
+		FOREIGN KEY (parent) REFERENCES directory(name),
+		PRIMARY KEY (bucket, name)
+	);
+`
+
+// schemaObjects lists the tables, indexes and columns with their types of the schema of db
+func schemaObjects(t *testing.T, db *Database) map[string]bool {
+	t.Helper()
+
+	var objects []struct {
+		Type string `db:"type"`
+		Name string `db:"name"`
+	}
+	if err := db.Select(&objects, `SELECT type, name FROM sqlite_master WHERE name NOT LIKE 'sqlite_%';`); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := map[string]bool{}
+	for _, object := range objects {
+		schema[object.Type+" "+object.Name] = true
+		if object.Type != "table" {
+			continue
+		}
+
+		var columns []struct {
+			Name string `db:"name"`
+			Type string `db:"type"`
+		}
+		if err := db.Select(&columns, `SELECT name, type FROM pragma_table_xinfo(?);`, object.Name); err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range columns {
+			schema["column "+object.Name+"."+column.Name+" "+column.Type] = true
+		}
+	}
+	return schema
+}
+
+func TestMetadataParent(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataRepo := NewMetadataRepository(db)
+
+	// The computed parent matches the directory the object is counted in
+	names := []string{"file", "a/file", "a/b/file.txt", "a//file", "a/", "a/b/"}
+	for _, name := range names {
+		obj := &model.Metadata{Bucket: "mock", Name: name, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+		if err := metadataRepo.Insert(context.Background(), obj); err != nil {
+			t.Fatal(err)
+		}
+
+		var parent string
+		if err := db.Get(&parent, `SELECT parent FROM metadata WHERE bucket = 'mock' AND name = ?;`, name); err != nil {
+			t.Fatal(err)
+		}
+
+		want := getParentDir(name)
+		if strings.HasSuffix(name, "/") {
+			want = name // placeholder objects are listed in the directory they mark
+		}
+
+		if parent != want {
+			t.Errorf("Parent mismatch for %q: got %q, want %q", name, parent, want)
+		}
+	}
+}
+
+func TestMigrateDirectoryMarkers(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revert to the schema counting markers as objects of the directories above them
+	if _, err := db.Exec(`
+		ALTER TABLE metadata DROP COLUMN marker;
+		ALTER TABLE directory DROP COLUMN markers;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO metadata (bucket, name, size, storage_class, created, updated)
+		VALUES ('mock', 'a/file', 1, 'STANDARD', ?, ?), ('mock', 'a/b/', 0, 'STANDARD', ?, ?), ('mock', 'c/', 0, 'STANDARD', ?, ?);
+		INSERT INTO directory (bucket, name, count, size_standard, parent)
+		VALUES ('mock', '/', 3, 1, '/'), ('mock', 'a/', 2, 1, '/');
+	`, time.Now(), time.Now(), time.Now(), time.Now(), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := db.Select(&got, `SELECT name || ':' || count || ':' || markers FROM directory ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories match those counted by UpsertParentDirs since markers are counted apart
+	want := []string{"/:1:2", "a/:1:1", "a/b/:0:1", "c/:0:1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Directories mismatch: got %v, want %v", got, want)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	db := NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertVersion := func(want int) {
+		t.Helper()
+		if version, err := db.schemaVersion(ctx); err != nil || version != want {
+			t.Errorf("Schema version mismatch: got %d, %v, want %d", version, err, want)
+		}
+	}
+
+	// Created databases are at the current version
+	assertVersion(SchemaVersion)
+	if err := db.CheckSchema(ctx, SchemaRefuse); err != nil {
+		t.Errorf("Expected a created database to be compatible, got %v", err)
+	}
+
+	// Databases migrated by a later version are refused whatever the policy
+	if err := db.setSchemaVersion(ctx, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []SchemaPolicy{SchemaMigrate, SchemaRefuse} {
+		if err := db.CheckSchema(ctx, policy); !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("Expected ErrSchemaTooNew with policy %s, got %v", policy, err)
+		}
+	}
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(SchemaVersion + 1)
+
+	// Up to date databases created before versions were recorded are stamped
+	if err := db.setSchemaVersion(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckSchema(ctx, SchemaRefuse); err != nil {
+		t.Errorf("Expected an up to date unversioned database to be compatible, got %v", err)
+	}
+	assertVersion(SchemaVersion)
+
+	// Databases missing migrations are only migrated if the policy allows it
+	if _, err := db.Exec(`DROP TABLE reservation; PRAGMA user_version = 0;`); err != nil {
+		t.Fatal(err)
+	}
+	err := db.CheckSchema(ctx, SchemaRefuse)
+	if !errors.Is(err, ErrSchemaOutdated) || !strings.Contains(err.Error(), `"reservations"`) {
+		t.Errorf("Expected ErrSchemaOutdated naming the reservations migration, got %v", err)
+	}
+	assertVersion(0)
+
+	if err := db.CheckSchema(ctx, SchemaMigrate); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(SchemaVersion)
+	if err := NewReservationRepository(db).Release(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the reservation table to be migrated, got %v", err)
+	}
+}
+
+func TestSchemaStatus(t *testing.T) {
+	ctx := context.Background()
+	db := NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	if version, pending, err := db.SchemaStatus(ctx); err != nil || version != SchemaVersion || len(pending) > 0 {
+		t.Errorf("Expected a created database to be up to date, got version %d missing %v, %v", version, pending, err)
+	}
+
+	// Missing migrations are named without being applied
+	if _, err := db.Exec(`DROP TABLE reservation; PRAGMA user_version = 0;`); err != nil {
+		t.Fatal(err)
+	}
+	version, pending, err := db.SchemaStatus(ctx)
+	if err != nil || version != 0 || !slices.Equal(pending, []string{`"reservations"`}) {
+		t.Errorf("Expected the reservations migration to be missing, got version %d missing %v, %v", version, pending, err)
+	}
+	if version, _, _ := db.SchemaStatus(ctx); version != 0 {
+		t.Errorf("Expected the schema version to be left alone, got %d", version)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/metadata.db"
+
+	db := NewDatabase(path, 1)
+	if err := db.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CheckWritable(ctx); err != nil {
+		t.Errorf("Expected the database to be writable, got %v", err)
+	}
+
+	readOnly := NewDatabase("file:"+path+"?mode=ro", 1)
+	if err := readOnly.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+
+	if err := readOnly.CheckWritable(ctx); err == nil {
+		t.Error("Expected a read-only database not to be writable")
+	}
+}
@@ -1,25 +1,37 @@
 package repo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
 )
 
 type Directory struct {
 	*Database
+
+	mu         sync.Mutex
+	lastPruned time.Time // last write_stats window pruned
 }
 
 type DirectoryRepository interface {
-	Insert(dir model.Directory) error
-	Delete(bucket string, name string) error
-	UpsertParentDirs(storageClass StorageClass, bucket string, objName string, newSize int64, newCount int64) error
+	Insert(ctx context.Context, dir model.Directory) error
+	Delete(ctx context.Context, bucket string, name string) error
+	UpsertParentDirs(ctx context.Context, storageClass StorageClass, bucket string, objName string, newSize int64, newCount int64) error
+	UpsertNoncurrentDirs(ctx context.Context, bucket string, objName string, size int64, count int64) error
+	InsertObject(ctx context.Context, obj *model.Metadata) error
+	InsertNoncurrent(ctx context.Context, obj *model.NoncurrentObject) error
+	AggregateObject(ctx context.Context, obj *model.Metadata, checkpoint *model.SeedCheckpoint) error
+	AggregateNoncurrent(ctx context.Context, obj *model.NoncurrentObject, checkpoint *model.SeedCheckpoint) error
 }
 
 func NewDirectoryRepository(db *Database) DirectoryRepository {
-	return &Directory{db}
+	return &Directory{Database: db}
 }
 
 // getParentDir returns the parent directory of dir
@@ -40,50 +52,195 @@ func getParentDir(dir string) string {
 	return trimmedDir[:lastIndex+1]
 }
 
+// isDirectoryMarker returns whether an object is a placeholder for an empty directory, matching markerExpr
+func isDirectoryMarker(name string) bool {
+	return strings.HasSuffix(name, "/")
+}
+
 // UpsertParentDirs updates all parent directories of an object name in one transaction
-func (d *Directory) UpsertParentDirs(storageClass StorageClass, bucket string, objName string, newSize int64, newCount int64) error {
-	storageColumn := "size_" + strings.ToLower(string(storageClass))
+// records the change in their history and ranking, and counts the write in the write statistics of its top level prefix
+// Directory markers are counted apart from objects, and left out of the history
+// Sizes are rolled up in the tier the storage class is registered with
+func (d *Directory) UpsertParentDirs(ctx context.Context, storageClass StorageClass, bucket string, objName string, newSize int64, newCount int64) error {
+	if len(bucket) == 0 || len(objName) == 0 {
+		return errors.New("bucket or name argument is empty")
+	}
+
+	// Writes failing with a cancelled context may still commit, so their directories are forgotten either way
+	defer d.invalidateMissing(firstParentDir(objName))
+
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return d.upsertParentDirs(ctx, tx, storageClass, bucket, objName, newSize, newCount)
+	})
+}
+
+// firstParentDir is the first directory whose rollups account for an object
+// A marker stands for the directory named after it, which it is listed in
+func firstParentDir(objName string) string {
+	if isDirectoryMarker(objName) {
+		return objName
+	}
+	return getParentDir(objName)
+}
+
+// upsertParentDirs applies UpsertParentDirs within tx
+func (d *Directory) upsertParentDirs(ctx context.Context, tx *sql.Tx, storageClass StorageClass, bucket string, objName string, newSize int64, newCount int64) error {
+	tier, ok := storageTier(storageClass)
+	if !ok {
+		return fmt.Errorf("unknown storage class %q", storageClass)
+	}
+	storageColumn := "size_" + strings.ToLower(string(tier))
+	countColumn := "count"
+	historyCount := newCount
+	if isDirectoryMarker(objName) {
+		countColumn = "markers"
+		historyCount = 0
+	}
+
 	query := fmt.Sprintf(`
-			INSERT INTO directory (bucket, name, %[1]s, count, parent)
+			INSERT INTO directory (bucket, name, %[1]s, %[2]s, parent)
 			VALUES ($1, $2, $3, $4, $5)
 			ON CONFLICT(bucket, name)
 			DO UPDATE
 			SET %[1]s = %[1]s + $3,
-				count = count + $4;
-	`, storageColumn)
+				%[2]s = %[2]s + $4
+			RETURNING `+directorySizeExpr+`;
+	`, storageColumn, countColumn)
+
+	now := d.clock.Now()
+
+	dirName := firstParentDir(objName)
+	for {
+		var size int64
+		if err := tx.QueryRowContext(ctx, query, bucket, dirName, newSize, newCount, getParentDir(dirName)).Scan(&size); err != nil {
+			return err
+		}
+		if err := rankDirectory(ctx, tx, bucket, dirName, size, newSize); err != nil {
+			return err
+		}
+		if err := recordHistory(ctx, tx, bucket, dirName, newSize, historyCount, now); err != nil {
+			return err
+		}
+
+		// Last directory to update is root
+		if dirName == "/" {
+			break
+		}
+		dirName = getParentDir(dirName)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return recordWrite(ctx, tx, bucket, objName, now, &d.lastPruned)
+}
 
+// UpsertNoncurrentDirs adds the size and count of noncurrent generations of an object name to all its parent directories
+// Noncurrent generations are billed but no longer listed, so they are left out of the history, rankings and write statistics
+func (d *Directory) UpsertNoncurrentDirs(ctx context.Context, bucket string, objName string, size int64, count int64) error {
 	if len(bucket) == 0 || len(objName) == 0 {
 		return errors.New("bucket or name argument is empty")
 	}
 
-	dirName := getParentDir(objName)
+	defer d.invalidateMissing(getParentDir(objName))
 
-	tx, err := d.DB.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback() // no-op if commit succeeds
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return upsertNoncurrentDirs(ctx, tx, bucket, objName, size, count)
+	})
+}
 
-	for {
-		if _, err = tx.Exec(query, bucket, dirName, newSize, newCount, getParentDir(dirName)); err != nil {
+// upsertNoncurrentDirs applies UpsertNoncurrentDirs within tx
+func upsertNoncurrentDirs(ctx context.Context, tx *sql.Tx, bucket string, objName string, size int64, count int64) error {
+	query := `
+		INSERT INTO directory (bucket, name, noncurrent_size, noncurrent_count, parent)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(bucket, name)
+		DO UPDATE
+		SET noncurrent_size = noncurrent_size + excluded.noncurrent_size,
+			noncurrent_count = noncurrent_count + excluded.noncurrent_count;
+	`
+
+	for dirName := getParentDir(objName); ; dirName = getParentDir(dirName) {
+		if _, err := tx.ExecContext(ctx, query, bucket, dirName, size, count, getParentDir(dirName)); err != nil {
 			return err
 		}
 
 		// Last directory to update is root
 		if dirName == "/" {
-			break
+			return nil
 		}
-		dirName = getParentDir(dirName)
+	}
+}
+
+// InsertObject indexes an object and adds it to its parent directories in one transaction,
+// returning ErrConflict and leaving the directories unchanged if it already is indexed
+func (d *Directory) InsertObject(ctx context.Context, obj *model.Metadata) error {
+	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
+		return errors.New("bucket or name argument is empty")
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	defer d.invalidateMissing(firstParentDir(obj.Name))
+
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := insertMetadata(ctx, tx, obj); err != nil {
+			return err
+		}
+		return d.upsertParentDirs(ctx, tx, StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1)
+	})
+}
+
+// InsertNoncurrent records a noncurrent generation and adds it to the noncurrent totals of its parent directories
+// in one transaction, returning ErrConflict and leaving the directories unchanged if it already is recorded
+func (d *Directory) InsertNoncurrent(ctx context.Context, obj *model.NoncurrentObject) error {
+	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
+		return errors.New("bucket or name argument is empty")
+	}
+
+	defer d.invalidateMissing(getParentDir(obj.Name))
+
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := insertNoncurrent(ctx, tx, obj); err != nil {
+			return err
+		}
+		return upsertNoncurrentDirs(ctx, tx, obj.Bucket, obj.Name, obj.Size, 1)
+	})
+}
+
+// AggregateObject adds an object to its parent directories without indexing it, saving checkpoint in the same
+// transaction unless nil, so a seeding resumed from the checkpoint does not add it again
+func (d *Directory) AggregateObject(ctx context.Context, obj *model.Metadata, checkpoint *model.SeedCheckpoint) error {
+	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
+		return errors.New("bucket or name argument is empty")
+	}
+
+	defer d.invalidateMissing(firstParentDir(obj.Name))
+
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := d.upsertParentDirs(ctx, tx, StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			return err
+		}
+		return saveCheckpoint(ctx, tx, checkpoint)
+	})
+}
+
+// AggregateNoncurrent adds a noncurrent generation to the noncurrent totals of its parent directories without
+// recording it, saving checkpoint in the same transaction unless nil, as AggregateObject
+func (d *Directory) AggregateNoncurrent(ctx context.Context, obj *model.NoncurrentObject, checkpoint *model.SeedCheckpoint) error {
+	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
+		return errors.New("bucket or name argument is empty")
 	}
-	return nil
+
+	defer d.invalidateMissing(getParentDir(obj.Name))
+
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := upsertNoncurrentDirs(ctx, tx, obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			return err
+		}
+		return saveCheckpoint(ctx, tx, checkpoint)
+	})
 }
 
 // Insert a single directory
-func (d *Directory) Insert(dir model.Directory) error {
+func (d *Directory) Insert(ctx context.Context, dir model.Directory) error {
 	query := `
 		INSERT INTO directory (bucket, name, parent)		
 		VALUES (?, ?, ?)	
@@ -95,36 +252,38 @@ func (d *Directory) Insert(dir model.Directory) error {
 
 	parentDir := getParentDir(dir.Name)
 
-	if _, err := d.DB.Exec(query,
-		dir.Bucket,
-		dir.Name,
-		parentDir); err != nil {
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, dir.Bucket, dir.Name, parentDir)
 		return err
-	}
-	return nil
+	})
 }
 
 // Delete a single directory
-func (d *Directory) Delete(bucket string, name string) error {
+func (d *Directory) Delete(ctx context.Context, bucket string, name string) error {
 	query := `
 		DELETE FROM directory
 		WHERE bucket = ? AND name = ?;	
 	`
 
-	res, err := d.DB.Exec(query, bucket, name)
-
-	if err != nil {
-		return err
-	}
+	return d.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, bucket, name)
+		if err != nil {
+			return err
+		}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-	if rowsAffected == 0 {
-		return errors.New("no rows affected")
-	}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
 
-	return nil
+		floor, err := rankingFloor(ctx, tx, bucket)
+		if err != nil {
+			return err
+		}
+		return unrankDirectory(ctx, tx, bucket, name, floor)
+	})
 }
@@ -2,7 +2,9 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
@@ -104,6 +106,24 @@ func TestUpsertParentDirs(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"Rolls legacy storage class up in its tier",
+			[]*model.Metadata{
+				{Bucket: "mock", Name: "mock-1/file1", Size: 1, StorageClass: "STANDARD"},
+			},
+			&model.Metadata{Bucket: "mock", Name: "mock-1/file2", Size: 2, StorageClass: "REGIONAL"},
+			[]*dir{
+				{Name: "mock-1/", SizeStandard: 3, Count: 2},
+			},
+			false,
+		},
+		{
+			"Fails upserting unknown storage class",
+			[]*model.Metadata{},
+			&model.Metadata{Bucket: "mock", Name: "file1", Size: 1, StorageClass: "UNKNOWN"},
+			[]*dir{},
+			true,
+		},
 		{
 			"Fails upserting empty values",
 			[]*model.Metadata{},
@@ -130,12 +150,12 @@ func TestUpsertParentDirs(t *testing.T) {
 			dirRepo := NewDirectoryRepository(db)
 
 			for _, m := range tc.metadataInDB {
-				if err := dirRepo.UpsertParentDirs(StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
+				if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
 					log.Fatal(err)
 				}
 			}
 
-			if err := dirRepo.UpsertParentDirs(StorageClass(tc.in.StorageClass), tc.in.Bucket, tc.in.Name, tc.in.Size, 1); err != nil {
+			if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(tc.in.StorageClass), tc.in.Bucket, tc.in.Name, tc.in.Size, 1); err != nil {
 				if tc.wantErr {
 					return
 				}
@@ -207,7 +227,7 @@ func TestInsertDirectory(t *testing.T) {
 
 			dirRepo := NewDirectoryRepository(db)
 
-			if err := dirRepo.Insert(tc.dir); err != nil {
+			if err := dirRepo.Insert(context.Background(), tc.dir); err != nil {
 				if tc.wantErr {
 					return
 				}
@@ -232,6 +252,57 @@ func TestInsertDirectory(t *testing.T) {
 	}
 }
 
+func TestInsertObject(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	checkpointRepo := NewCheckpointRepository(db)
+
+	obj := &model.Metadata{Bucket: "mock", Name: "a/b", Size: 10, StorageClass: string(StorageStandard)}
+	if err := dirRepo.InsertObject(ctx, obj); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.InsertObject(ctx, obj); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected inserting the object again to conflict, got %v", err)
+	}
+
+	// Aggregated objects are rolled up along with the checkpoint counting them
+	checkpoint := &model.SeedCheckpoint{Bucket: "mock", LastObject: "a/c", LastGeneration: 2, Objects: 2}
+	if err := dirRepo.AggregateObject(ctx, &model.Metadata{Bucket: "mock", Name: "a/c", Size: 5, StorageClass: string(StorageStandard)}, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.AggregateObject(ctx, &model.Metadata{Bucket: "mock", Name: "a/d", Size: 5, StorageClass: "UNKNOWN"}, &model.SeedCheckpoint{Bucket: "mock", LastObject: "a/d", Objects: 3}); err == nil {
+		t.Fatal("expected an unknown storage class to fail")
+	}
+
+	var count, size int64
+	if err := db.QueryRow(`SELECT count, size_standard FROM directory WHERE bucket = 'mock' AND name = 'a/'`).Scan(&count, &size); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || size != 15 {
+		t.Errorf("Rollup mismatch: got %d objects of %d bytes, want 2 of 15", count, size)
+	}
+
+	got, err := checkpointRepo.Get(ctx, "mock", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastObject != "a/c" || got.LastGeneration != 2 || got.Objects != 2 {
+		t.Errorf("Checkpoint mismatch: got %+v", got)
+	}
+}
+
 func TestDeleteDirectory(t *testing.T) {
 	db := NewDatabase(":memory:", 1)
 	db.Connect(context.Background())
@@ -253,7 +324,7 @@ func TestDeleteDirectory(t *testing.T) {
 	}
 
 	for _, dir := range dirs {
-		if err := dirRepo.Insert(dir); err != nil {
+		if err := dirRepo.Insert(context.Background(), dir); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -272,7 +343,7 @@ func TestDeleteDirectory(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if err := dirRepo.Delete(tc.bucket, tc.dirName); err != nil {
+			if err := dirRepo.Delete(context.Background(), tc.bucket, tc.dirName); err != nil {
 				if tc.wantError {
 					return
 				}
@@ -294,3 +365,39 @@ func TestDeleteDirectory(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkUpsertParentDirs(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		objName string
+	}{
+		{"Root file", "file"},
+		{"Nested file", "mock-1/mock-2/mock-3/file"},
+		{"Deeply nested file", strings.Repeat("mock/", 20) + "file"},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			db := NewDatabase(":memory:", 1)
+			db.Connect(context.Background())
+			defer db.Close()
+
+			if err := db.Setup(); err != nil {
+				b.Fatal(err)
+			}
+
+			if err := db.CreateTables(); err != nil {
+				b.Fatal(err)
+			}
+
+			dirRepo := NewDirectoryRepository(db)
+			b.ResetTimer()
+
+			for range b.N {
+				if err := dirRepo.UpsertParentDirs(context.Background(), StorageStandard, "mock", bm.objName, 1, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+//go:build !purego
+
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// newDriver returns the cgo SQLite driver, the default, adding the SQL functions queries rely on
+// to every new connection
+func newDriver() driver.Driver {
+	return &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		if err := conn.RegisterFunc(foldNameFunc, foldName, true); err != nil {
+			return err
+		}
+		return conn.RegisterFunc(sampledFunc, sampled, true)
+	}}
+}
+
+// sqliteCodes returns the primary and extended result codes of a driver error
+func sqliteCodes(err error) (code int, extendedCode int, ok bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return 0, 0, false
+	}
+	return int(sqliteErr.Code), int(sqliteErr.ExtendedCode), true
+}
+
+// persistConn copies the main database of conn into the database file at path
+func persistConn(ctx context.Context, conn driver.Conn, path string) error {
+	return withFileConn(ctx, path, func(file *sqlite3.SQLiteConn) error {
+		return backup(ctx, file, conn.(*sqlite3.SQLiteConn))
+	})
+}
+
+// restoreConn copies the database file at path into the main database of conn
+func restoreConn(ctx context.Context, conn driver.Conn, path string) error {
+	return withFileConn(ctx, path, func(file *sqlite3.SQLiteConn) error {
+		return backup(ctx, conn.(*sqlite3.SQLiteConn), file)
+	})
+}
+
+// withFileConn runs f with a connection of the database file at path
+func withFileConn(ctx context.Context, path string, f func(file *sqlite3.SQLiteConn) error) error {
+	fileDB, err := sql.Open(queryLogDriver, path)
+	if err != nil {
+		return err
+	}
+	defer fileDB.Close()
+
+	fileConn, err := fileDB.Conn(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+	defer fileConn.Close()
+
+	return fileConn.Raw(func(c any) error {
+		return f(c.(*loggingConn).sqliteConn.(*sqlite3.SQLiteConn))
+	})
+}
+
+// backup copies the main database of src into dst, waiting while either is locked
+func backup(ctx context.Context, dst, src *sqlite3.SQLiteConn) error {
+	b, err := dst.Backup("main", src, "main")
+	if err != nil {
+		return translateError(err)
+	}
+
+	for {
+		done, err := b.Step(-1)
+		if err != nil {
+			b.Close()
+			return translateError(err)
+		}
+		if done {
+			return translateError(b.Close())
+		}
+
+		select {
+		case <-ctx.Done():
+			b.Close()
+			return ctx.Err()
+		case <-time.After(backupRetryDelay):
+		}
+	}
+}
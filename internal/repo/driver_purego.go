@@ -0,0 +1,124 @@
+//go:build purego
+
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// The SQL functions queries rely on are registered once for every connection of the driver
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction(foldNameFunc, 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch s := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return foldName(s), nil
+		case []byte:
+			return foldName(string(s)), nil
+		}
+		return args[0], nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction(sampledFunc, 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		var name string
+		switch s := args[0].(type) {
+		case string:
+			name = s
+		case []byte:
+			name = string(s)
+		default:
+			return int64(0), nil
+		}
+		if sampled(name) {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	})
+}
+
+// newDriver returns the pure Go SQLite driver selected by the purego build tag, which needs no C
+// toolchain so binaries can be built statically and cross-compiled
+func newDriver() driver.Driver {
+	// Functions are only added to the connections of the driver registered by the package
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	return &pureDriver{db.Driver().(*sqlite.Driver)}
+}
+
+// pureDriver stores times in the format of the cgo driver, so databases and snapshots work with both
+type pureDriver struct {
+	*sqlite.Driver
+}
+
+func (d *pureDriver) Open(name string) (driver.Conn, error) {
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	return d.Driver.Open(name + sep + "_time_format=sqlite")
+}
+
+// sqliteCodes returns the primary and extended result codes of a driver error
+func sqliteCodes(err error) (code int, extendedCode int, ok bool) {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return 0, 0, false
+	}
+	// The driver reports the extended code, whose low byte is the primary code
+	return sqliteErr.Code() & 0xff, sqliteErr.Code(), true
+}
+
+// backupConn is the part of the driver connections copying databases
+type backupConn interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// persistConn copies the main database of conn into the database file at path
+func persistConn(ctx context.Context, conn driver.Conn, path string) error {
+	b, err := conn.(backupConn).NewBackup(path)
+	if err != nil {
+		return translateError(err)
+	}
+	return backup(ctx, b)
+}
+
+// restoreConn copies the database file at path into the main database of conn
+func restoreConn(ctx context.Context, conn driver.Conn, path string) error {
+	b, err := conn.(backupConn).NewRestore(path)
+	if err != nil {
+		return translateError(err)
+	}
+	return backup(ctx, b)
+}
+
+// backup runs b to completion, waiting while either database is locked
+func backup(ctx context.Context, b *sqlite.Backup) error {
+	for {
+		more, err := b.Step(-1)
+		if err != nil && !errors.Is(translateError(err), ErrBusy) {
+			b.Finish()
+			return translateError(err)
+		}
+		if err == nil && !more {
+			return translateError(b.Finish())
+		}
+
+		select {
+		case <-ctx.Done():
+			b.Finish()
+			return ctx.Err()
+		case <-time.After(backupRetryDelay):
+		}
+	}
+}
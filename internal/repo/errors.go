@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by repositories, callers should match them with errors.Is
+var (
+	// ErrNotFound is returned when the row to update or delete does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrStale is returned when a write is older than the row it would replace
+	ErrStale = errors.New("stale write")
+	// ErrConflict is returned when a write violates a uniqueness constraint
+	ErrConflict = errors.New("conflict")
+	// ErrBusy is returned when the database is locked by another writer, the operation can be retried
+	ErrBusy = errors.New("database busy")
+	// ErrSchemaTooNew is returned when the database was migrated by a later version of the binary
+	ErrSchemaTooNew = errors.New("database schema is newer than supported")
+	// ErrSchemaOutdated is returned when the database misses migrations it was not allowed to apply
+	ErrSchemaOutdated = errors.New("database schema is outdated")
+)
+
+// SQLite result codes translated, the same whichever driver reports them, see https://www.sqlite.org/rescode.html
+const (
+	sqliteBusy                 = 5
+	sqliteLocked               = 6
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// Retryable reports whether an operation that failed with err may succeed if retried
+func Retryable(err error) bool {
+	return errors.Is(err, ErrBusy)
+}
+
+// translateError wraps driver errors into the repository error they correspond to
+func translateError(err error) error {
+	code, extendedCode, ok := sqliteCodes(err)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case code == sqliteBusy || code == sqliteLocked:
+		return fmt.Errorf("%w: %v", ErrBusy, err)
+	case extendedCode == sqliteConstraintPrimaryKey || extendedCode == sqliteConstraintUnique:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	// eventStatsWindow is the granularity of event statistics
+	eventStatsWindow = time.Hour
+	// EventStatsRetention is how long event statistics are kept, long enough to tell daily patterns apart
+	EventStatsRetention = 7 * 24 * time.Hour
+)
+
+// eventStatsSchema is part of the schema, and added to databases created before events were counted per type
+const eventStatsSchema = `
+	CREATE TABLE event_stats (
+		bucket			TEXT NOT NULL,
+		event_type		TEXT NOT NULL,
+		window_start	INTEGER NOT NULL, -- unix time of the hour
+		events			INTEGER DEFAULT 0,
+		PRIMARY KEY (bucket, event_type, window_start)
+	);
+`
+
+// RecordEvent counts a notification of eventType applied to bucket in the current hour
+// Hours older than EventStatsRetention are pruned once per hour
+func (s *Stats) RecordEvent(ctx context.Context, bucket string, eventType string) error {
+	window := s.clock.Now().Truncate(eventStatsWindow)
+
+	return s.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO event_stats (bucket, event_type, window_start, events)
+			VALUES ($1, $2, $3, 1)
+			ON CONFLICT(bucket, event_type, window_start)
+			DO UPDATE SET events = events + 1;
+		`, bucket, eventType, window.Unix()); err != nil {
+			return err
+		}
+
+		if !window.After(s.eventsPruned) {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM event_stats WHERE window_start < $1;`, window.Add(-EventStatsRetention).Unix()); err != nil {
+			return err
+		}
+		s.eventsPruned = window
+		return nil
+	})
+}
+
+// eventStatRow is the number of notifications of every type applied to a bucket in an hour
+type eventStatRow struct {
+	Bucket         string `db:"bucket"`
+	WindowStart    int64  `db:"window_start"`
+	Finalize       int64  `db:"finalize"`
+	MetadataUpdate int64  `db:"metadata_update"`
+	Archive        int64  `db:"archive"`
+	Delete         int64  `db:"delete"`
+}
+
+// GetEventStats returns the notifications applied per bucket and hour since a given time, by event type,
+// ordered from the earliest hour
+// Every bucket is returned if bucket is empty
+func (s *Stats) GetEventStats(ctx context.Context, since time.Time, bucket string) ([]*model.EventStat, error) {
+	filter, args := "", []any{since.Truncate(eventStatsWindow).Unix()}
+	if len(bucket) > 0 {
+		filter, args = "AND bucket = $2", append(args, bucket)
+	}
+
+	query := `
+		SELECT
+			bucket,
+			window_start,
+			SUM(CASE WHEN event_type = 'OBJECT_FINALIZE' THEN events ELSE 0 END) AS finalize,
+			SUM(CASE WHEN event_type = 'OBJECT_METADATA_UPDATE' THEN events ELSE 0 END) AS metadata_update,
+			SUM(CASE WHEN event_type = 'OBJECT_ARCHIVE' THEN events ELSE 0 END) AS archive,
+			SUM(CASE WHEN event_type = 'OBJECT_DELETE' THEN events ELSE 0 END) AS "delete"
+		FROM event_stats
+		WHERE window_start >= $1 ` + filter + `
+		GROUP BY bucket, window_start
+		ORDER BY window_start, bucket;
+	`
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows := []eventStatRow{}
+	if err := s.reader(ctx).SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, translateError(err)
+	}
+
+	stats := make([]*model.EventStat, len(rows))
+	for i, row := range rows {
+		stats[i] = &model.EventStat{
+			Bucket:         row.Bucket,
+			Hour:           time.Unix(row.WindowStart, 0).UTC(),
+			Finalize:       row.Finalize,
+			MetadataUpdate: row.MetadataUpdate,
+			Archive:        row.Archive,
+			Delete:         row.Delete,
+		}
+	}
+	return stats, nil
+}
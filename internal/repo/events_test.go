@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestEventStats(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 10, 1, 1, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(-2 * EventStatsRetention))
+	db.SetClock(clk)
+
+	ctx := context.Background()
+	statsRepo := NewStatsRepository(db)
+
+	record := func(bucket, eventType string, n int) {
+		for range n {
+			if err := statsRepo.RecordEvent(ctx, bucket, eventType); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Expired by the time the next hours are recorded
+	record("mock", "OBJECT_DELETE", 1)
+
+	clk.Advance(2 * EventStatsRetention)
+	record("mock", "OBJECT_FINALIZE", 3)
+	record("mock", "OBJECT_METADATA_UPDATE", 1)
+	record("other", "OBJECT_ARCHIVE", 2)
+
+	// A nightly delete storm
+	clk.Advance(time.Hour)
+	record("mock", "OBJECT_DELETE", 5)
+
+	var windows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM event_stats WHERE window_start < ?`, start.Add(-EventStatsRetention).Unix()).Scan(&windows); err != nil {
+		t.Fatal(err)
+	}
+	if windows != 0 {
+		t.Errorf("Expected expired hours to be pruned, got %d", windows)
+	}
+
+	hour := start.Truncate(time.Hour)
+	testCases := []struct {
+		name   string
+		since  time.Time
+		bucket string
+		want   []model.EventStat
+	}{
+		{
+			"Every bucket",
+			start.Add(-time.Hour),
+			"",
+			[]model.EventStat{
+				{Bucket: "mock", Hour: hour, Finalize: 3, MetadataUpdate: 1},
+				{Bucket: "other", Hour: hour, Archive: 2},
+				{Bucket: "mock", Hour: hour.Add(time.Hour), Delete: 5},
+			},
+		},
+		{
+			"One bucket",
+			start,
+			"mock",
+			[]model.EventStat{
+				{Bucket: "mock", Hour: hour, Finalize: 3, MetadataUpdate: 1},
+				{Bucket: "mock", Hour: hour.Add(time.Hour), Delete: 5},
+			},
+		},
+		{
+			"Later hours only",
+			start.Add(time.Hour),
+			"",
+			[]model.EventStat{
+				{Bucket: "mock", Hour: hour.Add(time.Hour), Delete: 5},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := statsRepo.GetEventStats(ctx, tc.since, tc.bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Hour count mismatch: got %d, want %d", len(got), len(tc.want))
+			}
+			for i := range tc.want {
+				if *got[i] != tc.want[i] {
+					t.Errorf("Hour %d mismatch: got %+v, want %+v", i, *got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
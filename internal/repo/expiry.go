@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// expireBatch is the maximum number of expired objects read at once
+const expireBatch = 1000
+
+// PrefixTTL is how long objects under a prefix stay indexed after their creation, across buckets
+type PrefixTTL struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+// ParsePrefixTTLs parses TTLs given per prefix, such as tmp/ to 168h, sorted by prefix
+func ParsePrefixTTLs(ttls map[string]string) ([]PrefixTTL, error) {
+	parsed := make([]PrefixTTL, 0, len(ttls))
+	for prefix, value := range ttls {
+		if len(prefix) == 0 || strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid prefix %q, expected a prefix of object names", prefix)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid TTL %q of prefix %s", value, prefix)
+		}
+		parsed = append(parsed, PrefixTTL{Prefix: prefix, TTL: ttl})
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].Prefix < parsed[j].Prefix })
+	return parsed, nil
+}
+
+// Expirer removes objects outliving the TTL of their prefix from the index, adjusting directory rollups as
+// their deletion would, so buckets deleting objects through lifecycle rules stay in sync when delete
+// notifications are lost
+type Expirer struct {
+	db            *Database
+	ttls          []PrefixTTL
+	metadataRepo  MetadataRepository
+	directoryRepo DirectoryRepository
+}
+
+func NewExpirer(db *Database, ttls []PrefixTTL) *Expirer {
+	return &Expirer{
+		db:            db,
+		ttls:          ttls,
+		metadataRepo:  NewMetadataRepository(db),
+		directoryRepo: NewDirectoryRepository(db),
+	}
+}
+
+// Expire removes the objects created longer than their TTL ago under every prefix
+func (e *Expirer) Expire(ctx context.Context) error {
+	for _, ttl := range e.ttls {
+		expired, err := e.expirePrefix(ctx, ttl.Prefix, e.db.clock.Now().Add(-ttl.TTL))
+		if expired > 0 {
+			log.Printf("Expired %d objects under %s", expired, ttl.Prefix)
+		}
+		if err != nil {
+			return fmt.Errorf("error expiring %s: %w", ttl.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// expirePrefix removes the objects under prefix created before cutoff, a batch at a time, returning how many were
+// Batches continue from the last object listed, so objects left in place don't end or repeat the listing
+func (e *Expirer) expirePrefix(ctx context.Context, prefix string, cutoff time.Time) (int, error) {
+	expired := 0
+	after := expiredObject{RowID: math.MinInt64}
+	for {
+		objects, err := e.listExpired(ctx, prefix, cutoff, after)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, obj := range objects {
+			ok, err := e.remove(ctx, &obj.Metadata)
+			if err != nil {
+				return expired, err
+			}
+			if ok {
+				expired++
+			}
+		}
+
+		if len(objects) < expireBatch {
+			return expired, nil
+		}
+		after = objects[len(objects)-1]
+	}
+}
+
+// expiredObject is an object listed for expiry, with the row ID the listing continues from
+type expiredObject struct {
+	RowID int64 `db:"rowid"`
+	model.Metadata
+}
+
+// listExpired returns a batch of objects under prefix created before cutoff, listed after after
+func (e *Expirer) listExpired(ctx context.Context, prefix string, cutoff time.Time, after expiredObject) ([]expiredObject, error) {
+	// The case-insensitive index narrows the scan of the metadata table down to the names starting with prefix,
+	// in the order of its entries, which tie names differing in case by row ID
+	query := `
+		SELECT rowid, bucket, name, size, storage_class, created, updated
+		FROM metadata
+		WHERE name COLLATE NOCASE >= ? AND name COLLATE NOCASE < ? AND (name COLLATE NOCASE > ? OR rowid > ?)
+			AND name >= ? AND name < ? AND created < ?
+		ORDER BY name COLLATE NOCASE, rowid
+		LIMIT ?;
+	`
+	lower := asciiLower(prefix)
+	start := max(lower, asciiLower(after.Name))
+
+	ctx, cancel := e.db.withTimeout(ctx)
+	defer cancel()
+
+	objects := []expiredObject{}
+	if err := e.db.reader(ctx).SelectContext(ctx, &objects, query, start, prefixEnd(lower), asciiLower(after.Name), after.RowID, prefix, prefixEnd(prefix), cutoff.UTC(), expireBatch); err != nil {
+		return nil, translateError(err)
+	}
+	return objects, nil
+}
+
+// remove deletes obj and removes it from the rollups of its parent directories in one transaction, unless it was
+// deleted or overwritten since it was listed
+func (e *Expirer) remove(ctx context.Context, obj *model.Metadata) (bool, error) {
+	removed := false
+	err := e.db.Atomically(ctx, func(ctx context.Context) error {
+		current, err := e.metadataRepo.Get(ctx, obj.Bucket, obj.Name)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !current.Updated.Equal(obj.Updated) {
+			return nil
+		}
+
+		if err := e.metadataRepo.Delete(ctx, current.Bucket, current.Name); err != nil {
+			return err
+		}
+		if err := e.directoryRepo.UpsertParentDirs(ctx, StorageClass(current.StorageClass), current.Bucket, current.Name, -current.Size, -1); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,193 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestParsePrefixTTLs(t *testing.T) {
+	got, err := ParsePrefixTTLs(map[string]string{"tmp/": "168h", "scratch/": "1h30m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PrefixTTL{{"scratch/", 90 * time.Minute}, {"tmp/", 7 * 24 * time.Hour}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TTL mismatch: got %v want %v", got, want)
+	}
+
+	for _, ttls := range []map[string]string{
+		{"tmp/": "a week"},
+		{"tmp/": "-1h"},
+		{"": "1h"},
+		{"/tmp/": "1h"},
+	} {
+		if _, err := ParsePrefixTTLs(ttls); err == nil {
+			t.Errorf("expected %v to be refused", ttls)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 10, 8, 0, 0, 0, 0, time.UTC)
+	db.SetClock(clock.NewFake(now))
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	directoryRepo := NewDirectoryRepository(db)
+
+	insert := func(bucket, name string, size int64, age time.Duration) {
+		created := now.Add(-age)
+		obj := &model.Metadata{Bucket: bucket, Name: name, Size: size, StorageClass: string(StorageStandard), Created: created, Updated: created}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := directoryRepo.UpsertParentDirs(ctx, StorageStandard, bucket, name, size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	week := 7 * 24 * time.Hour
+	insert("mock", "tmp/a/old", 10, 8*24*time.Hour)
+	insert("mock", "tmp/new", 20, time.Hour)
+	insert("mock", "keep/old", 40, 30*24*time.Hour)
+	insert("other", "tmp/old", 80, week+time.Second)
+	insert("other", "TMP/old", 160, 30*24*time.Hour)
+
+	expirer := NewExpirer(db, []PrefixTTL{{"tmp/", week}})
+	if err := expirer.Expire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, obj := range []struct {
+		bucket, name string
+		indexed      bool
+	}{
+		{"mock", "tmp/a/old", false},
+		{"mock", "tmp/new", true},
+		{"mock", "keep/old", true},
+		{"other", "tmp/old", false},
+		{"other", "TMP/old", true},
+	} {
+		_, err := metadataRepo.Get(ctx, obj.bucket, obj.name)
+		if indexed := !errors.Is(err, ErrNotFound); indexed != obj.indexed {
+			t.Errorf("%s/%s indexed mismatch: got %v want %v", obj.bucket, obj.name, indexed, obj.indexed)
+		}
+	}
+
+	for _, dir := range []struct {
+		bucket, name string
+		count, size  int64
+	}{
+		{"mock", "/", 2, 60},
+		{"mock", "tmp/", 1, 20},
+		{"mock", "tmp/a/", 0, 0},
+		{"other", "/", 1, 160},
+		{"other", "tmp/", 0, 0},
+	} {
+		var count, size int64
+		if err := db.QueryRow(`SELECT count, size_standard FROM directory WHERE bucket = ? AND name = ?`, dir.bucket, dir.name).Scan(&count, &size); err != nil {
+			t.Fatal(err)
+		}
+		if count != dir.count || size != dir.size {
+			t.Errorf("%s/%s rollup mismatch: got %d objects of %d bytes want %d of %d", dir.bucket, dir.name, count, size, dir.count, dir.size)
+		}
+	}
+
+	// Expiring again finds nothing left to remove
+	if err := expirer.Expire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := db.QueryRow(`SELECT count FROM directory WHERE bucket = 'mock' AND name = '/'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected a second run to leave rollups alone, got %d objects", count)
+	}
+}
+
+func TestListExpiredPaging(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 10, 8, 0, 0, 0, 0, time.UTC)
+	db.SetClock(clock.NewFake(now))
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+
+	// Names differing in case only tie in the listing order, across more than a batch
+	created := now.Add(-30 * 24 * time.Hour)
+	total := expireBatch + expireBatch/2
+	for i := range total {
+		name := fmt.Sprintf("tmp/f%04d", i/2)
+		if i%2 == 1 {
+			name = fmt.Sprintf("tmp/F%04d", i/2)
+		}
+		obj := &model.Metadata{Bucket: "mock", Name: name, StorageClass: string(StorageStandard), Created: created, Updated: created}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Listing without removing anything moves on to the next batch rather than repeating or ending
+	expirer := NewExpirer(db, []PrefixTTL{{"tmp/", time.Hour}})
+	listed := map[string]bool{}
+	after := expiredObject{RowID: math.MinInt64}
+	for {
+		objects, err := expirer.listExpired(ctx, "tmp/", now, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objects {
+			if listed[obj.Name] {
+				t.Fatalf("%s listed twice", obj.Name)
+			}
+			listed[obj.Name] = true
+		}
+		if len(objects) < expireBatch {
+			break
+		}
+		after = objects[len(objects)-1]
+	}
+	if len(listed) != total {
+		t.Errorf("Listed objects mismatch: got %d want %d", len(listed), total)
+	}
+
+	expired, err := expirer.expirePrefix(ctx, "tmp/", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != total {
+		t.Errorf("Expired objects mismatch: got %d want %d", expired, total)
+	}
+}
@@ -1,11 +1,14 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/jmoiron/sqlx"
 )
 
 type SortType string
@@ -16,22 +19,173 @@ const (
 	defaultLocation          = LocationUS
 )
 
+const defaultContentsLimit = 100
+
 type Explore struct {
 	*Database
+	cursors *cursorStore
 }
 
 type ExploreRepository interface {
-	GetPathContents(path string, sort SortType) ([]*model.Metadata, error)
-	GetPathSummary(path string) (*model.Summary, error)
+	GetPathContents(ctx context.Context, path string, sort SortType, opts ListOptions) ([]*model.Metadata, error)
+	GetPathContentsPage(ctx context.Context, path string, sort SortType, opts ListOptions, pageSize int, pageToken string) ([]*model.Metadata, string, error)
+	PageTokenAfter(path string, sort SortType, opts ListOptions, pageToken string, last *model.Metadata) (string, error)
+	GetPathSummary(ctx context.Context, path string) (*model.Summary, error)
+	GetTopLevelDirectories(ctx context.Context) ([]*model.Directory, error)
+	Search(ctx context.Context, path string, query string, collation Collation, opts ListOptions, limit int) ([]*model.Metadata, error)
+	GetLargestDirectories(ctx context.Context, bucket string, n int) ([]*model.Directory, error)
+	CountObjects(ctx context.Context, path string, opts ListOptions, approximate bool) (*model.ObjectCount, error)
 }
 
 func NewExploreRepository(db *Database) ExploreRepository {
-	return &Explore{db}
+	return &Explore{db, newCursorStore()}
 }
 
-// GetPath retrieves all directory contents of a given path including itself
+// GetPath retrieves all directory contents of a given path including itself matching opts
 // It excludes directories whose size is 0
-func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metadata, error) {
+func (e *Explore) GetPathContents(ctx context.Context, path string, sortBy SortType, opts ListOptions) ([]*model.Metadata, error) {
+	kind := missingContents
+	if opts.IncludeMarkers {
+		kind = missingMarkersContents
+	}
+
+	// Filtered listings may be empty while the path exists, and snapshots of read transactions may predate
+	// the writes creating it, they are not remembered
+	missing := e.missing
+	if !opts.IsZero() || e.inReadTx(ctx) {
+		missing = nil
+	}
+	if missing.known(kind, path) {
+		return []*model.Metadata{}, nil
+	}
+
+	generation := missing.start()
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	contents, err := getPathContents(ctx, e.reader(ctx), path, sortBy, opts, defaultContentsLimit, nil)
+	if err == nil && len(contents) == 0 {
+		missing.add(kind, path, generation)
+	}
+	return contents, err
+}
+
+// GetPathContentsPage retrieves one page of the directory contents of a given path
+// Pages start after the last row of the previous page, whose position is carried by the page token
+// Every page of a listing is read from the snapshot taken when its first page was requested while its cursor
+// stays open, so concurrent writes can't cause rows to be skipped or duplicated between pages
+// Listings whose cursor expired, was evicted, was disabled by SetCursorTimeout or is held by another server
+// continue on the current contents, where only rows whose size or count changed between pages may be skipped or
+// repeated
+// An empty pageToken starts a new listing, and an empty returned token marks the last page
+func (e *Explore) GetPathContentsPage(ctx context.Context, path string, sortBy SortType, opts ListOptions, pageSize int, pageToken string) ([]*model.Metadata, string, error) {
+	if pageSize <= 0 {
+		return nil, "", errors.New("page size must be positive")
+	}
+
+	key := listingKey(path, sortBy, opts)
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	var c *cursor
+	var after *pagePosition
+	if len(pageToken) > 0 {
+		t, err := decodePageToken(pageToken, key)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &t.After
+		if len(t.Cursor) > 0 {
+			c = e.cursors.take(t.Cursor)
+		}
+	}
+
+	// The snapshot of the first page is kept for the following ones, later pages losing theirs read without one
+	var q reader = e.reader(ctx)
+	switch {
+	case c != nil:
+		q = c.tx
+	case after == nil && e.cursorTimeout > 0:
+		// The transaction outlives this request, so it is not bound to ctx
+		tx, err := e.DB.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, "", translateError(err)
+		}
+		c = &cursor{tx: tx}
+		q = tx
+	}
+	rollback := func() {
+		if c != nil {
+			c.tx.Rollback()
+		}
+	}
+
+	// Fetch one extra row to detect whether another page follows
+	contents, err := getPathContents(ctx, q, path, sortBy, opts, pageSize+1, after)
+	if err != nil {
+		rollback()
+		return nil, "", err
+	}
+
+	if len(contents) <= pageSize {
+		rollback()
+		return contents, "", nil
+	}
+	contents = contents[:pageSize]
+
+	next := pageState{Listing: listingHash(key), After: positionOf(contents[pageSize-1], sortBy)}
+	if c != nil {
+		if len(c.id) > 0 {
+			e.cursors.put(c)
+		} else if err := e.cursors.open(c, e.cursorTimeout); err != nil {
+			c.tx.Rollback()
+			return nil, "", err
+		}
+		next.Cursor = c.id
+	}
+
+	token, err := next.encode()
+	if err != nil {
+		return nil, "", err
+	}
+	return contents, token, nil
+}
+
+// PageTokenAfter returns the token of the page following last, a row of the listing of pageToken, the token of the
+// page following it or an empty token if it was the last page
+// Pages may so end before their size, such as to bound the size of responses
+func (e *Explore) PageTokenAfter(path string, sortBy SortType, opts ListOptions, pageToken string, last *model.Metadata) (string, error) {
+	key := listingKey(path, sortBy, opts)
+	next := pageState{Listing: listingHash(key), After: positionOf(last, sortBy)}
+	if len(pageToken) > 0 {
+		t, err := decodePageToken(pageToken, key)
+		if err != nil {
+			return "", err
+		}
+		next.Cursor = t.Cursor
+	}
+	return next.encode()
+}
+
+// listingKey identifies the listing of the contents of path, whatever the size of its pages
+func listingKey(path string, sortBy SortType, opts ListOptions) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", path, sortBy, opts)
+}
+
+// positionOf returns the position of a row of directory contents sorted by sortBy
+func positionOf(m *model.Metadata, sortBy SortType) pagePosition {
+	value := m.Size
+	if sortBy == SortByCount {
+		value = m.Count
+	}
+	return pagePosition{Value: value, Name: m.Name, Marker: m.Marker}
+}
+
+// getPathContents runs the directory contents query against q, a database or transaction
+// Rows are listed after the position after, from the first row if nil
+func getPathContents(ctx context.Context, q sqlx.QueryerContext, path string, sortBy SortType, opts ListOptions, limit int, after *pagePosition) ([]*model.Metadata, error) {
 	type contentRow struct {
 		Name         string `db:"name"`
 		NameLength   int    `db:"name_length"`
@@ -43,13 +197,35 @@ func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metada
 		Size         int64  `db:"size"`
 		Count        int64  `db:"count"`
 		Parent       string `db:"parent"`
+		Location     string `db:"location"`
+		DetectedType string `db:"detected_type"`
+		Marker       bool   `db:"marker"`
+		// NoncurrentSize is only set on directories
+		NoncurrentSize int64 `db:"noncurrent_size"`
 	}
 
-	if path == "/" {
-		path = "" // handle root
+	// Children are looked up by their parent, the directory itself under its own parent
+	parent := getParentDir(path)
+
+	// Markers are left out unless requested, they only stand for directories already listed
+	// With them, directories holding nothing but markers are listed as well
+	// Directories holding nothing but noncurrent generations are still billed, so they are listed
+	dirCount, dirFilter, objectFilter := "count", "(size > 0 OR noncurrent_size > 0)", "AND NOT marker"
+	if opts.IncludeMarkers {
+		dirCount, dirFilter, objectFilter = "count + markers", "(size > 0 OR noncurrent_size > 0 OR markers > 0)", ""
 	}
 
+	// Filters are bound after the path, parent and limit
+	args := []any{path, parent, limit}
+	bind := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("?%d", len(args))
+	}
+	dirFilter += opts.sizeConditions(directorySizeExpr, bind)
+	objectFilter += opts.objectConditions(bind)
+
 	queryContent := `
+		SELECT * FROM (
 		SELECT
 			name, 
 			LENGTH(name) AS name_length,
@@ -61,14 +237,18 @@ func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metada
 			size_nearline  + 
 			size_coldline  + 
 			size_archive) AS size, 
-			count,
+			` + dirCount + ` AS count,
 			'' as storage_class,
-			parent
+			parent,
+			COALESCE((SELECT location FROM bucket WHERE bucket.name = directory.bucket), '') AS location,
+			'' AS detected_type,
+			FALSE AS marker,
+			noncurrent_size
 		FROM directory
 		WHERE
-			name LIKE $1 || '%' AND
-			NOT name LIKE $1 || '%/%/' AND
-			size > 0
+			parent IN (?1, ?2) AND
+			(parent = ?1 OR name = ?1) AND
+			` + dirFilter + `
 		UNION ALL
 		SELECT 
 			name, 
@@ -80,20 +260,29 @@ func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metada
 			size, 
 			0 as count,
 			storage_class,
-			'' as parent 
+			'' as parent,
+			COALESCE((SELECT location FROM bucket WHERE bucket.name = metadata.bucket), '') AS location,
+			COALESCE(detected_type, '') AS detected_type,
+			marker,
+			0 AS noncurrent_size
 		FROM metadata
-		WHERE
-			name LIKE $1 || '%' AND
-			NOT name LIKE $1 || '%/%'
+		WHERE parent = ?1 ` + objectFilter + `
+		)
 	`
 
 	if sortBy != SortByCount && sortBy != SortBySize {
 		return nil, errors.New("invalid sort parameter")
 	}
-	queryContent += fmt.Sprintf(" ORDER BY %s DESC, name_length", sortBy)
-	queryContent += " LIMIT 100;"
 
-	rows, err := e.DB.Queryx(queryContent, path)
+	// Markers share their names with the directories they stand for, so they are ordered after them
+	if after != nil {
+		queryContent += fmt.Sprintf(" WHERE (-%s, name_length, name, marker) > (%s, LENGTH(%s), %[3]s, %s)",
+			sortBy, bind(-after.Value), bind(after.Name), bind(after.Marker))
+	}
+	queryContent += fmt.Sprintf(" ORDER BY %s DESC, name_length, name, marker", sortBy)
+	queryContent += " LIMIT ?3;"
+
+	rows, err := q.QueryxContext(ctx, queryContent, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
 	}
@@ -107,23 +296,26 @@ func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metada
 		}
 
 		metadata := &model.Metadata{
-			Name:         row.Name,
-			Size:         row.Size,
-			Count:        row.Count,
-			StorageClass: row.StorageClass,
-			Parent:       row.Parent,
+			Name:           row.Name,
+			Size:           row.Size,
+			Count:          row.Count,
+			StorageClass:   row.StorageClass,
+			Parent:         row.Parent,
+			DetectedType:   row.DetectedType,
+			Marker:         row.Marker,
+			NoncurrentSize: row.NoncurrentSize,
 		}
 
-		// Calculate costs of every object and directory
-		// defaultLocation is used for all results until storing location from bucket is implemented
+		// Calculate costs of every object and directory, priced at the location of their bucket
+		location := pricingLocation(row.Location)
 		if len(metadata.StorageClass) > 0 { // object
-			cost, err := getObjectCost(defaultLocation, StorageClass(metadata.StorageClass), metadata.Size)
+			cost, err := getObjectCost(location, StorageClass(metadata.StorageClass), metadata.Size)
 			if err != nil {
 				return nil, err
 			}
 			metadata.Cost = cost
 		} else { // directory
-			totalCost, err := getDirectoryCost(defaultLocation, row.SizeStandard, row.SizeNearline, row.SizeColdline, row.SizeArchive)
+			totalCost, err := getDirectoryCost(location, row.SizeStandard, row.SizeNearline, row.SizeColdline, row.SizeArchive)
 			if err != nil {
 				return nil, err
 			}
@@ -136,25 +328,14 @@ func (e *Explore) GetPathContents(path string, sortBy SortType) ([]*model.Metada
 	return pathContents, nil
 }
 
-func (e *Explore) GetPathSummary(path string) (*model.Summary, error) {
+func (e *Explore) GetPathSummary(ctx context.Context, path string) (*model.Summary, error) {
 	var summary model.Summary
 
-	query := `
-		SELECT
-			name,
-			size_standard,
-			size_nearline,
-			size_coldline,
-			size_archive	
-		FROM
-			directory
-		WHERE
-			name = $1;
-	`
-
-	row := e.DB.QueryRowx(query, path)
-	if err := row.StructScan(&summary); err != nil && err != sql.ErrNoRows {
-		return nil, err
+	// Missing paths are summarized as empty, which needs no query once known
+	if !e.missing.known(missingSummary, path) {
+		if err := e.scanSummary(ctx, path, &summary); err != nil {
+			return nil, err
+		}
 	}
 
 	// Compute pricing
@@ -170,12 +351,221 @@ func (e *Explore) GetPathSummary(path string) (*model.Summary, error) {
 	}
 
 	for _, sc := range storageClasses {
-		cost, err := getObjectCost(defaultLocation, sc.class, *sc.size)
+		cost, err := getObjectCost(pricingLocation(summary.Location), sc.class, *sc.size)
 		if err != nil {
 			return nil, err
 		}
 		*sc.cost = cost
 	}
 
+	summary.LiveSize = summary.Size.Standard + summary.Size.Nearline + summary.Size.Coldline + summary.Size.Archive
+	summary.BillableSize = summary.LiveSize + summary.NoncurrentSize
+
 	return &summary, nil
 }
+
+// scanSummary reads the sizes of the directory path into summary, with the notification lag of its top level prefix
+// over the last summaryLagWindow, leaving it empty if path is missing
+func (e *Explore) scanSummary(ctx context.Context, path string, summary *model.Summary) error {
+	query := `
+		SELECT
+			name,
+			size_standard,
+			size_nearline,
+			size_coldline,
+			size_archive,
+			noncurrent_size,
+			COALESCE((SELECT location FROM bucket WHERE bucket.name = directory.bucket), '') AS location,
+			lag_events,
+			lag_total,
+			lag_max
+		FROM
+			directory,
+			(
+				SELECT
+					COALESCE(SUM(events), 0) AS lag_events,
+					COALESCE(SUM(total_lag), 0) AS lag_total,
+					COALESCE(MAX(max_lag), 0) AS lag_max
+				FROM notification_lag
+				WHERE window_start >= CAST(strftime('%s', 'now') AS INTEGER) - ` + strconv.Itoa(int(summaryLagWindow.Seconds())) + `
+					AND ($1 = '/' OR prefix = substr($1, 1, instr($1, '/')))
+			)
+		WHERE
+			name = $1;
+	`
+
+	var row struct {
+		*model.Summary
+		LagEvents int64 `db:"lag_events"`
+		LagTotal  int64 `db:"lag_total"`
+		LagMax    int64 `db:"lag_max"`
+	}
+	row.Summary = summary
+
+	generation := e.missing.start()
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	err := e.reader(ctx).QueryRowxContext(ctx, query, path).StructScan(&row)
+	if err == sql.ErrNoRows {
+		// Snapshots of read transactions may predate the writes creating the path
+		if !e.inReadTx(ctx) {
+			e.missing.add(missingSummary, path, generation)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if row.LagEvents > 0 {
+		stats := lagRow{Events: row.LagEvents, TotalLag: row.LagTotal, MaxLag: row.LagMax}.stats()
+		summary.NotificationLag = &stats
+	}
+	return nil
+}
+
+// GetTopLevelDirectories retrieves the root and top level directories of every bucket with their total size
+func (e *Explore) GetTopLevelDirectories(ctx context.Context) ([]*model.Directory, error) {
+	query := `
+		SELECT
+			bucket,
+			name,
+			size_standard + size_nearline + size_coldline + size_archive AS size,
+			count
+		FROM directory
+		WHERE parent = '/'
+		ORDER BY bucket, name;
+	`
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	dirs := []*model.Directory{}
+	if err := e.reader(ctx).SelectContext(ctx, &dirs, query); err != nil {
+		return nil, translateError(err)
+	}
+	return dirs, nil
+}
+
+// Search retrieves up to limit objects under path whose name contains query and matching opts, in name order
+// Both path and query are compared with the names under collation
+// Binary and case-insensitive searches look the path up in the case-insensitive name index,
+// Unicode searches compare the name of every object
+func (e *Explore) Search(ctx context.Context, path string, query string, collation Collation, opts ListOptions, limit int) ([]*model.Metadata, error) {
+	// Names are stored without the root
+	prefix := path
+	if path == "/" {
+		prefix = ""
+	}
+
+	var filter string
+	var args []any
+	switch collation {
+	case CollationBinary:
+		lower := asciiLower(prefix)
+		filter = `name COLLATE NOCASE >= ? AND name COLLATE NOCASE < ? AND name >= ? AND name < ? AND instr(name, ?) > 0`
+		args = []any{lower, prefixEnd(lower), prefix, prefixEnd(prefix), query}
+	case CollationNoCase:
+		lower := asciiLower(prefix)
+		filter = `name COLLATE NOCASE >= ? AND name COLLATE NOCASE < ? AND instr(lower(name), ?) > 0`
+		args = []any{lower, prefixEnd(lower), asciiLower(query)}
+	case CollationUnicode:
+		folded := foldName(prefix)
+		filter = foldNameFunc + `(name) >= ? AND ` + foldNameFunc + `(name) < ? AND instr(` + foldNameFunc + `(name), ?) > 0`
+		args = []any{folded, prefixEnd(folded), foldName(query)}
+	default:
+		return nil, fmt.Errorf("unknown collation %q", collation)
+	}
+
+	if !opts.IncludeMarkers {
+		filter += " AND NOT marker"
+	}
+	filter += opts.objectConditions(func(v any) string {
+		args = append(args, v)
+		return "?"
+	})
+
+	searchQuery := `
+		SELECT
+			bucket,
+			name,
+			parent,
+			size,
+			storage_class,
+			created,
+			updated,
+			custom_time,
+			COALESCE(detected_type, '') AS detected_type,
+			marker,
+			COALESCE((SELECT location FROM bucket WHERE bucket.name = metadata.bucket), '') AS location
+		FROM metadata
+		WHERE ` + filter + `
+		ORDER BY name, bucket
+		LIMIT ?;
+	`
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := e.reader(ctx).QueryxContext(ctx, searchQuery, append(args, limit)...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var results []*model.Metadata
+	for rows.Next() {
+		var row struct {
+			model.Metadata
+			Location string `db:"location"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		cost, err := getObjectCost(pricingLocation(row.Location), StorageClass(row.StorageClass), row.Size)
+		if err != nil {
+			return nil, err
+		}
+		row.Cost = cost
+
+		obj := row.Metadata
+		results = append(results, &obj)
+	}
+
+	return results, translateError(rows.Err())
+}
+
+// GetLargestDirectories retrieves the n largest directories of a bucket, or of every bucket if bucket is empty,
+// the root excluded. They are read from the ranking maintained as directories change, n being at most MaxTopDirectories
+func (e *Explore) GetLargestDirectories(ctx context.Context, bucket string, n int) ([]*model.Directory, error) {
+	if n <= 0 || n > MaxTopDirectories {
+		return nil, fmt.Errorf("n must be between 1 and %d", MaxTopDirectories)
+	}
+
+	// The largest directories of every bucket are among the largest of each one
+	filter, args := "", []any{n}
+	if len(bucket) > 0 {
+		filter, args = "WHERE t.bucket = ?", []any{bucket, n}
+	}
+
+	query := `
+		SELECT t.bucket, t.name, t.size, d.count
+		FROM top_directory t
+		JOIN directory d ON d.bucket = t.bucket AND d.name = t.name
+		` + filter + `
+		ORDER BY t.size DESC, t.bucket, t.name
+		LIMIT ?;
+	`
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	directories := []*model.Directory{}
+	if err := e.reader(ctx).SelectContext(ctx, &directories, query, args...); err != nil {
+		return nil, translateError(err)
+	}
+	return directories, nil
+}
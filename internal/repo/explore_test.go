@@ -2,7 +2,11 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -35,10 +39,10 @@ func TestGetPathContents(t *testing.T) {
 	}
 
 	for _, m := range metadata {
-		if err := metadataRepo.Insert(&m); err != nil {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
 			t.Fatal(err)
 		}
-		if err := dirRepo.UpsertParentDirs(StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
+		if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -113,7 +117,7 @@ func TestGetPathContents(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := exploreRepo.GetPathContents(tc.path, SortType(tc.sort))
+			got, err := exploreRepo.GetPathContents(context.Background(), tc.path, SortType(tc.sort), ListOptions{})
 			if err != nil {
 				if tc.wantErr {
 					return
@@ -176,10 +180,10 @@ func TestGetPathSummary(t *testing.T) {
 	}
 
 	for _, m := range metadata {
-		if err := metadataRepo.Insert(&m); err != nil {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
 			t.Fatal(err)
 		}
-		if err := dirRepo.UpsertParentDirs(StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
+		if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -260,7 +264,7 @@ func TestGetPathSummary(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := exploreRepo.GetPathSummary(tc.path)
+			got, err := exploreRepo.GetPathSummary(context.Background(), tc.path)
 			if err != nil {
 				if tc.wantErr {
 					return
@@ -326,3 +330,432 @@ func TestGetPathSummary(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPathContentsPage(t *testing.T) {
+	// Snapshots require a writer on a separate connection, which in-memory databases don't share
+	db := NewDatabase(filepath.Join(t.TempDir(), "test.db"), maxOpenCursors+2)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	exploreRepo := NewExploreRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+
+	insert := func(m model.Metadata) {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Insert mock data
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		insert(model.Metadata{Bucket: "mock", Name: name, Size: int64(5 - i), StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()})
+	}
+
+	names := func(contents []*model.Metadata) []string {
+		var got []string
+		for _, c := range contents {
+			got = append(got, c.Name)
+		}
+		return got
+	}
+
+	t.Run("Pages read from a consistent snapshot", func(t *testing.T) {
+		page, token, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 3, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := names(page), []string{"/", "a", "b"}; !slices.Equal(got, want) {
+			t.Fatalf("First page mismatch: got %v, want %v", got, want)
+		}
+		if len(token) == 0 {
+			t.Fatal("Expected next page token")
+		}
+
+		// Written between pages, it would shift every following row if read
+		insert(model.Metadata{Bucket: "mock", Name: "z", Size: 10, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()})
+
+		page, token, err = exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 3, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := names(page), []string{"c", "d", "e"}; !slices.Equal(got, want) {
+			t.Fatalf("Second page mismatch: got %v, want %v", got, want)
+		}
+		if len(token) != 0 {
+			t.Errorf("Expected last page, got token %s", token)
+		}
+
+		// A new listing observes the write
+		page, _, err = exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 3, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := names(page), []string{"/", "z", "a"}; !slices.Equal(got, want) {
+			t.Errorf("New listing mismatch: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Rejects unknown page token", func(t *testing.T) {
+		if _, _, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 3, "unknown"); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("Expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+
+	t.Run("Rejects page token of another listing", func(t *testing.T) {
+		_, token, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortByCount, ListOptions{}, 1, token); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("Expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+
+	t.Run("Evicts oldest cursor beyond limit", func(t *testing.T) {
+		var tokens []string
+		for i := 0; i < maxOpenCursors+1; i++ {
+			_, token, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 1, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, token)
+		}
+
+		// Every cursor is taken and put back, which must not grow the store beyond its limit either
+		for _, token := range tokens[1:] {
+			if _, _, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 1, token); err != nil {
+				t.Errorf("Expected newest cursors to be valid, got %v", err)
+			}
+		}
+		if open := len(exploreRepo.(*Explore).cursors.cursors); open > maxOpenCursors {
+			t.Errorf("Expected at most %d open cursors, got %d", maxOpenCursors, open)
+		}
+
+		// The evicted listing continues after its last row without a snapshot
+		page, _, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 1, tokens[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := names(page), []string{"z"}; !slices.Equal(got, want) {
+			t.Errorf("Evicted listing mismatch: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Continues after the last row of each page", func(t *testing.T) {
+		var got []string
+		token := ""
+		for {
+			page, next, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortByCount, ListOptions{}, 2, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, names(page)...)
+			if len(next) == 0 {
+				break
+			}
+			token = next
+		}
+
+		if want := []string{"/", "a", "b", "c", "d", "e", "z"}; !slices.Equal(got, want) {
+			t.Errorf("Listing mismatch: got %v, want %v", got, want)
+		}
+	})
+
+	openCursors := func() int {
+		store := exploreRepo.(*Explore).cursors
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.cursors)
+	}
+
+	t.Run("Releases idle cursors after the cursor timeout", func(t *testing.T) {
+		db.SetCursorTimeout(50 * time.Millisecond)
+		defer db.SetCursorTimeout(defaultCursorTimeout)
+
+		if _, _, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for openCursors() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the idle cursor to be released")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Pages without cursors when disabled", func(t *testing.T) {
+		db.SetCursorTimeout(0)
+		defer db.SetCursorTimeout(defaultCursorTimeout)
+
+		page, token, err := exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 2, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open := openCursors(); open != 0 {
+			t.Errorf("Expected no open cursors, got %d", open)
+		}
+
+		page, _, err = exploreRepo.GetPathContentsPage(context.Background(), "/", SortBySize, ListOptions{}, 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := names(page), []string{"a", "b"}; !slices.Equal(got, want) {
+			t.Errorf("Second page mismatch: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestGetTopLevelDirectories(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	objects := []struct {
+		bucket string
+		name   string
+		class  StorageClass
+		size   int64
+	}{
+		{"mock-a", "logs/2024/a", StorageStandard, 10},
+		{"mock-a", "logs/b", StorageArchive, 5},
+		{"mock-a", "c", StorageStandard, 1},
+		{"mock-b", "data/d", StorageNearline, 20},
+	}
+	for _, o := range objects {
+		if err := dirRepo.UpsertParentDirs(ctx, o.class, o.bucket, o.name, o.size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := exploreRepo.GetTopLevelDirectories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.Directory{
+		{Bucket: "mock-a", Name: "/", Size: 16, Count: 3},
+		{Bucket: "mock-a", Name: "logs/", Size: 15, Count: 2},
+		{Bucket: "mock-b", Name: "/", Size: 20, Count: 1},
+		{Bucket: "mock-b", Name: "data/", Size: 20, Count: 1},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Return count mismatch: got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("Directory %d mismatch: got %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestGetPathContentsMarkers(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	exploreRepo := NewExploreRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+
+	metadata := []model.Metadata{
+		{Bucket: "mock", Name: "a/", StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()},
+		{Bucket: "mock", Name: "a/file", Size: bytesPerGB, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()},
+		{Bucket: "mock", Name: "a/empty/", StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()},
+	}
+
+	for _, m := range metadata {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(context.Background(), StorageClass(m.StorageClass), m.Bucket, m.Name, m.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{
+			"Leaves markers out by default",
+			ListOptions{},
+			[]string{"a/:1", "a/file:0"},
+		},
+		{
+			"Lists markers and the directories they stand for on request",
+			ListOptions{IncludeMarkers: true},
+			[]string{"a/:0", "a/:3", "a/empty/:1", "a/file:0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			contents, err := exploreRepo.GetPathContents(context.Background(), "a/", SortBySize, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, c := range contents {
+				got = append(got, fmt.Sprintf("%s:%d", c.Name, c.Count))
+			}
+			slices.Sort(got) // markers and empty directories tie on size
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Contents mismatch: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	exploreRepo := NewExploreRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+
+	// The accent of the lowercase gif is a combining character
+	names := []string{"Photos/Café.jpg", "Photos/CAFE.png", "photos/cafe\u0301.gif", "photos/", "Docs/café.txt", "Photos2/cafe.jpg"}
+	for _, name := range names {
+		obj := &model.Metadata{Bucket: "mock", Name: name, Size: bytesPerGB, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+		if err := metadataRepo.Insert(context.Background(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		path      string
+		query     string
+		collation Collation
+		want      []string
+	}{
+		{
+			"Binary search matches exact bytes",
+			"Photos/",
+			"Caf",
+			CollationBinary,
+			[]string{"Photos/Café.jpg"},
+		},
+		{
+			"Binary search of the root",
+			"/",
+			"cafe",
+			CollationBinary,
+			[]string{"Photos2/cafe.jpg", "photos/cafe\u0301.gif"},
+		},
+		{
+			"Case-insensitive search ignores ASCII case of path and query",
+			"PHOTOS/",
+			"CAFE",
+			CollationNoCase,
+			[]string{"Photos/CAFE.png", "photos/cafe\u0301.gif"},
+		},
+		{
+			"Unicode search folds case and normalizes composed characters",
+			"photos/",
+			"CAFÉ",
+			CollationUnicode,
+			[]string{"Photos/Café.jpg", "photos/cafe\u0301.gif"},
+		},
+		{
+			"Search excludes directory markers",
+			"photos/",
+			"/",
+			CollationBinary,
+			[]string{"photos/cafe\u0301.gif"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := exploreRepo.Search(context.Background(), tc.path, tc.query, tc.collation, ListOptions{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Name)
+				if r.Cost == 0 {
+					t.Errorf("Cost of %s not computed", r.Name)
+				}
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Results mismatch: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Paths are looked up in the case-insensitive name index unless compared as Unicode
+	for _, collation := range []Collation{CollationBinary, CollationNoCase} {
+		ctx, queryLog := WithQueryLog(context.Background())
+		if _, err := exploreRepo.Search(ctx, "photos/", "cafe", collation, ListOptions{}, 10); err != nil {
+			t.Fatal(err)
+		}
+
+		q := queryLog.Queries()[0]
+		plan, err := db.ExplainQueryPlan(context.Background(), q.SQL, q.Args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(strings.Join(plan, "\n"), "metadata_name_nocase") {
+			t.Errorf("Expected %s search to use the name index, got plan %v", collation, plan)
+		}
+	}
+
+	if _, err := exploreRepo.Search(context.Background(), "/", "cafe", "fuzzy", ListOptions{}, 10); err == nil {
+		t.Error("Expected error searching with unknown collation")
+	}
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// FaultConfig injects faults into the writes of a database, to test how writers retry and deduplicate
+// Rates are probabilities in [0, 1], the zero config injecting nothing
+// Faults are only meant for tests and load generation, never for serving
+type FaultConfig struct {
+	// WriteFailureRate is the rate of writes failing with ErrBusy before being applied
+	WriteFailureRate float64
+	// DuplicateRate is the rate of writes applied a second time once committed, as redelivered events would be
+	DuplicateRate float64
+	// AckDelayRate is the rate of writes returning AckDelay after being committed
+	AckDelayRate float64
+	AckDelay     time.Duration
+	// Seed makes the writes picked for every fault reproducible
+	Seed uint64
+}
+
+// Validate checks that every rate is a probability
+func (c FaultConfig) Validate() error {
+	for _, rate := range []float64{c.WriteFailureRate, c.DuplicateRate, c.AckDelayRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("fault rates must be in [0, 1]")
+		}
+	}
+	if c.AckDelay < 0 {
+		return errors.New("ack delay must not be negative")
+	}
+	return nil
+}
+
+// Enabled returns whether any fault is injected
+func (c FaultConfig) Enabled() bool {
+	return c.WriteFailureRate > 0 || c.DuplicateRate > 0 || c.AckDelayRate > 0
+}
+
+// errInjected marks the failures injected by faults
+var errInjected = fmt.Errorf("%w: injected fault", ErrBusy)
+
+type faults struct {
+	cfg FaultConfig
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// SetFaults injects the faults of cfg into every later write of repositories on db
+func (db *Database) SetFaults(cfg FaultConfig) {
+	db.faults = &faults{cfg: cfg, rand: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))}
+}
+
+// roll reports whether a fault of rate occurs
+func (f *faults) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// write commits op with commit, injecting the configured faults around it
+// Failures are injected within the transaction of op, so write queues retry them as they would a locked database
+func (f *faults) write(ctx context.Context, op WriteOp, commit func(ctx context.Context, op WriteOp) error) error {
+	failing := func(ctx context.Context, tx *sql.Tx) error {
+		if f.roll(f.cfg.WriteFailureRate) {
+			return errInjected
+		}
+		return op(ctx, tx)
+	}
+
+	if err := commit(ctx, failing); err != nil {
+		return err
+	}
+
+	if f.roll(f.cfg.DuplicateRate) {
+		if err := commit(ctx, op); err != nil {
+			log.Printf("Error applying duplicated write: %v", err)
+		}
+	}
+
+	if f.roll(f.cfg.AckDelayRate) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(f.cfg.AckDelay):
+		}
+	}
+	return nil
+}
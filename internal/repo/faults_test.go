@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFaults(t *testing.T) {
+	newDatabase := func(t *testing.T, cfg FaultConfig) *Database {
+		db := NewDatabase(":memory:", 1)
+		db.Connect(context.Background())
+		t.Cleanup(func() { db.Close() })
+
+		if err := db.Setup(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.CreateTables(); err != nil {
+			t.Fatal(err)
+		}
+
+		db.SetFaults(cfg)
+		return db
+	}
+
+	dirCount := func(t *testing.T, db *Database, name string) int64 {
+		var count int64
+		if err := db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM directory WHERE bucket = 'mock' AND name = ?;`, name).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	ctx := context.Background()
+
+	t.Run("Failed writes are not applied", func(t *testing.T) {
+		db := newDatabase(t, FaultConfig{WriteFailureRate: 1})
+		err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "a/file", 1, 1)
+		if !Retryable(err) {
+			t.Errorf("Expected a retryable error, got %v", err)
+		}
+		if count := dirCount(t, db, "a/"); count != 0 {
+			t.Errorf("Count mismatch: got %d, want 0", count)
+		}
+	})
+
+	t.Run("Write queues retry failed writes", func(t *testing.T) {
+		db := newDatabase(t, FaultConfig{WriteFailureRate: 0.3, Seed: 1})
+		queue := NewWriteQueue(db, 10)
+		db.SetWriteQueue(queue)
+
+		queueCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go queue.Run(queueCtx)
+
+		dirRepo := NewDirectoryRepository(db)
+		for range 10 {
+			if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", "a/file", 1, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count := dirCount(t, db, "a/"); count != 10 {
+			t.Errorf("Count mismatch: got %d, want 10", count)
+		}
+	})
+
+	t.Run("Duplicated writes are applied twice", func(t *testing.T) {
+		db := newDatabase(t, FaultConfig{DuplicateRate: 1})
+		if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "a/file", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if count := dirCount(t, db, "a/"); count != 2 {
+			t.Errorf("Count mismatch: got %d, want 2", count)
+		}
+	})
+
+	t.Run("Delayed writes are acknowledged late", func(t *testing.T) {
+		db := newDatabase(t, FaultConfig{AckDelayRate: 1, AckDelay: 20 * time.Millisecond})
+		start := time.Now()
+		if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "a/file", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("Expected write to be delayed, returned after %v", elapsed)
+		}
+	})
+}
+
+func TestFaultConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     FaultConfig
+		wantErr bool
+	}{
+		{"No faults", FaultConfig{}, false},
+		{"Every fault", FaultConfig{WriteFailureRate: 1, DuplicateRate: 0.5, AckDelayRate: 0.1, AckDelay: time.Second}, false},
+		{"Rate above 1", FaultConfig{DuplicateRate: 1.5}, true},
+		{"Negative rate", FaultConfig{WriteFailureRate: -0.1}, true},
+		{"Negative delay", FaultConfig{AckDelay: -time.Second}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Error mismatch: got %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
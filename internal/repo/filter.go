@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ListOptions narrow listings, searches and counts down in SQL, nil fields leaving them unfiltered
+// Directories are only filtered by their total size, the other conditions only apply to objects,
+// so listings still lead to the directories holding matching objects
+type ListOptions struct {
+	MinSize        *int64
+	MaxSize        *int64
+	UpdatedAfter   *time.Time
+	UpdatedBefore  *time.Time
+	StorageClasses []StorageClass
+	// IncludeMarkers lists directory marker objects and counts them in the totals of their directories
+	IncludeMarkers bool
+}
+
+// IsZero returns whether the options match every entry, directory markers excepted
+func (o ListOptions) IsZero() bool {
+	return o.MinSize == nil && o.MaxSize == nil && o.UpdatedAfter == nil && o.UpdatedBefore == nil && len(o.StorageClasses) == 0
+}
+
+// String identifies the options, to tell apart listings filtered differently
+func (o ListOptions) String() string {
+	var b strings.Builder
+	for _, size := range []*int64{o.MinSize, o.MaxSize} {
+		if size != nil {
+			fmt.Fprintf(&b, "%d", *size)
+		}
+		b.WriteString(",")
+	}
+	for _, t := range []*time.Time{o.UpdatedAfter, o.UpdatedBefore} {
+		if t != nil {
+			b.WriteString(t.Format(time.RFC3339Nano))
+		}
+		b.WriteString(",")
+	}
+	fmt.Fprintf(&b, "%v,%t", o.StorageClasses, o.IncludeMarkers)
+	return b.String()
+}
+
+// sizeConditions returns the conditions on a size expression, each prefixed with AND
+// bind adds a value to the arguments of the statement and returns its placeholder
+func (o ListOptions) sizeConditions(sizeExpr string, bind func(v any) string) string {
+	var conditions strings.Builder
+	if o.MinSize != nil {
+		conditions.WriteString(" AND " + sizeExpr + " >= " + bind(*o.MinSize))
+	}
+	if o.MaxSize != nil {
+		conditions.WriteString(" AND " + sizeExpr + " <= " + bind(*o.MaxSize))
+	}
+	return conditions.String()
+}
+
+// objectConditions returns the conditions on the columns of the metadata table, each prefixed with AND
+func (o ListOptions) objectConditions(bind func(v any) string) string {
+	var conditions strings.Builder
+	conditions.WriteString(o.sizeConditions("size", bind))
+	// Timestamps are stored in UTC, and compared as text
+	if o.UpdatedAfter != nil {
+		conditions.WriteString(" AND updated > " + bind(o.UpdatedAfter.UTC()))
+	}
+	if o.UpdatedBefore != nil {
+		conditions.WriteString(" AND updated < " + bind(o.UpdatedBefore.UTC()))
+	}
+	if len(o.StorageClasses) > 0 {
+		// Tiers match every storage class rolled up in them
+		var placeholders []string
+		for _, class := range o.StorageClasses {
+			for _, member := range storageClassMembers(class) {
+				placeholders = append(placeholders, bind(string(member)))
+			}
+		}
+		conditions.WriteString(" AND storage_class IN (" + strings.Join(placeholders, ", ") + ")")
+	}
+	return conditions.String()
+}
@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestListFilter(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	exploreRepo := NewExploreRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+
+	old := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, obj := range []model.Metadata{
+		{Bucket: "mock", Name: "a/small", Size: 1, StorageClass: "STANDARD", Updated: recent},
+		{Bucket: "mock", Name: "a/large", Size: 100, StorageClass: "STANDARD", Updated: old},
+		{Bucket: "mock", Name: "a/archived", Size: 50, StorageClass: "ARCHIVE", Updated: old},
+		{Bucket: "mock", Name: "a/b/nested", Size: 10, StorageClass: "NEARLINE", Updated: recent},
+	} {
+		obj.Created = obj.Updated
+		if err := metadataRepo.Insert(ctx, &obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(ctx, StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{"No filter", ListOptions{}, []string{"a/", "a/archived", "a/b/", "a/large", "a/small"}},
+		{"Minimum size", ListOptions{MinSize: ptr(int64(50))}, []string{"a/", "a/archived", "a/large"}},
+		{"Size range", ListOptions{MinSize: ptr(int64(5)), MaxSize: ptr(int64(60))}, []string{"a/archived", "a/b/"}},
+		{"Empty objects only", ListOptions{MaxSize: ptr(int64(0))}, nil},
+		{"Updated before", ListOptions{UpdatedBefore: &recent}, []string{"a/", "a/archived", "a/b/", "a/large"}},
+		{"Updated after, in another time zone", ListOptions{UpdatedAfter: ptr(old.In(time.FixedZone("UTC-8", -8*3600)))}, []string{"a/", "a/b/", "a/small"}},
+		{"Storage classes", ListOptions{StorageClasses: []StorageClass{StorageArchive, StorageNearline}}, []string{"a/", "a/archived", "a/b/"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			contents, err := exploreRepo.GetPathContents(ctx, "a/", SortBySize, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, entry := range contents {
+				got = append(got, entry.Name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("Contents mismatch: got %v, want %v", got, tc.want)
+			}
+
+			// Paginated listings apply the same filter
+			page, _, err := exploreRepo.GetPathContentsPage(ctx, "a/", SortBySize, tc.opts, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != len(contents) {
+				t.Errorf("Page mismatch: got %d entries, want %d", len(page), len(contents))
+			}
+		})
+	}
+
+	// Searches filter objects only
+	results, err := exploreRepo.Search(ctx, "a/", "a", CollationBinary, ListOptions{MinSize: ptr(int64(10))}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, obj := range results {
+		got = append(got, obj.Name)
+	}
+	if strings.Join(got, ",") != "a/archived,a/b/nested,a/large" {
+		t.Errorf("Search mismatch: got %v, want [a/archived a/b/nested a/large]", got)
+	}
+
+	// Filtered listings are not remembered as missing paths
+	db.SetMissingPathTTL(time.Hour)
+	exploreRepo = NewExploreRepository(db)
+	if _, err := exploreRepo.GetPathContents(ctx, "a/", SortBySize, ListOptions{MinSize: ptr(int64(1000))}); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := exploreRepo.GetPathContents(ctx, "a/", SortBySize, ListOptions{}); err != nil || len(contents) != 5 {
+		t.Errorf("Expected unfiltered contents, got %v, %v", contents, err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
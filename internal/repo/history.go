@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// historyWindow is the granularity of directory history, diffs are computed between whole windows
+const historyWindow = time.Hour
+
+// historySchema is part of the schema, and added to databases created before directory changes were recorded
+const historySchema = `
+	CREATE TABLE directory_history (
+		bucket			TEXT NOT NULL,
+		name			TEXT NOT NULL,
+		parent			TEXT,
+		window_start	INTEGER NOT NULL, -- unix time of the hour
+		size_delta		INTEGER DEFAULT 0,
+		count_delta		INTEGER DEFAULT 0,
+		PRIMARY KEY (bucket, name, window_start)
+	);
+
+	CREATE INDEX directory_history_parent ON directory_history (parent, window_start);
+`
+
+type History struct {
+	*Database
+}
+
+type HistoryRepository interface {
+	GetDiff(ctx context.Context, prefix string, from time.Time, to time.Time) ([]*model.DirectoryDelta, error)
+	GetStale(ctx context.Context, prefix string, since time.Time) ([]*model.StaleDirectory, error)
+	GetSeries(ctx context.Context, name string, from time.Time, to time.Time) ([]*model.DirectoryPoint, error)
+}
+
+func NewHistoryRepository(db *Database) HistoryRepository {
+	return &History{db}
+}
+
+// recordHistory adds a change of a directory's totals to the history window of now
+func recordHistory(ctx context.Context, tx *sql.Tx, bucket string, dirName string, sizeDelta int64, countDelta int64, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO directory_history (bucket, name, parent, window_start, size_delta, count_delta)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT(bucket, name, window_start)
+		DO UPDATE SET size_delta = size_delta + $5,
+			count_delta = count_delta + $6;
+	`, bucket, dirName, getParentDir(dirName), now.Truncate(historyWindow).Unix(), sizeDelta, countDelta)
+	return err
+}
+
+// GetDiff returns the size and count changes of every child directory of prefix between from and to,
+// ordered from the directory that grew the most
+// Changes are recorded per hour, so the diff covers every hour overlapping [from, to)
+func (h *History) GetDiff(ctx context.Context, prefix string, from time.Time, to time.Time) ([]*model.DirectoryDelta, error) {
+	query := `
+		SELECT name, SUM(size_delta) AS size_delta, SUM(count_delta) AS count_delta
+		FROM directory_history
+		WHERE parent = $1 AND name != parent
+			AND window_start >= $2 AND window_start < $3
+		GROUP BY name
+		HAVING SUM(size_delta) != 0 OR SUM(count_delta) != 0
+		ORDER BY size_delta DESC, name;
+	`
+
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+
+	deltas := []*model.DirectoryDelta{}
+	if err := h.reader(ctx).SelectContext(ctx, &deltas, query, prefix, from.Truncate(historyWindow).Unix(), to.Unix()); err != nil {
+		return nil, translateError(err)
+	}
+	return deltas, nil
+}
+
+// GetStale returns the non empty child directories of prefix which have not changed since a given time,
+// ordered from the largest directory
+// Directories last changed before history was recorded have a zero LastChange
+func (h *History) GetStale(ctx context.Context, prefix string, since time.Time) ([]*model.StaleDirectory, error) {
+	type staleRow struct {
+		Name       string        `db:"name"`
+		Size       int64         `db:"size"`
+		Count      int64         `db:"count"`
+		LastChange sql.NullInt64 `db:"last_change"`
+	}
+
+	query := `
+		SELECT d.name,
+			d.size_standard + d.size_nearline + d.size_coldline + d.size_archive AS size,
+			d.count,
+			MAX(h.window_start) AS last_change
+		FROM directory d
+		LEFT JOIN directory_history h ON h.bucket = d.bucket AND h.name = d.name
+		WHERE d.parent = $1 AND d.name != d.parent AND d.count > 0
+		GROUP BY d.bucket, d.name
+		HAVING last_change IS NULL OR last_change < $2
+		ORDER BY size DESC, d.name;
+	`
+
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+
+	var rows []staleRow
+	if err := h.reader(ctx).SelectContext(ctx, &rows, query, prefix, since.Truncate(historyWindow).Unix()); err != nil {
+		return nil, translateError(err)
+	}
+
+	stale := make([]*model.StaleDirectory, len(rows))
+	for i, row := range rows {
+		stale[i] = &model.StaleDirectory{Name: row.Name, Size: row.Size, Count: row.Count}
+		if row.LastChange.Valid {
+			stale[i].LastChange = time.Unix(row.LastChange.Int64, 0).UTC()
+		}
+	}
+	return stale, nil
+}
+
+// GetSeries returns the totals of a directory over time, starting with its totals at from
+// followed by a point for every history window in [from, to) in which it changed
+// Totals are derived from the current totals by reverting the changes recorded since
+func (h *History) GetSeries(ctx context.Context, name string, from time.Time, to time.Time) ([]*model.DirectoryPoint, error) {
+	type deltaRow struct {
+		WindowStart int64 `db:"window_start"`
+		SizeDelta   int64 `db:"size_delta"`
+		CountDelta  int64 `db:"count_delta"`
+	}
+
+	totalsQuery := `
+		SELECT
+			COALESCE(SUM(size_standard + size_nearline + size_coldline + size_archive), 0),
+			COALESCE(SUM(count), 0)
+		FROM directory
+		WHERE name = $1;
+	`
+
+	deltasQuery := `
+		SELECT window_start, SUM(size_delta) AS size_delta, SUM(count_delta) AS count_delta
+		FROM directory_history
+		WHERE name = $1 AND window_start >= $2
+		GROUP BY window_start
+		ORDER BY window_start DESC;
+	`
+
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+
+	// Read both in one transaction so writes in between can't skew the derived totals
+	var size, count int64
+	var deltas []deltaRow
+	err := h.withReadTx(ctx, func(r reader) error {
+		if err := r.QueryRowContext(ctx, totalsQuery, name).Scan(&size, &count); err != nil {
+			return err
+		}
+		return r.SelectContext(ctx, &deltas, deltasQuery, name, from.Truncate(historyWindow).Unix())
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	var points []*model.DirectoryPoint
+	for _, d := range deltas {
+		if windowStart := time.Unix(d.WindowStart, 0).UTC(); windowStart.Before(to) {
+			points = append(points, &model.DirectoryPoint{
+				Time:       windowStart,
+				Size:       size,
+				Count:      count,
+				SizeDelta:  d.SizeDelta,
+				CountDelta: d.CountDelta,
+			})
+		}
+		size -= d.SizeDelta
+		count -= d.CountDelta
+	}
+	points = append(points, &model.DirectoryPoint{Time: from, Size: size, Count: count})
+
+	slices.Reverse(points)
+	return points, nil
+}
@@ -0,0 +1,276 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetDiff(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	historyRepo := NewHistoryRepository(db)
+
+	lastWeek := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	yesterday := lastWeek.Add(6 * 24 * time.Hour)
+
+	changes := []struct {
+		dir        string
+		sizeDelta  int64
+		countDelta int64
+		at         time.Time
+	}{
+		{"logs/", 100, 2, lastWeek},
+		{"logs/2024/", 100, 2, lastWeek},
+		{"data/", 50, 1, lastWeek},
+		{"logs/", 400, 4, yesterday},
+		{"logs/2024/", 400, 4, yesterday},
+		{"data/", -50, -1, yesterday},
+		{"tmp/", 10, 1, yesterday},
+		{"tmp/", -10, -1, yesterday},
+		{"/", 500, 7, yesterday},
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if err := recordHistory(ctx, tx, "mock", c.dir, c.sizeDelta, c.countDelta, c.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	type delta struct {
+		name  string
+		size  int64
+		count int64
+	}
+
+	testCases := []struct {
+		name   string
+		prefix string
+		from   time.Time
+		to     time.Time
+		want   []delta
+	}{
+		{"Whole range", "/", lastWeek, yesterday.Add(time.Minute), []delta{{"logs/", 500, 6}}},
+		{"Last day", "/", yesterday.Add(-24 * time.Hour), yesterday.Add(time.Minute), []delta{{"logs/", 400, 4}, {"data/", -50, -1}}},
+		{"First hour only", "/", lastWeek, lastWeek.Add(time.Hour), []delta{{"logs/", 100, 2}, {"data/", 50, 1}}},
+		{"Nested prefix", "logs/", lastWeek, yesterday.Add(time.Minute), []delta{{"logs/2024/", 500, 6}}},
+		{"No changes", "/", lastWeek.Add(time.Hour), yesterday.Add(-time.Hour), []delta{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := historyRepo.GetDiff(ctx, tc.prefix, tc.from, tc.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("Directory count mismatch: got %d, want %d", len(got), len(tc.want))
+			}
+
+			for i, want := range tc.want {
+				if got[i].Name != want.name || got[i].SizeDelta != want.size || got[i].CountDelta != want.count {
+					t.Errorf("Delta %d mismatch: got %+v, want %+v", i, *got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpsertParentDirsRecordsHistory(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	historyRepo := NewHistoryRepository(db)
+
+	since := time.Now()
+	if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", "a/b/c", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.UpsertParentDirs(ctx, StorageNearline, "mock", "a/d", 5, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := historyRepo.GetDiff(ctx, "a/", since, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Name != "a/b/" || got[0].SizeDelta != 10 || got[0].CountDelta != 1 {
+		t.Errorf("Diff mismatch: got %+v", got)
+	}
+}
+
+func TestGetStale(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	historyRepo := NewHistoryRepository(db)
+
+	if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", "fresh/a", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories written before their history was recorded or long ago
+	lastChange := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []struct {
+		name string
+		size int64
+	}{{"old/", 20}, {"seeded/", 30}, {"empty/", 0}} {
+		count := int64(1)
+		if dir.size == 0 {
+			count = 0
+		}
+		if _, err := db.Exec(`INSERT INTO directory (bucket, name, size_standard, count, parent) VALUES ('mock', $1, $2, $3, '/')`, dir.name, dir.size, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordHistory(ctx, tx, "mock", "old/", 20, 1, lastChange); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := historyRepo.GetStale(ctx, "/", time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Return count mismatch: got %d, want %d", len(got), 2)
+	}
+
+	if got[0].Name != "seeded/" || !got[0].LastChange.IsZero() {
+		t.Errorf("First directory mismatch: got %+v", *got[0])
+	}
+
+	if got[1].Name != "old/" || !got[1].LastChange.Equal(lastChange) || got[1].Size != 20 {
+		t.Errorf("Second directory mismatch: got %+v", *got[1])
+	}
+}
+
+func TestGetSeries(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	historyRepo := NewHistoryRepository(db)
+
+	// logs/ holds 100 bytes in 1 object, written over the last three hours
+	now := time.Now().UTC().Truncate(time.Hour)
+	if _, err := db.Exec(`INSERT INTO directory (bucket, name, size_standard, size_archive, count, parent) VALUES ('mock', 'logs/', 60, 40, 1, '/')`); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		at         time.Time
+		sizeDelta  int64
+		countDelta int64
+	}{
+		{now.Add(-2 * time.Hour), 150, 3},
+		{now.Add(-time.Hour), -80, -2},
+		{now, 30, 0},
+	} {
+		if err := recordHistory(ctx, tx, "mock", "logs/", c.sizeDelta, c.countDelta, c.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		dir       string
+		from      time.Time
+		to        time.Time
+		wantSizes []int64
+	}{
+		{"Whole history", "logs/", now.Add(-3 * time.Hour), now.Add(time.Minute), []int64{0, 150, 70, 100}},
+		{"Excludes changes after to", "logs/", now.Add(-3 * time.Hour), now.Add(-time.Minute), []int64{0, 150, 70}},
+		{"Starts from totals at from", "logs/", now.Add(-time.Hour), now.Add(time.Minute), []int64{150, 70, 100}},
+		{"Unknown directory", "mock/", now.Add(-time.Hour), now, []int64{0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := historyRepo.GetSeries(ctx, tc.dir, tc.from, tc.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.wantSizes) {
+				t.Fatalf("Point count mismatch: got %d, want %d", len(got), len(tc.wantSizes))
+			}
+
+			if !got[0].Time.Equal(tc.from) {
+				t.Errorf("First point time mismatch: got %v, want %v", got[0].Time, tc.from)
+			}
+
+			for i, want := range tc.wantSizes {
+				if got[i].Size != want {
+					t.Errorf("Point %d size mismatch: got %d, want %d", i, got[i].Size, want)
+				}
+			}
+		})
+	}
+}
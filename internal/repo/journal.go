@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// journalSchema is part of the schema, and added to databases created before notifications were journaled
+const journalSchema = `
+	-- Messages applied per subscription, so redeliveries are recognized and skipped
+	CREATE TABLE ingest_journal (
+		subscription	TEXT NOT NULL,
+		message_id		TEXT NOT NULL,
+		published		INTEGER NOT NULL, -- unix milliseconds
+		PRIMARY KEY (subscription, message_id)
+	);
+
+	CREATE INDEX ingest_journal_published ON ingest_journal (subscription, published);
+
+	-- Publish time before which every message of a subscription received so far was applied
+	CREATE TABLE ingest_checkpoint (
+		subscription	TEXT NOT NULL PRIMARY KEY,
+		watermark		INTEGER NOT NULL, -- unix milliseconds
+		updated			TIMESTAMP NOT NULL
+	);
+`
+
+type Journal struct {
+	*Database
+}
+
+type JournalRepository interface {
+	ApplyOnce(ctx context.Context, subscription, messageID string, published, watermark time.Time, apply func(ctx context.Context) error) error
+	Checkpoint(ctx context.Context, subscription string) (time.Time, error)
+	Prune(ctx context.Context, subscription string, before time.Time) (int64, error)
+	Rewind(ctx context.Context, subscription string, since time.Time) (int64, error)
+}
+
+func NewJournalRepository(db *Database) JournalRepository {
+	return &Journal{db}
+}
+
+// ApplyOnce runs the writes of apply, journals message messageID of subscription and moves the checkpoint of
+// subscription to watermark, all in one transaction
+// It returns ErrConflict without running apply if the message was journaled already
+func (j *Journal) ApplyOnce(ctx context.Context, subscription, messageID string, published, watermark time.Time, apply func(ctx context.Context) error) error {
+	return j.Atomically(ctx, func(ctx context.Context) error {
+		var journaled bool
+		err := j.reader(ctx).QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM ingest_journal WHERE subscription = $1 AND message_id = $2);
+		`, subscription, messageID).Scan(&journaled)
+		if err != nil {
+			return err
+		}
+		if journaled {
+			return ErrConflict
+		}
+
+		if err := apply(ctx); err != nil {
+			return err
+		}
+
+		return j.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO ingest_journal (subscription, message_id, published)
+				VALUES ($1, $2, $3);
+			`, subscription, messageID, published.UnixMilli()); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO ingest_checkpoint (subscription, watermark, updated)
+				VALUES ($1, $2, $3)
+				ON CONFLICT(subscription)
+				DO UPDATE SET watermark = $2, updated = $3;
+			`, subscription, watermark.UnixMilli(), j.clock.Now())
+			return err
+		})
+	})
+}
+
+// Checkpoint returns the watermark of subscription, or ErrNotFound if none of its messages was journaled
+func (j *Journal) Checkpoint(ctx context.Context, subscription string) (time.Time, error) {
+	ctx, cancel := j.withTimeout(ctx)
+	defer cancel()
+
+	var watermark int64
+	err := j.reader(ctx).QueryRowContext(ctx, `SELECT watermark FROM ingest_checkpoint WHERE subscription = $1;`, subscription).Scan(&watermark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, translateError(err)
+	}
+	return time.UnixMilli(watermark), nil
+}
+
+// Prune forgets the messages of subscription published before before, returning how many
+func (j *Journal) Prune(ctx context.Context, subscription string, before time.Time) (int64, error) {
+	var pruned int64
+	err := j.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM ingest_journal WHERE subscription = $1 AND published < $2;`, subscription, before.UnixMilli())
+		if err != nil {
+			return err
+		}
+		pruned, err = res.RowsAffected()
+		return err
+	})
+	return pruned, err
+}
+
+// Rewind forgets the messages of subscription published since since and moves its checkpoint back to since, so
+// the messages replayed from then on are applied again, once, returning how many were forgotten
+// A zero since forgets every message and the checkpoint
+func (j *Journal) Rewind(ctx context.Context, subscription string, since time.Time) (int64, error) {
+	var forgotten int64
+	err := j.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if since.IsZero() {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM ingest_checkpoint WHERE subscription = $1;`, subscription); err != nil {
+				return err
+			}
+		} else if _, err := tx.ExecContext(ctx, `
+			UPDATE ingest_checkpoint
+			SET watermark = MIN(watermark, $1), updated = $2
+			WHERE subscription = $3;
+		`, since.UnixMilli(), j.clock.Now(), subscription); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM ingest_journal WHERE subscription = $1 AND published >= $2;`, subscription, since.UnixMilli())
+		if err != nil {
+			return err
+		}
+		forgotten, err = res.RowsAffected()
+		return err
+	})
+	return forgotten, err
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestJournal(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	journalRepo := NewJournalRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+
+	if _, err := journalRepo.Checkpoint(ctx, "sub"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	published := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	obj := &model.Metadata{Bucket: "mock", Name: "a/file", Size: 10, StorageClass: "STANDARD", Created: published, Updated: published}
+	insert := func(ctx context.Context) error {
+		return metadataRepo.Insert(ctx, obj)
+	}
+
+	if err := journalRepo.ApplyOnce(ctx, "sub", "1", published, published, insert); err != nil {
+		t.Fatal(err)
+	}
+	// Redeliveries are recognized without applying them again
+	if err := journalRepo.ApplyOnce(ctx, "sub", "1", published, published, insert); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict journaling a message twice, got %v", err)
+	}
+
+	// Failing applies journal nothing, and roll back the writes made before failing
+	other := *obj
+	other.Name = "b/file"
+	err := journalRepo.ApplyOnce(ctx, "sub", "2", published.Add(time.Second), published.Add(time.Second), func(ctx context.Context) error {
+		if err := metadataRepo.Insert(ctx, &other); err != nil {
+			return err
+		}
+		if _, err := metadataRepo.Get(ctx, "mock", "b/file"); err != nil {
+			t.Errorf("Expected writes to be read within the transaction, got %v", err)
+		}
+		return errors.New("mock error")
+	})
+	if err == nil {
+		t.Fatal("Expected the error of the apply")
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "b/file"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the writes of the failed apply to be rolled back, got %v", err)
+	}
+
+	if watermark, err := journalRepo.Checkpoint(ctx, "sub"); err != nil || !watermark.Equal(published) {
+		t.Errorf("Checkpoint mismatch: got %v, %v, want %v", watermark, err, published)
+	}
+
+	// Rewinding forgets the messages replayed and moves the checkpoint back
+	later := published.Add(time.Hour)
+	if err := journalRepo.ApplyOnce(ctx, "sub", "3", later, later, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if forgotten, err := journalRepo.Rewind(ctx, "sub", later.Add(-time.Minute)); err != nil || forgotten != 1 {
+		t.Errorf("Rewind mismatch: got %d, %v", forgotten, err)
+	}
+	if watermark, err := journalRepo.Checkpoint(ctx, "sub"); err != nil || !watermark.Equal(later.Add(-time.Minute)) {
+		t.Errorf("Checkpoint after rewind mismatch: got %v, %v", watermark, err)
+	}
+	if err := journalRepo.ApplyOnce(ctx, "sub", "3", later, later, func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("Expected the rewound message to be applied again, got %v", err)
+	}
+
+	if pruned, err := journalRepo.Prune(ctx, "sub", published.Add(time.Millisecond)); err != nil || pruned != 1 {
+		t.Errorf("Prune mismatch: got %d, %v", pruned, err)
+	}
+
+	// Rewinding to the zero time forgets every message and the checkpoint
+	if forgotten, err := journalRepo.Rewind(ctx, "sub", time.Time{}); err != nil || forgotten != 1 {
+		t.Errorf("Rewind mismatch: got %d, %v", forgotten, err)
+	}
+	if _, err := journalRepo.Checkpoint(ctx, "sub"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after rewinding everything, got %v", err)
+	}
+}
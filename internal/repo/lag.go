@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// lagSchema is part of the schema, and added to databases created before notification lag was measured
+const lagSchema = `
+	CREATE TABLE notification_lag (
+		bucket			TEXT NOT NULL,
+		prefix			TEXT NOT NULL,
+		window_start	INTEGER NOT NULL, -- unix time of the window
+		events			INTEGER DEFAULT 0,
+		total_lag		INTEGER DEFAULT 0, -- nanoseconds
+		max_lag			INTEGER DEFAULT 0,
+		PRIMARY KEY (bucket, prefix, window_start)
+	);
+`
+
+// summaryLagWindow is the window of the notification lag reported in summaries
+const summaryLagWindow = time.Hour
+
+// RecordLag counts a notification of an object arriving lag after its write, in the current window of the object's
+// top level prefix, negative lags from skewed clocks counting as none
+// Windows older than StatsRetention are pruned once per window
+func (s *Stats) RecordLag(ctx context.Context, bucket string, objName string, lag time.Duration) error {
+	lag = max(lag, 0)
+	window := s.clock.Now().Truncate(statsWindow)
+
+	return s.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_lag (bucket, prefix, window_start, events, total_lag, max_lag)
+			VALUES ($1, $2, $3, 1, $4, $4)
+			ON CONFLICT(bucket, prefix, window_start)
+			DO UPDATE SET events = events + 1,
+				total_lag = total_lag + $4,
+				max_lag = MAX(max_lag, $4);
+		`, bucket, getTopLevelPrefix(objName), window.Unix(), int64(lag)); err != nil {
+			return err
+		}
+
+		if !window.After(s.lagPruned) {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM notification_lag WHERE window_start < $1;`, window.Add(-StatsRetention).Unix()); err != nil {
+			return err
+		}
+		s.lagPruned = window
+		return nil
+	})
+}
+
+// lagRow is the sum of the lags of notifications in a set of windows
+type lagRow struct {
+	Bucket     string `db:"bucket"`
+	Prefix     string `db:"prefix"`
+	DualRegion bool   `db:"dual_region"`
+	Events     int64  `db:"events"`
+	TotalLag   int64  `db:"total_lag"`
+	MaxLag     int64  `db:"max_lag"`
+}
+
+func (r lagRow) stats() model.LagStats {
+	stats := model.LagStats{Events: r.Events, MaxLag: model.Duration(r.MaxLag)}
+	if r.Events > 0 {
+		stats.MeanLag = model.Duration(r.TotalLag / r.Events)
+	}
+	return stats
+}
+
+// GetNotificationLag returns the lag of notifications per bucket and top level prefix since a given time,
+// ordered from the latest prefix
+func (s *Stats) GetNotificationLag(ctx context.Context, since time.Time) ([]*model.PrefixLag, error) {
+	query := `
+		SELECT
+			bucket,
+			prefix,
+			COALESCE((SELECT location_type = 'dual-region' FROM bucket WHERE bucket.name = notification_lag.bucket), FALSE) AS dual_region,
+			SUM(events) AS events,
+			SUM(total_lag) AS total_lag,
+			MAX(max_lag) AS max_lag
+		FROM notification_lag
+		WHERE window_start >= $1
+		GROUP BY bucket, prefix
+		ORDER BY max_lag DESC, bucket, prefix;
+	`
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows := []lagRow{}
+	if err := s.reader(ctx).SelectContext(ctx, &rows, query, since.Truncate(statsWindow).Unix()); err != nil {
+		return nil, translateError(err)
+	}
+
+	lags := make([]*model.PrefixLag, len(rows))
+	for i, row := range rows {
+		lags[i] = &model.PrefixLag{Bucket: row.Bucket, Prefix: row.Prefix, DualRegion: row.DualRegion, LagStats: row.stats()}
+	}
+	return lags, nil
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestNotificationLag(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	statsRepo := NewStatsRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	if err := NewBucketRepository(db).Upsert(ctx, model.Bucket{Name: "replicated", Location: "NAM4", LocationType: "dual-region"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lag := range []struct {
+		bucket, name string
+		lag          time.Duration
+	}{
+		{"replicated", "logs/a", time.Second},
+		{"replicated", "logs/b/c", 3 * time.Second},
+		{"replicated", "f", -time.Second},
+		{"mock", "logs/d", 10 * time.Second},
+	} {
+		if err := statsRepo.RecordLag(ctx, lag.bucket, lag.name, lag.lag); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, lag.bucket, lag.name, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := statsRepo.GetNotificationLag(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.PrefixLag{
+		{Bucket: "mock", Prefix: "logs/", LagStats: model.LagStats{Events: 1, MeanLag: model.Duration(10 * time.Second), MaxLag: model.Duration(10 * time.Second)}},
+		{Bucket: "replicated", Prefix: "logs/", DualRegion: true, LagStats: model.LagStats{Events: 2, MeanLag: model.Duration(2 * time.Second), MaxLag: model.Duration(3 * time.Second)}},
+		// Negative lags from skewed clocks count as none
+		{Bucket: "replicated", Prefix: "/", DualRegion: true, LagStats: model.LagStats{Events: 1}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Lag count mismatch: got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("Lag %d mismatch: got %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+
+	if got, err := statsRepo.GetNotificationLag(ctx, time.Now().Add(time.Hour)); err != nil || len(got) != 0 {
+		t.Errorf("Expected no lag in a later window, got %v, %v", got, err)
+	}
+
+	// Summaries report the lag of the top level prefix of their path, every prefix for the root
+	for _, tc := range []struct {
+		path       string
+		wantEvents int64
+		wantMax    time.Duration
+	}{
+		{"logs/b/", 3, 10 * time.Second},
+		{"/", 4, 10 * time.Second},
+	} {
+		summary, err := exploreRepo.GetPathSummary(ctx, tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.NotificationLag == nil || summary.NotificationLag.Events != tc.wantEvents || time.Duration(summary.NotificationLag.MaxLag) != tc.wantMax {
+			t.Errorf("Summary lag of %s mismatch: got %+v", tc.path, summary.NotificationLag)
+		}
+	}
+}
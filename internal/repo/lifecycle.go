@@ -0,0 +1,325 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	LifecycleDelete          = "Delete"
+	LifecycleSetStorageClass = "SetStorageClass"
+
+	createdBeforeLayout = "2006-01-02"
+)
+
+type AgeField string
+
+const (
+	AgeByCustomTime AgeField = "custom_time"
+	AgeByCreated    AgeField = "created"
+	AgeByUpdated    AgeField = "updated"
+)
+
+// ageBucketDays are the upper bounds in days of the age histogram buckets, the last bucket being unbounded
+var ageBucketDays = []int64{30, 90, 365}
+
+type Lifecycle struct {
+	*Database
+}
+
+type LifecycleRepository interface {
+	SimulateLifecycle(ctx context.Context, bucket, prefix string, policy *model.LifecyclePolicy, now time.Time) ([]*model.LifecycleSimulation, error)
+	GetAgeHistogram(ctx context.Context, bucket, prefix string, by AgeField, now time.Time) ([]*model.AgeBucket, error)
+	RecommendLifecycle(ctx context.Context, prefix string, now time.Time) (*model.LifecycleRecommendations, error)
+}
+
+func NewLifecycleRepository(db *Database) LifecycleRepository {
+	return &Lifecycle{db}
+}
+
+// ValidateLifecyclePolicy checks that every rule uses a supported action and condition
+func ValidateLifecyclePolicy(policy *model.LifecyclePolicy) error {
+	if policy == nil || len(policy.Rules) == 0 {
+		return errors.New("policy must contain at least one rule")
+	}
+
+	for i, rule := range policy.Rules {
+		switch rule.Action.Type {
+		case LifecycleDelete:
+		case LifecycleSetStorageClass:
+			if _, ok := storageTier(StorageClass(rule.Action.StorageClass)); !ok {
+				return fmt.Errorf("rule %d: invalid storage class %q", i, rule.Action.StorageClass)
+			}
+		default:
+			return fmt.Errorf("rule %d: unsupported action type %q", i, rule.Action.Type)
+		}
+
+		cond := rule.Condition
+		if cond.Age != nil && *cond.Age < 0 {
+			return fmt.Errorf("rule %d: age must not be negative", i)
+		}
+		if len(cond.CreatedBefore) > 0 {
+			if _, err := time.Parse(createdBeforeLayout, cond.CreatedBefore); err != nil {
+				return fmt.Errorf("rule %d: createdBefore must be formatted as YYYY-MM-DD", i)
+			}
+		}
+		if cond.DaysSinceCustomTime != nil && *cond.DaysSinceCustomTime < 0 {
+			return fmt.Errorf("rule %d: daysSinceCustomTime must not be negative", i)
+		}
+		if len(cond.CustomTimeBefore) > 0 {
+			if _, err := time.Parse(createdBeforeLayout, cond.CustomTimeBefore); err != nil {
+				return fmt.Errorf("rule %d: customTimeBefore must be formatted as YYYY-MM-DD", i)
+			}
+		}
+		for _, class := range cond.MatchesStorageClass {
+			if _, ok := storageTier(StorageClass(class)); !ok {
+				return fmt.Errorf("rule %d: invalid storage class %q", i, class)
+			}
+		}
+	}
+	return nil
+}
+
+// SimulateLifecycle evaluates a lifecycle policy against every object of bucket under prefix
+// and aggregates the objects that would be deleted or transitioned per child prefix
+func (l *Lifecycle) SimulateLifecycle(ctx context.Context, bucket, prefix string, policy *model.LifecyclePolicy, now time.Time) ([]*model.LifecycleSimulation, error) {
+	type objectRow struct {
+		Name         string     `db:"name"`
+		Size         int64      `db:"size"`
+		StorageClass string     `db:"storage_class"`
+		Created      time.Time  `db:"created"`
+		CustomTime   *time.Time `db:"custom_time"`
+	}
+
+	if err := ValidateLifecyclePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	if prefix == "/" {
+		prefix = "" // handle root
+	}
+
+	// Names starting with prefix range over the primary key, case-sensitively as GCS matches lifecycle prefixes
+	query := `
+		SELECT name, size, storage_class, created, custom_time
+		FROM metadata
+		WHERE bucket = $1 AND name >= $2 AND name < $3;
+	`
+
+	ctx, cancel := l.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := l.reader(ctx).QueryxContext(ctx, query, bucket, prefix, prefixEnd(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	simulations := make(map[string]*model.LifecycleSimulation)
+	for rows.Next() {
+		var row objectRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		deleted, target := evaluateLifecycle(policy, row.Name, StorageClass(row.StorageClass), row.Created, row.CustomTime, now)
+		if !deleted && len(target) == 0 {
+			continue
+		}
+
+		childPrefix := getChildPrefix(prefix, row.Name)
+		sim, ok := simulations[childPrefix]
+		if !ok {
+			sim = &model.LifecycleSimulation{
+				Prefix:      childPrefix,
+				Transitions: make(map[string]model.LifecycleImpact),
+			}
+			simulations[childPrefix] = sim
+		}
+
+		if deleted {
+			sim.Delete.Count++
+			sim.Delete.Size += row.Size
+			continue
+		}
+
+		impact := sim.Transitions[string(target)]
+		impact.Count++
+		impact.Size += row.Size
+		sim.Transitions[string(target)] = impact
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*model.LifecycleSimulation, 0, len(simulations))
+	for _, sim := range simulations {
+		results = append(results, sim)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Prefix < results[j].Prefix
+	})
+
+	return results, nil
+}
+
+// GetAgeHistogram counts the objects of bucket under prefix per age of a timestamp column, in days
+// Objects without a custom time are counted in a last bucket without bounds
+func (l *Lifecycle) GetAgeHistogram(ctx context.Context, bucket, prefix string, by AgeField, now time.Time) ([]*model.AgeBucket, error) {
+	if by != AgeByCustomTime && by != AgeByCreated && by != AgeByUpdated {
+		return nil, fmt.Errorf("unsupported age field %q", by)
+	}
+
+	if prefix == "/" {
+		prefix = "" // handle root
+	}
+
+	// by is one of the known column names, never user input
+	// Names starting with prefix range over the primary key, as for simulations of lifecycle policies
+	query := `
+		SELECT size, ` + string(by) + ` AS since
+		FROM metadata
+		WHERE bucket = $1 AND name >= $2 AND name < $3;
+	`
+
+	ctx, cancel := l.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := l.reader(ctx).QueryxContext(ctx, query, bucket, prefix, prefixEnd(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]*model.AgeBucket, 0, len(ageBucketDays)+2)
+	var minDays int64
+	for _, maxDays := range ageBucketDays {
+		buckets = append(buckets, &model.AgeBucket{Label: fmt.Sprintf("%d-%dd", minDays, maxDays), MinDays: minDays, MaxDays: &maxDays})
+		minDays = maxDays
+	}
+	buckets = append(buckets, &model.AgeBucket{Label: fmt.Sprintf("%dd+", minDays), MinDays: minDays})
+	none := &model.AgeBucket{Label: "none"}
+
+	for rows.Next() {
+		var row struct {
+			Size  int64      `db:"size"`
+			Since *time.Time `db:"since"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		bucket := none
+		if row.Since != nil {
+			ageDays := int64(now.Sub(*row.Since).Hours() / 24)
+			i := sort.Search(len(ageBucketDays), func(i int) bool { return ageDays < ageBucketDays[i] })
+			bucket = buckets[i]
+		}
+		bucket.Count++
+		bucket.Size += row.Size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only custom times are optional
+	if by == AgeByCustomTime {
+		buckets = append(buckets, none)
+	}
+	return buckets, nil
+}
+
+// evaluateLifecycle returns whether an object would be deleted, otherwise the storage class it would transition to
+// Delete takes precedence over SetStorageClass, and the storage class of the coldest tier wins
+func evaluateLifecycle(policy *model.LifecyclePolicy, name string, storageClass StorageClass, created time.Time, customTime *time.Time, now time.Time) (bool, StorageClass) {
+	var target StorageClass
+	for _, rule := range policy.Rules {
+		if !matchesCondition(rule.Condition, name, storageClass, created, customTime, now) {
+			continue
+		}
+
+		switch rule.Action.Type {
+		case LifecycleDelete:
+			return true, ""
+		case LifecycleSetStorageClass:
+			class := StorageClass(rule.Action.StorageClass)
+			if storageRank(class) <= storageRank(storageClass) {
+				continue // transitions only move objects to a colder tier
+			}
+			if len(target) == 0 || storageRank(class) > storageRank(target) {
+				target = class
+			}
+		}
+	}
+	return false, target
+}
+
+// matchesCondition reports whether an object satisfies every condition set on a rule
+// Objects without a custom time never satisfy conditions on it
+func matchesCondition(cond model.LifecycleCondition, name string, storageClass StorageClass, created time.Time, customTime *time.Time, now time.Time) bool {
+	if cond.Age != nil {
+		ageDays := int64(now.Sub(created).Hours() / 24)
+		if ageDays < *cond.Age {
+			return false
+		}
+	}
+
+	if len(cond.CreatedBefore) > 0 {
+		before, err := time.Parse(createdBeforeLayout, cond.CreatedBefore)
+		if err != nil || !created.Before(before) {
+			return false
+		}
+	}
+
+	if cond.DaysSinceCustomTime != nil {
+		if customTime == nil || int64(now.Sub(*customTime).Hours()/24) < *cond.DaysSinceCustomTime {
+			return false
+		}
+	}
+
+	if len(cond.CustomTimeBefore) > 0 {
+		before, err := time.Parse(createdBeforeLayout, cond.CustomTimeBefore)
+		if err != nil || customTime == nil || !customTime.Before(before) {
+			return false
+		}
+	}
+
+	if len(cond.MatchesStorageClass) > 0 && !slices.Contains(cond.MatchesStorageClass, string(storageClass)) {
+		return false
+	}
+
+	if len(cond.MatchesPrefix) > 0 && !slices.ContainsFunc(cond.MatchesPrefix, func(prefix string) bool {
+		return strings.HasPrefix(name, prefix)
+	}) {
+		return false
+	}
+
+	if len(cond.MatchesSuffix) > 0 && !slices.ContainsFunc(cond.MatchesSuffix, func(suffix string) bool {
+		return strings.HasSuffix(name, suffix)
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// getChildPrefix returns the immediate child directory of prefix containing name
+// Objects placed directly under prefix are grouped into prefix itself
+func getChildPrefix(prefix string, name string) string {
+	rest := strings.TrimPrefix(name, prefix)
+	if i := strings.Index(rest, "/"); i != -1 {
+		return prefix + rest[:i+1]
+	}
+
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
@@ -0,0 +1,351 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestSimulateLifecycle(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	lifecycleRepo := NewLifecycleRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.AddDate(0, 0, -days)
+	}
+	age := func(days int64) *int64 {
+		return &days
+	}
+	customTime := func(days int) *time.Time {
+		t := daysAgo(days)
+		return &t
+	}
+
+	// Insert mock data
+	metadata := []model.Metadata{
+		{Bucket: "mock", Name: "file1", Size: 10, StorageClass: "STANDARD", Created: daysAgo(100), Updated: daysAgo(100), CustomTime: customTime(10)},
+		{Bucket: "mock", Name: "file2.log", Size: 1, StorageClass: "STANDARD", Created: daysAgo(5), Updated: daysAgo(5)},
+		{Bucket: "mock", Name: "logs/file3.log", Size: 2, StorageClass: "STANDARD", Created: daysAgo(40), Updated: daysAgo(40)},
+		{Bucket: "mock", Name: "logs/file4.log", Size: 3, StorageClass: "NEARLINE", Created: daysAgo(400), Updated: daysAgo(400), CustomTime: customTime(200)},
+		{Bucket: "mock", Name: "logs/nested/file5.log", Size: 4, StorageClass: "ARCHIVE", Created: daysAgo(40), Updated: daysAgo(40)},
+		// Neither under logs/, which is case-sensitive, nor in the simulated bucket
+		{Bucket: "mock", Name: "LOGS/file6.log", Size: 16, StorageClass: "STANDARD", Created: daysAgo(1), Updated: daysAgo(1)},
+		{Bucket: "other", Name: "logs/file7.log", Size: 32, StorageClass: "STANDARD", Created: daysAgo(400), Updated: daysAgo(400)},
+	}
+
+	for _, m := range metadata {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		prefix  string
+		policy  *model.LifecyclePolicy
+		want    []*model.LifecycleSimulation
+		wantErr bool
+	}{
+		{
+			"Transitions objects older than age",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "NEARLINE"}, Condition: model.LifecycleCondition{Age: age(30)}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "/", Transitions: map[string]model.LifecycleImpact{"NEARLINE": {Count: 1, Size: 10}}},
+				{Prefix: "logs/", Transitions: map[string]model.LifecycleImpact{"NEARLINE": {Count: 1, Size: 2}}},
+			},
+			false,
+		},
+		{
+			"Delete takes precedence over transitions",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "COLDLINE"}, Condition: model.LifecycleCondition{Age: age(30)}},
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{Age: age(365)}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "/", Transitions: map[string]model.LifecycleImpact{"COLDLINE": {Count: 1, Size: 10}}},
+				{Prefix: "logs/", Delete: model.LifecycleImpact{Count: 1, Size: 3}, Transitions: map[string]model.LifecycleImpact{"COLDLINE": {Count: 1, Size: 2}}},
+			},
+			false,
+		},
+		{
+			"Coldest matching storage class wins",
+			"logs/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "NEARLINE"}, Condition: model.LifecycleCondition{Age: age(0)}},
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "ARCHIVE"}, Condition: model.LifecycleCondition{MatchesSuffix: []string{".log"}}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "logs/", Transitions: map[string]model.LifecycleImpact{"ARCHIVE": {Count: 2, Size: 5}}},
+			},
+			false,
+		},
+		{
+			"Matches storage class and prefix conditions",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{MatchesStorageClass: []string{"ARCHIVE", "NEARLINE"}, MatchesPrefix: []string{"logs/nested/"}}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "logs/", Delete: model.LifecycleImpact{Count: 1, Size: 4}},
+			},
+			false,
+		},
+		{
+			"Matches created before condition",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{CreatedBefore: "2024-01-01"}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "logs/", Delete: model.LifecycleImpact{Count: 1, Size: 3}},
+			},
+			false,
+		},
+		{
+			"Matches days since custom time condition",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{DaysSinceCustomTime: age(100)}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "logs/", Delete: model.LifecycleImpact{Count: 1, Size: 3}},
+			},
+			false,
+		},
+		{
+			"Matches custom time before condition",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "COLDLINE"}, Condition: model.LifecycleCondition{CustomTimeBefore: "2024-09-25"}},
+			}},
+			[]*model.LifecycleSimulation{
+				{Prefix: "/", Transitions: map[string]model.LifecycleImpact{"COLDLINE": {Count: 1, Size: 10}}},
+				{Prefix: "logs/", Transitions: map[string]model.LifecycleImpact{"COLDLINE": {Count: 1, Size: 3}}},
+			},
+			false,
+		},
+		{
+			"Returns empty when no objects match",
+			"non-existent/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{Age: age(0)}},
+			}},
+			[]*model.LifecycleSimulation{},
+			false,
+		},
+		{
+			"Returns error for invalid storage class",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "SetStorageClass", StorageClass: "invalid"}},
+			}},
+			nil,
+			true,
+		},
+		{
+			"Returns error for malformed custom time before",
+			"/",
+			&model.LifecyclePolicy{Rules: []model.LifecycleRule{
+				{Action: model.LifecycleAction{Type: "Delete"}, Condition: model.LifecycleCondition{CustomTimeBefore: "01/01/2024"}},
+			}},
+			nil,
+			true,
+		},
+		{
+			"Returns error for empty policy",
+			"/",
+			&model.LifecyclePolicy{},
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lifecycleRepo.SimulateLifecycle(context.Background(), "mock", tc.prefix, tc.policy, now)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wantErr {
+				t.Fatalf("Expected error but did pass")
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("Return count mismatch: got %d, want %d", len(got), len(tc.want))
+			}
+
+			for i := range got {
+				if got[i].Prefix != tc.want[i].Prefix {
+					t.Errorf("Prefix mismatch: got %s, want %s", got[i].Prefix, tc.want[i].Prefix)
+				}
+
+				if got[i].Delete != tc.want[i].Delete {
+					t.Errorf("%s delete mismatch: got %+v, want %+v", got[i].Prefix, got[i].Delete, tc.want[i].Delete)
+				}
+
+				if len(got[i].Transitions) != len(tc.want[i].Transitions) {
+					t.Errorf("%s transitions mismatch: got %+v, want %+v", got[i].Prefix, got[i].Transitions, tc.want[i].Transitions)
+				}
+
+				for class, impact := range tc.want[i].Transitions {
+					if got[i].Transitions[class] != impact {
+						t.Errorf("%s %s transition mismatch: got %+v, want %+v", got[i].Prefix, class, got[i].Transitions[class], impact)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGetChildPrefix(t *testing.T) {
+	testCases := []struct {
+		name   string
+		prefix string
+		in     string
+		want   string
+	}{
+		{"Root file", "", "file", "/"},
+		{"Root directory file", "", "mock-1/file", "mock-1/"},
+		{"Nested directory file", "mock-1/", "mock-1/mock-2/file", "mock-1/mock-2/"},
+		{"File directly in prefix", "mock-1/", "mock-1/file", "mock-1/"},
+		{"Trailing slash directory", "mock-1/", "mock-1//file", "mock-1//"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := getChildPrefix(tc.prefix, tc.in)
+			if got != tc.want {
+				t.Errorf("Child prefix mismatch: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetAgeHistogram(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	lifecycleRepo := NewLifecycleRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		t := now.AddDate(0, 0, -days)
+		return &t
+	}
+
+	metadata := []model.Metadata{
+		{Bucket: "mock", Name: "file1", Size: 1, StorageClass: "STANDARD", Created: *daysAgo(400), Updated: *daysAgo(5), CustomTime: daysAgo(10)},
+		{Bucket: "mock", Name: "logs/file2", Size: 2, StorageClass: "STANDARD", Created: *daysAgo(400), Updated: *daysAgo(5), CustomTime: daysAgo(30)},
+		{Bucket: "mock", Name: "logs/file3", Size: 4, StorageClass: "STANDARD", Created: *daysAgo(100), Updated: *daysAgo(5), CustomTime: daysAgo(500)},
+		{Bucket: "mock", Name: "logs/file4", Size: 8, StorageClass: "STANDARD", Created: *daysAgo(100), Updated: *daysAgo(5)},
+		{Bucket: "other", Name: "logs/file5", Size: 16, StorageClass: "STANDARD", Created: *daysAgo(100), Updated: *daysAgo(5)},
+	}
+
+	for _, m := range metadata {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type bucket struct {
+		label string
+		count int64
+		size  int64
+	}
+
+	testCases := []struct {
+		name    string
+		prefix  string
+		by      AgeField
+		want    []bucket
+		wantErr bool
+	}{
+		{
+			"Buckets by custom time",
+			"/",
+			AgeByCustomTime,
+			[]bucket{{"0-30d", 1, 1}, {"30-90d", 1, 2}, {"90-365d", 0, 0}, {"365d+", 1, 4}, {"none", 1, 8}},
+			false,
+		},
+		{
+			"Buckets by creation under prefix",
+			"logs/",
+			AgeByCreated,
+			[]bucket{{"0-30d", 0, 0}, {"30-90d", 0, 0}, {"90-365d", 2, 12}, {"365d+", 1, 2}},
+			false,
+		},
+		{
+			"Prefixes match case-sensitively and without wildcards",
+			"LOG_/",
+			AgeByCreated,
+			[]bucket{{"0-30d", 0, 0}, {"30-90d", 0, 0}, {"90-365d", 0, 0}, {"365d+", 0, 0}},
+			false,
+		},
+		{
+			"Unsupported field",
+			"/",
+			AgeField("name"),
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lifecycleRepo.GetAgeHistogram(context.Background(), "mock", tc.prefix, tc.by, now)
+			if err != nil {
+				if tc.wantErr {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wantErr {
+				t.Fatalf("Expected error but did pass")
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("Return count mismatch: got %d, want %d", len(got), len(tc.want))
+			}
+
+			for i, w := range tc.want {
+				if got[i].Label != w.label || got[i].Count != w.count || got[i].Size != w.size {
+					t.Errorf("Bucket %d mismatch: got %+v, want %+v", i, got[i], w)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package repo
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -12,82 +15,328 @@ type Metadata struct {
 }
 
 type MetadataRepository interface {
-	Insert(*model.Metadata) error
-	Update(bucket, name string, size int64, updated time.Time) error
-	Delete(bucket, name string) error
+	Get(ctx context.Context, bucket, name string) (*model.Metadata, error)
+	GetMany(ctx context.Context, bucket string, names []string) ([]*model.Metadata, error)
+	Insert(ctx context.Context, obj *model.Metadata) error
+	Update(ctx context.Context, bucket, name string, size int64, customTime *time.Time, updated time.Time) error
+	Replace(ctx context.Context, current, obj *model.Metadata) error
+	Delete(ctx context.Context, bucket, name string) error
+	LastUpdated(ctx context.Context, bucket string) (time.Time, error)
+	ListPrefix(ctx context.Context, bucket, prefix string, recursive bool) ([]*model.Metadata, error)
+	TopLevelPrefixes(ctx context.Context, bucket string) ([]string, error)
+	SetDetectedType(ctx context.Context, bucket, name, detectedType string) error
 }
 
 func NewMetadataRepository(db *Database) MetadataRepository {
 	return &Metadata{db}
 }
 
-func (m *Metadata) Insert(obj *model.Metadata) error {
+// Get returns an indexed object, or ErrNotFound
+func (m *Metadata) Get(ctx context.Context, bucket, name string) (*model.Metadata, error) {
 	query := `
-		INSERT INTO metadata 
-		(bucket, name, size, storage_class, created, updated)	
-		VALUES (?, ?, ?, ?, ?, ?);
+		SELECT bucket, name, parent, size, storage_class, created, updated, custom_time, COALESCE(detected_type, '') AS detected_type, marker,
+			generation, metageneration, event_time
+		FROM metadata
+		WHERE bucket = ? AND name = ?;
+	`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var obj model.Metadata
+	err := m.reader(ctx).GetContext(ctx, &obj, query, bucket, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &obj, nil
+}
+
+// GetMany returns the indexed objects of a bucket among names, sorted by name, leaving out the missing ones
+// Names are bound as a single JSON array, so any number of them is looked up in one statement
+func (m *Metadata) GetMany(ctx context.Context, bucket string, names []string) ([]*model.Metadata, error) {
+	query := `
+		SELECT bucket, name, parent, size, storage_class, created, updated, custom_time, COALESCE(detected_type, '') AS detected_type, marker,
+			generation, metageneration, event_time
+		FROM metadata
+		WHERE bucket = ? AND name IN (SELECT value FROM json_each(?))
+		ORDER BY name;
 	`
 
+	encoded, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	objects := []*model.Metadata{}
+	if err := m.reader(ctx).SelectContext(ctx, &objects, query, bucket, string(encoded)); err != nil {
+		return nil, translateError(err)
+	}
+	return objects, nil
+}
+
+func (m *Metadata) Insert(ctx context.Context, obj *model.Metadata) error {
 	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
 		return errors.New("bucket or name argument is empty")
 	}
 
-	if _, err := m.DB.Exec(query,
+	return m.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return insertMetadata(ctx, tx, obj)
+	})
+}
+
+// insertMetadata applies Insert within tx
+func insertMetadata(ctx context.Context, tx *sql.Tx, obj *model.Metadata) error {
+	query := `
+		INSERT INTO metadata 
+		(bucket, name, size, storage_class, created, updated, custom_time, generation, metageneration, event_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`
+
+	_, err := tx.ExecContext(ctx, query,
 		obj.Bucket,
 		obj.Name,
 		obj.Size,
 		obj.StorageClass,
 		obj.Created,
-		obj.Updated); err != nil {
+		obj.Updated,
+		obj.CustomTime,
+		obj.Generation,
+		obj.Metageneration,
+		obj.EventTime)
+	if err != nil || !sampled(obj.Name) {
 		return err
 	}
-	return nil
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO object_sample (bucket, name, size, storage_class, updated)
+		VALUES (?, ?, ?, ?, ?);
+	`, obj.Bucket, obj.Name, obj.Size, obj.StorageClass, obj.Updated)
+	return err
 }
 
-func (m *Metadata) Update(bucket string, name string, size int64, updated time.Time) error {
+// Update sets the size and custom time of an object, returning ErrStale if the stored object was updated later
+// The generation of the object is forgotten, conflicts with it being resolved by update time
+func (m *Metadata) Update(ctx context.Context, bucket string, name string, size int64, customTime *time.Time, updated time.Time) error {
 	query := `
 		UPDATE metadata
 		SET size = ?,
-			updated = ?
+			custom_time = ?,
+			updated = ?,
+			generation = 0,
+			metageneration = 0,
+			event_time = NULL
 		WHERE bucket = ? AND name = ?;
 	`
 
-	res, err := m.DB.Exec(query, size, updated, bucket, name)
-	if err != nil {
-		return err
-	}
+	return m.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var current time.Time
+		err := tx.QueryRowContext(ctx, `SELECT updated FROM metadata WHERE bucket = ? AND name = ?;`, bucket, name).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
+		if updated.Before(current) {
+			return ErrStale
+		}
+
+		_, err = tx.ExecContext(ctx, query, size, customTime, updated, bucket, name)
+		if err != nil || !sampled(name) {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE object_sample SET size = ?, updated = ? WHERE bucket = ? AND name = ?;`, size, updated, bucket, name)
 		return err
-	}
+	})
+}
 
-	if rowsAffected == 0 {
-		return errors.New("no rows affected")
-	}
+// Replace overwrites the version current of an object of the same storage class with obj, returning ErrStale if
+// the stored object is no longer current, such as when another writer replaced it since it was read
+func (m *Metadata) Replace(ctx context.Context, current, obj *model.Metadata) error {
+	query := `
+		UPDATE metadata
+		SET size = ?,
+			custom_time = ?,
+			updated = ?,
+			generation = ?,
+			metageneration = ?,
+			event_time = ?
+		WHERE bucket = ? AND name = ?;
+	`
+
+	return m.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var stored model.Metadata
+		err := tx.QueryRowContext(ctx, `SELECT updated, generation, metageneration FROM metadata WHERE bucket = ? AND name = ?;`,
+			current.Bucket, current.Name).Scan(&stored.Updated, &stored.Generation, &stored.Metageneration)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrStale
+		}
+		if err != nil {
+			return err
+		}
+		if !stored.Updated.Equal(current.Updated) || stored.Generation != current.Generation || stored.Metageneration != current.Metageneration {
+			return ErrStale
+		}
 
-	return nil
+		_, err = tx.ExecContext(ctx, query, obj.Size, obj.CustomTime, obj.Updated, obj.Generation, obj.Metageneration, obj.EventTime, obj.Bucket, obj.Name)
+		if err != nil || !sampled(obj.Name) {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE object_sample SET size = ?, updated = ? WHERE bucket = ? AND name = ?;`, obj.Size, obj.Updated, obj.Bucket, obj.Name)
+		return err
+	})
 }
 
-func (m *Metadata) Delete(bucket string, name string) error {
+func (m *Metadata) Delete(ctx context.Context, bucket string, name string) error {
 	query := `
 		DELETE FROM metadata
 		WHERE bucket = ? AND name = ?;	
 	`
 
-	res, err := m.DB.Exec(query, bucket, name)
-	if err != nil {
+	return m.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, bucket, name)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		if sampled(name) {
+			_, err = tx.ExecContext(ctx, `DELETE FROM object_sample WHERE bucket = ? AND name = ?;`, bucket, name)
+		}
 		return err
-	}
+	})
+}
+
+// SetDetectedType records the content type sniffed from an object, or returns ErrNotFound
+func (m *Metadata) SetDetectedType(ctx context.Context, bucket, name, detectedType string) error {
+	query := `
+		UPDATE metadata
+		SET detected_type = ?
+		WHERE bucket = ? AND name = ?;
+	`
+
+	return m.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, detectedType, bucket, name)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// LastUpdated returns the latest update time of the objects of a bucket, ErrNotFound if it has none
+func (m *Metadata) LastUpdated(ctx context.Context, bucket string) (time.Time, error) {
+	// MAX() would lose the column type, returning the timestamp as text
+	query := `
+		SELECT updated
+		FROM metadata
+		WHERE bucket = ?
+		ORDER BY updated DESC
+		LIMIT 1;
+	`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-	rowsAffected, err := res.RowsAffected()
+	var updated time.Time
+	err := m.reader(ctx).QueryRowContext(ctx, query, bucket).Scan(&updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
 	if err != nil {
-		return err
+		return time.Time{}, translateError(err)
+	}
+	return updated, nil
+}
+
+// ListPrefix returns the objects of a bucket whose name starts with prefix, sorted by name
+// Unless recursive, only the objects directly under prefix are returned, "" being the root of the bucket
+func (m *Metadata) ListPrefix(ctx context.Context, bucket, prefix string, recursive bool) ([]*model.Metadata, error) {
+	query := `
+		SELECT bucket, name, size, storage_class, created, updated
+		FROM metadata
+		WHERE bucket = $1 AND parent = $2
+		ORDER BY name;
+	`
+	args := []any{bucket, prefix}
+	if len(prefix) == 0 {
+		args[1] = "/"
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("no rows affected")
+	if recursive {
+		query = `
+			SELECT bucket, name, size, storage_class, created, updated
+			FROM metadata
+			WHERE bucket = $1 AND name >= $2 AND name < $3
+			ORDER BY name;
+		`
+		args = []any{bucket, prefix, prefixEnd(prefix)}
 	}
 
-	return nil
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	objects := []*model.Metadata{}
+	if err := m.reader(ctx).SelectContext(ctx, &objects, query, args...); err != nil {
+		return nil, translateError(err)
+	}
+	return objects, nil
+}
+
+// TopLevelPrefixes returns the distinct top level prefixes of the objects of a bucket, sorted
+func (m *Metadata) TopLevelPrefixes(ctx context.Context, bucket string) ([]string, error) {
+	query := `
+		SELECT DISTINCT substr(name, 1, instr(name, '/')) AS prefix
+		FROM metadata
+		WHERE bucket = ? AND instr(name, '/') > 0
+		ORDER BY prefix;
+	`
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	prefixes := []string{}
+	if err := m.reader(ctx).SelectContext(ctx, &prefixes, query, bucket); err != nil {
+		return nil, translateError(err)
+	}
+	return prefixes, nil
+}
+
+// prefixEnd returns the smallest string sorting after every string starting with prefix,
+// so names starting with prefix range over [prefix, prefixEnd(prefix)) using the primary key
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// Only the empty prefix gets here, valid UTF-8 names never contain 0xff so all of them sort before it
+	return "\xff"
 }
@@ -2,7 +2,9 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -70,7 +72,7 @@ func TestInsertMetadata(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if err := metadataRepo.Insert(tc.metadata); err != nil {
+			if err := metadataRepo.Insert(context.Background(), tc.metadata); err != nil {
 				if tc.wantErr {
 					return
 				}
@@ -117,10 +119,12 @@ func TestUpdateMetadata(t *testing.T) {
 	}
 
 	// Insert initial metadata
-	if err := metadataRepo.Insert(mockMetadata); err != nil {
+	if err := metadataRepo.Insert(context.Background(), mockMetadata); err != nil {
 		t.Fatal(err)
 	}
 
+	customTime := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
 	testCases := []struct {
 		testName string
 		metadata *model.Metadata
@@ -131,6 +135,11 @@ func TestUpdateMetadata(t *testing.T) {
 			&model.Metadata{Bucket: mockMetadata.Bucket, Name: mockMetadata.Name, Size: 100, Updated: time.Now()},
 			false,
 		},
+		{
+			"Updates custom time",
+			&model.Metadata{Bucket: mockMetadata.Bucket, Name: mockMetadata.Name, Size: 100, CustomTime: &customTime, Updated: time.Now()},
+			false,
+		},
 		{
 			"Fails to update non-existent metadata",
 			&model.Metadata{Bucket: "fake-bucket", Name: "fake-name.txt", Size: 100, Updated: time.Now()},
@@ -145,7 +154,7 @@ func TestUpdateMetadata(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
-			if err := metadataRepo.Update(tc.metadata.Bucket, tc.metadata.Name, tc.metadata.Size, tc.metadata.Updated); err != nil {
+			if err := metadataRepo.Update(context.Background(), tc.metadata.Bucket, tc.metadata.Name, tc.metadata.Size, tc.metadata.CustomTime, tc.metadata.Updated); err != nil {
 				if tc.wantErr {
 					return
 				}
@@ -170,6 +179,14 @@ func TestUpdateMetadata(t *testing.T) {
 			if gotSize != tc.metadata.Size || gotUpdated.Unix() != tc.metadata.Updated.Unix() {
 				t.Fatalf("got (%d, %v), want (%d, %v)", gotSize, gotUpdated, tc.metadata.Size, tc.metadata.Updated)
 			}
+
+			got, err := metadataRepo.Get(context.Background(), tc.metadata.Bucket, tc.metadata.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got.CustomTime == nil) != (tc.metadata.CustomTime == nil) || got.CustomTime != nil && !got.CustomTime.Equal(*tc.metadata.CustomTime) {
+				t.Errorf("Custom time mismatch: got %v, want %v", got.CustomTime, tc.metadata.CustomTime)
+			}
 		})
 	}
 }
@@ -197,7 +214,7 @@ func TestDeleteMetadata(t *testing.T) {
 	}
 
 	metadataRepo := NewMetadataRepository(db)
-	metadataRepo.Insert(mockMetadata)
+	metadataRepo.Insert(context.Background(), mockMetadata)
 
 	testCases := []struct {
 		name     string
@@ -211,7 +228,7 @@ func TestDeleteMetadata(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if err := metadataRepo.Delete(tc.metadata.Bucket, tc.metadata.Name); err != nil {
+			if err := metadataRepo.Delete(context.Background(), tc.metadata.Bucket, tc.metadata.Name); err != nil {
 				if tc.wantErr {
 					return
 				}
@@ -235,3 +252,204 @@ func TestDeleteMetadata(t *testing.T) {
 	}
 
 }
+
+func TestMetadataErrors(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := time.Now()
+	mockMetadata := &model.Metadata{
+		Bucket:       "mock",
+		Name:         "mock/mock.txt",
+		Size:         1,
+		StorageClass: "STANDARD",
+		Created:      updated,
+		Updated:      updated,
+	}
+
+	ctx := context.Background()
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	metadataRepo := NewMetadataRepository(db)
+	if err := metadataRepo.Insert(ctx, mockMetadata); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		op      func() error
+		wantErr error
+	}{
+		{
+			"Insert of existing object conflicts",
+			func() error { return metadataRepo.Insert(ctx, mockMetadata) },
+			ErrConflict,
+		},
+		{
+			"Update of older version is stale",
+			func() error {
+				return metadataRepo.Update(ctx, "mock", "mock/mock.txt", 2, nil, updated.Add(-time.Minute))
+			},
+			ErrStale,
+		},
+		{
+			"Update of non-existent object is not found",
+			func() error { return metadataRepo.Update(ctx, "mock", "fake", 2, nil, updated) },
+			ErrNotFound,
+		},
+		{
+			"Delete of non-existent object is not found",
+			func() error { return metadataRepo.Delete(ctx, "mock", "fake") },
+			ErrNotFound,
+		},
+		{
+			"Cancelled operation is not applied",
+			func() error { return metadataRepo.Delete(cancelledCtx, "mock", "mock/mock.txt") },
+			context.Canceled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.op(); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Error mismatch: got %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestListPrefix(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+
+	if _, err := metadataRepo.LastUpdated(ctx, "mock"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Last update of empty bucket error mismatch: got %v, want %v", err, ErrNotFound)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"a.txt", "a/1.txt", "a/b/2.txt", "a0.txt", "b/3.txt"}
+	for i, name := range names {
+		if err := metadataRepo.Insert(ctx, &model.Metadata{
+			Bucket:       "mock",
+			Name:         name,
+			Size:         1,
+			StorageClass: "STANDARD",
+			Created:      start,
+			Updated:      start.Add(time.Duration(i) * time.Minute),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, err := metadataRepo.LastUpdated(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(4 * time.Minute); !updated.Equal(want) {
+		t.Errorf("Last update mismatch: got %v, want %v", updated, want)
+	}
+
+	testCases := []struct {
+		name      string
+		prefix    string
+		recursive bool
+		want      []string
+	}{
+		{"Lists root objects", "", false, []string{"a.txt", "a0.txt"}},
+		{"Lists every object", "", true, names},
+		{"Lists objects directly under prefix", "a/", false, []string{"a/1.txt"}},
+		{"Lists objects recursively under prefix", "a/", true, []string{"a/1.txt", "a/b/2.txt"}},
+		{"Lists nothing under missing prefix", "c/", true, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects, err := metadataRepo.ListPrefix(ctx, "mock", tc.prefix, tc.recursive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, obj := range objects {
+				got = append(got, obj.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("Objects mismatch: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	prefixes, err := metadataRepo.TopLevelPrefixes(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/", "b/"}; strings.Join(prefixes, ",") != strings.Join(want, ",") {
+		t.Errorf("Top level prefixes mismatch: got %v, want %v", prefixes, want)
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+
+	for _, name := range []string{"a/1", "a/2", "b/"} {
+		if err := metadataRepo.Insert(ctx, &model.Metadata{Bucket: "mock", Name: name, Size: 1, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	obj, err := metadataRepo.Get(ctx, "mock", "b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Parent != "b/" || !obj.Marker {
+		t.Errorf("Object mismatch: got %+v", obj)
+	}
+
+	if _, err := metadataRepo.Get(ctx, "other", "a/1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	objects, err := metadataRepo.GetMany(ctx, "mock", []string{"a/2", "missing", "a/1", `"quoted"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, obj := range objects {
+		got = append(got, obj.Name)
+	}
+	if strings.Join(got, ",") != "a/1,a/2" {
+		t.Errorf("Objects mismatch: got %v, want [a/1 a/2]", got)
+	}
+}
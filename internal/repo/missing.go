@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+)
+
+// maxMissingPaths bounds the paths remembered as missing, new ones are not remembered beyond it
+const maxMissingPaths = 10000
+
+// missingPaths remembers the paths recently looked up and found missing, so clients stating the same
+// missing paths again, as filesystem gateways do, don't query the database every time
+// Writes to a directory through this process forget it at once, writes of other processes after the TTL
+// A nil missingPaths remembers nothing
+type missingPaths struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+	// generation is incremented by every invalidation, so lookups racing a write don't remember its path as missing
+	generation uint64
+}
+
+func newMissingPaths(ttl time.Duration, clk clock.Clock) *missingPaths {
+	return &missingPaths{ttl: ttl, clock: clk, expires: make(map[string]time.Time)}
+}
+
+// missingKind distinguishes lookups which may find the same path missing or not
+type missingKind string
+
+const (
+	missingContents        missingKind = "contents"
+	missingMarkersContents missingKind = "markers"
+	missingSummary         missingKind = "summary"
+)
+
+var missingKinds = []missingKind{missingContents, missingMarkersContents, missingSummary}
+
+func missingKey(kind missingKind, path string) string {
+	return string(kind) + ":" + path
+}
+
+// start returns the generation to pass to add once the lookup of a path found it missing
+func (m *missingPaths) start() uint64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.generation
+}
+
+// known returns whether path was found missing by a lookup of kind within the TTL
+func (m *missingPaths) known(kind missingKind, path string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := missingKey(kind, path)
+	expires, ok := m.expires[key]
+	if ok && m.clock.Now().After(expires) {
+		delete(m.expires, key)
+		return false
+	}
+	return ok
+}
+
+// add remembers path as missing for lookups of kind, unless a write was invalidated since generation
+func (m *missingPaths) add(kind missingKind, path string, generation uint64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if generation != m.generation {
+		return
+	}
+
+	now := m.clock.Now()
+	if len(m.expires) >= maxMissingPaths {
+		for key, expires := range m.expires {
+			if now.After(expires) {
+				delete(m.expires, key)
+			}
+		}
+		if len(m.expires) >= maxMissingPaths {
+			return
+		}
+	}
+	m.expires[missingKey(kind, path)] = now.Add(m.ttl)
+}
+
+// invalidate forgets paths, which were written
+func (m *missingPaths) invalidate(paths ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation++
+	for _, path := range paths {
+		for _, kind := range missingKinds {
+			delete(m.expires, missingKey(kind, path))
+		}
+	}
+}
+
+// SetMissingPathTTL remembers paths found missing by directory lookups for ttl, 0 to disable
+// It must be called before the database is used
+func (db *Database) SetMissingPathTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		db.missing = nil
+		return
+	}
+	db.missing = newMissingPaths(ttl, db.clock)
+}
+
+// invalidateMissing forgets the directories written for an object name, from firstDir up to the root
+func (db *Database) invalidateMissing(firstDir string) {
+	if db.missing == nil {
+		return
+	}
+
+	var dirs []string
+	for dirName := firstDir; ; dirName = getParentDir(dirName) {
+		dirs = append(dirs, dirName)
+		if dirName == "/" {
+			break
+		}
+	}
+	db.missing.invalidate(dirs...)
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestMissingPaths(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	db.SetMissingPathTTL(time.Hour)
+	db.SetClock(clk)
+
+	ctx := context.Background()
+	exploreRepo := NewExploreRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+
+	for _, path := range []string{"a/", "a/b/"} {
+		if contents, err := exploreRepo.GetPathContents(ctx, path, SortBySize, ListOptions{}); err != nil || len(contents) != 0 {
+			t.Fatalf("Expected no contents, got %v, %v", contents, err)
+		}
+		if summary, err := exploreRepo.GetPathSummary(ctx, path); err != nil || len(summary.Path) != 0 {
+			t.Fatalf("Expected empty summary, got %+v, %v", summary, err)
+		}
+		if !db.missing.known(missingContents, path) || !db.missing.known(missingSummary, path) {
+			t.Errorf("Expected %s to be known missing", path)
+		}
+	}
+
+	// Known missing paths are answered without querying, so a row written behind the cache's back is not seen
+	if _, err := db.Exec(`INSERT INTO directory (bucket, name, size_standard, count, parent) VALUES ('mock', 'a/', 1, 1, '/');`); err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := exploreRepo.GetPathSummary(ctx, "a/"); err != nil || summary.LiveSize != 0 {
+		t.Errorf("Expected cached empty summary, got %+v, %v", summary, err)
+	}
+	if _, err := db.Exec(`DELETE FROM directory;`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Finalizing an object forgets its parent directories
+	metadata := &model.Metadata{Bucket: "mock", Name: "a/b/file", Size: 2, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+	if err := NewMetadataRepository(db).Insert(ctx, metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", metadata.Name, metadata.Size, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"a/", "a/b/"} {
+		summary, err := exploreRepo.GetPathSummary(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Path != path || summary.LiveSize != 2 {
+			t.Errorf("Summary of %s mismatch: got %+v", path, summary)
+		}
+
+		contents, err := exploreRepo.GetPathContents(ctx, path, SortBySize, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contents) != 2 {
+			t.Errorf("Contents of %s mismatch: got %d entries, want 2", path, len(contents))
+		}
+	}
+
+	// A lookup racing a write does not remember the path as missing
+	generation := db.missing.start()
+	db.invalidateMissing("c/")
+	db.missing.add(missingSummary, "c/", generation)
+	if db.missing.known(missingSummary, "c/") {
+		t.Error("Expected c/ not to be remembered after a concurrent write")
+	}
+
+	// Expired paths are looked up again
+	db.missing.add(missingSummary, "c/", db.missing.start())
+	clk.Advance(time.Hour - time.Second)
+	if !db.missing.known(missingSummary, "c/") {
+		t.Error("Expected c/ to be known missing within the TTL")
+	}
+	clk.Advance(2 * time.Second)
+	if db.missing.known(missingSummary, "c/") {
+		t.Error("Expected c/ to expire")
+	}
+}
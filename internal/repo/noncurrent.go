@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Noncurrent records the noncurrent generations of objects of versioned buckets,
+// which are billed like live objects until they expire
+type Noncurrent struct {
+	*Database
+}
+
+type NoncurrentRepository interface {
+	Insert(ctx context.Context, obj *model.NoncurrentObject) error
+	Delete(ctx context.Context, bucket, name string, generation int64) error
+	ListPrefix(ctx context.Context, bucket, prefix string, recursive bool) ([]*model.NoncurrentObject, error)
+	TopLevelPrefixes(ctx context.Context, bucket string) ([]string, error)
+}
+
+func NewNoncurrentRepository(db *Database) NoncurrentRepository {
+	return &Noncurrent{db}
+}
+
+// noncurrentSchema is part of the schema, and added to databases created before generations were accounted
+const noncurrentSchema = `
+	CREATE TABLE noncurrent (
+		bucket			TEXT NOT NULL,
+		name			TEXT NOT NULL,
+		generation		INTEGER NOT NULL,
+		size			INTEGER NOT NULL,
+		storage_class	TEXT NOT NULL,
+		deleted			TIMESTAMP NOT NULL, -- when the generation was overwritten or deleted
+		PRIMARY KEY (bucket, name, generation)
+	);
+`
+
+// Insert records a noncurrent generation, returning ErrConflict if it already is
+func (n *Noncurrent) Insert(ctx context.Context, obj *model.NoncurrentObject) error {
+	if len(obj.Bucket) == 0 || len(obj.Name) == 0 {
+		return errors.New("bucket or name argument is empty")
+	}
+
+	return n.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return insertNoncurrent(ctx, tx, obj)
+	})
+}
+
+// insertNoncurrent applies Insert within tx
+func insertNoncurrent(ctx context.Context, tx *sql.Tx, obj *model.NoncurrentObject) error {
+	query := `
+		INSERT INTO noncurrent (bucket, name, generation, size, storage_class, deleted)
+		VALUES (?, ?, ?, ?, ?, ?);
+	`
+
+	_, err := tx.ExecContext(ctx, query, obj.Bucket, obj.Name, obj.Generation, obj.Size, obj.StorageClass, obj.Deleted)
+	return err
+}
+
+// Delete removes an expired noncurrent generation
+func (n *Noncurrent) Delete(ctx context.Context, bucket, name string, generation int64) error {
+	query := `
+		DELETE FROM noncurrent
+		WHERE bucket = ? AND name = ? AND generation = ?;
+	`
+
+	return n.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, bucket, name, generation)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// ListPrefix returns the noncurrent generations of a bucket whose name starts with prefix, sorted by name and generation
+// Unless recursive, only the generations directly under prefix are returned, "" being the root of the bucket
+func (n *Noncurrent) ListPrefix(ctx context.Context, bucket, prefix string, recursive bool) ([]*model.NoncurrentObject, error) {
+	query := `
+		SELECT bucket, name, generation, size, storage_class, deleted
+		FROM noncurrent
+		WHERE bucket = ? AND name >= ? AND name < ?
+		ORDER BY name, generation;
+	`
+	args := []any{bucket, prefix, prefixEnd(prefix)}
+
+	if !recursive {
+		query = `
+			SELECT bucket, name, generation, size, storage_class, deleted
+			FROM noncurrent
+			WHERE bucket = ? AND name >= ? AND name < ? AND instr(substr(name, length(?) + 1), '/') = 0
+			ORDER BY name, generation;
+		`
+		args = append(args, prefix)
+	}
+
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+
+	objects := []*model.NoncurrentObject{}
+	if err := n.reader(ctx).SelectContext(ctx, &objects, query, args...); err != nil {
+		return nil, translateError(err)
+	}
+	return objects, nil
+}
+
+// TopLevelPrefixes returns the distinct top level prefixes of the noncurrent generations of a bucket, sorted
+func (n *Noncurrent) TopLevelPrefixes(ctx context.Context, bucket string) ([]string, error) {
+	query := `
+		SELECT DISTINCT substr(name, 1, instr(name, '/')) AS prefix
+		FROM noncurrent
+		WHERE bucket = ? AND instr(name, '/') > 0
+		ORDER BY prefix;
+	`
+
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+
+	prefixes := []string{}
+	if err := n.reader(ctx).SelectContext(ctx, &prefixes, query, bucket); err != nil {
+		return nil, translateError(err)
+	}
+	return prefixes, nil
+}
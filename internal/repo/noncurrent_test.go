@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestNoncurrent(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	noncurrentRepo := NewNoncurrentRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+	deleted := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := NewMetadataRepository(db).Insert(ctx, &model.Metadata{Bucket: "mock", Name: "a/b/file", Size: 4, StorageClass: "STANDARD", Created: deleted, Updated: deleted}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", "a/b/file", 4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, obj := range []*model.NoncurrentObject{
+		{Bucket: "mock", Name: "a/b/file", Generation: 1, Size: 2, StorageClass: "STANDARD", Deleted: deleted},
+		{Bucket: "mock", Name: "a/b/file", Generation: 2, Size: 3, StorageClass: "STANDARD", Deleted: deleted},
+		{Bucket: "mock", Name: "a/gone", Generation: 1, Size: 5, StorageClass: "NEARLINE", Deleted: deleted},
+		{Bucket: "mock", Name: "root", Generation: 1, Size: 7, StorageClass: "STANDARD", Deleted: deleted},
+	} {
+		if err := noncurrentRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertNoncurrentDirs(ctx, obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := noncurrentRepo.Insert(ctx, &model.NoncurrentObject{Bucket: "mock", Name: "root", Generation: 1, Deleted: deleted}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := noncurrentRepo.Delete(ctx, "mock", "root", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	listed, err := noncurrentRepo.ListPrefix(ctx, "mock", "a/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Name != "a/gone" || !listed[0].Deleted.Equal(deleted) {
+		t.Errorf("Listing mismatch: got %+v", listed)
+	}
+
+	if listed, err = noncurrentRepo.ListPrefix(ctx, "mock", "a/", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || listed[0].Generation != 1 || listed[1].Generation != 2 {
+		t.Errorf("Recursive listing mismatch: got %+v", listed)
+	}
+
+	prefixes, err := noncurrentRepo.TopLevelPrefixes(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(prefixes, ",") != "a/" {
+		t.Errorf("Prefixes mismatch: got %v, want [a/]", prefixes)
+	}
+
+	summary, err := exploreRepo.GetPathSummary(ctx, "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.LiveSize != 4 || summary.NoncurrentSize != 10 || summary.BillableSize != 14 {
+		t.Errorf("Summary mismatch: got live %d noncurrent %d billable %d, want 4, 10 and 14", summary.LiveSize, summary.NoncurrentSize, summary.BillableSize)
+	}
+
+	// Directories holding only noncurrent generations are still listed
+	if err := noncurrentRepo.Delete(ctx, "mock", "a/gone", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.UpsertNoncurrentDirs(ctx, "mock", "a/gone", -5, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", "a/b/file", -4, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := exploreRepo.GetPathContents(ctx, "a/", SortBySize, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range contents {
+		got = append(got, fmt.Sprintf("%s:%d:%d", c.Name, c.Size, c.NoncurrentSize))
+	}
+	sort.Strings(got)
+	if want := []string{"a/:0:5", "a/b/:0:5"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Contents mismatch: got %v, want %v", got, want)
+	}
+
+	if summary, err = exploreRepo.GetPathSummary(ctx, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if summary.LiveSize != 0 || summary.NoncurrentSize != 12 || summary.BillableSize != 12 {
+		t.Errorf("Root summary mismatch: got live %d noncurrent %d billable %d, want 0, 12 and 12", summary.LiveSize, summary.NoncurrentSize, summary.BillableSize)
+	}
+}
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"time"
+)
+
+// backupRetryDelay is the pause before retrying a backup step that found the database locked
+const backupRetryDelay = 10 * time.Millisecond
+
+// InMemoryURL returns the URL of a database kept in memory and shared by every connection of the process
+// Its contents are lost once its last connection closes unless persisted
+func InMemoryURL(name string) string {
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+}
+
+// Persist copies the whole database into the database file at path, replacing its contents
+// Readers of the file keep a consistent view, they see the previous contents until the copy completes
+func (db *Database) Persist(ctx context.Context, path string) error {
+	return db.withDriverConn(ctx, func(conn driver.Conn) error {
+		return persistConn(ctx, conn, path)
+	})
+}
+
+// Restore replaces the whole database with the contents of the database file at path
+func (db *Database) Restore(ctx context.Context, path string) error {
+	return db.withDriverConn(ctx, func(conn driver.Conn) error {
+		return restoreConn(ctx, conn, path)
+	})
+}
+
+// PersistEvery persists the database to path every interval until ctx is cancelled,
+// then persists it one last time so a clean shutdown loses nothing
+func (db *Database) PersistEvery(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := db.Persist(context.WithoutCancel(ctx), path); err != nil {
+				log.Printf("Error persisting database on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if err := db.Persist(ctx, path); err != nil {
+				log.Printf("Error persisting database: %v", err)
+				continue
+			}
+			log.Printf("Persisted database to %s in %v", path, time.Since(start))
+		}
+	}
+}
+
+// withDriverConn runs f with the driver connection underlying a connection of the database
+func (db *Database) withDriverConn(ctx context.Context, f func(conn driver.Conn) error) error {
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(c any) error {
+		return f(c.(*loggingConn).sqliteConn)
+	})
+}
@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestPersistAndRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metadata.db")
+
+	memDB := NewDatabase(InMemoryURL(t.Name()), 1)
+	if err := memDB.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer memDB.Close()
+
+	if err := memDB.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := memDB.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers of the file see every persisted state
+	fileDB := NewDatabase(path, 1)
+	if err := fileDB.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer fileDB.Close()
+
+	if err := fileDB.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataRepo := NewMetadataRepository(memDB)
+	for i, name := range []string{"file1", "file2"} {
+		obj := &model.Metadata{Bucket: "mock", Name: name, Size: 1, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := memDB.Persist(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		if err := fileDB.Get(&count, `SELECT COUNT(*) FROM metadata;`); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != i+1 {
+			t.Errorf("Persisted count mismatch: got %d, want %d", count, i+1)
+		}
+	}
+
+	restoredDB := NewDatabase(InMemoryURL(t.Name()+"-restored"), 1)
+	if err := restoredDB.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredDB.Close()
+
+	if err := restoredDB.Restore(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	if err := restoredDB.Select(&names, `SELECT name FROM metadata ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 || names[0] != "file1" || names[1] != "file2" {
+		t.Errorf("Restored names mismatch: got %v", names)
+	}
+}
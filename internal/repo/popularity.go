@@ -0,0 +1,192 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// popularitySchema is part of the schema, and added to databases created before access logs were ingested
+const popularitySchema = `
+	CREATE TABLE object_reads (
+		bucket		TEXT NOT NULL,
+		name		TEXT NOT NULL,
+		reads		INTEGER DEFAULT 0,
+		last_read	INTEGER NOT NULL, -- unix time in nanoseconds of the latest read
+		PRIMARY KEY (bucket, name)
+	);
+
+	-- Reads of every object counted in each of its parent directories, the root included
+	CREATE TABLE directory_reads (
+		bucket		TEXT NOT NULL,
+		name		TEXT NOT NULL,
+		reads		INTEGER DEFAULT 0,
+		last_read	INTEGER NOT NULL,
+		PRIMARY KEY (bucket, name)
+	);
+
+	-- Access logs already counted, so each is ingested once
+	CREATE TABLE access_log (
+		name		TEXT NOT NULL PRIMARY KEY,
+		reads		INTEGER NOT NULL,
+		ingested	TIMESTAMP NOT NULL
+	);
+`
+
+// MaxColdEntries bounds the entries of a cold data report
+const MaxColdEntries = 1000
+
+type Popularity struct {
+	*Database
+}
+
+type PopularityRepository interface {
+	IngestLog(ctx context.Context, logName string, reads []model.ObjectRead) error
+	GetColdReport(ctx context.Context, path string, since time.Time, limit int) ([]*model.ColdEntry, error)
+}
+
+func NewPopularityRepository(db *Database) PopularityRepository {
+	return &Popularity{db}
+}
+
+// IngestLog counts the reads of an access log in the objects read and their parent directories,
+// returning ErrConflict without counting them again if the log was already ingested
+func (p *Popularity) IngestLog(ctx context.Context, logName string, reads []model.ObjectRead) error {
+	if len(logName) == 0 {
+		return errors.New("log name is empty")
+	}
+
+	type key struct{ bucket, name string }
+	type count struct {
+		reads    int64
+		lastRead int64
+	}
+
+	// Reads are aggregated per object and directory, logs reading the same objects many times
+	objects := make(map[key]*count)
+	dirs := make(map[key]*count)
+	add := func(counts map[key]*count, k key, t int64) {
+		c, ok := counts[k]
+		if !ok {
+			c = &count{}
+			counts[k] = c
+		}
+		c.reads++
+		c.lastRead = max(c.lastRead, t)
+	}
+	for _, read := range reads {
+		if len(read.Bucket) == 0 || len(read.Name) == 0 {
+			return errors.New("bucket or name of a read is empty")
+		}
+
+		t := read.Time.UnixNano()
+		add(objects, key{read.Bucket, read.Name}, t)
+		for dir := getParentDir(read.Name); ; dir = getParentDir(dir) {
+			add(dirs, key{read.Bucket, dir}, t)
+			if dir == "/" {
+				break
+			}
+		}
+	}
+
+	upsert := `
+		INSERT INTO %s (bucket, name, reads, last_read)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT(bucket, name)
+		DO UPDATE
+		SET reads = reads + $3,
+			last_read = MAX(last_read, $4);
+	`
+
+	return p.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO access_log (name, reads, ingested) VALUES ($1, $2, $3);`, logName, len(reads), p.clock.Now()); err != nil {
+			return err
+		}
+
+		for _, table := range []struct {
+			name   string
+			counts map[key]*count
+		}{
+			{"object_reads", objects},
+			{"directory_reads", dirs},
+		} {
+			stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(upsert, table.name))
+			if err != nil {
+				return err
+			}
+			for k, c := range table.counts {
+				if _, err := stmt.ExecContext(ctx, k.bucket, k.name, c.reads, c.lastRead); err != nil {
+					stmt.Close()
+					return err
+				}
+			}
+			stmt.Close()
+		}
+		return nil
+	})
+}
+
+// GetColdReport lists the directories and objects directly under path not read since since, or never read
+// if since is zero, from the largest
+func (p *Popularity) GetColdReport(ctx context.Context, path string, since time.Time, limit int) ([]*model.ColdEntry, error) {
+	type coldRow struct {
+		Bucket    string        `db:"bucket"`
+		Name      string        `db:"name"`
+		Directory bool          `db:"directory"`
+		Size      int64         `db:"size"`
+		Count     int64         `db:"count"`
+		Reads     int64         `db:"reads"`
+		LastRead  sql.NullInt64 `db:"last_read"`
+	}
+
+	// Entries read at or after cutoff are left out, every read entry when since is zero
+	cutoff := int64(0)
+	if !since.IsZero() {
+		cutoff = since.UnixNano()
+	}
+
+	query := `
+		SELECT d.bucket AS bucket, d.name AS name, TRUE AS directory, ` + directorySizeExpr + ` AS size, d.count,
+			COALESCE(r.reads, 0) AS reads, r.last_read
+		FROM directory d
+		LEFT JOIN directory_reads r ON r.bucket = d.bucket AND r.name = d.name
+		WHERE d.parent = $1 AND d.name != $1 AND d.count > 0 AND (r.last_read IS NULL OR r.last_read < $2)
+		UNION ALL
+		SELECT m.bucket AS bucket, m.name AS name, FALSE AS directory, m.size, 1 AS count,
+			COALESCE(r.reads, 0) AS reads, r.last_read
+		FROM metadata m
+		LEFT JOIN object_reads r ON r.bucket = m.bucket AND r.name = m.name
+		WHERE m.parent = $1 AND NOT m.marker AND (r.last_read IS NULL OR r.last_read < $2)
+		ORDER BY size DESC, bucket, name
+		LIMIT $3;
+	`
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var rows []coldRow
+	if err := p.reader(ctx).SelectContext(ctx, &rows, query, path, cutoff, limit); err != nil {
+		return nil, translateError(err)
+	}
+
+	entries := make([]*model.ColdEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = &model.ColdEntry{
+			Bucket:    row.Bucket,
+			Name:      row.Name,
+			Directory: row.Directory,
+			Size:      row.Size,
+			Count:     row.Count,
+			Reads:     row.Reads,
+		}
+		if row.LastRead.Valid {
+			lastRead := time.Unix(0, row.LastRead.Int64).UTC()
+			entries[i].LastRead = &lastRead
+		}
+	}
+	return entries, nil
+}
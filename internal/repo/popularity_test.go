@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestPopularity(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+	popularityRepo := NewPopularityRepository(db)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range []model.Metadata{
+		{Bucket: "mock", Name: "hot/file1", Size: 10},
+		{Bucket: "mock", Name: "warm/file2", Size: 20},
+		{Bucket: "mock", Name: "cold/file3", Size: 30},
+		{Bucket: "mock", Name: "file4", Size: 5},
+		{Bucket: "mock", Name: "file5", Size: 1},
+	} {
+		m.StorageClass, m.Created, m.Updated = "STANDARD", created, created
+		if err := metadataRepo.Insert(ctx, &m); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, m.Bucket, m.Name, m.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	reads := []model.ObjectRead{
+		{Bucket: "mock", Name: "hot/file1", Time: recent},
+		{Bucket: "mock", Name: "hot/file1", Time: old},
+		{Bucket: "mock", Name: "warm/file2", Time: old},
+		{Bucket: "mock", Name: "file5", Time: recent},
+	}
+	if err := popularityRepo.IngestLog(ctx, "usage_1", reads); err != nil {
+		t.Fatal(err)
+	}
+	if err := popularityRepo.IngestLog(ctx, "usage_1", reads); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict ingesting a log twice, got %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		path  string
+		since time.Time
+		want  []string
+	}{
+		{"Never read", "/", time.Time{}, []string{"cold/", "file4"}},
+		{"Not read since", "/", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), []string{"cold/", "warm/", "file4"}},
+		{"Under a directory", "hot/", recent.Add(time.Hour), []string{"hot/file1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := popularityRepo.GetColdReport(ctx, tc.path, tc.since, MaxColdEntries)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Entries mismatch: got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("Entries mismatch: got %v, want %v", got, tc.want)
+					break
+				}
+			}
+		})
+	}
+
+	entries, err := popularityRepo.GetColdReport(ctx, "hot/", recent.Add(time.Hour), MaxColdEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hot := entries[0]; hot.Reads != 2 || hot.LastRead == nil || !hot.LastRead.Equal(recent) {
+		t.Errorf("Reads of hot/file1 mismatch: got %+v", hot)
+	}
+}
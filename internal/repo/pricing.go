@@ -1,6 +1,9 @@
 package repo
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 type StorageClass string
 type Location string
@@ -64,6 +67,50 @@ var locationPricing = map[Location]map[StorageClass]float64{
 	},
 }
 
+// dualRegionLocations maps predefined dual-regions to the location they are priced as
+var dualRegionLocations = map[string]Location{
+	"NAM4":  LocationUS,
+	"EUR4":  LocationEU,
+	"EUR5":  LocationEU,
+	"EUR7":  LocationEU,
+	"EUR8":  LocationEU,
+	"ASIA1": LocationASIA,
+}
+
+// regionPrefixLocations maps region name prefixes to the location they are priced as
+// Longer prefixes are listed first so they take precedence
+var regionPrefixLocations = []struct {
+	prefix   string
+	location Location
+}{
+	{"ASIA-SOUTH", LocationIN},
+	{"ASIA-", LocationASIA},
+	{"AUSTRALIA-", LocationAU},
+	{"EUROPE-", LocationEU},
+	{"NORTHAMERICA-", LocationCA},
+	{"US-", LocationUS},
+}
+
+// pricingLocation returns the location whose pricing applies to a bucket location
+// such as a multi-region (EU), dual-region (NAM4) or region (europe-west6)
+// Unknown or missing locations fall back to defaultLocation
+func pricingLocation(bucketLocation string) Location {
+	bucketLocation = strings.ToUpper(bucketLocation)
+
+	if _, ok := locationPricing[Location(bucketLocation)]; ok {
+		return Location(bucketLocation)
+	}
+	if location, ok := dualRegionLocations[bucketLocation]; ok {
+		return location
+	}
+	for _, r := range regionPrefixLocations {
+		if strings.HasPrefix(bucketLocation, r.prefix) {
+			return r.location
+		}
+	}
+	return defaultLocation
+}
+
 // getPrice returns the price for a given storage class in a specific location.
 func getPrice(costMap map[StorageClass]float64, storageClass StorageClass, size int64) (float64, error) {
 	price, ok := costMap[storageClass]
@@ -75,13 +122,17 @@ func getPrice(costMap map[StorageClass]float64, storageClass StorageClass, size
 	return price * sizeGB, nil
 }
 
-// getObjectCost returns the total cost for an object based on its storage class
+// getObjectCost returns the total cost for an object based on the tier of its storage class
 func getObjectCost(location Location, storageClass StorageClass, size int64) (float64, error) {
 	costMap, ok := locationPricing[location]
 	if !ok {
 		return 0, errors.New("invalid location")
 	}
 
+	if tier, ok := storageTier(storageClass); ok {
+		storageClass = tier
+	}
+
 	cost, err := getPrice(costMap, storageClass, size)
 	if err != nil {
 		return 0, err
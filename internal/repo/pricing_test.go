@@ -0,0 +1,29 @@
+package repo
+
+import "testing"
+
+func TestPricingLocation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		location string
+		want     Location
+	}{
+		{"Multi-region", "EU", LocationEU},
+		{"Lowercase multi-region", "asia", LocationASIA},
+		{"Dual-region", "NAM4", LocationUS},
+		{"Region", "EUROPE-WEST6", LocationEU},
+		{"Region priced as its own location", "ASIA-SOUTH2", LocationIN},
+		{"Region of a broader location", "ASIA-EAST2", LocationASIA},
+		{"Canada region", "NORTHAMERICA-NORTHEAST1", LocationCA},
+		{"Unknown location", "SOUTHAMERICA-EAST1", defaultLocation},
+		{"Missing location", "", defaultLocation},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := pricingLocation(tc.location); got != tc.want {
+				t.Errorf("Location mismatch: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
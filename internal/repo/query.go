@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
+)
+
+type Query struct {
+	*Database
+}
+
+type QueryRepository interface {
+	Run(ctx context.Context, stmt *query.Statement) (*model.QueryResult, error)
+}
+
+func NewQueryRepository(db *Database) QueryRepository {
+	return &Query{db}
+}
+
+// Run executes a statement validated by query.Parse, bounded by the operation timeout like every other read
+func (q *Query) Run(ctx context.Context, stmt *query.Statement) (*model.QueryResult, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := q.reader(ctx).QueryContext(ctx, stmt.SQL, stmt.Args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	result := &model.QueryResult{Columns: stmt.Columns, Rows: []map[string]any{}}
+	values := make([]any, len(stmt.Columns))
+	pointers := make([]any, len(stmt.Columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		row := make(map[string]any, len(values))
+		for i, column := range stmt.Columns {
+			// SQLite stores booleans as integers, only some drivers convert them back
+			if n, ok := values[i].(int64); ok && stmt.Booleans[i] {
+				values[i] = n != 0
+			}
+			row[column] = values[i]
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, translateError(rows.Err())
+}
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
+)
+
+func TestRunQuery(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataRepo := NewMetadataRepository(db)
+	queryRepo := NewQueryRepository(db)
+
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	metadata := []model.Metadata{
+		{Bucket: "mock", Name: "logs/a.log", Size: 10, StorageClass: "STANDARD", Created: old, Updated: old},
+		{Bucket: "mock", Name: "logs/2024/b.log", Size: 20, StorageClass: "NEARLINE", Created: recent, Updated: recent},
+		{Bucket: "mock", Name: "logs/", StorageClass: "STANDARD", Created: recent, Updated: recent},
+		{Bucket: "mock", Name: "img/c.png", Size: 30, StorageClass: "STANDARD", Created: recent, Updated: recent},
+	}
+
+	for _, m := range metadata {
+		if err := metadataRepo.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmt, err := query.Parse("SELECT name, size, created, marker, depth WHERE name LIKE 'logs/%' AND NOT marker AND created >= '2024-10-01' ORDER BY size DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := queryRepo.Run(context.Background(), stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Rows) != 1 {
+		t.Fatalf("Row count mismatch: got %d, want 1: %v", len(result.Rows), result.Rows)
+	}
+
+	row := result.Rows[0]
+	if row["name"] != "logs/2024/b.log" || row["size"] != int64(20) || row["marker"] != false || row["depth"] != int64(2) {
+		t.Errorf("Row mismatch: got %v", row)
+	}
+	if created, ok := row["created"].(time.Time); !ok || !created.Equal(recent) {
+		t.Errorf("Created mismatch: got %v, want %v", row["created"], recent)
+	}
+
+	// Timestamps compare at a finer grain than days
+	stmt, err = query.Parse("SELECT name WHERE updated > '2024-10-01T12:00:00Z' AND updated < '2024-10-01T14:00:00+01:00' LIMIT 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err = queryRepo.Run(context.Background(), stmt); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 3 {
+		t.Errorf("Row count mismatch: got %d, want 3: %v", len(result.Rows), result.Rows)
+	}
+}
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// queryLogDriver is the SQLite driver recording statements into the QueryLog of their context
+const queryLogDriver = DATABASE_TYPE + "_querylog"
+
+func init() {
+	sql.Register(queryLogDriver, &loggingDriver{newDriver()})
+	sqlx.BindDriver(queryLogDriver, sqlx.QUESTION)
+}
+
+// LoggedQuery is a statement executed on behalf of a context
+type LoggedQuery struct {
+	SQL  string
+	Args []any
+	// Duration is the time until the statement completed, or until its first rows were available
+	Duration time.Duration
+}
+
+// QueryLog collects the statements executed with a context, to diagnose slow requests
+type QueryLog struct {
+	mu      sync.Mutex
+	queries []LoggedQuery
+	// parent is the log of the enclosing context, which records the same statements
+	parent *QueryLog
+}
+
+type queryLogKey struct{}
+
+// WithQueryLog returns a context recording every statement executed with it into the returned log
+// Logs already carried by ctx keep recording them too
+func WithQueryLog(ctx context.Context) (context.Context, *QueryLog) {
+	parent, _ := ctx.Value(queryLogKey{}).(*QueryLog)
+	l := &QueryLog{parent: parent}
+	return context.WithValue(ctx, queryLogKey{}, l), l
+}
+
+// Queries returns the statements recorded so far in execution order
+func (l *QueryLog) Queries() []LoggedQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]LoggedQuery(nil), l.queries...)
+}
+
+func (l *QueryLog) record(query string, args []driver.NamedValue, start time.Time) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	q := LoggedQuery{SQL: query, Args: values, Duration: time.Since(start)}
+	for ; l != nil; l = l.parent {
+		l.mu.Lock()
+		l.queries = append(l.queries, q)
+		l.mu.Unlock()
+	}
+}
+
+// ExplainQueryPlan returns the query plan SQLite picks for a statement, one line per step
+func (db *Database) ExplainQueryPlan(ctx context.Context, query string, args []any) ([]string, error) {
+	type planRow struct {
+		ID      int    `db:"id"`
+		Parent  int    `db:"parent"`
+		NotUsed int    `db:"notused"`
+		Detail  string `db:"detail"`
+	}
+
+	// Explaining a logged statement is not part of the work it logs
+	ctx = context.WithValue(ctx, queryLogKey{}, (*QueryLog)(nil))
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var rows []planRow
+	if err := db.reader(ctx).SelectContext(ctx, &rows, "EXPLAIN QUERY PLAN "+query, args...); err != nil {
+		return nil, translateError(err)
+	}
+
+	// Indent every step under its parent
+	depths := make(map[int]int)
+	plan := make([]string, len(rows))
+	for i, row := range rows {
+		depths[row.ID] = depths[row.Parent] + 1
+		plan[i] = fmt.Sprintf("%*s%s", 2*(depths[row.ID]-1), "", row.Detail)
+	}
+	return plan, nil
+}
+
+type loggingDriver struct {
+	driver.Driver
+}
+
+func (d *loggingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &loggingConn{conn.(sqliteConn)}, nil
+}
+
+// sqliteConn is the part of the connections of both SQLite drivers database/sql relies on
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+}
+
+// loggingConn records statements run with a context carrying a QueryLog
+type loggingConn struct {
+	sqliteConn
+}
+
+func (c *loggingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if l, _ := ctx.Value(queryLogKey{}).(*QueryLog); l != nil {
+		defer l.record(query, args, time.Now())
+	}
+	return c.sqliteConn.QueryContext(ctx, query, args)
+}
+
+func (c *loggingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if l, _ := ctx.Value(queryLogKey{}).(*QueryLog); l != nil {
+		defer l.record(query, args, time.Now())
+	}
+	return c.sqliteConn.ExecContext(ctx, query, args)
+}
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQueryLog(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	// Statements without a log are not recorded
+	if err := dirRepo.UpsertParentDirs(context.Background(), StorageStandard, "mock", "a/b", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, queryLog := WithQueryLog(context.Background())
+	if _, err := exploreRepo.GetPathSummary(ctx, "a/"); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := queryLog.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("Query count mismatch: got %d, want %d", len(queries), 1)
+	}
+
+	if !strings.Contains(queries[0].SQL, "FROM") || len(queries[0].Args) != 1 || queries[0].Args[0] != "a/" {
+		t.Errorf("Query mismatch: got %+v", queries[0])
+	}
+
+	plan, err := db.ExplainQueryPlan(ctx, queries[0].SQL, queries[0].Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) == 0 || !strings.Contains(strings.Join(plan, "\n"), "directory") {
+		t.Errorf("Plan mismatch: got %v", plan)
+	}
+
+	if n := len(queryLog.Queries()); n != 1 {
+		t.Errorf("Expected explaining not to be logged, got %d queries", n)
+	}
+
+	// Nested logs record into their parents
+	nestedCtx, nestedLog := WithQueryLog(ctx)
+	if _, err := exploreRepo.GetPathSummary(nestedCtx, "a/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(nestedLog.Queries()); n != 1 {
+		t.Errorf("Nested query count mismatch: got %d, want %d", n, 1)
+	}
+
+	if n := len(queryLog.Queries()); n != 2 {
+		t.Errorf("Parent query count mismatch: got %d, want %d", n, 2)
+	}
+}
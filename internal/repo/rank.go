@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// MaxTopDirectories is the longest ranking of the largest directories served
+	MaxTopDirectories = 100
+	// rankedDirectories is the number of directories ranked per bucket, the slack above MaxTopDirectories
+	// absorbs ranked directories shrinking below the floor before the ranking must be rebuilt
+	rankedDirectories = 2 * MaxTopDirectories
+)
+
+// topDirectorySchema is part of the schema, and added to databases created before rankings
+// Every ranked directory is at least as large as the floor of its bucket, and every other directory at most,
+// so the ranked directories are always the largest ones. Buckets without a floor have a floor of 0
+const topDirectorySchema = `
+	CREATE TABLE top_directory (
+		bucket		TEXT NOT NULL,
+		name		TEXT NOT NULL,
+		size		INTEGER NOT NULL,
+		PRIMARY KEY (bucket, name)
+	);
+
+	CREATE INDEX top_directory_size ON top_directory (bucket, size);
+
+	CREATE TABLE top_directory_floor (
+		bucket		TEXT NOT NULL PRIMARY KEY,
+		floor		INTEGER NOT NULL
+	);
+`
+
+// directorySizeExpr computes the total size of a directory
+const directorySizeExpr = `size_standard + size_nearline + size_coldline + size_archive`
+
+// rankingRebuild ranks the largest directories of every bucket matching filter from scratch,
+// the root excluded as it holds every other directory
+func rankingRebuild(filter string) string {
+	sized := fmt.Sprintf(`
+		SELECT bucket, name, size, ROW_NUMBER() OVER (PARTITION BY bucket ORDER BY size DESC) AS ranking
+		FROM (SELECT bucket, name, %s AS size FROM directory WHERE name != '/' AND %s)
+		WHERE size > 0
+	`, directorySizeExpr, filter)
+
+	return fmt.Sprintf(`
+		DELETE FROM top_directory WHERE %[1]s;
+		DELETE FROM top_directory_floor WHERE %[1]s;
+		INSERT INTO top_directory (bucket, name, size)
+		SELECT bucket, name, size FROM (%[2]s) WHERE ranking <= %[3]d;
+		INSERT INTO top_directory_floor (bucket, floor)
+		SELECT bucket, size FROM (%[2]s) WHERE ranking = %[3]d + 1;
+	`, filter, sized, rankedDirectories)
+}
+
+// rankDirectory updates the ranking of a bucket after the size of one of its directories changed by delta
+func rankDirectory(ctx context.Context, tx *sql.Tx, bucket string, name string, size int64, delta int64) error {
+	if name == "/" || delta == 0 {
+		return nil
+	}
+
+	floor, err := rankingFloor(ctx, tx, bucket)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE top_directory SET size = ? WHERE bucket = ? AND name = ?;`, size, bucket, name)
+	if err != nil {
+		return err
+	}
+
+	ranked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ranked == 1 && (size < floor || size <= 0):
+		// Unranked directories may now be larger
+		return unrankDirectory(ctx, tx, bucket, name, floor)
+	case ranked == 0 && size > floor:
+		return insertRanked(ctx, tx, bucket, name, size)
+	}
+	return nil
+}
+
+// rankingFloor returns the floor of the ranking of a bucket
+func rankingFloor(ctx context.Context, tx *sql.Tx, bucket string) (int64, error) {
+	var floor int64
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE((SELECT floor FROM top_directory_floor WHERE bucket = ?), 0);`, bucket).Scan(&floor)
+	return floor, err
+}
+
+// insertRanked ranks a directory grown above the floor, evicting the smallest ranked directory if the ranking is full
+func insertRanked(ctx context.Context, tx *sql.Tx, bucket string, name string, size int64) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO top_directory (bucket, name, size) VALUES (?, ?, ?);`, bucket, name, size); err != nil {
+		return err
+	}
+
+	var ranked int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM top_directory WHERE bucket = ?;`, bucket).Scan(&ranked); err != nil {
+		return err
+	}
+	if ranked <= rankedDirectories {
+		return nil
+	}
+
+	var floor int64
+	if err := tx.QueryRowContext(ctx, `
+		DELETE FROM top_directory
+		WHERE bucket = ? AND name = (SELECT name FROM top_directory WHERE bucket = ? ORDER BY size, name LIMIT 1)
+		RETURNING size;
+	`, bucket, bucket).Scan(&floor); err != nil {
+		return err
+	}
+
+	// The evicted directory was the smallest ranked one, so the ranking stays above the raised floor
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO top_directory_floor (bucket, floor) VALUES (?, ?)
+		ON CONFLICT(bucket) DO UPDATE SET floor = MAX(floor, excluded.floor);
+	`, bucket, floor)
+	return err
+}
+
+// unrankDirectory removes a directory from the ranking, rebuilding the ranking if it gets too short to serve
+// Rankings with a floor of 0 hold every directory which is not empty, they never need rebuilding
+func unrankDirectory(ctx context.Context, tx *sql.Tx, bucket string, name string, floor int64) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM top_directory WHERE bucket = ? AND name = ?;`, bucket, name); err != nil {
+		return err
+	}
+
+	var ranked int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM top_directory WHERE bucket = ?;`, bucket).Scan(&ranked); err != nil {
+		return err
+	}
+	if ranked >= MaxTopDirectories || floor == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, rankingRebuild("bucket = ?"), bucket, bucket, bucket, bucket)
+	return err
+}
@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// largestSizes returns the sizes of the n largest directories of a bucket sorting every directory
+func largestSizes(t *testing.T, db *Database, bucket string, n int) []int64 {
+	var sizes []int64
+	if err := db.Select(&sizes, `
+		SELECT `+directorySizeExpr+` AS size FROM directory
+		WHERE bucket = ? AND name != '/' AND size > 0
+		ORDER BY size DESC LIMIT ?;
+	`, bucket, n); err != nil {
+		t.Fatal(err)
+	}
+	return sizes
+}
+
+func rankedSizes(t *testing.T, exploreRepo ExploreRepository, bucket string, n int) []int64 {
+	top, err := exploreRepo.GetLargestDirectories(context.Background(), bucket, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int64
+	for _, dir := range top {
+		sizes = append(sizes, dir.Size)
+	}
+	return sizes
+}
+
+func TestRankDirectories(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	// Directories grow and shrink at random, more of them than are ranked so that
+	// the ranking evicts directories, raises its floor and gets rebuilt
+	rng := rand.New(rand.NewPCG(1, 2))
+	sizes := make(map[string]int64)
+	for i := range 3000 {
+		name := fmt.Sprintf("dir-%d/file", rng.IntN(rankedDirectories+MaxTopDirectories))
+		delta := rng.Int64N(1000) + 1
+		if sizes[name] > 0 && rng.IntN(3) == 0 {
+			delta = -min(sizes[name], rng.Int64N(2000)+1)
+		}
+		sizes[name] += delta
+
+		if err := dirRepo.UpsertParentDirs(context.Background(), StorageStandard, "mock", name, delta, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if i%100 == 0 {
+			for _, n := range []int{1, 10, MaxTopDirectories} {
+				if got, want := rankedSizes(t, exploreRepo, "mock", n), largestSizes(t, db, "mock", n); !slices.Equal(got, want) {
+					t.Fatalf("Ranking mismatch after %d changes: got %v, want %v", i, got, want)
+				}
+			}
+		}
+	}
+
+	// Emptying the largest directories twice leaves too few ranked ones, the ranking is rebuilt
+	for range 2 {
+		top, err := exploreRepo.GetLargestDirectories(context.Background(), "mock", MaxTopDirectories)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range top {
+			if err := dirRepo.UpsertParentDirs(context.Background(), StorageStandard, "mock", dir.Name+"file", -dir.Size, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if got, want := rankedSizes(t, exploreRepo, "mock", MaxTopDirectories), largestSizes(t, db, "mock", MaxTopDirectories); !slices.Equal(got, want) {
+		t.Errorf("Ranking mismatch after emptying the largest directories: got %v, want %v", got, want)
+	}
+
+	// Deleted directories leave the ranking
+	top, err := exploreRepo.GetLargestDirectories(context.Background(), "mock", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dirRepo.Delete(context.Background(), "mock", top[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rankedSizes(t, exploreRepo, "mock", MaxTopDirectories), largestSizes(t, db, "mock", MaxTopDirectories); !slices.Equal(got, want) {
+		t.Errorf("Ranking mismatch after deleting the largest directory: got %v, want %v", got, want)
+	}
+
+	if _, err := exploreRepo.GetLargestDirectories(context.Background(), "mock", MaxTopDirectories+1); err == nil {
+		t.Error("Expected error ranking more than MaxTopDirectories directories")
+	}
+}
+
+func TestMigrateTopDirectories(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	for i := range rankedDirectories + 10 {
+		for _, bucket := range []string{"a", "b"} {
+			name := fmt.Sprintf("dir-%d/file", i)
+			if err := dirRepo.UpsertParentDirs(context.Background(), StorageStandard, bucket, name, int64(i+1), 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Revert to the schema without rankings
+	if _, err := db.Exec(`DROP TABLE top_directory; DROP TABLE top_directory_floor;`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bucket := range []string{"a", "b"} {
+		if got, want := rankedSizes(t, exploreRepo, bucket, MaxTopDirectories), largestSizes(t, db, bucket, MaxTopDirectories); !slices.Equal(got, want) {
+			t.Errorf("Ranking of %s mismatch: got %v, want %v", bucket, got, want)
+		}
+	}
+
+	var floor int64
+	if err := db.Get(&floor, `SELECT floor FROM top_directory_floor WHERE bucket = 'a';`); err != nil || floor != 10 {
+		t.Errorf("Floor mismatch: got %d, %v, want 10", floor, err)
+	}
+
+	// Rankings of every bucket merge into one
+	top, err := exploreRepo.GetLargestDirectories(context.Background(), "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("Largest directories count mismatch: got %d, want 2", len(top))
+	}
+	if top[0].Bucket != "a" || top[1].Bucket != "b" || top[0].Size != top[1].Size {
+		t.Errorf("Largest directories of every bucket mismatch: got %+v, %+v", top[0], top[1])
+	}
+}
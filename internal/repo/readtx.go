@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// reader runs the read queries of repositories, a database or a transaction
+type reader interface {
+	sqlx.QueryerContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type readTxKey struct{}
+
+// readTx is the read transaction shared by the repository reads of a context
+type readTx struct {
+	db *Database
+	tx *sqlx.Tx
+}
+
+// WithReadTransaction begins a read transaction on db shared by every repository read of the returned context,
+// so endpoints issuing several queries respond from a single snapshot of the database while writes go on
+// The snapshot is taken by the first read, and end releases the transaction once the reads are done
+// The transaction holds a connection of db until then, so the returned context must not be used to write
+func (db *Database) WithReadTransaction(ctx context.Context) (context.Context, func(), error) {
+	tx, err := db.DB.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return ctx, func() {}, translateError(err)
+	}
+	return context.WithValue(ctx, readTxKey{}, &readTx{db, tx}), func() { tx.Rollback() }, nil
+}
+
+// readTxOf returns the read transaction of ctx begun on db, or nil
+func (db *Database) readTxOf(ctx context.Context) *sqlx.Tx {
+	if t, ok := ctx.Value(readTxKey{}).(*readTx); ok && t.db == db {
+		return t.tx
+	}
+	return nil
+}
+
+// inReadTx reports whether the reads of ctx on db see the snapshot of a read transaction
+func (db *Database) inReadTx(ctx context.Context) bool {
+	return db.readTxOf(ctx) != nil
+}
+
+// reader returns the read transaction of ctx begun on db, its write transaction if it runs Atomically, or db itself
+func (db *Database) reader(ctx context.Context) reader {
+	if tx := db.writeTxOf(ctx); tx != nil {
+		return db.txReader(tx)
+	}
+	if tx := db.readTxOf(ctx); tx != nil {
+		return tx
+	}
+	return db.DB
+}
+
+// withReadTx runs f with the read transaction of ctx begun on db, or with a read transaction of its own,
+// so the queries of f see a single snapshot either way
+func (db *Database) withReadTx(ctx context.Context, f func(r reader) error) error {
+	if tx := db.writeTxOf(ctx); tx != nil {
+		return f(db.txReader(tx))
+	}
+	if tx := db.readTxOf(ctx); tx != nil {
+		return f(tx)
+	}
+
+	tx, err := db.DB.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
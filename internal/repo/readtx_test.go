@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestWithReadTransaction(t *testing.T) {
+	// Reads of the transaction and writes need connections of their own
+	db := NewDatabase(filepath.Join(t.TempDir(), "metadata.db"), 2)
+	if err := db.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+	exploreRepo := NewExploreRepository(db)
+	treeRepo := NewTreeRepository(db)
+
+	insert := func(name string, size int64) {
+		created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		obj := &model.Metadata{Bucket: "mock", Name: name, Size: size, StorageClass: "STANDARD", Created: created, Updated: created}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", name, size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("dir/a", 10)
+
+	readCtx, end, err := db.WithReadTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer end()
+
+	// The first read takes the snapshot
+	count, err := exploreRepo.CountObjects(readCtx, "dir/", ListOptions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Count != 1 {
+		t.Fatalf("Count mismatch: got %d, want 1", count.Count)
+	}
+
+	insert("dir/b", 20)
+
+	// Reads of the transaction don't see the write, including those of repositories reading in a transaction of their own
+	if count, err := exploreRepo.CountObjects(readCtx, "dir/", ListOptions{}, false); err != nil || count.Count != 1 {
+		t.Errorf("Expected the snapshot count to stay at 1, got %+v, %v", count, err)
+	}
+	summary, err := exploreRepo.GetPathSummary(readCtx, "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Size.Standard != 10 {
+		t.Errorf("Snapshot summary size mismatch: got %d, want 10", summary.Size.Standard)
+	}
+	tree, err := treeRepo.GetTree(readCtx, "dir/", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree["dir/"].Size != 10 {
+		t.Errorf("Snapshot tree size mismatch: got %d, want 10", tree["dir/"].Size)
+	}
+
+	// Reads outside of it see the write
+	if count, err := exploreRepo.CountObjects(ctx, "dir/", ListOptions{}, false); err != nil || count.Count != 2 {
+		t.Errorf("Expected the count to reach 2, got %+v, %v", count, err)
+	}
+
+	// Reads of another database are not part of the transaction
+	other := NewDatabase(":memory:", 1)
+	if err := other.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := NewExploreRepository(other).CountObjects(readCtx, "dir/", ListOptions{}, false); err != nil || count.Count != 0 {
+		t.Errorf("Expected another database to be read outside of the transaction, got %+v, %v", count, err)
+	}
+}
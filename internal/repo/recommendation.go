@@ -0,0 +1,185 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// recommendedTransitions are the transitions considered per prefix, aged as the minimum storage duration
+// of their class so objects untouched that long are unlikely to be read or deleted early once moved
+var recommendedTransitions = []struct {
+	class StorageClass
+	days  int64
+}{
+	{StorageNearline, 30},
+	{StorageColdline, 90},
+	{StorageArchive, 365},
+}
+
+// RecommendLifecycle recommends, for every child prefix of prefix, the transition to a colder storage class
+// saving the most storage cost per month, largest savings first
+// Objects are eligible once older than the age of the transition and stored in a warmer class, unless access logs
+// show them read within that age. Objects directly under prefix are left out, no prefix condition matching them alone
+func (l *Lifecycle) RecommendLifecycle(ctx context.Context, prefix string, now time.Time) (*model.LifecycleRecommendations, error) {
+	type objectRow struct {
+		Name         string    `db:"name"`
+		Size         int64     `db:"size"`
+		StorageClass string    `db:"storage_class"`
+		Created      time.Time `db:"created"`
+		Location     string    `db:"location"`
+		LastRead     *int64    `db:"last_read"`
+	}
+
+	if prefix == "/" {
+		prefix = "" // handle root
+	}
+
+	ctx, cancel := l.withTimeout(ctx)
+	defer cancel()
+
+	result := &model.LifecycleRecommendations{Recommendations: []*model.LifecycleRecommendation{}}
+	if err := l.reader(ctx).GetContext(ctx, &result.AccessData, `SELECT EXISTS (SELECT 1 FROM access_log);`); err != nil {
+		return nil, translateError(err)
+	}
+
+	query := `
+		SELECT m.name, m.size, m.storage_class, m.created,
+			COALESCE((SELECT location FROM bucket WHERE bucket.name = m.bucket), '') AS location,
+			r.last_read
+		FROM metadata m
+		LEFT JOIN object_reads r ON r.bucket = m.bucket AND r.name = m.name
+		WHERE m.name LIKE $1 || '%' AND NOT m.marker;
+	`
+
+	rows, err := l.reader(ctx).QueryxContext(ctx, query, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	// Sizes are summed per location and tier before pricing, as directories are
+	type sizeKey struct {
+		location Location
+		tier     StorageClass
+	}
+	type candidate struct {
+		count, size, recentlyRead int64
+		sizes                     map[sizeKey]int64
+	}
+
+	candidates := make(map[string][]*candidate)
+	for rows.Next() {
+		var row objectRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		childPrefix := getChildPrefix(prefix, row.Name)
+		if childPrefix == prefix || childPrefix == "/" {
+			continue
+		}
+
+		prefixCandidates, ok := candidates[childPrefix]
+		if !ok {
+			prefixCandidates = make([]*candidate, len(recommendedTransitions))
+			for i := range prefixCandidates {
+				prefixCandidates[i] = &candidate{sizes: make(map[sizeKey]int64)}
+			}
+			candidates[childPrefix] = prefixCandidates
+		}
+
+		tier, ok := storageTier(StorageClass(row.StorageClass))
+		if !ok {
+			tier = StorageStandard
+		}
+		ageDays := int64(now.Sub(row.Created).Hours() / 24)
+		for i, t := range recommendedTransitions {
+			if storageRank(tier) >= storageRank(t.class) || ageDays < t.days {
+				continue
+			}
+
+			c := prefixCandidates[i]
+			if row.LastRead != nil && int64(now.Sub(time.Unix(0, *row.LastRead)).Hours()/24) < t.days {
+				c.recentlyRead++
+				continue
+			}
+			c.count++
+			c.size += row.Size
+			c.sizes[sizeKey{pricingLocation(row.Location), tier}] += row.Size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for childPrefix, prefixCandidates := range candidates {
+		var best *model.LifecycleRecommendation
+		for i, c := range prefixCandidates {
+			t := recommendedTransitions[i]
+
+			var currentCost, recommendedCost float64
+			for k, size := range c.sizes {
+				cost, err := getObjectCost(k.location, k.tier, size)
+				if err != nil {
+					return nil, err
+				}
+				currentCost += cost
+
+				if cost, err = getObjectCost(k.location, t.class, size); err != nil {
+					return nil, err
+				}
+				recommendedCost += cost
+			}
+
+			savings := currentCost - recommendedCost
+			if savings <= 0 || (best != nil && savings <= best.MonthlySavings) {
+				continue
+			}
+
+			age := t.days
+			best = &model.LifecycleRecommendation{
+				Prefix: childPrefix,
+				Rule: model.LifecycleRule{
+					Action: model.LifecycleAction{Type: LifecycleSetStorageClass, StorageClass: string(t.class)},
+					Condition: model.LifecycleCondition{
+						Age:                 &age,
+						MatchesStorageClass: warmerStorageClasses(t.class),
+						MatchesPrefix:       []string{childPrefix},
+					},
+				},
+				Description:     fmt.Sprintf("Move %s to %s after %dd, saves ~$%.2f/month", childPrefix, t.class, t.days, savings),
+				Count:           c.count,
+				Size:            c.size,
+				RecentlyRead:    c.recentlyRead,
+				CurrentCost:     currentCost,
+				RecommendedCost: recommendedCost,
+				MonthlySavings:  savings,
+			}
+		}
+		if best != nil {
+			result.Recommendations = append(result.Recommendations, best)
+		}
+	}
+
+	sort.Slice(result.Recommendations, func(i, j int) bool {
+		a, b := result.Recommendations[i], result.Recommendations[j]
+		if a.MonthlySavings != b.MonthlySavings {
+			return a.MonthlySavings > b.MonthlySavings
+		}
+		return a.Prefix < b.Prefix
+	})
+	return result, nil
+}
+
+// warmerStorageClasses returns the tiers warmer than class, so rules never move objects back to a warmer class
+func warmerStorageClasses(class StorageClass) []string {
+	var classes []string
+	for _, tier := range storageTiers[:storageRank(class)] {
+		classes = append(classes, string(tier))
+	}
+	return classes
+}
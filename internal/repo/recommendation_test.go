@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestRecommendLifecycle(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	lifecycleRepo := NewLifecycleRepository(db)
+	metadataRepo := NewMetadataRepository(db)
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.AddDate(0, 0, -days)
+	}
+
+	result, err := lifecycleRepo.RecommendLifecycle(ctx, "/", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessData || len(result.Recommendations) != 0 {
+		t.Fatalf("Expected no recommendation nor access data for an empty database, got %+v", result)
+	}
+
+	for _, m := range []model.Metadata{
+		{Bucket: "mock", Name: "logs/a", Size: 100 * bytesPerGB, StorageClass: "STANDARD", Created: daysAgo(400)},
+		{Bucket: "mock", Name: "data/b", Size: 100 * bytesPerGB, StorageClass: "STANDARD", Created: daysAgo(100)},
+		{Bucket: "mock", Name: "data/c", Size: 50 * bytesPerGB, StorageClass: "STANDARD", Created: daysAgo(100)},
+		{Bucket: "mock", Name: "archive/d", Size: 10 * bytesPerGB, StorageClass: "ARCHIVE", Created: daysAgo(400)},
+		{Bucket: "mock", Name: "e", Size: 100 * bytesPerGB, StorageClass: "STANDARD", Created: daysAgo(400)},
+	} {
+		m.Updated = m.Created
+		if err := metadataRepo.Insert(ctx, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := NewPopularityRepository(db).IngestLog(ctx, "usage_1", []model.ObjectRead{
+		{Bucket: "mock", Name: "data/c", Time: daysAgo(10)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = lifecycleRepo.RecommendLifecycle(ctx, "/", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.AccessData {
+		t.Error("Expected access data once a log was ingested")
+	}
+
+	want := []struct {
+		prefix       string
+		class        string
+		age          int64
+		count        int64
+		recentlyRead int64
+		savings      float64
+	}{
+		// 100 GB moving from 0.023 to 0.0025 per GB
+		{"logs/", "ARCHIVE", 365, 1, 0, 2.05},
+		// 100 GB moving from 0.023 to 0.007 per GB, the other object being read 10 days ago
+		{"data/", "COLDLINE", 90, 1, 1, 1.6},
+	}
+	if len(result.Recommendations) != len(want) {
+		t.Fatalf("Recommendations mismatch: got %d, want %d", len(result.Recommendations), len(want))
+	}
+	for i, w := range want {
+		got := result.Recommendations[i]
+		if got.Prefix != w.prefix || got.Rule.Action.StorageClass != w.class || *got.Rule.Condition.Age != w.age {
+			t.Errorf("Recommendation %d mismatch: got %s to %s after %dd, want %s to %s after %dd",
+				i, got.Prefix, got.Rule.Action.StorageClass, *got.Rule.Condition.Age, w.prefix, w.class, w.age)
+		}
+		if got.Count != w.count || got.RecentlyRead != w.recentlyRead {
+			t.Errorf("Recommendation %d counts mismatch: got %d and %d recently read, want %d and %d",
+				i, got.Count, got.RecentlyRead, w.count, w.recentlyRead)
+		}
+		if math.Round(got.MonthlySavings*100) != math.Round(w.savings*100) {
+			t.Errorf("Recommendation %d savings mismatch: got %f, want %f", i, got.MonthlySavings, w.savings)
+		}
+		if err := ValidateLifecyclePolicy(&model.LifecyclePolicy{Rules: []model.LifecycleRule{got.Rule}}); err != nil {
+			t.Errorf("Recommendation %d rule is invalid: %v", i, err)
+		}
+	}
+
+	if got := result.Recommendations[0].Rule.Condition.MatchesStorageClass; len(got) != 3 {
+		t.Errorf("Expected the archive rule to match the 3 warmer classes, got %v", got)
+	}
+}
@@ -0,0 +1,110 @@
+// Package repotest builds in-memory databases populated from declarative object trees, sparing the tests
+// of the packages built on the repositories from setting databases up and indexing objects one by one
+package repotest
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Epoch is when the objects of trees are created unless they say otherwise
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Object is an object of a tree, named relative to the root of its bucket
+// Objects are STANDARD unless given a storage class, created at Epoch unless given a creation time,
+// and updated when created unless given an update time
+type Object struct {
+	Name         string
+	Size         int64
+	StorageClass string
+	Created      time.Time
+	Updated      time.Time
+}
+
+// Tree lists the objects of every bucket, by bucket name
+type Tree map[string][]Object
+
+// NewDatabase returns an empty in-memory database with the tables of the current schema, closed once t completes
+// Every database lives in its own connection, so tests running in parallel never see each other's objects
+func NewDatabase(t testing.TB) *repo.Database {
+	t.Helper()
+
+	db := repo.NewDatabase(":memory:", 1)
+	if err := db.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// New returns an in-memory database holding the objects of tree, see NewDatabase
+func New(t testing.TB, tree Tree) *repo.Database {
+	t.Helper()
+
+	db := NewDatabase(t)
+	Populate(t, db, tree)
+	return db
+}
+
+// Populate indexes the objects of tree in db and rolls them up into their directories as ingestion does,
+// bucket by bucket in name order and objects in the order listed
+func Populate(t testing.TB, db *repo.Database, tree Tree) {
+	t.Helper()
+
+	ctx := context.Background()
+	metadataRepo := repo.NewMetadataRepository(db)
+	dirRepo := repo.NewDirectoryRepository(db)
+
+	buckets := make([]string, 0, len(tree))
+	for bucket := range tree {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		for _, obj := range tree[bucket] {
+			m := obj.metadata(bucket)
+			if err := metadataRepo.Insert(ctx, m); err != nil {
+				t.Fatalf("Error indexing %s/%s: %v", bucket, obj.Name, err)
+			}
+			if err := dirRepo.UpsertParentDirs(ctx, repo.StorageClass(m.StorageClass), bucket, m.Name, m.Size, 1); err != nil {
+				t.Fatalf("Error rolling %s/%s up: %v", bucket, obj.Name, err)
+			}
+		}
+	}
+}
+
+// metadata returns the metadata of the object in bucket, defaults applied
+func (o Object) metadata(bucket string) *model.Metadata {
+	m := &model.Metadata{
+		Bucket:       bucket,
+		Name:         o.Name,
+		Size:         o.Size,
+		StorageClass: o.StorageClass,
+		Created:      o.Created,
+		Updated:      o.Updated,
+	}
+	if len(m.StorageClass) == 0 {
+		m.StorageClass = string(repo.StorageStandard)
+	}
+	if m.Created.IsZero() {
+		m.Created = Epoch
+	}
+	if m.Updated.IsZero() {
+		m.Updated = m.Created
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestNew(t *testing.T) {
+	db := New(t, Tree{
+		"mock": {
+			{Name: "a/file1", Size: 10},
+			{Name: "a/b/file2", Size: 20, StorageClass: "NEARLINE"},
+			{Name: "file3", Size: 5, Created: Epoch.Add(-time.Hour), Updated: Epoch},
+		},
+		"other": {
+			{Name: "c/file4", Size: 1},
+		},
+	})
+	ctx := context.Background()
+
+	obj, err := repo.NewMetadataRepository(db).Get(ctx, "mock", "file3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.StorageClass != "STANDARD" || !obj.Created.Equal(Epoch.Add(-time.Hour)) || !obj.Updated.Equal(Epoch) {
+		t.Errorf("Object mismatch: got %+v", obj)
+	}
+
+	summary, err := repo.NewExploreRepository(db).GetPathSummary(ctx, "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Size.Standard != 10 || summary.Size.Nearline != 20 {
+		t.Errorf("Summary of a/ mismatch: got %+v", summary.Size)
+	}
+}
+
+func TestNewParallel(t *testing.T) {
+	for i := range 8 {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+
+			// Every test only sees the objects of its own tree
+			objects := make([]Object, i+1)
+			for j := range objects {
+				objects[j] = Object{Name: fmt.Sprintf("dir/%d", j), Size: 1}
+			}
+			db := New(t, Tree{"mock": objects})
+
+			contents, err := repo.NewExploreRepository(db).GetPathContents(context.Background(), "dir/", repo.SortBySize, repo.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Listings include the directory itself
+			if len(contents) != i+2 {
+				t.Errorf("Content count mismatch: got %d, want %d", len(contents), i+2)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// reservationSchema is part of the schema, and added to databases created before reservations
+const reservationSchema = `
+	CREATE TABLE reservation (
+		id			INTEGER PRIMARY KEY AUTOINCREMENT,
+		bucket		TEXT NOT NULL,
+		prefix		TEXT NOT NULL,
+		bytes		INTEGER NOT NULL,
+		expires_at	TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX reservation_bucket_expires_at ON reservation (bucket, expires_at);
+`
+
+const (
+	// DefaultReservationTTL is how long reservations count against budgets unless requested otherwise
+	DefaultReservationTTL = 15 * time.Minute
+	// MaxReservationTTL bounds how long reservations count against budgets
+	MaxReservationTTL = 24 * time.Hour
+)
+
+type Reservation struct {
+	*Database
+}
+
+type ReservationRepository interface {
+	Reserve(ctx context.Context, bucket string, prefix string, bytes int64, ttl time.Duration) (*model.Reservation, error)
+	Release(ctx context.Context, id int64) error
+}
+
+func NewReservationRepository(db *Database) ReservationRepository {
+	return &Reservation{db}
+}
+
+// budgetPrefix normalizes a prefix to the name of its directory, the root being "/"
+func budgetPrefix(prefix string) string {
+	if len(prefix) == 0 || prefix == "/" {
+		return "/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Reserve checks bytes written under prefix against the budgets configured for the bucket on prefix and its parents
+// and records a reservation expiring after ttl if the billable size of every budgeted prefix,
+// its outstanding reservations and bytes fit in its budget, or returns ErrNotFound if the bucket is not registered
+// Prefixes without budgets are always granted, their reservations counting against budgets configured later
+func (r *Reservation) Reserve(ctx context.Context, bucket string, prefix string, bytes int64, ttl time.Duration) (*model.Reservation, error) {
+	if bytes < 0 {
+		return nil, errors.New("reserved bytes must not be negative")
+	}
+
+	prefix = budgetPrefix(prefix)
+	now := r.clock.Now().UTC()
+	expiresAt := now.Add(ttl)
+
+	var reservation *model.Reservation
+	err := r.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		reservation = &model.Reservation{Bucket: bucket, Prefix: prefix, Bytes: bytes, Granted: true, Budgets: []*model.BudgetUsage{}}
+
+		var data string
+		err := tx.QueryRowContext(ctx, `SELECT config FROM bucket WHERE name = $1;`, bucket).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var cfg model.BucketConfig
+		if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+			return fmt.Errorf("error decoding config of bucket %s: %w", bucket, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM reservation WHERE expires_at <= $1;`, now); err != nil {
+			return err
+		}
+
+		for budgeted, budget := range cfg.Budgets {
+			budgeted = budgetPrefix(budgeted)
+			if budgeted != "/" && !strings.HasPrefix(prefix, budgeted) {
+				continue
+			}
+
+			usage := &model.BudgetUsage{Prefix: budgeted, Budget: budget}
+			err := tx.QueryRowContext(ctx, `
+				SELECT `+directorySizeExpr+` + noncurrent_size
+				FROM directory
+				WHERE bucket = $1 AND name = $2;
+			`, bucket, budgeted).Scan(&usage.Used)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			err = tx.QueryRowContext(ctx, `
+				SELECT COALESCE(SUM(bytes), 0)
+				FROM reservation
+				WHERE bucket = $1 AND expires_at > $2 AND ($3 = '/' OR substr(prefix, 1, length($3)) = $3);
+			`, bucket, now, budgeted).Scan(&usage.Reserved)
+			if err != nil {
+				return err
+			}
+
+			usage.Remaining = max(budget-usage.Used-usage.Reserved, 0)
+			if bytes > usage.Remaining {
+				reservation.Granted = false
+			}
+			reservation.Budgets = append(reservation.Budgets, usage)
+		}
+
+		sort.Slice(reservation.Budgets, func(i, j int) bool {
+			return reservation.Budgets[i].Prefix < reservation.Budgets[j].Prefix
+		})
+
+		if !reservation.Granted {
+			return nil
+		}
+
+		reservation.ExpiresAt = &expiresAt
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO reservation (bucket, prefix, bytes, expires_at)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id;
+		`, bucket, prefix, bytes, expiresAt).Scan(&reservation.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Release stops a reservation from counting against budgets before it expires, or returns ErrNotFound
+func (r *Reservation) Release(ctx context.Context, id int64) error {
+	return r.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM reservation WHERE id = $1 AND expires_at > $2;`, id, r.clock.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
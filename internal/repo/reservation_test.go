@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestReservation(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	db.SetClock(clk)
+
+	ctx := context.Background()
+	reservationRepo := NewReservationRepository(db)
+	bucketRepo := NewBucketRepository(db)
+
+	if _, err := reservationRepo.Reserve(ctx, "mock", "a/", 1, time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unregistered bucket, got %v", err)
+	}
+
+	if err := bucketRepo.Upsert(ctx, model.Bucket{Name: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucketRepo.SetConfig(ctx, "mock", &model.BucketConfig{Budgets: map[string]int64{"/": 100, "a": 20}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "a/b/file", 4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	granted, err := reservationRepo.Reserve(ctx, "mock", "a/b", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !granted.Granted || granted.ID == 0 || granted.Prefix != "a/b/" || granted.ExpiresAt == nil {
+		t.Errorf("Expected a granted reservation, got %+v", granted)
+	}
+	if len(granted.Budgets) != 2 || granted.Budgets[0].Prefix != "/" || granted.Budgets[1].Prefix != "a/" {
+		t.Fatalf("Budgets mismatch: got %+v", granted.Budgets)
+	}
+	if usage := granted.Budgets[1]; usage.Used != 4 || usage.Reserved != 0 || usage.Remaining != 16 {
+		t.Errorf("Budget of a/ mismatch: got %+v", usage)
+	}
+
+	denied, err := reservationRepo.Reserve(ctx, "mock", "a/", 15, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if denied.Granted || denied.ID != 0 || denied.ExpiresAt != nil {
+		t.Errorf("Expected a denied reservation, got %+v", denied)
+	}
+	if usage := denied.Budgets[1]; usage.Reserved != 10 || usage.Remaining != 6 {
+		t.Errorf("Budget of a/ mismatch after reservation: got %+v", usage)
+	}
+
+	unbudgeted, err := reservationRepo.Reserve(ctx, "mock", "c/", 50, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unbudgeted.Granted || len(unbudgeted.Budgets) != 1 || unbudgeted.Budgets[0].Remaining != 86 {
+		t.Errorf("Expected a reservation checked against the root budget only, got %+v", unbudgeted)
+	}
+
+	clk.Advance(2 * time.Minute)
+
+	if err := reservationRepo.Release(ctx, granted.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound releasing an expired reservation, got %v", err)
+	}
+
+	regranted, err := reservationRepo.Reserve(ctx, "mock", "a/", 15, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regranted.Granted || regranted.Budgets[0].Reserved != 50 {
+		t.Errorf("Expected expired reservations to stop counting, got %+v", regranted)
+	}
+
+	if err := reservationRepo.Release(ctx, unbudgeted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := reservationRepo.Release(ctx, unbudgeted.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound releasing twice, got %v", err)
+	}
+}
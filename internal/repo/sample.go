@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// SampleRate is one in how many objects are kept in the sample approximate counts are extrapolated from
+const SampleRate = 256
+
+// sampledFunc is the SQL function telling whether an object name is part of the sample
+const sampledFunc = "sampled_name"
+
+// z95 is the standard score of a two-sided 95% confidence interval
+const z95 = 1.96
+
+// sampleSchema is part of the schema, and added to databases created before counts could be approximated
+// Objects are sampled by a hash of their name, so the sample is maintained as objects change without coordination
+const sampleSchema = `
+	CREATE TABLE object_sample (
+		bucket			TEXT NOT NULL,
+		name			TEXT NOT NULL,
+		size			INTEGER NOT NULL,
+		storage_class	TEXT NOT NULL,
+		updated			TIMESTAMP NOT NULL,
+		PRIMARY KEY (bucket, name)
+	);
+`
+
+// sampleBackfill samples the objects of databases created before counts could be approximated
+const sampleBackfill = `
+	INSERT INTO object_sample (bucket, name, size, storage_class, updated)
+	SELECT bucket, name, size, storage_class, updated FROM metadata WHERE ` + sampledFunc + `(name);
+`
+
+// sampled reports whether the object named name is part of the sample, one in SampleRate names hashing into it
+func sampled(name string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()%SampleRate == 0
+}
+
+// countRow is the number and size of the objects matching a count, and the sum of their squared sizes
+type countRow struct {
+	Count      int64   `db:"count"`
+	Size       int64   `db:"size"`
+	SizeSquare float64 `db:"size_square"`
+}
+
+// CountObjects counts the objects under path and their size across buckets, narrowed down by opts
+// Exact counts scan every matching object, approximate ones the sampled objects only, their margins bounding
+// the error with 95% confidence
+func (e *Explore) CountObjects(ctx context.Context, path string, opts ListOptions, approximate bool) (*model.ObjectCount, error) {
+	// Names are stored without the root
+	prefix := path
+	if path == "/" {
+		prefix = ""
+	}
+
+	table := "metadata"
+	if approximate {
+		table = "object_sample"
+	}
+
+	// The case-insensitive index narrows the scan of the metadata table down to the names starting with prefix
+	lower := asciiLower(prefix)
+	args := []any{lower, prefixEnd(lower), prefix, prefixEnd(prefix)}
+	filter := `name COLLATE NOCASE >= ? AND name COLLATE NOCASE < ? AND name >= ? AND name < ?`
+	if !opts.IncludeMarkers {
+		filter += " AND NOT " + markerExpr
+	}
+	filter += opts.objectConditions(func(v any) string {
+		args = append(args, v)
+		return "?"
+	})
+
+	query := `
+		SELECT
+			COUNT(*) AS count,
+			COALESCE(SUM(size), 0) AS size,
+			COALESCE(SUM(CAST(size AS REAL) * size), 0) AS size_square
+		FROM ` + table + `
+		WHERE ` + filter + `;
+	`
+
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	var row countRow
+	if err := e.reader(ctx).GetContext(ctx, &row, query, args...); err != nil {
+		return nil, translateError(err)
+	}
+
+	count := &model.ObjectCount{Path: path, Count: row.Count, Size: row.Size}
+	if approximate {
+		count.Approximate = true
+		count.Sampled = row.Count
+		count.SampleRate = SampleRate
+		count.Count, count.Size, count.CountMargin, count.SizeMargin = extrapolate(row)
+	}
+	return count, nil
+}
+
+// extrapolate scales the count and size of sampled objects up to every object, with the margins of a 95%
+// confidence interval of a binomial sample
+// No sampled object still leaves up to 3 in a sample's worth of objects unseen, by the rule of three
+func extrapolate(row countRow) (count, size, countMargin, sizeMargin int64) {
+	rate := float64(SampleRate)
+	unsampled := 1 - 1/rate
+
+	count = row.Count * SampleRate
+	size = row.Size * SampleRate
+	if row.Count == 0 {
+		return count, size, 3 * SampleRate, 0
+	}
+	countMargin = int64(math.Ceil(z95 * rate * math.Sqrt(float64(row.Count)*unsampled)))
+	sizeMargin = int64(math.Ceil(z95 * rate * math.Sqrt(row.SizeSquare*unsampled)))
+	return count, size, countMargin, sizeMargin
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestCountObjects(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metadataRepo := NewMetadataRepository(db)
+	exploreRepo := NewExploreRepository(db)
+
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	insert := func(name string, size int64, class StorageClass) {
+		obj := &model.Metadata{Bucket: "mock", Name: name, Size: size, StorageClass: string(class), Created: updated, Updated: updated}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const objects = 20000
+	for i := range objects {
+		class := StorageStandard
+		if i%2 == 1 {
+			class = StorageArchive
+		}
+		insert(fmt.Sprintf("wide/%d", i), 100, class)
+	}
+	insert("wide/", 0, StorageStandard)
+	insert("other/a", 1, StorageStandard)
+
+	t.Run("Exact", func(t *testing.T) {
+		got, err := exploreRepo.CountObjects(ctx, "wide/", ListOptions{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := model.ObjectCount{Path: "wide/", Count: objects, Size: 100 * objects}
+		if *got != want {
+			t.Errorf("count mismatch: got %+v want %+v", *got, want)
+		}
+	})
+
+	t.Run("Exact with markers", func(t *testing.T) {
+		got, err := exploreRepo.CountObjects(ctx, "/", ListOptions{IncludeMarkers: true}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != objects+2 {
+			t.Errorf("count mismatch: got %d want %d", got.Count, objects+2)
+		}
+	})
+
+	t.Run("Approximate", func(t *testing.T) {
+		got, err := exploreRepo.CountObjects(ctx, "wide/", ListOptions{}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Approximate || got.SampleRate != SampleRate || got.Sampled == 0 {
+			t.Fatalf("expected an approximate count from a sample, got %+v", *got)
+		}
+		if got.Count != got.Sampled*SampleRate || got.Size != 100*got.Count {
+			t.Errorf("expected sampled objects to be scaled up, got %+v", *got)
+		}
+		if diff := got.Count - objects; diff > got.CountMargin || -diff > got.CountMargin {
+			t.Errorf("count %d±%d does not cover %d", got.Count, got.CountMargin, objects)
+		}
+		if diff := got.Size - 100*objects; diff > got.SizeMargin || -diff > got.SizeMargin {
+			t.Errorf("size %d±%d does not cover %d", got.Size, got.SizeMargin, 100*objects)
+		}
+	})
+
+	t.Run("Approximate filtered", func(t *testing.T) {
+		got, err := exploreRepo.CountObjects(ctx, "wide/", ListOptions{StorageClasses: []StorageClass{StorageArchive}}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := got.Count - objects/2; diff > got.CountMargin || -diff > got.CountMargin {
+			t.Errorf("count %d±%d does not cover %d", got.Count, got.CountMargin, objects/2)
+		}
+	})
+
+	t.Run("Approximate without samples", func(t *testing.T) {
+		got, err := exploreRepo.CountObjects(ctx, "missing/", ListOptions{}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := model.ObjectCount{Path: "missing/", Approximate: true, CountMargin: 3 * SampleRate, SampleRate: SampleRate}
+		if *got != want {
+			t.Errorf("count mismatch: got %+v want %+v", *got, want)
+		}
+	})
+
+	t.Run("Sample follows updates and deletes", func(t *testing.T) {
+		var name string
+		for i := range objects {
+			if name = fmt.Sprintf("wide/%d", i); sampled(name) {
+				break
+			}
+		}
+
+		if err := metadataRepo.Update(ctx, "mock", name, 500, nil, updated.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		var size int64
+		if err := db.DB.GetContext(ctx, &size, `SELECT size FROM object_sample WHERE bucket = 'mock' AND name = ?;`, name); err != nil {
+			t.Fatal(err)
+		}
+		if size != 500 {
+			t.Errorf("sampled size mismatch: got %d want 500", size)
+		}
+
+		if err := metadataRepo.Delete(ctx, "mock", name); err != nil {
+			t.Fatal(err)
+		}
+		var exists bool
+		if err := db.DB.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM object_sample WHERE bucket = 'mock' AND name = ?);`, name); err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Errorf("expected %s to leave the sample once deleted", name)
+		}
+	})
+
+	t.Run("Backfill samples the same objects", func(t *testing.T) {
+		var before int64
+		if err := db.DB.GetContext(ctx, &before, `SELECT COUNT(*) FROM object_sample;`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.DB.ExecContext(ctx, `DELETE FROM object_sample;`+sampleBackfill); err != nil {
+			t.Fatal(err)
+		}
+		var after int64
+		if err := db.DB.GetContext(ctx, &after, `SELECT COUNT(*) FROM object_sample;`); err != nil {
+			t.Fatal(err)
+		}
+		if before == 0 || after != before {
+			t.Errorf("sample size mismatch after backfill: got %d want %d", after, before)
+		}
+	})
+}
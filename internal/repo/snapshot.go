@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SnapshotExtension is the file extension of zstd compressed snapshots
+const SnapshotExtension = ".zst"
+
+// WriteSnapshot streams a zstd compressed copy of the whole database to w,
+// compressed at level from 1 (fastest) to 22 (smallest)
+// The copy is persisted to a temporary file first, so writes are only held back while it is taken
+func (db *Database) WriteSnapshot(ctx context.Context, w io.Writer, level int) error {
+	dir, err := os.MkdirTemp("", "snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metadata.db")
+	if err := db.Persist(ctx, path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, f); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadSnapshot decompresses a snapshot written by WriteSnapshot into the database file at path, replacing it
+// once the whole snapshot was read, so a corrupt snapshot leaves the file as it was
+func ReadSnapshot(r io.Reader, path string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, zr); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
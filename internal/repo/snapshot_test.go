@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDatabase(InMemoryURL(t.Name()), 1)
+	if err := db.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := &model.Metadata{Bucket: "mock", Name: "a/file", Size: 1, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+	if err := NewMetadataRepository(db).Insert(ctx, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := db.WriteSnapshot(ctx, &snapshot, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	if err := ReadSnapshot(&snapshot, path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewDatabase(path, 1)
+	if err := restored.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if _, err := NewMetadataRepository(restored).Get(ctx, "mock", "a/file"); err != nil {
+		t.Errorf("Expected the snapshot to hold a/file, got %v", err)
+	}
+
+	if err := ReadSnapshot(bytes.NewReader([]byte("not a snapshot")), path); err == nil {
+		t.Error("Expected an error reading an uncompressed file")
+	}
+	if _, err := NewMetadataRepository(restored).Get(ctx, "mock", "a/file"); err != nil {
+		t.Errorf("Expected a failed read to leave the file as it was, got %v", err)
+	}
+}
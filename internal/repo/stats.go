@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	// statsWindow is the granularity of write statistics
+	statsWindow = time.Minute
+	// StatsRetention is how long write statistics are kept
+	StatsRetention = 24 * time.Hour
+)
+
+// writeStatsSchema is part of the schema, and added to databases created before writes were counted
+const writeStatsSchema = `
+	CREATE TABLE write_stats (
+		bucket			TEXT NOT NULL,
+		prefix			TEXT NOT NULL,
+		window_start	INTEGER NOT NULL, -- unix time of the window
+		events			INTEGER DEFAULT 0,
+		last_write		INTEGER NOT NULL, -- unix time in nanoseconds of the latest write
+		PRIMARY KEY (bucket, prefix, window_start)
+	);
+`
+
+type Stats struct {
+	*Database
+	lagPruned    time.Time // last notification_lag window pruned
+	eventsPruned time.Time // last event_stats window pruned
+}
+
+type StatsRepository interface {
+	GetWriteStats(ctx context.Context, since time.Time) ([]*model.PrefixWriteStat, error)
+	GetLastWrite(ctx context.Context) (time.Time, error)
+	RecordLag(ctx context.Context, bucket string, objName string, lag time.Duration) error
+	GetNotificationLag(ctx context.Context, since time.Time) ([]*model.PrefixLag, error)
+	RecordEvent(ctx context.Context, bucket string, eventType string) error
+	GetEventStats(ctx context.Context, since time.Time, bucket string) ([]*model.EventStat, error)
+}
+
+func NewStatsRepository(db *Database) StatsRepository {
+	return &Stats{Database: db}
+}
+
+// getTopLevelPrefix returns the top level directory of an object name, or root for root level objects
+func getTopLevelPrefix(objName string) string {
+	i := strings.Index(objName, "/")
+	if i == -1 {
+		return "/"
+	}
+	return objName[:i+1]
+}
+
+// recordWrite counts an applied write in the current window of the object's top level prefix
+// Windows older than StatsRetention are pruned once per window
+func recordWrite(ctx context.Context, tx *sql.Tx, bucket string, objName string, now time.Time, lastPruned *time.Time) error {
+	window := now.Truncate(statsWindow)
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO write_stats (bucket, prefix, window_start, events, last_write)
+		VALUES ($1, $2, $3, 1, $4)
+		ON CONFLICT(bucket, prefix, window_start)
+		DO UPDATE SET events = events + 1,
+			last_write = MAX(last_write, $4);
+	`, bucket, getTopLevelPrefix(objName), window.Unix(), now.UnixNano()); err != nil {
+		return err
+	}
+
+	if !window.After(*lastPruned) {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM write_stats WHERE window_start < $1;`, window.Add(-StatsRetention).Unix()); err != nil {
+		return err
+	}
+	*lastPruned = window
+	return nil
+}
+
+// GetWriteStats returns the number of writes applied per top level prefix since a given time,
+// ordered from the busiest prefix
+func (s *Stats) GetWriteStats(ctx context.Context, since time.Time) ([]*model.PrefixWriteStat, error) {
+	query := `
+		SELECT prefix, SUM(events) AS events
+		FROM write_stats
+		WHERE window_start >= $1
+		GROUP BY prefix
+		ORDER BY events DESC, prefix;
+	`
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stats := []*model.PrefixWriteStat{}
+	if err := s.reader(ctx).SelectContext(ctx, &stats, query, since.Truncate(statsWindow).Unix()); err != nil {
+		return nil, translateError(err)
+	}
+	return stats, nil
+}
+
+// GetLastWrite returns the time of the latest applied write, or the zero time if nothing was written
+func (s *Stats) GetLastWrite(ctx context.Context) (time.Time, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var lastWrite sql.NullInt64
+	if err := s.reader(ctx).QueryRowContext(ctx, `SELECT MAX(last_write) FROM write_stats;`).Scan(&lastWrite); err != nil {
+		return time.Time{}, translateError(err)
+	}
+
+	if !lastWrite.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, lastWrite.Int64), nil
+}
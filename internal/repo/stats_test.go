@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetTopLevelPrefix(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"Root file", "mock", "/"},
+		{"Root level directory", "mock/", "mock/"},
+		{"Nested file", "mock-1/mock-2/file", "mock-1/"},
+		{"Leading slash", "/mock", "/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getTopLevelPrefix(tc.in); got != tc.want {
+				t.Errorf("Prefix mismatch: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetWriteStats(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	statsRepo := NewStatsRepository(db)
+
+	for _, name := range []string{"logs/a", "logs/b/c", "logs/d", "data/e", "f"} {
+		if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", name, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := statsRepo.GetWriteStats(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		prefix string
+		events int64
+	}{
+		{"logs/", 3},
+		{"/", 1},
+		{"data/", 1},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Return count mismatch: got %d, want %d", len(got), len(want))
+	}
+
+	for i, w := range want {
+		if got[i].Prefix != w.prefix || got[i].Events != w.events {
+			t.Errorf("Stat %d mismatch: got %+v, want %+v", i, got[i], w)
+		}
+	}
+
+	// Windows before since are excluded
+	got, err = statsRepo.GetWriteStats(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("Expected no stats, got %d", len(got))
+	}
+}
+
+func TestRecordWritePrunes(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	var lastPruned time.Time
+
+	record := func(at time.Time) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordWrite(ctx, tx, "mock", "mock/file", at, &lastPruned); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record(now.Add(-2 * StatsRetention))
+	record(now)
+
+	var windows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM write_stats`).Scan(&windows); err != nil {
+		t.Fatal(err)
+	}
+
+	if windows != 1 {
+		t.Errorf("Expected expired window to be pruned, got %d windows", windows)
+	}
+}
+
+func TestGetLastWrite(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	statsRepo := NewStatsRepository(db)
+
+	got, err := statsRepo.GetLastWrite(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.IsZero() {
+		t.Errorf("Expected zero time without writes, got %v", got)
+	}
+
+	before := time.Now()
+	if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "mock/file", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err = statsRepo.GetLastWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Before(before) || got.After(time.Now()) {
+		t.Errorf("Last write mismatch: got %v, want after %v", got, before)
+	}
+}
@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// storageTiers are the storage classes directories roll sizes up in, from hottest to coldest
+// Every other storage class is rolled up in the tier it is registered with
+var storageTiers = []StorageClass{StorageStandard, StorageNearline, StorageColdline, StorageArchive}
+
+var (
+	storageClassesMu sync.RWMutex
+	// storageClasses maps storage classes to the tier they are rolled up and priced in
+	storageClasses = map[StorageClass]StorageClass{
+		StorageStandard: StorageStandard,
+		StorageNearline: StorageNearline,
+		StorageColdline: StorageColdline,
+		StorageArchive:  StorageArchive,
+
+		// Legacy classes are billed as standard storage
+		"MULTI_REGIONAL":               StorageStandard,
+		"REGIONAL":                     StorageStandard,
+		"DURABLE_REDUCED_AVAILABILITY": StorageStandard,
+	}
+)
+
+// RegisterStorageClass rolls class up in tier, one of STANDARD, NEARLINE, COLDLINE or ARCHIVE,
+// so new GCS storage classes or custom groupings such as HOT or COLD are counted and priced as tier
+// Tiers themselves cannot be registered again
+func RegisterStorageClass(class StorageClass, tier StorageClass) error {
+	class, tier = StorageClass(strings.ToUpper(strings.TrimSpace(string(class)))), StorageClass(strings.ToUpper(string(tier)))
+	if len(class) == 0 {
+		return errors.New("storage class must not be empty")
+	}
+	if !slices.Contains(storageTiers, tier) {
+		return fmt.Errorf("storage class %s: invalid tier %q, must be STANDARD, NEARLINE, COLDLINE or ARCHIVE", class, tier)
+	}
+	if slices.Contains(storageTiers, class) {
+		return fmt.Errorf("storage class %s is a tier", class)
+	}
+
+	storageClassesMu.Lock()
+	defer storageClassesMu.Unlock()
+	storageClasses[class] = tier
+	return nil
+}
+
+// RegisterStorageClasses registers every storage class of classes with its tier
+func RegisterStorageClasses(classes map[string]string) error {
+	for class, tier := range classes {
+		if err := RegisterStorageClass(StorageClass(class), StorageClass(tier)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseStorageClass returns the registered storage class named by class, ignoring case
+func ParseStorageClass(class string) (StorageClass, error) {
+	storageClass := StorageClass(strings.ToUpper(strings.TrimSpace(class)))
+	if _, ok := storageTier(storageClass); !ok {
+		return "", fmt.Errorf("unknown storage class %q", class)
+	}
+	return storageClass, nil
+}
+
+// storageTier returns the tier class is rolled up in
+func storageTier(class StorageClass) (StorageClass, bool) {
+	storageClassesMu.RLock()
+	defer storageClassesMu.RUnlock()
+	tier, ok := storageClasses[class]
+	return tier, ok
+}
+
+// storageRank orders storage classes by the tier they are rolled up in, from hottest to coldest
+// Unknown storage classes rank as the hottest tier
+func storageRank(class StorageClass) int {
+	tier, _ := storageTier(class)
+	return max(slices.Index(storageTiers, tier), 0)
+}
+
+// storageClassMembers returns class and every storage class rolled up in it, sorted
+func storageClassMembers(class StorageClass) []StorageClass {
+	storageClassesMu.RLock()
+	defer storageClassesMu.RUnlock()
+
+	members := []StorageClass{class}
+	for member, tier := range storageClasses {
+		if tier == class && member != class {
+			members = append(members, member)
+		}
+	}
+	slices.Sort(members[1:])
+	return members
+}
@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRegisterStorageClass(t *testing.T) {
+	t.Cleanup(func() {
+		storageClassesMu.Lock()
+		defer storageClassesMu.Unlock()
+		delete(storageClasses, "HOT")
+		delete(storageClasses, "COLD")
+	})
+
+	if err := RegisterStorageClasses(map[string]string{"hot": "standard", "COLD": "ARCHIVE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		class    StorageClass
+		tier     StorageClass
+		wantErr  bool
+		wantRank int
+	}{
+		{"Custom class", "HOT", StorageStandard, false, 0},
+		{"Custom cold class", "COLD", StorageArchive, false, 3},
+		{"Legacy class", "DURABLE_REDUCED_AVAILABILITY", StorageStandard, false, 0},
+		{"Tier", StorageColdline, StorageColdline, false, 2},
+		{"Unknown class", "WARM", "", true, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			class, err := ParseStorageClass(string(tc.class))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseStorageClass() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if tier, _ := storageTier(class); tier != tc.tier {
+				t.Errorf("Tier mismatch: got %s, want %s", tier, tc.tier)
+			}
+			if rank := storageRank(class); rank != tc.wantRank {
+				t.Errorf("Rank mismatch: got %d, want %d", rank, tc.wantRank)
+			}
+		})
+	}
+
+	if members := storageClassMembers(StorageArchive); !slices.Equal(members, []StorageClass{StorageArchive, "COLD"}) {
+		t.Errorf("Members mismatch: got %v", members)
+	}
+
+	for _, invalid := range [][2]StorageClass{{"", StorageStandard}, {"HOT", "WARM"}, {StorageNearline, StorageArchive}} {
+		if err := RegisterStorageClass(invalid[0], invalid[1]); err == nil {
+			t.Errorf("Expected registering %s as %s to fail", invalid[0], invalid[1])
+		}
+	}
+}
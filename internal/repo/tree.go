@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+type Tree struct {
+	*Database
+}
+
+type TreeRepository interface {
+	GetTree(ctx context.Context, prefix string, asOf time.Time) (map[string]model.DirectoryTotals, error)
+}
+
+func NewTreeRepository(db *Database) TreeRepository {
+	return &Tree{db}
+}
+
+// GetTree returns the totals of prefix and every directory under it, the current totals if asOf is zero
+// Totals as of a point in time are derived from the current totals by reverting the changes recorded since,
+// from the start of its history window
+func (t *Tree) GetTree(ctx context.Context, prefix string, asOf time.Time) (map[string]model.DirectoryTotals, error) {
+	type totalsRow struct {
+		Name  string `db:"name"`
+		Size  int64  `db:"size"`
+		Count int64  `db:"count"`
+	}
+
+	totalsQuery := `
+		SELECT name, SUM(` + directorySizeExpr + `) AS size, SUM(count) AS count
+		FROM directory
+		WHERE $1 = '/' OR substr(name, 1, length($1)) = $1
+		GROUP BY name;
+	`
+
+	deltasQuery := `
+		SELECT name, SUM(size_delta) AS size, SUM(count_delta) AS count
+		FROM directory_history
+		WHERE ($1 = '/' OR substr(name, 1, length($1)) = $1) AND window_start >= $2
+		GROUP BY name;
+	`
+
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	// Read both in one transaction so writes in between can't skew the derived totals
+	var totals, deltas []totalsRow
+	err := t.withReadTx(ctx, func(r reader) error {
+		if err := r.SelectContext(ctx, &totals, totalsQuery, prefix); err != nil {
+			return err
+		}
+		if asOf.IsZero() {
+			return nil
+		}
+		return r.SelectContext(ctx, &deltas, deltasQuery, prefix, asOf.Truncate(historyWindow).Unix())
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	tree := make(map[string]model.DirectoryTotals, len(totals))
+	for _, row := range totals {
+		tree[row.Name] = model.DirectoryTotals{Size: row.Size, Count: row.Count}
+	}
+	if asOf.IsZero() {
+		return tree, nil
+	}
+
+	for _, d := range deltas {
+		totals := tree[d.Name]
+		totals.Size -= d.Size
+		totals.Count -= d.Count
+		tree[d.Name] = totals
+	}
+	return tree, nil
+}
+
+// DiffTrees compares the directory trees under prefix of two snapshots, ignoring directories outside of it
+// Directories holding nothing count as missing, and changed directories deeper than depth below prefix
+// are left out unless depth is 0
+func DiffTrees(prefix string, from map[string]model.DirectoryTotals, to map[string]model.DirectoryTotals, depth int) *model.TreeDiff {
+	exists := func(tree map[string]model.DirectoryTotals, name string) bool {
+		totals, ok := tree[name]
+		return ok && (totals.Size != 0 || totals.Count != 0)
+	}
+
+	diff := &model.TreeDiff{
+		Prefix:     prefix,
+		SizeDelta:  to[prefix].Size - from[prefix].Size,
+		CountDelta: to[prefix].Count - from[prefix].Count,
+		Added:      []*model.SubtreeChange{},
+		Removed:    []*model.SubtreeChange{},
+		Changed:    []*model.SubtreeChange{},
+	}
+
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+
+	for name := range names {
+		if prefix != "/" && !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		change := &model.SubtreeChange{
+			Name:       name,
+			From:       from[name],
+			To:         to[name],
+			SizeDelta:  to[name].Size - from[name].Size,
+			CountDelta: to[name].Count - from[name].Count,
+		}
+
+		inFrom, inTo := exists(from, name), exists(to, name)
+		switch {
+		case !inFrom && inTo:
+			// Subdirectories of an added directory are added along with it
+			if name == prefix || exists(from, getParentDir(name)) {
+				diff.Added = append(diff.Added, change)
+			}
+		case inFrom && !inTo:
+			if name == prefix || exists(to, getParentDir(name)) {
+				diff.Removed = append(diff.Removed, change)
+			}
+		case inFrom && inTo && (change.SizeDelta != 0 || change.CountDelta != 0):
+			if depth == 0 || treeDepth(prefix, name) <= depth {
+				diff.Changed = append(diff.Changed, change)
+			}
+		}
+	}
+
+	for _, changes := range [][]*model.SubtreeChange{diff.Added, diff.Removed, diff.Changed} {
+		sortSubtreeChanges(changes)
+	}
+	return diff
+}
+
+// treeDepth returns how many levels directory name is below prefix
+func treeDepth(prefix string, name string) int {
+	if prefix == "/" {
+		if name == "/" {
+			return 0
+		}
+		return strings.Count(name, "/")
+	}
+	return strings.Count(strings.TrimPrefix(name, prefix), "/")
+}
+
+// sortSubtreeChanges orders changes from the largest change in bytes, either way
+func sortSubtreeChanges(changes []*model.SubtreeChange) {
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	slices.SortFunc(changes, func(a, b *model.SubtreeChange) int {
+		if c := cmp.Compare(abs(b.SizeDelta), abs(a.SizeDelta)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
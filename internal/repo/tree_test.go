@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestTreeDiff(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	db.SetClock(clk)
+
+	ctx := context.Background()
+	dirRepo := NewDirectoryRepository(db)
+	treeRepo := NewTreeRepository(db)
+
+	upsert := func(name string, size int64, count int64) {
+		t.Helper()
+		if err := dirRepo.UpsertParentDirs(ctx, StorageStandard, "mock", name, size, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upsert("a/b/file", 4, 1)
+	upsert("c/file", 6, 1)
+
+	clk.Advance(2 * time.Hour)
+	upsert("a/new/file", 10, 1)
+	upsert("d/e/file", 3, 1)
+	upsert("a/b/other", 1, 1)
+	upsert("c/file", -6, -1)
+
+	current, err := treeRepo.GetTree(ctx, "/", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := current["a/"]; got.Size != 15 || got.Count != 3 {
+		t.Errorf("Current totals of a/ mismatch: got %+v", got)
+	}
+
+	past, err := treeRepo.GetTree(ctx, "/", start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := past["a/"]; got.Size != 4 || got.Count != 1 {
+		t.Errorf("Past totals of a/ mismatch: got %+v", got)
+	}
+	if got := past["c/"]; got.Size != 6 || got.Count != 1 {
+		t.Errorf("Past totals of c/ mismatch: got %+v", got)
+	}
+
+	prefixed, err := treeRepo.GetTree(ctx, "a/", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixed) != 3 {
+		t.Errorf("Expected a/ and its 2 subdirectories, got %+v", prefixed)
+	}
+
+	names := func(changes []*model.SubtreeChange) []string {
+		var names []string
+		for _, c := range changes {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	diff := DiffTrees("/", past, current, 0)
+	if diff.SizeDelta != 8 || diff.CountDelta != 2 {
+		t.Errorf("Total delta mismatch: got %d bytes and %d objects", diff.SizeDelta, diff.CountDelta)
+	}
+	if got := names(diff.Added); len(got) != 2 || got[0] != "a/new/" || got[1] != "d/" {
+		t.Errorf("Added mismatch: got %v", got)
+	}
+	if got := names(diff.Removed); len(got) != 1 || got[0] != "c/" || diff.Removed[0].SizeDelta != -6 {
+		t.Errorf("Removed mismatch: got %v", got)
+	}
+	if got := names(diff.Changed); len(got) != 3 || got[0] != "a/" || got[1] != "/" || got[2] != "a/b/" {
+		t.Errorf("Changed mismatch: got %v", got)
+	}
+
+	if got := names(DiffTrees("/", past, current, 1).Changed); len(got) != 2 {
+		t.Errorf("Expected changes down to depth 1, got %v", got)
+	}
+	if got := names(DiffTrees("a/", past, current, 0).Changed); len(got) != 2 || got[0] != "a/" || got[1] != "a/b/" {
+		t.Errorf("Changed under a/ mismatch: got %v", got)
+	}
+}
@@ -0,0 +1,150 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+const (
+	// UsageRetention is how long daily consumer usage is kept
+	UsageRetention = 90 * 24 * time.Hour
+
+	usageDayLayout = "2006-01-02"
+)
+
+// usageSchema is part of the schema, and added to databases created before usage was accounted
+const usageSchema = `
+	CREATE TABLE consumer_usage (
+		day			TEXT NOT NULL, -- UTC day formatted as YYYY-MM-DD
+		consumer	TEXT NOT NULL,
+		requests	INTEGER DEFAULT 0,
+		bytes		INTEGER DEFAULT 0,
+		latency_us	INTEGER DEFAULT 0, -- total latency of the requests in microseconds
+		PRIMARY KEY (day, consumer)
+	);
+`
+
+type Usage struct {
+	*Database
+}
+
+type UsageRepository interface {
+	GetUsage(ctx context.Context, since time.Time) ([]*model.ConsumerUsage, error)
+}
+
+func NewUsageRepository(db *Database) UsageRepository {
+	return &Usage{db}
+}
+
+// GetUsage returns the daily usage of every consumer since a given day,
+// ordered from the latest day and the busiest consumer
+func (u *Usage) GetUsage(ctx context.Context, since time.Time) ([]*model.ConsumerUsage, error) {
+	query := `
+		SELECT day, consumer, requests, bytes,
+			CASE WHEN requests = 0 THEN 0 ELSE latency_us / 1000.0 / requests END AS avg_latency_ms
+		FROM consumer_usage
+		WHERE day >= $1
+		ORDER BY day DESC, requests DESC, consumer;
+	`
+
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	usage := []*model.ConsumerUsage{}
+	if err := u.reader(ctx).SelectContext(ctx, &usage, query, since.UTC().Format(usageDayLayout)); err != nil {
+		return nil, translateError(err)
+	}
+	return usage, nil
+}
+
+type usageKey struct {
+	day      string
+	consumer string
+}
+
+type usageTotals struct {
+	requests int64
+	bytes    int64
+	latency  time.Duration
+}
+
+// UsageMeter aggregates requests per consumer and day in memory,
+// so serving a request does not wait on a write
+type UsageMeter struct {
+	db *Database
+
+	mu      sync.Mutex
+	pending map[usageKey]*usageTotals
+}
+
+func NewUsageMeter(db *Database) *UsageMeter {
+	return &UsageMeter{db: db, pending: make(map[usageKey]*usageTotals)}
+}
+
+// Record counts a request of consumer in the current day
+func (m *UsageMeter) Record(consumer string, bytes int64, latency time.Duration) {
+	key := usageKey{m.db.clock.Now().UTC().Format(usageDayLayout), consumer}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totals, ok := m.pending[key]
+	if !ok {
+		totals = &usageTotals{}
+		m.pending[key] = totals
+	}
+	totals.requests++
+	totals.bytes += bytes
+	totals.latency += latency
+}
+
+// Flush adds the usage aggregated since the last flush to the daily aggregates, and prunes days past UsageRetention
+// Usage failing to be written is kept for the next flush
+func (m *UsageMeter) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[usageKey]*usageTotals)
+	m.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := m.db.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for key, totals := range pending {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO consumer_usage (day, consumer, requests, bytes, latency_us)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT(day, consumer)
+				DO UPDATE SET requests = requests + $3,
+					bytes = bytes + $4,
+					latency_us = latency_us + $5;
+			`, key.day, key.consumer, totals.requests, totals.bytes, totals.latency.Microseconds()); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM consumer_usage WHERE day < $1;`, m.db.clock.Now().Add(-UsageRetention).UTC().Format(usageDayLayout))
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, totals := range pending {
+		if current, ok := m.pending[key]; ok {
+			current.requests += totals.requests
+			current.bytes += totals.bytes
+			current.latency += totals.latency
+		} else {
+			m.pending[key] = totals
+		}
+	}
+	return err
+}
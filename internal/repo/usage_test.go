@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUsageMeter(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	meter := NewUsageMeter(db)
+	usageRepo := NewUsageRepository(db)
+
+	// Days past the retention are pruned on flush
+	old := time.Now().Add(-UsageRetention - 48*time.Hour).UTC().Format(usageDayLayout)
+	if _, err := db.Exec(`INSERT INTO consumer_usage (day, consumer, requests) VALUES (?, 'old', 1);`, old); err != nil {
+		t.Fatal(err)
+	}
+
+	meter.Record("a", 10, 2*time.Millisecond)
+	meter.Record("b", 5, time.Millisecond)
+	if err := meter.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Later flushes add to the day's aggregates
+	meter.Record("a", 20, 4*time.Millisecond)
+	if err := meter.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := usageRepo.GetUsage(ctx, time.Now().Add(-UsageRetention-72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().UTC().Format(usageDayLayout)
+	want := []struct {
+		consumer     string
+		requests     int64
+		bytes        int64
+		avgLatencyMs float64
+	}{
+		{"a", 2, 30, 3},
+		{"b", 1, 5, 1},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Return count mismatch: got %d, want %d", len(got), len(want))
+	}
+
+	for i, w := range want {
+		if got[i].Day != today || got[i].Consumer != w.consumer || got[i].Requests != w.requests || got[i].Bytes != w.bytes || got[i].AvgLatencyMs != w.avgLatencyMs {
+			t.Errorf("Usage %d mismatch: got %+v, want %+v", i, got[i], w)
+		}
+	}
+
+	// Usage failing to be written is kept for the next flush
+	meter.Record("c", 1, time.Millisecond)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := meter.Flush(cancelled); err == nil {
+		t.Fatal("Expected flush with a cancelled context to fail")
+	}
+	if err := meter.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err = usageRepo.GetUsage(ctx, time.Now()); err != nil || len(got) != 3 {
+		t.Errorf("Expected usage of 3 consumers, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,13 @@
+package repo
+
+import "context"
+
+// Vacuum rebuilds the database file, returning the pages freed by deleted objects and purged buckets to the
+// file system and defragmenting indexes
+// Writes wait for the rebuild, which is not bound by the operation timeout as it takes as long as copying the database
+func (db *Database) Vacuum(ctx context.Context) error {
+	defer db.load.start(db.clock)()
+
+	_, err := db.DB.ExecContext(ctx, "VACUUM;")
+	return translateError(err)
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestVacuum(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDatabase(InMemoryURL(t.Name()), 1)
+	if err := db.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataRepo := NewMetadataRepository(db)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		obj := &model.Metadata{Bucket: "mock", Name: fmt.Sprintf("a/file%d", i), Size: 1, StorageClass: "STANDARD", Created: now, Updated: now}
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM metadata WHERE name LIKE 'a/file%'"); err != nil {
+		t.Fatal(err)
+	}
+
+	var freePages int
+	if err := db.GetContext(ctx, &freePages, "PRAGMA freelist_count"); err != nil {
+		t.Fatal(err)
+	}
+	if freePages == 0 {
+		t.Fatal("Expected deleted objects to free pages")
+	}
+
+	if err := db.Vacuum(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.GetContext(ctx, &freePages, "PRAGMA freelist_count"); err != nil {
+		t.Fatal(err)
+	}
+	if freePages != 0 {
+		t.Errorf("Free pages mismatch: got %d, want 0", freePages)
+	}
+}
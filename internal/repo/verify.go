@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// maxIntegrityProblems bounds the problems integrity_check reports before stopping
+const maxIntegrityProblems = 100
+
+// IntegrityCheck runs SQLite's integrity check over the whole database, returning the problems it found
+func (db *Database) IntegrityCheck(ctx context.Context) ([]string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var results []string
+	if err := db.SelectContext(ctx, &results, fmt.Sprintf("PRAGMA integrity_check(%d);", maxIntegrityProblems)); err != nil {
+		return nil, translateError(err)
+	}
+	if len(results) == 1 && results[0] == "ok" {
+		return nil, nil
+	}
+	return results, nil
+}
+
+// CountRows counts the rows of table, or returns ErrNotFound if the database has no such table
+func (db *Database) CountRows(ctx context.Context, table string) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	if err := db.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?);`, table); err != nil {
+		return 0, translateError(err)
+	}
+	if !exists {
+		return 0, ErrNotFound
+	}
+
+	var count int64
+	if err := db.GetContext(ctx, &count, fmt.Sprintf(`SELECT COUNT(*) FROM "%s";`, strings.ReplaceAll(table, `"`, `""`))); err != nil {
+		return 0, translateError(err)
+	}
+	return count, nil
+}
+
+// Verify runs the integrity check and counts the rows of every table in expected, adding a problem to check
+// for every corruption found and every count out of its range
+func (db *Database) Verify(ctx context.Context, check *model.BackupCheck, expected map[string]model.RowRange) error {
+	problems, err := db.IntegrityCheck(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking integrity: %w", err)
+	}
+	check.Problems = append(check.Problems, problems...)
+
+	// Sorted so problems are reported in a stable order
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+
+	if check.Rows == nil {
+		check.Rows = make(map[string]int64, len(tables))
+	}
+	for _, table := range tables {
+		count, err := db.CountRows(ctx, table)
+		if errors.Is(err, ErrNotFound) {
+			check.Problems = append(check.Problems, fmt.Sprintf("table %s is missing", table))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error counting rows of %s: %w", table, err)
+		}
+
+		check.Rows[table] = count
+		if r := expected[table]; !r.Contains(count) {
+			check.Problems = append(check.Problems, fmt.Sprintf("table %s has %d rows, expected %s", table, count, formatRowRange(r)))
+		}
+	}
+	return nil
+}
+
+func formatRowRange(r model.RowRange) string {
+	if r.Max == 0 {
+		return fmt.Sprintf("at least %d", r.Min)
+	}
+	return fmt.Sprintf("%d to %d", r.Min, r.Max)
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestVerify(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := NewBucketRepository(db).Upsert(ctx, model.Bucket{Name: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDirectoryRepository(db).UpsertParentDirs(ctx, StorageStandard, "mock", "a/b/file", 4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CountRows(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound counting a missing table, got %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		expected     map[string]model.RowRange
+		wantProblems int
+	}{
+		{"No expectations", nil, 0},
+		{"Within ranges", map[string]model.RowRange{"bucket": {Min: 1, Max: 1}, "directory": {Min: 1}}, 0},
+		{"Too few rows", map[string]model.RowRange{"bucket": {Min: 2}}, 1},
+		{"Too many rows", map[string]model.RowRange{"bucket": {Max: 0}, "directory": {Min: 1, Max: 2}}, 1},
+		{"Missing table", map[string]model.RowRange{"missing": {}}, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			check := &model.BackupCheck{}
+			if err := db.Verify(ctx, check, tc.expected); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(check.Problems) != tc.wantProblems {
+				t.Errorf("Problems mismatch: got %q, want %d", check.Problems, tc.wantProblems)
+			}
+			if check.Valid() != (tc.wantProblems == 0) {
+				t.Errorf("Valid mismatch: got %v with problems %q", check.Valid(), check.Problems)
+			}
+			if count, ok := check.Rows["bucket"]; ok && count != 1 {
+				t.Errorf("Row count of bucket mismatch: got %d want 1", count)
+			}
+		})
+	}
+}
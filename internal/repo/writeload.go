@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// writeLoad tracks the writes in progress on a database
+type writeLoad struct {
+	mu      sync.Mutex
+	pending map[uint64]time.Time
+	nextID  uint64
+	// busy is the time writes were in progress until busySince, zero while none is
+	busy      time.Duration
+	busySince time.Time
+}
+
+// start registers a write starting now, returning the function to call once it completes
+func (l *writeLoad) start(c clock.Clock) func() {
+	now := c.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending == nil {
+		l.pending = make(map[uint64]time.Time)
+	}
+	if len(l.pending) == 0 {
+		l.busySince = now
+	}
+	id := l.nextID
+	l.nextID++
+	l.pending[id] = now
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		delete(l.pending, id)
+		if len(l.pending) == 0 {
+			l.busy += c.Now().Sub(l.busySince)
+			l.busySince = time.Time{}
+		}
+	}
+}
+
+func (l *writeLoad) at(now time.Time) model.WriteLoad {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	load := model.WriteLoad{Pending: len(l.pending), Busy: model.Duration(l.busy)}
+	if !l.busySince.IsZero() {
+		load.Busy += model.Duration(now.Sub(l.busySince))
+	}
+	for _, started := range l.pending {
+		load.OldestPending = max(load.OldestPending, model.Duration(now.Sub(started)))
+	}
+	return load
+}
+
+// WriteLoad returns the writes pending on the database and the total time writes were in progress
+func (db *Database) WriteLoad() model.WriteLoad {
+	return db.load.at(db.clock.Now())
+}
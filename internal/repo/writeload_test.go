@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestWriteLoad(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	clk := clock.NewFake(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	db.SetClock(clk)
+
+	var during model.WriteLoad
+	err := db.write(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+		clk.Advance(2 * time.Second)
+		during = db.WriteLoad()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if during.Pending != 1 || during.OldestPending != model.Duration(2*time.Second) || during.Busy != model.Duration(2*time.Second) {
+		t.Errorf("Load during a write mismatch: got %+v", during)
+	}
+
+	clk.Advance(time.Minute)
+	if after := db.WriteLoad(); after.Pending != 0 || after.OldestPending != 0 || after.Busy != model.Duration(2*time.Second) {
+		t.Errorf("Load after a write mismatch: got %+v", after)
+	}
+}
@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	// writeQueueRetries bounds the retries of a batch that found the database locked
+	writeQueueRetries = 5
+	writeQueueBackoff = 50 * time.Millisecond
+)
+
+// ErrQueueClosed is returned when submitting to a write queue that stopped running
+var ErrQueueClosed = errors.New("write queue closed")
+
+// WriteOp is a mutation run within a transaction
+// Ops run again when their transaction is retried, so they reset any state they collect each time they run
+type WriteOp func(ctx context.Context, tx *sql.Tx) error
+
+type writeRequest struct {
+	ctx  context.Context
+	op   WriteOp
+	done chan error
+}
+
+// WriteQueue serializes database writes through a single goroutine,
+// committing the operations submitted concurrently in one transaction
+// Batches that find the database locked are retried here, so submitters never see ErrBusy
+// unless the database stays locked through every retry
+type WriteQueue struct {
+	db       *Database
+	maxBatch int
+	requests chan *writeRequest
+	closed   chan struct{}
+}
+
+// NewWriteQueue commits up to maxBatch operations per transaction
+// Writes of repositories on db go through the queue once set with Database.SetWriteQueue
+func NewWriteQueue(db *Database, maxBatch int) *WriteQueue {
+	return &WriteQueue{
+		db:       db,
+		maxBatch: max(maxBatch, 1),
+		requests: make(chan *writeRequest),
+		closed:   make(chan struct{}),
+	}
+}
+
+// SetWriteQueue makes repositories on db submit their writes to q instead of committing them on their own
+func (db *Database) SetWriteQueue(q *WriteQueue) {
+	db.writeQueue = q
+}
+
+// write runs op in a transaction of its own, or in a batch of the write queue if one is set, or in the
+// transaction of ctx if it runs Atomically
+func (db *Database) write(ctx context.Context, op WriteOp) error {
+	if tx := db.writeTxOf(ctx); tx != nil {
+		return writeWithin(ctx, tx, op)
+	}
+	defer db.load.start(db.clock)()
+
+	if db.faults != nil {
+		return db.faults.write(ctx, op, db.commit)
+	}
+	return db.commit(ctx, op)
+}
+
+func (db *Database) commit(ctx context.Context, op WriteOp) error {
+	if db.writeQueue != nil {
+		return db.writeQueue.Submit(ctx, op)
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback() // no-op if commit succeeds
+
+	if err := op(ctx, tx); err != nil {
+		return translateError(err)
+	}
+	return translateError(tx.Commit())
+}
+
+// Submit applies op in the next batch and returns its error once the batch committed
+// op runs with the values of ctx, such as its query log
+// An operation whose ctx is done before its batch commits is skipped or rolled back, but one cancelled
+// while its batch commits may be applied even though Submit returned the context error
+func (q *WriteQueue) Submit(ctx context.Context, op WriteOp) error {
+	req := &writeRequest{ctx: ctx, op: op, done: make(chan error, 1)}
+
+	select {
+	case q.requests <- req:
+	case <-q.closed:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run commits submitted operations until ctx is cancelled
+// Each batch holds the operations already waiting when the previous one committed
+func (q *WriteQueue) Run(ctx context.Context) {
+	defer close(q.closed)
+
+	for {
+		var batch []*writeRequest
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-q.requests:
+			batch = append(batch, req)
+		}
+
+	collect:
+		for len(batch) < q.maxBatch {
+			select {
+			case req := <-q.requests:
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+
+		q.commit(ctx, batch)
+	}
+}
+
+// commit applies a batch, retrying it while the database is locked, and reports every operation's error
+func (q *WriteQueue) commit(ctx context.Context, batch []*writeRequest) {
+	var errs []error
+	var err error
+	for attempt := 0; ; attempt++ {
+		errs, err = q.apply(ctx, batch)
+		if err == nil || !Retryable(err) || attempt == writeQueueRetries {
+			break
+		}
+		time.Sleep(writeQueueBackoff << attempt)
+	}
+
+	for i, req := range batch {
+		if err != nil {
+			req.done <- err
+		} else {
+			req.done <- errs[i]
+		}
+	}
+}
+
+// apply runs every operation of a batch in one transaction
+// An operation failing rolls back its own changes only, unless the database is locked,
+// in which case the whole batch is rolled back to be retried
+func (q *WriteQueue) apply(ctx context.Context, batch []*writeRequest) ([]error, error) {
+	ctx, cancel := q.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := q.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback() // no-op if commit succeeds
+
+	errs := make([]error, len(batch))
+	for i, req := range batch {
+		if err := req.ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT write_op;`); err != nil {
+			return nil, translateError(err)
+		}
+
+		if err := runOp(ctx, tx, req); err != nil {
+			if Retryable(err) {
+				return nil, err
+			}
+			errs[i] = err
+
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO write_op;`); err != nil {
+				return nil, translateError(err)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE write_op;`); err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, translateError(err)
+	}
+	return errs, nil
+}
+
+// runOp runs the operation of req with the values of its context, failing it if its context is done by the time
+// it completed
+// Cancelling a statement aborts the whole transaction, so the operation is only interrupted once ctx, the
+// context of its batch, is done
+func runOp(ctx context.Context, tx *sql.Tx, req *writeRequest) error {
+	opCtx, cancel := context.WithCancel(context.WithoutCancel(req.ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	if err := translateError(req.op(opCtx, tx)); err != nil {
+		return err
+	}
+	return req.ctx.Err()
+}
@@ -0,0 +1,256 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestWriteQueue(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := NewWriteQueue(db, 10)
+	db.SetWriteQueue(queue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		queue.Run(ctx)
+	}()
+
+	metadataRepo := NewMetadataRepository(db)
+	dirRepo := NewDirectoryRepository(db)
+
+	// Concurrent writes are committed together, a failing write only rolls back its own changes
+	names := []string{"a/file1", "a/file2", "b/file3", "a/file1"}
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obj := &model.Metadata{Bucket: "mock", Name: name, Size: 1, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+			if errs[i] = metadataRepo.Insert(context.Background(), obj); errs[i] != nil {
+				return
+			}
+			errs[i] = dirRepo.UpsertParentDirs(context.Background(), StorageStandard, obj.Bucket, obj.Name, obj.Size, 1)
+		}()
+	}
+	wg.Wait()
+
+	var conflicts int
+	for _, err := range errs {
+		if errors.Is(err, ErrConflict) {
+			conflicts++
+		} else if err != nil {
+			t.Errorf("Unexpected write error: %v", err)
+		}
+	}
+
+	if conflicts != 1 {
+		t.Errorf("Conflict count mismatch: got %d, want %d", conflicts, 1)
+	}
+
+	var count int64
+	if err := db.Get(&count, `SELECT count FROM directory WHERE name = '/';`); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3 {
+		t.Errorf("Root count mismatch: got %d, want %d", count, 3)
+	}
+
+	// Operations report their own errors
+	wantErr := fmt.Errorf("mock error")
+	err := queue.Submit(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM metadata;`); err != nil {
+			return err
+		}
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Error mismatch: got %v, want %v", err, wantErr)
+	}
+
+	var objects int
+	if err := db.Get(&objects, `SELECT COUNT(*) FROM metadata;`); err != nil {
+		t.Fatal(err)
+	}
+
+	if objects != 3 {
+		t.Errorf("Expected failed operation to be rolled back, got %d objects", objects)
+	}
+
+	// Submitting to a stopped queue fails instead of blocking
+	cancel()
+	<-running
+
+	if err := metadataRepo.Delete(context.Background(), "mock", "a/file1"); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Error mismatch: got %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestWriteQueueRetriesBusy(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	queue := NewWriteQueue(db, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	testCases := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"Succeeds without retries", []error{nil}, 1, nil},
+		{"Retries busy database", []error{ErrBusy, ErrBusy, nil}, 3, nil},
+		{"Does not retry conflicts", []error{ErrConflict}, 1, ErrConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			err := queue.Submit(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Error mismatch: got %v, want %v", err, tc.wantErr)
+			}
+
+			if calls != tc.wantCalls {
+				t.Errorf("Calls mismatch: got %d, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWriteQueueRetriedDryRun(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := NewWriteQueue(db, 2)
+	db.SetWriteQueue(queue)
+
+	// A dry run and an operation finding the database locked once are retried in the same batch
+	type result struct {
+		writes []LoggedQuery
+		err    error
+	}
+	dryRun := make(chan result, 1)
+	go func() {
+		writes, err := db.DryRun(context.Background(), func(ctx context.Context) error {
+			return db.write(ctx, func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM metadata;`)
+				return err
+			})
+		})
+		dryRun <- result{writes, err}
+	}()
+	batch := []*writeRequest{<-queue.requests}
+
+	calls := 0
+	busy := make(chan error, 1)
+	go func() {
+		busy <- queue.Submit(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+			if calls++; calls == 1 {
+				return ErrBusy
+			}
+			return nil
+		})
+	}()
+	batch = append(batch, <-queue.requests)
+	queue.commit(context.Background(), batch)
+
+	if err := <-busy; err != nil || calls != 2 {
+		t.Errorf("Retried operation mismatch: got %d calls, error %v", calls, err)
+	}
+	res := <-dryRun
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.writes) != 1 {
+		t.Errorf("Dry run writes mismatch: got %v, want the statement of the last attempt", res.writes)
+	}
+}
+
+type writeQueueKey struct{}
+
+func TestWriteQueueCallerContext(t *testing.T) {
+	db := NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := NewWriteQueue(db, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	// Operations see the values of the context they were submitted with
+	callerCtx := context.WithValue(context.Background(), writeQueueKey{}, "caller")
+	err := queue.Submit(callerCtx, func(ctx context.Context, tx *sql.Tx) error {
+		if got := ctx.Value(writeQueueKey{}); got != "caller" {
+			t.Errorf("Context value mismatch: got %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Operations whose caller is cancelled while they run are rolled back
+	callerCtx, cancelCaller := context.WithCancel(context.Background())
+	err = queue.Submit(callerCtx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO bucket (name, location, location_type) VALUES ('mock', 'US', 'multi-region');`)
+		cancelCaller()
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Error mismatch: got %v, want %v", err, context.Canceled)
+	}
+
+	// The cancelled operation may return before its batch committed
+	if err := queue.Submit(context.Background(), func(ctx context.Context, tx *sql.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	var buckets int
+	if err := db.Get(&buckets, `SELECT COUNT(*) FROM bucket;`); err != nil {
+		t.Fatal(err)
+	}
+	if buckets != 0 {
+		t.Errorf("Expected the operation of the cancelled caller to be rolled back, got %d buckets", buckets)
+	}
+}
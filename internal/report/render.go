@@ -0,0 +1,110 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	ContentTypeHTML = "text/html; charset=utf-8"
+	ContentTypeCSV  = "text/csv"
+)
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"date":  func(t time.Time) string { return t.UTC().Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Storage report for {{.Prefix}}</title></head>
+<body>
+<h1>Storage report for {{.Prefix}}</h1>
+<p>Generated {{date .Generated}} UTC, growth since {{date .From}} UTC</p>
+
+<h2>Size and estimated monthly cost</h2>
+<table>
+<tr><th>Storage class</th><th>Size</th><th>Cost (USD)</th></tr>
+<tr><td>Standard</td><td>{{bytes .Summary.Size.Standard}}</td><td>{{printf "%.2f" .Summary.Cost.Standard}}</td></tr>
+<tr><td>Nearline</td><td>{{bytes .Summary.Size.Nearline}}</td><td>{{printf "%.2f" .Summary.Cost.Nearline}}</td></tr>
+<tr><td>Coldline</td><td>{{bytes .Summary.Size.Coldline}}</td><td>{{printf "%.2f" .Summary.Cost.Coldline}}</td></tr>
+<tr><td>Archive</td><td>{{bytes .Summary.Size.Archive}}</td><td>{{printf "%.2f" .Summary.Cost.Archive}}</td></tr>
+<tr><th>Total</th><th>{{bytes .TotalSize}}</th><th>{{printf "%.2f" .TotalCost}}</th></tr>
+</table>
+
+<h2>Top growers</h2>
+{{if .TopGrowers}}<table>
+<tr><th>Directory</th><th>Growth</th><th>Objects</th></tr>
+{{range .TopGrowers}}<tr><td>{{.Name}}</td><td>{{bytes .SizeDelta}}</td><td>{{.CountDelta}}</td></tr>
+{{end}}</table>{{else}}<p>No directory grew over the period.</p>{{end}}
+
+<h2>Stale directories</h2>
+{{if .Stale}}<table>
+<tr><th>Directory</th><th>Size</th><th>Objects</th><th>Last change</th></tr>
+{{range .Stale}}<tr><td>{{.Name}}</td><td>{{bytes .Size}}</td><td>{{.Count}}</td><td>{{if .LastChange.IsZero}}unknown{{else}}{{date .LastChange}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>No stale directories.</p>{{end}}
+</body>
+</html>
+`))
+
+// RenderHTML writes the report as an HTML document
+func RenderHTML(w io.Writer, r *Report) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+// RenderCSV writes the report as a single CSV table, with a section column telling its parts apart
+// Sizes are in bytes and costs in USD per month
+func RenderCSV(w io.Writer, r *Report) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"section", "name", "size", "count", "cost", "last_change"})
+
+	classes := []struct {
+		name string
+		size int64
+		cost float64
+	}{
+		{"STANDARD", r.Summary.Size.Standard, r.Summary.Cost.Standard},
+		{"NEARLINE", r.Summary.Size.Nearline, r.Summary.Cost.Nearline},
+		{"COLDLINE", r.Summary.Size.Coldline, r.Summary.Cost.Coldline},
+		{"ARCHIVE", r.Summary.Size.Archive, r.Summary.Cost.Archive},
+	}
+	for _, c := range classes {
+		writer.Write([]string{"summary", c.name, strconv.FormatInt(c.size, 10), "", strconv.FormatFloat(c.cost, 'f', 2, 64), ""})
+	}
+
+	for _, d := range r.TopGrowers {
+		writer.Write([]string{"top_growers", d.Name, strconv.FormatInt(d.SizeDelta, 10), strconv.FormatInt(d.CountDelta, 10), "", ""})
+	}
+
+	for _, d := range r.Stale {
+		var lastChange string
+		if !d.LastChange.IsZero() {
+			lastChange = d.LastChange.UTC().Format(time.RFC3339)
+		}
+		writer.Write([]string{"stale", d.Name, strconv.FormatInt(d.Size, 10), strconv.FormatInt(d.Count, 10), "", lastChange})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatBytes formats a size in binary units, e.g. 2.0 TiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := abs / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+var mockReport = &Report{
+	Prefix:    "/",
+	Generated: time.Date(2024, 10, 8, 0, 0, 0, 0, time.UTC),
+	From:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+	Summary: &model.Summary{
+		Size: model.Size{Standard: 2 << 40, Archive: 1024},
+		Cost: model.Cost{Standard: 47.1},
+	},
+	TopGrowers: []*model.DirectoryDelta{{Name: "logs/", SizeDelta: 2 << 40, CountDelta: 10}},
+	Stale:      []*model.StaleDirectory{{Name: "<old>/", Size: 1024, Count: 1}},
+}
+
+func TestRenderCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderCSV(&buf, mockReport); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "section,name,size,count,cost,last_change\n" +
+		"summary,STANDARD,2199023255552,,47.10,\n" +
+		"summary,NEARLINE,0,,0.00,\n" +
+		"summary,COLDLINE,0,,0.00,\n" +
+		"summary,ARCHIVE,1024,,0.00,\n" +
+		"top_growers,logs/,2199023255552,10,,\n" +
+		"stale,<old>/,1024,1,,\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("CSV mismatch:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderHTML(&buf, mockReport); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"<td>logs/</td><td>2.0 TiB</td>", "&lt;old&gt;/", "<th>47.10</th>", "unknown"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("HTML does not contain %q", want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{2 << 40, "2.0 TiB"},
+		{-3 << 30, "-3.0 GiB"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatBytes(tc.in); got != tc.want {
+			t.Errorf("formatBytes(%d) mismatch: got %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
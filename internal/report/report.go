@@ -0,0 +1,114 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Config selects what a report covers
+type Config struct {
+	// Prefix is the directory the report is about, root if empty
+	Prefix string
+	// Period is the time span growth is measured over, ending when the report is built
+	Period time.Duration
+	// Top is the maximum number of growing and stale directories listed
+	Top int
+	// StaleAfter is how long a directory must go unchanged to be reported as stale
+	StaleAfter time.Duration
+}
+
+// Validate checks the configuration describes a report that can be built
+func (c Config) Validate() error {
+	switch {
+	case c.Period <= 0:
+		return errors.New("period must be positive")
+	case c.Top < 1:
+		return errors.New("top must be at least 1")
+	case c.StaleAfter <= 0:
+		return errors.New("stale after must be positive")
+	}
+	return nil
+}
+
+// Report is a periodic summary of a directory
+type Report struct {
+	Prefix    string
+	Generated time.Time
+	From      time.Time
+	Summary   *model.Summary
+	// TopGrowers are the child directories which grew the most over the period
+	TopGrowers []*model.DirectoryDelta
+	// Stale are the largest child directories which have not changed for StaleAfter
+	Stale []*model.StaleDirectory
+}
+
+// TotalCost returns the estimated monthly storage cost of the directory
+func (r *Report) TotalCost() float64 {
+	c := r.Summary.Cost
+	return c.Standard + c.Nearline + c.Coldline + c.Archive
+}
+
+// TotalSize returns the size of the directory across storage classes
+func (r *Report) TotalSize() int64 {
+	s := r.Summary.Size
+	return s.Standard + s.Nearline + s.Coldline + s.Archive
+}
+
+type Builder struct {
+	cfg         Config
+	exploreRepo repo.ExploreRepository
+	historyRepo repo.HistoryRepository
+}
+
+func NewBuilder(cfg Config, exploreRepo repo.ExploreRepository, historyRepo repo.HistoryRepository) *Builder {
+	if len(cfg.Prefix) == 0 {
+		cfg.Prefix = "/"
+	}
+	return &Builder{cfg, exploreRepo, historyRepo}
+}
+
+// Build reads a report of the period ending at now from the repositories
+func (b *Builder) Build(ctx context.Context, now time.Time) (*Report, error) {
+	from := now.Add(-b.cfg.Period)
+
+	summary, err := b.exploreRepo.GetPathSummary(ctx, b.cfg.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving summary: %w", err)
+	}
+
+	deltas, err := b.historyRepo.GetDiff(ctx, b.cfg.Prefix, from, now)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving directory diff: %w", err)
+	}
+
+	// Deltas are ordered from the largest growth, keep the directories which grew
+	growers := make([]*model.DirectoryDelta, 0, b.cfg.Top)
+	for _, d := range deltas {
+		if len(growers) == b.cfg.Top || d.SizeDelta <= 0 {
+			break
+		}
+		growers = append(growers, d)
+	}
+
+	stale, err := b.historyRepo.GetStale(ctx, b.cfg.Prefix, now.Add(-b.cfg.StaleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving stale directories: %w", err)
+	}
+	if len(stale) > b.cfg.Top {
+		stale = stale[:b.cfg.Top]
+	}
+
+	return &Report{
+		Prefix:     b.cfg.Prefix,
+		Generated:  now,
+		From:       from,
+		Summary:    summary,
+		TopGrowers: growers,
+		Stale:      stale,
+	}, nil
+}
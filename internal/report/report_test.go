@@ -0,0 +1,120 @@
+package report
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func newTestBuilder(t *testing.T, cfg Config) *Builder {
+	t.Helper()
+
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	dirRepo := repo.NewDirectoryRepository(db)
+	objects := []struct {
+		name string
+		size int64
+	}{
+		{"logs/a", 300},
+		{"logs/b", 200},
+		{"data/c", 100},
+		{"file", 50},
+	}
+	for _, o := range objects {
+		if err := dirRepo.UpsertParentDirs(context.Background(), repo.StorageStandard, "mock", o.name, o.size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewBuilder(cfg, repo.NewExploreRepository(db), repo.NewHistoryRepository(db))
+}
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	testCases := []struct {
+		name        string
+		cfg         Config
+		now         time.Time
+		wantGrowers []string
+		wantStale   []string
+	}{
+		{"Lists growers", Config{Period: time.Hour, Top: 10, StaleAfter: time.Hour}, now, []string{"logs/", "data/"}, nil},
+		{"Limits growers", Config{Period: time.Hour, Top: 1, StaleAfter: time.Hour}, now, []string{"logs/"}, nil},
+		{"Lists stale directories", Config{Period: time.Hour, Top: 10, StaleAfter: time.Hour}, now.Add(48 * time.Hour), nil, []string{"logs/", "data/"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newTestBuilder(t, tc.cfg).Build(ctx, tc.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.TotalSize() != 650 {
+				t.Errorf("Size mismatch: got %d, want %d", r.TotalSize(), 650)
+			}
+
+			if len(r.TopGrowers) != len(tc.wantGrowers) {
+				t.Fatalf("Grower count mismatch: got %d, want %d", len(r.TopGrowers), len(tc.wantGrowers))
+			}
+			for i, name := range tc.wantGrowers {
+				if r.TopGrowers[i].Name != name {
+					t.Errorf("Grower %d mismatch: got %s, want %s", i, r.TopGrowers[i].Name, name)
+				}
+			}
+
+			if len(r.Stale) != len(tc.wantStale) {
+				t.Fatalf("Stale count mismatch: got %d, want %d", len(r.Stale), len(tc.wantStale))
+			}
+			for i, name := range tc.wantStale {
+				if r.Stale[i].Name != name {
+					t.Errorf("Stale %d mismatch: got %s, want %s", i, r.Stale[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+type recordingSink struct {
+	files []File
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, r *Report, files []File) error {
+	s.files = files
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	b := newTestBuilder(t, Config{Period: time.Hour, Top: 10, StaleAfter: time.Hour})
+	sink := &recordingSink{}
+
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	if err := Generate(context.Background(), b, []Sink{sink}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"report-20241001T120000Z.html", "report-20241001T120000Z.csv"}
+	if len(sink.files) != len(want) {
+		t.Fatalf("File count mismatch: got %d, want %d", len(sink.files), len(want))
+	}
+	for i, name := range want {
+		if sink.files[i].Name != name || len(sink.files[i].Body) == 0 {
+			t.Errorf("File %d mismatch: got %s with %d bytes, want %s", i, sink.files[i].Name, len(sink.files[i].Body), name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Generate builds a report of the period ending at now, renders it as HTML and CSV and delivers it to every sink
+// Every sink is attempted even if another failed
+func Generate(ctx context.Context, b *Builder, sinks []Sink, now time.Time) error {
+	r, err := b.Build(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var html, csv bytes.Buffer
+	if err := RenderHTML(&html, r); err != nil {
+		return fmt.Errorf("error rendering HTML: %w", err)
+	}
+	if err := RenderCSV(&csv, r); err != nil {
+		return fmt.Errorf("error rendering CSV: %w", err)
+	}
+
+	name := "report-" + now.UTC().Format("20060102T150405Z")
+	files := []File{
+		{Name: name + ".html", ContentType: ContentTypeHTML, Body: html.Bytes()},
+		{Name: name + ".csv", ContentType: ContentTypeCSV, Body: csv.Bytes()},
+	}
+
+	var errs []error
+	for _, sink := range sinks {
+		errs = append(errs, sink.Deliver(ctx, r, files))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// File is a rendered report
+type File struct {
+	Name        string
+	ContentType string
+	Body        []byte
+}
+
+// Sink delivers the rendered files of a report
+type Sink interface {
+	Deliver(ctx context.Context, r *Report, files []File) error
+}
+
+type gcsSink struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// NewGCSSink returns a sink writing every file as an object named prefix followed by the file name
+func NewGCSSink(bucket *storage.BucketHandle, prefix string) Sink {
+	return &gcsSink{bucket, prefix}
+}
+
+func (g *gcsSink) Deliver(ctx context.Context, r *Report, files []File) error {
+	for _, f := range files {
+		w := g.bucket.Object(g.prefix + f.Name).NewWriter(ctx)
+		w.ContentType = f.ContentType
+
+		if _, err := w.Write(f.Body); err != nil {
+			w.Close()
+			return fmt.Errorf("error writing %s: %w", f.Name, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// SMTPConfig addresses a mail server, e.g. smtp.sendgrid.net:587 with the username apikey for SendGrid
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+type smtpSink struct {
+	cfg  SMTPConfig
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPSink returns a sink mailing the HTML file as message body and every other file as attachment
+func NewSMTPSink(cfg SMTPConfig) Sink {
+	return &smtpSink{cfg, smtp.SendMail}
+}
+
+func (s *smtpSink) Deliver(ctx context.Context, r *Report, files []File) error {
+	msg, err := s.message(r, files)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(s.cfg.Username) > 0 {
+		host, _, _ := strings.Cut(s.cfg.Addr, ":")
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	if err := s.send(s.cfg.Addr, auth, s.cfg.From, s.cfg.To, msg); err != nil {
+		return fmt.Errorf("error sending mail: %w", err)
+	}
+	return nil
+}
+
+// message builds a multipart MIME message of the report files
+func (s *smtpSink) message(r *Report, files []File) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	subject := fmt.Sprintf("Storage report for %s, %s", r.Prefix, r.Generated.UTC().Format(time.DateOnly))
+	fmt.Fprintf(&buf, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	for _, f := range files {
+		header := textproto.MIMEHeader{"Content-Type": {f.ContentType}}
+		if !strings.HasPrefix(f.ContentType, "text/html") {
+			header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+		}
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(f.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,50 @@
+package report
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestSMTPSink(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg []byte
+
+	sink := &smtpSink{
+		cfg: SMTPConfig{Addr: "smtp.example.com:587", Username: "apikey", Password: "mock", From: "reports@example.com", To: []string{"a@example.com", "b@example.com"}},
+		send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, msg
+			return nil
+		},
+	}
+
+	files := []File{
+		{Name: "report.html", ContentType: ContentTypeHTML, Body: []byte("<h1>mock</h1>")},
+		{Name: "report.csv", ContentType: ContentTypeCSV, Body: []byte("section,name\n")},
+	}
+	if err := sink.Deliver(context.Background(), mockReport, files); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotAddr != "smtp.example.com:587" || gotFrom != "reports@example.com" || len(gotTo) != 2 {
+		t.Errorf("Envelope mismatch: got %s %s %v", gotAddr, gotFrom, gotTo)
+	}
+
+	msg := string(gotMsg)
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: Storage report for /, 2024-10-08\r\n",
+		"<h1>mock</h1>",
+		`Content-Disposition: attachment; filename=report.csv`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Message does not contain %q", want)
+		}
+	}
+
+	if strings.Contains(msg, `filename=report.html`) {
+		t.Error("HTML report should be the message body")
+	}
+}
@@ -0,0 +1,203 @@
+// Package resources lists the service account and IAM bindings the servers need given their configuration,
+// rendered as JSON or Terraform so they can be provisioned declaratively
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Kinds of resources roles are granted on
+const (
+	KindProject   = "project"
+	KindBucket    = "bucket"
+	KindCryptoKey = "crypto_key"
+)
+
+// Config mirrors the flags of the servers deciding which permissions they need
+type Config struct {
+	// Project owns the service account
+	Project string
+	// ServiceAccountID is the account ID of the service account, its email being derived from it
+	ServiceAccountID string
+	// Buckets are indexed by the seeder, or by the API with --backfill, --gcs-fallback or --lazy-indexing
+	Buckets []string
+	// BillingProject is billed for requests to requester pays buckets
+	BillingProject string
+	// LeaseObject is the writer lease of the seeder, as bucket/object
+	LeaseObject string
+	// ReportDestination is where the reporter writes reports, as bucket/prefix
+	ReportDestination string
+	// SnapshotDestination is where the snapshot job of the API writes snapshots, as bucket/prefix
+	SnapshotDestination string
+	// SnapshotLocation is where verify-backup reads snapshots from, as bucket/prefix
+	SnapshotLocation string
+	// AccessLogLocation is where ingest-access-logs reads usage or audit logs from, as bucket/prefix
+	AccessLogLocation string
+	// KMSKey wraps the data keys of snapshots
+	KMSKey string
+	// MonitoringProject receives custom metrics
+	MonitoringProject string
+}
+
+// ServiceAccount is the identity the servers run as
+type ServiceAccount struct {
+	Project     string `json:"project"`
+	AccountID   string `json:"account_id"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+}
+
+// Binding grants a role on a resource to the service account
+type Binding struct {
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	Role     string `json:"role"`
+	Member   string `json:"member"`
+	Reason   string `json:"reason"`
+}
+
+// Resources are the service account and the bindings it needs
+type Resources struct {
+	ServiceAccount ServiceAccount `json:"service_account"`
+	Bindings       []Binding      `json:"bindings"`
+}
+
+// accountIDPattern matches the account IDs GCP accepts
+var accountIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// Build lists the resources cfg needs, every binding granted once per resource and role
+func Build(cfg Config) (*Resources, error) {
+	if len(cfg.Project) == 0 {
+		return nil, errors.New("project is required")
+	}
+	if !accountIDPattern.MatchString(cfg.ServiceAccountID) {
+		return nil, fmt.Errorf("invalid service account ID %q", cfg.ServiceAccountID)
+	}
+
+	sa := ServiceAccount{
+		Project:     cfg.Project,
+		AccountID:   cfg.ServiceAccountID,
+		DisplayName: "GCS Metadata Server",
+		Email:       fmt.Sprintf("%s@%s.iam.gserviceaccount.com", cfg.ServiceAccountID, cfg.Project),
+	}
+	r := &Resources{ServiceAccount: sa, Bindings: []Binding{}}
+	member := "serviceAccount:" + sa.Email
+
+	seen := make(map[Binding]bool)
+	grant := func(kind, resource, role, reason string) {
+		b := Binding{Kind: kind, Resource: resource, Role: role, Member: member}
+		if seen[b] {
+			return
+		}
+		seen[b] = true
+		b.Reason = reason
+		r.Bindings = append(r.Bindings, b)
+	}
+
+	for _, bucket := range cfg.Buckets {
+		grant(KindBucket, bucket, "roles/storage.legacyBucketReader", "Read bucket attributes such as its location")
+		grant(KindBucket, bucket, "roles/storage.objectViewer", "List and read the objects indexed")
+	}
+	if len(cfg.BillingProject) > 0 {
+		grant(KindProject, cfg.BillingProject, "roles/serviceusage.serviceUsageConsumer", "Bill requests to requester pays buckets")
+	}
+	if len(cfg.LeaseObject) > 0 {
+		bucket, object, _ := strings.Cut(cfg.LeaseObject, "/")
+		if len(object) == 0 {
+			return nil, fmt.Errorf("invalid lease object %q, expected bucket/object", cfg.LeaseObject)
+		}
+		grant(KindBucket, bucket, "roles/storage.objectUser", "Create, renew and release the writer lease")
+	}
+	if len(cfg.ReportDestination) > 0 {
+		bucket, _, _ := strings.Cut(cfg.ReportDestination, "/")
+		grant(KindBucket, bucket, "roles/storage.objectCreator", "Write reports")
+	}
+	if len(cfg.SnapshotDestination) > 0 {
+		bucket, _, _ := strings.Cut(cfg.SnapshotDestination, "/")
+		grant(KindBucket, bucket, "roles/storage.objectCreator", "Write scheduled snapshots")
+	}
+	if len(cfg.SnapshotLocation) > 0 {
+		bucket, _, _ := strings.Cut(cfg.SnapshotLocation, "/")
+		grant(KindBucket, bucket, "roles/storage.objectViewer", "List and download snapshots to verify")
+	}
+	if len(cfg.AccessLogLocation) > 0 {
+		bucket, _, _ := strings.Cut(cfg.AccessLogLocation, "/")
+		grant(KindBucket, bucket, "roles/storage.objectViewer", "List and download access logs to ingest")
+	}
+	if len(cfg.KMSKey) > 0 {
+		grant(KindCryptoKey, cfg.KMSKey, "roles/cloudkms.cryptoKeyEncrypterDecrypter", "Wrap and unwrap the data keys of snapshots")
+	}
+	if len(cfg.MonitoringProject) > 0 {
+		grant(KindProject, cfg.MonitoringProject, "roles/monitoring.metricWriter", "Export custom metrics")
+	}
+	return r, nil
+}
+
+// terraformResources are the Terraform resource types granting roles per kind, and their resource argument
+var terraformResources = map[string][2]string{
+	KindProject:   {"google_project_iam_member", "project"},
+	KindBucket:    {"google_storage_bucket_iam_member", "bucket"},
+	KindCryptoKey: {"google_kms_crypto_key_iam_member", "crypto_key_id"},
+}
+
+// terraformNamePattern matches the characters Terraform names can't hold
+var terraformNamePattern = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// WriteTerraform writes the resources as Terraform configuration for the Google provider
+func (r *Resources) WriteTerraform(w io.Writer) error {
+	var b strings.Builder
+	sa := terraformName(r.ServiceAccount.AccountID)
+
+	fmt.Fprintf(&b, "resource \"google_service_account\" %q {\n", sa)
+	fmt.Fprintf(&b, "  project      = %q\n", r.ServiceAccount.Project)
+	fmt.Fprintf(&b, "  account_id   = %q\n", r.ServiceAccount.AccountID)
+	fmt.Fprintf(&b, "  display_name = %q\n", r.ServiceAccount.DisplayName)
+	b.WriteString("}\n")
+
+	names := make(map[string]int)
+	for _, binding := range r.Bindings {
+		tf, ok := terraformResources[binding.Kind]
+		if !ok {
+			return fmt.Errorf("unknown resource kind %q", binding.Kind)
+		}
+
+		// Names combine the resource and the role, numbered if they still collide
+		name := terraformName(lastSegment(binding.Resource) + "_" + strings.TrimPrefix(binding.Role, "roles/"))
+		if n := names[name]; n > 0 {
+			names[name]++
+			name = fmt.Sprintf("%s_%d", name, n)
+		} else {
+			names[name] = 1
+		}
+
+		fmt.Fprintf(&b, "\n# %s\n", binding.Reason)
+		fmt.Fprintf(&b, "resource %q %q {\n", tf[0], name)
+		// Arguments are aligned as terraform fmt would
+		width := max(len(tf[1]), len("member"))
+		fmt.Fprintf(&b, "  %-*s = %q\n", width, tf[1], binding.Resource)
+		fmt.Fprintf(&b, "  %-*s = %q\n", width, "role", binding.Role)
+		fmt.Fprintf(&b, "  %-*s = \"serviceAccount:${google_service_account.%s.email}\"\n", width, "member", sa)
+		b.WriteString("}\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// terraformName turns s into a valid Terraform resource name, which can't start with a digit
+func terraformName(s string) string {
+	name := strings.Trim(terraformNamePattern.ReplaceAllString(strings.ToLower(s), "_"), "_")
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// lastSegment returns what follows the last slash of a resource path, such as the key of a crypto key name
+func lastSegment(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
@@ -0,0 +1,97 @@
+package resources
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cfg          Config
+		wantBindings int
+		wantErr      bool
+	}{
+		{"Service account only", Config{Project: "mock", ServiceAccountID: "gcs-metadata"}, 0, false},
+		{
+			"Every feature",
+			Config{
+				Project:             "mock",
+				ServiceAccountID:    "gcs-metadata",
+				Buckets:             []string{"data", "logs"},
+				BillingProject:      "billing",
+				LeaseObject:         "infra/seeder.lease",
+				ReportDestination:   "infra/reports",
+				SnapshotDestination: "data/snapshots",
+				SnapshotLocation:    "data/snapshots",
+				AccessLogLocation:   "access-logs/gcs",
+				KMSKey:              "projects/mock/locations/global/keyRings/ring/cryptoKeys/snapshots",
+				MonitoringProject:   "mock",
+			},
+			// Reading snapshots of an indexed bucket needs no other binding
+			4 + 1 + 1 + 1 + 1 + 1 + 1 + 1, false,
+		},
+		{"Missing project", Config{ServiceAccountID: "gcs-metadata"}, 0, true},
+		{"Invalid account ID", Config{Project: "mock", ServiceAccountID: "GCS"}, 0, true},
+		{"Invalid lease object", Config{Project: "mock", ServiceAccountID: "gcs-metadata", LeaseObject: "infra"}, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Build(tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.ServiceAccount.Email != "gcs-metadata@mock.iam.gserviceaccount.com" {
+				t.Errorf("Email mismatch: got %s", r.ServiceAccount.Email)
+			}
+			if len(r.Bindings) != tc.wantBindings {
+				t.Errorf("Binding count mismatch: got %d want %d: %+v", len(r.Bindings), tc.wantBindings, r.Bindings)
+			}
+			for _, b := range r.Bindings {
+				if b.Member != "serviceAccount:"+r.ServiceAccount.Email || len(b.Reason) == 0 {
+					t.Errorf("Binding mismatch: got %+v", b)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTerraform(t *testing.T) {
+	r, err := Build(Config{
+		Project:          "mock",
+		ServiceAccountID: "gcs-metadata",
+		Buckets:          []string{"my.data"},
+		KMSKey:           "projects/mock/locations/global/keyRings/ring/cryptoKeys/snapshots",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := r.WriteTerraform(&b); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+
+	for _, want := range []string{
+		`resource "google_service_account" "gcs_metadata" {`,
+		`resource "google_storage_bucket_iam_member" "my_data_storage_objectviewer" {`,
+		`  bucket = "my.data"`,
+		`resource "google_kms_crypto_key_iam_member" "snapshots_cloudkms_cryptokeyencrypterdecrypter" {`,
+		`  crypto_key_id = "projects/mock/locations/global/keyRings/ring/cryptoKeys/snapshots"`,
+		`  role          = "roles/cloudkms.cryptoKeyEncrypterDecrypter"`,
+		`  member = "serviceAccount:${google_service_account.gcs_metadata.email}"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in:\n%s", want, got)
+		}
+	}
+}
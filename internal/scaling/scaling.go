@@ -0,0 +1,122 @@
+// Package scaling turns the write load of a server into a single signal external autoscalers can
+// scale on, read replicas being added or operators paged as it exceeds 1
+package scaling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Components of the signal
+const (
+	ComponentPending       = "pending"
+	ComponentOldestPending = "oldest_pending"
+	ComponentUtilization   = "utilization"
+)
+
+// Targets are the loads the signal reads 1 at, components at or below 0 being ignored
+type Targets struct {
+	Pending       int
+	OldestPending time.Duration
+	Utilization   float64
+}
+
+// DefaultTargets leave the single writer some headroom for bursts
+var DefaultTargets = Targets{
+	Pending:       50,
+	OldestPending: 5 * time.Second,
+	Utilization:   0.8,
+}
+
+// Signal samples the write load to compute the utilization of the writer between samples
+type Signal struct {
+	load    func() model.WriteLoad
+	targets Targets
+	clock   clock.Clock
+
+	mu       sync.Mutex
+	lastBusy model.Duration
+	lastAt   time.Time
+	current  model.ScalingSignal
+}
+
+// NewSignal computes the signal from load against targets
+func NewSignal(load func() model.WriteLoad, targets Targets) *Signal {
+	return &Signal{load: load, targets: targets, clock: clock.Real}
+}
+
+// SetClock replaces the clock samples are taken with, for tests
+func (s *Signal) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Sample updates the signal from the current load, the utilization covering the time since the previous sample
+func (s *Signal) Sample() model.ScalingSignal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	load := s.load()
+
+	signal := model.ScalingSignal{
+		Pending:       load.Pending,
+		OldestPending: load.OldestPending,
+		Sampled:       now,
+	}
+	if elapsed := now.Sub(s.lastAt); !s.lastAt.IsZero() && elapsed > 0 {
+		signal.Utilization = min(float64(load.Busy-s.lastBusy)/float64(elapsed), 1)
+	}
+	signal.Headroom = 1 - signal.Utilization
+	s.lastBusy, s.lastAt = load.Busy, now
+
+	components := []struct {
+		name  string
+		value float64
+	}{
+		{ComponentPending, ratio(float64(signal.Pending), float64(s.targets.Pending))},
+		{ComponentOldestPending, ratio(float64(signal.OldestPending), float64(s.targets.OldestPending))},
+		{ComponentUtilization, ratio(signal.Utilization, s.targets.Utilization)},
+	}
+	for _, c := range components {
+		if len(signal.Limiting) == 0 || c.value > signal.Value {
+			signal.Value, signal.Limiting = c.value, c.name
+		}
+	}
+
+	s.current = signal
+	return signal
+}
+
+func ratio(value, target float64) float64 {
+	if target <= 0 {
+		return 0
+	}
+	return value / target
+}
+
+// Current returns the signal of the latest sample
+func (s *Signal) Current() model.ScalingSignal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Run samples the load every interval until ctx is cancelled
+func (s *Signal) Run(ctx context.Context, interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.Sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s.Sample()
+		}
+	}
+}
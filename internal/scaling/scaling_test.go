@@ -0,0 +1,43 @@
+package scaling
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestSignal(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	load := model.WriteLoad{}
+
+	signal := NewSignal(func() model.WriteLoad { return load }, DefaultTargets)
+	signal.SetClock(clk)
+
+	if got := signal.Sample(); got.Value != 0 || got.Headroom != 1 {
+		t.Errorf("Expected an idle signal, got %+v", got)
+	}
+
+	// Writes in progress 3 seconds out of 10
+	clk.Advance(10 * time.Second)
+	load = model.WriteLoad{Pending: 10, OldestPending: model.Duration(time.Second), Busy: model.Duration(3 * time.Second)}
+	got := signal.Sample()
+	if math.Abs(got.Utilization-0.3) > 1e-9 || math.Abs(got.Headroom-0.7) > 1e-9 {
+		t.Errorf("Utilization mismatch: got %+v", got)
+	}
+	if got.Limiting != ComponentUtilization || math.Abs(got.Value-0.375) > 1e-9 {
+		t.Errorf("Expected utilization to limit, got %+v", got)
+	}
+
+	// A backlog twice the target
+	clk.Advance(10 * time.Second)
+	load = model.WriteLoad{Pending: 100, OldestPending: model.Duration(time.Second), Busy: model.Duration(4 * time.Second)}
+	if got := signal.Sample(); got.Limiting != ComponentPending || got.Value != 2 {
+		t.Errorf("Expected the backlog to limit, got %+v", got)
+	}
+	if current := signal.Current(); current.Value != 2 {
+		t.Errorf("Current mismatch: got %+v", current)
+	}
+}
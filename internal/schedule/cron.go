@@ -0,0 +1,156 @@
+// Package schedule runs the background jobs of a server on cron schedules, with jitter and without letting
+// the runs of a job overlap
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job runs next
+type Schedule interface {
+	// Next returns the first run strictly after t, or the zero time if the job never runs again
+	Next(t time.Time) time.Time
+}
+
+// shorthands are the cron expressions of the @ shorthands
+var shorthands = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Parse parses a cron expression of five fields, minute hour day-of-month month day-of-week, evaluated in UTC,
+// one of the @yearly, @monthly, @weekly, @daily and @hourly shorthands, or @every DURATION such as @every 10m
+// Fields are *, a number, a range such as 1-5 or a comma separated list of them, each optionally stepped by /N
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid interval %q, expected a duration of at least 1s", every)
+		}
+		return everySchedule(d), nil
+	}
+	if expr, ok := shorthands[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", spec)
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute: %w", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour: %w", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month: %w", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month: %w", err)
+	}
+	// Sunday is either 0 or 7
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDom, s.anyDow = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parseField parses a cron field of values between min and max into a set of bits
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeValue, stepValue, stepped := strings.Cut(item, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		low, high := min, max
+		if rangeValue != "*" {
+			lowValue, highValue, isRange := strings.Cut(rangeValue, "-")
+			var err error
+			if low, err = strconv.Atoi(lowValue); err != nil || low < min || low > max {
+				return 0, fmt.Errorf("invalid value %q, expected %d to %d", lowValue, min, max)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highValue); err != nil || high < low || high > max {
+					return 0, fmt.Errorf("invalid range %q", rangeValue)
+				}
+			} else if stepped {
+				high = max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// cronSchedule holds the values every field of a cron expression matches as bits
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// anyDom and anyDow are set for fields starting with *, a day then matching the other field alone
+	anyDom, anyDow bool
+}
+
+// maxSearchYears bounds the search of the next run of expressions which never match, such as February 30th
+const maxSearchYears = 5
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay follows cron in running on days matching either day field when both are restricted
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// everySchedule runs a job at a fixed interval
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	from := time.Date(2024, 10, 1, 12, 34, 56, 0, time.UTC) // a Tuesday
+
+	testCases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 10, 1, 12, 35, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 10, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 10, 2, 3, 0, 0, 0, time.UTC)},
+		{"30 2 * * 0", time.Date(2024, 10, 6, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2024, 10, 6, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 10, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 5", time.Date(2024, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"5,50 12 * * *", time.Date(2024, 10, 1, 12, 50, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 10, 1, 13, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 10, 1, 12, 36, 26, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			s, err := Parse(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(from); !got.Equal(tc.want) {
+				t.Errorf("Next mismatch: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@every 10ms", "@every soon", "@sometimes"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected an error parsing %q", spec)
+		}
+	}
+}
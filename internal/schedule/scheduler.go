@@ -0,0 +1,175 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Job is the work of a scheduled job, cancelled with the scheduler
+type Job func(ctx context.Context) error
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      Job
+
+	// due is the run the schedule asked for, next the same run delayed by jitter
+	due, next time.Time
+	status    model.JobStatus
+}
+
+// Scheduler runs jobs on their schedules, skipping the runs of a job whose previous run is still in progress
+type Scheduler struct {
+	clock  clock.Clock
+	jitter time.Duration
+
+	mu      sync.Mutex
+	jobs    []*job
+	running sync.WaitGroup
+}
+
+// New returns a scheduler delaying every run by a random duration of up to jitter, so that servers sharing a
+// schedule don't all hit GCS or the database at once
+func New(jitter time.Duration) *Scheduler {
+	return &Scheduler{clock: clock.Real, jitter: jitter}
+}
+
+// SetClock replaces the clock runs are scheduled with, for tests
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Add schedules run under name with spec, a cron expression as accepted by Parse
+// Jobs must be added before the scheduler runs
+func (s *Scheduler) Add(name, spec string, run Job) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule of job %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.name == name {
+			return fmt.Errorf("job %s is already scheduled", name)
+		}
+	}
+	s.jobs = append(s.jobs, &job{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// Run runs the jobs on their schedules until ctx is cancelled, then waits for the runs in progress to return
+// Failed runs are logged and retried at their next scheduled time
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.running.Wait()
+
+	s.mu.Lock()
+	now := s.clock.Now()
+	for _, j := range s.jobs {
+		s.plan(j, j.schedule.Next(now))
+	}
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		var next time.Time
+		for _, j := range s.jobs {
+			if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+				next = j.next
+			}
+		}
+		s.mu.Unlock()
+		if next.IsZero() {
+			<-ctx.Done()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(next.Sub(s.clock.Now())):
+		}
+
+		s.mu.Lock()
+		now := s.clock.Now()
+		for _, j := range s.jobs {
+			if j.next.IsZero() || j.next.After(now) {
+				continue
+			}
+			s.start(ctx, j)
+
+			// Runs missed while the process was suspended are not caught up
+			due := j.schedule.Next(j.due)
+			if !due.IsZero() && !due.After(now) {
+				due = j.schedule.Next(now)
+			}
+			s.plan(j, due)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// plan schedules the next run of j at due, delayed by jitter
+func (s *Scheduler) plan(j *job, due time.Time) {
+	j.due, j.next = due, due
+	if !due.IsZero() && s.jitter > 0 {
+		j.next = due.Add(rand.N(s.jitter))
+	}
+	j.status.NextRun = j.next
+}
+
+// start runs j in the background unless its previous run is still in progress
+func (s *Scheduler) start(ctx context.Context, j *job) {
+	if j.status.Running {
+		j.status.Skipped++
+		log.Printf("Skipping run of job %s, its previous run is still in progress", j.name)
+		return
+	}
+	j.status.Running = true
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+
+		start := s.clock.Now()
+		err := j.run(ctx)
+		duration := s.clock.Now().Sub(start)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		j.status.Running = false
+		j.status.Runs++
+		j.status.LastRun = &start
+		j.status.LastDuration = model.Duration(duration)
+		j.status.LastError = ""
+		if err != nil {
+			j.status.Failures++
+			j.status.LastError = err.Error()
+			log.Printf("Error running job %s: %v", j.name, err)
+		}
+	}()
+}
+
+// Status returns the schedule and last outcome of every job, sorted by name
+func (s *Scheduler) Status() []model.JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]model.JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status := j.status
+		status.Name, status.Schedule = j.name, j.spec
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
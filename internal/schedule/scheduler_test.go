@@ -0,0 +1,110 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// waitStatus polls the status of the only job of s until cond holds
+func waitStatus(t *testing.T, s *Scheduler, cond func(model.JobStatus) bool) model.JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := s.Status()[0]
+		if cond(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job status, got %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	start := time.Date(2024, 10, 1, 12, 0, 30, 0, time.UTC)
+	f := clock.NewFake(start)
+	s := New(0)
+	s.SetClock(f)
+
+	started := make(chan struct{})
+	release := make(chan error)
+	if err := s.Add("vacuum", "* * * * *", func(ctx context.Context) error {
+		started <- struct{}{}
+		return <-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("vacuum", "@daily", nil); err == nil {
+		t.Error("Expected an error scheduling a job twice")
+	}
+	if err := s.Add("export", "never", nil); err == nil {
+		t.Error("Expected an error scheduling an invalid expression")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	if got := s.Status()[0].NextRun; !got.Equal(time.Date(2024, 10, 1, 12, 1, 0, 0, time.UTC)) {
+		t.Errorf("Next run mismatch: got %v", got)
+	}
+
+	// Runs overlapping a run still in progress are skipped
+	f.Advance(30 * time.Second)
+	<-started
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	waitStatus(t, s, func(status model.JobStatus) bool { return status.Skipped == 1 })
+
+	release <- errors.New("database is locked")
+	status := waitStatus(t, s, func(status model.JobStatus) bool { return status.Runs == 1 })
+	if status.Failures != 1 || status.LastError != "database is locked" || status.Running || !status.LastRun.Equal(time.Date(2024, 10, 1, 12, 1, 0, 0, time.UTC)) {
+		t.Errorf("Status mismatch: got %+v", status)
+	}
+	if !status.NextRun.Equal(time.Date(2024, 10, 1, 12, 3, 0, 0, time.UTC)) {
+		t.Errorf("Next run mismatch: got %v", status.NextRun)
+	}
+
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-started
+	release <- nil
+	status = waitStatus(t, s, func(status model.JobStatus) bool { return status.Runs == 2 })
+	if status.Failures != 1 || len(status.LastError) > 0 {
+		t.Errorf("Status mismatch: got %+v", status)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	start := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	f := clock.NewFake(start)
+	s := New(time.Minute)
+	s.SetClock(f)
+
+	if err := s.Add("snapshot", "@hourly", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	f.BlockUntil(1)
+	due := time.Date(2024, 10, 1, 13, 0, 0, 0, time.UTC)
+	if got := s.Status()[0].NextRun; got.Before(due) || !got.Before(due.Add(time.Minute)) {
+		t.Errorf("Next run mismatch: got %v, want within a minute of %v", got, due)
+	}
+}
@@ -0,0 +1,136 @@
+package seeder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Backfiller seeds buckets registered at runtime in the background, one seeding per bucket at a time
+type Backfiller struct {
+	ctx              context.Context
+	client           *storage.Client
+	db               *repo.Database
+	workers          int
+	objectsPerSecond float64
+	clock            clock.Clock
+
+	mu      sync.Mutex
+	running map[string]*backfill
+}
+
+type backfill struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBackfiller seeds into db until ctx is cancelled
+func NewBackfiller(ctx context.Context, client *storage.Client, db *repo.Database) *Backfiller {
+	return &Backfiller{
+		ctx:     ctx,
+		client:  client,
+		db:      db,
+		workers: 1,
+		clock:   clock.Real,
+		running: make(map[string]*backfill),
+	}
+}
+
+// SetParallelism configures every backfill as SeedService.SetParallelism
+func (b *Backfiller) SetParallelism(workers int, objectsPerSecond float64) {
+	b.workers = workers
+	b.objectsPerSecond = objectsPerSecond
+}
+
+// SetClock times reconciliations with c
+func (b *Backfiller) SetClock(c clock.Clock) {
+	b.clock = c
+}
+
+// Start begins seeding bucket with its configuration, resuming its checkpoints if a previous backfill was interrupted
+// Once seeded, the bucket is caught up every reconcile interval of its configuration until stopped
+// It returns repo.ErrConflict if bucket is already being seeded
+func (b *Backfiller) Start(bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.running[bucket]; ok {
+		return fmt.Errorf("%w: bucket %s is already being backfilled", repo.ErrConflict, bucket)
+	}
+
+	bucketRepo := repo.NewBucketRepository(b.db)
+	cfg, err := bucketRepo.GetConfig(b.ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("error reading config of bucket %s: %w", bucket, err)
+	}
+
+	s := NewSeedService(b.client, bucket, bucketRepo, repo.NewDirectoryRepository(b.db), repo.NewMetadataRepository(b.db))
+	s.SetCheckpointRepository(repo.NewCheckpointRepository(b.db))
+	s.SetNoncurrentRepository(repo.NewNoncurrentRepository(b.db))
+	s.SetParallelism(b.workers, b.objectsPerSecond)
+	s.SetConfig(cfg)
+
+	ctx, cancel := context.WithCancel(b.ctx)
+	run := &backfill{cancel: cancel, done: make(chan struct{})}
+	b.running[bucket] = run
+
+	go func() {
+		defer close(run.done)
+		defer cancel()
+
+		defer func() {
+			b.mu.Lock()
+			delete(b.running, bucket)
+			b.mu.Unlock()
+		}()
+
+		log.Printf("Backfilling bucket %s\n", bucket)
+		start := time.Now()
+		if err := s.Start(ctx); err != nil {
+			log.Printf("Error backfilling bucket %s: %v\n", bucket, err)
+			return
+		}
+		log.Printf("Backfilled bucket %s in %v\n", bucket, time.Since(start))
+
+		if cfg.ReconcileInterval > 0 {
+			reconcile(ctx, s, b.clock, time.Duration(cfg.ReconcileInterval))
+		}
+	}()
+	return nil
+}
+
+// Stop cancels the seeding of bucket if running and waits for it to return
+func (b *Backfiller) Stop(bucket string) {
+	b.mu.Lock()
+	run, ok := b.running[bucket]
+	b.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	run.cancel()
+	<-run.done
+}
+
+// reconcile catches the bucket of s up every interval until ctx is cancelled
+func reconcile(ctx context.Context, s *SeedService, clk clock.Clock, interval time.Duration) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := s.CatchUp(ctx); err != nil {
+				log.Printf("Error reconciling bucket %s: %v\n", s.bucketId, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"google.golang.org/api/iterator"
+)
+
+// CatchUp brings an indexed bucket up to date after changes were missed, such as downtime longer than
+// notifications are retained, without seeding it again
+// Listings cannot filter on update time, so every top level prefix is listed and compared with the objects
+// stored under it, and only the objects created, updated or deleted since they were indexed are written
+// Catching up keeps no checkpoint, an interrupted catch-up compares every prefix again
+func (s *SeedService) CatchUp(ctx context.Context) error {
+	list, err := s.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return s.catchUp(ctx, list)
+}
+
+func (s *SeedService) catchUp(ctx context.Context, list func(q storage.Query) objectIterator) error {
+	s.mu.Lock()
+	s.progress = model.SeedProgress{Bucket: s.bucketId, Started: time.Now()}
+	s.resumedObjects = 0
+	s.mu.Unlock()
+
+	since, err := s.metadataRepo.LastUpdated(ctx, s.bucketId)
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		log.Println("No object indexed yet, catching up on every object")
+	case err != nil:
+		return fmt.Errorf("error reading last update: %w", err)
+	default:
+		log.Printf("Catching up on changes since %v\n", since)
+		s.mu.Lock()
+		s.progress.CatchUpSince = &since
+		s.mu.Unlock()
+	}
+
+	// Prefixes are compared with the indexed ones, which split on "/" whatever the seeding delimiter
+	var root objectSlice
+	prefixes, err := s.discoverPrefixes(ctx, list, "/", func(obj *storage.ObjectAttrs) error {
+		root = append(root, obj)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Prefixes whose objects were all deleted are no longer listed
+	indexed, err := s.metadataRepo.TopLevelPrefixes(ctx, s.bucketId)
+	if err != nil {
+		return fmt.Errorf("error reading indexed prefixes: %w", err)
+	}
+	prefixes = mergePrefixes(prefixes, indexed)
+
+	// Prefixes holding nothing but expired noncurrent generations are no longer listed either
+	if s.noncurrentRepo != nil {
+		noncurrent, err := s.noncurrentRepo.TopLevelPrefixes(ctx, s.bucketId)
+		if err != nil {
+			return fmt.Errorf("error reading indexed prefixes: %w", err)
+		}
+		prefixes = mergePrefixes(prefixes, noncurrent)
+	}
+
+	if err := s.catchUpPrefix(ctx, "", &root); err != nil {
+		return err
+	}
+
+	if err := s.forEachPrefix(ctx, prefixes, func(ctx context.Context, prefix string) error {
+		it := newResumingIterator(ctx, s.gcsBreaker, "", 0, func(startOffset string) objectIterator {
+			return list(storage.Query{Prefix: prefix, StartOffset: startOffset})
+		})
+		return s.catchUpPrefix(ctx, prefix, it)
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.progress.Completed = true
+	progress := s.progress
+	s.mu.Unlock()
+
+	log.Printf("Caught up: %d objects inserted, %d updated, %d deleted\n", progress.Inserted, progress.Updated, progress.Deleted)
+	return nil
+}
+
+// catchUpPrefix applies the differences between the objects listed under prefix and those indexed under it
+// The root prefix "" only compares the objects at the root of the bucket
+func (s *SeedService) catchUpPrefix(ctx context.Context, prefix string, it objectIterator) error {
+	stored, err := s.metadataRepo.ListPrefix(ctx, s.bucketId, prefix, len(prefix) > 0)
+	if err != nil {
+		return fmt.Errorf("error reading indexed objects: %w", err)
+	}
+
+	unlisted := make(map[string]*model.Metadata, len(stored))
+	for _, obj := range stored {
+		unlisted[obj.Name] = obj
+	}
+
+	generations, err := s.storedGenerations(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	unlistedGenerations := make(map[generationKey]bool, len(generations))
+	for _, obj := range generations {
+		unlistedGenerations[generationKey{obj.Name, obj.Generation}] = true
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.wait(ctx); err != nil {
+			return err
+		}
+
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error retrieving iterator object: %v", err)
+		}
+
+		// Directories already count an unknown share of aggregate-only objects, they are left as they are
+		// Indexed objects of excluded prefixes stay unlisted, so they are deleted
+		current := unlisted[obj.Name]
+		switch {
+		case !obj.Deleted.IsZero():
+			// Noncurrent generations never change, they are only created and expire
+			key := generationKey{obj.Name, obj.Generation}
+			if unlistedGenerations[key] {
+				delete(unlistedGenerations, key)
+			} else if !hasPrefix(obj.Name, s.aggregateOnlyPrefixes) {
+				s.insertObject(ctx, obj, nil)
+			}
+		case hasPrefix(obj.Name, s.aggregateOnlyPrefixes):
+			delete(unlisted, obj.Name)
+		case !hasPrefix(obj.Name, s.excludePrefixes):
+			delete(unlisted, obj.Name)
+			s.catchUpObject(ctx, obj, current)
+		}
+
+		if err := s.recordProgress(ctx, nil, obj); err != nil {
+			return err
+		}
+	}
+
+	// Iterate the sorted slice so deletions apply in name order
+	for _, obj := range stored {
+		if _, ok := unlisted[obj.Name]; ok {
+			s.deleteObject(ctx, obj)
+			s.count(&s.progress.Deleted)
+		}
+	}
+
+	for _, obj := range generations {
+		if unlistedGenerations[generationKey{obj.Name, obj.Generation}] {
+			s.deleteNoncurrent(ctx, obj)
+		}
+	}
+
+	if len(prefix) > 0 {
+		s.mu.Lock()
+		s.progress.PartitionsCompleted++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// catchUpObject indexes a listed object, current being its indexed version or nil
+// Errors are logged, so a single malformed object does not stop catching up
+func (s *SeedService) catchUpObject(ctx context.Context, obj *storage.ObjectAttrs, current *model.Metadata) {
+	switch {
+	case current == nil:
+		s.insertObject(ctx, obj, nil)
+		s.count(&s.progress.Inserted)
+	case current.StorageClass != obj.StorageClass:
+		// Directories total sizes per storage class, the object moves from one total to the other
+		s.deleteObject(ctx, current)
+		s.insertObject(ctx, obj, nil)
+		s.count(&s.progress.Updated)
+	case obj.Updated.After(current.Updated) || obj.Size != current.Size:
+		err := s.metadataRepo.Update(ctx, s.bucketId, obj.Name, obj.Size, customTime(obj), obj.Updated)
+		if errors.Is(err, repo.ErrStale) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating metadata: %v", err)
+			return
+		}
+
+		err = s.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), s.bucketId, obj.Name, obj.Size-current.Size, 0)
+		if err != nil {
+			log.Printf("Error upserting directories: %v", err)
+		}
+
+		if entities := aclEntities(obj.ACL); s.aclRepo != nil && len(entities) > 0 {
+			if err := s.aclRepo.Upsert(ctx, s.bucketId, obj.Name, entities); err != nil {
+				log.Printf("Error recording object ACL: %v", err)
+			}
+		}
+		s.count(&s.progress.Updated)
+	}
+}
+
+// deleteObject removes an indexed object and subtracts it from its parent directories
+func (s *SeedService) deleteObject(ctx context.Context, obj *model.Metadata) {
+	err := s.metadataRepo.Delete(ctx, obj.Bucket, obj.Name)
+	if errors.Is(err, repo.ErrNotFound) {
+		return // already deleted, directories no longer account for it
+	}
+	if err != nil {
+		log.Printf("Error deleting metadata: %v", err)
+		return
+	}
+
+	err = s.directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, -obj.Size, -1)
+	if err != nil {
+		log.Printf("Error upserting directories: %v", err)
+	}
+}
+
+// generationKey identifies a generation of an object
+type generationKey struct {
+	name       string
+	generation int64
+}
+
+// storedGenerations returns the noncurrent generations indexed under prefix as catchUpPrefix compares them,
+// or none if noncurrent generations are not accounted
+func (s *SeedService) storedGenerations(ctx context.Context, prefix string) ([]*model.NoncurrentObject, error) {
+	if s.noncurrentRepo == nil {
+		return nil, nil
+	}
+
+	generations, err := s.noncurrentRepo.ListPrefix(ctx, s.bucketId, prefix, len(prefix) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading indexed noncurrent generations: %w", err)
+	}
+	return generations, nil
+}
+
+// deleteNoncurrent removes an expired noncurrent generation and subtracts it from its parent directories
+func (s *SeedService) deleteNoncurrent(ctx context.Context, obj *model.NoncurrentObject) {
+	err := s.noncurrentRepo.Delete(ctx, obj.Bucket, obj.Name, obj.Generation)
+	if errors.Is(err, repo.ErrNotFound) {
+		return // already deleted, directories no longer account for it
+	}
+	if err != nil {
+		log.Printf("Error deleting noncurrent generation: %v", err)
+		return
+	}
+
+	if err := s.directoryRepo.UpsertNoncurrentDirs(ctx, obj.Bucket, obj.Name, -obj.Size, -1); err != nil {
+		log.Printf("Error upserting directories: %v", err)
+	}
+}
+
+// count increments a counter of s.progress
+func (s *SeedService) count(counter *int64) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// mergePrefixes returns the sorted union of two sorted prefix lists
+func mergePrefixes(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			merged = append(merged, a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			merged = append(merged, b[0])
+			b = b[1:]
+		default:
+			merged = append(merged, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return merged
+}
+
+// objectSlice iterates objects already listed
+type objectSlice []*storage.ObjectAttrs
+
+func (o *objectSlice) Next() (*storage.ObjectAttrs, error) {
+	if len(*o) == 0 {
+		return nil, iterator.Done
+	}
+
+	obj := (*o)[0]
+	*o = (*o)[1:]
+	return obj, nil
+}
@@ -0,0 +1,178 @@
+package seeder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/breaker"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestCatchUp(t *testing.T) {
+	ctx := context.Background()
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	changed := indexed.Add(time.Hour)
+
+	metadataRepo := repo.NewMetadataRepository(db)
+	directoryRepo := repo.NewDirectoryRepository(db)
+	for _, obj := range []*model.Metadata{
+		{Bucket: "mock", Name: "a/kept", Size: 1, StorageClass: "STANDARD"},
+		{Bucket: "mock", Name: "a/resized", Size: 1, StorageClass: "STANDARD"},
+		{Bucket: "mock", Name: "a/archived", Size: 1, StorageClass: "STANDARD"},
+		{Bucket: "mock", Name: "a/deleted", Size: 1, StorageClass: "STANDARD"},
+		{Bucket: "mock", Name: "gone/deleted", Size: 1, StorageClass: "STANDARD"},
+		{Bucket: "mock", Name: "root", Size: 1, StorageClass: "STANDARD"},
+	} {
+		obj.Created, obj.Updated = indexed, indexed
+		if err := metadataRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := directoryRepo.UpsertParentDirs(ctx, repo.StorageClass(obj.StorageClass), obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := listObjects([]*storage.ObjectAttrs{
+		{Bucket: "mock", Name: "a/archived", Size: 1, StorageClass: "ARCHIVE", Updated: changed},
+		{Bucket: "mock", Name: "a/kept", Size: 1, StorageClass: "STANDARD", Updated: indexed},
+		{Bucket: "mock", Name: "a/resized", Size: 5, StorageClass: "STANDARD", Updated: changed},
+		{Bucket: "mock", Name: "b/created", Size: 2, StorageClass: "STANDARD", Updated: changed},
+		{Bucket: "mock", Name: "root", Size: 1, StorageClass: "STANDARD", Updated: indexed},
+	})
+
+	s := &SeedService{
+		bucketId:      "mock",
+		metadataRepo:  metadataRepo,
+		directoryRepo: directoryRepo,
+		gcsBreaker:    breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+	}
+	s.SetParallelism(2, 0)
+
+	if err := s.catchUp(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := metadataRepo.ListPrefix(ctx, "mock", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, obj := range objects {
+		got = append(got, obj.Name+":"+obj.StorageClass)
+	}
+	want := []string{"a/archived:ARCHIVE", "a/kept:STANDARD", "a/resized:STANDARD", "b/created:STANDARD", "root:STANDARD"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Objects mismatch: got %v, want %v", got, want)
+	}
+
+	var size, count int64
+	if err := db.QueryRow(`SELECT size_standard + size_archive, count FROM directory WHERE bucket = 'mock' AND name = 'a/';`).Scan(&size, &count); err != nil {
+		t.Fatal(err)
+	}
+	if size != 7 || count != 3 {
+		t.Errorf("Directory a/ mismatch: got size %d count %d, want size 7 count 3", size, count)
+	}
+
+	progress := s.Progress()
+	if !progress.Completed || progress.CatchUpSince == nil || !progress.CatchUpSince.Equal(indexed) {
+		t.Errorf("Progress mismatch: got %+v", progress)
+	}
+	if progress.Inserted != 1 || progress.Updated != 2 || progress.Deleted != 2 || progress.Partitions != 3 || progress.PartitionsCompleted != 3 {
+		t.Errorf("Changes mismatch: got %d inserted, %d updated, %d deleted over %d/%d partitions",
+			progress.Inserted, progress.Updated, progress.Deleted, progress.PartitionsCompleted, progress.Partitions)
+	}
+}
+
+func TestCatchUpNoncurrent(t *testing.T) {
+	ctx := context.Background()
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	changed := indexed.Add(time.Hour)
+
+	metadataRepo := repo.NewMetadataRepository(db)
+	directoryRepo := repo.NewDirectoryRepository(db)
+	noncurrentRepo := repo.NewNoncurrentRepository(db)
+
+	if err := metadataRepo.Insert(ctx, &model.Metadata{Bucket: "mock", Name: "a/file", Size: 1, StorageClass: "STANDARD", Created: indexed, Updated: indexed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := directoryRepo.UpsertParentDirs(ctx, repo.StorageStandard, "mock", "a/file", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []*model.NoncurrentObject{
+		{Bucket: "mock", Name: "a/expired", Generation: 1, Size: 3, StorageClass: "STANDARD", Deleted: indexed},
+		{Bucket: "mock", Name: "gone/expired", Generation: 1, Size: 4, StorageClass: "STANDARD", Deleted: indexed},
+	} {
+		if err := noncurrentRepo.Insert(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := directoryRepo.UpsertNoncurrentDirs(ctx, obj.Bucket, obj.Name, obj.Size, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a/file was overwritten, its first generation becoming noncurrent
+	list := listObjects([]*storage.ObjectAttrs{
+		{Bucket: "mock", Name: "a/file", Generation: 1, Size: 1, StorageClass: "STANDARD", Updated: indexed, Deleted: changed},
+		{Bucket: "mock", Name: "a/file", Generation: 2, Size: 2, StorageClass: "STANDARD", Updated: changed},
+		{Bucket: "mock", Name: "a/marker/", Generation: 1, StorageClass: "STANDARD", Updated: indexed, Deleted: changed},
+	})
+
+	s := &SeedService{
+		bucketId:       "mock",
+		metadataRepo:   metadataRepo,
+		directoryRepo:  directoryRepo,
+		noncurrentRepo: noncurrentRepo,
+		gcsBreaker:     breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+	}
+
+	if err := s.catchUp(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	generations, err := noncurrentRepo.ListPrefix(ctx, "mock", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 1 || generations[0].Name != "a/file" || generations[0].Generation != 1 {
+		t.Errorf("Noncurrent generations mismatch: got %+v", generations)
+	}
+
+	for _, tc := range []struct {
+		dir                     string
+		size, noncurrent, count int64
+	}{
+		{"a/", 2, 1, 1},
+		{"gone/", 0, 0, 0},
+		{"/", 2, 1, 1},
+	} {
+		var size, noncurrent, count int64
+		if err := db.QueryRow(`SELECT size_standard, noncurrent_size, noncurrent_count FROM directory WHERE bucket = 'mock' AND name = ?;`, tc.dir).Scan(&size, &noncurrent, &count); err != nil {
+			t.Fatal(err)
+		}
+		if size != tc.size || noncurrent != tc.noncurrent || count != tc.count {
+			t.Errorf("Directory %s mismatch: got size %d noncurrent %d over %d generations, want %d, %d over %d",
+				tc.dir, size, noncurrent, count, tc.size, tc.noncurrent, tc.count)
+		}
+	}
+}
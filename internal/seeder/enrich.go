@@ -0,0 +1,103 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+const (
+	// sniffLength is the number of leading bytes read from an object, all http.DetectContentType considers
+	sniffLength = 512
+	// enrichQueueSize bounds the objects waiting to be sniffed, later samples are dropped
+	enrichQueueSize = 1024
+)
+
+// Enricher detects the content type of a sample of new objects from their leading bytes,
+// for buckets whose uploaders set every content type to application/octet-stream
+// Objects are never read beyond their first sniffLength bytes, nor hashed
+type Enricher struct {
+	read         func(ctx context.Context, name string) (io.ReadCloser, error)
+	metadataRepo repo.MetadataRepository
+	fraction     float64
+	queue        chan *model.Metadata
+}
+
+// NewEnricher sniffs fraction of the objects observed in bucket b, from 0 for none to 1 for all
+func NewEnricher(b *storage.BucketHandle, metadataRepo repo.MetadataRepository, fraction float64) *Enricher {
+	return newEnricher(func(ctx context.Context, name string) (io.ReadCloser, error) {
+		r, err := b.Object(name).NewRangeReader(ctx, 0, sniffLength)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}, metadataRepo, fraction)
+}
+
+func newEnricher(read func(ctx context.Context, name string) (io.ReadCloser, error), metadataRepo repo.MetadataRepository, fraction float64) *Enricher {
+	return &Enricher{
+		read:         read,
+		metadataRepo: metadataRepo,
+		fraction:     fraction,
+		queue:        make(chan *model.Metadata, enrichQueueSize),
+	}
+}
+
+// Observe samples a new object for sniffing without blocking
+// It must not be called once the enricher is closed
+func (e *Enricher) Observe(obj *model.Metadata) {
+	if obj.Size == 0 || rand.Float64() >= e.fraction {
+		return
+	}
+
+	select {
+	case e.queue <- obj:
+	default:
+	}
+}
+
+// Close lets Run return once the sampled objects are sniffed
+func (e *Enricher) Close() {
+	close(e.queue)
+}
+
+// Run sniffs sampled objects until the enricher is closed or ctx is cancelled
+func (e *Enricher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case obj, ok := <-e.queue:
+			if !ok {
+				return
+			}
+			if err := e.sniff(ctx, obj); err != nil {
+				log.Printf("Error detecting content type of %s: %v", obj.Name, err)
+			}
+		}
+	}
+}
+
+// sniff records the content type detected from the first bytes of obj
+func (e *Enricher) sniff(ctx context.Context, obj *model.Metadata) error {
+	r, err := e.read(ctx, obj.Name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	return e.metadataRepo.SetDetectedType(ctx, obj.Bucket, obj.Name, http.DetectContentType(buf[:n]))
+}
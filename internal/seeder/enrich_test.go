@@ -0,0 +1,83 @@
+package seeder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestEnricher(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	contents := map[string][]byte{
+		"image":   append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 1024)...),
+		"text":    []byte("plain text"),
+		"pdf":     []byte("%PDF-1.7"),
+		"missing": nil,
+	}
+
+	var reads []string
+	read := func(ctx context.Context, name string) (io.ReadCloser, error) {
+		reads = append(reads, name)
+		if contents[name] == nil {
+			return nil, errors.New("object not found")
+		}
+		return io.NopCloser(bytes.NewReader(contents[name])), nil
+	}
+
+	metadataRepo := repo.NewMetadataRepository(db)
+	enricher := newEnricher(read, metadataRepo, 1)
+
+	sizes := map[string]int64{"image": 1032, "text": 10, "pdf": 8, "missing": 1, "empty": 0}
+	for _, name := range []string{"image", "text", "pdf", "missing", "empty"} {
+		obj := &model.Metadata{Bucket: "mock", Name: name, Size: sizes[name], StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+		if err := metadataRepo.Insert(context.Background(), obj); err != nil {
+			t.Fatal(err)
+		}
+		enricher.Observe(obj)
+	}
+	enricher.Close()
+	enricher.Run(context.Background())
+
+	// Empty objects have nothing to sniff
+	if len(reads) != 4 {
+		t.Errorf("Reads mismatch: got %v", reads)
+	}
+
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{"image", "image/png"},
+		{"text", "text/plain; charset=utf-8"},
+		{"pdf", "application/pdf"},
+		{"missing", ""},
+		{"empty", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			if err := db.Get(&got, `SELECT COALESCE(detected_type, '') FROM metadata WHERE name = ?;`, tc.name); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("Detected type mismatch: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Nothing is sampled with a zero fraction
+	unsampled := newEnricher(read, metadataRepo, 0)
+	unsampled.Observe(&model.Metadata{Bucket: "mock", Name: "image", Size: 1})
+	if len(unsampled.queue) != 0 {
+		t.Errorf("Expected no sample, got %d", len(unsampled.queue))
+	}
+}
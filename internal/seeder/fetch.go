@@ -0,0 +1,79 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/breaker"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// Fetcher looks objects missing from the index up in GCS, so lookups are answered for objects
+// created since their bucket was last seeded or caught up
+// Objects found are indexed as seeding would have, those of unregistered buckets are never looked up
+type Fetcher struct {
+	attrs         func(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error)
+	gcsBreaker    *breaker.Breaker
+	bucketRepo    repo.BucketRepository
+	directoryRepo repo.DirectoryRepository
+}
+
+// NewFetcher looks objects up with client, indexing them into db
+func NewFetcher(client *storage.Client, db *repo.Database) *Fetcher {
+	cfg := breaker.DefaultConfig
+	cfg.Retryable = storage.ShouldRetry
+
+	return newFetcher(func(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+		return client.Bucket(bucket).Object(name).Attrs(ctx)
+	}, breaker.New("gcs", cfg), db)
+}
+
+func newFetcher(attrs func(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error), b *breaker.Breaker, db *repo.Database) *Fetcher {
+	return &Fetcher{
+		attrs:         attrs,
+		gcsBreaker:    b,
+		bucketRepo:    repo.NewBucketRepository(db),
+		directoryRepo: repo.NewDirectoryRepository(db),
+	}
+}
+
+// Fetch returns the live metadata of an object, indexing it unless its bucket configuration excludes it
+// It returns repo.ErrNotFound if the bucket is not registered or the object does not exist
+func (f *Fetcher) Fetch(ctx context.Context, bucket, name string) (*model.Metadata, error) {
+	cfg, err := f.bucketRepo.GetConfig(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj *storage.ObjectAttrs
+	err = f.gcsBreaker.Do(ctx, func() error {
+		var err error
+		obj, err = f.attrs(ctx, bucket, name)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil // missing objects are an answer, not a failure
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error looking object up: %w", err)
+	}
+	if obj == nil {
+		return nil, repo.ErrNotFound
+	}
+
+	metadata := newMetadata(obj)
+	if hasPrefix(name, cfg.ExcludePrefixes) || hasPrefix(name, cfg.AggregateOnlyPrefixes) {
+		return metadata, nil
+	}
+
+	// A seeding or another lookup may index the object first, directories then already account for it
+	err = f.directoryRepo.InsertObject(ctx, metadata)
+	if err != nil && !errors.Is(err, repo.ErrConflict) {
+		log.Printf("Error inserting metadata: %v", err)
+	}
+	return metadata, nil
+}
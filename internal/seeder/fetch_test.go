@@ -0,0 +1,86 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/breaker"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	bucketRepo := repo.NewBucketRepository(db)
+	if err := bucketRepo.Upsert(ctx, model.Bucket{Name: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucketRepo.SetConfig(ctx, "mock", &model.BucketConfig{ExcludePrefixes: []string{"tmp/"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	customTime := time.Date(2024, 10, 1, 0, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	var lookups int
+	f := newFetcher(func(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+		lookups++
+		if name == "gone" {
+			return nil, storage.ErrObjectNotExist
+		}
+		return &storage.ObjectAttrs{Bucket: bucket, Name: name, Size: 3, StorageClass: "STANDARD", Updated: time.Now(), CustomTime: customTime}, nil
+	}, breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}), db)
+
+	if _, err := f.Fetch(ctx, "unregistered", "a/new"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unregistered bucket, got %v", err)
+	}
+	if _, err := f.Fetch(ctx, "mock", "gone"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing object, got %v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("Lookups mismatch: got %d, want 1", lookups)
+	}
+
+	for _, name := range []string{"a/new", "tmp/excluded"} {
+		obj, err := f.Fetch(ctx, "mock", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Name != name || obj.Size != 3 {
+			t.Errorf("Object mismatch: got %+v", obj)
+		}
+	}
+
+	metadataRepo := repo.NewMetadataRepository(db)
+	if obj, err := metadataRepo.Get(ctx, "mock", "a/new"); err != nil {
+		t.Errorf("Expected a/new to be indexed, got %v", err)
+	} else if obj.CustomTime == nil || !obj.CustomTime.Equal(customTime) {
+		t.Errorf("Custom time mismatch: got %v, want %v", obj.CustomTime, customTime)
+	}
+	if _, err := metadataRepo.Get(ctx, "mock", "tmp/excluded"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Expected excluded object not to be indexed, got %v", err)
+	}
+
+	// Fetching an object indexed meanwhile leaves its directories as they are
+	if _, err := f.Fetch(ctx, "mock", "a/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	var size, count int64
+	if err := db.QueryRow(`SELECT size_standard, count FROM directory WHERE bucket = 'mock' AND name = 'a/';`).Scan(&size, &count); err != nil {
+		t.Fatal(err)
+	}
+	if size != 3 || count != 1 {
+		t.Errorf("Directory a/ mismatch: got size %d count %d, want size 3 count 1", size, count)
+	}
+}
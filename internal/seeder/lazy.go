@@ -0,0 +1,129 @@
+package seeder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+// LazyIndexer indexes the subtree of a prefix from GCS on its first query, so buckets that are sparsely
+// queried are served without seeding them first, notifications keeping indexed subtrees fresh thereafter
+// Only registered buckets are listed, and prefixes under a seeded or already indexed one are never listed again
+type LazyIndexer struct {
+	ctx            context.Context
+	bucketRepo     repo.BucketRepository
+	checkpointRepo repo.CheckpointRepository
+	// seed lists and indexes the objects of bucket under prefix
+	seed func(ctx context.Context, bucket string, prefix string) error
+
+	mu       sync.Mutex
+	indexing map[string]*lazyIndexing
+}
+
+// lazyIndexing is a listing in progress, awaited by the queries of its prefix
+type lazyIndexing struct {
+	done chan struct{}
+	err  error
+}
+
+// NewLazyIndexer lists objects with client, indexing them into db until ctx is cancelled
+func NewLazyIndexer(ctx context.Context, client *storage.Client, db *repo.Database) *LazyIndexer {
+	bucketRepo := repo.NewBucketRepository(db)
+	checkpointRepo := repo.NewCheckpointRepository(db)
+
+	return newLazyIndexer(ctx, func(ctx context.Context, bucket string, prefix string) error {
+		cfg, err := bucketRepo.GetConfig(ctx, bucket)
+		if err != nil {
+			return fmt.Errorf("error reading config of bucket %s: %w", bucket, err)
+		}
+
+		s := NewSeedService(client, bucket, bucketRepo, repo.NewDirectoryRepository(db), repo.NewMetadataRepository(db))
+		s.SetCheckpointRepository(checkpointRepo)
+		s.SetNoncurrentRepository(repo.NewNoncurrentRepository(db))
+		s.SetConfig(cfg)
+		return s.SeedPrefix(ctx, prefix)
+	}, db)
+}
+
+func newLazyIndexer(ctx context.Context, seed func(ctx context.Context, bucket string, prefix string) error, db *repo.Database) *LazyIndexer {
+	return &LazyIndexer{
+		ctx:            ctx,
+		bucketRepo:     repo.NewBucketRepository(db),
+		checkpointRepo: repo.NewCheckpointRepository(db),
+		seed:           seed,
+		indexing:       make(map[string]*lazyIndexing),
+	}
+}
+
+// Index lists the objects under the directory path in every registered bucket it was not listed in yet,
+// waiting for listings of the same prefix started by other queries
+// The root directory "/" lists whole buckets
+func (l *LazyIndexer) Index(ctx context.Context, path string) error {
+	prefix := strings.TrimPrefix(path, "/")
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	buckets, err := l.bucketRepo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing buckets: %w", err)
+	}
+
+	for _, bucket := range buckets {
+		covered, err := l.checkpointRepo.Covers(ctx, bucket.Name, prefix)
+		if err != nil {
+			return fmt.Errorf("error reading checkpoints of bucket %s: %w", bucket.Name, err)
+		}
+		if covered {
+			continue
+		}
+		if err := l.indexPrefix(ctx, bucket.Name, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexPrefix lists prefix of bucket unless another query is already listing it, then waits for that listing
+// Listings outlive the queries giving up on them, an interrupted listing is resumed from its checkpoint
+func (l *LazyIndexer) indexPrefix(ctx context.Context, bucket string, prefix string) error {
+	key := bucket + "/" + prefix
+
+	l.mu.Lock()
+	run, ok := l.indexing[key]
+	if !ok {
+		run = &lazyIndexing{done: make(chan struct{})}
+		l.indexing[key] = run
+
+		go func() {
+			defer close(run.done)
+			defer func() {
+				l.mu.Lock()
+				delete(l.indexing, key)
+				l.mu.Unlock()
+			}()
+
+			log.Printf("Indexing %q of bucket %s on demand\n", prefix, bucket)
+			start := time.Now()
+			if run.err = l.seed(l.ctx, bucket, prefix); run.err != nil {
+				run.err = fmt.Errorf("error indexing %q of bucket %s: %w", prefix, bucket, run.err)
+				return
+			}
+			log.Printf("Indexed %q of bucket %s in %v\n", prefix, bucket, time.Since(start))
+		}()
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,98 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+func TestLazyIndex(t *testing.T) {
+	ctx := context.Background()
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(ctx)
+	defer db.Close()
+
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	bucketRepo := repo.NewBucketRepository(db)
+	checkpointRepo := repo.NewCheckpointRepository(db)
+	for _, name := range []string{"mock", "seeded"} {
+		if err := bucketRepo.Upsert(ctx, model.Bucket{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkpointRepo.Save(ctx, &model.SeedCheckpoint{Bucket: "seeded", Completed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var listed []string
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	l := newLazyIndexer(ctx, func(ctx context.Context, bucket string, prefix string) error {
+		mu.Lock()
+		listed = append(listed, bucket+"/"+prefix)
+		mu.Unlock()
+
+		switch prefix {
+		case "err/":
+			return errors.New("listing failed")
+		case "slow/":
+			started <- struct{}{}
+			<-release
+		}
+		return checkpointRepo.Save(ctx, &model.SeedCheckpoint{Bucket: bucket, Prefix: prefix, Completed: true})
+	}, db)
+
+	for _, path := range []string{"a/b", "a/b/c/", "a/b/"} {
+		if err := l.Index(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Index(ctx, "err"); err == nil {
+		t.Error("Expected the failed listing to be reported")
+	}
+
+	// Queries of a prefix being listed wait for its listing instead of starting another
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- l.Index(ctx, "slow/")
+	}()
+	<-started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- l.Index(ctx, "slow/")
+	}()
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"mock/a/b/", "mock/err/", "mock/slow/"}
+	if len(listed) != len(want) {
+		t.Fatalf("Listings mismatch: got %v want %v", listed, want)
+	}
+	for i := range want {
+		if listed[i] != want[i] {
+			t.Errorf("Listing %d mismatch: got %s want %s", i, listed[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package seeder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"google.golang.org/api/iterator"
+)
+
+// seedParallel indexes the root level objects of the bucket while discovering its top level prefixes,
+// then lists every prefix as a partition of its own, up to s.workers at a time
+func (s *SeedService) seedParallel(ctx context.Context, list func(q storage.Query) objectIterator, resume bool) error {
+	delimiter := s.delimiter
+	if len(delimiter) == 0 {
+		delimiter = "/"
+	}
+
+	// Root level objects are checkpointed apart, so a resumed seeding only visits those it did not before
+	root := &partition{prefix: rootPartition}
+	if resume && s.checkpointRepo != nil {
+		if _, err := s.resumePartition(ctx, root); err != nil {
+			return err
+		}
+	}
+
+	// Until every partition completes, an interrupted seeding resumes the partitions
+	if err := s.saveBucketCheckpoint(ctx, false); err != nil {
+		return err
+	}
+
+	prefixes, err := s.discoverPrefixes(ctx, list, delimiter, func(obj *storage.ObjectAttrs) error {
+		if root.listed(obj) {
+			return nil
+		}
+		s.insertObject(ctx, obj, root)
+		return s.recordProgress(ctx, root, obj)
+	})
+	if err != nil {
+		return err
+	}
+	if !root.completed {
+		if err := s.recordProgress(ctx, root, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := s.forEachPrefix(ctx, prefixes, func(ctx context.Context, prefix string) error {
+		return s.seedPartition(ctx, &partition{prefix: prefix}, list, resume)
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.progress.Completed = true
+	s.mu.Unlock()
+
+	return s.saveBucketCheckpoint(ctx, true)
+}
+
+// forEachPrefix runs fn for every prefix, up to s.workers at a time, stopping at the first error
+func (s *SeedService) forEachPrefix(ctx context.Context, prefixes []string, fn func(ctx context.Context, prefix string) error) error {
+	s.mu.Lock()
+	s.progress.Partitions = len(prefixes)
+	s.mu.Unlock()
+
+	workers := max(1, min(s.workers, len(prefixes)))
+	log.Printf("Listing %d prefixes with %d workers\n", len(prefixes), workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partitions := make(chan string)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for prefix := range partitions {
+				if err := fn(ctx, prefix); err != nil {
+					errs <- fmt.Errorf("error listing prefix %q: %w", prefix, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, prefix := range prefixes {
+		select {
+		case partitions <- prefix:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(partitions)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// discoverPrefixes lists the bucket with delimiter, visiting root level objects and returning top level prefixes
+// The listing is cheap compared to partitions, so it is never checkpointed
+func (s *SeedService) discoverPrefixes(ctx context.Context, list func(q storage.Query) objectIterator, delimiter string, visit func(obj *storage.ObjectAttrs) error) ([]string, error) {
+	it := newResumingIterator(ctx, s.gcsBreaker, "", 0, func(startOffset string) objectIterator {
+		return list(storage.Query{Delimiter: delimiter, StartOffset: startOffset})
+	})
+
+	var prefixes []string
+	seen := make(map[string]bool)
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			return prefixes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error discovering prefixes: %v", err)
+		}
+
+		if len(obj.Name) == 0 {
+			// Resumed listings may return a prefix again
+			if !seen[obj.Prefix] {
+				seen[obj.Prefix] = true
+				prefixes = append(prefixes, obj.Prefix)
+			}
+			continue
+		}
+
+		if err := s.wait(ctx); err != nil {
+			return nil, err
+		}
+		if err := visit(obj); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// saveBucketCheckpoint records whether the partitions of the bucket all completed
+// It lists from the start, so sequential seeding resuming from it lists the whole bucket
+func (s *SeedService) saveBucketCheckpoint(ctx context.Context, completed bool) error {
+	if s.checkpointRepo == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	checkpoint := &model.SeedCheckpoint{
+		Bucket:    s.bucketId,
+		Objects:   s.progress.Objects,
+		Completed: completed,
+		Updated:   time.Now(),
+	}
+	s.mu.Unlock()
+
+	if err := s.checkpointRepo.Save(ctx, checkpoint); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package seeder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"google.golang.org/api/googleapi"
+)
+
+// Provisioner looks buckets registered at runtime up in GCS and provisions the notifications indexing them
+type Provisioner struct {
+	client *storage.Client
+	// topicProject and topic are the Pub/Sub topic notifications are sent to, empty if not provisioned
+	topicProject string
+	topic        string
+}
+
+// NewProvisioner looks buckets up with client, provisioning notifications to topic, as
+// projects/PROJECT/topics/TOPIC, unless empty
+func NewProvisioner(client *storage.Client, topic string) (*Provisioner, error) {
+	p := &Provisioner{client: client}
+	if len(topic) > 0 {
+		parts := strings.Split(topic, "/")
+		if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" || len(parts[1]) == 0 || len(parts[3]) == 0 {
+			return nil, fmt.Errorf("invalid topic %q, please use projects/PROJECT/topics/TOPIC", topic)
+		}
+		p.topicProject, p.topic = parts[1], parts[3]
+	}
+	return p, nil
+}
+
+// Locate returns bucket with its location, which costs are priced at, or repo.ErrNotFound if it doesn't exist
+func (p *Provisioner) Locate(ctx context.Context, bucket string) (*model.Bucket, error) {
+	attrs, err := p.client.Bucket(bucket).Attrs(ctx)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		return nil, repo.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.Bucket{Name: bucket, Location: attrs.Location, LocationType: attrs.LocationType}, nil
+}
+
+// Notify provisions a notification of every event of bucket to the topic, in the JSON_API_V1 payload format,
+// unless one exists, returning its ID
+func (p *Provisioner) Notify(ctx context.Context, bucket string) (string, error) {
+	if len(p.topic) == 0 {
+		return "", errors.New("no topic to provision notifications to")
+	}
+
+	b := p.client.Bucket(bucket)
+	notifications, err := b.Notifications(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error listing notifications: %w", err)
+	}
+	for _, n := range notifications {
+		// Notifications filtering events would leave the index drifting from the bucket
+		if p.targets(n) && n.PayloadFormat == storage.JSONPayload && len(n.EventTypes) == 0 && len(n.ObjectNamePrefix) == 0 {
+			return n.ID, nil
+		}
+	}
+
+	n, err := b.AddNotification(ctx, &storage.Notification{
+		TopicProjectID: p.topicProject,
+		TopicID:        p.topic,
+		PayloadFormat:  storage.JSONPayload,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating notification: %w", err)
+	}
+	log.Printf("Created notification %s of bucket %s to projects/%s/topics/%s", n.ID, bucket, p.topicProject, p.topic)
+	return n.ID, nil
+}
+
+// Unnotify deletes the notifications of bucket to the topic, if any, so a deregistered bucket isn't indexed again
+// Buckets which don't exist anymore have no notifications left
+func (p *Provisioner) Unnotify(ctx context.Context, bucket string) error {
+	if len(p.topic) == 0 {
+		return nil
+	}
+
+	b := p.client.Bucket(bucket)
+	notifications, err := b.Notifications(ctx)
+	var apiErr *googleapi.Error
+	if errors.Is(err, storage.ErrBucketNotExist) || (errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error listing notifications: %w", err)
+	}
+	for _, n := range notifications {
+		if !p.targets(n) {
+			continue
+		}
+		if err := b.DeleteNotification(ctx, n.ID); err != nil {
+			return fmt.Errorf("error deleting notification %s: %w", n.ID, err)
+		}
+		log.Printf("Deleted notification %s of bucket %s", n.ID, bucket)
+	}
+	return nil
+}
+
+// targets returns whether n notifies the topic
+func (p *Provisioner) targets(n *storage.Notification) bool {
+	return n.TopicProjectID == p.topicProject && n.TopicID == p.topic
+}
@@ -0,0 +1,97 @@
+package seeder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"google.golang.org/api/option"
+)
+
+func TestProvisioner(t *testing.T) {
+	notification := func(id, topic string, events ...string) map[string]any {
+		return map[string]any{"id": id, "topic": "//pubsub.googleapis.com/projects/p/topics/" + topic, "payload_format": "JSON_API_V1", "event_types": events}
+	}
+	notifications := map[string][]any{
+		"mock":     {notification("1", "events")},
+		"filtered": {notification("2", "events", "OBJECT_FINALIZE"), notification("3", "other")},
+	}
+
+	var mu sync.Mutex
+	var created, deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /storage/v1/b/mock":
+			json.NewEncoder(w).Encode(map[string]any{"name": "mock", "location": "US", "locationType": "multi-region"})
+		case "GET /storage/v1/b/mock/notificationConfigs":
+			json.NewEncoder(w).Encode(map[string]any{"items": notifications["mock"]})
+		case "GET /storage/v1/b/filtered/notificationConfigs":
+			json.NewEncoder(w).Encode(map[string]any{"items": notifications["filtered"]})
+		case "POST /storage/v1/b/filtered/notificationConfigs":
+			created = append(created, "filtered")
+			json.NewEncoder(w).Encode(notification("4", "events"))
+		case "DELETE /storage/v1/b/filtered/notificationConfigs/2":
+			deleted = append(deleted, "2")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": http.StatusNotFound, "message": "Not Found"}})
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := NewProvisioner(client, "events"); err == nil {
+		t.Error("Expected an error for a topic without its project")
+	}
+	p, err := NewProvisioner(client, "projects/p/topics/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, err := p.Locate(ctx, "mock")
+	if err != nil || bucket.Location != "US" || bucket.LocationType != "multi-region" {
+		t.Errorf("Location mismatch: got %+v, %v", bucket, err)
+	}
+	if _, err := p.Locate(ctx, "missing"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("Error mismatch: got %v, want %v", err, repo.ErrNotFound)
+	}
+
+	// Notifications to the topic of every event are kept, others don't count
+	if id, err := p.Notify(ctx, "mock"); err != nil || id != "1" {
+		t.Errorf("Existing notification mismatch: got %s, %v", id, err)
+	}
+	if id, err := p.Notify(ctx, "filtered"); err != nil || id != "4" {
+		t.Errorf("Created notification mismatch: got %s, %v", id, err)
+	}
+	if !slices.Equal(created, []string{"filtered"}) {
+		t.Errorf("Created notifications mismatch: got %v", created)
+	}
+
+	// Only the notifications to the topic are deleted, buckets which don't exist having none
+	if err := p.Unnotify(ctx, "filtered"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unnotify(ctx, "missing"); err != nil {
+		t.Errorf("Unexpected error deleting the notifications of a missing bucket: %v", err)
+	}
+	if !slices.Equal(deleted, []string{"2"}) {
+		t.Errorf("Deleted notifications mismatch: got %v", deleted)
+	}
+}
@@ -2,28 +2,63 @@ package seeder
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/breaker"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/iterator"
 )
 
 type SeedService struct {
 	client        *storage.Client
 	bucketId      string
+	bucketRepo    repo.BucketRepository
 	directoryRepo repo.DirectoryRepository
 	metadataRepo  repo.MetadataRepository
+	gcsBreaker    *breaker.Breaker
+	userProject   string
+	aclRepo       repo.ACLRepository
+	// noncurrentRepo records noncurrent generations, listed only when set and the bucket is versioned
+	noncurrentRepo repo.NoncurrentRepository
+
+	checkpointRepo   repo.CheckpointRepository
+	estimatedObjects int64
+	workers          int
+	limiter          *rate.Limiter
+	delimiter        string
+	// excludePrefixes are skipped, objects of aggregateOnlyPrefixes only counted in their directories
+	excludePrefixes       []string
+	aggregateOnlyPrefixes []string
+	enricher              *Enricher
+
+	mu       sync.Mutex
+	progress model.SeedProgress
+	// resumedObjects are the objects indexed before resuming, excluded from the indexing rate
+	resumedObjects int64
 }
 
-func NewSeedService(client *storage.Client, bucketId string, directoryRepo repo.DirectoryRepository, metadataRepo repo.MetadataRepository) *SeedService {
+// checkpointEvery is the number of objects indexed between two checkpoints
+const checkpointEvery = 1000
+
+func NewSeedService(client *storage.Client, bucketId string, bucketRepo repo.BucketRepository, directoryRepo repo.DirectoryRepository, metadataRepo repo.MetadataRepository) *SeedService {
+	cfg := breaker.DefaultConfig
+	cfg.Retryable = storage.ShouldRetry
+
 	return &SeedService{
 		client:        client,
 		bucketId:      bucketId,
+		bucketRepo:    bucketRepo,
 		directoryRepo: directoryRepo,
 		metadataRepo:  metadataRepo,
+		gcsBreaker:    breaker.New("gcs", cfg),
 	}
 }
 
@@ -35,6 +70,30 @@ func newMetadata(obj *storage.ObjectAttrs) *model.Metadata {
 		StorageClass: obj.StorageClass,
 		Created:      obj.Created,
 		Updated:      obj.Updated,
+		CustomTime:   customTime(obj),
+
+		Generation:     obj.Generation,
+		Metageneration: obj.Metageneration,
+	}
+}
+
+// customTime returns the custom time of an object, or nil if none is set
+func customTime(obj *storage.ObjectAttrs) *time.Time {
+	if obj.CustomTime.IsZero() {
+		return nil
+	}
+	t := obj.CustomTime.UTC()
+	return &t
+}
+
+func newNoncurrentObject(obj *storage.ObjectAttrs) *model.NoncurrentObject {
+	return &model.NoncurrentObject{
+		Bucket:       obj.Bucket,
+		Name:         obj.Name,
+		Generation:   obj.Generation,
+		Size:         obj.Size,
+		StorageClass: obj.StorageClass,
+		Deleted:      obj.Deleted,
 	}
 }
 
@@ -42,41 +101,532 @@ type objectIterator interface {
 	Next() (*storage.ObjectAttrs, error)
 }
 
+// SetUserProject bills GCS requests to project, which is required to list requester pays buckets
+func (s *SeedService) SetUserProject(project string) {
+	s.userProject = project
+}
+
+// SetACLRepository enables recording the objects that grant access through object ACLs
+// Listings then fetch full object metadata, which is slower, so reporting is disabled by default
+func (s *SeedService) SetACLRepository(aclRepo repo.ACLRepository) {
+	s.aclRepo = aclRepo
+}
+
+// SetNoncurrentRepository enables accounting the noncurrent generations of versioned buckets apart from live objects
+// Every generation of every object is then listed, which is slower on buckets keeping many generations
+func (s *SeedService) SetNoncurrentRepository(noncurrentRepo repo.NoncurrentRepository) {
+	s.noncurrentRepo = noncurrentRepo
+}
+
+// SetCheckpointRepository enables resuming an interrupted seeding from its last checkpoint
+func (s *SeedService) SetCheckpointRepository(checkpointRepo repo.CheckpointRepository) {
+	s.checkpointRepo = checkpointRepo
+}
+
+// SetEstimatedObjects sets the expected object count of the bucket, to estimate when seeding completes
+func (s *SeedService) SetEstimatedObjects(n int64) {
+	s.estimatedObjects = n
+}
+
+// SetParallelism lists up to workers top level prefixes of the bucket concurrently,
+// indexing at most objectsPerSecond objects per second across all of them, 0 for no limit
+func (s *SeedService) SetParallelism(workers int, objectsPerSecond float64) {
+	s.workers = workers
+	s.limiter = newLimiter(objectsPerSecond)
+}
+
+// SetEnricher samples inserted objects for content type detection
+func (s *SeedService) SetEnricher(enricher *Enricher) {
+	s.enricher = enricher
+}
+
+// SetConfig applies the overrides of a bucket on top of the settings of the service
+func (s *SeedService) SetConfig(cfg *model.BucketConfig) {
+	if cfg.Workers > 0 {
+		s.workers = cfg.Workers
+	}
+	if cfg.MaxObjectsPerSecond > 0 {
+		s.limiter = newLimiter(cfg.MaxObjectsPerSecond)
+	}
+	s.delimiter = cfg.Delimiter
+	s.excludePrefixes = cfg.ExcludePrefixes
+	s.aggregateOnlyPrefixes = cfg.AggregateOnlyPrefixes
+}
+
+// newLimiter allows objectsPerSecond objects per second, or returns nil for no limit
+func newLimiter(objectsPerSecond float64) *rate.Limiter {
+	if objectsPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(objectsPerSecond), max(1, int(objectsPerSecond)))
+}
+
+// Progress reports the objects indexed so far and, if the object count was estimated, when seeding should complete
+func (s *SeedService) Progress() *model.SeedProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress := s.progress
+	progress.EstimatedObjects = s.estimatedObjects
+
+	elapsed := time.Since(progress.Started).Seconds()
+	if progress.Started.IsZero() || elapsed <= 0 {
+		return &progress
+	}
+	progress.ObjectsPerSecond = float64(progress.Objects-s.resumedObjects) / elapsed
+
+	if remaining := s.estimatedObjects - progress.Objects; !progress.Completed && remaining > 0 && progress.ObjectsPerSecond > 0 {
+		eta := time.Now().Add(time.Duration(float64(remaining) / progress.ObjectsPerSecond * float64(time.Second)))
+		progress.ETA = &eta
+	}
+	return &progress
+}
+
+// partition is a range of the bucket listed on its own, checkpointed under its prefix
+// The partition with an empty prefix is the whole bucket
+type partition struct {
+	prefix         string
+	lastObject     string
+	lastGeneration int64
+	objects        int64
+	completed      bool
+}
+
+// rootPartition checkpoints the root level objects visited while discovering the prefixes to list in parallel
+// Object names never contain line feeds, so it is no prefix of the bucket
+const rootPartition = "\n"
+
+// listed returns whether obj was listed before the partition was interrupted,
+// generations of a name being listed in order
+func (p *partition) listed(obj *storage.ObjectAttrs) bool {
+	if p.completed {
+		return true
+	}
+	name := objectKey(obj)
+	return name < p.lastObject || (name == p.lastObject && obj.Generation <= p.lastGeneration)
+}
+
+// startProgress resets progress, and the checkpoints of the bucket unless its previous seeding was interrupted
+// It returns whether partitions should resume from their checkpoints
+func (s *SeedService) startProgress(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	s.progress = model.SeedProgress{Bucket: s.bucketId, Started: time.Now()}
+	s.resumedObjects = 0
+	s.mu.Unlock()
+
+	if s.checkpointRepo == nil {
+		return false, nil
+	}
+
+	checkpoint, err := s.checkpointRepo.Get(ctx, s.bucketId, "")
+	if err == nil && !checkpoint.Completed {
+		return true, nil
+	}
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return false, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	if err == nil {
+		log.Println("Previous seeding completed, listing every object again")
+	}
+	if err := s.checkpointRepo.Reset(ctx, s.bucketId); err != nil {
+		return false, fmt.Errorf("error resetting checkpoints: %w", err)
+	}
+	return false, nil
+}
+
+// resumePartition restores a partition from its checkpoint, counting the objects indexed before
+// It returns whether the partition was already completed
+func (s *SeedService) resumePartition(ctx context.Context, part *partition) (bool, error) {
+	checkpoint, err := s.checkpointRepo.Get(ctx, s.bucketId, part.prefix)
+	if errors.Is(err, repo.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress.Objects += checkpoint.Objects
+	s.resumedObjects += checkpoint.Objects
+
+	part.lastObject = checkpoint.LastObject
+	part.lastGeneration = checkpoint.LastGeneration
+	part.objects = checkpoint.Objects
+	part.completed = checkpoint.Completed
+
+	if checkpoint.Completed {
+		if part.prefix != rootPartition {
+			s.progress.PartitionsCompleted++
+		}
+		return true, nil
+	}
+
+	log.Printf("Resuming seeding of %q after %q, %d objects already indexed\n", part.prefix, checkpoint.LastObject, checkpoint.Objects)
+
+	if len(part.prefix) == 0 {
+		s.progress.ResumedFrom = checkpoint.LastObject
+		s.progress.LastObject = checkpoint.LastObject
+	}
+	return false, nil
+}
+
+// recordProgress counts an indexed object of part, nil for objects outside of any partition, or its completion
+// if obj is nil
+// A checkpoint of part is saved every checkpointEvery objects and once its listing completed
+func (s *SeedService) recordProgress(ctx context.Context, part *partition, obj *storage.ObjectAttrs) error {
+	completed := obj == nil
+
+	s.mu.Lock()
+	if !completed {
+		s.progress.Objects++
+		s.progress.LastObject = obj.Name
+	}
+
+	if part == nil {
+		s.mu.Unlock()
+		return nil
+	}
+
+	if !completed {
+		part.objects++
+		part.lastObject = objectKey(obj)
+		part.lastGeneration = obj.Generation
+	} else if len(part.prefix) == 0 {
+		s.progress.Completed = true
+	} else if part.prefix != rootPartition {
+		s.progress.PartitionsCompleted++
+	}
+	part.completed = completed
+
+	checkpoint := s.checkpoint(part)
+	s.mu.Unlock()
+
+	if s.checkpointRepo == nil || (!completed && checkpoint.Objects%checkpointEvery != 0) {
+		return nil
+	}
+
+	if err := s.checkpointRepo.Save(ctx, checkpoint); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// checkpoint returns the checkpoint of part, s.mu being held
+func (s *SeedService) checkpoint(part *partition) *model.SeedCheckpoint {
+	return &model.SeedCheckpoint{
+		Bucket:         s.bucketId,
+		Prefix:         part.prefix,
+		LastObject:     part.lastObject,
+		LastGeneration: part.lastGeneration,
+		Objects:        part.objects,
+		Completed:      part.completed,
+		Updated:        time.Now(),
+	}
+}
+
+// nextCheckpoint returns the checkpoint of part once obj is counted, or nil without checkpoints or partition
+func (s *SeedService) nextCheckpoint(part *partition, obj *storage.ObjectAttrs) *model.SeedCheckpoint {
+	if s.checkpointRepo == nil || part == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint := s.checkpoint(part)
+	checkpoint.LastObject = objectKey(obj)
+	checkpoint.LastGeneration = obj.Generation
+	checkpoint.Objects++
+	return checkpoint
+}
+
+// wait blocks until the rate limit allows indexing another object
+func (s *SeedService) wait(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
+	}
+	return s.limiter.Wait(ctx)
+}
+
+// aclEntities returns the entities an object ACL grants access to outside of project roles
+func aclEntities(acl []storage.ACLRule) []string {
+	var entities []string
+	for _, rule := range acl {
+		if !strings.HasPrefix(string(rule.Entity), "project-") {
+			entities = append(entities, string(rule.Entity))
+		}
+	}
+	return entities
+}
+
 // Seed initiates the seeding process by traversing bucket and inserting into db
 func (s *SeedService) Start(ctx context.Context) error {
-	b := s.client.Bucket(s.bucketId)
-	if _, err := b.Attrs(ctx); err != nil {
+	list, err := s.prepare(ctx)
+	if err != nil {
 		return err
 	}
 
-	it := b.Objects(ctx, nil)
-	if err := s.insertFromIterator(it); err != nil {
+	resume, err := s.startProgress(ctx)
+	if err != nil {
 		return err
 	}
-	return nil
+
+	if s.workers > 1 {
+		return s.seedParallel(ctx, list, resume)
+	}
+	return s.seedPartition(ctx, &partition{}, list, resume)
+}
+
+// SeedPrefix indexes the objects under prefix, resuming from its checkpoint, the whole bucket if prefix is empty
+// Once completed, its checkpoint records the prefix as listed
+func (s *SeedService) SeedPrefix(ctx context.Context, prefix string) error {
+	list, err := s.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	return s.seedPartition(ctx, &partition{prefix: prefix}, list, true)
 }
 
-// insertFromIterator traverses iterator while inserting all containing items into db
-func (s *SeedService) insertFromIterator(it objectIterator) error {
+// prepare registers the bucket and returns a function listing its objects
+func (s *SeedService) prepare(ctx context.Context) (func(q storage.Query) objectIterator, error) {
+	b := s.client.Bucket(s.bucketId)
+	if len(s.userProject) > 0 {
+		b = b.UserProject(s.userProject)
+	}
+
+	var attrs *storage.BucketAttrs
+	if err := s.gcsBreaker.Do(ctx, func() error {
+		var err error
+		attrs, err = b.Attrs(ctx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// Register the bucket location, which costs are priced at
+	if err := s.bucketRepo.Upsert(ctx, model.Bucket{
+		Name:         s.bucketId,
+		Location:     attrs.Location,
+		LocationType: attrs.LocationType,
+	}); err != nil {
+		return nil, fmt.Errorf("error registering bucket: %w", err)
+	}
+
+	// Object ACLs are ignored when uniform bucket-level access is enabled
+	projection := storage.ProjectionDefault
+	if s.aclRepo != nil {
+		if attrs.UniformBucketLevelAccess.Enabled {
+			log.Println("Uniform bucket-level access is enabled, skipping object ACL report")
+			s.aclRepo = nil
+		} else {
+			projection = storage.ProjectionFull
+		}
+	}
+
+	// Listing every generation reports the noncurrent ones with their deletion time
+	versions := s.noncurrentRepo != nil && attrs.VersioningEnabled
+
+	return func(q storage.Query) objectIterator {
+		q.Projection = projection
+		q.Versions = versions
+		return b.Objects(ctx, &q)
+	}, nil
+}
+
+// seedPartition lists and indexes the objects of a partition, resuming from its checkpoint if resume is set
+func (s *SeedService) seedPartition(ctx context.Context, part *partition, list func(q storage.Query) objectIterator, resume bool) error {
+	if resume && s.checkpointRepo != nil {
+		completed, err := s.resumePartition(ctx, part)
+		if err != nil || completed {
+			return err
+		}
+	}
+
+	it := newResumingIterator(ctx, s.gcsBreaker, part.lastObject, part.lastGeneration, func(startOffset string) objectIterator {
+		return list(storage.Query{Prefix: part.prefix, StartOffset: startOffset})
+	})
+	return s.insertFromIterator(ctx, it, part)
+}
+
+// insertFromIterator traverses iterator while inserting all containing items of part into db
+func (s *SeedService) insertFromIterator(ctx context.Context, it objectIterator, part *partition) error {
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.wait(ctx); err != nil {
+			return err
+		}
+
 		obj, err := it.Next()
 		if err != nil {
 			if err == iterator.Done {
-				break
+				return s.recordProgress(ctx, part, nil)
 			}
 
 			return fmt.Errorf("error retrieving iterator object: %v", err)
 		}
 
-		metadata := newMetadata(obj)
+		s.insertObject(ctx, obj, part)
+
+		if err := s.recordProgress(ctx, part, obj); err != nil {
+			return err
+		}
+	}
+}
+
+// insertObject indexes an object of part, nil outside of any partition, and adds it to its parent directories
+// Objects of excluded prefixes are skipped, those of aggregate-only prefixes only added to their directories,
+// along with the checkpoint of part counting them so they are not added again after resuming
+// Errors are logged, so a single malformed object does not stop seeding
+func (s *SeedService) insertObject(ctx context.Context, obj *storage.ObjectAttrs, part *partition) {
+	if hasPrefix(obj.Name, s.excludePrefixes) {
+		return
+	}
+	if !obj.Deleted.IsZero() {
+		s.insertNoncurrent(ctx, obj, part)
+		return
+	}
+	metadata := newMetadata(obj)
+
+	if hasPrefix(obj.Name, s.aggregateOnlyPrefixes) {
+		if err := s.directoryRepo.AggregateObject(ctx, metadata, s.nextCheckpoint(part, obj)); err != nil {
+			log.Printf("Error upserting directories: %v", err)
+		}
+		return
+	}
+
+	err := s.directoryRepo.InsertObject(ctx, metadata)
+	if errors.Is(err, repo.ErrConflict) {
+		return // already seeded, along with its directories
+	}
+	if err != nil {
+		log.Printf("Error inserting metadata: %v", err)
+		return
+	}
+	if s.enricher != nil {
+		s.enricher.Observe(metadata)
+	}
 
-		if err := s.metadataRepo.Insert(metadata); err != nil {
-			log.Printf("Error inserting metadata: %v", err)
+	if entities := aclEntities(obj.ACL); s.aclRepo != nil && len(entities) > 0 {
+		if err := s.aclRepo.Upsert(ctx, metadata.Bucket, metadata.Name, entities); err != nil {
+			log.Printf("Error recording object ACL: %v", err)
 		}
+	}
+}
+
+// insertNoncurrent records a noncurrent generation and adds it to the noncurrent totals of its parent directories
+// Directory markers hold no data, their noncurrent generations are skipped
+func (s *SeedService) insertNoncurrent(ctx context.Context, obj *storage.ObjectAttrs, part *partition) {
+	if s.noncurrentRepo == nil || strings.HasSuffix(obj.Name, "/") {
+		return
+	}
 
-		if err := s.directoryRepo.UpsertParentDirs(repo.StorageClass(metadata.StorageClass), metadata.Bucket, metadata.Name, metadata.Size, 1); err != nil {
+	// Aggregate-only prefixes keep no rows, as for live objects
+	if hasPrefix(obj.Name, s.aggregateOnlyPrefixes) {
+		if err := s.directoryRepo.AggregateNoncurrent(ctx, newNoncurrentObject(obj), s.nextCheckpoint(part, obj)); err != nil {
 			log.Printf("Error upserting directories: %v", err)
 		}
+		return
 	}
-	return nil
+
+	err := s.directoryRepo.InsertNoncurrent(ctx, newNoncurrentObject(obj))
+	if errors.Is(err, repo.ErrConflict) {
+		return // already seeded, along with its directories
+	}
+	if err != nil {
+		log.Printf("Error inserting noncurrent generation: %v", err)
+	}
+}
+
+// resumingIterator lists objects through a circuit breaker
+// A listing iterator keeps failing after its first error, so failed listings are resumed
+// from the last object returned instead of being retried
+type resumingIterator struct {
+	ctx     context.Context
+	breaker *breaker.Breaker
+	list    func(startOffset string) objectIterator
+	it      objectIterator
+	// last and lastGeneration are the key and generation of the last object returned
+	last           string
+	lastGeneration int64
+	// resumed is set until the listing resumed from last moves past the objects returned before
+	resumed bool
+}
+
+// newResumingIterator lists the objects sorting after the generation start of object start,
+// or every object if start is empty
+func newResumingIterator(ctx context.Context, b *breaker.Breaker, start string, startGeneration int64, list func(startOffset string) objectIterator) *resumingIterator {
+	return &resumingIterator{
+		ctx:            ctx,
+		breaker:        b,
+		list:           list,
+		it:             list(start),
+		last:           start,
+		lastGeneration: startGeneration,
+		resumed:        len(start) > 0,
+	}
+}
+
+func (r *resumingIterator) Next() (*storage.ObjectAttrs, error) {
+	var obj *storage.ObjectAttrs
+	done := false
+	err := r.breaker.Do(r.ctx, func() error {
+		var err error
+		for {
+			obj, err = r.it.Next()
+			if err != nil {
+				break
+			}
+			// StartOffset is inclusive, skip the generations of the last object returned before resuming,
+			// which are listed in order
+			if r.resumed && objectKey(obj) == r.last && obj.Generation <= r.lastGeneration {
+				continue
+			}
+			r.resumed = false
+			break
+		}
+
+		// The end of the listing is no failure of GCS
+		if err == iterator.Done {
+			done = true
+			return nil
+		}
+		if err != nil {
+			r.it = r.list(r.last)
+			r.resumed = len(r.last) > 0
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		return nil, iterator.Done
+	}
+
+	r.last = objectKey(obj)
+	r.lastGeneration = obj.Generation
+	return obj, nil
+}
+
+// hasPrefix returns whether name starts with any of prefixes
+func hasPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// objectKey is the name of an object, or the prefix of a prefix listed with a delimiter
+func objectKey(obj *storage.ObjectAttrs) string {
+	if len(obj.Name) == 0 {
+		return obj.Prefix
+	}
+	return obj.Name
 }
@@ -1,12 +1,18 @@
 package seeder
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/breaker"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
 	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
 	"google.golang.org/api/iterator"
 )
 
@@ -60,30 +66,427 @@ func TestReadFromIterator(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			mockMetadataRepo := &mockMetadataRepository{}
 			mockDirRepo := &mockDirectoryRepository{}
 
 			s := &SeedService{
-				metadataRepo:  mockMetadataRepo,
 				directoryRepo: mockDirRepo,
 			}
 
-			err := s.insertFromIterator(tc.it)
+			err := s.insertFromIterator(context.Background(), tc.it, &partition{})
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if mockMetadataRepo.calls != len(tc.it.items) {
-				t.Errorf("Metadata Insert calls mismatch: got %d, want %d", mockMetadataRepo.calls, len(tc.it.items))
+			if mockDirRepo.inserts != len(tc.it.items) {
+				t.Errorf("Insert calls mismatch: got %d, want %d", mockDirRepo.inserts, len(tc.it.items))
 			}
+		})
+	}
+}
 
-			if mockDirRepo.calls != len(tc.it.items) {
-				t.Errorf("Directory Upsert calls mismatch: got %d, want %d", mockDirRepo.calls, len(tc.it.items))
+func TestACLEntities(t *testing.T) {
+	testCases := []struct {
+		name string
+		acl  []storage.ACLRule
+		want []string
+	}{
+		{"No ACL", nil, nil},
+		{
+			"Project roles only",
+			[]storage.ACLRule{{Entity: "project-owners-123", Role: storage.RoleOwner}, {Entity: "project-viewers-123", Role: storage.RoleReader}},
+			nil,
+		},
+		{
+			"Public and user grants",
+			[]storage.ACLRule{{Entity: "project-owners-123", Role: storage.RoleOwner}, {Entity: storage.AllUsers, Role: storage.RoleReader}, {Entity: "user-mock@example.com", Role: storage.RoleReader}},
+			[]string{"allUsers", "user-mock@example.com"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := aclEntities(tc.acl)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("Entities mismatch: got %v, want %v", got, tc.want)
 			}
 		})
 	}
 }
 
+func TestResumingIterator(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	errMock := errors.New("mock")
+
+	// Every listing fails after returning two objects from its start offset
+	var listings []string
+	list := func(startOffset string) objectIterator {
+		listings = append(listings, startOffset)
+
+		it := &failingObjectIterator{failAfter: 2, err: errMock}
+		for _, name := range names {
+			if name >= startOffset {
+				it.items = append(it.items, &storage.ObjectAttrs{Name: name})
+			}
+		}
+		return it
+	}
+
+	b := breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	it := newResumingIterator(context.Background(), b, "", 0, list)
+
+	var got []string
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, obj.Name)
+	}
+
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Errorf("Listed objects mismatch: got %v, want %v", got, names)
+	}
+
+	if want := []string{"", "b", "c", "d"}; strings.Join(listings, ",") != strings.Join(want, ",") {
+		t.Errorf("Listing offsets mismatch: got %v, want %v", listings, want)
+	}
+}
+
+func TestResumingIteratorVersions(t *testing.T) {
+	objects := []*storage.ObjectAttrs{{Name: "a", Generation: 1}, {Name: "a", Generation: 2}, {Name: "a", Generation: 3}, {Name: "b", Generation: 1}}
+
+	// The first listing fails after the second generation of a, resumed listings start at a again
+	var listings int
+	list := func(startOffset string) objectIterator {
+		listings++
+		it := &failingObjectIterator{failAfter: len(objects), err: errors.New("mock")}
+		if listings == 1 {
+			it.failAfter = 2
+		}
+		for _, obj := range objects {
+			if obj.Name >= startOffset {
+				it.items = append(it.items, obj)
+			}
+		}
+		return it
+	}
+
+	b := breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	it := newResumingIterator(context.Background(), b, "", 0, list)
+
+	var got []string
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s#%d", obj.Name, obj.Generation))
+	}
+
+	if want := "a#1,a#2,a#3,b#1"; strings.Join(got, ",") != want {
+		t.Errorf("Listed generations mismatch: got %v, want %v", got, want)
+	}
+
+	// The end of the listing is no failure, listing many prefixes keeps the breaker closed
+	for range 20 {
+		it := newResumingIterator(context.Background(), b, "", 0, func(string) objectIterator { return &testObjectIterator{} })
+		if _, err := it.Next(); err != iterator.Done {
+			t.Fatalf("expected the listing to end, got %v", err)
+		}
+	}
+	if b.State() != breaker.StateClosed {
+		t.Errorf("Breaker state mismatch: got %v", b.State())
+	}
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	testCases := []struct {
+		name        string
+		checkpoint  *model.SeedCheckpoint
+		wantStart   string
+		wantInserts int
+	}{
+		{"No checkpoint", nil, "", 4},
+		{"Interrupted seeding", &model.SeedCheckpoint{Bucket: "mock", LastObject: "b", Objects: 2}, "b", 2},
+		{"Completed seeding", &model.SeedCheckpoint{Bucket: "mock", LastObject: "d", Objects: 4, Completed: true}, "", 4},
+	}
+
+	names := []string{"a", "b", "c", "d"}
+	list := func(q storage.Query) objectIterator {
+		it := &testObjectIterator{}
+		for _, name := range names {
+			if name >= q.StartOffset {
+				it.items = append(it.items, &storage.ObjectAttrs{Bucket: "mock", Name: name})
+			}
+		}
+		return it
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := repotest.NewDatabase(t)
+
+			checkpointRepo := repo.NewCheckpointRepository(db)
+			if tc.checkpoint != nil {
+				if err := checkpointRepo.Save(context.Background(), tc.checkpoint); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mockDirRepo := &mockDirectoryRepository{}
+			s := &SeedService{
+				bucketId:      "mock",
+				directoryRepo: mockDirRepo,
+				gcsBreaker:    breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+			}
+			s.SetCheckpointRepository(checkpointRepo)
+			s.SetEstimatedObjects(int64(len(names)))
+
+			resume, err := s.startProgress(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.seedPartition(context.Background(), &partition{}, list, resume); err != nil {
+				t.Fatal(err)
+			}
+
+			if mockDirRepo.inserts != tc.wantInserts {
+				t.Errorf("Insert calls mismatch: got %d, want %d", mockDirRepo.inserts, tc.wantInserts)
+			}
+
+			got, err := checkpointRepo.Get(context.Background(), "mock", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Completed || got.LastObject != "d" || got.Objects != int64(len(names)) {
+				t.Errorf("Checkpoint mismatch: got %+v", got)
+			}
+
+			progress := s.Progress()
+			if !progress.Completed || progress.Objects != int64(len(names)) || progress.ResumedFrom != tc.wantStart || progress.ETA != nil {
+				t.Errorf("Progress mismatch: got %+v", progress)
+			}
+		})
+	}
+}
+
+func TestSeedParallel(t *testing.T) {
+	names := []string{"a/1", "a/2", "b/1", "b/c/1", "c/1", "root"}
+
+	var objects []*storage.ObjectAttrs
+	for _, name := range names {
+		objects = append(objects, &storage.ObjectAttrs{Bucket: "mock", Name: name, StorageClass: "STANDARD"})
+	}
+	list := listObjects(objects)
+
+	db := repotest.NewDatabase(t)
+
+	checkpointRepo := repo.NewCheckpointRepository(db)
+	s := &SeedService{
+		bucketId:      "mock",
+		metadataRepo:  repo.NewMetadataRepository(db),
+		directoryRepo: repo.NewDirectoryRepository(db),
+		gcsBreaker:    breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+	}
+	s.SetCheckpointRepository(checkpointRepo)
+	s.SetParallelism(2, 0)
+
+	resume, err := s.startProgress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.seedParallel(context.Background(), list, resume); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexed int
+	if err := db.Get(&indexed, `SELECT COUNT(*) FROM metadata WHERE bucket = 'mock';`); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != len(names) {
+		t.Errorf("Indexed objects mismatch: got %d, want %d", indexed, len(names))
+	}
+
+	for _, prefix := range []string{"", "a/", "b/", "c/"} {
+		checkpoint, err := checkpointRepo.Get(context.Background(), "mock", prefix)
+		if err != nil {
+			t.Fatalf("Checkpoint of %q: %v", prefix, err)
+		}
+		if !checkpoint.Completed {
+			t.Errorf("Checkpoint of %q not completed: %+v", prefix, checkpoint)
+		}
+	}
+
+	progress := s.Progress()
+	if !progress.Completed || progress.Objects != int64(len(names)) || progress.Partitions != 3 || progress.PartitionsCompleted != 3 {
+		t.Errorf("Progress mismatch: got %+v", progress)
+	}
+}
+
+func TestSeedConfig(t *testing.T) {
+	db := repotest.NewDatabase(t)
+
+	var objects []*storage.ObjectAttrs
+	for _, name := range []string{"data/1", "logs/1", "logs/2", "tmp/1"} {
+		objects = append(objects, &storage.ObjectAttrs{Bucket: "mock", Name: name, Size: 1, StorageClass: "STANDARD"})
+	}
+
+	s := &SeedService{
+		bucketId:      "mock",
+		metadataRepo:  repo.NewMetadataRepository(db),
+		directoryRepo: repo.NewDirectoryRepository(db),
+		gcsBreaker:    breaker.New("test", breaker.Config{FailureThreshold: 10, MaxRetries: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+	}
+	s.SetConfig(&model.BucketConfig{ExcludePrefixes: []string{"tmp/"}, AggregateOnlyPrefixes: []string{"logs/"}})
+
+	if err := s.seedPartition(context.Background(), &partition{}, listObjects(objects), false); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexed []string
+	if err := db.Select(&indexed, `SELECT name FROM metadata ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(indexed, ",") != "data/1" {
+		t.Errorf("Indexed objects mismatch: got %v, want [data/1]", indexed)
+	}
+
+	var dirs []string
+	if err := db.Select(&dirs, `SELECT name || ':' || count FROM directory WHERE parent = '/' AND name != '/' ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+	if want := "data/:1,logs/:2"; strings.Join(dirs, ",") != want {
+		t.Errorf("Directories mismatch: got %v, want %v", dirs, want)
+	}
+}
+
+func TestResumeAggregateOnly(t *testing.T) {
+	db := repotest.NewDatabase(t)
+	ctx := context.Background()
+
+	var objects []*storage.ObjectAttrs
+	for _, name := range []string{"data/1", "logs/1", "logs/2", "logs/3", "tmp/1"} {
+		objects = append(objects, &storage.ObjectAttrs{Bucket: "mock", Name: name, Size: 1, StorageClass: "STANDARD"})
+	}
+
+	// The first seeding is interrupted after logs/2, long before its first periodic checkpoint
+	interrupted := func(q storage.Query) objectIterator {
+		it := &failingObjectIterator{failAfter: 3, err: errors.New("mock")}
+		it.items = listObjects(objects)(q).(*testObjectIterator).items
+		return it
+	}
+
+	checkpointRepo := repo.NewCheckpointRepository(db)
+	newService := func() *SeedService {
+		s := &SeedService{
+			bucketId:      "mock",
+			metadataRepo:  repo.NewMetadataRepository(db),
+			directoryRepo: repo.NewDirectoryRepository(db),
+			gcsBreaker:    breaker.New("test", breaker.Config{FailureThreshold: 10, Retryable: func(error) bool { return false }}),
+		}
+		s.SetCheckpointRepository(checkpointRepo)
+		s.SetConfig(&model.BucketConfig{AggregateOnlyPrefixes: []string{"logs/"}})
+		return s
+	}
+
+	s := newService()
+	if _, err := s.startProgress(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.seedPartition(ctx, &partition{}, interrupted, false); err == nil {
+		t.Fatal("expected the first seeding to be interrupted")
+	}
+
+	s = newService()
+	resume, err := s.startProgress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resume {
+		t.Fatal("expected the seeding to resume")
+	}
+	if err := s.seedPartition(ctx, &partition{}, listObjects(objects), resume); err != nil {
+		t.Fatal(err)
+	}
+
+	var dirs []string
+	if err := db.Select(&dirs, `SELECT name || ':' || count FROM directory WHERE parent = '/' AND name != '/' ORDER BY name;`); err != nil {
+		t.Fatal(err)
+	}
+	if want := "data/:1,logs/:3,tmp/:1"; strings.Join(dirs, ",") != want {
+		t.Errorf("Directories mismatch: got %v, want %v", dirs, want)
+	}
+}
+
+func BenchmarkInsertFromIterator(b *testing.B) {
+	db := repo.NewDatabase(":memory:", 1)
+	db.Connect(context.Background())
+	defer db.Close()
+
+	if err := db.Setup(); err != nil {
+		b.Fatal(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		b.Fatal(err)
+	}
+
+	it := &testObjectIterator{items: make([]*storage.ObjectAttrs, b.N)}
+	for i := range it.items {
+		it.items[i] = &storage.ObjectAttrs{
+			Bucket:       "mock",
+			Name:         fmt.Sprintf("mock-%d/mock-%d/file%d", i%10, i%100, i),
+			Size:         1,
+			StorageClass: "STANDARD",
+			Created:      time.Now(),
+			Updated:      time.Now(),
+		}
+	}
+
+	s := &SeedService{
+		metadataRepo:  repo.NewMetadataRepository(db),
+		directoryRepo: repo.NewDirectoryRepository(db),
+	}
+	b.ResetTimer()
+
+	if err := s.insertFromIterator(context.Background(), it, &partition{}); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// listObjects lists sorted objects like GCS, returning a prefix entry per delimited name
+func listObjects(objects []*storage.ObjectAttrs) func(q storage.Query) objectIterator {
+	return func(q storage.Query) objectIterator {
+		it := &testObjectIterator{}
+		seen := make(map[string]bool)
+		for _, obj := range objects {
+			if !strings.HasPrefix(obj.Name, q.Prefix) || obj.Name < q.StartOffset {
+				continue
+			}
+
+			if i := strings.Index(obj.Name[len(q.Prefix):], q.Delimiter); len(q.Delimiter) > 0 && i >= 0 {
+				prefix := obj.Name[:len(q.Prefix)+i+1]
+				if !seen[prefix] {
+					seen[prefix] = true
+					it.items = append(it.items, &storage.ObjectAttrs{Prefix: prefix})
+				}
+				continue
+			}
+			it.items = append(it.items, obj)
+		}
+		return it
+	}
+}
+
 type testObjectIterator struct {
 	items []*storage.ObjectAttrs
 	index int
@@ -99,22 +502,26 @@ func (t *testObjectIterator) Next() (*storage.ObjectAttrs, error) {
 	return obj, nil
 }
 
-type mockMetadataRepository struct {
-	repo.MetadataRepository
-	calls int
+// failingObjectIterator fails permanently after returning failAfter objects
+type failingObjectIterator struct {
+	testObjectIterator
+	failAfter int
+	err       error
 }
 
-func (m *mockMetadataRepository) Insert(metadata *model.Metadata) error {
-	m.calls++
-	return nil
+func (f *failingObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	if f.index >= f.failAfter {
+		return nil, f.err
+	}
+	return f.testObjectIterator.Next()
 }
 
 type mockDirectoryRepository struct {
 	repo.DirectoryRepository
-	calls int
+	inserts int
 }
 
-func (d *mockDirectoryRepository) UpsertParentDirs(storageClass repo.StorageClass, bucket string, objName string, newSize int64, newCount int64) error {
-	d.calls++
+func (d *mockDirectoryRepository) InsertObject(ctx context.Context, metadata *model.Metadata) error {
+	d.inserts++
 	return nil
 }
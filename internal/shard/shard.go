@@ -0,0 +1,159 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/lease"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// lock is the lease a bucket is claimed with
+type lock interface {
+	Acquire(ctx context.Context) (context.Context, error)
+	Release(ctx context.Context) error
+	Holder(ctx context.Context) (string, time.Time, error)
+}
+
+// claim is a bucket held by this instance, lost once ctx is cancelled
+type claim struct {
+	lock lock
+	ctx  context.Context
+}
+
+// Claims splits buckets among the instances of a fleet, each bucket being owned by the single instance holding
+// its lease, which advertises the address queries of the bucket are routed to
+// Instances claim free buckets up to a maximum, taking over the buckets of instances which stopped renewing
+// their leases
+type Claims struct {
+	lease    func(bucket string) lock
+	buckets  []string
+	max      int
+	interval time.Duration
+
+	mu   sync.Mutex
+	held map[string]*claim
+}
+
+// NewGCSClaims returns claims of up to max of buckets, leased for duration in the objects named after the buckets
+// under prefix in location, advertising address to route queries to
+// Instances routing queries only call Owners, with an empty address
+func NewGCSClaims(location *storage.BucketHandle, prefix, address string, buckets []string, max int, duration time.Duration) *Claims {
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return newClaims(func(bucket string) lock {
+		return lease.NewGCSLease(location.Object(prefix+bucket), address, duration)
+	}, buckets, max, duration/3)
+}
+
+func newClaims(lease func(bucket string) lock, buckets []string, max int, interval time.Duration) *Claims {
+	return &Claims{
+		lease:    lease,
+		buckets:  buckets,
+		max:      max,
+		interval: interval,
+		held:     make(map[string]*claim),
+	}
+}
+
+// Run claims free buckets every interval until ctx is cancelled, then releases them for other instances
+func (c *Claims) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.claim(ctx)
+
+		select {
+		case <-ctx.Done():
+			c.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim forgets the buckets whose lease was lost and claims free ones until max are held
+func (c *Claims) claim(ctx context.Context) {
+	c.mu.Lock()
+	for bucket, cl := range c.held {
+		if cl.ctx.Err() != nil {
+			log.Printf("Lost bucket %s: %v", bucket, context.Cause(cl.ctx))
+			delete(c.held, bucket)
+		}
+	}
+	held := len(c.held)
+	c.mu.Unlock()
+
+	for _, bucket := range c.buckets {
+		if held >= c.max || ctx.Err() != nil {
+			return
+		}
+		if c.Owns(bucket) {
+			continue
+		}
+
+		l := c.lease(bucket)
+		leaseCtx, err := l.Acquire(ctx)
+		if errors.Is(err, lease.ErrLeaseHeld) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error claiming bucket %s: %v", bucket, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.held[bucket] = &claim{lock: l, ctx: leaseCtx}
+		c.mu.Unlock()
+		held++
+		log.Printf("Claimed bucket %s", bucket)
+	}
+}
+
+// release frees every bucket held
+func (c *Claims) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for bucket, cl := range c.held {
+		if err := cl.lock.Release(context.Background()); err != nil {
+			log.Printf("Error releasing bucket %s: %v", bucket, err)
+		}
+		delete(c.held, bucket)
+	}
+}
+
+// Owns returns whether this instance holds the lease of bucket
+func (c *Claims) Owns(bucket string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cl, ok := c.held[bucket]
+	return ok && cl.ctx.Err() == nil
+}
+
+// Owners returns the owner of every bucket, read from their leases, for clients to route queries with
+func (c *Claims) Owners(ctx context.Context) ([]model.BucketShard, error) {
+	shards := make([]model.BucketShard, 0, len(c.buckets))
+	for _, bucket := range c.buckets {
+		shard := model.BucketShard{Bucket: bucket}
+
+		address, expires, err := c.lease(bucket).Holder(ctx)
+		switch {
+		case errors.Is(err, lease.ErrLeaseFree):
+		case err != nil:
+			return nil, err
+		default:
+			shard.Address, shard.Expires = address, &expires
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
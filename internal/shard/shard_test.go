@@ -0,0 +1,128 @@
+package shard
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/lease"
+)
+
+// fakeLocks holds the leases of buckets in memory, by holder
+type fakeLocks struct {
+	mu      sync.Mutex
+	holders map[string]string
+	lost    map[string]context.CancelFunc
+}
+
+func newFakeLocks() *fakeLocks {
+	return &fakeLocks{holders: make(map[string]string), lost: make(map[string]context.CancelFunc)}
+}
+
+// claims returns the claims of holder over buckets
+func (f *fakeLocks) claims(holder string, buckets []string, max int) *Claims {
+	return newClaims(func(bucket string) lock {
+		return &fakeLock{locks: f, bucket: bucket, holder: holder}
+	}, buckets, max, time.Hour)
+}
+
+// takeOver gives the lease of bucket to holder, cancelling the context of its previous holder
+func (f *fakeLocks) takeOver(bucket, holder string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.holders[bucket] = holder
+	f.lost[bucket]()
+}
+
+type fakeLock struct {
+	locks  *fakeLocks
+	bucket string
+	holder string
+}
+
+func (l *fakeLock) Acquire(ctx context.Context) (context.Context, error) {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+	if holder, ok := l.locks.holders[l.bucket]; ok && holder != l.holder {
+		return nil, lease.ErrLeaseHeld
+	}
+	l.locks.holders[l.bucket] = l.holder
+	leaseCtx, cancel := context.WithCancel(ctx)
+	l.locks.lost[l.bucket] = cancel
+	return leaseCtx, nil
+}
+
+func (l *fakeLock) Release(ctx context.Context) error {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+	if l.locks.holders[l.bucket] == l.holder {
+		delete(l.locks.holders, l.bucket)
+	}
+	return nil
+}
+
+func (l *fakeLock) Holder(ctx context.Context) (string, time.Time, error) {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+	holder, ok := l.locks.holders[l.bucket]
+	if !ok {
+		return "", time.Time{}, lease.ErrLeaseFree
+	}
+	return holder, time.Now().Add(time.Minute), nil
+}
+
+func TestClaims(t *testing.T) {
+	ctx := context.Background()
+	buckets := []string{"a", "b", "c"}
+	locks := newFakeLocks()
+
+	first, second := locks.claims("first:8080", buckets, 2), locks.claims("second:8080", buckets, 2)
+	first.claim(ctx)
+	second.claim(ctx)
+
+	// Instances claim free buckets up to their maximum
+	owned := func(c *Claims) []string {
+		return slices.DeleteFunc(slices.Clone(buckets), func(bucket string) bool { return !c.Owns(bucket) })
+	}
+	if got := owned(first); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("Buckets of the first instance mismatch: got %v", got)
+	}
+	if got := owned(second); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("Buckets of the second instance mismatch: got %v", got)
+	}
+
+	shards, err := locks.claims("", buckets, 0).Owners(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []string
+	for _, shard := range shards {
+		addresses = append(addresses, shard.Bucket+"="+shard.Address)
+	}
+	if want := []string{"a=first:8080", "b=first:8080", "c=second:8080"}; !slices.Equal(addresses, want) {
+		t.Errorf("Owners mismatch: got %v, want %v", addresses, want)
+	}
+
+	// A bucket taken over stops being owned, and another free bucket is claimed in its place
+	locks.takeOver("a", "third:8080")
+	if first.Owns("a") {
+		t.Error("Bucket taken over still owned")
+	}
+	second.release()
+	first.claim(ctx)
+	if got := owned(first); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("Buckets after the takeover mismatch: got %v", got)
+	}
+
+	// Released buckets are freed for other instances
+	first.release()
+	shards, err = first.Owners(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards[1].Address != "" || shards[1].Expires != nil {
+		t.Errorf("Released bucket still owned: %+v", shards[1])
+	}
+}
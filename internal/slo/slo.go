@@ -0,0 +1,275 @@
+// Package slo tracks the latency objectives of API endpoints and alerts when their error budget burns too fast,
+// following the multiwindow burn rate alerts of the Google SRE workbook
+package slo
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Severities of alerts
+const (
+	SeverityPage   = "page"
+	SeverityTicket = "ticket"
+)
+
+// alertWindow fires when both windows burn the budget at least threshold times faster than sustainable,
+// the short window letting the alert resolve soon after the burn stops
+type alertWindow struct {
+	severity    string
+	long, short time.Duration
+	threshold   float64
+}
+
+// alertWindows are the windows of a 30 day SLO period, paging once 2% of the budget burns in an hour
+// and opening a ticket once 5% burns in 6 hours
+var alertWindows = []alertWindow{
+	{SeverityPage, time.Hour, 5 * time.Minute, 14.4},
+	{SeverityTicket, 6 * time.Hour, 30 * time.Minute, 6},
+}
+
+const (
+	// bucketWidth is the resolution requests are counted at
+	bucketWidth = time.Minute
+	// retention is the longest window requests are kept for
+	retention = 6 * time.Hour
+)
+
+// slos exposes the status of every tracked endpoint under /debug/vars
+var slos = expvar.NewMap("slo")
+
+// bucket counts the requests of a minute
+type bucket struct {
+	start     time.Time
+	requests  int64
+	bad       int64
+	populated bool
+}
+
+// endpoint counts the requests of an endpoint over the retention, one bucket per minute
+type endpoint struct {
+	slo      model.SLO
+	buckets  []bucket
+	severity string
+}
+
+// Tracker counts good and bad requests per endpoint to compute how fast they burn their error budget
+type Tracker struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+}
+
+// NewTracker tracks the endpoints of slos, publishing their status in the slo expvar
+func NewTracker(slos []model.SLO) *Tracker {
+	t := &Tracker{clock: clock.Real, endpoints: make(map[string]*endpoint, len(slos))}
+	for _, slo := range slos {
+		t.endpoints[slo.Endpoint] = &endpoint{slo: slo, buckets: make([]bucket, retention/bucketWidth)}
+		publish(slo.Endpoint, t)
+	}
+	return t
+}
+
+func publish(name string, t *Tracker) {
+	slos.Set(name, expvar.Func(func() any {
+		for _, status := range t.Status() {
+			if status.SLO.Endpoint == name {
+				return status
+			}
+		}
+		return nil
+	}))
+}
+
+// SetClock replaces the clock requests are counted with, for tests
+func (t *Tracker) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+// Record counts a request of endpoint, bad if it failed with a server error or took longer than the objective
+// Requests of endpoints without an objective are ignored
+func (t *Tracker) Record(name string, status int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.endpoints[name]
+	if !ok {
+		return
+	}
+
+	b := e.bucket(t.clock.Now())
+	b.requests++
+	if status >= http.StatusInternalServerError || latency > time.Duration(e.slo.Latency) {
+		b.bad++
+	}
+}
+
+// bucket returns the bucket counting requests at now, reset if it last counted an earlier minute
+func (e *endpoint) bucket(now time.Time) *bucket {
+	start := now.Truncate(bucketWidth)
+	b := &e.buckets[int(start.Unix()/int64(bucketWidth/time.Second))%len(e.buckets)]
+	if !b.populated || !b.start.Equal(start) {
+		*b = bucket{start: start, populated: true}
+	}
+	return b
+}
+
+// burnRate returns how fast the endpoint burned its budget over the window ending at now
+func (e *endpoint) burnRate(now time.Time, window time.Duration) model.BurnRate {
+	rate := model.BurnRate{Window: window.String()}
+	since := now.Truncate(bucketWidth).Add(-window)
+	for _, b := range e.buckets {
+		if b.populated && b.start.After(since) && !b.start.After(now) {
+			rate.Requests += b.requests
+			rate.Bad += b.bad
+		}
+	}
+
+	if rate.Requests > 0 && e.slo.Target < 1 {
+		rate.Rate = float64(rate.Bad) / float64(rate.Requests) / (1 - e.slo.Target)
+	}
+	return rate
+}
+
+// status returns the burn rates of every alert window and the severity of the most urgent firing alert
+func (e *endpoint) status(now time.Time) model.SLOStatus {
+	status := model.SLOStatus{SLO: e.slo}
+	rates := make(map[time.Duration]model.BurnRate)
+	for _, w := range alertWindows {
+		for _, window := range []time.Duration{w.short, w.long} {
+			if _, ok := rates[window]; !ok {
+				rates[window] = e.burnRate(now, window)
+			}
+		}
+	}
+
+	windows := make([]time.Duration, 0, len(rates))
+	for window := range rates {
+		windows = append(windows, window)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	for _, window := range windows {
+		status.BurnRates = append(status.BurnRates, rates[window])
+	}
+
+	for _, w := range alertWindows {
+		if rates[w.long].Rate >= w.threshold && rates[w.short].Rate >= w.threshold {
+			status.Severity = w.severity
+			break
+		}
+	}
+	return status
+}
+
+// Status returns the budget consumption of every tracked endpoint, sorted by endpoint
+func (t *Tracker) Status() []model.SLOStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	statuses := make([]model.SLOStatus, 0, len(t.endpoints))
+	for _, e := range t.endpoints {
+		statuses = append(statuses, e.status(now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].SLO.Endpoint < statuses[j].SLO.Endpoint })
+	return statuses
+}
+
+// Evaluate returns an alert for every endpoint whose severity changed since the previous evaluation,
+// firing at the new severity or resolved
+func (t *Tracker) Evaluate() []model.SLOAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	var alerts []model.SLOAlert
+	for _, e := range t.endpoints {
+		status := e.status(now)
+		if status.Severity == e.severity {
+			continue
+		}
+
+		previous := e.severity
+		e.severity = status.Severity
+
+		// Resolved alerts carry the severity they fired at
+		firing := len(status.Severity) > 0
+		if !firing {
+			status.Severity = previous
+		}
+		alerts = append(alerts, model.SLOAlert{Status: status, Firing: firing, Observed: now})
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Status.SLO.Endpoint < alerts[j].Status.SLO.Endpoint })
+	return alerts
+}
+
+// Paging reports whether an endpoint burned its error budget fast enough to page at the last evaluation
+func (t *Tracker) Paging() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, e := range t.endpoints {
+		if e.severity == SeverityPage {
+			return true
+		}
+	}
+	return false
+}
+
+// Run evaluates the alerts every interval until ctx is cancelled, passing changes to notify
+// Alerts are logged whether notify is nil or not
+func (t *Tracker) Run(ctx context.Context, interval time.Duration, notify func(context.Context, model.SLOAlert) error) {
+	ticker := t.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			for _, alert := range t.Evaluate() {
+				state := "resolved"
+				if alert.Firing {
+					state = "firing"
+				}
+				log.Printf("SLO %s alert %s for %s", alert.Status.Severity, state, alert.Status.SLO.Endpoint)
+
+				if notify != nil {
+					if err := notify(ctx, alert); err != nil {
+						log.Printf("Error notifying SLO alert: %v", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Parse parses an objective given as LATENCY:TARGET, such as 500ms:99.9 for 99.9% of requests within 500ms
+func Parse(name string, s string) (model.SLO, error) {
+	latencyValue, targetValue, ok := strings.Cut(s, ":")
+	if !ok {
+		return model.SLO{}, fmt.Errorf("invalid objective %q, expected LATENCY:TARGET", s)
+	}
+
+	latency, err := time.ParseDuration(latencyValue)
+	if err != nil || latency <= 0 {
+		return model.SLO{}, fmt.Errorf("invalid latency %q", latencyValue)
+	}
+	target, err := strconv.ParseFloat(targetValue, 64)
+	if err != nil || target <= 0 || target >= 100 {
+		return model.SLO{}, fmt.Errorf("invalid target %q, expected a percentage below 100", targetValue)
+	}
+	return model.SLO{Endpoint: name, Latency: model.Duration(latency), Target: target / 100}, nil
+}
@@ -0,0 +1,132 @@
+package slo
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/clock"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		want    model.SLO
+		wantErr bool
+	}{
+		{"Valid", "500ms:99.9", model.SLO{Endpoint: "explore", Latency: model.Duration(500 * time.Millisecond), Target: 0.999}, false},
+		{"Missing target", "500ms", model.SLO{}, true},
+		{"Invalid latency", "fast:99", model.SLO{}, true},
+		{"Target of 100%", "1s:100", model.SLO{}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse("explore", tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Endpoint != tc.want.Endpoint || got.Latency != tc.want.Latency || got.Target-tc.want.Target > 1e-9 || tc.want.Target-got.Target > 1e-9 {
+				t.Errorf("Objective mismatch: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewTracker([]model.SLO{{Endpoint: "explore", Latency: model.Duration(time.Second), Target: 0.99}})
+	tracker.SetClock(clk)
+
+	// An hour within the objective
+	for range 60 {
+		for range 100 {
+			tracker.Record("explore", http.StatusOK, 10*time.Millisecond)
+		}
+		tracker.Record("search", http.StatusInternalServerError, time.Minute)
+		clk.Advance(time.Minute)
+	}
+	if alerts := tracker.Evaluate(); len(alerts) != 0 {
+		t.Fatalf("Expected no alert within the objective, got %+v", alerts)
+	}
+	if tracker.Paging() {
+		t.Error("Expected no page within the objective")
+	}
+
+	// Every request failing or too slow for an hour burns the budget 100 times too fast
+	for i := range 60 {
+		for range 50 {
+			tracker.Record("explore", http.StatusServiceUnavailable, 10*time.Millisecond)
+			tracker.Record("explore", http.StatusOK, 2*time.Second)
+		}
+		if i < 59 {
+			clk.Advance(time.Minute)
+		}
+	}
+
+	alerts := tracker.Evaluate()
+	if len(alerts) != 1 || !alerts[0].Firing || alerts[0].Status.Severity != SeverityPage {
+		t.Fatalf("Expected a page, got %+v", alerts)
+	}
+	rates := alerts[0].Status.BurnRates
+	if len(rates) != 4 || rates[0].Window != "5m0s" || math.Round(rates[0].Rate) != 100 {
+		t.Errorf("Burn rates mismatch: got %+v", rates)
+	}
+	if alerts := tracker.Evaluate(); len(alerts) != 0 {
+		t.Errorf("Expected the alert to fire once, got %+v", alerts)
+	}
+	if !tracker.Paging() {
+		t.Error("Expected the tracker to be paging")
+	}
+
+	// The short windows clear first, downgrading the page to a ticket then resolving it
+	clk.Advance(10 * time.Minute)
+	for range 100 {
+		tracker.Record("explore", http.StatusOK, 10*time.Millisecond)
+	}
+	alerts = tracker.Evaluate()
+	if len(alerts) != 1 || !alerts[0].Firing || alerts[0].Status.Severity != SeverityTicket {
+		t.Fatalf("Expected a ticket, got %+v", alerts)
+	}
+	if tracker.Paging() {
+		t.Error("Expected a ticket not to page")
+	}
+
+	clk.Advance(30 * time.Minute)
+	for range 100 {
+		tracker.Record("explore", http.StatusOK, 10*time.Millisecond)
+	}
+	alerts = tracker.Evaluate()
+	if len(alerts) != 1 || alerts[0].Firing || alerts[0].Status.Severity != SeverityTicket {
+		t.Fatalf("Expected the ticket to resolve, got %+v", alerts)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Content-Type")
+	}))
+	defer server.Close()
+
+	if err := Webhook(server.Client(), server.URL)(context.Background(), model.SLOAlert{Firing: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "application/json" {
+		t.Errorf("Content-Type mismatch: got %q", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	if err := Webhook(failing.Client(), failing.URL)(context.Background(), model.SLOAlert{}); err == nil {
+		t.Error("Expected an error from a failing receiver")
+	}
+}
@@ -0,0 +1,39 @@
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Webhook returns a notifier posting every alert as JSON to url, such as an Alertmanager
+// or chat webhook receiver
+func Webhook(client *http.Client, url string) func(context.Context, model.SLOAlert) error {
+	return func(ctx context.Context, alert model.SLOAlert) error {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook responded %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/ingest"
+)
+
+func TestRollups(t *testing.T) {
+	testCases := []struct {
+		versioned  bool
+		writeQueue bool
+		workers    int
+	}{
+		{false, false, 1},
+		{true, false, 1},
+		{true, true, 4},
+	}
+
+	for _, tc := range testCases {
+		for seed := range uint64(5) {
+			t.Run(fmt.Sprintf("versioned=%v workers=%d seed=%d", tc.versioned, tc.workers, seed), func(t *testing.T) {
+				s := NewServer(t, tc.writeQueue)
+				g := NewEventGenerator("mock", tc.versioned, 20, seed)
+				events := Interleave(g.Events(300), seed)
+
+				if err := ingest.NewApplier(s.DB).ApplyAll(context.Background(), events, tc.workers); err != nil {
+					t.Fatal(err)
+				}
+
+				AssertRollups(t, s, g.Rollups())
+			})
+		}
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	events := NewEventGenerator("mock", true, 5, 1).Events(100)
+	interleaved := Interleave(events, 2)
+
+	if len(interleaved) != len(events) {
+		t.Fatalf("Event count mismatch: got %d, want %d", len(interleaved), len(events))
+	}
+
+	// Events of every name keep their order
+	next := make(map[string][]Event)
+	for _, ev := range events {
+		next[ev.Object.Name] = append(next[ev.Object.Name], ev)
+	}
+	for _, ev := range interleaved {
+		if want := next[ev.Object.Name][0]; ev != want {
+			t.Fatalf("Order of %s mismatch: got %v, want %v", ev.Object.Name, ev, want)
+		}
+		next[ev.Object.Name] = next[ev.Object.Name][1:]
+	}
+}
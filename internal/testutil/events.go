@@ -0,0 +1,160 @@
+package testutil
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/ingest"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Events are the notifications indexed by ingest.Applier
+type (
+	EventType = ingest.EventType
+	Event     = ingest.Event
+)
+
+const (
+	EventFinalize = ingest.EventFinalize
+	EventArchive  = ingest.EventArchive
+	EventDelete   = ingest.EventDelete
+)
+
+var storageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+type generation struct {
+	object     model.Metadata
+	generation int64
+}
+
+// EventGenerator synthesizes a deterministic sequence of events for a seed,
+// creating, overwriting, deleting and expiring a small set of names so every name sees many events
+type EventGenerator struct {
+	bucket    string
+	versioned bool
+	rand      *rand.Rand
+	names     []string
+
+	live       map[string]*generation
+	noncurrent []*generation
+	generation int64
+	now        time.Time
+}
+
+func NewEventGenerator(bucket string, versioned bool, names int, seed uint64) *EventGenerator {
+	g := &EventGenerator{
+		bucket:    bucket,
+		versioned: versioned,
+		rand:      rand.New(rand.NewPCG(seed, seed)),
+		live:      make(map[string]*generation),
+		now:       time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for i := range names {
+		name := ""
+		for range g.rand.IntN(4) {
+			name += fmt.Sprintf("dir-%d/", g.rand.IntN(3))
+		}
+		g.names = append(g.names, fmt.Sprintf("%sobject-%d", name, i))
+	}
+	return g
+}
+
+// Next returns the events of the next mutation, in the order GCS may deliver them
+func (g *EventGenerator) Next() []Event {
+	g.now = g.now.Add(time.Second)
+
+	switch roll := g.rand.Float64(); {
+	case roll < 0.6 || len(g.live) == 0:
+		return g.write(g.names[g.rand.IntN(len(g.names))])
+	case roll < 0.85:
+		for _, name := range g.names {
+			if _, ok := g.live[name]; ok && g.rand.IntN(2) == 0 {
+				return []Event{g.retire(name)}
+			}
+		}
+		return g.write(g.names[g.rand.IntN(len(g.names))])
+	default:
+		if len(g.noncurrent) == 0 {
+			return g.write(g.names[g.rand.IntN(len(g.names))])
+		}
+		i := g.rand.IntN(len(g.noncurrent))
+		expired := g.noncurrent[i]
+		g.noncurrent = append(g.noncurrent[:i], g.noncurrent[i+1:]...)
+		return []Event{{Type: EventDelete, Object: expired.object, Generation: expired.generation}}
+	}
+}
+
+// write finalizes a new generation of name, retiring the live one it overwrites
+// Notifications of an overwrite are not ordered, so the retirement may come first
+func (g *EventGenerator) write(name string) []Event {
+	g.generation++
+	obj := model.Metadata{
+		Bucket:       g.bucket,
+		Name:         name,
+		StorageClass: storageClasses[g.rand.IntN(len(storageClasses))],
+		Size:         g.rand.Int64N(1000) + 1,
+		Created:      g.now,
+		Updated:      g.now,
+	}
+
+	var events []Event
+	if _, ok := g.live[name]; ok {
+		events = append(events, g.retire(name))
+	}
+	g.live[name] = &generation{obj, g.generation}
+
+	finalize := Event{Type: EventFinalize, Object: obj, Generation: g.generation}
+	if len(events) > 0 && g.rand.IntN(2) == 0 {
+		return append(events, finalize)
+	}
+	return append([]Event{finalize}, events...)
+}
+
+// retire deletes the live generation of name, which becomes noncurrent in versioned buckets
+func (g *EventGenerator) retire(name string) Event {
+	current := g.live[name]
+	delete(g.live, name)
+
+	if !g.versioned {
+		return Event{Type: EventDelete, Object: current.object, Generation: current.generation}
+	}
+	g.noncurrent = append(g.noncurrent, current)
+	return Event{Type: EventArchive, Object: current.object, Generation: current.generation}
+}
+
+// Events returns the events of n mutations
+func (g *EventGenerator) Events(n int) []Event {
+	var events []Event
+	for range n {
+		events = append(events, g.Next()...)
+	}
+	return events
+}
+
+// Interleave shuffles events while keeping the events of every name in order,
+// as notifications published with the object name as ordering key are delivered
+func Interleave(events []Event, seed uint64) []Event {
+	r := rand.New(rand.NewPCG(seed, seed))
+
+	var names []string
+	queues := make(map[string][]Event)
+	for _, ev := range events {
+		if _, ok := queues[ev.Object.Name]; !ok {
+			names = append(names, ev.Object.Name)
+		}
+		queues[ev.Object.Name] = append(queues[ev.Object.Name], ev)
+	}
+
+	interleaved := make([]Event, 0, len(events))
+	for len(names) > 0 {
+		i := r.IntN(len(names))
+		name := names[i]
+		interleaved = append(interleaved, queues[name][0])
+		if queues[name] = queues[name][1:]; len(queues[name]) == 0 {
+			names = append(names[:i], names[i+1:]...)
+		}
+	}
+	return interleaved
+}
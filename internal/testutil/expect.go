@@ -0,0 +1,110 @@
+package testutil
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+// Rollup is what a directory accounts for
+type Rollup struct {
+	Size           model.Size
+	Count          int64
+	NoncurrentSize int64
+}
+
+// Rollups returns what every directory of the generated names accounts for once every event generated so far
+// is applied, root being "/" and directories emptied since accounting for nothing
+func (g *EventGenerator) Rollups() map[string]*Rollup {
+	rollups := make(map[string]*Rollup)
+	for _, name := range g.names {
+		for _, dir := range parentDirs(name) {
+			rollups[dir] = &Rollup{}
+		}
+	}
+
+	for _, current := range g.live {
+		obj := current.object
+		for _, dir := range parentDirs(obj.Name) {
+			r := rollups[dir]
+			r.Count++
+			switch obj.StorageClass {
+			case "STANDARD":
+				r.Size.Standard += obj.Size
+			case "NEARLINE":
+				r.Size.Nearline += obj.Size
+			case "COLDLINE":
+				r.Size.Coldline += obj.Size
+			case "ARCHIVE":
+				r.Size.Archive += obj.Size
+			}
+		}
+	}
+
+	for _, n := range g.noncurrent {
+		for _, dir := range parentDirs(n.object.Name) {
+			rollups[dir].NoncurrentSize += n.object.Size
+		}
+	}
+	return rollups
+}
+
+// parentDirs returns every directory containing an object name, from root down to its parent
+func parentDirs(name string) []string {
+	dirs := []string{"/"}
+	for i, c := range name {
+		if c == '/' {
+			dirs = append(dirs, name[:i+1])
+		}
+	}
+	return dirs
+}
+
+// parentDir returns the directory containing dir
+func parentDir(dir string) string {
+	trimmed := strings.TrimSuffix(dir, "/")
+	i := strings.LastIndex(trimmed, "/")
+	if i == -1 {
+		return "/"
+	}
+	return trimmed[:i+1]
+}
+
+// AssertRollups checks the summary s serves for every directory of want, and its count in the listing of its parent
+func AssertRollups(t testing.TB, s *Server, want map[string]*Rollup) {
+	t.Helper()
+
+	dirs := make([]string, 0, len(want))
+	for dir := range want {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		var summary model.Summary
+		s.Get(t, "/v1/summary/"+strings.TrimPrefix(dir, "/"), &summary)
+		if summary.Size != want[dir].Size || summary.NoncurrentSize != want[dir].NoncurrentSize {
+			t.Errorf("Summary of %s mismatch: got size %+v noncurrent %d, want size %+v noncurrent %d",
+				dir, summary.Size, summary.NoncurrentSize, want[dir].Size, want[dir].NoncurrentSize)
+		}
+
+		if dir == "/" {
+			continue // root is listed by no parent
+		}
+
+		var contents model.PathContents
+		s.Get(t, "/v1/explore/"+strings.TrimPrefix(parentDir(dir), "/"), &contents)
+
+		var count int64
+		for _, entry := range contents.Contents {
+			if entry.Name == dir {
+				count = entry.Count
+			}
+		}
+		if count != want[dir].Count {
+			t.Errorf("Count of %s mismatch: got %d, want %d", dir, count, want[dir].Count)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package client provides a Go client for the GCS Metadata Server REST API
+// as described by the OpenAPI document served at /openapi.json
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+)
+
+type (
+	Metadata                  = model.Metadata
+	PathContents              = model.PathContents
+	Summary                   = model.Summary
+	Size                      = model.Size
+	Cost                      = model.Cost
+	LifecyclePolicy           = model.LifecyclePolicy
+	LifecycleRule             = model.LifecycleRule
+	LifecycleAction           = model.LifecycleAction
+	LifecycleCondition        = model.LifecycleCondition
+	LifecycleSimulation       = model.LifecycleSimulation
+	LifecycleImpact           = model.LifecycleImpact
+	LifecycleSimulationResult = model.LifecycleSimulationResult
+	WriteStats                = model.WriteStats
+	PrefixWriteStat           = model.PrefixWriteStat
+	DirectoryDiff             = model.DirectoryDiff
+	DirectoryDelta            = model.DirectoryDelta
+	BucketComparison          = model.BucketComparison
+	ObjectDifference          = model.ObjectDifference
+)
+
+// apiVersion is the server API version this client targets
+const apiVersion = "/v1"
+
+const (
+	// ndjsonMediaType is the media type of listings the server streams for exceeding its response size limit
+	ndjsonMediaType = "application/x-ndjson"
+	// nextPageTokenHeader carries the token of the next page of streamed listings
+	nextPageTokenHeader = "X-Next-Page-Token"
+)
+
+type SortType string
+
+const (
+	SortBySize  SortType = "size"
+	SortByCount SortType = "count"
+)
+
+// APIError is returned when the server responds with a non-2xx status code
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("metadata server returned %d: %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080
+// If httpClient is nil, http.DefaultClient is used
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// Explore lists the immediate contents of a directory
+func (c *Client) Explore(ctx context.Context, path string, sort SortType) (*PathContents, error) {
+	query := url.Values{}
+	if len(sort) > 0 {
+		query.Set("sort", string(sort))
+	}
+
+	return c.explore(ctx, path, query)
+}
+
+// ExplorePage lists one page of the contents of a directory
+// Pass the NextPageToken of each page to fetch the following one until it is empty
+func (c *Client) ExplorePage(ctx context.Context, path string, sort SortType, pageSize int, pageToken string) (*PathContents, error) {
+	query := url.Values{}
+	if len(sort) > 0 {
+		query.Set("sort", string(sort))
+	}
+	query.Set("page_size", strconv.Itoa(pageSize))
+	if len(pageToken) > 0 {
+		query.Set("page_token", pageToken)
+	}
+
+	return c.explore(ctx, path, query)
+}
+
+// explore lists the contents of a directory, decoding listings the server streamed for exceeding its
+// response size limit as well
+func (c *Client) explore(ctx context.Context, path string, query url.Values) (*PathContents, error) {
+	res, err := c.send(ctx, http.MethodGet, apiVersion+"/explore/"+escapePath(path), query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var contents PathContents
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != ndjsonMediaType {
+		if err := json.NewDecoder(res.Body).Decode(&contents); err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+		return &contents, nil
+	}
+
+	// Streamed listings hold one entry per line, the token of the next page being sent as a header
+	contents = PathContents{Path: path, Contents: []*Metadata{}, NextPageToken: res.Header.Get(nextPageTokenHeader)}
+	decoder := json.NewDecoder(res.Body)
+	for decoder.More() {
+		var entry Metadata
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+		contents.Contents = append(contents.Contents, &entry)
+	}
+	return &contents, nil
+}
+
+// Summary returns the size and cost of a directory per storage class
+func (c *Client) Summary(ctx context.Context, path string) (*Summary, error) {
+	var summary Summary
+	if err := c.do(ctx, http.MethodGet, apiVersion+"/summary/"+escapePath(path), nil, nil, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SimulateLifecycle previews the objects of bucket under prefix a lifecycle policy would transition or delete
+func (c *Client) SimulateLifecycle(ctx context.Context, bucket, prefix string, policy *LifecyclePolicy) (*LifecycleSimulationResult, error) {
+	query := url.Values{}
+	query.Set("bucket", bucket)
+	query.Set("prefix", prefix)
+
+	var result LifecycleSimulationResult
+	if err := c.do(ctx, http.MethodPost, apiVersion+"/simulate/lifecycle", query, policy, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WriteStats counts the writes applied per top level prefix over the last window
+func (c *Client) WriteStats(ctx context.Context, window time.Duration) (*WriteStats, error) {
+	query := url.Values{"window": {window.String()}}
+
+	var stats WriteStats
+	if err := c.do(ctx, http.MethodGet, apiVersion+"/stats/writes", query, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Diff returns how much every child directory of prefix grew or shrank between from and to
+func (c *Client) Diff(ctx context.Context, prefix string, from time.Time, to time.Time) (*DirectoryDiff, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("from", from.Format(time.RFC3339))
+	query.Set("to", to.Format(time.RFC3339))
+
+	var diff DirectoryDiff
+	if err := c.do(ctx, http.MethodGet, apiVersion+"/diff", query, nil, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// CompareBuckets lists up to limit objects missing under prefixA of bucketA or prefixB of bucketB, or differing
+// in size, to validate replications and migrations
+func (c *Client) CompareBuckets(ctx context.Context, bucketA, prefixA, bucketB, prefixB string, limit int) (*BucketComparison, error) {
+	query := url.Values{}
+	query.Set("bucketA", bucketA)
+	query.Set("bucketB", bucketB)
+	query.Set("prefix", prefixA)
+	query.Set("prefixB", prefixB)
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var comparison BucketComparison
+	if err := c.do(ctx, http.MethodGet, apiVersion+"/compare", query, nil, &comparison); err != nil {
+		return nil, err
+	}
+	return &comparison, nil
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	res, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// send sends a request with an optional JSON body, returning the response unless its status is not 2xx
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, body any) (*http.Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return res, nil
+}
+
+// escapePath escapes every segment of an object path while preserving its slashes
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/middleware"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/api/router"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo/repotest"
+)
+
+func TestClient(t *testing.T) {
+	created := time.Now().AddDate(0, 0, -60)
+	db := repotest.New(t, repotest.Tree{
+		"mock": {
+			{Name: "file1", Size: 10, Created: created},
+			{Name: "mock-1/file 2", Size: 5, StorageClass: "NEARLINE", Created: created},
+		},
+	})
+
+	server := httptest.NewServer(router.New(db, nil))
+	defer server.Close()
+
+	c := New(server.URL, nil)
+	ctx := context.Background()
+
+	t.Run("Explore", func(t *testing.T) {
+		got, err := c.Explore(ctx, "mock-1/", SortBySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Contents) != 2 {
+			t.Fatalf("Return count mismatch: got %d, want %d", len(got.Contents), 2)
+		}
+
+		if got.Contents[1].Name != "mock-1/file 2" {
+			t.Errorf("Return name mismatch: got %s, want %s", got.Contents[1].Name, "mock-1/file 2")
+		}
+	})
+
+	t.Run("Explore decodes streamed listings", func(t *testing.T) {
+		limited := httptest.NewServer(middleware.LimitResponses(router.New(db, nil), 1, nil))
+		defer limited.Close()
+
+		got, err := New(limited.URL, nil).Explore(ctx, "mock-1/", SortBySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Contents) != 2 || got.Contents[1].Name != "mock-1/file 2" {
+			t.Errorf("Streamed listing mismatch: got %+v", got)
+		}
+	})
+
+	t.Run("Explore returns APIError for invalid sort", func(t *testing.T) {
+		_, err := c.Explore(ctx, "/", SortType("invalid"))
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request APIError, got %v", err)
+		}
+	})
+
+	t.Run("ExplorePage", func(t *testing.T) {
+		var names []string
+		var pageToken string
+		for {
+			page, err := c.ExplorePage(ctx, "/", SortBySize, 1, pageToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range page.Contents {
+				names = append(names, m.Name)
+			}
+
+			if pageToken = page.NextPageToken; len(pageToken) == 0 {
+				break
+			}
+		}
+
+		if len(names) != 3 {
+			t.Errorf("Return count mismatch: got %v, want %d entries", names, 3)
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		got, err := c.Summary(ctx, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Size.Standard != 10 || got.Size.Nearline != 5 {
+			t.Errorf("Summary size mismatch: got %+v", got.Size)
+		}
+	})
+
+	t.Run("WriteStats", func(t *testing.T) {
+		got, err := c.WriteStats(ctx, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Prefixes) != 2 {
+			t.Fatalf("Return count mismatch: got %d, want %d", len(got.Prefixes), 2)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		got, err := c.Diff(ctx, "", time.Now().Add(-time.Hour), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Directories) != 1 || got.Directories[0].SizeDelta != 5 {
+			t.Fatalf("Diff mismatch: got %+v", got.Directories)
+		}
+	})
+
+	t.Run("SimulateLifecycle", func(t *testing.T) {
+		age := int64(30)
+		policy := &LifecyclePolicy{Rules: []LifecycleRule{
+			{Action: LifecycleAction{Type: "Delete"}, Condition: LifecycleCondition{Age: &age}},
+		}}
+
+		got, err := c.SimulateLifecycle(ctx, "mock", "", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Prefixes) != 2 {
+			t.Fatalf("Return count mismatch: got %d, want %d", len(got.Prefixes), 2)
+		}
+	})
+	t.Run("CompareBuckets", func(t *testing.T) {
+		got, err := c.CompareBuckets(ctx, "mock", "mock-1/", "mock", "/", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.OnlyInA != 1 || got.OnlyInB != 2 || len(got.Differences) != 3 {
+			t.Fatalf("Comparison mismatch: got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+// Package metacache embeds the GCS Metadata Server index in-process, so Go services can feed it
+// object change notifications from their own source and query it without running a separate server
+package metacache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/ingest"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/model"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/query"
+	"github.com/GoogleCloudPlatform/gcs-metadata-server/internal/repo"
+)
+
+type (
+	Metadata    = model.Metadata
+	Summary     = model.Summary
+	QueryResult = model.QueryResult
+	Event       = ingest.Event
+	EventType   = ingest.EventType
+	PayloadMode = ingest.PayloadMode
+	SortType    = repo.SortType
+	Collation   = repo.Collation
+)
+
+const (
+	EventFinalize       = ingest.EventFinalize
+	EventMetadataUpdate = ingest.EventMetadataUpdate
+	EventArchive        = ingest.EventArchive
+	EventDelete         = ingest.EventDelete
+
+	PayloadStrict  = ingest.PayloadStrict
+	PayloadLenient = ingest.PayloadLenient
+
+	SortBySize  = repo.SortBySize
+	SortByCount = repo.SortByCount
+
+	CollationBinary  = repo.CollationBinary
+	CollationNoCase  = repo.CollationNoCase
+	CollationUnicode = repo.CollationUnicode
+)
+
+// ErrNotFound is returned when an object is not in the cache
+var ErrNotFound = repo.ErrNotFound
+
+// ErrMetadataMissing is returned by Decode with the event of notifications carrying no object metadata,
+// whose object must be looked up before the event is applied
+var ErrMetadataMissing = ingest.ErrMetadataMissing
+
+// maxDbConnections lets reads run alongside the single writer SQLite allows
+const maxDbConnections = 5
+
+// Cache is an index of object metadata and directory rollups stored in a SQLite database
+type Cache struct {
+	db           *repo.Database
+	applier      *ingest.Applier
+	metadataRepo repo.MetadataRepository
+	exploreRepo  repo.ExploreRepository
+	queryRepo    repo.QueryRepository
+}
+
+// Open opens the cache stored in the SQLite database at url, creating its tables or migrating
+// them to the schema of this version
+// In-memory databases must be shared by every connection, see InMemoryURL
+func Open(ctx context.Context, url string) (*Cache, error) {
+	db := repo.NewDatabase(url, maxDbConnections)
+	if err := db.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := initialize(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Cache{
+		db:           db,
+		applier:      ingest.NewApplier(db),
+		metadataRepo: repo.NewMetadataRepository(db),
+		exploreRepo:  repo.NewExploreRepository(db),
+		queryRepo:    repo.NewQueryRepository(db),
+	}, nil
+}
+
+func initialize(ctx context.Context, db *repo.Database) error {
+	if err := db.Setup(); err != nil {
+		return err
+	}
+
+	exists, err := db.PingTable()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return db.CreateTables()
+	}
+	return db.CheckSchema(ctx, repo.SchemaMigrate)
+}
+
+// InMemoryURL returns the URL of a cache kept in memory under name, lost once closed
+func InMemoryURL(name string) string {
+	return repo.InMemoryURL(name)
+}
+
+// Close closes the database of the cache
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Apply indexes a single object change notification
+// Notifications may be redelivered or arrive out of order across objects, those about generations
+// the cache already moved past are ignored, but the notifications of an object must be applied in order
+func (c *Cache) Apply(ctx context.Context, ev Event) error {
+	return c.applier.Apply(ctx, ev)
+}
+
+// Decode decodes the data and attributes of a GCS notification message into an event, by its payloadFormat attribute
+func Decode(data []byte, attributes map[string]string, mode PayloadMode) (Event, error) {
+	return ingest.Decode(data, attributes, mode)
+}
+
+// Get returns the live generation of an object, or ErrNotFound
+func (c *Cache) Get(ctx context.Context, bucket, name string) (*Metadata, error) {
+	return c.metadataRepo.Get(ctx, bucket, name)
+}
+
+// List lists the objects and subdirectories directly under path, across buckets
+// Paths are relative to the bucket root, / listing the root itself
+func (c *Cache) List(ctx context.Context, path string, sortBy SortType) ([]*Metadata, error) {
+	return c.exploreRepo.GetPathContents(ctx, normalizePath(path), sortBy, repo.ListOptions{})
+}
+
+// Summary returns the size per storage class and cost of the subtree under path
+func (c *Cache) Summary(ctx context.Context, path string) (*Summary, error) {
+	return c.exploreRepo.GetPathSummary(ctx, normalizePath(path))
+}
+
+// Search returns up to limit objects under path whose name contains q, compared under collation
+func (c *Cache) Search(ctx context.Context, path string, q string, collation Collation, limit int) ([]*Metadata, error) {
+	return c.exploreRepo.Search(ctx, normalizePath(path), q, collation, repo.ListOptions{}, limit)
+}
+
+// Query runs a read-only statement of the query language served at /query, such as
+// SELECT name, size WHERE size > 1000000 ORDER BY size DESC
+func (c *Cache) Query(ctx context.Context, statement string) (*QueryResult, error) {
+	stmt, err := query.Parse(statement)
+	if err != nil {
+		return nil, err
+	}
+	return c.queryRepo.Run(ctx, stmt)
+}
+
+// normalizePath adds the trailing slash of directory paths
+func normalizePath(path string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	if !strings.HasSuffix(path, "/") {
+		return path + "/"
+	}
+	return path
+}
@@ -0,0 +1,107 @@
+package metacache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	cache, err := Open(ctx, InMemoryURL(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	created := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	object := func(name string, size int64, updated time.Time) Metadata {
+		return Metadata{Bucket: "mock", Name: name, Size: size, StorageClass: "STANDARD", Created: created, Updated: updated}
+	}
+
+	events := []Event{
+		{Type: EventFinalize, Object: object("logs/a.txt", 10, created), Generation: 1},
+		{Type: EventFinalize, Object: object("logs/b.txt", 20, created), Generation: 2},
+		{Type: EventFinalize, Object: object("data/c.bin", 30, created), Generation: 3},
+		// Overwrites logs/a.txt, then a redelivery of its first generation is ignored
+		{Type: EventFinalize, Object: object("logs/a.txt", 15, created.Add(time.Hour)), Generation: 4},
+		{Type: EventFinalize, Object: object("logs/a.txt", 10, created), Generation: 1},
+		{Type: EventDelete, Object: object("data/c.bin", 30, created), Generation: 3},
+	}
+	for _, ev := range events {
+		if err := cache.Apply(ctx, ev); err != nil {
+			t.Fatalf("Error applying %v: %v", ev, err)
+		}
+	}
+
+	if obj, err := cache.Get(ctx, "mock", "logs/a.txt"); err != nil || obj.Size != 15 {
+		t.Errorf("Object mismatch: got %+v, %v", obj, err)
+	}
+	if _, err := cache.Get(ctx, "mock", "data/c.bin"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a deleted object, got %v", err)
+	}
+
+	contents, err := cache.List(ctx, "logs", SortBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The directory itself is listed first with its totals
+	if len(contents) != 3 || contents[0].Name != "logs/" || contents[0].Size != 35 || contents[1].Name != "logs/b.txt" {
+		t.Errorf("Contents mismatch: got %+v", contents)
+	}
+
+	summary, err := cache.Summary(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.LiveSize != 35 {
+		t.Errorf("Summary mismatch: got %+v", summary)
+	}
+
+	results, err := cache.Search(ctx, "/", "A.TXT", CollationNoCase, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "logs/a.txt" {
+		t.Errorf("Search results mismatch: got %+v", results)
+	}
+
+	result, err := cache.Query(ctx, "SELECT name WHERE size >= 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0]["name"] != "logs/b.txt" {
+		t.Errorf("Query result mismatch: got %+v", result.Rows)
+	}
+	if _, err := cache.Query(ctx, "DELETE FROM metadata"); err == nil {
+		t.Error("Expected an error running a statement other than SELECT")
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	ctx := context.Background()
+	url := filepath.Join(t.TempDir(), "metadata.db")
+
+	cache, err := Open(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := Metadata{Bucket: "mock", Name: "file", Size: 1, StorageClass: "STANDARD", Created: time.Now(), Updated: time.Now()}
+	if err := cache.Apply(ctx, Event{Type: EventFinalize, Object: obj, Generation: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// Reopening keeps the index
+	cache, err = Open(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if _, err := cache.Get(ctx, "mock", "file"); err != nil {
+		t.Errorf("Expected the object to be kept, got %v", err)
+	}
+}
@@ -43,7 +43,7 @@ export class ExploreService {
     path = this.normalizePath(path);
 
     const response = await fetch(
-      `${API_BASE_URL}/explore/${path}?sort=${sort}`,
+      `${API_BASE_URL}/v1/explore/${path}?sort=${sort}`,
     );
     if (!response.ok) {
       throw new Error(`Response status: ${response.status}`);